@@ -4,14 +4,89 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/jmoiron/sqlx/types"
+	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/internal/captcha"
 	"github.com/labstack/echo/v4"
 	null "gopkg.in/volatiletech/null.v6"
 )
 
+const (
+	// Default and max. number of days in the date range of dashboard stats.
+	dashboardDefaultDays = 30
+	dashboardMaxDays     = 365
+
+	// Duration for which the dashboard stats of the commonly used date ranges are cached.
+	dashboardCacheTTL = time.Minute * 5
+)
+
+// dashboardCacheDays are the number of days in the commonly used date ranges ending
+// today (last 7 days, last 30 days) of which the dashboard stats are cached.
+var dashboardCacheDays = []int{7, 30}
+
+// dashboardCache caches the dashboard stats of the commonly used date ranges.
+type dashboardCache struct {
+	items map[string]dashboardCacheItem
+	sync.Mutex
+}
+
+type dashboardCacheItem struct {
+	data    types.JSONText
+	expires time.Time
+}
+
+// get returns the dashboard stats of the given type in a date range, either from
+// the cache, or by fetching them with fn. Only the commonly used date ranges are cached.
+func (d *dashboardCache) get(typ string, from, to time.Time, fn func(from, to time.Time) (types.JSONText, error)) (types.JSONText, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	cache := false
+	if to.Equal(today.AddDate(0, 0, 1)) {
+		for _, n := range dashboardCacheDays {
+			if from.Equal(today.AddDate(0, 0, -(n - 1))) {
+				cache = true
+				break
+			}
+		}
+	}
+	if !cache {
+		return fn(from, to)
+	}
+
+	key := fmt.Sprintf("%s:%d:%d", typ, from.Unix(), to.Unix())
+
+	d.Lock()
+	defer d.Unlock()
+
+	if it, ok := d.items[key]; ok && now.Before(it.expires) {
+		return it.data, nil
+	}
+
+	out, err := fn(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the expired items before caching the new one.
+	if d.items == nil {
+		d.items = make(map[string]dashboardCacheItem)
+	}
+	for k, it := range d.items {
+		if now.After(it.expires) {
+			delete(d.items, k)
+		}
+	}
+	d.items[key] = dashboardCacheItem{data: out, expires: now.Add(dashboardCacheTTL)}
+
+	return out, nil
+}
+
 type serverConfig struct {
 	RootURL            string `json:"root_url"`
 	FromEmail          string `json:"from_email"`
@@ -32,6 +107,8 @@ type serverConfig struct {
 	Langs         []i18nLang      `json:"langs"`
 	Lang          string          `json:"lang"`
 	Permissions   json.RawMessage `json:"permissions"`
+	CampaignGates []string        `json:"campaign_gates"`
+	GoogleSheets  bool            `json:"google_sheets"`
 	Update        *AppUpdate      `json:"update"`
 	NeedsRestart  bool            `json:"needs_restart"`
 	HasLegacyUser bool            `json:"has_legacy_user"`
@@ -83,6 +160,9 @@ func (a *App) GetServerConfig(c echo.Context) error {
 	}
 	out.Langs = langList
 
+	out.CampaignGates = a.campGate.URLs()
+	out.GoogleSheets = a.cfg.Security.GoogleSheets.Enabled
+
 	out.Messengers = make([]string, 0, len(a.messengers))
 	for _, m := range a.messengers {
 		out.Messengers = append(out.Messengers, m.Name())
@@ -97,10 +177,16 @@ func (a *App) GetServerConfig(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// GetDashboardCharts returns chart data points to render ont he dashboard.
+// GetDashboardCharts returns chart data points in a date range (?from=&to=)
+// to render on the dashboard.
 func (a *App) GetDashboardCharts(c echo.Context) error {
+	from, to, err := a.parseDashboardRange(c)
+	if err != nil {
+		return err
+	}
+
 	// Get the chart data from the DB.
-	out, err := a.core.GetDashboardCharts()
+	out, err := a.dash.get("charts", from, to, a.core.GetDashboardCharts)
 	if err != nil {
 		return err
 	}
@@ -108,10 +194,93 @@ func (a *App) GetDashboardCharts(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// GetDashboardCounts returns stats counts to show on the dashboard.
+// GetDashboardCounts returns stats counts to show on the dashboard along with
+// the counts in a date range (?from=&to=).
 func (a *App) GetDashboardCounts(c echo.Context) error {
+	from, to, err := a.parseDashboardRange(c)
+	if err != nil {
+		return err
+	}
+
 	// Get the chart data from the DB.
-	out, err := a.core.GetDashboardCounts()
+	out, err := a.dash.get("counts", from, to, a.core.GetDashboardCounts)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// parseDashboardRange parses the from and to dates (YYYY-MM-DD) of a dashboard date range.
+// The range defaults to the last 30 days and the returned to date is the start of the day
+// after the given date.
+func (a *App) parseDashboardRange(c echo.Context) (time.Time, time.Time, error) {
+	var (
+		now = time.Now()
+		to  = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	)
+	if v := c.QueryParam("to"); v != "" {
+		t, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "to"))
+		}
+		to = t
+	}
+
+	from := to.AddDate(0, 0, -(dashboardDefaultDays - 1))
+	if v := c.QueryParam("from"); v != "" {
+		t, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "from"))
+		}
+		from = t
+	}
+
+	to = to.AddDate(0, 0, 1)
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "from"))
+	}
+	if days := to.Sub(from).Round(24*time.Hour) / (24 * time.Hour); days > dashboardMaxDays {
+		return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("dashboard.rangeTooLong", "days", strconv.Itoa(dashboardMaxDays)))
+	}
+
+	return from, to, nil
+}
+
+// GetDashboardCalendar returns the sends of campaigns to their lists in a month
+// (?year=&month=, the current month by default) for the dashboard calendar.
+func (a *App) GetDashboardCalendar(c echo.Context) error {
+	var (
+		user = auth.GetUser(c)
+		now  = time.Now()
+	)
+
+	year, month := now.Year(), int(now.Month())
+	if v := c.QueryParam("year"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1970 || n > 9999 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "year"))
+		}
+		year = n
+	}
+	if v := c.QueryParam("month"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 12 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "month"))
+		}
+		month = n
+	}
+
+	// Either the user has campaigns:get_all permissions and can view all campaigns,
+	// or the campaigns are filtered by the lists the user has get|manage access to.
+	hasAllPerm := user.HasPerm(auth.PermCampaignsGetAll)
+	var permittedLists []int
+	if !hasAllPerm {
+		hasAllPerm, permittedLists = user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage)
+	}
+
+	out, err := a.core.GetCampaignCalendar(year, time.Month(month), hasAllPerm, permittedLists)
 	if err != nil {
 		return err
 	}
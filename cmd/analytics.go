@@ -0,0 +1,329 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	heatmapDefaultDays = 90
+	heatmapMaxDays     = 365
+
+	sendFreqDefaultDays = 90
+	sendFreqMaxDays     = 730
+
+	cohortDefaultWeeks = 12
+	cohortMaxWeeks     = 52
+
+	disengagedDefaultDays   = 90
+	disengagedMaxDays       = 3650
+	disengagedDefaultSample = 20
+	disengagedMaxSample     = 100
+)
+
+// GetEngagementHeatmap returns 7x24 (day-of-week x hour-of-day) matrices of unique
+// subscribers who viewed or clicked campaigns in the last n days. An optional list_id
+// restricts the aggregation to campaigns sent to that list.
+func (a *App) GetEngagementHeatmap(c echo.Context) error {
+	var (
+		days   = heatmapDefaultDays
+		tz     = c.QueryParam("tz")
+		listID = 0
+	)
+
+	if v := c.QueryParam("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > heatmapMaxDays {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "days"))
+		}
+		days = n
+	}
+
+	if tz == "" {
+		tz = "UTC"
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "tz"))
+	}
+
+	if v := c.QueryParam("list_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+		}
+		listID = id
+	}
+
+	// Aggregating across all campaigns requires blanket list permissions.
+	// Otherwise, the user should have access to the given list.
+	user := auth.GetUser(c)
+	if listID > 0 {
+		if err := user.HasListPerm(auth.PermTypeGet, listID); err != nil {
+			return err
+		}
+	} else if hasAll, _ := user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage); !hasAll {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", "lists"))
+	}
+
+	out, err := a.core.GetEngagementHeatmap(days, tz, listID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetDisengagedSubscribersReport returns the number of subscribers who have been on a
+// list (?list_id, or on listmonk) for at least ?inactive_days days but have never viewed
+// or clicked a campaign, by inactivity duration buckets, and a sample of them.
+func (a *App) GetDisengagedSubscribersReport(c echo.Context) error {
+	var (
+		days   = disengagedDefaultDays
+		sample = disengagedDefaultSample
+		listID = 0
+	)
+
+	if v := c.QueryParam("inactive_days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > disengagedMaxDays {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "inactive_days"))
+		}
+		days = n
+	}
+
+	if v := c.QueryParam("sample"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > disengagedMaxSample {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "sample"))
+		}
+		sample = n
+	}
+
+	if v := c.QueryParam("list_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+		}
+		listID = id
+	}
+
+	// Reporting on all subscribers requires blanket list permissions.
+	// Otherwise, the user should have access to the given list.
+	user := auth.GetUser(c)
+	if listID > 0 {
+		if err := user.HasListPerm(auth.PermTypeGet, listID); err != nil {
+			return err
+		}
+	} else if hasAll, _ := user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage); !hasAll {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", "lists"))
+	}
+
+	out, err := a.core.GetDisengagedSubscribers(days, listID, sample)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetEngagementFunnelReport returns the funnel of a campaign's (?campaign_id=) messages
+// from sent, to delivered, opened, and clicked as counts and percentages.
+func (a *App) GetEngagementFunnelReport(c echo.Context) error {
+	id, _ := strconv.Atoi(c.QueryParam("campaign_id"))
+	if id < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	out, err := a.core.GetEngagementFunnel(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSubscriberGrowthReport returns the number of new subscribers over time (?from=&to=,
+// the last 30 days by default) by day, week, or month (?group_by=), broken down by the
+// source of their subscriptions (api, form, import, automation). An optional list_id
+// restricts the report to the subscriptions to that list.
+func (a *App) GetSubscriberGrowthReport(c echo.Context) error {
+	var (
+		from    = c.QueryParam("from")
+		to      = c.QueryParam("to")
+		groupBy = c.QueryParam("group_by")
+		listID  = 0
+	)
+	if (from != "" && !strHasLen(from, 10, 30)) || (to != "" && !strHasLen(to, 10, 30)) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
+	}
+
+	if groupBy == "" {
+		groupBy = "day"
+	}
+	if groupBy != "day" && groupBy != "week" && groupBy != "month" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "group_by"))
+	}
+
+	if v := c.QueryParam("list_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+		}
+		listID = id
+	}
+
+	// Reporting on all subscribers requires blanket list permissions.
+	// Otherwise, the user should have access to the given list.
+	user := auth.GetUser(c)
+	if listID > 0 {
+		if err := user.HasListPerm(auth.PermTypeGet, listID); err != nil {
+			return err
+		}
+	} else if hasAll, _ := user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage); !hasAll {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", "lists"))
+	}
+
+	out, err := a.core.GetSubscriberGrowth(from, to, groupBy, listID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSubscriberCohorts returns the cumulative open or click rates (?metric=open_rate|click_rate)
+// of the weekly cohorts of subscribers who subscribed in the last n weeks, by the number of
+// weeks since they subscribed. An optional list_id restricts the cohorts to the subscribers
+// of that list.
+func (a *App) GetSubscriberCohorts(c echo.Context) error {
+	var (
+		by     = c.QueryParam("by")
+		metric = c.QueryParam("metric")
+		weeks  = cohortDefaultWeeks
+		listID = 0
+	)
+
+	if by != "" && by != "subscribe_week" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "by"))
+	}
+
+	if metric == "" {
+		metric = "open_rate"
+	}
+	if metric != "open_rate" && metric != "click_rate" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "metric"))
+	}
+
+	if v := c.QueryParam("weeks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > cohortMaxWeeks {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "weeks"))
+		}
+		weeks = n
+	}
+
+	if v := c.QueryParam("list_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+		}
+		listID = id
+	}
+
+	// Aggregating across all subscribers requires blanket list permissions.
+	// Otherwise, the user should have access to the given list.
+	user := auth.GetUser(c)
+	if listID > 0 {
+		if err := user.HasListPerm(auth.PermTypeGet, listID); err != nil {
+			return err
+		}
+	} else if hasAll, _ := user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage); !hasAll {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", "lists"))
+	}
+
+	out, err := a.core.GetSubscriberCohorts(weeks, listID, metric)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSendFrequency returns the number of campaigns sent each week over the ?period
+// (eg: 90d or 12w) and the unsubscribe rates in those weeks, to help find the send
+// cadence that subscribers are comfortable with. An optional list_id restricts the
+// numbers to that list.
+func (a *App) GetSendFrequency(c echo.Context) error {
+	var (
+		days   = sendFreqDefaultDays
+		listID = 0
+	)
+
+	if v := c.QueryParam("period"); v != "" {
+		n, ok := parsePeriodDays(v)
+		if !ok || n < 1 || n > sendFreqMaxDays {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "period"))
+		}
+		days = n
+	}
+
+	if v := c.QueryParam("list_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+		}
+		listID = id
+	}
+
+	// Aggregating across all lists requires blanket list permissions.
+	// Otherwise, the user should have access to the given list.
+	user := auth.GetUser(c)
+	if listID > 0 {
+		if err := user.HasListPerm(auth.PermTypeGet, listID); err != nil {
+			return err
+		}
+	} else if hasAll, _ := user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage); !hasAll {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", "lists"))
+	}
+
+	out, err := a.core.GetSendFrequency(days, listID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// parsePeriodDays parses a period in days (eg: 90d) or weeks (eg: 12w) into days.
+func parsePeriodDays(s string) (int, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil {
+		return 0, false
+	}
+
+	switch s[len(s)-1] {
+	case 'd':
+		return n, true
+	case 'w':
+		return n * 7, true
+	}
+
+	return 0, false
+}
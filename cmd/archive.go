@@ -2,10 +2,21 @@ package main
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"html/template"
+	"mime"
 	"net/http"
+	"net/mail"
 	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/feeds"
 	"github.com/knadh/listmonk/internal/manager"
@@ -14,20 +25,61 @@ import (
 	null "gopkg.in/volatiletech/null.v6"
 )
 
+const (
+	// archiveCookieTTL is the duration for which a password protected archive
+	// page remains unlocked after the password is entered.
+	archiveCookieTTL = time.Hour
+
+	archiveCookiePrefix = "archive_"
+)
+
+var reHeadTag = regexp.MustCompile(`(?i)<head[^>]*>`)
+
 type campArchive struct {
-	UUID      string    `json:"uuid"`
-	Subject   string    `json:"subject"`
-	Content   string    `json:"content"`
-	CreatedAt null.Time `json:"created_at"`
-	SendAt    null.Time `json:"send_at"`
-	URL       string    `json:"url"`
+	UUID        string    `json:"uuid"`
+	Subject     string    `json:"subject"`
+	Content     string    `json:"content"`
+	Excerpt     string    `json:"excerpt"`
+	AccentColor string    `json:"accent_color"`
+	CoverURL    string    `json:"cover_url"`
+	CreatedAt   null.Time `json:"created_at"`
+	SendAt      null.Time `json:"send_at"`
+	URL         string    `json:"url"`
+
+	// Protected indicates that the archive page requires a password.
+	// The content of protected campaigns is never included in listings.
+	Protected bool `json:"protected"`
+}
+
+// archiveMetadata is the schema.org NewsArticle structured data (JSON-LD) of
+// a campaign archive page for search engines.
+type archiveMetadata struct {
+	Context          string         `json:"@context"`
+	Type             string         `json:"@type"`
+	Headline         string         `json:"headline"`
+	Author           *archiveAuthor `json:"author,omitempty"`
+	DatePublished    null.Time      `json:"datePublished"`
+	Description      string         `json:"description,omitempty"`
+	Image            string         `json:"image,omitempty"`
+	URL              string         `json:"url"`
+	MainEntityOfPage string         `json:"mainEntityOfPage"`
+}
+
+type archiveAuthor struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type archivePasswordTpl struct {
+	publicTpl
+	Error string
 }
 
 // GetCampaignArchives renders the public campaign archives page.
 func (a *App) GetCampaignArchives(c echo.Context) error {
 	// Get archives from the DB.
 	pg := a.pg.NewFromURL(c.Request().URL.Query())
-	camps, total, err := a.getCampaignArchives(pg.Offset, pg.Limit, false)
+	camps, total, err := a.getCampaignArchives(pg.Offset, pg.Limit, 0, false)
 	if err != nil {
 		return err
 	}
@@ -49,17 +101,136 @@ func (a *App) GetCampaignArchives(c echo.Context) error {
 	return c.JSON(200, okResp{out})
 }
 
+// jsonFeed is a JSON Feed 1.1 document. https://www.jsonfeed.org/version/1.1/
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string    `json:"id"`
+	URL           string    `json:"url"`
+	Title         string    `json:"title"`
+	ContentHTML   string    `json:"content_html,omitempty"`
+	ContentText   string    `json:"content_text,omitempty"`
+	Summary       string    `json:"summary,omitempty"`
+	Image         string    `json:"image,omitempty"`
+	DatePublished time.Time `json:"date_published"`
+}
+
 // GetCampaignArchivesFeed renders the public campaign archives RSS feed.
 func (a *App) GetCampaignArchivesFeed(c echo.Context) error {
+	feed, err := a.makeArchiveFeed(c)
+	if err != nil {
+		return err
+	}
+
+	if err := feed.WriteRss(c.Response().Writer); err != nil {
+		a.log.Printf("error generating archive RSS feed: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.errorProcessingRequest"))
+	}
+
+	return nil
+}
+
+// GetCampaignArchivesAtomFeed renders the public campaign archives Atom 1.0 feed.
+func (a *App) GetCampaignArchivesAtomFeed(c echo.Context) error {
+	feed, err := a.makeArchiveFeed(c)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/atom+xml; charset=utf-8")
+	if err := feed.WriteAtom(c.Response().Writer); err != nil {
+		a.log.Printf("error generating archive Atom feed: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.errorProcessingRequest"))
+	}
+
+	return nil
+}
+
+// GetCampaignArchivesJSONFeed renders the public campaign archives JSON Feed 1.1 feed.
+func (a *App) GetCampaignArchivesJSONFeed(c echo.Context) error {
+	feed, err := a.makeArchiveFeed(c)
+	if err != nil {
+		return err
+	}
+
+	feedURL, _ := url.JoinPath(a.urlCfg.ArchiveURL, "feed.json")
+	if listUUID := c.QueryParam("list_uuid"); listUUID != "" {
+		feedURL += "?list_uuid=" + url.QueryEscape(listUUID)
+	}
+	out := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.Link.Href,
+		FeedURL:     feedURL,
+		Description: feed.Description,
+		Items:       make([]jsonFeedItem, 0, len(feed.Items)),
+	}
+	for _, i := range feed.Items {
+		item := jsonFeedItem{
+			ID:            i.Id,
+			URL:           i.Link.Href,
+			Title:         i.Title,
+			ContentHTML:   i.Content,
+			Summary:       i.Description,
+			DatePublished: i.Created,
+		}
+		if i.Enclosure != nil {
+			item.Image = i.Enclosure.Url
+		}
+
+		// Items should have content. If the full content isn't published, use the excerpt.
+		if item.ContentHTML == "" {
+			item.ContentText = i.Description
+		}
+		out.Items = append(out.Items, item)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/feed+json; charset=utf-8")
+	return json.NewEncoder(c.Response().Writer).Encode(out)
+}
+
+// makeArchiveFeed returns the feed of archived campaigns that's rendered in
+// the different feed formats. If a public list's UUID is given in ?list_uuid,
+// only the campaigns sent to the list are in the feed.
+func (a *App) makeArchiveFeed(c echo.Context) (*feeds.Feed, error) {
 	var (
 		pg              = a.pg.NewFromURL(c.Request().URL.Query())
 		showFullContent = a.cfg.EnablePublicArchiveRSSContent
+		title           = a.cfg.SiteName
+		listID          = 0
 	)
 
+	if listUUID := c.QueryParam("list_uuid"); listUUID != "" {
+		if !reUUID.MatchString(listUUID) {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidUUID"))
+		}
+
+		list, err := a.core.GetList(0, listUUID)
+		if err != nil {
+			if er, ok := err.(*echo.HTTPError); !ok || er.Code != http.StatusBadRequest {
+				return nil, err
+			}
+		}
+
+		// Unknown and private lists aren't found.
+		if err != nil || list.Type == models.ListTypePrivate {
+			return nil, echo.NewHTTPError(http.StatusNotFound, a.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.list}"))
+		}
+		listID = list.ID
+		title += " - " + list.Name
+	}
+
 	// Get archives from the DB.
-	camps, _, err := a.getCampaignArchives(pg.Offset, pg.Limit, showFullContent)
+	camps, _, err := a.getCampaignArchives(pg.Offset, pg.Limit, listID, showFullContent)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Format output for the feed.
@@ -71,35 +242,48 @@ func (a *App) GetCampaignArchivesFeed(c echo.Context) error {
 			pubDate = c.SendAt.Time
 		}
 
-		out = append(out, &feeds.Item{
-			Title:   c.Subject,
-			Link:    &feeds.Link{Href: c.URL},
-			Content: c.Content,
-			Created: pubDate,
-		})
+		item := &feeds.Item{
+			Id:          c.URL,
+			Title:       c.Subject,
+			Link:        &feeds.Link{Href: c.URL},
+			Description: c.Excerpt,
+			Content:     c.Content,
+			Created:     pubDate,
+		}
+		if c.CoverURL != "" {
+			item.Enclosure = &feeds.Enclosure{
+				Url:    c.CoverURL,
+				Length: "0",
+				Type:   mime.TypeByExtension(path.Ext(c.CoverURL)),
+			}
+		}
+
+		out = append(out, item)
 	}
 
 	// Generate the feed.
 	feed := &feeds.Feed{
-		Title:       a.cfg.SiteName,
+		Title:       title,
 		Link:        &feeds.Link{Href: a.urlCfg.RootURL},
 		Description: a.i18n.T("public.archiveTitle"),
 		Items:       out,
 	}
 
-	if err := feed.WriteRss(c.Response().Writer); err != nil {
-		a.log.Printf("error generating archive RSS feed: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.errorProcessingRequest"))
+	// The feed was last updated when the newest campaign was published.
+	for _, i := range out {
+		if i.Created.After(feed.Updated) {
+			feed.Updated = i.Created
+		}
 	}
 
-	return nil
+	return feed, nil
 }
 
 // CampaignArchivesPage renders the public campaign archives page.
 func (a *App) CampaignArchivesPage(c echo.Context) error {
 	// Get archives from the DB.
 	pg := a.pg.NewFromURL(c.Request().URL.Query())
-	out, total, err := a.getCampaignArchives(pg.Offset, pg.Limit, false)
+	out, total, err := a.getCampaignArchives(pg.Offset, pg.Limit, 0, false)
 	if err != nil {
 		return err
 	}
@@ -154,6 +338,30 @@ func (a *App) CampaignArchivePage(c echo.Context) error {
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
 	}
 
+	// The campaign is password protected. Serve it only with a valid unlock
+	// cookie or on a POST with the correct password.
+	if pubCamp.ArchivePassword.Valid && !a.hasArchiveAccess(c, pubCamp) {
+		if c.Request().Method != http.MethodPost {
+			return a.renderArchivePassword(c, http.StatusOK, "")
+		}
+
+		ok, err := a.core.CheckCampaignArchivePassword(pubCamp.ID, c.FormValue("password"))
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, tplMessage,
+				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
+		}
+		if !ok {
+			return a.renderArchivePassword(c, http.StatusUnauthorized, a.i18n.T("public.archivePasswordInvalid"))
+		}
+
+		a.setArchiveCookie(c, pubCamp)
+	}
+
+	// Serve the raw Markdown source of Markdown campaigns for download.
+	if c.QueryParam("format") == "md" {
+		return a.downloadArchiveMarkdown(c, pubCamp)
+	}
+
 	// "Compile" the campaign template with appropriate data.
 	out, err := a.compileArchiveCampaigns([]models.Campaign{pubCamp})
 	if err != nil {
@@ -170,13 +378,77 @@ func (a *App) CampaignArchivePage(c echo.Context) error {
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
 	}
 
-	return c.HTML(http.StatusOK, string(msg.Body()))
+	// Add the social meta tags to the rendered page.
+	u, _ := url.JoinPath(a.urlCfg.ArchiveURL, idStr)
+	return c.HTML(http.StatusOK, injectArchiveMetaTags(string(msg.Body()), camp, u))
+}
+
+// downloadArchiveMarkdown serves the raw Markdown body of a public Markdown
+// campaign as a file download.
+func (a *App) downloadArchiveMarkdown(c echo.Context, camp models.Campaign) error {
+	if camp.ContentType != models.CampaignContentTypeMarkdown {
+		return c.Render(http.StatusBadRequest, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("globals.messages.invalidFields", "name", "format")))
+	}
+
+	name := camp.UUID
+	if camp.ArchiveSlug.Valid && camp.ArchiveSlug.String != "" {
+		name = camp.ArchiveSlug.String
+	}
+
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.md"`, name))
+	return c.Blob(http.StatusOK, "text/markdown; charset=utf-8", []byte(camp.Body))
+}
+
+// CampaignArchiveMetadata returns the schema.org NewsArticle structured data
+// (JSON-LD) of a public campaign archive page.
+func (a *App) CampaignArchiveMetadata(c echo.Context) error {
+	// ID can be the UUID or slug.
+	var (
+		idStr      = c.Param("id")
+		uuid, slug string
+	)
+	if reUUID.MatchString(idStr) {
+		uuid = idStr
+	} else {
+		slug = idStr
+	}
+
+	pubCamp, err := a.core.GetArchivedCampaign(0, uuid, slug)
+	if err != nil {
+		if er, ok := err.(*echo.HTTPError); ok && er.Code == http.StatusBadRequest {
+			return echo.NewHTTPError(http.StatusNotFound, a.i18n.T("public.campaignNotFound"))
+		}
+		return err
+	}
+	if pubCamp.Type != models.CampaignTypeRegular {
+		return echo.NewHTTPError(http.StatusNotFound, a.i18n.T("public.campaignNotFound"))
+	}
+
+	// Password protected campaigns are only described with a valid unlock cookie.
+	if pubCamp.ArchivePassword.Valid && !a.hasArchiveAccess(c, pubCamp) {
+		return echo.NewHTTPError(http.StatusUnauthorized, a.i18n.T("public.archivePasswordInfo"))
+	}
+
+	// Compile the campaign to render its subject.
+	out, err := a.compileArchiveCampaigns([]models.Campaign{pubCamp})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorFetchingCampaign"))
+	}
+
+	u, _ := url.JoinPath(a.urlCfg.ArchiveURL, idStr)
+	b, err := json.Marshal(makeArchiveMetadata(out[0].Campaign, u))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorFetchingCampaign"))
+	}
+
+	return c.Blob(http.StatusOK, "application/ld+json", b)
 }
 
 // CampaignArchivePageLatest renders the latest public campaign.
 func (a *App) CampaignArchivePageLatest(c echo.Context) error {
 	// Get the latest campaign from the DB.
-	camps, _, err := a.getCampaignArchives(0, 1, true)
+	camps, _, err := a.getCampaignArchives(0, 1, 0, true)
 	if err != nil {
 		return err
 	}
@@ -187,12 +459,19 @@ func (a *App) CampaignArchivePageLatest(c echo.Context) error {
 	}
 	camp := camps[0]
 
+	// Protected campaigns aren't rendered in listings. Send the visitor
+	// to the campaign's page to unlock it.
+	if camp.Protected {
+		return c.Redirect(http.StatusFound, camp.URL)
+	}
+
 	return c.HTML(http.StatusOK, camp.Content)
 }
 
-// getCampaignArchives fetches the public campaign archives from the DB.
-func (a *App) getCampaignArchives(offset, limit int, renderBody bool) ([]campArchive, int, error) {
-	pubCamps, total, err := a.core.GetArchivedCampaigns(offset, limit)
+// getCampaignArchives fetches the public campaign archives from the DB, optionally
+// only the ones sent to the given list.
+func (a *App) getCampaignArchives(offset, limit, listID int, renderBody bool) ([]campArchive, int, error) {
+	pubCamps, total, err := a.core.GetArchivedCampaigns(offset, limit, listID)
 	if err != nil {
 		return []campArchive{}, total, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorFetchingCampaign"))
 	}
@@ -207,10 +486,14 @@ func (a *App) getCampaignArchives(offset, limit int, renderBody bool) ([]campArc
 		camp := m.Campaign
 
 		archive := campArchive{
-			UUID:      camp.UUID,
-			Subject:   camp.Subject,
-			CreatedAt: camp.CreatedAt,
-			SendAt:    camp.SendAt,
+			UUID:        camp.UUID,
+			Subject:     camp.Subject,
+			Excerpt:     camp.ArchiveExcerpt.String,
+			AccentColor: camp.ArchiveAccentColor.String,
+			CoverURL:    camp.ArchiveCoverURL,
+			CreatedAt:   camp.CreatedAt,
+			SendAt:      camp.SendAt,
+			Protected:   camp.ArchivePassword.Valid,
 		}
 
 		// The campaign may have a custom slug.
@@ -221,7 +504,7 @@ func (a *App) getCampaignArchives(offset, limit int, renderBody bool) ([]campArc
 		}
 
 		// Render the full template body if requested.
-		if renderBody {
+		if renderBody && !archive.Protected {
 			msg, err := a.manager.NewCampaignMessage(camp, m.Subscriber)
 			if err != nil {
 				return []campArchive{}, total, err
@@ -235,6 +518,67 @@ func (a *App) getCampaignArchives(offset, limit int, renderBody bool) ([]campArc
 	return out, total, nil
 }
 
+// renderArchivePassword renders the password form of a protected archive page.
+func (a *App) renderArchivePassword(c echo.Context, code int, errMsg string) error {
+	title := a.i18n.T("public.archivePasswordTitle")
+	return c.Render(code, "archive-password", archivePasswordTpl{
+		publicTpl: publicTpl{Title: title},
+		Error:     errMsg,
+	})
+}
+
+// hasArchiveAccess checks whether the request has a valid, unexpired cookie
+// that unlocks the given campaign's protected archive page.
+func (a *App) hasArchiveAccess(c echo.Context, camp models.Campaign) bool {
+	if a.cfg.Security.ArchiveKey == "" {
+		return false
+	}
+
+	ck, err := c.Cookie(archiveCookiePrefix + camp.UUID)
+	if err != nil {
+		return false
+	}
+
+	// The cookie is in the format expiry.signature.
+	exp, sig, ok := strings.Cut(ck.Value, ".")
+	if !ok {
+		return false
+	}
+	expiry, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(a.signArchiveCookie(camp, expiry)))
+}
+
+// setArchiveCookie sets the short-lived cookie that unlocks the given
+// campaign's protected archive page.
+func (a *App) setArchiveCookie(c echo.Context, camp models.Campaign) {
+	if a.cfg.Security.ArchiveKey == "" {
+		return
+	}
+
+	expiry := time.Now().Add(archiveCookieTTL).Unix()
+	c.SetCookie(&http.Cookie{
+		Name:     archiveCookiePrefix + camp.UUID,
+		Value:    strconv.FormatInt(expiry, 10) + "." + a.signArchiveCookie(camp, expiry),
+		Path:     "/archive",
+		MaxAge:   int(archiveCookieTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// signArchiveCookie returns the hex signature of an archive unlock cookie. The
+// password hash is a part of the signature so that changing the password
+// invalidates existing cookies.
+func (a *App) signArchiveCookie(camp models.Campaign, expiry int64) string {
+	h := hmac.New(sha256.New, []byte(a.cfg.Security.ArchiveKey))
+	fmt.Fprintf(h, "%s.%d.%s", camp.UUID, expiry, camp.ArchivePassword.String)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // compileArchiveCampaigns compiles the campaign template with the subscriber data.
 func (a *App) compileArchiveCampaigns(camps []models.Campaign) ([]manager.CampaignMessage, error) {
 
@@ -244,6 +588,15 @@ func (a *App) compileArchiveCampaigns(camps []models.Campaign) ([]manager.Campai
 	)
 	for _, c := range camps {
 		camp := c
+
+		// Resolve the cover image's URL so that it's available to the templates.
+		// The media may have been deleted from the store.
+		if camp.ArchiveCoverMediaID.Valid {
+			if m, err := a.core.GetMedia(int(camp.ArchiveCoverMediaID.Int), "", "", a.media); err == nil {
+				camp.ArchiveCoverURL = m.URL
+			}
+		}
+
 		if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
 			a.log.Printf("error compiling template: %v", err)
 			return nil, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorFetchingCampaign"))
@@ -275,3 +628,67 @@ func (a *App) compileArchiveCampaigns(camps []models.Campaign) ([]manager.Campai
 
 	return out, nil
 }
+
+// makeArchiveMetadata returns the NewsArticle structured data of a campaign's archive page.
+// The author is the name in the campaign's from address, or the address if it has no name.
+func makeArchiveMetadata(camp *models.Campaign, pageURL string) archiveMetadata {
+	out := archiveMetadata{
+		Context:          "https://schema.org",
+		Type:             "NewsArticle",
+		Headline:         camp.Subject,
+		DatePublished:    camp.SendAt,
+		Description:      camp.ArchiveExcerpt.String,
+		Image:            camp.ArchiveCoverURL,
+		URL:              pageURL,
+		MainEntityOfPage: pageURL,
+	}
+
+	// Campaigns that aren't scheduled are published when they're started.
+	if !out.DatePublished.Valid {
+		out.DatePublished = camp.StartedAt
+	}
+	if !out.DatePublished.Valid {
+		out.DatePublished = camp.CreatedAt
+	}
+
+	if em, err := mail.ParseAddress(camp.FromEmail); err == nil {
+		name := em.Name
+		if name == "" {
+			name = em.Address
+		}
+		out.Author = &archiveAuthor{Type: "Person", Name: name}
+	}
+
+	return out
+}
+
+// injectArchiveMetaTags adds Open Graph meta tags with the campaign's subject, excerpt,
+// and cover image, and its structured data to the <head> of a rendered archive page,
+// or to the top of the page if there's no <head>.
+func injectArchiveMetaTags(body string, camp *models.Campaign, pageURL string) string {
+	esc := template.HTMLEscapeString
+
+	var b strings.Builder
+	b.WriteString(`<meta property="og:type" content="article" />` + "\n")
+	fmt.Fprintf(&b, `<meta property="og:title" content="%s" />`+"\n", esc(camp.Subject))
+	fmt.Fprintf(&b, `<meta property="og:url" content="%s" />`+"\n", esc(pageURL))
+	if camp.ArchiveExcerpt.String != "" {
+		fmt.Fprintf(&b, `<meta name="description" content="%s" />`+"\n", esc(camp.ArchiveExcerpt.String))
+		fmt.Fprintf(&b, `<meta property="og:description" content="%s" />`+"\n", esc(camp.ArchiveExcerpt.String))
+	}
+	if camp.ArchiveCoverURL != "" {
+		fmt.Fprintf(&b, `<meta property="og:image" content="%s" />`+"\n", esc(camp.ArchiveCoverURL))
+		b.WriteString(`<meta name="twitter:card" content="summary_large_image" />` + "\n")
+	}
+
+	// Structured data for search engines. json.Marshal escapes <, >, and & and
+	// the JSON can't break out of the script tag.
+	if j, err := json.Marshal(makeArchiveMetadata(camp, pageURL)); err == nil {
+		fmt.Fprintf(&b, `<script type="application/ld+json">%s</script>`+"\n", j)
+	}
+
+	if loc := reHeadTag.FindStringIndex(body); loc != nil {
+		return body[:loc[1]] + "\n" + b.String() + body[loc[1]:]
+	}
+	return b.String() + body
+}
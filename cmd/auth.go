@@ -263,7 +263,7 @@ func (a *App) OIDCFinish(c echo.Context) error {
 	}
 
 	// Set the session in the DB and cookie.
-	if err := a.auth.SaveSession(user, oidcToken, c); err != nil {
+	if err := a.saveSession(user, oidcToken, c); err != nil {
 		return a.renderLoginPage(c, err)
 	}
 
@@ -492,13 +492,40 @@ func (a *App) doLogin(c echo.Context) error {
 	}
 
 	// Set the session in the DB and cookie.
-	if err := a.auth.SaveSession(user, "", c); err != nil {
+	if err := a.saveSession(user, "", c); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// saveSession creates and sets a login session for the user and records the IP they logged in from.
+func (a *App) saveSession(u auth.User, oidcToken string, c echo.Context) error {
+	if err := a.auth.SaveSession(u, oidcToken, c); err != nil {
+		return err
+	}
+
+	ip := c.RealIP()
+	// Failing to record the IP shouldn't fail the login.
+	isNew, err := a.core.RecordUserLoginIP(u.ID, ip)
+	if err != nil {
+		return nil
+	}
+
+	// Notify the admin if a super admin logs in from an IP they've never logged in from before.
+	if isNew && u.UserRoleID == auth.SuperAdminRoleID {
+		notifs.Alert(models.NotificationNewLogin, fmt.Sprintf("%d:%s", u.ID, ip),
+			a.i18n.Ts("notifications.newLogin", "name", u.Username, "ip", ip), map[string]any{
+				"user":       u.Username,
+				"id":         u.ID,
+				"ip":         ip,
+				"user_agent": c.Request().UserAgent(),
+			})
+	}
+
+	return nil
+}
+
 // doFirstTimeSetup sets a user up for the first time.
 func (a *App) doFirstTimeSetup(c echo.Context) error {
 	var (
@@ -559,7 +586,7 @@ func (a *App) doFirstTimeSetup(c echo.Context) error {
 	}
 
 	// Set the session in the DB and cookie.
-	if err := a.auth.SaveSession(user, "", c); err != nil {
+	if err := a.saveSession(user, "", c); err != nil {
 		return err
 	}
 
@@ -693,7 +720,7 @@ func (a *App) doResetPassword(c echo.Context, token, email string) error {
 	}
 
 	// Log the user in directly without forcing a manual login right after password change.
-	if err := a.auth.SaveSession(user, "", c); err != nil {
+	if err := a.saveSession(user, "", c); err != nil {
 		return err
 	}
 
@@ -743,7 +770,7 @@ func (a *App) doTwofaVerify(c echo.Context, token string, userID int, next strin
 	tmptokens.Delete(token)
 
 	// Set the session.
-	if err := a.auth.SaveSession(user, "", c); err != nil {
+	if err := a.saveSession(user, "", c); err != nil {
 		return err
 	}
 
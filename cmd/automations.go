@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	reconfirmMaxInactiveDays = 3650
+	reconfirmMaxConfirmDays  = 365
+
+	// reconfirmExpiryInterval is the interval at which expired re-confirmations
+	// are processed and their subscribers unsubscribed.
+	reconfirmExpiryInterval = time.Hour
+)
+
+type reconfirmReq struct {
+	InactiveDays int   `json:"inactive_days"`
+	ConfirmDays  int   `json:"confirm_days"`
+	TemplateID   int   `json:"template_id"`
+	ListIDs      []int `json:"list_ids"`
+}
+
+type reconfirmTpl struct {
+	publicTpl
+	Reconfirmation models.Reconfirmation
+}
+
+// CreateReconfirmation finds the subscribers on the given lists who haven't interacted with
+// any campaign in the last inactive_days and sends them the given tx template asking them
+// to re-confirm their subscriptions. The ones who don't confirm within confirm_days are
+// unsubscribed from the lists.
+func (a *App) CreateReconfirmation(c echo.Context) error {
+	var req reconfirmReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if req.InactiveDays < 1 || req.InactiveDays > reconfirmMaxInactiveDays {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "inactive_days"))
+	}
+	if req.ConfirmDays < 1 || req.ConfirmDays > reconfirmMaxConfirmDays {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "confirm_days"))
+	}
+	if len(req.ListIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "list_ids"))
+	}
+
+	// The user should be able to manage every list.
+	user := auth.GetUser(c)
+	for _, id := range req.ListIDs {
+		if id < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+		}
+		if err := user.HasListPerm(auth.PermTypeManage, id); err != nil {
+			return err
+		}
+	}
+
+	// Get the cached tx template.
+	tpl, err := a.manager.GetTpl(req.TemplateID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.notFound", "name", fmt.Sprintf("template %d", req.TemplateID)))
+	}
+
+	// Render the template once before creating the re-confirmations so that
+	// subscribers don't get unsubscribed for messages that couldn't be sent.
+	if _, err := a.renderReconfirmation(models.Reconfirmation{}, dummySubscriber, tpl); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorRendering", "error", err.Error()))
+	}
+
+	recs, err := a.core.CreateReconfirmations(req.InactiveDays, req.ConfirmDays, req.ListIDs)
+	if err != nil {
+		return err
+	}
+
+	// Send the messages in the background as there could be many.
+	go a.sendReconfirmations(recs, tpl)
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Count int `json:"count"`
+	}{len(recs)}})
+}
+
+// sendReconfirmations renders the re-confirmation template for the subscriber
+// of each re-confirmation and sends it.
+func (a *App) sendReconfirmations(recs []models.Reconfirmation, tpl *models.Template) {
+	for _, r := range recs {
+		sub, err := a.core.GetSubscriber(r.SubscriberID, "", "")
+		if err != nil {
+			continue
+		}
+
+		msg, err := a.renderReconfirmation(r, sub, tpl)
+		if err != nil {
+			a.log.Printf("error rendering re-confirmation template: %v", err)
+			continue
+		}
+
+		if err := a.manager.PushMessage(msg); err != nil {
+			a.log.Printf("error sending re-confirmation (%s): %v", sub.Email, err)
+		}
+	}
+}
+
+// renderReconfirmation renders the re-confirmation template for a subscriber. The confirmation
+// URL, expiry, and the list IDs are available to the template in .Tx.Data.
+func (a *App) renderReconfirmation(r models.Reconfirmation, sub models.Subscriber, tpl *models.Template) (models.Message, error) {
+	m := models.TxMessage{
+		FromEmail: a.cfg.FromEmail,
+		Messenger: emailMsgr,
+		Data: map[string]any{
+			"ConfirmURL": a.urlCfg.RootURL + "/subscription/reconfirm/" + r.UUID,
+			"ExpiresAt":  r.ExpiresAt,
+			"ListIDs":    r.ListIDs,
+		},
+	}
+
+	// Render the message with only the attributes available to templates.
+	tplSub := sub
+	tplSub.Attribs = a.manager.TemplateAttribs().Apply(sub.Attribs)
+	if err := m.Render(tplSub, tpl, a.manager.GenericTemplateFuncs()); err != nil {
+		return models.Message{}, err
+	}
+
+	msg := models.Message{}
+	msg.Subscriber = sub
+	msg.To = []string{sub.Email}
+	msg.From = m.FromEmail
+	msg.Subject = m.Subject
+	msg.ContentType = m.ContentType
+	msg.Messenger = m.Messenger
+	msg.Body = m.Body
+	msg.AltBody = []byte(m.AltBody)
+	msg.Attachments = append(msg.Attachments, tpl.Attachments...)
+
+	// Journal a copy of the message as an envelope recipient.
+	if j := a.cfg.Privacy.Journal; j.Enabled && j.Tx && j.Address != "" {
+		msg.Bcc = []string{j.Address}
+	}
+
+	return msg, nil
+}
+
+// ReconfirmPage renders the page on which subscribers re-confirm their
+// subscriptions from the link in re-confirmation messages.
+func (a *App) ReconfirmPage(c echo.Context) error {
+	var (
+		uuid       = c.Param("uuid")
+		confirm, _ = strconv.ParseBool(c.FormValue("confirm"))
+	)
+
+	r, err := a.core.GetReconfirmation(uuid)
+	if err != nil {
+		if e, ok := err.(*echo.HTTPError); ok && e.Code == http.StatusNotFound {
+			return c.Render(http.StatusNotFound, tplMessage,
+				makeMsgTpl(a.i18n.T("public.notFoundTitle"), "", a.i18n.T("public.reconfirmNotFound")))
+		}
+
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
+	}
+
+	switch {
+	case r.Status == models.ReconfirmationStatusConfirmed:
+		return c.Render(http.StatusOK, tplMessage,
+			makeMsgTpl(a.i18n.T("public.subConfirmedTitle"), "", a.i18n.T("public.reconfirmed")))
+	case r.Status == models.ReconfirmationStatusExpired || !r.ExpiresAt.After(time.Now()):
+		return c.Render(http.StatusOK, tplMessage,
+			makeMsgTpl(a.i18n.T("public.unsubbedTitle"), "", a.i18n.T("public.reconfirmExpired")))
+	}
+
+	if !confirm || c.Request().Method != http.MethodPost {
+		out := reconfirmTpl{Reconfirmation: r}
+		out.Title = a.i18n.T("public.reconfirmTitle")
+		return c.Render(http.StatusOK, "reconfirm", out)
+	}
+
+	if ok, err := a.core.ConfirmReconfirmation(uuid); err != nil {
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
+	} else if !ok {
+		return c.Render(http.StatusOK, tplMessage,
+			makeMsgTpl(a.i18n.T("public.unsubbedTitle"), "", a.i18n.T("public.reconfirmExpired")))
+	}
+
+	listIDs := make([]int, 0, len(r.ListIDs))
+	for _, id := range r.ListIDs {
+		listIDs = append(listIDs, int(id))
+	}
+	a.recordConsent(c, models.ConsentEvent{SubscriberIDs: []int{r.SubscriberID}, Type: models.ConsentTypeMarketing,
+		ListIDs: listIDs, Text: a.i18n.T("subscribers.consentReconfirm")})
+
+	return c.Render(http.StatusOK, tplMessage,
+		makeMsgTpl(a.i18n.T("public.subConfirmedTitle"), "", a.i18n.T("public.reconfirmed")))
+}
+
+// expireReconfirmations unsubscribes the subscribers who didn't re-confirm
+// their subscriptions before their re-confirmations expired.
+func expireReconfirmations(co *core.Core) {
+	n, err := co.ExpireReconfirmations()
+	if err != nil {
+		return
+	}
+
+	if n > 0 {
+		lo.Printf("unsubscribed %d subscribers with expired re-confirmations", n)
+	}
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"io"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/bounce/mailbox"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
+// maxDeliveryCount is the max. number of deliveries that can be confirmed in
+// a single delivery webhook request.
+const maxDeliveryCount = 100000
+
 // GetBounce handles retrieval of a specific bounce record by ID.
 func (a *App) GetBounce(c echo.Context) error {
 	// Fetch one bounce from the DB.
@@ -29,14 +36,23 @@ func (a *App) GetBounces(c echo.Context) error {
 	var (
 		campID, _ = strconv.Atoi(c.QueryParam("campaign_id"))
 		source    = c.FormValue("source")
+		reason    = c.FormValue("reason")
+		typ       = c.FormValue("type")
 		orderBy   = c.FormValue("order_by")
 		order     = c.FormValue("order")
 
 		pg = a.pg.NewFromURL(c.Request().URL.Query())
 	)
 
+	if reason != "" && !slices.Contains(models.BounceReasons, reason) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "reason"))
+	}
+	if typ != "" && typ != models.BounceTypeHard && typ != models.BounceTypeSoft && typ != models.BounceTypeComplaint {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+	}
+
 	// Query and fetch bounces from the DB.
-	res, total, err := a.core.QueryBounces(campID, 0, source, orderBy, order, pg.Offset, pg.Limit)
+	res, total, err := a.core.QueryBounces(campID, 0, source, reason, typ, orderBy, order, pg.Offset, pg.Limit)
 	if err != nil {
 		return err
 	}
@@ -56,6 +72,119 @@ func (a *App) GetBounces(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// GetCampaignBounces handles retrieval of the bounce records of a campaign,
+// optionally filtered by bounce type.
+func (a *App) GetCampaignBounces(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	var (
+		typ     = c.FormValue("type")
+		orderBy = c.FormValue("order_by")
+		order   = c.FormValue("order")
+
+		pg = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+	if typ != "" && typ != models.BounceTypeHard && typ != models.BounceTypeSoft && typ != models.BounceTypeComplaint {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+	}
+
+	res, total, err := a.core.QueryBounces(id, 0, "", "", typ, orderBy, order, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	// No results.
+	if len(res) == 0 {
+		return c.JSON(http.StatusOK, okResp{models.PageResults{Results: []models.Bounce{}}})
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetBounceReasons handles retrieval of the number of bounces by reason,
+// optionally filtered by campaign, source, and date range.
+func (a *App) GetBounceReasons(c echo.Context) error {
+	var (
+		campID, _ = strconv.Atoi(c.QueryParam("campaign_id"))
+		source    = c.FormValue("source")
+		from      = c.QueryParam("from")
+		to        = c.QueryParam("to")
+	)
+	if (from != "" && !strHasLen(from, 10, 30)) || (to != "" && !strHasLen(to, 10, 30)) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
+	}
+
+	campIDs := []int{}
+	if campID > 0 {
+		campIDs = append(campIDs, campID)
+	}
+
+	out, err := a.core.GetBounceReasonCounts(campIDs, source, from, to)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetBounceDomains handles retrieval of the number of bounces by the domain of the
+// subscribers' e-mail addresses, optionally filtered by date range. With ?format=csv,
+// the counts are downloaded as a CSV file.
+func (a *App) GetBounceDomains(c echo.Context) error {
+	var (
+		from   = c.QueryParam("from")
+		to     = c.QueryParam("to")
+		format = c.QueryParam("format")
+	)
+	if (from != "" && !strHasLen(from, 10, 30)) || (to != "" && !strHasLen(to, 10, 30)) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
+	}
+	if format != "" && format != "json" && format != "csv" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "format"))
+	}
+
+	out, err := a.core.GetBounceDomainCounts(from, to)
+	if err != nil {
+		return err
+	}
+
+	if format != "csv" {
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	var (
+		hdr = c.Response().Header()
+		wr  = csv.NewWriter(c.Response())
+	)
+	hdr.Set(echo.HeaderContentType, "text/csv")
+	hdr.Set(echo.HeaderContentDisposition, "attachment; filename=bounce_domains.csv")
+	hdr.Set("Cache-Control", "no-cache")
+
+	wr.Write([]string{"domain", "hard", "soft", "complaint", "total"})
+	for _, d := range out {
+		if err := wr.Write([]string{d.Domain, strconv.Itoa(d.Hard), strconv.Itoa(d.Soft),
+			strconv.Itoa(d.Complaint), strconv.Itoa(d.Total)}); err != nil {
+			a.log.Printf("error streaming CSV: %v", err)
+			return nil
+		}
+	}
+	wr.Flush()
+
+	return nil
+}
+
 // GetSubscriberBounces retrieves a subscriber's bounce records.
 func (a *App) GetSubscriberBounces(c echo.Context) error {
 	subID := getID(c)
@@ -66,7 +195,7 @@ func (a *App) GetSubscriberBounces(c echo.Context) error {
 	}
 
 	// Query and fetch bounces from the DB.
-	out, _, err := a.core.QueryBounces(0, subID, "", "", "", 0, 1000)
+	out, _, err := a.core.QueryBounces(0, subID, "", "", "", "", "", 0, 1000)
 	if err != nil {
 		return err
 	}
@@ -120,6 +249,37 @@ func (a *App) BlocklistBouncedSubscribers(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// GetBounceMailbox handles retrieval of the status of the bounce mailbox scanner
+// and the messages in the mailbox that are quarantined.
+func (a *App) GetBounceMailbox(c echo.Context) error {
+	// If bounce processing is disabled, a.bounce will be nil.
+	if a.bounce == nil {
+		return c.JSON(http.StatusOK, okResp{mailbox.Status{Quarantine: []mailbox.QuarantinedMessage{}}})
+	}
+
+	return c.JSON(http.StatusOK, okResp{a.bounce.MailboxStatus()})
+}
+
+// GetQuarantinedBounce handles the download of the raw copy of a quarantined
+// bounce mailbox message for debugging.
+func (a *App) GetQuarantinedBounce(c echo.Context) error {
+	uid := c.Param("uid")
+
+	var (
+		b  []byte
+		ok bool
+	)
+	if a.bounce != nil {
+		b, ok = a.bounce.QuarantinedMessage(uid)
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, a.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.bounce}"))
+	}
+
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="quarantined-bounce.eml"`)
+	return c.Blob(http.StatusOK, "message/rfc822", b)
+}
+
 // BounceWebhook handles incoming bounce webhook notifications from various providers.
 func (a *App) BounceWebhook(c echo.Context) error {
 	// If bounce processing is disabled, a.bounce will be nil.
@@ -178,12 +338,12 @@ func (a *App) BounceWebhook(c echo.Context) error {
 
 		// Bounce notification.
 		case "Notification":
-			b, err := a.bounce.SES.ProcessBounce(rawReq)
+			bs, err := a.bounce.SES.ProcessBounce(rawReq)
 			if err != nil {
 				a.log.Printf("error processing SES notification: %v", err)
 				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 			}
-			bounces = append(bounces, b)
+			bounces = append(bounces, bs...)
 
 		default:
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
@@ -287,6 +447,45 @@ func (a *App) BounceWebhook(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// DeliveryWebhook records delivery confirmations of a campaign's messages posted
+// by relays or e-mail services as {"campaign_uuid": "", "count": n}.
+func (a *App) DeliveryWebhook(c echo.Context) error {
+	var req struct {
+		CampaignUUID string `json:"campaign_uuid"`
+		Count        int    `json:"count"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+":"+err.Error())
+	}
+
+	if !reUUID.MatchString(req.CampaignUUID) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "campaign_uuid"))
+	}
+
+	// A confirmation without a count is of a single message.
+	if req.Count == 0 {
+		req.Count = 1
+	}
+	if req.Count < 1 || req.Count > maxDeliveryCount {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "count"))
+	}
+
+	if err := a.core.RecordCampaignDeliveries(req.CampaignUUID, req.Count); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// SESWebhook handles the bounce and complaint notifications of SES configuration sets
+// published to an SNS topic. It's the same as the ses bounce webhook service.
+func (a *App) SESWebhook(c echo.Context) error {
+	c.SetParamNames("service")
+	c.SetParamValues("ses")
+
+	return a.BounceWebhook(c)
+}
+
 func (a *App) validateBounceFields(b models.Bounce) (models.Bounce, error) {
 	if b.Email == "" && b.SubscriberUUID == "" {
 		return b, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "email / subscriber_uuid"))
@@ -308,5 +507,10 @@ func (a *App) validateBounceFields(b models.Bounce) (models.Bounce, error) {
 		return b, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "type"))
 	}
 
+	// The reason is optional and is classified from the meta if it's not set.
+	if b.Reason != "" && !slices.Contains(models.BounceReasons, b.Reason) {
+		return b, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "reason"))
+	}
+
 	return b, nil
 }
@@ -2,25 +2,46 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/knadh/listmonk/internal/a11y"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/campgate"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/htmlmin"
+	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/outlook"
+	"github.com/knadh/listmonk/internal/spellcheck"
+	"github.com/knadh/listmonk/internal/tmptokens"
+	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
 	"gopkg.in/volatiletech/null.v6"
 )
 
+// campBodyMeta has the sizes (in bytes) of a campaign's body before and after
+// its HTML was minified.
+type campBodyMeta struct {
+	BodySizeOriginal int `json:"body_size_original"`
+	BodySizeMinified int `json:"body_size_minified"`
+}
+
 // campReq is a wrapper over the Campaign model for receiving
 // campaign creation and update data from APIs.
 type campReq struct {
@@ -34,8 +55,21 @@ type campReq struct {
 
 	MediaIDs []int `json:"media"`
 
-	// This is only relevant to campaign test requests.
+	// These are only relevant to campaign test requests.
 	SubscriberEmails pq.StringArray `json:"subscribers"`
+	ReturnSource     bool           `json:"return_source"`
+}
+
+// campTestMessage represents a sent test message returned by a
+// campaign test request with return_source.
+type campTestMessage struct {
+	To        string               `json:"to"`
+	Messenger string               `json:"messenger"`
+	Headers   textproto.MIMEHeader `json:"headers"`
+	Source    string               `json:"source"`
+
+	// Differences between the test message and the real campaign message.
+	Differences []string `json:"differences"`
 }
 
 // campContentReq wraps params coming from API requests for converting
@@ -46,9 +80,60 @@ type campContentReq struct {
 	To   string `json:"to"`
 }
 
+// campConvertReq represents params for converting a campaign's content type.
+// An empty body converts the campaign's saved body.
+type campConvertReq struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Body string `json:"body"`
+}
+
+// campStartToken is the transient state of a pending campaign start
+// confirmation stored in tmptokens.
+type campStartToken struct {
+	Token  string
+	UserID int
+}
+
+const (
+	// campStartTTL is the time within which a campaign start has to be confirmed.
+	campStartTTL      = 2 * time.Minute
+	campStartTokenLen = 32
+
+	// archiveExcerptMaxLen is the max length of a campaign's archive excerpt.
+	archiveExcerptMaxLen = 1000
+
+	// maxSendSpread is the max window (minutes) over which a campaign's
+	// messages can be spread out.
+	maxSendSpread = 7 * 24 * 60
+
+	// Default and max number of recent comparable campaigns used for
+	// predicting a campaign's performance.
+	predictionDefaultCampaigns = 10
+	predictionMaxCampaigns     = 50
+
+	// Timeout for checking that a campaign's tracking domain points to listmonk.
+	trackingDomainTimeout = 5 * time.Second
+
+	// Default and max. page sizes of a campaign's activity.
+	activityDefaultPerPage = 500
+	activityMaxPerPage     = 5000
+
+	// Max. number of campaigns a campaign's audience can be compared with.
+	maxOverlapCampaigns = 10
+
+	// Max. number of subscribers in a campaign's test cohort.
+	testCohortMaxLimit = 10000
+
+	// Max. number of campaign analytics exports a user can run in the window.
+	analyticsExportRateLimit       = 10
+	analyticsExportRateLimitWindow = time.Hour
+)
+
 var (
 	reFromAddress = regexp.MustCompile(`((.+?)\s)?<(.+?)@(.+?)>`)
 	reSlug        = regexp.MustCompile(`[^\p{L}\p{M}\p{N}]`)
+	reHexColor    = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
 )
 
 // GetCampaigns handles retrieval of campaigns.
@@ -70,16 +155,17 @@ func (a *App) GetCampaigns(c echo.Context) error {
 	var (
 		pg = a.pg.NewFromURL(c.Request().URL.Query())
 
-		status    = c.QueryParams()["status"]
-		tags      = c.QueryParams()["tag"]
-		query     = strings.TrimSpace(c.FormValue("query"))
-		orderBy   = c.FormValue("order_by")
-		order     = c.FormValue("order")
-		noBody, _ = strconv.ParseBool(c.QueryParam("no_body"))
+		status        = c.QueryParams()["status"]
+		tags          = c.QueryParams()["tag"]
+		query         = strings.TrimSpace(c.FormValue("query"))
+		orderBy       = c.FormValue("order_by")
+		order         = c.FormValue("order")
+		noBody, _     = strconv.ParseBool(c.QueryParam("no_body"))
+		searchBody, _ = strconv.ParseBool(c.QueryParam("search_body"))
 	)
 
 	// Query and retrieve campaigns from the DB.
-	res, total, err := a.core.QueryCampaigns(query, status, tags, orderBy, order, hasAllPerm, permittedLists, pg.Offset, pg.Limit)
+	res, total, err := a.core.QueryCampaigns(query, searchBody, status, tags, orderBy, order, hasAllPerm, permittedLists, pg.Offset, pg.Limit)
 	if err != nil {
 		return err
 	}
@@ -130,11 +216,16 @@ func (a *App) GetCampaign(c echo.Context) error {
 		out.Body = ""
 	}
 
+	// Other users who are currently editing the campaign.
+	out.Editors = a.presence.Get(campPresenceKey(id), auth.GetUser(c).ID)
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// PreviewCampaign renders the HTML preview of a campaign body.
-func (a *App) PreviewCampaign(c echo.Context) error {
+// GetCampaignAudience returns the number of subscribers a campaign would be sent
+// to now along with the approximate daily counts of the last N (?days) days, so that
+// the changes in the audience of a scheduled campaign can be anticipated.
+func (a *App) GetCampaignAudience(c echo.Context) error {
 	// Get the campaign ID.
 	id := getID(c)
 
@@ -143,60 +234,38 @@ func (a *App) PreviewCampaign(c echo.Context) error {
 		return err
 	}
 
-	var (
-		isPost      = c.Request().Method == http.MethodPost
-		contentType = c.FormValue("content_type")
-		tplID, _    = strconv.Atoi(c.FormValue("template_id"))
-	)
-	// For visual content, template ID for previewing is irrelevant.
-	if contentType == models.CampaignContentTypeVisual || tplID < 1 {
-		tplID = 0
+	days, _ := strconv.Atoi(c.QueryParam("days"))
+	if days < 1 {
+		days = 7
+	} else if days > 90 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "days"))
 	}
 
-	// Get the campaign from the DB for previewing with the `template_body` field.
-	camp, err := a.core.GetCampaignForPreview(id, tplID)
+	cm, err := a.core.GetCampaign(id, "", "")
 	if err != nil {
 		return err
 	}
 
-	// There's a body in the request to preview instead of the body in the DB.
-	if isPost {
-		camp.ContentType = contentType
-		camp.Body = c.FormValue("body")
-
-		// For visual campaigns, template body from the DB shouldn't be used.
-		if contentType == models.CampaignContentTypeVisual {
-			camp.TemplateBody = ""
-		}
-	}
-
-	// Use a dummy campaign ID to prevent views and clicks from {{ TrackView }}
-	// and {{ TrackLink }} being registered on preview.
-	camp.UUID = dummySubscriber.UUID
-	if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
-		a.log.Printf("error compiling template: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest,
-			a.i18n.Ts("templates.errorCompiling", "error", err.Error()))
-	}
-
-	// Render the message body.
-	msg, err := a.manager.NewCampaignMessage(&camp, dummySubscriber)
+	count, err := a.core.GetCampaignAudienceCount(id)
 	if err != nil {
-		a.log.Printf("error rendering message: %v", err)
-		return echo.NewHTTPError(http.StatusBadRequest,
-			a.i18n.Ts("templates.errorRendering", "error", err.Error()))
+		return err
 	}
 
-	// Plaintext headers for plain body.
-	if camp.ContentType == models.CampaignContentTypePlain {
-		return c.String(http.StatusOK, string(msg.Body()))
+	trend, err := a.core.GetCampaignAudienceTrend(id, days)
+	if err != nil {
+		return err
 	}
 
-	return c.HTML(http.StatusOK, string(msg.Body()))
+	return c.JSON(http.StatusOK, okResp{models.CampaignAudience{
+		Count:    count,
+		FrozenAt: cm.AudienceFrozenAt,
+		Trend:    trend,
+	}})
 }
 
-// PreviewCampaignArchive renders the public campaign archives page.
-func (a *App) PreviewCampaignArchive(c echo.Context) error {
+// GetCampaignClientReport returns the number of opens of a campaign in each
+// e-mail client (eg: Gmail, Apple Mail) and their share of all the opens.
+func (a *App) GetCampaignClientReport(c echo.Context) error {
 	// Get the campaign ID.
 	id := getID(c)
 
@@ -205,100 +274,162 @@ func (a *App) PreviewCampaignArchive(c echo.Context) error {
 		return err
 	}
 
-	// Fetch the campaign body from the DB.
-	tplID, _ := strconv.Atoi(c.FormValue("template_id"))
-	camp, err := a.core.GetCampaignForPreview(id, tplID)
+	out, err := a.core.GetCampaignClientReport(id)
 	if err != nil {
 		return err
 	}
 
-	camp.ArchiveMeta = json.RawMessage([]byte(c.FormValue("archive_meta")))
+	return c.JSON(http.StatusOK, okResp{out})
+}
 
-	// "Compile" the campaign template with appropriate data.
-	res, err := a.compileArchiveCampaigns([]models.Campaign{camp})
-	if err != nil {
-		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
+// GetCampaignISPReport returns the number of subscribers of each major ISP (eg: gmail)
+// that a campaign was sent to, bounced for, and was opened by on the ISP's tracking domain.
+func (a *App) GetCampaignISPReport(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
 	}
 
-	// Render the campaign body.
-	out := res[0].Campaign
-	msg, err := a.manager.NewCampaignMessage(out, res[0].Subscriber)
+	out, err := a.core.GetCampaignISPReport(id)
 	if err != nil {
-		a.log.Printf("error rendering campaign: %v", err)
-		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
+		return err
 	}
 
-	return c.HTML(http.StatusOK, string(msg.Body()))
+	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// CampaignContent handles campaign content (body) format conversions.
-func (a *App) CampaignContent(c echo.Context) error {
-	var camp campContentReq
-	if err := c.Bind(&camp); err != nil {
+// GetCampaignSubscriberOverlap returns the number of subscribers in the audience of a
+// campaign who would also receive each of the given campaigns, eg: other campaigns
+// scheduled for the same day, to avoid overloading subscribers.
+func (a *App) GetCampaignSubscriberOverlap(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
 		return err
 	}
 
-	// Convert formats, eg: markdown to HTML.
-	out, err := camp.ConvertContent(camp.From, camp.To)
+	ids, err := parseStringIDs(strings.Split(c.QueryParam("compare_campaign_ids"), ","))
+	if err != nil || len(ids) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "compare_campaign_ids"))
+	}
+
+	// Dedup the IDs and skip the campaign itself.
+	ids = slices.DeleteFunc(slices.Compact(slices.Sorted(slices.Values(ids))), func(v int) bool {
+		return v == id
+	})
+	if len(ids) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "compare_campaign_ids"))
+	}
+	if len(ids) > maxOverlapCampaigns {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("campaigns.overlapMaxCampaigns", "num", strconv.Itoa(maxOverlapCampaigns)))
+	}
+
+	// Check if the user has access to all the compared campaigns.
+	for _, cid := range ids {
+		if err := a.checkCampaignPerm(auth.PermTypeGet, cid, c); err != nil {
+			return err
+		}
+	}
+
+	out, err := a.core.GetCampaignSubscriberOverlap(id, ids)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
-	return c.JSON(http.StatusOK, okResp{out})
+	return c.JSON(http.StatusOK, okResp{struct {
+		Overlap []models.CampaignOverlap `json:"overlap"`
+	}{out}})
 }
 
-// CreateCampaign handles campaign creation.
-// Newly created campaigns are always drafts.
-func (a *App) CreateCampaign(c echo.Context) error {
-	var o campReq
-	if err := c.Bind(&o); err != nil {
+// PreviewCampaign renders the HTML preview of a campaign body. If ?subscriber_id
+// is given, the body is rendered with that subscriber's data instead of the dummy
+// subscriber and the preview is recorded as a campaign event.
+func (a *App) PreviewCampaign(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
 		return err
 	}
 
-	// Filter lists against the current user's permitted lists.
-	user := auth.GetUser(c)
-	o.ListIDs = user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, o.ListIDs)
+	var (
+		user  = auth.GetUser(c)
+		sub   = dummySubscriber
+		subID = 0
+	)
+	if v := c.QueryParam("subscriber_id"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "subscriber_id"))
+		}
+		subID = n
 
-	// If the campaign's 'opt-in', prepare a default message.
-	switch o.Type {
-	case models.CampaignTypeOptin:
-		op, err := a.makeOptinCampaignMessage(o)
+		s, err := a.getPreviewSubscriber(user, subID)
 		if err != nil {
 			return err
 		}
-		o = op
-	case "":
-		o.Type = models.CampaignTypeRegular
+		sub = s
 	}
 
-	if o.Messenger == "" {
-		o.Messenger = "email"
+	camp, body, err := a.renderCampaignPreview(c, id, sub)
+	if err != nil {
+		return err
 	}
 
-	// Validate.
-	if c, err := a.validateCampaignFields(o); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
-	} else {
-		o = c
+	// Record the subscriber's data being viewed in the campaign's audit trail.
+	if subID > 0 {
+		a.recordSubscriberPreview(id, subID, user)
 	}
 
-	if o.ArchiveTemplateID.Valid && o.ArchiveTemplateID.Int != 0 {
-		o.ArchiveTemplateID = o.TemplateID
+	// Plaintext headers for plain body.
+	if camp.ContentType == models.CampaignContentTypePlain {
+		return c.String(http.StatusOK, string(body))
 	}
 
-	out, err := a.core.CreateCampaign(o.Campaign, o.ListIDs, o.MediaIDs)
+	return c.HTML(http.StatusOK, string(body))
+}
+
+// getPreviewSubscriber returns a subscriber that the user has access to for
+// previewing campaigns with the subscriber's data.
+func (a *App) getPreviewSubscriber(user auth.User, subID int) (models.Subscriber, error) {
+	// Check if the user has access to the subscriber.
+	if err := a.hasSubPerm(user, []int{subID}); err != nil {
+		return models.Subscriber{}, err
+	}
+
+	sub, err := a.core.GetSubscriber(subID, "", "")
 	if err != nil {
-		return err
+		return models.Subscriber{}, err
 	}
 
-	return c.JSON(http.StatusOK, okResp{out})
+	// Replace the subscriber's identifiers with dummy ones so that the
+	// unsubscribe and other subscriber specific links in the preview
+	// can't act on the subscriber.
+	sub.UUID = dummySubscriber.UUID
+	sub.UnsubscribeToken = dummySubscriber.UnsubscribeToken
+
+	return sub, nil
 }
 
-// UpdateCampaign handles campaign modification.
-// Campaigns that are done cannot be modified.
-func (a *App) UpdateCampaign(c echo.Context) error {
+// recordSubscriberPreview records a subscriber's data being viewed in a campaign
+// preview in the campaign's audit trail.
+func (a *App) recordSubscriberPreview(campID, subID int, user auth.User) {
+	if err := a.core.RecordCampaignEvent(campID, models.CampaignEventSubscriberPreview,
+		models.JSON{"subscriber_id": subID}, user.ID); err != nil {
+		a.log.Printf("error recording subscriber preview of campaign %d: %v", campID, err)
+	}
+}
+
+// RetryCampaignSendFailures re-queues a campaign's messages to only the subscribers
+// to whom they failed to be sent earlier, eg: on temporary SMTP errors.
+func (a *App) RetryCampaignSendFailures(c echo.Context) error {
 	// Get the campaign ID.
 	id := getID(c)
 
@@ -307,49 +438,30 @@ func (a *App) UpdateCampaign(c echo.Context) error {
 		return err
 	}
 
-	// Retrieve the campaign from the DB.
-	cm, err := a.core.GetCampaign(id, "", "")
+	camp, err := a.core.GetCampaign(id, "", "")
 	if err != nil {
 		return err
 	}
-
-	if !canEditCampaign(cm.Status) {
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdate"))
-	}
-
-	// Clear attribs to avoid merging old and new values as json.Unmarshal in JSON.scan() merges maps,
-	// merging values already in the DB and incoming values. If this is nil, then DB values remain
-	// unchanged.
-	cm.Attribs = nil
-
-	// Read the incoming params into the existing campaign fields from the DB.
-	// This allows updating of values that have been sent whereas fields
-	// that are not in the request retain the old values.
-	o := campReq{Campaign: cm}
-	if err := c.Bind(&o); err != nil {
-		return err
-	}
-
-	// Filter lists against the current user's permitted lists.
-	user := auth.GetUser(c)
-	o.ListIDs = user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, o.ListIDs)
-
-	if c, err := a.validateCampaignFields(o); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
-	} else {
-		o = c
+	if camp.Status == models.CampaignStatusDraft || camp.Status == models.CampaignStatusScheduled {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.notStarted"))
 	}
 
-	out, err := a.core.UpdateCampaign(id, o.Campaign, o.ListIDs, o.MediaIDs)
+	n, err := a.manager.RetrySendFailures(id)
 	if err != nil {
-		return err
+		a.log.Printf("error retrying campaign send failures: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("campaigns.errorRetrying", "error", err.Error()))
 	}
 
-	return c.JSON(http.StatusOK, okResp{out})
+	return c.JSON(http.StatusOK, okResp{struct {
+		Queued int `json:"queued_for_retry"`
+	}{n}})
 }
 
-// UpdateCampaignStatus handles campaign status modification.
-func (a *App) UpdateCampaignStatus(c echo.Context) error {
+// SendCampaignTestCohort sends a campaign to only the first ?limit subscribers (by creation)
+// of one of its lists before it's sent in full, so that its analytics can be reviewed on
+// the test cohort. The subscribers are skipped when the campaign is sent in full.
+func (a *App) SendCampaignTestCohort(c echo.Context) error {
 	// Get the campaign ID.
 	id := getID(c)
 
@@ -358,80 +470,1113 @@ func (a *App) UpdateCampaignStatus(c echo.Context) error {
 		return err
 	}
 
-	req := struct {
-		Status string `json:"status"`
-	}{}
+	var req struct {
+		ListID int `json:"list_id"`
+		Limit  int `json:"limit"`
+	}
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
+	if req.Limit < 1 || req.Limit > testCohortMaxLimit {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "limit"))
+	}
 
-	// Update the campaign status in the DB.
-	out, err := a.core.UpdateCampaignStatus(id, req.Status)
+	// The user should be able to get the list's subscribers.
+	user := auth.GetUser(c)
+	if err := user.HasListPerm(auth.PermTypeGet, req.ListID); err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
 	if err != nil {
 		return err
 	}
+	if !canEditCampaign(camp.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdate"))
+	}
 
-	// If the campaign is being stopped, send the signal to the manager to stop it in flight.
-	if req.Status == models.CampaignStatusPaused || req.Status == models.CampaignStatusCancelled {
-		a.manager.StopCampaign(id)
+	// The list should be one of the campaign's lists.
+	var lists []models.List
+	_ = json.Unmarshal(camp.Lists, &lists)
+	if !slices.ContainsFunc(lists, func(l models.List) bool { return l.ID == req.ListID }) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "list_id"))
 	}
 
-	return c.JSON(http.StatusOK, okResp{out})
+	n, err := a.manager.SendTestCohort(id, req.ListID, req.Limit)
+	if err != nil {
+		a.log.Printf("error sending campaign to test cohort: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("campaigns.errorSendTest", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Queued int `json:"queued"`
+	}{n}})
 }
 
-// UpdateCampaignArchive handles campaign status modification.
-func (a *App) UpdateCampaignArchive(c echo.Context) error {
+// GetCampaignPerformancePrediction returns the predicted open, click, and unsubscribe
+// rates of a campaign based on the ?n most recent comparable campaigns.
+func (a *App) GetCampaignPerformancePrediction(c echo.Context) error {
+	// Get the campaign ID.
 	id := getID(c)
 
 	// Check if the user has access to the campaign.
-	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
 		return err
 	}
 
-	req := struct {
-		Archive     bool        `json:"archive"`
-		TemplateID  int         `json:"archive_template_id"`
-		Meta        models.JSON `json:"archive_meta"`
-		ArchiveSlug string      `json:"archive_slug"`
-	}{}
-	if err := c.Bind(&req); err != nil {
-		return err
+	n := predictionDefaultCampaigns
+	if v := c.QueryParam("n"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 1 || i > predictionMaxCampaigns {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "n"))
+		}
+		n = i
 	}
 
-	if req.ArchiveSlug != "" {
-		// Format the slug to be alpha-numeric-dash.
-		s := strings.ToLower(req.ArchiveSlug)
-		s = strings.TrimSpace(reSlug.ReplaceAllString(s, " "))
-		s = regexpSpaces.ReplaceAllString(s, "-")
-		req.ArchiveSlug = s
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
 	}
 
-	if err := a.core.UpdateCampaignArchive(id, req.Archive, req.TemplateID, req.Meta, req.ArchiveSlug); err != nil {
+	out, err := a.core.PredictCampaignPerformance(camp, n)
+	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, okResp{req})
+	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// DeleteCampaign handles campaign deletion.
-// Only scheduled campaigns that have not started yet can be deleted.
-func (a *App) DeleteCampaign(c echo.Context) error {
+// GetCampaignTemplateDiff renders a campaign's body with its current template and
+// with the latest saved (previous) version of the template, and returns a unified diff
+// of the two, for reviewing the effect of template changes on the campaign.
+func (a *App) GetCampaignTemplateDiff(c echo.Context) error {
 	// Get the campaign ID.
 	id := getID(c)
 
 	// Check if the user has access to the campaign.
-	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
 		return err
 	}
 
-	// Delete the campaign from the DB.
-	if err := a.core.DeleteCampaign(id); err != nil {
+	camp, err := a.core.GetCampaignForPreview(id, 0)
+	if err != nil {
 		return err
 	}
+	if !camp.TemplateID.Valid || camp.ContentType == models.CampaignContentTypeVisual {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.noTemplate"))
+	}
+
+	ver, err := a.core.GetLatestTemplateVersion(camp.TemplateID.Int)
+	if err != nil {
+		return err
+	}
+
+	// Render the campaign with the previous and the current template.
+	prev := camp
+	prev.TemplateBody = ver.Body
+	prevBody, err := a.renderCampaignDummy(&prev)
+	if err != nil {
+		return err
+	}
+
+	curBody, err := a.renderCampaignDummy(&camp)
+	if err != nil {
+		return err
+	}
+
+	diff := makeUnifiedDiff(string(prevBody), string(curBody),
+		fmt.Sprintf("template-version-%d", ver.ID), fmt.Sprintf("template-%d", camp.TemplateID.Int))
+
+	out := struct {
+		TemplateID       int       `json:"template_id"`
+		VersionID        int64     `json:"version_id"`
+		VersionCreatedAt null.Time `json:"version_created_at"`
+		Diff             string    `json:"diff"`
+	}{camp.TemplateID.Int, ver.ID, ver.CreatedAt, diff}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// AccessibilityCheckCampaign renders a campaign and checks its HTML for
+// accessibility issues. Like previews, an unsaved body can be posted to be
+// checked instead of the one in the DB.
+func (a *App) AccessibilityCheckCampaign(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	camp, body, err := a.renderCampaignPreview(c, id, dummySubscriber)
+	if err != nil {
+		return err
+	}
+
+	// Plaintext campaigns have no markup to check.
+	if camp.ContentType == models.CampaignContentTypePlain {
+		return c.JSON(http.StatusOK, okResp{a11y.Result{Score: 100, Issues: []a11y.Issue{}}})
+	}
+
+	out, err := a11y.Check(string(body))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorRendering", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// PreviewCampaignOutlook renders a campaign and converts its HTML to the HTML that
+// Outlook on Windows (Word engine) renders, for previewing. Like previews, an unsaved
+// body can be posted to be converted instead of the one in the DB.
+func (a *App) PreviewCampaignOutlook(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	camp, body, err := a.renderCampaignPreview(c, id, dummySubscriber)
+	if err != nil {
+		return err
+	}
+
+	// Plaintext campaigns have no markup to convert.
+	if camp.ContentType == models.CampaignContentTypePlain {
+		return c.String(http.StatusOK, string(body))
+	}
+
+	out, err := outlook.Transform(string(body))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorRendering", "error", err.Error()))
+	}
+
+	return c.HTML(http.StatusOK, out)
+}
+
+// markdownIssueMsgs maps the types of Markdown issues to their i18n messages.
+var markdownIssueMsgs = map[string]string{
+	models.MarkdownIssueUnclosedFence: "campaigns.markdownUnclosedFence",
+	models.MarkdownIssueEmptyLink:     "campaigns.markdownEmptyLink",
+	models.MarkdownIssueUndefinedRef:  "campaigns.markdownUndefinedRef",
+}
+
+// campValidation is the result of validating a campaign's body.
+type campValidation struct {
+	Valid  bool        `json:"valid"`
+	Issues []campIssue `json:"issues"`
+}
+
+// campIssue is an issue in a campaign's body. Line is 0 for issues that
+// aren't tied to a line, eg: template errors.
+type campIssue struct {
+	Type    string `json:"type"`
+	Line    int    `json:"line"`
+	Text    string `json:"text"`
+	Message string `json:"message"`
+}
+
+// ValidateCampaign validates a campaign's body: its template expressions and, for
+// Markdown campaigns, its Markdown syntax. Like previews, an unsaved body and
+// content type can be posted to be validated instead of the ones in the DB.
+func (a *App) ValidateCampaign(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if typ := c.FormValue("content_type"); typ != "" {
+		camp.ContentType = typ
+		camp.Body = c.FormValue("body")
+	}
+
+	out := campValidation{Issues: []campIssue{}}
+
+	// Validate the body fetched from the body URL, if any.
+	if camp.BodyURL != "" {
+		body, err := manager.FetchRemoteBody(camp.BodyURL)
+		if err != nil {
+			out.Issues = append(out.Issues, campIssue{
+				Type:    "body_url",
+				Message: a.i18n.Ts("campaigns.errorFetchingBody", "error", err.Error()),
+			})
+		}
+		camp.Body = body
+	}
+
+	if camp.ContentType == models.CampaignContentTypeMarkdown {
+		for _, i := range models.ValidateMarkdown(camp.Body) {
+			out.Issues = append(out.Issues, campIssue{
+				Type:    i.Type,
+				Line:    i.Line,
+				Text:    i.Text,
+				Message: a.i18n.Ts(markdownIssueMsgs[i.Type], "line", strconv.Itoa(i.Line)),
+			})
+		}
+	}
+
+	// Check the template expressions in the body.
+	tpl := models.Campaign{Body: camp.Body, ContentType: camp.ContentType, TemplateBody: tplTag}
+	if err := tpl.CompileTemplate(a.manager.TemplateFuncs(&tpl)); err != nil {
+		out.Issues = append(out.Issues, campIssue{
+			Type:    "template",
+			Message: a.i18n.Ts("templates.errorCompiling", "error", err.Error()),
+		})
+	}
+
+	out.Valid = len(out.Issues) == 0
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// PreviewCampaignMarkdown renders a Markdown body to HTML without the campaign's
+// template. Template expressions in the body are left as-is.
+func (a *App) PreviewCampaignMarkdown(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	out, err := models.MarkdownToHTML(c.FormValue("body"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorRendering", "error", err.Error()))
+	}
+
+	return c.HTML(http.StatusOK, out)
+}
+
+// renderCampaignPreview renders a campaign's message body with a dummy subscriber
+// for previewing. If the request is a POST, the campaign's body and content type
+// are replaced with the ones in the request.
+func (a *App) renderCampaignPreview(c echo.Context, id int, sub models.Subscriber) (models.Campaign, []byte, error) {
+	var (
+		isPost      = c.Request().Method == http.MethodPost
+		contentType = c.FormValue("content_type")
+		tplID, _    = strconv.Atoi(c.FormValue("template_id"))
+	)
+	// For visual content, template ID for previewing is irrelevant.
+	if contentType == models.CampaignContentTypeVisual || tplID < 1 {
+		tplID = 0
+	}
+
+	// Get the campaign from the DB for previewing with the `template_body` field.
+	camp, err := a.core.GetCampaignForPreview(id, tplID)
+	if err != nil {
+		return camp, nil, err
+	}
+
+	// There's a body in the request to preview instead of the body in the DB.
+	if isPost {
+		camp.ContentType = contentType
+		camp.Body = c.FormValue("body")
+
+		// For visual campaigns, template body from the DB shouldn't be used.
+		if contentType == models.CampaignContentTypeVisual {
+			camp.TemplateBody = ""
+		}
+	}
+
+	body, err := a.renderCampaignFor(&camp, sub)
+	if err != nil {
+		return camp, nil, err
+	}
+
+	return camp, body, nil
+}
+
+// renderCampaignDummy compiles a campaign's template and renders its message
+// body with a dummy subscriber.
+func (a *App) renderCampaignDummy(camp *models.Campaign) ([]byte, error) {
+	return a.renderCampaignFor(camp, dummySubscriber)
+}
+
+// renderCampaignFor compiles a campaign's template and renders its message
+// body with the given subscriber.
+func (a *App) renderCampaignFor(camp *models.Campaign, sub models.Subscriber) ([]byte, error) {
+	// Use a dummy campaign ID to prevent views and clicks from {{ TrackView }}
+	// and {{ TrackLink }} being registered on preview.
+	camp.UUID = dummySubscriber.UUID
+	if err := a.manager.LoadRemoteBody(camp); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("campaigns.errorFetchingBody", "error", err.Error()))
+	}
+	if err := camp.CompileTemplate(a.manager.TemplateFuncs(camp)); err != nil {
+		a.log.Printf("error compiling template: %v", err)
+		return nil, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorCompiling", "error", err.Error()))
+	}
+
+	// Render the message body.
+	msg, err := a.manager.NewCampaignMessage(camp, sub)
+	if err != nil {
+		a.log.Printf("error rendering message: %v", err)
+		return nil, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorRendering", "error", err.Error()))
+	}
+
+	return msg.Body(), nil
+}
+
+// PreviewCampaignArchive renders the public campaign archives page.
+func (a *App) PreviewCampaignArchive(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	// Fetch the campaign body from the DB.
+	tplID, _ := strconv.Atoi(c.FormValue("template_id"))
+	camp, err := a.core.GetCampaignForPreview(id, tplID)
+	if err != nil {
+		return err
+	}
+
+	camp.ArchiveMeta = json.RawMessage([]byte(c.FormValue("archive_meta")))
+
+	// "Compile" the campaign template with appropriate data.
+	res, err := a.compileArchiveCampaigns([]models.Campaign{camp})
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
+	}
+
+	// Render the campaign body.
+	out := res[0].Campaign
+	msg, err := a.manager.NewCampaignMessage(out, res[0].Subscriber)
+	if err != nil {
+		a.log.Printf("error rendering campaign: %v", err)
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
+	}
+
+	return c.HTML(http.StatusOK, string(msg.Body()))
+}
+
+// CampaignContent handles campaign content (body) format conversions.
+func (a *App) CampaignContent(c echo.Context) error {
+	var camp campContentReq
+	if err := c.Bind(&camp); err != nil {
+		return err
+	}
+
+	// Convert formats, eg: markdown to HTML.
+	out, err := camp.ConvertContent(camp.From, camp.To)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// ConvertCampaign converts a campaign's body to another content type and returns
+// the converted body along with a report of the constructs that won't survive
+// the conversion. The converted body is not saved and is meant to be confirmed
+// by the user before updating the campaign with ?converted=true.
+func (a *App) ConvertCampaign(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	var req campConvertReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	if req.From == "" {
+		req.From = camp.ContentType
+	}
+	if req.Body != "" {
+		camp.Body = req.Body
+	}
+
+	out, err := camp.Convert(req.From, req.To)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// SpellCheckCampaign checks the campaign's subject and body for misspelled words
+// and returns them along with suggested corrections. An optional `body` in the request
+// (eg: unsaved content in the editor) is checked instead of the campaign's saved body.
+func (a *App) SpellCheckCampaign(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	// Lazy load the dictionaries on the first request. This is done outside the
+	// app lock as loading custom dictionaries involves fetching media files.
+	a.spellcheckOnce.Do(func() {
+		a.spellcheck = initSpellChecker(a.fs, a.core, a.media, ko)
+	})
+	sc := a.spellcheck
+
+	lang := c.QueryParam("lang")
+	if lang == "" {
+		lang = "en"
+	}
+	if !sc.HasLang(lang) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "lang"))
+	}
+
+	body := camp.Body
+	if b := c.FormValue("body"); b != "" {
+		body = b
+	}
+
+	out := sc.Check(lang, template.HTMLEscapeString(camp.Subject)+"\n"+body)
+	return c.JSON(http.StatusOK, okResp{struct {
+		Lang  string              `json:"lang"`
+		Words []spellcheck.Result `json:"words"`
+	}{lang, out}})
+}
+
+// CreateCampaign handles campaign creation.
+// Newly created campaigns are always drafts.
+func (a *App) CreateCampaign(c echo.Context) error {
+	var o campReq
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	// Filter lists against the current user's permitted lists.
+	user := auth.GetUser(c)
+	o.ListIDs = user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, o.ListIDs)
+
+	// If the campaign's 'opt-in', prepare a default message.
+	switch o.Type {
+	case models.CampaignTypeOptin:
+		op, err := a.makeOptinCampaignMessage(o)
+		if err != nil {
+			return err
+		}
+		o = op
+	case "":
+		o.Type = models.CampaignTypeRegular
+	}
+
+	if o.Messenger == "" {
+		o.Messenger = "email"
+	}
+
+	// Validate.
+	if c, err := a.validateCampaignFields(o); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else {
+		o = c
+	}
+
+	// Check the campaign's segment and its param values.
+	if err := a.validateCampaignSegment(&o.Campaign, user); err != nil {
+		return err
+	}
+
+	if o.ArchiveTemplateID.Valid && o.ArchiveTemplateID.Int != 0 {
+		o.ArchiveTemplateID = o.TemplateID
+	}
+
+	meta := a.minifyCampaignBody(o.ContentType, &o.Body)
+
+	out, err := a.core.CreateCampaign(o.Campaign, o.ListIDs, o.MediaIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		models.Campaign
+		Meta *campBodyMeta `json:"meta,omitempty"`
+	}{out, meta}})
+}
+
+// UpdateCampaign handles campaign modification.
+// Campaigns that are done cannot be modified.
+func (a *App) UpdateCampaign(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	// Retrieve the campaign from the DB.
+	cm, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	if !canEditCampaign(cm.Status) {
+		// The content of a running campaign can only be changed when it's explicitly
+		// asked to be applied to the rest of its messages right away.
+		if cm.Status == models.CampaignStatusRunning && c.QueryParam("apply") == "immediately" {
+			return a.swapCampaignContent(cm, c)
+		}
+
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdate"))
+	}
+	contentType := cm.ContentType
+
+	// Clear attribs to avoid merging old and new values as json.Unmarshal in JSON.scan() merges maps,
+	// merging values already in the DB and incoming values. If this is nil, then DB values remain
+	// unchanged.
+	cm.Attribs = nil
+
+	// Likewise, the segment's param values are replaced and not merged.
+	cm.SegmentParams = nil
+
+	// updated_at (from when the campaign was read) has to be in the request
+	// and not be the one read above.
+	cm.UpdatedAt = null.Time{}
+
+	// Read the incoming params into the existing campaign fields from the DB.
+	// This allows updating of values that have been sent whereas fields
+	// that are not in the request retain the old values.
+	o := campReq{Campaign: cm}
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	// Changing the content type without converting the body (eg: via /convert) may
+	// silently lose content. Require the caller to acknowledge the conversion.
+	if o.ContentType != contentType && c.QueryParam("converted") != "true" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.contentTypeNotConverted"))
+	}
+
+	// Filter lists against the current user's permitted lists.
+	user := auth.GetUser(c)
+	o.ListIDs = user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, o.ListIDs)
+
+	if c, err := a.validateCampaignFields(o); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else {
+		o = c
+	}
+
+	// Check the campaign's segment and its param values.
+	if err := a.validateCampaignSegment(&o.Campaign, user); err != nil {
+		return err
+	}
+
+	meta := a.minifyCampaignBody(o.ContentType, &o.Body)
+
+	// The update is rejected if the campaign has been modified since updated_at.
+	out, err := a.core.UpdateCampaign(id, o.Campaign, o.ListIDs, o.MediaIDs)
+	if err == core.ErrConflict {
+		// Return the current campaign so that the changes can be merged.
+		cur, err := a.core.GetCampaign(id, "", "")
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusConflict, conflictResp{
+			Message: a.i18n.Ts("globals.messages.conflict", "name", cur.Name),
+			Data:    cur,
+		})
+	} else if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		models.Campaign
+		Meta *campBodyMeta `json:"meta,omitempty"`
+	}{out, meta}})
+}
+
+// swapCampaignContent replaces the content of a running campaign with a new revision
+// that the manager picks up from the next batch of messages. Only the body, altbody,
+// and body_source are changed and the other fields in the request are ignored.
+func (a *App) swapCampaignContent(cm models.Campaign, c echo.Context) error {
+	req := struct {
+		Body       string      `json:"body"`
+		AltBody    null.String `json:"altbody"`
+		BodySource null.String `json:"body_source"`
+
+		// The content revision that the change is based on.
+		ContentRevision null.Int `json:"content_revision"`
+	}{Body: cm.Body, AltBody: cm.AltBody, BodySource: cm.BodySource}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if cm.ContentType != models.CampaignContentTypeVisual {
+		req.BodySource.Valid = false
+	}
+
+	// Check the template expressions in the new content.
+	camp := models.Campaign{Body: req.Body, AltBody: req.AltBody, ContentType: cm.ContentType, TemplateBody: tplTag}
+	if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("campaigns.fieldInvalidBody", "error", err.Error()))
+	}
+
+	a.minifyCampaignBody(cm.ContentType, &req.Body)
+
+	user := auth.GetUser(c)
+	if _, err := a.core.SwapCampaignContent(cm.ID, req.Body, req.AltBody, req.BodySource, req.ContentRevision, user.ID); err == core.ErrConflict {
+		// The content has been changed since, or the campaign is no longer running.
+		cur, err := a.core.GetCampaign(cm.ID, "", "")
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusConflict, conflictResp{
+			Message: a.i18n.Ts("globals.messages.conflict", "name", cur.Name),
+			Data:    cur,
+		})
+	} else if err != nil {
+		return err
+	}
+
+	out, err := a.core.GetCampaign(cm.ID, "", "")
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignRevisions returns the content revisions of a campaign, including the changes
+// made while it was running, along with the views and clicks of each revision.
+func (a *App) GetCampaignRevisions(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	out, err := a.core.GetCampaignRevisions(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// TouchCampaign records that the current user is editing a campaign and returns the other
+// users who are editing it. Editors should touch the campaign periodically while it's open
+// and DELETE it once they're done.
+func (a *App) TouchCampaign(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	user := auth.GetUser(c)
+	if c.Request().Method == http.MethodDelete {
+		a.presence.Release(campPresenceKey(id), user.ID)
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	a.presence.Touch(campPresenceKey(id), user.ID, user.Username)
+	return c.JSON(http.StatusOK, okResp{a.presence.Get(campPresenceKey(id), user.ID)})
+}
+
+// campPresenceKey returns the key of a campaign in the editor presence tracker.
+func campPresenceKey(id int) string {
+	return "campaign:" + strconv.Itoa(id)
+}
+
+// UpdateCampaignStatus handles campaign status modification.
+func (a *App) UpdateCampaignStatus(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	req := struct {
+		Status    string `json:"status"`
+		Token     string `json:"confirmation_token"`
+		Checklist bool   `json:"checklist"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	// The approval of the campaign's segment may have been revoked since the campaign was saved.
+	if req.Status == models.CampaignStatusRunning || req.Status == models.CampaignStatusScheduled {
+		if err := a.checkCampaignSegmentUse(id, auth.GetUser(c)); err != nil {
+			return err
+		}
+
+		// Optionally, don't start the campaign if any of the pre-send checks fail.
+		if req.Checklist {
+			if err := a.checkCampaignChecklist(id); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Check the send frequency limits of the campaign's lists.
+	warning, err := a.checkListSendLimits(id, req.Status)
+	if err != nil {
+		return err
+	}
+
+	// If start confirmation is enabled, the first request only returns a token and a
+	// snapshot of the campaign. The campaign is started by a second request with the token.
+	if user := auth.GetUser(c); a.needsStartConfirmation(req.Status, user) {
+		if req.Token == "" {
+			out, err := a.makeCampaignStartConfirmation(id, user)
+			if err != nil {
+				return err
+			}
+
+			return c.JSON(http.StatusAccepted, okResp{out})
+		}
+
+		if err := a.consumeCampaignStartToken(id, req.Token, user); err != nil {
+			return err
+		}
+	}
+
+	// If the campaign has an approval gate, it has to approve the campaign first.
+	held, gateMsg, err := a.checkCampaignGate(id, req.Status)
+	if err != nil {
+		return err
+	}
+	if gateMsg != "" {
+		warning = gateMsg
+	}
+
+	// The gate hasn't approved the campaign. It stays as-is and the gate is retried
+	// in the background.
+	if held {
+		out, err := a.core.GetCampaign(id, "", "")
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, okResp{struct {
+			models.Campaign
+			Warning string `json:"warning,omitempty"`
+		}{out, warning}})
+	}
+
+	// Update the campaign status in the DB.
+	out, err := a.core.UpdateCampaignStatus(id, req.Status)
+	if err != nil {
+		return err
+	}
+
+	// If the campaign is being stopped, send the signal to the manager to stop it in flight.
+	if req.Status == models.CampaignStatusPaused || req.Status == models.CampaignStatusCancelled {
+		a.manager.StopCampaign(id)
+	}
+
+	// If the maximum number of campaigns are already running, the campaign is queued
+	// as scheduled and started when the campaign manager has a free slot.
+	if out.Status == models.CampaignStatusRunning && a.cfg.MaxConcurrentCampaigns > 0 {
+		retryAt := time.Now().Add(a.cfg.CampaignQueueDelay)
+		queued, err := a.core.QueueCampaign(id, a.cfg.MaxConcurrentCampaigns, retryAt)
+		if err != nil {
+			return err
+		}
+		if queued {
+			out.Status = models.CampaignStatusScheduled
+			out.SendAt = null.TimeFrom(retryAt)
+		}
+	}
+
+	// Position of the campaign in the queue of scheduled campaigns.
+	var queuePos int
+	if out.Status == models.CampaignStatusScheduled {
+		if queuePos, err = a.core.GetCampaignQueuePosition(id); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		models.Campaign
+		QueuePosition int    `json:"queue_position,omitempty"`
+		Warning       string `json:"warning,omitempty"`
+	}{out, queuePos, warning}})
+}
+
+// CancelCampaignAndReport cancels a running or paused campaign and returns the
+// breakdown of its subscribers who were sent it, skipped, or are yet to be sent it,
+// eg: for re-sending the campaign to the remaining subscribers.
+func (a *App) CancelCampaignAndReport(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	if _, err := a.core.UpdateCampaignStatus(id, models.CampaignStatusCancelled); err != nil {
+		return err
+	}
+
+	// Stop the campaign in flight and record the messages sent so far before reporting.
+	a.manager.StopCampaign(id)
+	a.manager.FlushSendLog()
+
+	out, err := a.core.GetCampaignSendReport(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateCampaignsStatus handles status modification of multiple campaigns
+// and returns the result of every campaign.
+func (a *App) UpdateCampaignsStatus(c echo.Context) error {
+	var req struct {
+		IDs    []int  `json:"ids"`
+		Status string `json:"status"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if len(req.IDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.missingFields", "name", "`ids`"))
+	}
+
+	// Campaigns that need a start confirmation have to be started individually.
+	if a.needsStartConfirmation(req.Status, auth.GetUser(c)) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.startConfirmBatch"))
+	}
+
+	// Campaigns that the user doesn't have access to are reported as failures.
+	var (
+		ids    = make([]int, 0, len(req.IDs))
+		denied = make(map[int]string)
+	)
+	warnings := make(map[int]string)
+	for _, id := range req.IDs {
+		err := a.checkCampaignPerm(auth.PermTypeManage, id, c)
+		if err == nil {
+			warnings[id], err = a.checkListSendLimits(id, req.Status)
+		}
+
+		// Campaigns that are held by their approval gates are reported as failures.
+		if err == nil {
+			var (
+				held bool
+				msg  string
+			)
+			held, msg, err = a.checkCampaignGate(id, req.Status)
+			if err == nil && held {
+				err = errors.New(msg)
+			} else if msg != "" {
+				warnings[id] = msg
+			}
+		}
+		if err != nil {
+			if e, ok := err.(*echo.HTTPError); ok {
+				denied[id] = fmt.Sprintf("%v", e.Message)
+			} else {
+				denied[id] = err.Error()
+			}
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	res := []models.CampaignStatusResult{}
+	if len(ids) > 0 {
+		r, err := a.core.UpdateCampaignsStatus(ids, req.Status)
+		if err != nil {
+			return err
+		}
+		res = r
+	}
+
+	// Merge the results in the order of the request.
+	var (
+		out     = make([]models.CampaignStatusResult, 0, len(req.IDs))
+		results = make(map[int]models.CampaignStatusResult, len(res))
+	)
+	for _, r := range res {
+		results[r.ID] = r
+	}
+	for _, id := range req.IDs {
+		if msg, ok := denied[id]; ok {
+			out = append(out, models.CampaignStatusResult{ID: id, Error: msg})
+			continue
+		}
+
+		r := results[id]
+		if r.Success {
+			r.Warning = warnings[id]
+		}
+		out = append(out, r)
+
+		// If the campaign is being stopped, send the signal to the manager to stop it in flight.
+		if r.Success && (req.Status == models.CampaignStatusPaused || req.Status == models.CampaignStatusCancelled) {
+			a.manager.StopCampaign(id)
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateCampaignArchive handles campaign status modification.
+func (a *App) UpdateCampaignArchive(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	req := struct {
+		Archive      bool        `json:"archive"`
+		TemplateID   int         `json:"archive_template_id"`
+		Meta         models.JSON `json:"archive_meta"`
+		ArchiveSlug  string      `json:"archive_slug"`
+		CoverMediaID null.Int    `json:"archive_cover_media_id"`
+		AccentColor  null.String `json:"archive_accent_color"`
+		Excerpt      null.String `json:"archive_excerpt"`
+		Password     null.String `json:"archive_password"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if req.Password.Valid && len(req.Password.String) > stdInputMaxLen {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "archive_password"))
+	}
+
+	if err := a.validateArchiveMeta(&req.CoverMediaID, &req.AccentColor, &req.Excerpt); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if req.ArchiveSlug != "" {
+		// Format the slug to be alpha-numeric-dash.
+		s := strings.ToLower(req.ArchiveSlug)
+		s = strings.TrimSpace(reSlug.ReplaceAllString(s, " "))
+		s = regexpSpaces.ReplaceAllString(s, "-")
+		req.ArchiveSlug = s
+	}
+
+	if err := a.core.UpdateCampaignArchive(id, req.Archive, req.TemplateID, req.Meta, req.ArchiveSlug,
+		req.CoverMediaID, req.AccentColor, req.Excerpt, req.Password); err != nil {
+		return err
+	}
+
+	// Never echo the password back.
+	req.Password = null.String{}
+
+	return c.JSON(http.StatusOK, okResp{req})
+}
+
+// UpdateCampaignSurvey handles setting the questions of a campaign's survey.
+func (a *App) UpdateCampaignSurvey(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	var req struct {
+		Questions models.SurveyQuestions `json:"questions"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := req.Questions.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("campaigns.invalidSurvey", "error", err.Error()))
+	}
+	if req.Questions == nil {
+		req.Questions = models.SurveyQuestions{}
+	}
+
+	if err := a.core.UpdateCampaignSurvey(id, req.Questions); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{req.Questions})
+}
+
+// GetCampaignSurveyResults returns the aggregated responses to a campaign's survey.
+func (a *App) GetCampaignSurveyResults(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	out, err := a.core.GetSurveyResults(id, camp.Survey)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteCampaign handles campaign deletion.
+// Only scheduled campaigns that have not started yet can be deleted.
+func (a *App) DeleteCampaign(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	// Delete the campaign from the DB.
+	if err := a.core.DeleteCampaign(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
 
-	return c.JSON(http.StatusOK, okResp{true})
-}
-
 // DeleteCampaigns deletes multiple campaigns by IDs or by query.
 func (a *App) DeleteCampaigns(c echo.Context) error {
 	// Get the authenticated user.
@@ -496,6 +1641,9 @@ func (a *App) GetRunningCampaignStats(c echo.Context) error {
 
 	// Compute rate.
 	for i, c := range out {
+		st := a.manager.GetCampaignStats(c.ID)
+		out[i].RenderStats = st.RenderStats
+
 		if c.Started.Valid && c.UpdatedAt.Valid {
 			diff := max(int(c.UpdatedAt.Time.Sub(c.Started.Time).Minutes()), 1)
 
@@ -508,13 +1656,44 @@ func (a *App) GetRunningCampaignStats(c echo.Context) error {
 			out[i].NetRate = rate
 
 			// Realtime running rate over the last minute.
-			out[i].Rate = a.manager.GetCampaignStats(c.ID).SendRate
+			out[i].Rate = st.SendRate
+		}
+
+		if !st.SpreadEnd.IsZero() {
+			out[i].SpreadEnd = null.TimeFrom(st.SpreadEnd)
 		}
+		out[i].ETA = projectCampaignETA(out[i], st.SpreadEnd)
 	}
 
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// projectCampaignETA returns the projected completion time of a running campaign.
+// A spread out campaign completes at the end of its window as its send rate is
+// throttled to the window. Once the window has passed, or for campaigns that
+// aren't spread out, the remaining messages are projected at the current send rate.
+func projectCampaignETA(st models.CampaignStats, spreadEnd time.Time) null.Time {
+	remaining := st.ToSend - st.Sent
+	if remaining <= 0 {
+		return null.Time{}
+	}
+
+	now := time.Now()
+	if spreadEnd.After(now) {
+		return null.TimeFrom(spreadEnd)
+	}
+
+	rate := st.Rate
+	if rate < 1 {
+		rate = st.NetRate
+	}
+	if rate < 1 {
+		return null.Time{}
+	}
+
+	return null.TimeFrom(now.Add(time.Duration(float64(remaining) / float64(rate) * float64(time.Minute))))
+}
+
 // TestCampaign handles the sending of a campaign message to
 // arbitrary subscribers for testing.
 func (a *App) TestCampaign(c echo.Context) error {
@@ -591,61 +1770,226 @@ func (a *App) TestCampaign(c echo.Context) error {
 		}
 	}
 
-	// Send the test messages.
-	for _, s := range subs {
-		sub := s
+	// Send the test messages.
+	out := make([]campTestMessage, 0, len(subs))
+	for _, s := range subs {
+		sub := s
+
+		msg, src, err := a.sendTestMessage(sub, &camp, req.ReturnSource)
+		if err != nil {
+			a.log.Printf("error sending test message: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				a.i18n.Ts("campaigns.errorSendTest", "error", err.Error()))
+		}
+
+		if req.ReturnSource {
+			out = append(out, a.makeCampTestMessage(msg, src))
+		}
+	}
+
+	if !req.ReturnSource {
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// makeCampTestMessage returns a sent test message with the
+// differences from a real campaign message.
+func (a *App) makeCampTestMessage(msg models.Message, src []byte) campTestMessage {
+	out := campTestMessage{
+		To:        strings.Join(msg.To, ", "),
+		Messenger: msg.Campaign.Messenger,
+		Headers:   msg.Headers,
+		Source:    string(src),
+		Differences: []string{
+			a.i18n.Ts("campaigns.testDiffRecipient", "email", strings.Join(msg.To, ", ")),
+		},
+	}
+
+	if src == nil {
+		out.Differences = append(out.Differences, a.i18n.Ts("campaigns.testNoSource", "name", msg.Campaign.Messenger))
+	} else {
+		out.Differences = append(out.Differences, a.i18n.T("campaigns.testDiffMIME"), a.i18n.T("campaigns.testDiffDKIM"))
+	}
+
+	return out
+}
+
+// GetCampaignViewAnalytics retrieves view counts for a campaign.
+func (a *App) GetCampaignViewAnalytics(c echo.Context) error {
+	ids, err := parseStringIDs(c.Request().URL.Query()["id"])
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.errorInvalidIDs", "error", err.Error()))
+	}
+
+	if len(ids) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.missingFields", "name", "`id`"))
+	}
+
+	// Ensure the user has access to campaigns via lists.
+	for _, id := range ids {
+		if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+			return err
+		}
+	}
+
+	var (
+		typ  = c.Param("type")
+		from = c.QueryParams().Get("from")
+		to   = c.QueryParams().Get("to")
+	)
+	if !strHasLen(from, 10, 30) || !strHasLen(to, 10, 30) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
+	}
+
+	// Breakdown of the campaigns' bounces by reason.
+	if typ == "bounce_reasons" {
+		out, err := a.core.GetBounceReasonCounts(ids, "", from, to)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	// Breakdown of the campaigns' bounces by type.
+	if typ == "bounce_types" {
+		out, err := a.core.GetCampaignAnalyticsBounceTypes(ids, from, to)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	// Campaign link stats.
+	if typ == "links" {
+		out, err := a.core.GetCampaignAnalyticsLinks(ids, typ, from, to)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	// Get the analytics numbers from the DB for the campaigns.
+	out, err := a.core.GetCampaignAnalyticsCounts(ids, typ, from, to)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// ExportCampaignSubscriberAnalytics streams a CSV (?format=csv) of the engagement
+// (opens, clicks, bounces, unsubscriptions) of each subscriber a campaign was sent to.
+func (a *App) ExportCampaignSubscriberAnalytics(c echo.Context) error {
+	id := getID(c)
 
-		if err := a.sendTestMessage(sub, &camp); err != nil {
-			a.log.Printf("error sending test message: %v", err)
-			return echo.NewHTTPError(http.StatusInternalServerError,
-				a.i18n.Ts("campaigns.errorSendTest", "error", err.Error()))
-		}
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
 	}
 
-	return c.JSON(http.StatusOK, okResp{true})
-}
+	if f := c.QueryParam("format"); f != "" && f != "csv" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "format"))
+	}
 
-// GetCampaignViewAnalytics retrieves view counts for a campaign.
-func (a *App) GetCampaignViewAnalytics(c echo.Context) error {
-	ids, err := parseStringIDs(c.Request().URL.Query()["id"])
+	camp, err := a.core.GetCampaign(id, "", "")
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest,
-			a.i18n.Ts("globals.messages.errorInvalidIDs", "error", err.Error()))
+		return err
 	}
 
-	if len(ids) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest,
-			a.i18n.Ts("globals.messages.missingFields", "name", "`id`"))
+	// Exports of large campaigns are expensive. Rate limit them per user. If the
+	// bucket can't be checked, the export is allowed.
+	user := auth.GetUser(c)
+	ok, retry, err := a.core.TakeRateLimitToken("analytics-export:"+strconv.Itoa(user.ID), analyticsExportRateLimit, analyticsExportRateLimitWindow)
+	if err == nil && !ok {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())))
+		return echo.NewHTTPError(http.StatusTooManyRequests, a.i18n.T("campaigns.tooManyExports"))
 	}
 
-	// Ensure the user has access to campaigns via lists.
-	for _, id := range ids {
-		if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+	var (
+		hdr = c.Response().Header()
+		wr  = csv.NewWriter(c.Response())
+	)
+	hdr.Set(echo.HeaderContentType, "text/csv")
+	hdr.Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="campaign-%d-analytics.csv"`, camp.ID))
+	hdr.Set("Cache-Control", "no-cache")
+
+	fmtTime := func(t null.Time) string {
+		if !t.Valid {
+			return ""
+		}
+		return t.Time.Format(time.RFC3339)
+	}
+
+	wr.Write([]string{"email", "sent_at", "opened_at", "first_clicked_at", "bounced", "unsubscribed"})
+
+	n := 0
+	err = a.core.ExportCampaignSubscriberAnalytics(c.Request().Context(), id, func(r models.CampaignSubscriberAnalytics) error {
+		if err := wr.Write([]string{
+			r.Email, fmtTime(r.SentAt), fmtTime(r.OpenedAt), fmtTime(r.FirstClickedAt),
+			strconv.FormatBool(r.Bounced), strconv.FormatBool(r.Unsubscribed),
+		}); err != nil {
+			return err
+		}
+
+		// Flush every batch of rows to stream them to the client.
+		n++
+		if n%a.cfg.DBBatchSize == 0 {
+			wr.Flush()
+			return wr.Error()
+		}
+		return nil
+	})
+	if err != nil {
+		// Once the response has been started, the error can't be sent.
+		if !c.Response().Committed {
 			return err
 		}
+		a.log.Printf("error streaming campaign analytics CSV: %v", err)
+		return nil
+	}
+	wr.Flush()
+
+	return nil
+}
+
+// GetCampaignActivity handles retrieval of a campaign's views, clicks, bounces, or
+// unsubscriptions (:type) with cursor based pagination (?cursor=&per_page=).
+func (a *App) GetCampaignActivity(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
 	}
 
 	var (
-		typ  = c.Param("type")
-		from = c.QueryParams().Get("from")
-		to   = c.QueryParams().Get("to")
+		cursor  int64
+		perPage = activityDefaultPerPage
 	)
-	if !strHasLen(from, 10, 30) || !strHasLen(to, 10, 30) {
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
+	if v := c.QueryParam("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "cursor"))
+		}
+		cursor = n
 	}
 
-	// Campaign link stats.
-	if typ == "links" {
-		out, err := a.core.GetCampaignAnalyticsLinks(ids, typ, from, to)
-		if err != nil {
-			return err
+	if v := c.QueryParam("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > activityMaxPerPage {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "per_page"))
 		}
-
-		return c.JSON(http.StatusOK, okResp{out})
+		perPage = n
 	}
 
-	// Get the analytics numbers from the DB for the campaigns.
-	out, err := a.core.GetCampaignAnalyticsCounts(ids, typ, from, to)
+	out, err := a.core.GetCampaignActivity(id, c.Param("type"), cursor, perPage)
 	if err != nil {
 		return err
 	}
@@ -653,27 +1997,34 @@ func (a *App) GetCampaignViewAnalytics(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// sendTestMessage takes a campaign and a subscriber and sends out a sample campaign message.
-func (a *App) sendTestMessage(sub models.Subscriber, camp *models.Campaign) error {
+// sendTestMessage takes a campaign and a subscriber and sends out a sample campaign message
+// constructed exactly like a real campaign message. If withSource is true, the raw message
+// source is returned if the messenger supports it.
+func (a *App) sendTestMessage(sub models.Subscriber, camp *models.Campaign, withSource bool) (models.Message, []byte, error) {
+	if err := a.manager.LoadRemoteBody(camp); err != nil {
+		return models.Message{}, nil, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("campaigns.errorFetchingBody", "error", err.Error()))
+	}
+
 	if err := a.manager.LoadInlineImages(camp); err != nil {
 		a.log.Printf("error loading inline images: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return models.Message{}, nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
 	if err := camp.CompileTemplate(a.manager.TemplateFuncs(camp)); err != nil {
 		a.log.Printf("error compiling template: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError,
+		return models.Message{}, nil, echo.NewHTTPError(http.StatusInternalServerError,
 			a.i18n.Ts("templates.errorCompiling", "error", err.Error()))
 	}
 
 	// Create a sample campaign message.
-	msg, err := a.manager.NewCampaignMessage(camp, sub)
+	msg, err := a.manager.NewTestCampaignMessage(camp, sub)
 	if err != nil {
 		a.log.Printf("error rendering message: %v", err)
-		return echo.NewHTTPError(http.StatusNotFound, a.i18n.Ts("templates.errorRendering", "error", err.Error()))
+		return models.Message{}, nil, echo.NewHTTPError(http.StatusNotFound, a.i18n.Ts("templates.errorRendering", "error", err.Error()))
 	}
 
-	return a.manager.PushCampaignMessage(msg)
+	return a.manager.SendCampaignMessage(msg, withSource)
 }
 
 // validateCampaignFields validates incoming campaign field values.
@@ -719,6 +2070,82 @@ func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 		return c, errors.New(a.i18n.T("campaigns.fieldInvalidListIDs"))
 	}
 
+	c.JournalAddress = strings.TrimSpace(c.JournalAddress)
+	if c.JournalAddress != "" && !utils.ValidateEmail(c.JournalAddress) {
+		return c, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("campaigns.journalAddress")))
+	}
+
+	// The approval gate should be one of the allowlisted gates.
+	c.GateURL = strings.TrimSpace(c.GateURL)
+	if c.GateURL != "" && !a.campGate.Allowed(c.GateURL) {
+		return c, errors.New(a.i18n.T("campaigns.fieldInvalidGateURL"))
+	}
+
+	// The body is fetched from the body URL, if any, only over HTTPS.
+	c.BodyURL = strings.TrimSpace(c.BodyURL)
+	if c.BodyURL != "" {
+		u, err := url.Parse(c.BodyURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return c, errors.New(a.i18n.T("campaigns.fieldInvalidBodyURL"))
+		}
+	}
+
+	// An empty tracking domain uses the root URL.
+	if c.TrackingDomain.Valid {
+		d := strings.ToLower(strings.TrimSpace(c.TrackingDomain.String))
+		if d == "" {
+			c.TrackingDomain = null.String{}
+		} else {
+			if err := a.checkTrackingDomain(d); err != nil {
+				return c, err
+			}
+			c.TrackingDomain = null.StringFrom(d)
+		}
+	}
+
+	// A campaign's UTM template overrides the global default.
+	if c.UTMTemplate != nil {
+		if _, err := c.UTMTemplate.Compile(a.manager.TemplateFuncs(&c.Campaign)); err != nil {
+			return c, errors.New(a.i18n.Ts("campaigns.invalidUTMTemplate", "error", err.Error()))
+		}
+	}
+
+	// The send spread window can be at most a week.
+	if c.SendSpread < 0 || c.SendSpread > maxSendSpread {
+		return c, errors.New(a.i18n.T("campaigns.fieldInvalidSendSpread"))
+	}
+	switch c.SendSpreadCurve {
+	case models.CampaignSpreadUniform, models.CampaignSpreadRampUp, models.CampaignSpreadRampDown:
+	case "":
+		c.SendSpreadCurve = models.CampaignSpreadUniform
+	default:
+		return c, errors.New(a.i18n.T("campaigns.fieldInvalidSendSpread"))
+	}
+
+	// Delivery at the local time (HH:MM) of subscribers needs a send_at date, which
+	// is the time before which no message is sent, and can't be spread out.
+	c.SendAtLocalTime = strings.TrimSpace(c.SendAtLocalTime)
+	if c.SendAtLocalTime != "" {
+		if _, err := time.Parse("15:04", c.SendAtLocalTime); err != nil || len(c.SendAtLocalTime) != 5 {
+			return c, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "send_at_local_time"))
+		}
+		if !c.SendAt.Valid || c.SendSpread > 0 {
+			return c, errors.New(a.i18n.T("campaigns.fieldInvalidSendAtLocalTime"))
+		}
+	}
+
+	// Flag references to subscriber attributes that aren't available to templates.
+	if keys := a.manager.ExcludedAttribs(c.Subject, c.Body, c.AltBody.String); len(keys) > 0 {
+		return c, errors.New(a.i18n.Ts("templates.excludedAttribs", "keys", strings.Join(keys, ", ")))
+	}
+
+	// A list can't be both targeted and excluded.
+	for _, id := range c.ExcludeListIDs {
+		if slices.Contains(c.ListIDs, int(id)) {
+			return c, errors.New(a.i18n.T("campaigns.fieldInvalidExcludeLists"))
+		}
+	}
+
 	if !a.manager.HasMessenger(c.Messenger) {
 		// If it's a specific SMTP, but it's no longer available (removed/disabled), fall back to general email messenger.
 		if strings.HasPrefix(c.Messenger, "email-") {
@@ -760,9 +2187,76 @@ func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 		c.ArchiveSlug.Valid = false
 	}
 
+	if err := a.validateArchiveMeta(&c.ArchiveCoverMediaID, &c.ArchiveAccentColor, &c.ArchiveExcerpt); err != nil {
+		return c, err
+	}
+
 	return c, nil
 }
 
+// checkTrackingDomain checks that a campaign's tracking domain (host[:port]) points
+// to listmonk by requesting the health endpoint on it.
+func (a *App) checkTrackingDomain(domain string) error {
+	h, err := url.Parse("//" + domain)
+	if err != nil || h.Host != domain || h.Hostname() == "" || h.Path != "" || h.User != nil {
+		return errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("campaigns.trackingDomain")))
+	}
+
+	u, err := url.Parse(a.urlCfg.RootURL)
+	if err != nil {
+		return err
+	}
+	u.Host = domain
+
+	// Redirects aren't followed as tracking URLs on the domain have to be served directly.
+	client := &http.Client{
+		Timeout: trackingDomainTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(u.String() + "/health")
+	if err != nil {
+		return errors.New(a.i18n.Ts("campaigns.trackingDomainUnreachable", "name", domain, "error", err.Error()))
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Data bool `json:"data"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(io.LimitReader(resp.Body, 1024)).Decode(&out) != nil || !out.Data {
+		return errors.New(a.i18n.Ts("campaigns.trackingDomainUnreachable", "name", domain, "error", resp.Status))
+	}
+
+	return nil
+}
+
+// validateArchiveMeta validates a campaign's archive cover media, accent color,
+// and excerpt. Empty values are set to NULL.
+func (a *App) validateArchiveMeta(coverID *null.Int, color, excerpt *null.String) error {
+	if coverID.Int < 1 {
+		coverID.Valid = false
+	} else if _, err := a.core.GetMedia(int(coverID.Int), "", "", a.media); err != nil {
+		return errors.New(a.i18n.Ts("campaigns.fieldInvalidArchiveCover"))
+	}
+
+	color.String = strings.TrimSpace(color.String)
+	if color.String == "" {
+		color.Valid = false
+	} else if !reHexColor.MatchString(color.String) {
+		return errors.New(a.i18n.Ts("campaigns.fieldInvalidAccentColor"))
+	}
+
+	excerpt.String = strings.TrimSpace(excerpt.String)
+	if excerpt.String == "" {
+		excerpt.Valid = false
+	} else if !strHasLen(excerpt.String, 1, archiveExcerptMaxLen) {
+		return errors.New(a.i18n.Ts("campaigns.fieldInvalidExcerpt"))
+	}
+
+	return nil
+}
+
 // makeOptinCampaignMessage makes a default opt-in campaign message body.
 func (a *App) makeOptinCampaignMessage(o campReq) (campReq, error) {
 	if len(o.ListIDs) == 0 {
@@ -849,3 +2343,300 @@ func canEditCampaign(status string) bool {
 		status == models.CampaignStatusPaused ||
 		status == models.CampaignStatusScheduled
 }
+
+// needsStartConfirmation checks whether changing a campaign's status to the given
+// status requires a confirmation. API users with the start_immediate permission bypass it.
+func (a *App) needsStartConfirmation(status string, user auth.User) bool {
+	if !a.cfg.ConfirmCampaignStart || status != models.CampaignStatusRunning {
+		return false
+	}
+
+	return user.Type != auth.UserTypeAPI || !user.HasPerm(auth.PermCampaignsStartImmediate)
+}
+
+// checkCampaignGate checks a campaign that's being started or scheduled against its
+// external approval gate, if it has one that hasn't approved it yet. If the gate doesn't
+// approve the campaign, it's marked as pending approval and the gate is retried in the
+// background. held indicates that the campaign should not be started. Scheduled campaigns
+// are not held as the scheduler doesn't pick them up until they're approved.
+func (a *App) checkCampaignGate(id int, status string) (bool, string, error) {
+	if status != models.CampaignStatusRunning && status != models.CampaignStatusScheduled {
+		return false, "", nil
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return false, "", err
+	}
+	if camp.GateURL == "" || camp.GateStatus == models.CampaignGateApproved || camp.GateStatus == models.CampaignGateOverridden {
+		return false, "", nil
+	}
+
+	ok, reason := runCampaignGate(a.campGate, a.core, camp)
+	if ok {
+		return false, "", nil
+	}
+
+	msg := a.i18n.Ts("campaigns.gatePending", "reason", reason)
+	return status == models.CampaignStatusRunning && !camp.SendAt.Valid, msg, nil
+}
+
+// runCampaignGate checks a campaign against its approval gate and records the
+// result on the campaign. It returns whether the campaign was approved and the
+// gate's reason or the error that occurred.
+func runCampaignGate(gate *campgate.Gate, co *core.Core, camp models.Campaign) (bool, string) {
+	var (
+		res campgate.Result
+		err error
+	)
+	if gate == nil {
+		err = errors.New("campaign gates are disabled")
+	} else {
+		res, err = gate.Check(camp)
+	}
+
+	status, reason := models.CampaignGateApproved, res.Reason
+	if err != nil {
+		reason = err.Error()
+	}
+	if err != nil || !res.Approved {
+		status = models.CampaignGatePending
+	}
+
+	if err := co.UpdateCampaignGate(camp.ID, status, reason); err != nil {
+		lo.Printf("error recording gate result of campaign %d: %v", camp.ID, err)
+	}
+
+	return status == models.CampaignGateApproved, reason
+}
+
+// retryCampaignGates re-checks campaigns that are awaiting approval from their gates.
+// Held campaigns that were being started are started once they're approved.
+func retryCampaignGates(co *core.Core, gate *campgate.Gate) {
+	ids, err := co.GetPendingGateCampaigns()
+	if err != nil {
+		return
+	}
+
+	for _, id := range ids {
+		camp, err := co.GetCampaign(id, "", "")
+		if err != nil {
+			continue
+		}
+
+		if ok, reason := runCampaignGate(gate, co, camp); !ok {
+			lo.Printf("campaign %d (%s) is awaiting gate approval: %s", camp.ID, camp.Name, reason)
+			continue
+		}
+
+		lo.Printf("campaign %d (%s) approved by gate", camp.ID, camp.Name)
+		startGatedCampaign(co, camp)
+	}
+}
+
+// startGatedCampaign starts a campaign that was held by its gate. Scheduled campaigns
+// are left to the scheduler.
+func startGatedCampaign(co *core.Core, camp models.Campaign) {
+	if camp.Status != models.CampaignStatusDraft && camp.Status != models.CampaignStatusPaused {
+		return
+	}
+
+	if _, err := co.UpdateCampaignStatus(camp.ID, models.CampaignStatusRunning); err != nil {
+		lo.Printf("error starting gate approved campaign %d: %v", camp.ID, err)
+	}
+}
+
+// OverrideCampaignGate handles the manual override of a campaign's approval gate.
+// A campaign that's held by its gate is started.
+func (a *App) OverrideCampaignGate(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if camp.GateURL == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.noGate"))
+	}
+
+	user := auth.GetUser(c)
+	if err := a.core.UpdateCampaignGate(id, models.CampaignGateOverridden,
+		a.i18n.Ts("campaigns.gateOverriddenBy", "name", user.Username)); err != nil {
+		return err
+	}
+
+	if camp.GateStatus == models.CampaignGatePending {
+		startGatedCampaign(a.core, camp)
+	}
+
+	out, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// makeCampaignStartConfirmation creates a start confirmation token for a campaign
+// along with a snapshot of what's about to be sent. Only one token is valid per
+// campaign at a time. Requesting a new one invalidates the previous one.
+func (a *App) makeCampaignStartConfirmation(id int, user auth.User) (models.CampaignStartConfirmation, error) {
+	camp, err := a.core.GetCampaignForPreview(id, 0)
+	if err != nil {
+		return models.CampaignStartConfirmation{}, err
+	}
+
+	// Check if the campaign can be started at all before asking for a confirmation.
+	if camp.Status != models.CampaignStatusPaused && camp.Status != models.CampaignStatusDraft {
+		return models.CampaignStartConfirmation{}, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.onlyPausedDraft"))
+	}
+
+	count, err := a.core.GetCampaignAudienceCount(id)
+	if err != nil {
+		return models.CampaignStartConfirmation{}, err
+	}
+
+	snap := models.CampaignStartSnapshot{
+		CampaignID:    camp.ID,
+		Name:          camp.Name,
+		Subject:       camp.Subject,
+		FromEmail:     camp.FromEmail,
+		Messenger:     camp.Messenger,
+		SendAt:        camp.SendAt,
+		Lists:         camp.Lists,
+		AudienceCount: count,
+		Warnings:      a.campaignStartWarnings(camp, count),
+	}
+
+	token, err := generateRandomString(campStartTokenLen)
+	if err != nil {
+		a.log.Printf("error generating campaign start token: %v", err)
+		return models.CampaignStartConfirmation{}, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+	tmptokens.Set(campStartTokenKey(id), campStartTTL, campStartToken{Token: token, UserID: user.ID})
+
+	return models.CampaignStartConfirmation{
+		Token:     token,
+		ExpiresAt: time.Now().Add(campStartTTL),
+		Snapshot:  snap,
+	}, nil
+}
+
+// consumeCampaignStartToken validates a campaign start confirmation token and
+// deletes it so that it can't be used again.
+func (a *App) consumeCampaignStartToken(id int, token string, user auth.User) error {
+	key := campStartTokenKey(id)
+
+	data, err := tmptokens.Check(key)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.startConfirmInvalid"))
+	}
+	t, ok := data.(campStartToken)
+	if !ok || t.UserID != user.ID || subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) != 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.startConfirmInvalid"))
+	}
+
+	// Get() deletes the token. If it's already gone, a concurrent request has consumed it.
+	if _, err := tmptokens.Get(key); err != nil {
+		return echo.NewHTTPError(http.StatusConflict, a.i18n.T("campaigns.startConfirmInvalid"))
+	}
+
+	return nil
+}
+
+// campaignStartWarnings returns warnings about a campaign that's about to be started.
+func (a *App) campaignStartWarnings(camp models.Campaign, count int) []string {
+	out := []string{}
+
+	if count == 0 {
+		out = append(out, a.i18n.T("campaigns.startWarnNoAudience"))
+	}
+	if !a.manager.HasMessenger(camp.Messenger) {
+		out = append(out, a.i18n.Ts("campaigns.fieldInvalidMessenger", "name", camp.Messenger))
+	}
+	if camp.SendAt.Valid && camp.SendAt.Time.After(time.Now()) {
+		out = append(out, a.i18n.T("campaigns.startWarnSendAt"))
+	}
+	if camp.Type != models.CampaignTypeOptin &&
+		!strings.Contains(camp.Body, "UnsubscribeURL") && !strings.Contains(camp.TemplateBody, "UnsubscribeURL") {
+		out = append(out, a.i18n.T("campaigns.startWarnNoUnsub"))
+	}
+	if w, err := a.listSendLimitWarnings(camp.ID, models.CampaignStatusRunning); err == nil {
+		out = append(out, w...)
+	}
+
+	return out
+}
+
+// checkListSendLimits checks whether sending or scheduling a campaign (changing its status
+// to the given status) exceeds the send frequency limits of any of its lists. Exceeding
+// them returns a warning, or an error if the limits are enforced.
+func (a *App) checkListSendLimits(id int, status string) (string, error) {
+	if status != models.CampaignStatusScheduled && status != models.CampaignStatusRunning {
+		return "", nil
+	}
+
+	warns, err := a.listSendLimitWarnings(id, status)
+	if err != nil || len(warns) == 0 {
+		return "", err
+	}
+
+	msg := strings.Join(warns, " ")
+	if a.cfg.EnforceListSendLimits {
+		return "", echo.NewHTTPError(http.StatusBadRequest, msg)
+	}
+
+	return msg, nil
+}
+
+// listSendLimitWarnings returns warnings for the campaign's lists whose weekly or
+// monthly send frequency limits would be exceeded by one more campaign.
+func (a *App) listSendLimitWarnings(id int, status string) ([]string, error) {
+	lists, err := a.core.GetCampaignListSendCounts(id, status)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []string{}
+	for _, l := range lists {
+		if l.MaxCampsPerWeek > 0 && l.WeekCount >= l.MaxCampsPerWeek {
+			out = append(out, a.i18n.Ts("campaigns.listSendLimitWeek", "name", l.Name,
+				"count", strconv.Itoa(l.WeekCount), "max", strconv.Itoa(l.MaxCampsPerWeek)))
+		}
+		if l.MaxCampsPerMonth > 0 && l.MonthCount >= l.MaxCampsPerMonth {
+			out = append(out, a.i18n.Ts("campaigns.listSendLimitMonth", "name", l.Name,
+				"count", strconv.Itoa(l.MonthCount), "max", strconv.Itoa(l.MaxCampsPerMonth)))
+		}
+	}
+
+	return out, nil
+}
+
+func campStartTokenKey(id int) string {
+	return "campaign-start:" + strconv.Itoa(id)
+}
+
+// minifyCampaignBody minifies the HTML body of a campaign in place if HTML minification
+// is enabled, and returns the sizes of the body before and after. It returns nil if the
+// body isn't minified.
+func (a *App) minifyCampaignBody(contentType string, body *string) *campBodyMeta {
+	if !a.cfg.CampaignMinifyHTML {
+		return nil
+	}
+
+	switch contentType {
+	case models.CampaignContentTypeHTML, models.CampaignContentTypeRichtext, models.CampaignContentTypeVisual:
+	default:
+		return nil
+	}
+
+	n := len(*body)
+	*body = htmlmin.Minify(*body)
+
+	return &campBodyMeta{BodySizeOriginal: n, BodySizeMinified: len(*body)}
+}
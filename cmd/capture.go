@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/internal/messenger/capture"
+	"github.com/labstack/echo/v4"
+)
+
+// GetCapturedMessages returns the messages recorded by the capture messenger,
+// newest first, optionally filtered by recipient, subscriber, campaign, or text.
+func (a *App) GetCapturedMessages(c echo.Context) error {
+	if a.capture == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.messengers.captureDisabled"))
+	}
+
+	out := a.capture.Get(capture.Query{
+		Email:          c.QueryParam("email"),
+		SubscriberUUID: c.QueryParam("subscriber_uuid"),
+		CampaignUUID:   c.QueryParam("campaign_uuid"),
+		Search:         c.QueryParam("query"),
+	})
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// ClearCapturedMessages removes all the messages recorded by the capture messenger.
+func (a *App) ClearCapturedMessages(c echo.Context) error {
+	if a.capture == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.messengers.captureDisabled"))
+	}
+
+	a.capture.Clear()
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
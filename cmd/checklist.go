@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/spamcheck"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Max. number of links in a campaign that are checked, the number of links
+	// that are checked concurrently, and the timeout for each of them.
+	maxChecklistLinks    = 50
+	checklistLinkWorkers = 5
+	checklistLinkTimeout = 10 * time.Second
+
+	// Max. number of broken links listed in the result of the links check.
+	maxChecklistBadLinks = 5
+)
+
+var (
+	// Links in the campaign body: href attributes, Markdown links, and the URLs
+	// in {{ TrackLink "..." }} and ...@TrackLink.
+	reChecklistHref      = regexp.MustCompile(`(?i)\bhref\s*=\s*["']([^"']+)["']`)
+	reChecklistMDLink    = regexp.MustCompile(`\]\((https?://[^)\s]+)\)`)
+	reChecklistTrackLink = regexp.MustCompile(`TrackLink\s+"([^"]+)"`)
+)
+
+// GetCampaignChecklist runs the pre-send checks on a campaign and returns their results.
+func (a *App) GetCampaignChecklist(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	out, err := a.campaignChecklist(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// campaignChecklist runs the pre-send checks on a campaign.
+func (a *App) campaignChecklist(id int) ([]models.CampaignCheck, error) {
+	camp, err := a.core.GetCampaignForPreview(id, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]models.CampaignCheck, 0, 7)
+	add := func(check string, passed bool, msg string) {
+		out = append(out, models.CampaignCheck{Check: check, Passed: passed, Message: msg})
+	}
+
+	// From address and subject.
+	if strings.TrimSpace(camp.FromEmail) != "" {
+		add(models.CampaignCheckFromEmail, true, camp.FromEmail)
+	} else {
+		add(models.CampaignCheckFromEmail, false, a.i18n.T("campaigns.checklistNoFromEmail"))
+	}
+
+	if strings.TrimSpace(camp.Subject) != "" {
+		add(models.CampaignCheckSubject, true, camp.Subject)
+	} else {
+		add(models.CampaignCheckSubject, false, a.i18n.T("campaigns.checklistNoSubject"))
+	}
+
+	// The unsubscribe link can be in the body or in the template.
+	if strings.Contains(camp.Body, "UnsubscribeURL") || strings.Contains(camp.TemplateBody, "UnsubscribeURL") {
+		add(models.CampaignCheckUnsubscribe, true, "")
+	} else {
+		add(models.CampaignCheckUnsubscribe, false, a.i18n.T("campaigns.checklistNoUnsubscribe"))
+	}
+
+	// Links in the body.
+	links := extractCampaignLinks(camp.Body)
+	if bad := checkLinks(links); len(bad) > 0 {
+		if len(bad) > maxChecklistBadLinks {
+			bad = append(bad[:maxChecklistBadLinks], "...")
+		}
+		add(models.CampaignCheckLinks, false,
+			a.i18n.Ts("campaigns.checklistBrokenLinks", "links", strings.Join(bad, ", ")))
+	} else {
+		add(models.CampaignCheckLinks, true, a.i18n.Ts("campaigns.checklistLinks", "num", strconv.Itoa(len(links))))
+	}
+
+	// Subscribers the campaign would be sent to.
+	count, err := a.core.GetCampaignAudienceCount(id)
+	if err != nil {
+		return nil, err
+	}
+	add(models.CampaignCheckSubscribers, count > 0, a.i18n.Ts("campaigns.checklistSubscribers", "num", strconv.Itoa(count)))
+
+	// Rendering the template. Without a rendered body, the spam score can't be checked either.
+	body, err := a.renderCampaignDummy(&camp)
+	if err != nil {
+		msg := err.Error()
+		if e, ok := err.(*echo.HTTPError); ok {
+			msg = fmt.Sprintf("%v", e.Message)
+		}
+		add(models.CampaignCheckTemplate, false, msg)
+		add(models.CampaignCheckSpamScore, false, a.i18n.T("campaigns.checklistNoSpamScore"))
+
+		return out, nil
+	}
+	add(models.CampaignCheckTemplate, true, "")
+
+	spam := spamcheck.Check(camp.Subject, string(body))
+	add(models.CampaignCheckSpamScore, !spam.Spam, a.i18n.Ts("campaigns.checklistSpamScore",
+		"score", strconv.FormatFloat(spam.Score, 'f', 1, 64),
+		"max", strconv.FormatFloat(spamcheck.Threshold, 'f', 1, 64)))
+
+	return out, nil
+}
+
+// checkCampaignChecklist returns an error listing the failed checks if any of the
+// pre-send checks on a campaign fail.
+func (a *App) checkCampaignChecklist(id int) error {
+	checks, err := a.campaignChecklist(id)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, c := range checks {
+		if !c.Passed {
+			failed = append(failed, c.Check)
+		}
+	}
+	if len(failed) > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("campaigns.checklistFailed", "checks", strings.Join(failed, ", ")))
+	}
+
+	return nil
+}
+
+// extractCampaignLinks returns the unique http(s) links in a campaign body.
+// Links with template expressions, other than TrackLink, are skipped as they
+// can only be resolved for a subscriber.
+func extractCampaignLinks(body string) []string {
+	var (
+		out  []string
+		seen = map[string]struct{}{}
+	)
+
+	matches := reChecklistHref.FindAllStringSubmatch(body, -1)
+	matches = append(matches, reChecklistMDLink.FindAllStringSubmatch(body, -1)...)
+	for _, m := range matches {
+		u := strings.TrimSpace(m[1])
+		if t := reChecklistTrackLink.FindStringSubmatch(u); t != nil {
+			u = t[1]
+		}
+		u = strings.TrimSuffix(u, "@TrackLink")
+
+		if strings.Contains(u, "{{") || !(strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")) {
+			continue
+		}
+		if _, ok := seen[u]; ok {
+			continue
+		}
+		seen[u] = struct{}{}
+
+		out = append(out, u)
+		if len(out) >= maxChecklistLinks {
+			break
+		}
+	}
+
+	return out
+}
+
+// checkLinks requests the given links and returns the ones that fail or
+// respond with an error.
+func checkLinks(links []string) []string {
+	var (
+		client = &http.Client{Timeout: checklistLinkTimeout}
+		ch     = make(chan int)
+		bad    = make([]bool, len(links))
+		wg     sync.WaitGroup
+	)
+
+	for range min(checklistLinkWorkers, len(links)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				bad[i] = !isLinkOK(client, links[i])
+			}
+		}()
+	}
+	for i := range links {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+
+	var out []string
+	for i, b := range bad {
+		if b {
+			out = append(out, links[i])
+		}
+	}
+
+	return out
+}
+
+// isLinkOK checks whether a link responds without an error. Servers that don't
+// support HEAD requests are retried with GET.
+func isLinkOK(client *http.Client, link string) bool {
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequest(method, link, nil)
+		if err != nil {
+			return false
+		}
+		req.Header.Set("User-Agent", "listmonk")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+			continue
+		}
+
+		return resp.StatusCode < http.StatusBadRequest
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Query param on a destination URL that opts its clicks into conversion tracking.
+	convParam = "listmonk_conv"
+
+	// Query params with which the link, the campaign, and the subscriber of a click are
+	// carried over to the destination page of a link that's opted into conversion tracking.
+	convLinkParam = "listmonk_link"
+	convCampParam = "listmonk_campaign"
+	convSubParam  = "listmonk_subscriber"
+)
+
+// addConversionParams adds the link, campaign, and subscriber UUIDs of a click to its
+// destination URL if the URL has the listmonk_conv=1 param. The destination page
+// reports a conversion by requesting the link's tracking URL with conv=1, which
+// carries them back in the Referer.
+func addConversionParams(link, linkUUID, campUUID, subUUID string) string {
+	if subUUID == "" || subUUID == dummyUUID {
+		return link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return link
+	}
+
+	q := u.Query()
+	if q.Get(convParam) != "1" {
+		return link
+	}
+	q.Set(convLinkParam, linkUUID)
+	q.Set(convCampParam, campUUID)
+	q.Set(convSubParam, subUUID)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// registerLinkConversion records a conversion on the latest click of a link by a
+// subscriber when the destination page of the click requests the link's tracking
+// URL with conv=1, for example, as an image. The conversion is only recorded if the
+// request's Referer is the destination page, with the listmonk_conv=1 param and the
+// UUIDs added to it on the click. Regardless of errors, the pixel image is always rendered.
+func (a *App) registerLinkConversion(c echo.Context) error {
+	var (
+		linkUUID = c.Param("linkUUID")
+		campUUID = c.Param("campUUID")
+		subUUID  = c.Param("subUUID")
+	)
+
+	// Conversions are tied to the clicks of individual subscribers.
+	if !a.cfg.Privacy.DisableTracking && a.cfg.Privacy.IndividualTracking &&
+		campUUID != dummyUUID && subUUID != dummyUUID {
+		if ref, err := url.Parse(c.Request().Referer()); err == nil {
+			q := ref.Query()
+			if q.Get(convParam) == "1" && q.Get(convLinkParam) == linkUUID &&
+				q.Get(convCampParam) == campUUID && q.Get(convSubParam) == subUUID {
+				if err := a.core.RegisterLinkConversion(linkUUID, campUUID, subUUID); err != nil {
+					a.log.Printf("error registering link conversion: %v", err)
+				}
+			}
+		}
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	return c.Blob(http.StatusOK, "image/png", pixelPNG)
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
+	"github.com/knadh/listmonk/internal/events"
+	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
+// subscriberStreamIdle is the duration without any events after which a
+// subscriber event stream is closed.
+const subscriberStreamIdle = time.Minute * 30
+
 // EventStream serves an endpoint that never closes and pushes a
 // live event stream (text/event-stream) such as a error messages.
 func (a *App) EventStream(c echo.Context) error {
@@ -19,7 +29,7 @@ func (a *App) EventStream(c echo.Context) error {
 
 	// Subscribe to the event stream with a random ID.
 	id := fmt.Sprintf("api:%v", time.Now().UnixNano())
-	sub, err := a.events.Subscribe(id)
+	sub, err := a.events.Subscribe(id, events.TypeError)
 	if err != nil {
 		log.Fatalf("error subscribing to events: %v", err)
 	}
@@ -45,3 +55,77 @@ func (a *App) EventStream(c echo.Context) error {
 	}
 
 }
+
+// SubscriberEventStream serves a live event stream (text/event-stream) of subscriber
+// lifecycle events (created, confirmed, blocklisted, unsubscribed). The stream is
+// closed after subscriberStreamIdle without any events.
+func (a *App) SubscriberEventStream(c echo.Context) error {
+	hdr := c.Response().Header()
+	hdr.Set(echo.HeaderContentType, "text/event-stream")
+	hdr.Set(echo.HeaderCacheControl, "no-store")
+	hdr.Set(echo.HeaderConnection, "keep-alive")
+
+	// Subscribe to subscriber events with a random ID.
+	id := fmt.Sprintf("subscribers:%v", time.Now().UnixNano())
+	sub, err := a.events.Subscribe(id, events.TypeSubscriber)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer a.events.Unsubscribe(id)
+
+	c.Response().WriteHeader(http.StatusOK)
+	c.Response().Flush()
+
+	idle := time.NewTimer(subscriberStreamIdle)
+	defer idle.Stop()
+
+	ctx := c.Request().Context()
+	for {
+		select {
+		case e := <-sub:
+			b, err := json.Marshal(e.Data)
+			if err != nil {
+				a.log.Printf("error marshalling event: %v", err)
+				continue
+			}
+
+			c.Response().Write([]byte(fmt.Sprintf("retry: 3000\ndata: %s\n\n", b)))
+			c.Response().Flush()
+			idle.Reset(subscriberStreamIdle)
+
+		case <-idle.C:
+			return nil
+
+		case <-ctx.Done():
+			// On HTTP connection close, unsubscribe.
+			return nil
+		}
+	}
+}
+
+// makeSubscriberEventPublisher returns a function that publishes subscriber lifecycle
+// events to the event stream. Subscribers are identified by their IDs and the hashes
+// of their e-mails.
+func makeSubscriberEventPublisher(ev *events.Events) func(string, []models.Subscriber) {
+	return func(typ string, subs []models.Subscriber) {
+		if !ev.HasSubscribers(events.TypeSubscriber) {
+			return
+		}
+
+		now := time.Now()
+		for _, s := range subs {
+			h := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(s.Email))))
+
+			// A client that isn't reading its stream fast enough misses events.
+			_ = ev.Publish(events.Event{
+				Type: events.TypeSubscriber,
+				Data: models.SubscriberEvent{
+					SubscriberID: s.ID,
+					EmailHash:    hex.EncodeToString(h[:]),
+					Type:         typ,
+					Timestamp:    now,
+				},
+			})
+		}
+	}
+}
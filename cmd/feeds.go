@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Number of subscriptions in the new subscribers feed.
+	newSubscribersFeedSize = 100
+
+	// Min. length of the new subscribers feed token.
+	minFeedTokenLen = 16
+)
+
+// GetNewSubscribersFeed renders the Atom feed of the latest subscriptions to lists.
+// It's authenticated with the feed token in the settings so that it can be
+// polled by feed readers. E-mails are hashed (SHA-256 of the lowercased e-mail).
+func (a *App) GetNewSubscribersFeed(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.cfg.Security.SubscriberFeed.Token)) != 1 {
+		return echo.NewHTTPError(http.StatusForbidden, a.i18n.T("subscribers.invalidFeedToken"))
+	}
+
+	subs, err := a.core.GetNewSubscriptions(newSubscribersFeedSize)
+	if err != nil {
+		return err
+	}
+
+	out := make([]*feeds.Item, 0, len(subs))
+	for _, s := range subs {
+		h := sha256.Sum256([]byte(strings.ToLower(s.Email)))
+		var (
+			emailHash = hex.EncodeToString(h[:])
+			link      = fmt.Sprintf("%s/admin/subscribers/%d", a.urlCfg.RootURL, s.SubscriberID)
+		)
+
+		// Entry content as a list of field: value.
+		fields := [][2]string{
+			{a.i18n.T("subscribers.emailHash"), emailHash},
+			{a.i18n.Tc("globals.terms.list", 1), s.ListName},
+			{a.i18n.T("subscribers.subscribedAt"), s.CreatedAt.Format(time.RFC3339)},
+			{a.i18n.T("lists.optin"), a.i18n.T("lists.optins." + s.Optin)},
+			{a.i18n.T("globals.fields.status"), a.i18n.T("subscribers.status." + s.Status)},
+		}
+		var b strings.Builder
+		b.WriteString("<dl>")
+		for _, f := range fields {
+			fmt.Fprintf(&b, "<dt>%s</dt><dd>%s</dd>", html.EscapeString(f[0]), html.EscapeString(f[1]))
+		}
+		b.WriteString("</dl>")
+
+		out = append(out, &feeds.Item{
+			Id:      fmt.Sprintf("%s#list-%d", link, s.ListID),
+			Title:   a.i18n.Ts("subscribers.feedEntryTitle", "name", s.ListName),
+			Link:    &feeds.Link{Href: link},
+			Content: b.String(),
+			Created: s.CreatedAt,
+		})
+	}
+
+	feed := &feeds.Feed{
+		Title: a.cfg.SiteName + " - " + a.i18n.T("subscribers.newSubscribersFeed"),
+		Link:  &feeds.Link{Href: a.urlCfg.RootURL + "/admin/subscribers"},
+		Items: out,
+	}
+	if len(out) > 0 {
+		feed.Updated = out[0].Created
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/atom+xml; charset=utf-8")
+	if err := feed.WriteAtom(c.Response().Writer); err != nil {
+		a.log.Printf("error generating new subscribers feed: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorProcessingRequest"))
+	}
+
+	return nil
+}
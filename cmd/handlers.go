@@ -7,8 +7,10 @@ import (
 	"path"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -25,6 +27,13 @@ type okResp struct {
 	Data any `json:"data"`
 }
 
+// conflictResp is the response to an update of a resource that has been modified
+// since it was read by the client. Data is the current state of the resource.
+type conflictResp struct {
+	Message string `json:"message"`
+	Data    any    `json:"data"`
+}
+
 var (
 	reUUID = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
 )
@@ -33,10 +42,15 @@ var (
 func initHTTPHandlers(e *echo.Echo, a *App) {
 	// Default error handler.
 	e.HTTPErrorHandler = func(err error, c echo.Context) {
-		// Generic, non-echo error. Log it.
-		if _, ok := err.(*echo.HTTPError); !ok {
+		he, ok := err.(*echo.HTTPError)
+		if !ok {
+			// Generic, non-echo error. Log it.
 			a.log.Println(err.Error())
+		} else if msg, isStr := he.Message.(string); isStr && strings.Contains(msg, core.ErrMsgQueryTimeout) {
+			// A DB query that was cancelled for exceeding the statement timeout.
+			err = echo.NewHTTPError(http.StatusServiceUnavailable, a.i18n.T("globals.messages.queryTimeout"))
 		}
+
 		e.DefaultHTTPErrorHandler(err, c)
 	}
 
@@ -100,23 +114,37 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 
 		// API endpoints.
 		g.GET("/api/health", a.HealthCheck)
+		g.GET("/api/health/media", pm(a.MediaHealthCheck, "media:manage"))
 		g.GET("/api/config", a.GetServerConfig)
 		g.GET("/api/lang/:lang", a.GetI18nLang)
 		g.GET("/api/dashboard/charts", a.GetDashboardCharts)
 		g.GET("/api/dashboard/counts", a.GetDashboardCounts)
+		g.GET("/api/dashboard/calendar", pm(a.GetDashboardCalendar, "campaigns:get_all", "campaigns:get"))
 
 		g.GET("/api/settings", pm(a.GetSettings, "settings:get"))
 		g.PUT("/api/settings", pm(a.UpdateSettings, "settings:manage"))
+		g.PATCH("/api/settings", pm(a.PatchSettings, "settings:manage"))
+		g.GET("/api/settings/export", pm(a.ExportSettings, "settings:get"))
+		g.POST("/api/settings/import", pm(a.ImportSettings, "settings:manage"))
 		g.PUT("/api/settings/:key", pm(a.UpdateSettingsByKey, "settings:manage"))
 		g.POST("/api/settings/smtp/test", pm(a.TestSMTPSettings, "settings:manage"))
+		g.GET("/api/messengers/capture", pm(a.GetCapturedMessages, "settings:get"))
+		g.DELETE("/api/messengers/capture", pm(a.ClearCapturedMessages, "settings:manage"))
 		g.POST("/api/admin/reload", pm(a.ReloadApp, "settings:manage"))
 		g.GET("/api/logs", pm(a.GetLogs, "settings:get"))
 		g.GET("/api/events", pm(a.EventStream, "settings:get"))
+		g.GET("/api/notifications", pm(a.GetNotifications, "settings:get"))
 		g.GET("/api/about", a.GetAboutInfo)
 
 		g.GET("/api/subscribers", pm(a.QuerySubscribers, "subscribers:get_all", "subscribers:get"))
+		g.GET("/api/subscribers/attrib_stats", pm(a.GetSubscriberAttribStats, "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id", pm(hasID(a.GetSubscriber), "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id/activity", pm(hasID(a.GetSubscriberActivity), "subscribers:get_all", "subscribers:get"))
+		g.GET("/api/subscribers/:id/sends", pm(hasID(a.GetSubscriberSends), "subscribers:get_all", "subscribers:get"))
+		g.GET("/api/subscribers/:id/consents", pm(hasID(a.GetSubscriberConsents), "subscribers:get_all", "subscribers:get"))
+		g.GET("/api/subscribers/:id/campaign_history", pm(hasID(a.GetSubscriberCampaignHistory), "subscribers:get_all", "subscribers:get"))
+		g.GET("/api/subscribers/:id/campaign_preview/:campaign_id", pm(hasID(a.PreviewSubscriberCampaign), "subscribers:get_all", "subscribers:get"))
+		g.GET("/api/subscribers/:id/attrib_history", pm(hasID(a.GetSubscriberAttribHistory), "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id/export", pm(hasID(a.ExportSubscriberData), "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id/bounces", pm(hasID(a.GetSubscriberBounces), "bounces:get"))
 		g.DELETE("/api/subscribers/:id/bounces", pm(hasID(a.DeleteSubscriberBounces), "bounces:manage"))
@@ -125,14 +153,19 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.PATCH("/api/subscribers/:id", pm(hasID(a.PatchSubscriber), "subscribers:manage"))
 		g.POST("/api/subscribers/:id/optin", pm(hasID(a.SubscriberSendOptin), "subscribers:manage"))
 		g.PUT("/api/subscribers/blocklist", pm(a.BlocklistSubscribers, "subscribers:manage"))
+		g.POST("/api/subscribers/bulk_status", pm(a.UpdateSubscribersStatus, "subscribers:manage"))
 		g.PUT("/api/subscribers/:id/blocklist", pm(hasID(a.BlocklistSubscriber), "subscribers:manage"))
 		g.PUT("/api/subscribers/lists/:id", pm(a.ManageSubscriberLists, "subscribers:manage"))
 		g.PUT("/api/subscribers/lists", pm(a.ManageSubscriberLists, "subscribers:manage"))
 		g.DELETE("/api/subscribers/:id", pm(hasID(a.DeleteSubscriber), "subscribers:manage"))
+		g.POST("/api/subscribers/:id/anonymize", pm(hasID(a.AnonymizeSubscriber), "subscribers:manage"))
 		g.DELETE("/api/subscribers", pm(a.DeleteSubscribers, "subscribers:manage"))
 
 		g.GET("/api/bounces", pm(a.GetBounces, "bounces:get"))
 		g.PUT("/api/bounces/blocklist", pm(a.BlocklistBouncedSubscribers, "bounces:manage"))
+		g.GET("/api/bounces/mailbox", pm(a.GetBounceMailbox, "bounces:get"))
+		g.GET("/api/bounces/reasons", pm(a.GetBounceReasons, "bounces:get"))
+		g.GET("/api/bounces/mailbox/quarantine/:uid", pm(a.GetQuarantinedBounce, "bounces:manage"))
 		g.GET("/api/bounces/:id", pm(hasID(a.GetBounce), "bounces:get"))
 		g.DELETE("/api/bounces", pm(a.DeleteBounces, "bounces:manage"))
 		g.DELETE("/api/bounces/:id", pm(hasID(a.DeleteBounce), "bounces:manage"))
@@ -147,45 +180,116 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 
 		g.GET("/api/import/subscribers", pm(a.GetImportSubscribers, "subscribers:import"))
 		g.GET("/api/import/subscribers/logs", pm(a.GetImportSubscriberStats, "subscribers:import"))
+		g.GET("/api/import/subscribers/errors", pm(a.GetImportSubscriberErrors, "subscribers:import"))
+		g.GET("/api/subscribers/import/:id/archive", pm(hasID(a.GetImportArchive), "subscribers:import"))
 		g.POST("/api/import/subscribers", pm(a.ImportSubscribers, "subscribers:import"))
+		g.POST("/api/subscribers/import/preview", pm(a.PreviewImportSubscribers, "subscribers:import"))
+		g.POST("/api/subscribers/import/google_sheets", pm(a.ImportGoogleSheet, "subscribers:import"))
+		g.POST("/api/migrate/klaviyo", pm(a.MigrateKlaviyo, "subscribers:import"))
 		g.DELETE("/api/import/subscribers", pm(a.StopImportSubscribers, "subscribers:import"))
 
 		// Individual list permissions are applied directly within handleGetLists.
 		g.GET("/api/lists", a.GetLists)
+		g.GET("/api/lists/overlap", a.GetListsOverlap)
 		g.GET("/api/lists/:id", hasID(a.GetList))
+		g.GET("/api/lists/:id/subscriber_count", hasID(a.GetListSubscriberCount))
+		g.GET("/api/lists/:id/qrcode", hasID(a.GetListQRCode))
+		g.POST("/api/lists/:id/landing_page", hasID(a.CreateListLandingPage))
+		g.GET("/api/lists/:id/subscribers/export",
+			pm(middleware.GzipWithConfig(middleware.GzipConfig{Level: 9})(hasID(a.ExportListSubscribers)), "subscribers:get_all", "subscribers:get"))
 		g.POST("/api/lists", pm(a.CreateList, "lists:manage_all"))
 		g.PUT("/api/lists/:id", hasID(a.UpdateList))
+		g.POST("/api/lists/:id/rules/evaluate", hasID(a.EvaluateListRules))
+		g.POST("/api/lists/:id/merge", hasID(a.MergeList))
+		g.POST("/api/lists/:id/suppressions/import", pm(hasID(a.ImportListSuppressions), "subscribers:manage"))
 		g.DELETE("/api/lists", a.DeleteLists)
 		g.DELETE("/api/lists/:id", hasID(a.DeleteList))
 
+		g.GET("/api/topics", a.GetTopics)
+		g.GET("/api/topics/:id", hasID(a.GetTopic))
+		g.POST("/api/topics", pm(a.CreateTopic, "lists:manage_all"))
+		g.PUT("/api/topics/:id", pm(hasID(a.UpdateTopic), "lists:manage_all"))
+		g.DELETE("/api/topics/:id", pm(hasID(a.DeleteTopic), "lists:manage_all"))
+
+		g.GET("/api/segments", pm(a.GetSegments, "segments:get"))
+		g.GET("/api/segments/:id", pm(hasID(a.GetSegment), "segments:get"))
+		g.POST("/api/segments", pm(a.CreateSegment, "segments:manage"))
+		g.POST("/api/segments/:id/query", pm(hasID(a.QuerySegment), "segments:get"))
+		g.PUT("/api/segments/:id", pm(hasID(a.UpdateSegment), "segments:manage"))
+		g.PUT("/api/segments/:id/approve", pm(hasID(a.ApproveSegment), "segments:approve"))
+		g.DELETE("/api/segments/:id", pm(hasID(a.DeleteSegment), "segments:manage"))
+
 		g.GET("/api/campaigns", pm(a.GetCampaigns, "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/running/stats", pm(a.GetRunningCampaignStats, "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/:id", pm(hasID(a.GetCampaign), "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/analytics/:type", pm(a.GetCampaignViewAnalytics, "campaigns:get_analytics"))
+		g.GET("/api/analytics/engagement-heatmap", pm(a.GetEngagementHeatmap, "campaigns:get_analytics"))
+		g.GET("/api/analytics/send_frequency", pm(a.GetSendFrequency, "campaigns:get_analytics"))
+		g.GET("/api/analytics/cohorts", pm(a.GetSubscriberCohorts, "campaigns:get_analytics"))
+		g.GET("/api/analytics/bounce_domains", pm(a.GetBounceDomains, "bounces:get"))
+		g.GET("/api/reports/disengaged_subscribers", pm(a.GetDisengagedSubscribersReport, "subscribers:get_all", "subscribers:get"))
+		g.GET("/api/reports/engagement_funnel", pm(a.GetEngagementFunnelReport, "campaigns:get_analytics"))
+		g.GET("/api/reports/subscriber_growth", pm(a.GetSubscriberGrowthReport, "subscribers:get_all", "subscribers:get"))
+		g.GET("/api/campaigns/:id/audience", pm(hasID(a.GetCampaignAudience), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/subscriber_overlap", pm(hasID(a.GetCampaignSubscriberOverlap), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/bounces", pm(hasID(a.GetCampaignBounces), "bounces:get"))
+		g.GET("/api/campaigns/:id/events/:type", pm(hasID(a.GetCampaignActivity), "campaigns:get_analytics"))
+		g.GET("/api/campaigns/:id/analytics/export", pm(hasID(a.ExportCampaignSubscriberAnalytics), "campaigns:get_analytics"))
+		g.GET("/api/campaigns/:id/client_report", pm(hasID(a.GetCampaignClientReport), "campaigns:get_analytics"))
+		g.GET("/api/campaigns/:id/isp_report", pm(hasID(a.GetCampaignISPReport), "campaigns:get_analytics"))
+		g.GET("/api/campaigns/:id/revisions", pm(hasID(a.GetCampaignRevisions), "campaigns:get_analytics"))
 		g.GET("/api/campaigns/:id/preview", pm(hasID(a.PreviewCampaign), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/template_diff", pm(hasID(a.GetCampaignTemplateDiff), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/performance_prediction", pm(hasID(a.GetCampaignPerformancePrediction), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/preview/archive", pm(hasID(a.PreviewCampaignArchive), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/preview", pm(hasID(a.PreviewCampaign), "campaigns:get_all", "campaigns:get"))
+		g.POST("/api/campaigns/:id/preview/markdown", pm(hasID(a.PreviewCampaignMarkdown), "campaigns:get_all", "campaigns:get"))
+		g.POST("/api/campaigns/:id/outlook_preview", pm(hasID(a.PreviewCampaignOutlook), "campaigns:get_all", "campaigns:get"))
+		g.POST("/api/campaigns/:id/validate", pm(hasID(a.ValidateCampaign), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/content", pm(hasID(a.CampaignContent), "campaigns:manage_all", "campaigns:manage"))
 		g.POST("/api/campaigns/:id/text", pm(hasID(a.PreviewCampaign), "campaigns:get"))
 		g.POST("/api/campaigns/:id/test", pm(hasID(a.TestCampaign), "campaigns:manage_all", "campaigns:manage"))
+		g.POST("/api/campaigns/:id/spellcheck", pm(hasID(a.SpellCheckCampaign), "campaigns:get_all", "campaigns:get"))
+		g.POST("/api/campaigns/:id/accessibility_check", pm(hasID(a.AccessibilityCheckCampaign), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/checklist", pm(hasID(a.GetCampaignChecklist), "campaigns:get_all", "campaigns:get"))
+		g.POST("/api/campaigns/:id/convert", pm(hasID(a.ConvertCampaign), "campaigns:manage_all", "campaigns:manage"))
 		g.POST("/api/campaigns", pm(a.CreateCampaign, "campaigns:manage_all", "campaigns:manage"))
+		g.POST("/api/campaigns/batch_status", pm(a.UpdateCampaignsStatus, "campaigns:send"))
 		g.PUT("/api/campaigns/:id", pm(hasID(a.UpdateCampaign), "campaigns:manage_all", "campaigns:manage"))
+		g.POST("/api/campaigns/:id/touch", pm(hasID(a.TouchCampaign), "campaigns:manage_all", "campaigns:manage"))
+		g.DELETE("/api/campaigns/:id/touch", pm(hasID(a.TouchCampaign), "campaigns:manage_all", "campaigns:manage"))
 		g.PUT("/api/campaigns/:id/status", pm(hasID(a.UpdateCampaignStatus), "campaigns:send"))
+		g.POST("/api/campaigns/:id/cancel_and_report", pm(hasID(a.CancelCampaignAndReport), "campaigns:send"))
+		g.POST("/api/campaigns/:id/retry_failures", pm(hasID(a.RetryCampaignSendFailures), "campaigns:send"))
+		g.POST("/api/campaigns/:id/test_send", pm(hasID(a.SendCampaignTestCohort), "campaigns:send"))
+		g.PUT("/api/campaigns/:id/gate/override", pm(hasID(a.OverrideCampaignGate), "campaigns:override_gate"))
 		g.PUT("/api/campaigns/:id/archive", pm(hasID(a.UpdateCampaignArchive), "campaigns:manage_all", "campaigns:manage"))
+		g.POST("/api/campaigns/:id/survey", pm(hasID(a.UpdateCampaignSurvey), "campaigns:manage_all", "campaigns:manage"))
+		g.GET("/api/campaigns/:id/survey/results", pm(hasID(a.GetCampaignSurveyResults), "campaigns:get_analytics"))
 		g.DELETE("/api/campaigns", pm(a.DeleteCampaigns, "campaigns:manage", "campaigns:manage_all"))
 		g.DELETE("/api/campaigns/:id", pm(hasID(a.DeleteCampaign), "campaigns:manage_all", "campaigns:manage"))
 
 		g.GET("/api/media", pm(a.GetAllMedia, "media:get"))
 		g.GET("/api/media/:id", pm(hasID(a.GetMedia), "media:get"))
 		g.POST("/api/media", pm(a.UploadMedia, "media:manage"))
+		g.POST("/api/media/base64", pm(a.UploadMediaBase64, "media:manage"))
+		g.POST("/api/media/download_archive", pm(a.DownloadMediaArchive, "media:get"))
 		g.DELETE("/api/media/:id", pm(hasID(a.DeleteMedia), "media:manage"))
 
 		g.GET("/api/templates", pm(a.GetTemplates, "templates:get"))
+		g.GET("/api/templates/library", pm(a.GetLibraryTemplates, "templates:get"))
+		g.POST("/api/templates/library/:id/clone", pm(hasID(a.CloneLibraryTemplate), "templates:manage"))
 		g.GET("/api/templates/:id", pm(hasID(a.GetTemplate), "templates:get"))
 		g.GET("/api/templates/:id/preview", pm(hasID(a.PreviewTemplate), "templates:get"))
 		g.POST("/api/templates/preview", pm(a.PreviewTemplateBody, "templates:get"))
+		g.POST("/api/templates/:id/lint", pm(hasID(a.LintTemplate), "templates:get"))
+		g.POST("/api/templates/:id/test_matrix", pm(hasID(a.TemplateTestMatrix), "templates:get"))
+		g.POST("/api/templates/:id/benchmark", pm(hasID(a.BenchmarkTemplate), "templates:get"))
 		g.POST("/api/templates", pm(a.CreateTemplate, "templates:manage"))
+		g.POST("/api/templates/import-bundle", pm(a.ImportTemplateBundle, "templates:manage"))
 		g.PUT("/api/templates/:id", pm(hasID(a.UpdateTemplate), "templates:manage"))
+		g.POST("/api/templates/:id/touch", pm(hasID(a.TouchTemplate), "templates:manage"))
+		g.DELETE("/api/templates/:id/touch", pm(hasID(a.TouchTemplate), "templates:manage"))
 		g.PUT("/api/templates/:id/default", pm(hasID(a.TemplateSetDefault), "templates:manage"))
 		g.DELETE("/api/templates/:id", pm(hasID(a.DeleteTemplate), "templates:manage"))
 
@@ -193,9 +297,13 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.DELETE("/api/maintenance/analytics/:type", pm(a.GCCampaignAnalytics, "settings:maintain"))
 		g.GET("/api/maintenance/analytics/:type/export", pm(a.ExportCampaignAnalytics, "settings:maintain"))
 		g.DELETE("/api/maintenance/subscriptions/unconfirmed", pm(a.GCSubscriptions, "settings:maintain"))
+		g.GET("/api/maintenance/backup", pm(a.GetBackups, "settings:maintain"))
+		g.POST("/api/maintenance/backup", pm(a.CreateBackup, "settings:maintain"))
 
 		g.POST("/api/tx", pm(a.SendTxMessage, "tx:send"))
 
+		g.POST("/api/automations/reconfirmation", pm(a.CreateReconfirmation, "subscribers:manage"))
+
 		g.GET("/api/profile", a.GetUserProfile)
 		g.PUT("/api/profile", a.UpdateUserProfile)
 		g.GET("/api/users", pm(a.GetUsers, "users:get"))
@@ -222,6 +330,7 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		if a.cfg.BounceWebhooksEnabled {
 			// Private authenticated bounce endpoint.
 			g.POST("/webhooks/bounce", pm(a.BounceWebhook, "webhooks:post_bounce"))
+			g.POST("/webhooks/delivery", pm(a.DeliveryWebhook, "webhooks:post_delivery"))
 		}
 	}
 
@@ -234,8 +343,17 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		if a.cfg.BounceWebhooksEnabled {
 			// Public bounce endpoints for webservices like SES.
 			g.POST("/webhooks/service/:service", a.BounceWebhook)
+			g.POST("/webhooks/ses/sns", a.SESWebhook)
 		}
 
+		if a.cfg.Security.SubscriberFeed.Enabled {
+			// New subscribers feed authenticated with the feed token.
+			g.GET("/api/feeds/new_subscribers", a.GetNewSubscribersFeed)
+		}
+
+		// Subscriber event stream authenticated with an API token in the query param.
+		g.GET("/api/subscribers/events/stream", a.auth.QueryTokenMiddleware(a.auth.Perm(a.SubscriberEventStream, "subscribers:get_all")))
+
 		// Landing page.
 		g.GET("/", func(c echo.Context) error {
 			return c.Render(http.StatusOK, "home", publicTpl{Title: "listmonk"})
@@ -259,6 +377,8 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		// Public APIs.
 		g.GET("/api/public/lists", a.GetPublicLists)
 		g.POST("/api/public/subscription", a.PublicSubscription)
+		g.GET("/api/public/subscription_status", a.PublicSubscriptionStatus)
+		g.POST("/api/public/subscription/optin/:subUUID", a.PublicOptin)
 		g.GET("/api/public/captcha/altcha", a.AltchaChallenge)
 		if a.cfg.EnablePublicArchive {
 			g.GET("/api/public/archive", a.GetCampaignArchives)
@@ -272,16 +392,24 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.POST("/subscription/:campUUID/:subUUID", a.hasUUID(a.hasSub(a.SubscriptionPrefs), "campUUID", "subUUID"))
 		g.GET("/subscription/optin/:subUUID", noIndex(a.hasUUID(a.hasSub(a.OptinPage), "subUUID")))
 		g.POST("/subscription/optin/:subUUID", a.hasUUID(a.hasSub(a.OptinPage), "subUUID"))
+		g.GET("/subscription/reconfirm/:uuid", noIndex(a.hasUUID(a.ReconfirmPage, "uuid")))
+		g.POST("/subscription/reconfirm/:uuid", a.hasUUID(a.ReconfirmPage, "uuid"))
 		g.POST("/subscription/export/:subUUID", a.hasUUID(a.hasSub(a.SelfExportSubscriberData), "subUUID"))
 		g.POST("/subscription/wipe/:subUUID", a.hasUUID(a.hasSub(a.WipeSubscriberData), "subUUID"))
 		g.GET("/link/:linkUUID/:campUUID/:subUUID", noIndex(a.hasUUID(a.LinkRedirect, "linkUUID", "campUUID", "subUUID")))
 		g.GET("/campaign/:campUUID/:subUUID", noIndex(a.hasUUID(a.ViewCampaignMessage, "campUUID", "subUUID")))
 		g.GET("/campaign/:campUUID/:subUUID/px.png", noIndex(a.hasUUID(a.RegisterCampaignView, "campUUID", "subUUID")))
+		g.GET("/survey/:campUUID", noIndex(a.hasUUID(a.SurveyResponse, "campUUID")))
+		g.GET("/landing/:uuid", a.hasUUID(a.LandingPage, "uuid"))
 
 		if a.cfg.EnablePublicArchive {
 			g.GET("/archive", a.CampaignArchivesPage)
 			g.GET("/archive.xml", a.GetCampaignArchivesFeed)
+			g.GET("/archive/feed.json", a.GetCampaignArchivesJSONFeed)
+			g.GET("/archive/feed.atom", a.GetCampaignArchivesAtomFeed)
 			g.GET("/archive/:id", a.CampaignArchivePage)
+			g.GET("/archive/:id/metadata", a.CampaignArchiveMetadata)
+			g.POST("/archive/:id", a.CampaignArchivePage)
 			g.GET("/archive/latest", a.CampaignArchivePageLatest)
 		}
 
@@ -319,8 +447,42 @@ func (a *App) AdminPage(c echo.Context) error {
 }
 
 // HealthCheck is a healthcheck endpoint that returns a 200 response.
+// With leader election enabled, it also returns the leader status of the cluster.
 func (a *App) HealthCheck(c echo.Context) error {
-	return c.JSON(http.StatusOK, okResp{true})
+	if a.leader == nil {
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	out := struct {
+		Data    bool `json:"data"`
+		Cluster struct {
+			InstanceID string `json:"instance_id"`
+			IsLeader   bool   `json:"is_leader"`
+			Leader     string `json:"leader"`
+		} `json:"cluster"`
+	}{Data: true}
+
+	out.Cluster.InstanceID = a.leader.ID()
+	out.Cluster.IsLeader = a.leader.IsLeader()
+
+	ldr, err := a.leader.Current(c.Request().Context())
+	if err != nil {
+		a.log.Printf("error fetching current leader: %v", err)
+	}
+	out.Cluster.Leader = ldr
+
+	return c.JSON(http.StatusOK, out)
+}
+
+// MediaHealthCheck checks that the media store is functional by writing,
+// reading back, and deleting a test file.
+func (a *App) MediaHealthCheck(c echo.Context) error {
+	if err := a.checkMediaStore(c.Request().Context()); err != nil {
+		a.log.Printf("media store health check failed: %v", err)
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"media": "failed", "error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"media": "ok"})
 }
 
 // RobotsTxt serves the robots.txt file from the static filesystem.
@@ -391,13 +553,13 @@ func hasID(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-// hasSub middleware checks if a subscriber exists given the UUID
-// param in a request.
+// hasSub middleware checks if a subscriber exists given the subscriber
+// token param in a request and sets the subscriber on the context.
+// Legacy links carrying the subscriber UUID are also accepted.
 func (a *App) hasSub(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		subUUID := c.Param("subUUID")
-
-		if _, err := a.core.GetSubscriber(0, subUUID, ""); err != nil {
+		sub, err := a.core.GetSubscriberByToken(c.Param("subUUID"))
+		if err != nil {
 			if er, ok := err.(*echo.HTTPError); ok && er.Code == http.StatusBadRequest {
 				return c.Render(http.StatusNotFound, tplMessage,
 					makeMsgTpl(a.i18n.T("public.notFoundTitle"), "", er.Message.(string)))
@@ -408,6 +570,7 @@ func (a *App) hasSub(next echo.HandlerFunc) echo.HandlerFunc {
 				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorProcessingRequest")))
 		}
 
+		c.Set("sub", sub)
 		return next(c)
 	}
 }
@@ -1,23 +1,46 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// Number of rows returned in an import preview.
+	importPreviewRows = 5
+
+	// How often archived import files past their retention are deleted.
+	importArchivePurgeInterval = time.Hour
+
+	// Name of the lists column in the CSV of Klaviyo profiles.
+	klaviyoListsColumn = "lists"
+)
+
 // ImportSubscribers handles the uploading and bulk importing of
-// a ZIP file of one or more CSV files.
+// a ZIP file of one or more CSV files. With ?dry_run=true, the rows are only
+// validated and a report is returned without importing anything.
 func (a *App) ImportSubscribers(c echo.Context) error {
-	// Is an import already running?
-	if a.importer.GetStats().Status == subimporter.StatusImporting {
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+
+	// Is an import already running? Dry runs don't affect it.
+	if !dryRun && a.importer.GetStats().Status == subimporter.StatusImporting {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.alreadyRunning"))
 	}
 
@@ -28,15 +51,103 @@ func (a *App) ImportSubscribers(c echo.Context) error {
 			a.i18n.Ts("import.invalidParams", "error", err.Error()))
 	}
 
+	if err := a.validateImportOpt(&opt, auth.GetUser(c)); err != nil {
+		return err
+	}
+
+	// Open the HTTP file.
+	file, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidFile", "error", err.Error()))
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Copy it to a temp location.
+	out, err := os.CreateTemp("", "listmonk")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("import.errorCopyingFile", "error", err.Error()))
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, src); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("import.errorCopyingFile", "error", err.Error()))
+	}
+
+	// Validate the rows and return the report without importing them.
+	if dryRun {
+		defer os.Remove(out.Name())
+		return a.dryRunImport(c, opt, file.Filename, out.Name())
+	}
+
+	// Start the importer session.
+	opt.Filename = file.Filename
+	sess, err := a.importer.NewSession(opt)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("import.errorStarting", "error", err.Error()))
+	}
+	go sess.Start()
+
+	if strings.HasSuffix(strings.ToLower(file.Filename), ".csv") {
+		go sess.LoadCSV(out.Name(), rune(opt.Delim[0]))
+	} else {
+		// Only 1 CSV from the ZIP is considered. If multiple files have
+		// to be processed, counting the net number of lines (to track progress),
+		// keeping the global import state (failed / successful) etc. across
+		// multiple files becomes complex. Instead, it's just easier for the
+		// end user to concat multiple CSVs (if there are multiple in the first)
+		// place and upload as one in the first place.
+		dir, files, err := sess.ExtractZIP(out.Name(), 1)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				a.i18n.Ts("import.errorProcessingZIP", "error", err.Error()))
+		}
+
+		go sess.LoadCSV(dir+"/"+files[0], rune(opt.Delim[0]))
+	}
+
+	return c.JSON(http.StatusOK, okResp{a.importer.GetStats()})
+}
+
+// validateImportOpt validates the options of an import and sets their defaults.
+// The lists are filtered by the ones that the user can manage.
+func (a *App) validateImportOpt(opt *subimporter.SessionOpt, user auth.User) error {
 	// Filter list IDs against the current user's permitted lists.
-	// Blocklist mode doesn't require list subscriptions.
-	user := auth.GetUser(c)
+	// Blocklist mode doesn't require list subscriptions. With a lists column,
+	// the lists are optional defaults for rows that don't have lists.
 	opt.ListIDs = user.FilterListsByPerm(auth.PermTypeManage, opt.ListIDs)
-	if len(opt.ListIDs) == 0 && opt.Mode != subimporter.ModeBlocklist {
+	if len(opt.ListIDs) == 0 && opt.Mode != subimporter.ModeBlocklist && opt.ListsColumn == "" {
 		return echo.NewHTTPError(http.StatusForbidden,
 			a.i18n.Ts("globals.messages.permissionDenied", "name", "lists"))
 	}
 
+	// The lists column can't be one of the standard columns.
+	opt.ListsColumn = strings.TrimSpace(opt.ListsColumn)
+	if opt.Mode != subimporter.ModeSubscribe {
+		opt.ListsColumn = ""
+	}
+	switch opt.ListsColumn {
+	case "email", "name", "attributes":
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.invalidListsColumn"))
+	}
+
+	// Lists that the user can manage are the ones that can be referenced
+	// in the lists column. They also name the lists in the import report.
+	getAll, permittedIDs := user.GetPermittedLists(auth.PermTypeManage)
+	lists, err := a.core.GetLists("", "", getAll, permittedIDs)
+	if err != nil {
+		return err
+	}
+	opt.Lists = lists
+
 	// Validate mode.
 	if opt.Mode != subimporter.ModeSubscribe && opt.Mode != subimporter.ModeBlocklist {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.invalidMode"))
@@ -62,7 +173,57 @@ func (a *App) ImportSubscribers(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.invalidDelim"))
 	}
 
-	// Open the HTTP file.
+	// Validate the column mapping, if any.
+	for _, m := range opt.Mapping {
+		if !subimporter.IsValidField(strings.TrimSpace(m.Field)) {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("import.invalidMapping", "name", m.Column))
+		}
+	}
+
+	return nil
+}
+
+// dryRunImport validates the rows in an uploaded CSV or ZIP file and responds with
+// a validation report.
+func (a *App) dryRunImport(c echo.Context, opt subimporter.SessionOpt, filename, path string) error {
+	sess := a.importer.NewDryRunSession(opt)
+
+	// Only the first CSV in a ZIP is considered, like in imports.
+	if !strings.HasSuffix(strings.ToLower(filename), ".csv") {
+		dir, files, err := sess.ExtractZIP(path, 1)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("import.errorProcessingZIP", "error", err.Error()))
+		}
+		defer os.RemoveAll(dir)
+
+		path = dir + "/" + files[0]
+	}
+
+	out, err := sess.DryRun(path, rune(opt.Delim[0]))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidFile", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// PreviewImportSubscribers reads the header and the first few rows of an
+// uploaded CSV or ZIP file and returns the auto-detected mapping of its columns
+// to subscriber fields, which can be adjusted and sent with the import.
+func (a *App) PreviewImportSubscribers(c echo.Context) error {
+	var opt subimporter.SessionOpt
+	if err := json.Unmarshal([]byte(c.FormValue("params")), &opt); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidParams", "error", err.Error()))
+	}
+
+	if len(opt.Delim) != 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.invalidDelim"))
+	}
+
 	file, err := c.FormFile("file")
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest,
@@ -81,6 +242,7 @@ func (a *App) ImportSubscribers(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			a.i18n.Ts("import.errorCopyingFile", "error", err.Error()))
 	}
+	defer os.Remove(out.Name())
 	defer out.Close()
 
 	if _, err = io.Copy(out, src); err != nil {
@@ -88,34 +250,26 @@ func (a *App) ImportSubscribers(c echo.Context) error {
 			a.i18n.Ts("import.errorCopyingFile", "error", err.Error()))
 	}
 
-	// Start the importer session.
-	opt.Filename = file.Filename
-	sess, err := a.importer.NewSession(opt)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			a.i18n.Ts("import.errorStarting", "error", err.Error()))
-	}
-	go sess.Start()
-
-	if strings.HasSuffix(strings.ToLower(file.Filename), ".csv") {
-		go sess.LoadCSV(out.Name(), rune(opt.Delim[0]))
-	} else {
-		// Only 1 CSV from the ZIP is considered. If multiple files have
-		// to be processed, counting the net number of lines (to track progress),
-		// keeping the global import state (failed / successful) etc. across
-		// multiple files becomes complex. Instead, it's just easier for the
-		// end user to concat multiple CSVs (if there are multiple in the first)
-		// place and upload as one in the first place.
-		dir, files, err := sess.ExtractZIP(out.Name(), 1)
+	// Only the first CSV in a ZIP is considered, like in imports.
+	path := out.Name()
+	if !strings.HasSuffix(strings.ToLower(file.Filename), ".csv") {
+		dir, files, err := a.importer.NewDryRunSession(opt).ExtractZIP(path, 1)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError,
+			return echo.NewHTTPError(http.StatusBadRequest,
 				a.i18n.Ts("import.errorProcessingZIP", "error", err.Error()))
 		}
+		defer os.RemoveAll(dir)
 
-		go sess.LoadCSV(dir+"/"+files[0], rune(opt.Delim[0]))
+		path = dir + "/" + files[0]
 	}
 
-	return c.JSON(http.StatusOK, okResp{a.importer.GetStats()})
+	prev, err := subimporter.PreviewCSV(path, rune(opt.Delim[0]), strings.TrimSpace(opt.ListsColumn), importPreviewRows)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidFile", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{prev})
 }
 
 // GetImportSubscribers returns import statistics.
@@ -129,6 +283,35 @@ func (a *App) GetImportSubscriberStats(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{string(a.importer.GetLogs())})
 }
 
+// GetImportSubscriberErrors returns the CSV file of rows that failed to
+// import in the last import, with the reason in an additional column.
+func (a *App) GetImportSubscriberErrors(c echo.Context) error {
+	path, ok := a.importer.GetErrorFile()
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, a.i18n.T("import.noErrorFile"))
+	}
+
+	return c.Attachment(path, "import-errors.csv")
+}
+
+// GetImportArchive downloads the archived original (gzipped) CSV file of an import.
+func (a *App) GetImportArchive(c echo.Context) error {
+	arc, err := a.core.GetImportArchive(getID(c))
+	if err != nil {
+		return err
+	}
+
+	b, err := a.media.GetBlob(arc.Path)
+	if err != nil {
+		a.log.Printf("error fetching import archive %s: %v", arc.Path, err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{import.archive}", "error", err.Error()))
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="import-%d.csv.gz"`, arc.ID))
+	return c.Blob(http.StatusOK, "application/gzip", b)
+}
+
 // StopImportSubscribers sends a stop signal to the importer.
 // If there's an ongoing import, it'll be stopped, and if an import
 // is finished, it's state is cleared.
@@ -136,3 +319,362 @@ func (a *App) StopImportSubscribers(c echo.Context) error {
 	a.importer.Stop()
 	return c.JSON(http.StatusOK, okResp{a.importer.GetStats()})
 }
+
+// ImportGoogleSheet handles the importing of subscribers from a range (eg: Sheet1!A1:D1000)
+// of a Google Sheets spreadsheet that's shared with the configured service account. The
+// first row of the range is the header. With "preview": true, the auto-detected mapping
+// of the columns and the first few rows are returned without importing anything.
+func (a *App) ImportGoogleSheet(c echo.Context) error {
+	if !a.cfg.Security.GoogleSheets.Enabled {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.googleSheetsDisabled"))
+	}
+
+	var req struct {
+		subimporter.SessionOpt
+
+		URL     string `json:"url"`
+		Range   string `json:"range"`
+		Preview bool   `json:"preview"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidParams", "error", err.Error()))
+	}
+
+	sheetID, err := subimporter.ParseSheetID(req.URL)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "url"))
+	}
+	req.Range = strings.TrimSpace(req.Range)
+	if !strHasLen(req.Range, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "range"))
+	}
+
+	// Is an import already running? Previews don't affect it.
+	if !req.Preview && a.importer.GetStats().Status == subimporter.StatusImporting {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.alreadyRunning"))
+	}
+
+	// Fetch the rows from the sheet.
+	gs, err := subimporter.NewGoogleSheets([]byte(a.cfg.Security.GoogleSheets.Credentials))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("import.errorGoogleSheets", "error", err.Error()))
+	}
+	rows, err := gs.Fetch(sheetID, req.Range)
+	if err != nil {
+		a.log.Printf("error fetching google sheet %s: %v", sheetID, err)
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.errorGoogleSheets", "error", err.Error()))
+	}
+
+	if req.Preview {
+		return c.JSON(http.StatusOK, okResp{subimporter.PreviewRows(rows, strings.TrimSpace(req.ListsColumn), importPreviewRows)})
+	}
+
+	// The rows are imported as a CSV.
+	opt := req.SessionOpt
+	opt.Delim = ","
+	if err := a.validateImportOpt(&opt, auth.GetUser(c)); err != nil {
+		return err
+	}
+
+	path, err := subimporter.WriteCSV(rows)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("import.errorCopyingFile", "error", err.Error()))
+	}
+
+	// Start the importer session.
+	opt.Filename = req.Range
+	sess, err := a.importer.NewSession(opt)
+	if err != nil {
+		os.Remove(path)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("import.errorStarting", "error", err.Error()))
+	}
+	// The file is removed only after the import is done as it may be archived.
+	go func() {
+		sess.Start()
+		os.Remove(path)
+	}()
+	go sess.LoadCSV(path, ',')
+
+	return c.JSON(http.StatusOK, okResp{a.importer.GetStats()})
+}
+
+// MigrateKlaviyo imports the profiles in a Klaviyo list as subscribers with a Klaviyo
+// private API key. Without a list_id, the available Klaviyo lists and segments are
+// returned. Profiles in the given segment_ids are also subscribed to lists named after
+// the segments, which are created if they don't exist. Profiles that have unsubscribed
+// from e-mail marketing are blocklisted. The profiles are fetched and imported in the
+// background.
+func (a *App) MigrateKlaviyo(c echo.Context) error {
+	var req struct {
+		subimporter.SessionOpt
+
+		APIKey     string   `json:"api_key"`
+		ListID     string   `json:"list_id"`
+		SegmentIDs []string `json:"segment_ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidParams", "error", err.Error()))
+	}
+
+	k, err := subimporter.NewKlaviyo(req.APIKey)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "api_key"))
+	}
+
+	// Without a list, return the lists and segments to pick from.
+	req.ListID = strings.TrimSpace(req.ListID)
+	if req.ListID == "" {
+		lists, err := k.Lists()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("import.errorKlaviyo", "error", err.Error()))
+		}
+		segs, err := k.Segments()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("import.errorKlaviyo", "error", err.Error()))
+		}
+
+		return c.JSON(http.StatusOK, okResp{struct {
+			Lists    []subimporter.KlaviyoGroup `json:"lists"`
+			Segments []subimporter.KlaviyoGroup `json:"segments"`
+		}{lists, segs}})
+	}
+
+	// Is an import already running?
+	if a.importer.GetStats().Status == subimporter.StatusImporting {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.alreadyRunning"))
+	}
+
+	// Mapping segments may create lists.
+	user := auth.GetUser(c)
+	if len(req.SegmentIDs) > 0 && !user.HasPerm(auth.PermListManageAll) {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", "lists:manage_all"))
+	}
+
+	// The profiles are imported as a CSV with the segment lists in a lists column.
+	opt := req.SessionOpt
+	opt.Mode = subimporter.ModeSubscribe
+	opt.Delim = ","
+	opt.ListsColumn = ""
+	if len(req.SegmentIDs) > 0 {
+		opt.ListsColumn = klaviyoListsColumn
+	}
+	opt.Mapping = nil
+	if err := a.validateImportOpt(&opt, user); err != nil {
+		return err
+	}
+
+	go a.importKlaviyo(k, req.ListID, req.SegmentIDs, opt)
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// importKlaviyo fetches the profiles in a Klaviyo list and the memberships of the given
+// segments, imports the profiles, and blocklists the ones that have unsubscribed.
+func (a *App) importKlaviyo(k *subimporter.Klaviyo, listID string, segIDs []string, opt subimporter.SessionOpt) {
+	profiles, err := k.ListProfiles(listID)
+	if err != nil {
+		a.log.Printf("error fetching klaviyo list %s: %v", listID, err)
+		return
+	}
+
+	// Name the import after the Klaviyo list.
+	opt.Filename = "klaviyo:" + listID
+	if lists, err := k.Lists(); err == nil {
+		for _, l := range lists {
+			if l.ID == listID {
+				opt.Filename = "klaviyo:" + l.Name
+			}
+		}
+	}
+
+	// Map the segments to lists by their names, creating the ones that don't exist.
+	profLists := map[string][]string{}
+	if len(segIDs) > 0 {
+		segs, err := k.Segments()
+		if err != nil {
+			a.log.Printf("error fetching klaviyo segments: %v", err)
+			return
+		}
+
+		for _, s := range segs {
+			if !slices.Contains(segIDs, s.ID) {
+				continue
+			}
+
+			id, err := a.getKlaviyoSegmentList(s.Name, &opt)
+			if err != nil {
+				return
+			}
+
+			members, err := k.SegmentProfileIDs(s.ID)
+			if err != nil {
+				a.log.Printf("error fetching klaviyo segment %s: %v", s.ID, err)
+				return
+			}
+			for p := range members {
+				profLists[p] = append(profLists[p], strconv.Itoa(id))
+			}
+		}
+	}
+
+	// Profiles in segments are subscribed to the segment lists in addition to the lists
+	// in the import. The rest have an empty lists column and get the import's lists.
+	var defLists []string
+	for _, id := range opt.ListIDs {
+		defLists = append(defLists, strconv.Itoa(id))
+	}
+
+	var (
+		hdr    = []string{"email", "name", "attributes"}
+		unsubs []string
+	)
+	if opt.ListsColumn != "" {
+		hdr = append(hdr, opt.ListsColumn)
+	}
+	rows := [][]string{hdr}
+	for _, p := range profiles {
+		b, err := json.Marshal(p.Attribs)
+		if err != nil {
+			b = []byte("{}")
+		}
+
+		row := []string{p.Email, p.Name, string(b)}
+		if opt.ListsColumn != "" {
+			var l string
+			if ids, ok := profLists[p.ID]; ok {
+				l = strings.Join(append(slices.Clone(defLists), ids...), ",")
+			}
+			row = append(row, l)
+		}
+		rows = append(rows, row)
+
+		if p.Unsubscribed {
+			unsubs = append(unsubs, strings.ToLower(strings.TrimSpace(p.Email)))
+		}
+	}
+
+	path, err := subimporter.WriteCSV(rows)
+	if err != nil {
+		a.log.Printf("error writing klaviyo profiles: %v", err)
+		return
+	}
+	defer os.Remove(path)
+
+	sess, err := a.importer.NewSession(opt)
+	if err != nil {
+		a.log.Printf("error starting klaviyo import: %v", err)
+		return
+	}
+	go sess.LoadCSV(path, ',')
+	sess.Start()
+
+	// Blocklist the profiles that have unsubscribed once they've been imported.
+	if len(unsubs) == 0 || a.importer.GetStats().Status != subimporter.StatusFinished {
+		return
+	}
+	n, err := a.core.BlocklistSubscribersByEmail(unsubs)
+	if err != nil {
+		return
+	}
+	a.log.Printf("blocklisted %d unsubscribed klaviyo profiles", n)
+}
+
+// getKlaviyoSegmentList returns the ID of the list (that can be imported into) named
+// after a Klaviyo segment. If there's no such list, a private list is created.
+func (a *App) getKlaviyoSegmentList(name string, opt *subimporter.SessionOpt) (int, error) {
+	for _, l := range opt.Lists {
+		if strings.EqualFold(l.Name, name) {
+			return l.ID, nil
+		}
+	}
+
+	l, err := a.core.CreateList(models.List{
+		Name:  name,
+		Type:  models.ListTypePrivate,
+		Optin: models.ListOptinSingle,
+		Tags:  []string{"klaviyo"},
+	})
+	if err != nil {
+		return 0, err
+	}
+	opt.Lists = append(opt.Lists, l)
+
+	return l.ID, nil
+}
+
+// archiveImport stores a gzipped copy of the original CSV file of an import
+// in the media store under imports/ and records it. It returns the ID of the archive.
+func archiveImport(co *core.Core, store media.Store, filename, srcPath string) (int, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	// Compress to a temp file as the store requires a seekable reader.
+	f, err := os.CreateTemp("", "listmonk-import-*.csv.gz")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	gz := gzip.NewWriter(f)
+	if _, err := io.Copy(gz, src); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	arc, err := co.CreateImportArchive(filename, media.ImportsDir, size)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := store.Put(context.Background(), arc.Path, "application/gzip", f); err != nil {
+		_ = co.DeleteImportArchive(arc.ID)
+		return 0, fmt.Errorf("error uploading import archive: %w", err)
+	}
+
+	return arc.ID, nil
+}
+
+// purgeImportArchives deletes the archived import files that are older than
+// the given number of days from the media store.
+func purgeImportArchives(co *core.Core, store media.Store, days int) {
+	arcs, err := co.GetExpiredImportArchives(days)
+	if err != nil {
+		return
+	}
+
+	for _, arc := range arcs {
+		if err := store.Delete(context.Background(), arc.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			lo.Printf("error deleting import archive %s: %v", arc.Path, err)
+			continue
+		}
+
+		if err := co.DeleteImportArchive(arc.ID); err != nil {
+			return
+		}
+		lo.Printf("deleted expired import archive %s", arc.Path)
+	}
+}
@@ -13,12 +13,16 @@ import (
 	"log/slog"
 	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -34,20 +38,28 @@ import (
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/providers/posflag"
 	"github.com/knadh/koanf/v2"
+	"github.com/knadh/listmonk/internal/attribcrypt"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/backup"
 	"github.com/knadh/listmonk/internal/bounce"
 	"github.com/knadh/listmonk/internal/bounce/mailbox"
+	"github.com/knadh/listmonk/internal/campgate"
 	"github.com/knadh/listmonk/internal/captcha"
 	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/events"
 	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/leader"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/internal/media/providers/filesystem"
 	"github.com/knadh/listmonk/internal/media/providers/s3"
+	"github.com/knadh/listmonk/internal/messenger/capture"
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/internal/messenger/postback"
 	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/spellcheck"
 	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
 	"github.com/knadh/stuffbin"
 	"github.com/labstack/echo/v4"
@@ -75,6 +87,7 @@ type UrlConfig struct {
 	OptinURL     string
 	MessageURL   string
 	ArchiveURL   string
+	SurveyURL    string
 }
 
 // Config contains static, constant config values required by arbitrary handlers and functions.
@@ -86,20 +99,55 @@ type Config struct {
 	EnablePublicArchive           bool     `koanf:"enable_public_archive"`
 	EnablePublicArchiveRSSContent bool     `koanf:"enable_public_archive_rss_content"`
 	ShowOptinPage                 bool     `koanf:"show_optin_page"`
+	ConfirmCampaignStart          bool     `koanf:"confirm_campaign_start"`
+	EnforceListSendLimits         bool     `koanf:"enforce_list_send_limits"`
 	Lang                          string   `koanf:"lang"`
 	DBBatchSize                   int      `koanf:"batch_size"`
-	Privacy                       struct {
-		IndividualTracking bool            `koanf:"individual_tracking"`
-		DisableTracking    bool            `koanf:"disable_tracking"`
-		AllowPreferences   bool            `koanf:"allow_preferences"`
-		AllowBlocklist     bool            `koanf:"allow_blocklist"`
-		AllowExport        bool            `koanf:"allow_export"`
-		AllowWipe          bool            `koanf:"allow_wipe"`
-		RecordOptinIP      bool            `koanf:"record_optin_ip"`
-		UnsubHeader        bool            `koanf:"unsubscribe_header"`
-		Exportable         map[string]bool `koanf:"-"`
-		DomainBlocklist    []string        `koanf:"-"`
-		DomainAllowlist    []string        `koanf:"-"`
+	TemplateMaxBodyBytes          int      `koanf:"template_max_body_bytes"`
+	CampaignMinifyHTML            bool     `koanf:"campaign_minify_html"`
+
+	// Maximum number of campaigns that run concurrently (0 for no limit) and the delay
+	// after which campaigns queued at the limit are retried.
+	MaxConcurrentCampaigns int           `koanf:"max_concurrent_campaigns"`
+	CampaignQueueDelay     time.Duration `koanf:"campaign_queue_delay"`
+
+	// Default UTM template of campaigns that don't have one.
+	UTMTemplate models.UTMTemplate `koanf:"utm_template"`
+
+	// Severities of the template lint rules.
+	TemplateLintRules map[string]string `koanf:"template_lint_rules"`
+
+	Privacy struct {
+		IndividualTracking bool `koanf:"individual_tracking"`
+		DisableTracking    bool `koanf:"disable_tracking"`
+		AllowPreferences   bool `koanf:"allow_preferences"`
+		AllowBlocklist     bool `koanf:"allow_blocklist"`
+		AllowExport        bool `koanf:"allow_export"`
+		AllowWipe          bool `koanf:"allow_wipe"`
+		RecordOptinIP      bool `koanf:"record_optin_ip"`
+		UnsubHeader        bool `koanf:"unsubscribe_header"`
+
+		// Template (eg: t-{isp}.example.com) of the per-ISP tracking domains of view tracking pixels.
+		ISPTrackingDomain string `koanf:"isp_tracking_domain"`
+
+		// What deleting a subscriber does (models.PrivacyMode*).
+		Mode string `koanf:"mode"`
+
+		// Days after unsubscribing from all lists during which a subscriber can't
+		// be re-subscribed. 0 disables the protection.
+		ResubscribeProtectionDays int             `koanf:"resubscribe_protection_days"`
+		Exportable                map[string]bool `koanf:"-"`
+		DomainBlocklist           []string        `koanf:"-"`
+		DomainAllowlist           []string        `koanf:"-"`
+
+		// Subscriber attributes exposed on the public preference page and APIs.
+		PublicAttribs models.AttribFilter `koanf:"-"`
+
+		Journal struct {
+			Enabled bool   `koanf:"enabled"`
+			Address string `koanf:"address"`
+			Tx      bool   `koanf:"tx"`
+		} `koanf:"journal"`
 	} `koanf:"privacy"`
 	Security struct {
 		OIDC struct {
@@ -126,6 +174,24 @@ type Config struct {
 		} `koanf:"captcha"`
 
 		TrustedURLs []string `koanf:"trusted_urls"`
+
+		// ArchiveKey signs the cookies that unlock password protected archive pages.
+		ArchiveKey string `koanf:"archive_key"`
+
+		// SubStatusKey is the HMAC key of the e-mail hashes with which the status of
+		// public subscriptions is polled.
+		SubStatusKey string `koanf:"sub_status_key"`
+
+		SubscriberFeed struct {
+			Enabled bool   `koanf:"enabled"`
+			Token   string `koanf:"token"`
+		} `koanf:"subscriber_feed"`
+
+		// Google Cloud service account JSON key for importing subscribers from Google Sheets.
+		GoogleSheets struct {
+			Enabled     bool   `koanf:"enabled"`
+			Credentials string `koanf:"credentials"`
+		} `koanf:"google_sheets"`
 	} `koanf:"security"`
 
 	Appearance struct {
@@ -139,8 +205,9 @@ type Config struct {
 	AssetVersion  string
 
 	MediaUpload struct {
-		Provider   string
-		Extensions []string
+		Provider         string
+		Extensions       []string
+		MaxArchiveSizeMB int
 	}
 
 	BounceWebhooksEnabled     bool
@@ -176,6 +243,7 @@ func initFlags(ko *koanf.Koanf) {
 	f.String("i18n-dir", "", "(optional) path to directory with i18n language files")
 	f.Bool("yes", false, "assume 'yes' to prompts during --install/upgrade")
 	f.Bool("passive", false, "run in passive mode where campaigns are not processed")
+	f.Bool("encrypt-attribs", false, "encrypt the attributes of existing subscribers in lists flagged as sensitive")
 	if err := f.Parse(os.Args[1:]); err != nil {
 		lo.Fatalf("error loading flags: %v", err)
 	}
@@ -222,6 +290,7 @@ func initFS(appDir, frontendDir, staticDir, i18nDir string) stuffbin.FileSystem
 		staticFiles = []string{
 			// These paths are joined with staticDir.
 			"./email-templates:static/email-templates",
+			"./spellcheck:static/spellcheck",
 			"./public:/public",
 		}
 
@@ -309,25 +378,45 @@ func initFS(appDir, frontendDir, staticDir, i18nDir string) stuffbin.FileSystem
 	return fs
 }
 
-// initDB initializes the main DB connection pool and parse and loads the app's
-// SQL queries into a prepared query map.
-func initDB() *sqlx.DB {
+// initDB initializes the main DB connection pool and returns it along with
+// the DSN that's used for dedicated (streaming) connections.
+//
+// Queries on the pool are cancelled by Postgres after db.query_timeout_seconds
+// (statement_timeout). Export and import jobs, and the streaming connections,
+// are allowed the longer db.export_timeout_seconds.
+func initDB() (*sqlx.DB, string) {
 	var c struct {
-		Host        string        `koanf:"host"`
-		Port        int           `koanf:"port"`
-		User        string        `koanf:"user"`
-		Password    string        `koanf:"password"`
-		DBName      string        `koanf:"database"`
-		SSLMode     string        `koanf:"ssl_mode"`
-		Params      string        `koanf:"params"`
-		MaxOpen     int           `koanf:"max_open"`
-		MaxIdle     int           `koanf:"max_idle"`
-		MaxLifetime time.Duration `koanf:"max_lifetime"`
+		Host          string        `koanf:"host"`
+		Port          int           `koanf:"port"`
+		User          string        `koanf:"user"`
+		Password      string        `koanf:"password"`
+		DBName        string        `koanf:"database"`
+		SSLMode       string        `koanf:"ssl_mode"`
+		Params        string        `koanf:"params"`
+		MaxOpen       int           `koanf:"max_open"`
+		MaxIdle       int           `koanf:"max_idle"`
+		MaxLifetime   time.Duration `koanf:"max_lifetime"`
+		QueryTimeout  int           `koanf:"query_timeout_seconds"`
+		ExportTimeout int           `koanf:"export_timeout_seconds"`
 	}
 	if err := ko.Unmarshal("db", &c); err != nil {
 		lo.Fatalf("error loading db config: %v", err)
 	}
 
+	// Timeouts default to 30s and 30m when they're not configured. 0 disables them.
+	if !ko.Exists("db.query_timeout_seconds") {
+		c.QueryTimeout = 30
+	}
+	if !ko.Exists("db.export_timeout_seconds") {
+		c.ExportTimeout = 1800
+	}
+
+	// Schema installation and migrations can run long on large databases.
+	if ko.Bool("install") || ko.Bool("upgrade") {
+		c.QueryTimeout = 0
+	}
+	dbExportTimeout = time.Duration(c.ExportTimeout) * time.Second
+
 	lo.Printf("connecting to db: %s:%d/%s", c.Host, c.Port, c.DBName)
 
 	// Build Postgres DSN conditionally with non-empty fields.
@@ -356,7 +445,10 @@ func initDB() *sqlx.DB {
 		parts = append(parts, c.Params)
 	}
 
-	db, err := sqlx.Connect("postgres", strings.Join(parts, " "))
+	dsn := strings.Join(parts, " ")
+
+	// Unknown DSN params are sent to Postgres as session parameters by the drivers.
+	db, err := sqlx.Connect("postgres", fmt.Sprintf("%s statement_timeout=%d", dsn, c.QueryTimeout*1000))
 	if err != nil {
 		lo.Fatalf("error connecting to DB: %v", err)
 	}
@@ -365,7 +457,7 @@ func initDB() *sqlx.DB {
 	db.SetMaxIdleConns(c.MaxIdle)
 	db.SetConnMaxLifetime(c.MaxLifetime)
 
-	return db.Unsafe()
+	return db.Unsafe(), fmt.Sprintf("%s statement_timeout=%d", dsn, c.ExportTimeout*1000)
 }
 
 func readQueries(dir string, fs stuffbin.FileSystem) goyesql.Queries {
@@ -482,6 +574,9 @@ func initUrlConfig(ko *koanf.Koanf) *UrlConfig {
 
 		// url.com/campaign/{campaign_uuid}/{subscriber_uuid}/px.png
 		ViewTrackURL: fmt.Sprintf("%s/campaign/%%s/%%s/px.png", root),
+
+		// url.com/survey/{campaign_uuid}
+		SurveyURL: fmt.Sprintf("%s/survey/%%s", root),
 	}
 }
 
@@ -504,11 +599,16 @@ func initConstConfig(ko *koanf.Koanf) *Config {
 	}
 
 	c.Lang = ko.String("app.lang")
+
+	// Delimiters of template expressions in campaign bodies.
+	models.SetTemplateDelims(ko.String("app.template_left_delim"), ko.String("app.template_right_delim"))
 	c.Privacy.Exportable = koanfmaps.StringSliceToLookupMap(ko.Strings("privacy.exportable"))
 	c.MediaUpload.Provider = ko.String("upload.provider")
 	c.MediaUpload.Extensions = ko.Strings("upload.extensions")
+	c.MediaUpload.MaxArchiveSizeMB = ko.Int("upload.max_archive_size_mb")
 	c.Privacy.DomainBlocklist = ko.Strings("privacy.domain_blocklist")
 	c.Privacy.DomainAllowlist = ko.Strings("privacy.domain_allowlist")
+	c.Privacy.PublicAttribs = initAttribFilter("privacy.public_attribs", ko)
 
 	c.BounceWebhooksEnabled = ko.Bool("bounce.webhooks_enabled")
 	c.BounceSESEnabled = ko.Bool("bounce.ses_enabled")
@@ -569,9 +669,12 @@ func initCore(fnNotify func(sub models.Subscriber, listIDs []int) (int, error),
 		Constants: core.Constants{
 			SendOptinConfirmation: ko.Bool("app.send_optin_confirmation"),
 			CacheSlowQueries:      ko.Bool("app.cache_slow_queries"),
+			ExportTimeout:         dbExportTimeout,
+			LegacyUUIDLinksUntil:  initLegacyUUIDLinksUntil(ko),
 		},
 		Queries: queries,
 		DB:      db,
+		DSN:     dbDSN,
 		I18n:    i,
 		Log:     lo,
 	}
@@ -581,9 +684,20 @@ func initCore(fnNotify func(sub models.Subscriber, listIDs []int) (int, error),
 		lo.Fatalf("error unmarshalling bounce config: %v", err)
 	}
 
+	// Key for encrypting the attributes of subscribers in sensitive lists.
+	ci, err := attribcrypt.New(ko.String("secrets.attribs_key"))
+	if err != nil {
+		lo.Fatalf("error initializing attribute encryption: %v", err)
+	}
+	opt.AttribCipher = ci
+
 	// Initialize the CRUD core.
 	return core.New(opt, &core.Hooks{
 		SendOptinConfirmation: fnNotify,
+		SubscriberEvent:       makeSubscriberEventPublisher(evStream),
+		HasSubscriberEventListeners: func() bool {
+			return evStream.HasSubscribers(events.TypeSubscriber)
+		},
 	})
 }
 
@@ -594,26 +708,38 @@ func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlCon
 	}
 
 	mgr := manager.New(manager.Config{
-		BatchSize:             ko.Int("app.batch_size"),
-		Concurrency:           ko.Int("app.concurrency"),
-		MessageRate:           ko.Int("app.message_rate"),
-		MaxSendErrors:         ko.Int("app.max_send_errors"),
-		FromEmail:             ko.String("app.from_email"),
-		IndividualTracking:    ko.Bool("privacy.individual_tracking"),
-		DisableTracking:       ko.Bool("privacy.disable_tracking"),
-		UnsubURL:              u.UnsubURL,
-		OptinURL:              u.OptinURL,
-		LinkTrackURL:          u.LinkTrackURL,
-		ViewTrackURL:          u.ViewTrackURL,
-		MessageURL:            u.MessageURL,
-		ArchiveURL:            u.ArchiveURL,
-		RootURL:               u.RootURL,
-		UnsubHeader:           ko.Bool("privacy.unsubscribe_header"),
-		SlidingWindow:         ko.Bool("app.message_sliding_window"),
-		SlidingWindowDuration: ko.Duration("app.message_sliding_window_duration"),
-		SlidingWindowRate:     ko.Int("app.message_sliding_window_rate"),
-		ScanInterval:          time.Second * 5,
-		ScanCampaigns:         !ko.Bool("passive"),
+		BatchSize:              ko.Int("app.batch_size"),
+		Concurrency:            ko.Int("app.concurrency"),
+		MessageRate:            ko.Int("app.message_rate"),
+		MaxSendErrors:          ko.Int("app.max_send_errors"),
+		FromEmail:              ko.String("app.from_email"),
+		IndividualTracking:     ko.Bool("privacy.individual_tracking"),
+		DisableTracking:        ko.Bool("privacy.disable_tracking"),
+		UnsubURL:               u.UnsubURL,
+		OptinURL:               u.OptinURL,
+		LinkTrackURL:           u.LinkTrackURL,
+		ViewTrackURL:           u.ViewTrackURL,
+		SurveyURL:              u.SurveyURL,
+		MessageURL:             u.MessageURL,
+		ArchiveURL:             u.ArchiveURL,
+		RootURL:                u.RootURL,
+		UnsubHeader:            ko.Bool("privacy.unsubscribe_header"),
+		ISPTrackingDomain:      ko.String("privacy.isp_tracking_domain"),
+		MinifyHTML:             ko.Bool("app.campaign_minify_html"),
+		TemplateAttribs:        initAttribFilter("privacy.template_attribs", ko),
+		UnsubMailto:            initUnsubMailto(ko),
+		UnsubMailtoKey:         []byte(ko.String("security.unsubscribe_mailto_key")),
+		JournalAddress:         initJournalAddress(ko),
+		JournalMode:            ko.String("privacy.journal.mode"),
+		SlidingWindow:          ko.Bool("app.message_sliding_window"),
+		SlidingWindowDuration:  ko.Duration("app.message_sliding_window_duration"),
+		SlidingWindowRate:      ko.Int("app.message_sliding_window_rate"),
+		AlertErrorThreshold:    ko.Int("notifications.events.campaign_failure.threshold"),
+		MaxConcurrentCampaigns: ko.Int("app.max_concurrent_campaigns"),
+		CampaignQueueDelay:     ko.Duration("app.campaign_queue_delay"),
+		WarmupSchedules:        initWarmupSchedules(ko),
+		ScanInterval:           time.Second * 5,
+		ScanCampaigns:          !ko.Bool("passive"),
 	}, newManagerStore(q, co, md), i, lo)
 
 	// Attach all messengers to the campaign manager.
@@ -624,6 +750,120 @@ func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlCon
 	return mgr
 }
 
+// initWarmupSchedules returns the warm-up schedules of the enabled messengers keyed
+// by the messenger name. The schedule of an SMTP server applies to its standalone
+// messenger, or to the default "email" messenger if the server has no name or is
+// the only server.
+func initWarmupSchedules(ko *koanf.Koanf) map[string][]models.WarmupStep {
+	var (
+		out     = map[string][]models.WarmupStep{}
+		servers = 0
+	)
+	for _, item := range ko.Slices("smtp") {
+		if item.Bool("enabled") {
+			servers++
+		}
+	}
+
+	add := func(name string, item *koanf.Koanf) {
+		var steps []models.WarmupStep
+		if err := item.UnmarshalWithConf("warmup_schedule", &steps, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading warm-up schedule of messenger %s: %v", name, err)
+		}
+		if len(steps) == 0 {
+			return
+		}
+
+		// The first schedule for a messenger applies.
+		if _, ok := out[name]; ok {
+			return
+		}
+
+		sort.Slice(steps, func(i, j int) bool { return steps[i].Day < steps[j].Day })
+		out[name] = steps
+		lo.Printf("messenger %s is on a %d day warm-up schedule", name, steps[len(steps)-1].Day)
+	}
+
+	for _, item := range ko.Slices("smtp") {
+		if !item.Bool("enabled") || item.Bool("shadow_mode") {
+			continue
+		}
+
+		name := item.String("name")
+		if name == "" || servers == 1 {
+			name = email.MessengerName
+		}
+		add(name, item)
+	}
+
+	for _, item := range ko.Slices("messengers") {
+		if item.Bool("enabled") {
+			add(item.String("name"), item)
+		}
+	}
+
+	return out
+}
+
+// initUnsubMailto returns the List-Unsubscribe mailto: address if it's enabled and
+// can actually be processed, ie, the bounce mailbox that receives it is enabled.
+func initUnsubMailto(ko *koanf.Koanf) string {
+	if !ko.Bool("privacy.unsubscribe_header") || !ko.Bool("privacy.unsubscribe_mailto.enabled") {
+		return ""
+	}
+
+	if !ko.Bool("bounce.enabled") || !hasBounceMailbox(ko) {
+		lo.Println("WARNING: List-Unsubscribe mailto: is enabled but there is no enabled bounce mailbox to process it. Ignoring.")
+		return ""
+	}
+
+	if ko.String("security.unsubscribe_mailto_key") == "" {
+		lo.Println("WARNING: List-Unsubscribe mailto: is enabled but the signing key is missing. Ignoring.")
+		return ""
+	}
+
+	return ko.String("privacy.unsubscribe_mailto.address")
+}
+
+// initAttribFilter returns the subscriber attribute filter at the given config key.
+func initAttribFilter(key string, ko *koanf.Koanf) models.AttribFilter {
+	return models.AttribFilter{
+		Mode: ko.String(key + ".mode"),
+		Keys: ko.Strings(key + ".keys"),
+	}
+}
+
+// initEmailPatterns compiles the e-mail regexp patterns at the given config key.
+// Patterns are matched case insensitively. Invalid patterns are skipped.
+func initEmailPatterns(key string, ko *koanf.Koanf) []*regexp.Regexp {
+	var out []*regexp.Regexp
+	for _, p := range ko.Strings(key) {
+		re, err := compileEmailPattern(p)
+		if err != nil {
+			lo.Printf("error compiling e-mail pattern in %s (%s): %v", key, p, err)
+			continue
+		}
+		out = append(out, re)
+	}
+
+	return out
+}
+
+// compileEmailPattern compiles an e-mail regexp pattern to be matched case insensitively.
+func compileEmailPattern(p string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + p)
+}
+
+// initJournalAddress returns the global address to which copies of messages
+// are journaled if journaling is enabled.
+func initJournalAddress(ko *koanf.Koanf) string {
+	if !ko.Bool("privacy.journal.enabled") {
+		return ""
+	}
+
+	return ko.String("privacy.journal.address")
+}
+
 // initTxTemplates initializes and compiles the transactional templates and caches them in-memory.
 func initTxTemplates(m *manager.Manager, co *core.Core) {
 	tpls, err := co.GetTemplates(models.TemplateTypeTx, false)
@@ -641,15 +881,57 @@ func initTxTemplates(m *manager.Manager, co *core.Core) {
 	}
 }
 
+// initLegacyUUIDLinksUntil returns the end of the day (privacy.legacy_uuid_links_until)
+// until which subscriber UUIDs in the links in old messages are accepted, or zero.
+func initLegacyUUIDLinksUntil(ko *koanf.Koanf) time.Time {
+	s := ko.String("privacy.legacy_uuid_links_until")
+	if s == "" {
+		return time.Time{}
+	}
+
+	d, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		lo.Printf("error parsing privacy.legacy_uuid_links_until: %v", err)
+		return time.Time{}
+	}
+
+	return d.AddDate(0, 0, 1)
+}
+
 // initImporter initializes the bulk subscriber importer.
-func initImporter(q *models.Queries, db *sqlx.DB, core *core.Core, i *i18n.I18n, ko *koanf.Koanf) *subimporter.Importer {
+func initImporter(q *models.Queries, db *sqlx.DB, core *core.Core, store media.Store, i *i18n.I18n, ko *koanf.Koanf) *subimporter.Importer {
+	// Hook for archiving the original files of successful imports in the media store.
+	var archiveCB func(filename, path string) (int, error)
+	if ko.Bool("app.archive_imports") {
+		archiveCB = func(filename, path string) (int, error) {
+			return archiveImport(core, store, filename, path)
+		}
+	}
+
 	return subimporter.New(
 		subimporter.Options{
 			DomainBlocklist:    ko.Strings("privacy.domain_blocklist"),
 			DomainAllowlist:    ko.Strings("privacy.domain_allowlist"),
+			StrictASCIIEmail:   ko.Bool("privacy.strict_ascii_email"),
 			UpsertStmt:         q.UpsertSubscriber.Stmt,
 			BlocklistStmt:      q.UpsertBlocklistSubscriber.Stmt,
 			UpdateListDateStmt: q.UpdateListsDate.Stmt,
+			BatchSize:          ko.Int("app.import_batch_size"),
+			Timeout:            dbExportTimeout,
+			ErrorFileMaxSize:   ko.Int64("app.import_error_file_size") * 1024 * 1024,
+
+			EmailDenylistPatterns:  initEmailPatterns("privacy.email_denylist_patterns", ko),
+			EmailAllowlistPatterns: initEmailPatterns("privacy.email_allowlist_patterns", ko),
+
+			// Hook for encrypting the attributes of subscribers in sensitive lists.
+			EncryptAttribsCB: func(email string, listIDs []int, attribs models.JSON) (models.JSON, error) {
+				return core.EncryptAttribs(0, email, listIDs, nil, attribs)
+			},
+
+			ArchiveCB: archiveCB,
+
+			// Hook for publishing the created and blocklisted subscribers to the event stream.
+			SubscriberEventCB: makeSubscriberEventPublisher(evStream),
 
 			// Hook for triggering admin notifications and refreshing stats materialized
 			// views after a successful import.
@@ -684,12 +966,17 @@ func initSMTPMessengers() []manager.Messenger {
 		}
 
 		servers = append(servers, s)
+		if s.ShadowMode {
+			lo.Printf("initialized email (SMTP) messenger in shadow mode: %s@%s -> %s", item.String("username"), item.String("host"), s.ShadowBccAddress)
+			continue
+		}
 		lo.Printf("initialized email (SMTP) messenger: %s@%s", item.String("username"), item.String("host"))
 
 		// If the server has a name, initialize it as a standalone e-mail messenger
 		// allowing campaigns to select individual SMTPs. In the UI and config, it'll appear as `email / $name`.
+		// Servers in shadow mode can't be selected as they don't send live messages.
 		if s.Name != "" {
-			msgr, err := email.New(s.Name, s)
+			msgr, err := email.New(s.Name, lo, s)
 			if err != nil {
 				lo.Fatalf("error initializing e-mail messenger: %v", err)
 			}
@@ -698,7 +985,7 @@ func initSMTPMessengers() []manager.Messenger {
 	}
 
 	// Initialize the 'email' messenger with all SMTP servers.
-	msgr, err := email.New(email.MessengerName, servers...)
+	msgr, err := email.New(email.MessengerName, lo, servers...)
 	if err != nil {
 		lo.Fatalf("error initializing e-mail messenger: %v", err)
 	}
@@ -750,6 +1037,17 @@ func initPostbackMessengers(ko *koanf.Koanf) []manager.Messenger {
 	return out
 }
 
+// initCaptureMessenger initializes the capture messenger that records messages
+// in memory instead of delivering them, if it's enabled.
+func initCaptureMessenger(ko *koanf.Koanf) *capture.Capture {
+	if !ko.Bool("capture_messenger.enabled") {
+		return nil
+	}
+
+	lo.Printf("WARNING: capture messenger is enabled. Messages sent with it are not delivered")
+	return capture.New(ko.Int("capture_messenger.size"))
+}
+
 // initMediaStore initializes Upload manager with a custom backend.
 func initMediaStore(ko *koanf.Koanf) media.Store {
 	switch provider := ko.String("upload.provider"); provider {
@@ -785,8 +1083,9 @@ func initMediaStore(ko *koanf.Koanf) media.Store {
 	return nil
 }
 
-// initNotifs initializes the notifier with the system e-mail templates.
-func initNotifs(fs stuffbin.FileSystem, i *i18n.I18n, em *email.Emailer, u *UrlConfig, ko *koanf.Koanf) {
+// initNotifs initializes the notifier with the system e-mail templates and
+// the admin notifications of critical events.
+func initNotifs(fs stuffbin.FileSystem, co *core.Core, i *i18n.I18n, em *email.Emailer, u *UrlConfig, ko *koanf.Koanf) {
 	tpls, err := stuffbin.ParseTemplatesGlob(initTplFuncs(i, u), fs, "/static/email-templates/*.html")
 	if err != nil {
 		lo.Fatalf("error parsing e-mail notif templates: %v", err)
@@ -810,16 +1109,41 @@ func initNotifs(fs stuffbin.FileSystem, i *i18n.I18n, em *email.Emailer, u *UrlC
 		lo.Println("system e-mail templates are plaintext")
 	}
 
+	// Admin notifications of critical events.
+	alerts := notifs.AlertOpt{
+		Events: map[string]bool{
+			models.NotificationCampaignFailure: ko.Bool("notifications.events.campaign_failure.enabled"),
+			models.NotificationBounceSpike:     ko.Bool("notifications.events.bounce_spike.enabled"),
+			models.NotificationIPBounceSpike:   ko.Bool("notifications.events.ip_bounce_spike.enabled"),
+			models.NotificationNewLogin:        ko.Bool("notifications.events.new_login.enabled"),
+			models.NotificationDBPool:          ko.Bool("notifications.events.db_pool.enabled"),
+			models.NotificationWarmupLimit:     ko.Bool("notifications.events.warmup_limit.enabled"),
+		},
+		Log: co.LogNotification,
+	}
+
+	// If there are no notification e-mails, fall back to the admin notification e-mails.
+	if ko.Bool("notifications.email.enabled") {
+		alerts.Emails = ko.Strings("notifications.email.emails")
+		if len(alerts.Emails) == 0 {
+			alerts.Emails = ko.Strings("app.notify_emails")
+		}
+	}
+	if ko.Bool("notifications.webhook.enabled") {
+		alerts.WebhookURL = ko.String("notifications.webhook.url")
+	}
+
 	notifs.Initialize(notifs.Opt{
 		FromEmail:    ko.String("app.from_email"),
 		SystemEmails: ko.Strings("app.notify_emails"),
 		ContentType:  contentType,
+		Alerts:       alerts,
 	}, tpls, em, lo)
 }
 
 // initBounceManager initializes the bounce manager that scans mailboxes and listens to webhooks
 // for incoming bounce events.
-func initBounceManager(cb func(models.Bounce) error, stmt *sqlx.Stmt, lo *log.Logger, ko *koanf.Koanf) *bounce.Manager {
+func initBounceManager(cb func(models.Bounce) error, unsubCB func(models.MailtoUnsub) error, stmt *sqlx.Stmt, lo *log.Logger, ko *koanf.Koanf) *bounce.Manager {
 	opt := bounce.Opt{
 		WebhooksEnabled:         ko.Bool("bounce.webhooks_enabled"),
 		SESEnabled:              ko.Bool("bounce.ses_enabled"),
@@ -854,6 +1178,21 @@ func initBounceManager(cb func(models.Bounce) error, stmt *sqlx.Stmt, lo *log.Lo
 		RecordBounceCB: cb,
 	}
 
+	// Custom rules for classifying bounce reasons.
+	if err := ko.UnmarshalWithConf("bounce.reasons", &opt.Reasons, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Printf("error reading bounce reasons: %v", err)
+	}
+
+	// Custom rules for classifying mailbox bounces as hard or soft.
+	if err := ko.UnmarshalWithConf("bounce.classification_rules", &opt.TypeRules, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Printf("error reading bounce classification rules: %v", err)
+	}
+
+	// Process unsubscribe requests e-mailed to List-Unsubscribe mailto: addresses?
+	if initUnsubMailto(ko) != "" {
+		opt.UnsubscribeCB = unsubCB
+	}
+
 	// For now, only one mailbox is supported.
 	for _, b := range ko.Slices("bounce.mailboxes") {
 		if !b.Bool("enabled") {
@@ -880,6 +1219,38 @@ func initBounceManager(cb func(models.Bounce) error, stmt *sqlx.Stmt, lo *log.Lo
 	return b
 }
 
+// hasBounceMailbox checks whether there's at least one enabled bounce mailbox.
+func hasBounceMailbox(ko *koanf.Koanf) bool {
+	for _, b := range ko.Slices("bounce.mailboxes") {
+		if b.Bool("enabled") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// makeMailtoUnsubHook returns a callback that processes unsubscribe requests e-mailed to
+// signed List-Unsubscribe mailto: addresses exactly like the one-click unsubscribe URL.
+func makeMailtoUnsubHook(co *core.Core, key []byte) func(models.MailtoUnsub) error {
+	return func(u models.MailtoUnsub) error {
+		if !utils.VerifyUnsubMailto(u.CampaignUUID, u.SubscriberUUID, u.Signature, key) {
+			lo.Printf("ignoring mailto: unsubscribe with invalid signature from %s (campaign %s, subscriber %s)",
+				u.From, u.CampaignUUID, u.SubscriberUUID)
+			return errors.New("invalid signature")
+		}
+
+		// The address carries the subscriber's unsubscribe token (or the UUID
+		// in addresses from older e-mails).
+		sub, err := co.GetSubscriberByToken(u.SubscriberUUID)
+		if err != nil {
+			return err
+		}
+
+		return co.UnsubscribeByCampaign(sub.UUID, u.CampaignUUID, false)
+	}
+}
+
 // initAbout initializes the app's /about API endpoint with the app and system info.
 func initAbout(q *models.Queries, db *sqlx.DB) about {
 	var (
@@ -964,7 +1335,16 @@ func initHTTPServer(cfg *Config, urlCfg *UrlConfig, i *i18n.I18n, fs stuffbin.Fi
 	)
 	switch {
 	case uploadProvider == "filesystem" && uploadFsURI != "":
-		srv.Static(uploadFsURI, ko.String("upload.filesystem.upload_path"))
+		// Private files (eg: backups/) in the upload directory are never served.
+		g := srv.Group(uploadFsURI, func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				if p, err := url.PathUnescape(c.Param("*")); err != nil || media.IsPrivate(path.Clean("/"+p)) {
+					return echo.NewHTTPError(http.StatusNotFound)
+				}
+				return next(c)
+			}
+		})
+		g.Static("", ko.String("upload.filesystem.upload_path"))
 	case uploadProvider == "s3" && strings.HasPrefix(publicURL, "/"):
 		srv.GET(path.Join(publicURL, "/:filepath"), app.ServeS3Media)
 	}
@@ -996,8 +1376,113 @@ func initCaptcha() *captcha.Captcha {
 	return captcha.New(opt)
 }
 
-// initCron initializes cron jobs for slow query cache refresh and database vacuum.
-func initCron(co *core.Core, db *sqlx.DB) {
+// initCampaignGate initializes the external campaign approval gates.
+// It returns nil if gates are disabled.
+func initCampaignGate(ko *koanf.Koanf) *campgate.Gate {
+	if !ko.Bool("security.campaign_gate.enabled") {
+		return nil
+	}
+
+	timeout := ko.Duration("security.campaign_gate.timeout")
+	if timeout <= 0 {
+		timeout = time.Second * 10
+	}
+
+	return campgate.New(campgate.Opt{
+		URLs:    ko.Strings("security.campaign_gate.urls"),
+		Secret:  ko.String("security.campaign_gate.secret"),
+		Timeout: timeout,
+	})
+}
+
+// initSpellChecker loads the language dictionaries bundled in static/spellcheck
+// and the custom word dictionaries (media files) in spellcheck.dictionary_ids.
+func initSpellChecker(fs stuffbin.FileSystem, co *core.Core, md media.Store, ko *koanf.Koanf) *spellcheck.Checker {
+	sc := spellcheck.New()
+
+	files, err := fs.Glob("/static/spellcheck/*.txt")
+	if err != nil {
+		lo.Printf("error reading spellcheck dictionaries: %v", err)
+		return sc
+	}
+	for _, f := range files {
+		b, err := fs.Read(f)
+		if err != nil {
+			lo.Printf("error reading spellcheck dictionary %s: %v", f, err)
+			continue
+		}
+
+		lang := strings.TrimSuffix(filepath.Base(f), ".txt")
+		if err := sc.LoadLang(lang, bytes.NewReader(b)); err != nil {
+			lo.Printf("error loading spellcheck dictionary %s: %v", f, err)
+		}
+	}
+
+	// Custom dictionaries are uploaded media files with whitespace separated words.
+	var words []string
+	for _, id := range ko.Ints("spellcheck.dictionary_ids") {
+		m, err := co.GetMedia(id, "", "", md)
+		if err != nil {
+			lo.Printf("error fetching spellcheck dictionary media %d: %v", id, err)
+			continue
+		}
+
+		b, err := md.GetBlob(m.URL)
+		if err != nil {
+			lo.Printf("error reading spellcheck dictionary media %d: %v", id, err)
+			continue
+		}
+		words = append(words, strings.Fields(string(b))...)
+	}
+	sc.SetCustomWords(words)
+
+	return sc
+}
+
+// initBackups initializes database backups to the media store.
+func initBackups(db *sqlx.DB, store media.Store, ko *koanf.Koanf) *backup.Backups {
+	var d backup.DBOpt
+	d.Host = ko.String("db.host")
+	d.Port = ko.Int("db.port")
+	d.User = ko.String("db.user")
+	d.Password = ko.String("db.password")
+	d.Database = ko.String("db.database")
+	d.SSLMode = ko.String("db.ssl_mode")
+
+	// Backups are large. Retry transient upload failures without a per-attempt timeout.
+	store = media.WithRetry(store, media.RetryOpt{MaxTries: 3, Wait: time.Second * 5})
+
+	return backup.New(backup.Opt{
+		Method:     ko.String("maintenance.backup.method"),
+		PgDumpPath: ko.String("backup.pg_dump_path"),
+		Keep:       ko.Int("maintenance.backup.keep"),
+		DB:         d,
+	}, db, store, lo)
+}
+
+// initLeader initializes leader election between multiple app instances sharing the DB.
+// It returns nil if leader election is disabled.
+func initLeader(db *sqlx.DB, ko *koanf.Koanf) *leader.Leader {
+	if !ko.Bool("cluster.leader_election") {
+		return nil
+	}
+
+	id := ko.String("cluster.instance_id")
+	if id == "" {
+		host, _ := os.Hostname()
+		id = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+
+	lo.Printf("leader election enabled. instance: %s", id)
+	return leader.New(leader.Opt{
+		ID:       id,
+		Interval: ko.Duration("cluster.election_interval"),
+	}, db.DB, lo)
+}
+
+// initCron initializes cron jobs for slow query cache refresh, database vacuum, database backups,
+// and bounce spike notifications.
+func initCron(co *core.Core, db *sqlx.DB, md media.Store, bk *backup.Backups, gate *campgate.Gate, i *i18n.I18n) {
 	c := cron.New(cron.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
 
 	// Slow query cache cron job.
@@ -1036,6 +1521,129 @@ func initCron(co *core.Core, db *sqlx.DB) {
 		}
 	}
 
+	// Database backup cron job.
+	if ko.Bool("maintenance.backup.enabled") {
+		intval := ko.String("maintenance.backup.cron_interval")
+		if intval == "" {
+			lo.Println("error: invalid cron interval string for database backup")
+		} else {
+			_, err := c.Add(intval, func() {
+				_, _ = RunDBBackup(bk, i, lo)
+			})
+			if err != nil {
+				lo.Printf("error initializing database backup cron: %v", err)
+			} else {
+				lo.Printf("database backup cron enabled at interval: %s", intval)
+			}
+		}
+	}
+
+	// Campaign bounce rate spike notifications.
+	if ko.Bool("notifications.events.bounce_spike.enabled") {
+		var (
+			window    = ko.Duration("notifications.events.bounce_spike.window")
+			threshold = ko.Float64("notifications.events.bounce_spike.threshold")
+		)
+		if window < time.Minute || threshold <= 0 {
+			lo.Println("error: invalid window or threshold for bounce spike notifications")
+		} else {
+			_, err := c.Add("@every "+bounceSpikeCheckInterval.String(), func() {
+				checkBounceSpikes(co, window, threshold, i)
+			})
+			if err != nil {
+				lo.Printf("error initializing bounce spike notification cron: %v", err)
+			}
+		}
+	}
+
+	// Bounces by originating IP notifications and stats.
+	if ko.Bool("notifications.events.ip_bounce_spike.enabled") {
+		var (
+			window    = ko.Duration("notifications.events.ip_bounce_spike.window")
+			threshold = ko.Int("notifications.events.ip_bounce_spike.threshold")
+		)
+		if window < time.Minute || threshold < 1 {
+			lo.Println("error: invalid window or threshold for IP bounce spike notifications")
+		} else {
+			_, err := c.Add("@every "+ipBounceCheckInterval.String(), func() {
+				checkIPBounceSpikes(co, window, threshold, i)
+			})
+			if err != nil {
+				lo.Printf("error initializing IP bounce spike notification cron: %v", err)
+			}
+		}
+	}
+
+	// Delete archived import files past their retention.
+	if days := ko.Int("app.import_archive_retention_days"); ko.Bool("app.archive_imports") && days > 0 {
+		if _, err := c.Add("@every "+importArchivePurgeInterval.String(), func() {
+			purgeImportArchives(co, md, days)
+		}); err != nil {
+			lo.Printf("error initializing import archive purge cron: %v", err)
+		}
+	}
+
+	// Unsubscribe subscribers who didn't re-confirm their subscriptions in time.
+	if _, err := c.Add("@every "+reconfirmExpiryInterval.String(), func() {
+		expireReconfirmations(co)
+	}); err != nil {
+		lo.Printf("error initializing re-confirmation expiry cron: %v", err)
+	}
+
+	// Retry campaigns that are awaiting approval from their gates.
+	if gate != nil {
+		intval := ko.Duration("security.campaign_gate.retry_interval")
+		if intval < time.Minute {
+			intval = time.Minute
+		}
+		_, err := c.Add("@every "+intval.String(), func() {
+			retryCampaignGates(co, gate)
+		})
+		if err != nil {
+			lo.Printf("error initializing campaign gate cron: %v", err)
+		}
+	}
+
+	// Delete stale public subscription rate limit buckets.
+	if _, err := c.Add("@every "+subRateLimitEmailWindow.String(), func() {
+		_ = co.DeleteStaleRateLimits(subRateLimitEmailWindow)
+	}); err != nil {
+		lo.Printf("error initializing rate limit cleanup cron: %v", err)
+	}
+
+	// Evaluate list auto-assignment rules. The first run evaluates all subscribers
+	// and the subsequent ones, only the ones modified since the previous run.
+	var rulesSince null.Time
+	if _, err := c.Add("@every "+listRulesInterval.String(), func() {
+		rulesSince = applyListRules(co, rulesSince)
+	}); err != nil {
+		lo.Printf("error initializing list rules cron: %v", err)
+	}
+
+	// Post the subscribers joining and leaving lists to the list events webhook,
+	// starting with the ones from now on. Runs that overlap a slow one are skipped.
+	if ko.Bool("notifications.list_webhook.enabled") {
+		var (
+			w = &listWebhook{
+				url:    ko.String("notifications.list_webhook.url"),
+				secret: []byte(ko.String("notifications.list_webhook.secret")),
+				http:   &http.Client{Timeout: listWebhookTimeout},
+			}
+			after = models.ListEvent{CreatedAt: time.Now()}
+			mut   sync.Mutex
+		)
+		if _, err := c.Add("@every "+listEventsInterval.String(), func() {
+			if !mut.TryLock() {
+				return
+			}
+			defer mut.Unlock()
+
+			after = postListEvents(co, w, after)
+		}); err != nil {
+			lo.Printf("error initializing list webhook cron: %v", err)
+		}
+	}
+
 	if len(c.Entries()) > 0 {
 		c.Start()
 	}
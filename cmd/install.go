@@ -71,6 +71,7 @@ func install(lastVer string, db *sqlx.DB, fs stuffbin.FileSystem, prompt, idempo
 
 	// Templates.
 	campTplID, archiveTplID := installTemplates(q)
+	installTemplateLibrary(q)
 
 	// Sample campaign.
 	installCampaign(campTplID, archiveTplID, q)
@@ -172,7 +173,8 @@ func installSubs(defListID, optinListID int, q *models.Queries) {
 		`{"type": "known", "good": true, "city": "Bengaluru"}`,
 		pq.Int64Array{int64(defListID)},
 		models.SubscriptionStatusUnconfirmed,
-		true, true); err != nil {
+		true, true, false,
+		uuid.Must(uuid.NewV4())); err != nil {
 		lo.Fatalf("Error creating subscriber: %v", err)
 	}
 	if _, err := q.UpsertSubscriber.Exec(
@@ -182,7 +184,8 @@ func installSubs(defListID, optinListID int, q *models.Queries) {
 		`{"type": "unknown", "good": true, "city": "Bengaluru"}`,
 		pq.Int64Array{int64(optinListID)},
 		models.SubscriptionStatusUnconfirmed,
-		true, true); err != nil {
+		true, true, false,
+		uuid.Must(uuid.NewV4())); err != nil {
 		lo.Fatalf("error creating subscriber: %v", err)
 	}
 }
@@ -195,7 +198,7 @@ func installTemplates(q *models.Queries) (int, int) {
 	}
 
 	var campTplID int
-	if err := q.CreateTemplate.Get(&campTplID, "Default campaign template", models.TemplateTypeCampaign, "", campTpl.ReadBytes(), nil); err != nil {
+	if err := q.CreateTemplate.Get(&campTplID, "Default campaign template", models.TemplateTypeCampaign, "", campTpl.ReadBytes(), nil, nil, nil); err != nil {
 		lo.Fatalf("error creating default campaign template: %v", err)
 	}
 	if _, err := q.SetDefaultTemplate.Exec(campTplID); err != nil {
@@ -209,7 +212,7 @@ func installTemplates(q *models.Queries) (int, int) {
 	}
 
 	var archiveTplID int
-	if err := q.CreateTemplate.Get(&archiveTplID, "Default archive template", models.TemplateTypeCampaign, "", archiveTpl.ReadBytes(), nil); err != nil {
+	if err := q.CreateTemplate.Get(&archiveTplID, "Default archive template", models.TemplateTypeCampaign, "", archiveTpl.ReadBytes(), nil, nil, nil); err != nil {
 		lo.Fatalf("error creating default campaign template: %v", err)
 	}
 
@@ -240,6 +243,36 @@ func installTemplates(q *models.Queries) (int, int) {
 	return campTplID, archiveTplID
 }
 
+// installTemplateLibrary installs the read-only templates in the template library
+// from static/email-templates/library.
+func installTemplateLibrary(q *models.Queries) {
+	b, err := fs.Read("/static/email-templates/library/library.json")
+	if err != nil {
+		lo.Fatalf("error reading template library: %v", err)
+	}
+
+	var lib []struct {
+		Name        string `json:"name"`
+		Category    string `json:"category"`
+		Description string `json:"description"`
+		File        string `json:"file"`
+	}
+	if err := json.Unmarshal(b, &lib); err != nil {
+		lo.Fatalf("error parsing template library: %v", err)
+	}
+
+	for _, t := range lib {
+		body, err := fs.Read("/static/email-templates/library/" + t.File)
+		if err != nil {
+			lo.Fatalf("error reading library template %s: %v", t.File, err)
+		}
+
+		if _, err := q.CreateLibTemplate.Exec(t.Name, body, t.Description, t.Category); err != nil {
+			lo.Fatalf("error creating library template %s: %v", t.Name, err)
+		}
+	}
+}
+
 func installCampaign(campTplID, archiveTplID int, q *models.Queries) {
 	// Sample campaign.
 	if _, err := q.CreateCampaign.Exec(uuid.Must(uuid.NewV4()),
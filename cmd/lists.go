@@ -1,15 +1,66 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/campgate"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	"github.com/skip2/go-qrcode"
+	"gopkg.in/volatiletech/null.v6"
 )
 
+const (
+	// maxOverlapLists is the max number of lists that can be compared in a list overlap query.
+	maxOverlapLists = 10
+
+	// QR code image size limits in pixels.
+	qrDefaultSize = 256
+	qrMinSize     = 64
+	qrMaxSize     = 2048
+
+	// qrUTMSource is the utm_source param added to QR code subscription URLs.
+	qrUTMSource = "qrcode"
+
+	// Max. size of an uploaded suppression list.
+	maxSuppressionFileSize = 20 * 1024 * 1024
+
+	// Interval at which list auto-assignment rules are evaluated against
+	// subscribers modified since the last run.
+	listRulesInterval = time.Minute * 5
+
+	// Interval at which the subscribers joining and leaving lists are posted to
+	// the list events webhook, the number of events fetched at a time, and the
+	// timeout for posting an event.
+	listEventsInterval  = time.Second * 30
+	listEventsBatchSize = 500
+	listWebhookTimeout  = time.Second * 10
+)
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"low":     qrcode.Low,
+	"medium":  qrcode.Medium,
+	"high":    qrcode.High,
+	"highest": qrcode.Highest,
+}
+
 // GetLists retrieves lists with additional metadata like subscriber counts.
 func (a *App) GetLists(c echo.Context) error {
 	// Get the authenticated user.
@@ -52,9 +103,19 @@ func (a *App) GetLists(c echo.Context) error {
 		status  = c.FormValue("status")
 		order   = c.FormValue("order")
 
+		// Subscriber counts are included unless explicitly skipped.
+		includeCounts = true
+
 		pg = a.pg.NewFromURL(c.Request().URL.Query())
 	)
-	res, total, err := a.core.QueryLists(query, typ, optin, status, tags, orderBy, order, hasAllPerm, permittedIDs, pg.Offset, pg.Limit)
+	if v := c.FormValue("include_counts"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "include_counts"))
+		}
+		includeCounts = b
+	}
+	res, total, err := a.core.QueryLists(query, typ, optin, status, tags, orderBy, order, includeCounts, hasAllPerm, permittedIDs, pg.Offset, pg.Limit)
 	if err != nil {
 		return err
 	}
@@ -91,6 +152,250 @@ func (a *App) GetList(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// GetListSubscriberCount returns the total number of subscriptions to a list
+// without the overhead of fetching the list.
+func (a *App) GetListSubscriberCount(c echo.Context) error {
+	// Get the authenticated user.
+	user := auth.GetUser(c)
+
+	// Check if the user has access to the list.
+	id := getID(c)
+	if err := user.HasListPerm(auth.PermTypeGet, id); err != nil {
+		return err
+	}
+
+	count, err := a.core.GetListSubscriberCount(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Count int `json:"count"`
+	}{count}})
+}
+
+// GetListQRCode returns a QR code image (PNG or SVG) encoding the public
+// subscription form URL for a public list.
+func (a *App) GetListQRCode(c echo.Context) error {
+	// Get the authenticated user.
+	user := auth.GetUser(c)
+
+	// Check if the user has access to the list.
+	id := getID(c)
+	if err := user.HasListPerm(auth.PermTypeGet, id); err != nil {
+		return err
+	}
+
+	var (
+		format = c.QueryParam("format")
+		level  = c.QueryParam("level")
+		size   = qrDefaultSize
+	)
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "format"))
+	}
+	if level == "" {
+		level = "medium"
+	}
+	lvl, ok := qrLevels[level]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "level"))
+	}
+	if v := c.QueryParam("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "size"))
+		}
+		size = n
+	}
+
+	// Only public lists are shown on the public subscription form.
+	if !a.cfg.EnablePublicSubPage {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("public.invalidFeature"))
+	}
+	list, err := a.core.GetList(id, "")
+	if err != nil {
+		return err
+	}
+	if list.Type != models.ListTypePublic {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("lists.qrcodePublicOnly"))
+	}
+
+	// The subscription form URL with the list pre-selected.
+	q := url.Values{}
+	q.Set("l", list.UUID)
+	q.Set("utm_source", qrUTMSource)
+	u := a.urlCfg.RootURL + "/subscription/form?" + q.Encode()
+
+	qr, err := qrcode.New(u, lvl)
+	if err != nil {
+		a.log.Printf("error generating QR code: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.Ts("globals.messages.internalError"))
+	}
+
+	var (
+		b   []byte
+		typ string
+	)
+	if format == "svg" {
+		b, typ = makeQRCodeSVG(qr, size), "image/svg+xml"
+	} else {
+		b, err = qr.PNG(size)
+		if err != nil {
+			a.log.Printf("error generating QR code: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.Ts("globals.messages.internalError"))
+		}
+		typ = "image/png"
+	}
+
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="list-%d-qrcode.%s"`, list.ID, format))
+	return c.Blob(http.StatusOK, typ, b)
+}
+
+// CreateListLandingPage generates a standalone HTML subscription landing page for a
+// public list with the configured branding and an optional CSS override, and saves it
+// to be served at a public URL. Generating it again replaces the page at the same URL.
+// With ?preview=true, the generated HTML is returned without saving it.
+func (a *App) CreateListLandingPage(c echo.Context) error {
+	// Get the authenticated user.
+	user := auth.GetUser(c)
+
+	// Check if the user has access to the list.
+	id := getID(c)
+	if err := user.HasListPerm(auth.PermTypeManage, id); err != nil {
+		return err
+	}
+
+	var req struct {
+		CustomCSS string `json:"custom_css"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	// The landing page submits to the public subscription form.
+	if !a.cfg.EnablePublicSubPage {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("public.invalidFeature"))
+	}
+	list, err := a.core.GetList(id, "")
+	if err != nil {
+		return err
+	}
+	if list.Type != models.ListTypePublic {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("lists.landingPagePublicOnly"))
+	}
+
+	out := landingPageTpl{
+		List:      list,
+		PublicCSS: sanitizeStyleCSS(string(a.cfg.Appearance.PublicCSS)),
+		CustomCSS: sanitizeStyleCSS(req.CustomCSS),
+	}
+	out.Title = list.Name
+	a.setSubFormCaptcha(&out.subFormTpl)
+
+	var b bytes.Buffer
+	if err := c.Echo().Renderer.Render(&b, "landing-page", out, c); err != nil {
+		a.log.Printf("error rendering landing page: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "{lists.landingPage}", "error", err.Error()))
+	}
+
+	if ok, _ := strconv.ParseBool(c.QueryParam("preview")); ok {
+		return c.HTML(http.StatusOK, b.String())
+	}
+
+	lp, err := a.core.UpsertLandingPage(list.ID, req.CustomCSS, b.String())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		models.LandingPage
+		URL string `json:"url"`
+	}{lp, a.urlCfg.RootURL + "/landing/" + lp.UUID}})
+}
+
+// sanitizeStyleCSS returns CSS that's safe to embed in a <style> tag by
+// escaping closing tag sequences that would end the tag.
+func sanitizeStyleCSS(s string) template.CSS {
+	return template.CSS(strings.ReplaceAll(strings.TrimSpace(s), "</", `<\/`))
+}
+
+// ExportListSubscribers streams a CSV export of all the subscribers in a list
+// without pagination (?subscription_status= optionally filters the subscriptions).
+func (a *App) ExportListSubscribers(c echo.Context) error {
+	// Get the authenticated user.
+	user := auth.GetUser(c)
+
+	// Check if the user has access to the list.
+	id := getID(c)
+	if err := user.HasListPerm(auth.PermTypeGet, id); err != nil {
+		return err
+	}
+
+	subStatus := c.QueryParam("subscription_status")
+	switch subStatus {
+	case "", models.SubscriptionStatusUnconfirmed, models.SubscriptionStatusConfirmed, models.SubscriptionStatusUnsubscribed:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "subscription_status"))
+	}
+
+	// Check that the list exists.
+	if _, err := a.core.GetList(id, ""); err != nil {
+		return err
+	}
+
+	hdr := c.Response().Header()
+	hdr.Set(echo.HeaderContentType, "text/csv")
+	hdr.Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="list-%d-subscribers.csv"`, id))
+	hdr.Set("Cache-Control", "no-cache")
+
+	if _, err := a.core.ExportListSubscribers(c.Request().Context(), id, subStatus, c.Response()); err != nil {
+		// Once the stream has begun, the error can't be sent as a response.
+		if c.Response().Committed {
+			return nil
+		}
+		hdr.Del(echo.HeaderContentDisposition)
+		return err
+	}
+
+	return nil
+}
+
+// GetListsOverlap returns the number of subscribers common to every pair of
+// the given lists (?ids=1,2,3) and the number of unique subscribers in each list.
+func (a *App) GetListsOverlap(c echo.Context) error {
+	ids, err := parseStringIDs(strings.Split(c.QueryParam("ids"), ","))
+	if err != nil || len(ids) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidID"))
+	}
+
+	// Dedup the IDs.
+	ids = slices.Compact(slices.Sorted(slices.Values(ids)))
+	if len(ids) > maxOverlapLists {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("lists.overlapMaxLists", "num", strconv.Itoa(maxOverlapLists)))
+	}
+
+	// Check if the user has access to all the lists.
+	user := auth.GetUser(c)
+	for _, id := range ids {
+		if err := user.HasListPerm(auth.PermTypeGet, id); err != nil {
+			return err
+		}
+	}
+
+	out, err := a.core.GetListsOverlap(ids)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // CreateList handles list creation.
 func (a *App) CreateList(c echo.Context) error {
 	l := models.List{}
@@ -102,6 +407,15 @@ func (a *App) CreateList(c echo.Context) error {
 	if !strHasLen(l.Name, 1, stdInputMaxLen) {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("lists.invalidName"))
 	}
+	if l.Sensitive && !a.core.CanEncryptAttribs() {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("lists.sensitiveNoKey"))
+	}
+	if l.MaxCampsPerWeek < 0 || l.MaxCampsPerMonth < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "max_campaigns_per_week / max_campaigns_per_month"))
+	}
+	if err := l.AutoAssignmentRules.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("lists.invalidRules", "error", err.Error()))
+	}
 
 	out, err := a.core.CreateList(l)
 	if err != nil {
@@ -133,6 +447,15 @@ func (a *App) UpdateList(c echo.Context) error {
 	if !strHasLen(l.Name, 1, stdInputMaxLen) {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("lists.invalidName"))
 	}
+	if l.Sensitive && !a.core.CanEncryptAttribs() {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("lists.sensitiveNoKey"))
+	}
+	if l.MaxCampsPerWeek < 0 || l.MaxCampsPerMonth < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "max_campaigns_per_week / max_campaigns_per_month"))
+	}
+	if err := l.AutoAssignmentRules.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("lists.invalidRules", "error", err.Error()))
+	}
 
 	// Update the list in the DB.
 	out, err := a.core.UpdateList(id, l)
@@ -143,6 +466,248 @@ func (a *App) UpdateList(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// EvaluateListRules evaluates a list's auto-assignment rules against all subscribers
+// and returns the number of subscribers that were subscribed and unsubscribed.
+func (a *App) EvaluateListRules(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has manage permission for the list.
+	user := auth.GetUser(c)
+	if err := user.HasListPerm(auth.PermTypeManage, id); err != nil {
+		return err
+	}
+
+	l, err := a.core.GetList(id, "")
+	if err != nil {
+		return err
+	}
+	if len(l.AutoAssignmentRules) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("lists.noRules"))
+	}
+
+	out, err := a.core.ApplyListRules([]int{id}, nil, null.Time{})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// MergeList merges a list into another (target_list_id), eg: lists that were
+// duplicated by imports. The subscribers and campaigns of the list are moved to
+// the target list and the list is archived.
+func (a *App) MergeList(c echo.Context) error {
+	id := getID(c)
+
+	var req struct {
+		TargetListID int `json:"target_list_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if req.TargetListID < 1 || req.TargetListID == id {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "target_list_id"))
+	}
+
+	// Check if the user has manage permission for both the lists.
+	user := auth.GetUser(c)
+	if err := user.HasListPerm(auth.PermTypeManage, id, req.TargetListID); err != nil {
+		return err
+	}
+
+	// Check that the lists exist.
+	if _, err := a.core.GetList(id, ""); err != nil {
+		return err
+	}
+	if _, err := a.core.GetList(req.TargetListID, ""); err != nil {
+		return err
+	}
+
+	out, err := a.core.MergeLists(id, req.TargetListID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// ImportListSuppressions handles the import of a suppression list (eg: from an ESP) as a
+// CSV file with an e-mail per line. The existing subscribers with the e-mails are blocklisted
+// and the suppression is recorded on their subscriptions to the list. Subscribers are not
+// created for e-mails that don't exist.
+func (a *App) ImportListSuppressions(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has manage permission for the list.
+	user := auth.GetUser(c)
+	if err := user.HasListPerm(auth.PermTypeManage, id); err != nil {
+		return err
+	}
+
+	if _, err := a.core.GetList(id, ""); err != nil {
+		return err
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("import.invalidFile", "error", err.Error()))
+	}
+	if file.Size > maxSuppressionFileSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+			a.i18n.Ts("import.invalidFile", "error", fmt.Sprintf("max. size is %d MB", maxSuppressionFileSize/1024/1024)))
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Read the e-mails in the first column, skipping the header, if any.
+	rd := csv.NewReader(src)
+	rd.FieldsPerRecord = -1
+	rd.TrimLeadingSpace = true
+
+	var (
+		out    models.SuppressionImport
+		emails = []string{}
+		seen   = map[string]struct{}{}
+	)
+	for {
+		row, err := rd.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("import.invalidFile", "error", err.Error()))
+		}
+
+		v := strings.TrimSpace(row[0])
+		if v == "" || (out.Total == 0 && out.Invalid == 0 && strings.EqualFold(v, "email")) {
+			continue
+		}
+
+		em, err := utils.SanitizeEmail(v)
+		if err != nil {
+			out.Invalid++
+			continue
+		}
+
+		if _, ok := seen[em]; ok {
+			continue
+		}
+		seen[em] = struct{}{}
+		emails = append(emails, em)
+		out.Total++
+	}
+
+	// Suppress the subscribers in batches.
+	for i := 0; i < len(emails); i += a.cfg.DBBatchSize {
+		batch := emails[i:min(i+a.cfg.DBBatchSize, len(emails))]
+
+		sup, exist, err := a.core.SuppressSubscribers(id, batch)
+		if err != nil {
+			return err
+		}
+		out.Suppressed += sup
+		out.Existing += exist
+	}
+	out.NotFound = out.Total - out.Suppressed - out.Existing
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// applyListRules evaluates the auto-assignment rules of all lists against the
+// subscribers and lists modified since the given time (or all, if it's not set).
+// It returns the time to pass to the next run.
+func applyListRules(co *core.Core, since null.Time) null.Time {
+	// Record the start time so that subscribers modified during the run are
+	// picked up in the next one.
+	now := null.TimeFrom(time.Now())
+
+	res, err := co.ApplyListRules(nil, nil, since)
+	if err != nil {
+		return since
+	}
+	if res.Subscribed > 0 || res.Unsubscribed > 0 {
+		lo.Printf("list rules: subscribed %d, unsubscribed %d", res.Subscribed, res.Unsubscribed)
+	}
+
+	return now
+}
+
+// listWebhook posts the subscribers joining and leaving lists to a webhook URL.
+type listWebhook struct {
+	url    string
+	secret []byte
+	http   *http.Client
+}
+
+// postListEvents posts the subscribers who joined or left lists after the given
+// event to the webhook in the order they did. It returns the last posted (or skipped)
+// event to pass to the next run.
+func postListEvents(co *core.Core, w *listWebhook, after models.ListEvent) models.ListEvent {
+	for {
+		evs, err := co.GetListEvents(after, listEventsBatchSize)
+		if err != nil {
+			return after
+		}
+
+		for _, e := range evs {
+			if retry, err := w.post(e); err != nil {
+				lo.Printf("error posting list event (%s, subscriber %d, list %d) to webhook: %v", e.Event, e.SubscriberID, e.ListID, err)
+
+				// Retry the event on the next run.
+				if retry {
+					return after
+				}
+			}
+			after = e
+		}
+
+		if len(evs) < listEventsBatchSize {
+			return after
+		}
+	}
+}
+
+// post posts an event as JSON to the webhook. If a secret is set, the hex encoded
+// HMAC-SHA256 signature of the body is sent in the X-Listmonk-Signature header.
+// The returned bool indicates whether the event should be posted again.
+func (w *listWebhook) post(e models.ListEvent) (bool, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		h := hmac.New(sha256.New, w.secret)
+		h.Write(b)
+		req.Header.Set(campgate.SignatureHeader, hex.EncodeToString(h.Sum(nil)))
+	}
+
+	resp, err := w.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+
+	// Events rejected by the webhook are dropped, and the ones that fail with
+	// server errors or rate limits are retried.
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
 // DeleteList deletes a single list by ID.
 func (a *App) DeleteList(c echo.Context) error {
 	id := getID(c)
@@ -215,3 +780,25 @@ func (a *App) DeleteLists(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
+
+// makeQRCodeSVG renders a QR code as an SVG image of the given size.
+func makeQRCodeSVG(qr *qrcode.QRCode, size int) []byte {
+	var (
+		bm  = qr.Bitmap()
+		buf bytes.Buffer
+	)
+
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, len(bm), len(bm))
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, len(bm), len(bm))
+	for y, row := range bm {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+
+	return buf.Bytes()
+}
@@ -16,15 +16,21 @@ import (
 	"github.com/knadh/koanf/providers/env"
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/backup"
 	"github.com/knadh/listmonk/internal/bounce"
 	"github.com/knadh/listmonk/internal/buflog"
+	"github.com/knadh/listmonk/internal/campgate"
 	"github.com/knadh/listmonk/internal/captcha"
 	"github.com/knadh/listmonk/internal/core"
 	"github.com/knadh/listmonk/internal/events"
 	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/leader"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/internal/messenger/capture"
 	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/presence"
+	"github.com/knadh/listmonk/internal/spellcheck"
 	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
 	"github.com/knadh/paginator"
@@ -42,14 +48,20 @@ type App struct {
 	manager    *manager.Manager
 	messengers []manager.Messenger
 	emailMsgr  manager.Messenger
+	capture    *capture.Capture
 	importer   *subimporter.Importer
 	auth       *auth.Auth
 	media      media.Store
+	backups    *backup.Backups
 	bounce     *bounce.Manager
+	campGate   *campgate.Gate
+	leader     *leader.Leader
 	captcha    *captcha.Captcha
+	spellcheck *spellcheck.Checker
 	i18n       *i18n.I18n
 	pg         *paginator.Paginator
 	events     *events.Events
+	presence   *presence.Presence
 	log        *log.Logger
 	bufLog     *buflog.BufLog
 
@@ -68,6 +80,16 @@ type App struct {
 
 	// Global state that stores data on an available remote update.
 	update *AppUpdate
+
+	// Rendered UTM params of campaigns for link redirects.
+	utm utmCache
+
+	// Dashboard responses of the commonly used date ranges.
+	dash dashboardCache
+
+	// Guards the lazy loading of the spellcheck dictionaries.
+	spellcheckOnce sync.Once
+
 	sync.Mutex
 }
 
@@ -80,8 +102,12 @@ var (
 	ko      = koanf.New(".")
 	fs      stuffbin.FileSystem
 	db      *sqlx.DB
+	dbDSN   string
 	queries *models.Queries
 
+	// Statement timeout of DB export and import jobs.
+	dbExportTimeout time.Duration
+
 	// Compile-time variables.
 	buildString   string
 	versionString string
@@ -136,7 +162,7 @@ func init() {
 	}
 
 	// Connect to the database.
-	db = initDB()
+	db, dbDSN = initDB()
 
 	// Initialize the embedded filesystem with static assets.
 	fs = initFS(appDir, frontendDir, ko.String("static-dir"), ko.String("i18n-dir"))
@@ -212,15 +238,21 @@ func main() {
 		// Initialize all messengers, SMTP and postback.
 		msgrs = append(initSMTPMessengers(), initPostbackMessengers(ko)...)
 
+		// Optional capture messenger for end-to-end tests.
+		capt = initCaptureMessenger(ko)
+
 		// Campaign manager.
 		mgr = initCampaignManager(msgrs, queries, urlCfg, core, media, i18n, ko)
 
 		// Bulk importer.
-		importer = initImporter(queries, db, core, i18n, ko)
+		importer = initImporter(queries, db, core, media, i18n, ko)
 
 		// Initialize the auth manager.
 		hasUsers, auth = initAuth(core, db.DB, ko)
 
+		// Database backups to the media store.
+		backups = initBackups(db, media, ko)
+
 		// Initialize the webhook/POP3 bounce processor.
 		bounce *bounce.Manager
 
@@ -229,10 +261,27 @@ func main() {
 		chReload = make(chan os.Signal, 1)
 	)
 
+	// Attach the capture messenger to the campaign manager if it's enabled.
+	if capt != nil {
+		mgr.AddMessenger(capt)
+		msgrs = append(msgrs, capt)
+	}
+
+	// Encrypt the attributes of existing subscribers in sensitive lists and exit.
+	if ko.Bool("encrypt-attribs") {
+		n, err := core.EncryptSensitiveAttribs(ko.Int("app.batch_size"))
+		if err != nil {
+			lo.Fatalf("error encrypting attributes: %v", err)
+		}
+		lo.Printf("encrypted the attributes of %d subscribers", n)
+		os.Exit(0)
+	}
+
 	// Initialize the bounce manager that processes bounces from webhooks and
 	// POP3 mailbox scanning.
 	if ko.Bool("bounce.enabled") {
-		bounce = initBounceManager(core.RecordBounce, queries.RecordBounce, lo, ko)
+		bounce = initBounceManager(core.RecordBounce,
+			makeMailtoUnsubHook(core, []byte(ko.String("security.unsubscribe_mailto_key"))), queries.RecordBounce, lo, ko)
 	}
 
 	// Assign the default `email` messenger to the app.
@@ -243,7 +292,7 @@ func main() {
 	}
 
 	// Initialize the global admin/sub e-mail notifier.
-	initNotifs(fs, i18n, emailMsgr, urlCfg, ko)
+	initNotifs(fs, core, i18n, emailMsgr, urlCfg, ko)
 
 	// Initialize and cache tx templates in memory.
 	initTxTemplates(mgr, core)
@@ -254,13 +303,39 @@ func main() {
 		go bounce.Run()
 	}
 
-	// Start cronjobs.
-	initCron(core, db)
+	// Monitor the DB connection pool for exhaustion. Every instance has its own pool.
+	if ko.Bool("notifications.events.db_pool.enabled") {
+		go monitorDBPool(db, i18n)
+	}
 
 	// Start the campaign manager workers. The campaign batches (fetch from DB, push out
 	// messages) get processed at the specified interval.
 	go mgr.Run()
 
+	// Initialize the external campaign approval gates.
+	campGate := initCampaignGate(ko)
+
+	// Background jobs that should only run on one instance at a time: campaign scanning,
+	// bounce mailbox scanning, and cronjobs.
+	startJobs := func() {
+		mgr.StartCampaignScanner()
+		if bounce != nil {
+			bounce.StartMailboxScanner()
+		}
+		initCron(core, db, media, backups, campGate, i18n)
+	}
+
+	// With leader election enabled, the jobs run only on the instance that acquires the leader lock.
+	// If the leader loses the lock, it reloads and rejoins the election as a follower.
+	ldr := initLeader(db, ko)
+	if ldr != nil {
+		go ldr.Run(context.Background(), startJobs, func() {
+			chReload <- syscall.SIGHUP
+		})
+	} else {
+		startJobs()
+	}
+
 	// =========================================================================
 	// Initialize the App{} with all the global shared components, controllers and fields.
 	app := &App{
@@ -273,16 +348,23 @@ func main() {
 		manager:    mgr,
 		messengers: msgrs,
 		emailMsgr:  emailMsgr,
+		capture:    capt,
 		importer:   importer,
 		auth:       auth,
 		media:      media,
+		backups:    backups,
 		bounce:     bounce,
+		campGate:   campGate,
+		leader:     ldr,
 		captcha:    initCaptcha(),
 		i18n:       i18n,
 		log:        lo,
 		events:     evStream,
 		bufLog:     bufLog,
 
+		// Editors touch campaigns and templates every 30 seconds while they're open.
+		presence: presence.New(time.Second * 90),
+
 		pg: paginator.New(paginator.Opt{
 			DefaultPerPage: 20,
 			MaxPerPage:     50,
@@ -324,6 +406,11 @@ func main() {
 		// Close the campaign manager.
 		mgr.Close()
 
+		// Release the leader lock.
+		if ldr != nil {
+			ldr.Close()
+		}
+
 		// Close the DB pool.
 		db.Close()
 
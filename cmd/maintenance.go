@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/backup"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/labstack/echo/v4"
 )
 
@@ -132,7 +138,7 @@ func (a *App) ExportCampaignAnalytics(c echo.Context) error {
 		}
 
 	case "clicks":
-		wr.Write([]string{"campaign_id", "campaign_uuid", "campaign_name", "subscriber_id", "subscriber_uuid", "email", "subscriber_name", "url", "created_at"})
+		wr.Write([]string{"campaign_id", "campaign_uuid", "campaign_name", "subscriber_id", "subscriber_uuid", "email", "subscriber_name", "url", "utm", "created_at"})
 		next := a.core.ExportCampaignLinkClicks(since, a.cfg.DBBatchSize)
 		for {
 			rows, err := next()
@@ -145,7 +151,7 @@ func (a *App) ExportCampaignAnalytics(c echo.Context) error {
 			for _, r := range rows {
 				if err := wr.Write([]string{
 					strconv.Itoa(r.CampaignID), r.CampaignUUID, r.CampaignName,
-					strconv.Itoa(r.SubscriberID), r.SubscriberUUID, r.Email, r.SubscriberName, r.URL,
+					strconv.Itoa(r.SubscriberID), r.SubscriberUUID, r.Email, r.SubscriberName, r.URL, r.UTM,
 					r.CreatedAt.Format(time.RFC3339),
 				}); err != nil {
 					a.log.Printf("error streaming CSV: %v", err)
@@ -169,3 +175,51 @@ func RunDBVacuum(db *sqlx.DB, lo *log.Logger) {
 	}
 	lo.Println("finished database VACUUM ANALYZE")
 }
+
+// GetBackups returns the list of database backups in the media store.
+func (a *App) GetBackups(c echo.Context) error {
+	out, err := a.backups.List(c.Request().Context())
+	if err != nil {
+		a.log.Printf("error listing backups: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{maintenance.backup.title}", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateBackup starts a database backup in the background.
+func (a *App) CreateBackup(c echo.Context) error {
+	if a.backups.IsRunning() {
+		return echo.NewHTTPError(http.StatusConflict, a.i18n.T("maintenance.backup.running"))
+	}
+
+	go func() {
+		_, _ = RunDBBackup(a.backups, a.i18n, a.log)
+	}()
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// RunDBBackup takes a database backup and uploads it to the media store.
+// The admins are notified on failure.
+func RunDBBackup(bk *backup.Backups, i *i18n.I18n, lo *log.Logger) (media.Object, error) {
+	lo.Println("running database backup")
+	out, err := bk.Run(context.Background())
+	if err != nil {
+		if errors.Is(err, backup.ErrRunning) {
+			return out, err
+		}
+
+		lo.Printf("error running database backup: %v", err)
+		notifs.NotifySystem(i.T("maintenance.backup.failed"), notifs.TplBackupStatus, map[string]any{
+			"Status": "failed",
+			"Method": bk.Method(),
+			"Reason": err.Error(),
+		}, nil)
+		return out, err
+	}
+	lo.Println("finished database backup")
+
+	return out, nil
+}
@@ -1,12 +1,25 @@
 package main
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/gofrs/uuid/v5"
 	"github.com/knadh/listmonk/internal/core"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/models"
 	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	// sendFailureRetryInterval is the minimum interval between the retries of a
+	// failed campaign message.
+	sendFailureRetryInterval = time.Minute
+
+	// maxSendFailureErrLen is the max length of a recorded send error.
+	maxSendFailureErrLen = 1000
 )
 
 // store implements DataSource over the primary
@@ -18,11 +31,21 @@ type store struct {
 }
 
 type runningCamp struct {
-	CampaignID       int    `db:"campaign_id"`
-	CampaignType     string `db:"campaign_type"`
-	LastSubscriberID int    `db:"last_subscriber_id"`
-	MaxSubscriberID  int    `db:"max_subscriber_id"`
-	ListID           int    `db:"list_id"`
+	CampaignID       int           `db:"campaign_id"`
+	CampaignType     string        `db:"campaign_type"`
+	LastSubscriberID int           `db:"last_subscriber_id"`
+	MaxSubscriberID  int           `db:"max_subscriber_id"`
+	ExcludeListIDs   pq.Int64Array `db:"exclude_list_ids"`
+	TopicIDs         pq.Int64Array `db:"topic_ids"`
+	ListID           int           `db:"list_id"`
+
+	// Optional segment of the campaign and the values bound to its params.
+	SegmentID     null.Int             `db:"segment_id"`
+	SegmentParams models.SegmentValues `db:"segment_params"`
+
+	// If the campaign's audience is frozen, it's only sent to the subscribers
+	// in its snapshot.
+	AudienceFrozen bool `db:"audience_frozen"`
 }
 
 func newManagerStore(q *models.Queries, c *core.Core, m media.Store) *store {
@@ -35,18 +58,31 @@ func newManagerStore(q *models.Queries, c *core.Core, m media.Store) *store {
 
 // NextCampaigns retrieves active campaigns ready to be processed excluding
 // campaigns that are also being processed. Additionally, it takes a map of campaignID:sentCount
-// of campaigns that are being processed and updates them in the DB.
-func (s *store) NextCampaigns(currentIDs []int64, sentCounts []int64) ([]*models.Campaign, error) {
+// of campaigns that are being processed and updates them in the DB. At most limit
+// campaigns are returned (-1 for no limit).
+func (s *store) NextCampaigns(currentIDs []int64, sentCounts []int64, limit int) ([]*models.Campaign, error) {
 	var out []*models.Campaign
-	err := s.queries.NextCampaigns.Select(&out, pq.Int64Array(currentIDs), pq.Int64Array(sentCounts))
+	err := s.queries.NextCampaigns.Select(&out, pq.Int64Array(currentIDs), pq.Int64Array(sentCounts), limit)
 	return out, err
 }
 
+// QueueCampaigns queues the campaigns that are due to run, other than the ones
+// being processed, as scheduled to be retried at the given time.
+func (s *store) QueueCampaigns(currentIDs []int64, retryAt time.Time) (int, error) {
+	res, err := s.queries.QueueCampaigns.Exec(pq.Int64Array(currentIDs), retryAt)
+	if err != nil {
+		return 0, err
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
 // NextSubscribers retrieves a subset of subscribers of a given campaign.
 // Since batches are processed sequentially, the retrieval is ordered by ID,
 // and every batch takes the last ID of the last batch and fetches the next
 // batch above that.
-func (s *store) NextSubscribers(campID, limit int) ([]models.Subscriber, error) {
+func (s *store) NextSubscribers(campID, limit int, tz *manager.TimezoneFilter) ([]models.Subscriber, error) {
 	var camps []runningCamp
 	if err := s.queries.GetRunningCampaign.Select(&camps, campID); err != nil {
 		return nil, err
@@ -61,11 +97,179 @@ func (s *store) NextSubscribers(campID, limit int) ([]models.Subscriber, error)
 		return nil, nil
 	}
 
+	// If the campaign targets a segment, only the subscribers on its lists that match
+	// the segment are messaged. A frozen audience was already matched against the
+	// segment when it was snapshotted.
+	var (
+		camp = camps[0]
+		seg  *models.Segment
+	)
+	if camp.SegmentID.Valid && !camp.AudienceFrozen {
+		sg, err := s.core.GetSegment(camp.SegmentID.Int)
+		if err != nil {
+			return nil, err
+		}
+		seg = &sg
+	}
+
+	// If the campaign is sent in timezone waves, only the subscribers in the current wave are messaged.
+	var tzIn, tzNotIn []string
+	if tz != nil {
+		if tz.Exclude {
+			tzNotIn = tz.Timezones
+		} else {
+			tzIn = tz.Timezones
+		}
+	}
+
 	var out []models.Subscriber
-	err := s.queries.NextCampaignSubscribers.Select(&out, camps[0].CampaignID, camps[0].CampaignType, camps[0].LastSubscriberID, camps[0].MaxSubscriberID, pq.Array(listIDs), limit)
+	for {
+		out = nil
+		if err := s.queries.NextCampaignSubscribers.Select(&out, camp.CampaignID, camp.CampaignType, camp.LastSubscriberID, camp.MaxSubscriberID,
+			pq.Array(listIDs), limit, camp.ExcludeListIDs, camp.TopicIDs, camp.AudienceFrozen, pq.Array(tzIn), pq.Array(tzNotIn)); err != nil {
+			return nil, err
+		}
+		if seg == nil || len(out) == 0 {
+			break
+		}
+
+		// The query moves the campaign's checkpoint past the batch.
+		camp.LastSubscriberID = out[len(out)-1].ID
+
+		var err error
+		if out, err = s.filterSegmentSubscribers(*seg, camp.SegmentParams, out); err != nil {
+			return nil, err
+		}
+
+		// An empty batch marks the end of the campaign. If none of the subscribers
+		// in the batch match the segment, move on to the next batch.
+		if len(out) > 0 {
+			break
+		}
+	}
+
+	// Decrypt the attributes of subscribers in sensitive lists.
+	if err := s.core.DecryptSubscribers(out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// filterSegmentSubscribers returns the subscribers that match a segment with the given param values.
+func (s *store) filterSegmentSubscribers(seg models.Segment, params models.SegmentValues, subs []models.Subscriber) ([]models.Subscriber, error) {
+	ids := make([]int, len(subs))
+	for i, sub := range subs {
+		ids[i] = sub.ID
+	}
+
+	matched, err := s.core.FilterSegmentSubscribers(seg, params, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	ok := make(map[int]struct{}, len(matched))
+	for _, id := range matched {
+		ok[id] = struct{}{}
+	}
+
+	out := make([]models.Subscriber, 0, len(matched))
+	for _, sub := range subs {
+		if _, has := ok[sub.ID]; has {
+			out = append(out, sub)
+		}
+	}
+
+	return out, nil
+}
+
+// RecordSendFailure records the failure to send a campaign's message to a subscriber,
+// after the given number of retries, so that it can be retried.
+func (s *store) RecordSendFailure(campID, subID, retries int, sendErr string) error {
+	if len(sendErr) > maxSendFailureErrLen {
+		sendErr = sendErr[:maxSendFailureErrLen]
+	}
+
+	_, err := s.queries.RecordCampaignSendFailure.Exec(campID, subID, sendErr, retries)
+	return err
+}
+
+// RecordCampaignSends records a batch of campaign messages sent to subscribers
+// in the send log.
+func (s *store) RecordCampaignSends(sends []models.CampaignSend) error {
+	var (
+		campIDs  = make([]int64, len(sends))
+		subIDs   = make([]int64, len(sends))
+		subjects = make([]string, len(sends))
+		sentAt   = make([]string, len(sends))
+		tests    = make([]bool, len(sends))
+	)
+	for i, m := range sends {
+		campIDs[i] = int64(m.CampaignID)
+		subIDs[i] = int64(m.SubscriberID)
+		subjects[i] = m.Subject
+		sentAt[i] = m.SentAt.Format(time.RFC3339Nano)
+		tests[i] = m.Test
+	}
+
+	_, err := s.queries.RecordCampaignSends.Exec(pq.Int64Array(campIDs), pq.Int64Array(subIDs),
+		pq.StringArray(subjects), pq.StringArray(sentAt), pq.BoolArray(tests))
+	return err
+}
+
+// GetMessengerDailyStats fetches the warm-up day of a messenger and the number of
+// messages it has sent today.
+func (s *store) GetMessengerDailyStats(messenger string) (models.MessengerDailyStats, error) {
+	var out models.MessengerDailyStats
+	err := s.queries.GetMessengerDailyStats.Get(&out, messenger)
 	return out, err
 }
 
+// RecordMessengerSends adds to the number of messages sent by a messenger today.
+func (s *store) RecordMessengerSends(messenger string, n int) error {
+	_, err := s.queries.RecordMessengerSends.Exec(messenger, n)
+	return err
+}
+
+// DeleteSendFailure deletes the failure of a campaign message that was sent on retry.
+func (s *store) DeleteSendFailure(campID, subID int) error {
+	_, err := s.queries.DeleteCampaignSendFailure.Exec(campID, subID)
+	return err
+}
+
+// RetrySendFailures marks the failed messages of a campaign as retried and returns
+// the subscribers to retry them to. Failures that were attempted recently may still
+// be in the queue and are skipped.
+func (s *store) RetrySendFailures(campID int) ([]models.Subscriber, error) {
+	var out []models.Subscriber
+	if err := s.queries.RetryCampaignSendFailures.Select(&out, campID, int(sendFailureRetryInterval.Seconds())); err != nil {
+		return nil, err
+	}
+
+	// Decrypt the attributes of subscribers in sensitive lists.
+	if err := s.core.DecryptSubscribers(out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// GetTestCohort returns the first limit subscribers (by creation) of one of a campaign's
+// lists who can be sent the campaign and haven't been sent a test of it yet.
+func (s *store) GetTestCohort(campID, listID, limit int) ([]models.Subscriber, error) {
+	var out []models.Subscriber
+	if err := s.queries.GetCampaignTestCohort.Select(&out, campID, listID, limit); err != nil {
+		return nil, err
+	}
+
+	// Decrypt the attributes of subscribers in sensitive lists.
+	if err := s.core.DecryptSubscribers(out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
 // GetCampaign fetches a campaign from the database.
 func (s *store) GetCampaign(campID int) (*models.Campaign, error) {
 	var out = &models.Campaign{}
@@ -73,6 +277,38 @@ func (s *store) GetCampaign(campID int) (*models.Campaign, error) {
 	return out, err
 }
 
+// GetCampaignTimezones fetches the distinct timezones of the subscribers in a campaign's lists.
+func (s *store) GetCampaignTimezones(campID int) ([]string, error) {
+	var out []string
+	err := s.queries.GetCampaignTimezones.Select(&out, campID)
+	return out, err
+}
+
+// UpdateCampaignWave moves a running campaign on to the timezone wave that's due at
+// the given time. It returns false if the campaign is no longer running.
+func (s *store) UpdateCampaignWave(campID int, due time.Time) (bool, error) {
+	res, err := s.queries.UpdateCampaignWave.Exec(campID, due)
+	if err != nil {
+		return false, err
+	}
+
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// UpdateCampaignRemoteBody saves the body of a campaign fetched from its body URL.
+func (s *store) UpdateCampaignRemoteBody(campID int, body string) error {
+	_, err := s.queries.UpdateCampaignRemoteBody.Exec(campID, body)
+	return err
+}
+
+// GetCampaignLists fetches the lists of a campaign.
+func (s *store) GetCampaignLists(campID int) ([]models.List, error) {
+	var out []models.List
+	err := s.queries.GetCampaignLists.Select(&out, campID)
+	return out, err
+}
+
 // UpdateCampaignStatus updates a campaign's status.
 func (s *store) UpdateCampaignStatus(campID int, status string) error {
 	_, err := s.queries.UpdateCampaignStatus.Exec(campID, status)
@@ -85,6 +321,41 @@ func (s *store) UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID
 	return err
 }
 
+// UpdateCampaignRenderStats records the sampled render stats of a campaign's messages.
+func (s *store) UpdateCampaignRenderStats(campID int, st models.RenderStats) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.queries.UpdateCampaignRenderStats.Exec(campID, b)
+	return err
+}
+
+// GetCampaignRevision fetches the current content revision of a campaign.
+func (s *store) GetCampaignRevision(campID int) (int, error) {
+	var out int
+	err := s.queries.GetCampaignRevision.Get(&out, campID)
+	return out, err
+}
+
+// GetTemplateAttribs fetches the current filter of subscriber attributes
+// available to templates from the settings.
+func (s *store) GetTemplateAttribs() (models.AttribFilter, error) {
+	set, err := s.core.GetSettings()
+	if err != nil {
+		return models.AttribFilter{}, err
+	}
+	return set.PrivacyTemplateAttribs, nil
+}
+
+// ApplyCampaignRevision records the subscriber checkpoint at which a running
+// campaign was switched over to a content revision.
+func (s *store) ApplyCampaignRevision(campID int, revision int) error {
+	_, err := s.queries.ApplyCampaignRevision.Exec(campID, revision)
+	return err
+}
+
 // GetAttachment fetches a media attachment blob.
 func (s *store) GetAttachment(mediaID int) (models.Attachment, error) {
 	m, err := s.core.GetMedia(mediaID, "", "", s.media)
@@ -171,6 +442,6 @@ func (s *store) BlocklistSubscriber(id int64) error {
 
 // DeleteSubscriber deletes a subscriber from the DB.
 func (s *store) DeleteSubscriber(id int64) error {
-	_, err := s.queries.DeleteSubscribers.Exec(pq.Int64Array{id})
+	_, err := s.queries.DeleteSubscribers.Exec(pq.Int64Array{id}, pq.StringArray{}, models.SubscriberAnonymizedEmail)
 	return err
 }
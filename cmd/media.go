@@ -1,13 +1,27 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
-	"mime/multipart"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/disintegration/imaging"
+	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
@@ -15,13 +29,33 @@ import (
 const (
 	thumbPrefix   = "thumb_"
 	thumbnailSize = 250
+
+	// maxBase64MediaSize is the max size of a decoded base64 media upload.
+	maxBase64MediaSize = 10 * 1024 * 1024
+
+	// Timeout for the whole of the media store health check.
+	mediaHealthCheckTimeout = time.Second * 5
 )
 
 var (
 	vectorExts = []string{"svg"}
 	imageExts  = []string{"gif", "png", "jpg", "jpeg"}
+
+	// Best-effort retries for transient media store failures (eg: S3 503s).
+	mediaRetryOpt = media.RetryOpt{
+		MaxTries: 3,
+		Wait:     time.Millisecond * 500,
+		Timeout:  time.Second * 60,
+	}
 )
 
+// mediaUploadResp is the response of a media upload along with the warnings
+// of partial failures, eg: the file was stored but its thumbnail wasn't.
+type mediaUploadResp struct {
+	media.Media
+	Warnings []string `json:"warnings,omitempty"`
+}
+
 // UploadMedia handles media file uploads.
 func (a *App) UploadMedia(c echo.Context) error {
 	file, err := c.FormFile("file")
@@ -38,39 +72,113 @@ func (a *App) UploadMedia(c echo.Context) error {
 	}
 	defer src.Close()
 
+	out, err := a.storeMedia(c, file.Filename, file.Header.Get("Content-Type"), src)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UploadMediaBase64 handles media uploads where the file is base64 encoded in a
+// JSON body, eg: images pasted into editors. data can also be a data URI.
+func (a *App) UploadMediaBase64(c echo.Context) error {
+	var req struct {
+		Filename string `json:"filename"`
+		Data     string `json:"data"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	// Strip the data URI prefix, if any: data:image/png;base64,....
+	data := req.Data
+	if strings.HasPrefix(data, "data:") {
+		if i := strings.Index(data, ","); i > -1 {
+			data = data[i+1:]
+		}
+	}
+	if data == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "data"))
+	}
+	if base64.StdEncoding.DecodedLen(len(data)) > maxBase64MediaSize {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("media.fileTooLarge"))
+	}
+
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("media.invalidFile", "error", err.Error()))
+	}
+
+	// Validate the content type against the file's extension as it's not
+	// possible to trust either of them on their own.
 	var (
-		// Naive check for content type and extension.
-		ext         = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
-		contentType = file.Header.Get("Content-Type")
+		ext         = strings.TrimPrefix(strings.ToLower(filepath.Ext(req.Filename)), ".")
+		contentType = http.DetectContentType(b)
 	)
+	if inArray(ext, imageExts) {
+		if contentType != mime.TypeByExtension("."+ext) {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("media.unsupportedFileType", "type", contentType))
+		}
+	} else if t := mime.TypeByExtension("." + ext); t != "" {
+		// Sniffing doesn't detect all types, eg: SVGs, which are sniffed as text.
+		contentType = t
+	}
+
+	out, err := a.storeMedia(c, req.Filename, contentType, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// storeMedia validates a media file, uploads it and its thumbnail (for images) to
+// the media store, and records it in the DB.
+func (a *App) storeMedia(c echo.Context, filename, contentType string, src io.ReadSeeker) (mediaUploadResp, error) {
+	// Naive check for extension.
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
 
 	// Validate file extension.
 	if !inArray("*", a.cfg.MediaUpload.Extensions) {
 		if ok := inArray(ext, a.cfg.MediaUpload.Extensions); !ok {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return mediaUploadResp{}, echo.NewHTTPError(http.StatusBadRequest,
 				a.i18n.Ts("media.unsupportedFileType", "type", ext))
 		}
 	}
 
+	// Checksum of the file for deduplicating uploads.
+	checksum, err := fileChecksum(src)
+	if err != nil {
+		return mediaUploadResp{}, echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("media.errorReadingFile", "error", err.Error()))
+	}
+
 	// Sanitize the filename.
-	fName := makeFilename(file.Filename)
+	fName := makeFilename(filename)
 
 	// If the filename already exists in the DB, make it unique by adding a random suffix.
 	if _, err := a.core.GetMedia(0, "", fName, a.media); err == nil {
 		suffix, err := generateRandomString(6)
 		if err != nil {
 			a.log.Printf("error generating random string: %v", err)
-			return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+			return mediaUploadResp{}, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
 		}
 
 		fName = appendSuffixToFilename(fName, suffix)
 	}
 
 	// Upload the file to the media store.
-	fName, err = a.media.Put(fName, contentType, src)
+	var (
+		store = media.WithRetry(a.media, mediaRetryOpt)
+		ctx   = c.Request().Context()
+	)
+	fName, err = store.Put(ctx, fName, contentType, src)
 	if err != nil {
 		a.log.Printf("error uploading file: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError,
+		return mediaUploadResp{}, echo.NewHTTPError(mediaErrStatus(err),
 			a.i18n.Ts("media.errorUploading", "error", err.Error()))
 	}
 
@@ -79,13 +187,19 @@ func (a *App) UploadMedia(c echo.Context) error {
 	var (
 		cleanUp    = false
 		thumbfName = ""
+		warnings   []string
 	)
 	defer func() {
 		if cleanUp {
-			a.media.Delete(fName)
+			// The request context may have been cancelled.
+			if err := store.Delete(context.Background(), fName); err != nil {
+				a.log.Printf("error cleaning up file %s: %v", fName, err)
+			}
 
-			if thumbfName != "" {
-				a.media.Delete(thumbfName)
+			if thumbfName != "" && thumbfName != fName {
+				if err := store.Delete(context.Background(), thumbfName); err != nil {
+					a.log.Printf("error cleaning up thumbnail %s: %v", thumbfName, err)
+				}
 			}
 		}
 	}()
@@ -96,25 +210,30 @@ func (a *App) UploadMedia(c echo.Context) error {
 	// Create thumbnail from file for non-vector formats.
 	isImage := inArray(ext, imageExts)
 	if isImage {
-		thumbFile, wi, he, err := processImage(file)
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			cleanUp = true
+			return mediaUploadResp{}, echo.NewHTTPError(http.StatusInternalServerError,
+				a.i18n.Ts("media.errorReadingFile", "error", err.Error()))
+		}
+
+		thumbFile, wi, he, err := processImage(src)
 		if err != nil {
 			cleanUp = true
 			a.log.Printf("error resizing image: %v", err)
-			return echo.NewHTTPError(http.StatusInternalServerError,
+			return mediaUploadResp{}, echo.NewHTTPError(http.StatusInternalServerError,
 				a.i18n.Ts("media.errorResizing", "error", err.Error()))
 		}
 		width = wi
 		height = he
 
-		// Upload thumbnail.
-		tf, err := a.media.Put(thumbPrefix+fName, contentType, thumbFile)
-		if err != nil {
-			cleanUp = true
+		// Upload thumbnail. The original file is already stored, so a failure
+		// here is reported as a warning instead of failing the whole upload.
+		if tf, err := store.Put(ctx, thumbPrefix+fName, contentType, thumbFile); err != nil {
 			a.log.Printf("error saving thumbnail: %v", err)
-			return echo.NewHTTPError(http.StatusInternalServerError,
-				a.i18n.Ts("media.errorSavingThumbnail", "error", err.Error()))
+			warnings = append(warnings, a.i18n.Ts("media.errorSavingThumbnail", "error", err.Error()))
+		} else {
+			thumbfName = tf
 		}
-		thumbfName = tf
 	}
 	if inArray(ext, vectorExts) {
 		thumbfName = fName
@@ -130,13 +249,13 @@ func (a *App) UploadMedia(c echo.Context) error {
 	}
 
 	// Insert the media into the DB.
-	m, err := a.core.InsertMedia(fName, thumbfName, contentType, meta, a.cfg.MediaUpload.Provider, a.media)
+	m, err := a.core.InsertMedia(fName, thumbfName, contentType, meta, checksum, a.cfg.MediaUpload.Provider, a.media)
 	if err != nil {
 		cleanUp = true
-		return err
+		return mediaUploadResp{}, err
 	}
 
-	return c.JSON(http.StatusOK, okResp{m})
+	return mediaUploadResp{Media: m, Warnings: warnings}, nil
 }
 
 // GetAllMedia handles retrieval of uploaded media.
@@ -176,21 +295,191 @@ func (a *App) GetMedia(c echo.Context) error {
 
 // DeleteMedia handles deletion of uploaded media.
 func (a *App) DeleteMedia(c echo.Context) error {
-
-	// Delete the media from the DB. The query returns the filename.
 	id := getID(c)
-	fname, err := a.core.DeleteMedia(id)
+	m, err := a.core.GetMedia(id, "", "", a.media)
 	if err != nil {
 		return err
 	}
 
-	// Delete the files from the media store.
-	a.media.Delete(fname)
-	a.media.Delete(thumbPrefix + fname)
+	// Delete the file from the media store before the DB record. If it fails transiently
+	// (even after retries), the DB record is retained so that the deletion can be retried
+	// instead of orphaning the file in the store. Permanent failures (eg: the file
+	// doesn't exist) don't block the deletion.
+	var (
+		store = media.WithRetry(a.media, mediaRetryOpt)
+		ctx   = c.Request().Context()
+	)
+	if err := store.Delete(ctx, m.Filename); err != nil {
+		a.log.Printf("error deleting media file %s: %v", m.Filename, err)
+		if media.IsTransient(err) {
+			return echo.NewHTTPError(http.StatusServiceUnavailable,
+				a.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.media}", "error", err.Error()))
+		}
+	}
+	if m.Thumb != "" && m.Thumb != m.Filename {
+		if err := store.Delete(ctx, m.Thumb); err != nil {
+			a.log.Printf("error deleting media thumbnail %s: %v", m.Thumb, err)
+		}
+	}
+
+	// Delete the media from the DB.
+	if _, err := a.core.DeleteMedia(id); err != nil {
+		return err
+	}
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// DownloadMediaArchive handles the bulk download of media files as a ZIP archive.
+// The archive is written to a temporary file so that exceeding the max. archive
+// size can be reported as an error before anything is sent to the client.
+func (a *App) DownloadMediaArchive(c echo.Context) error {
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if len(req.IDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "ids"))
+	}
+
+	// Fetch the media items from the DB before fetching any of the files.
+	items := make([]media.Media, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		m, err := a.core.GetMedia(id, "", "", a.media)
+		if err != nil {
+			return err
+		}
+		items = append(items, m)
+	}
+
+	f, err := os.CreateTemp("", "listmonk-media-*.zip")
+	if err != nil {
+		a.log.Printf("error creating media archive: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	var (
+		zw      = zip.NewWriter(f)
+		maxSize = int64(a.cfg.MediaUpload.MaxArchiveSizeMB) * 1024 * 1024
+		size    int64
+		seen    = map[string]struct{}{}
+	)
+	for _, m := range items {
+		// Files are fetched one at a time to not hold all of them in memory.
+		b, err := a.media.GetBlob(m.Filename)
+		if err != nil {
+			a.log.Printf("error fetching media file %s: %v", m.Filename, err)
+			return echo.NewHTTPError(mediaErrStatus(err),
+				a.i18n.Ts("media.errorReadingFile", "error", err.Error()))
+		}
+
+		size += int64(len(b))
+		if size > maxSize {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("media.archiveTooLarge", "size", strconv.Itoa(a.cfg.MediaUpload.MaxArchiveSizeMB)))
+		}
+
+		// The same file may be requested more than once.
+		if _, ok := seen[m.Filename]; ok {
+			continue
+		}
+		seen[m.Filename] = struct{}{}
+
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     m.Filename,
+			Method:   zip.Deflate,
+			Modified: m.CreatedAt.Time,
+		})
+		if err == nil {
+			_, err = w.Write(b)
+		}
+		if err != nil {
+			a.log.Printf("error writing media archive: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+		}
+	}
+	if err := zw.Close(); err != nil {
+		a.log.Printf("error writing media archive: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		a.log.Printf("error reading media archive: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="media.zip"`)
+	return c.Stream(http.StatusOK, "application/zip", f)
+}
+
+// mediaErrStatus returns the HTTP status code for a media store error.
+func mediaErrStatus(err error) int {
+	if media.IsTransient(err) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// checkMediaStore checks that the media store is functional by uploading a tiny
+// test file, reading it back, and deleting it, all within the health check timeout.
+func (a *App) checkMediaStore(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, mediaHealthCheckTimeout)
+	defer cancel()
+
+	suffix, err := generateRandomString(12)
+	if err != nil {
+		return err
+	}
+
+	// GetBlob doesn't take a context, so the check runs in the background
+	// and is abandoned if it doesn't finish in time.
+	errCh := make(chan error, 1)
+	go func() {
+		var (
+			name = "health_check_" + suffix + ".txt"
+			body = []byte("listmonk health check " + suffix)
+		)
+
+		fName, err := a.media.Put(ctx, name, "text/plain", bytes.NewReader(body))
+		if err != nil {
+			errCh <- fmt.Errorf("error uploading file: %w", err)
+			return
+		}
+
+		b, rErr := a.media.GetBlob(fName)
+
+		// Clean up regardless of the read back, even if the check has timed out.
+		if err := a.media.Delete(context.Background(), fName); err != nil {
+			a.log.Printf("error deleting media health check file %s: %v", fName, err)
+			errCh <- fmt.Errorf("error deleting file: %w", err)
+			return
+		}
+
+		if rErr != nil {
+			errCh <- fmt.Errorf("error reading file: %w", rErr)
+			return
+		}
+		if !bytes.Equal(b, body) {
+			errCh <- errors.New("file read back does not match the uploaded file")
+			return
+		}
+		errCh <- nil
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", mediaHealthCheckTimeout)
+	}
+}
+
 // ServeS3Media serves media files stored in S3 when the public URL is a relative path.
 func (a *App) ServeS3Media(c echo.Context) error {
 	key := c.Param("filepath")
@@ -198,6 +487,11 @@ func (a *App) ServeS3Media(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "missing media file path")
 	}
 
+	// Files in private directories are never served.
+	if k, err := url.PathUnescape(key); err != nil || media.IsPrivate(path.Clean("/"+k)) {
+		return echo.NewHTTPError(http.StatusNotFound, "media not found")
+	}
+
 	b, err := a.media.GetBlob(key)
 	if err != nil {
 		a.log.Printf("error fetching media from s3 %s: %v", key, err)
@@ -207,15 +501,9 @@ func (a *App) ServeS3Media(c echo.Context) error {
 	return c.Stream(http.StatusOK, http.DetectContentType(b), bytes.NewReader(b))
 }
 
-// processImage reads the image file and returns thumbnail bytes and
+// processImage reads the image and returns thumbnail bytes and
 // the original image's width, and height.
-func processImage(file *multipart.FileHeader) (*bytes.Reader, int, int, error) {
-	src, err := file.Open()
-	if err != nil {
-		return nil, 0, 0, err
-	}
-	defer src.Close()
-
+func processImage(src io.Reader) (*bytes.Reader, int, int, error) {
 	img, err := imaging.Decode(src)
 	if err != nil {
 		return nil, 0, 0, err
@@ -233,3 +521,16 @@ func processImage(file *multipart.FileHeader) (*bytes.Reader, int, int, error) {
 	b := img.Bounds().Max
 	return bytes.NewReader(out.Bytes()), b.X, b.Y, nil
 }
+
+// fileChecksum returns the hex SHA-256 checksum of a file and rewinds it.
+func fileChecksum(src io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, src); err != nil {
+		return "", err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Interval at which campaign bounce rates are checked for spikes.
+	bounceSpikeCheckInterval = time.Minute * 5
+
+	// Minimum number of messages a campaign should have sent for its
+	// bounce rate to be considered for spikes.
+	bounceSpikeMinSent = 100
+
+	// Interval at which the bounces by originating IP are checked for spikes.
+	ipBounceCheckInterval = time.Minute * 5
+
+	// Interval at which the DB connection pool is checked for exhaustion.
+	dbPoolCheckInterval = time.Second * 15
+)
+
+// GetNotifications returns the log of admin notifications sent for critical events.
+func (a *App) GetNotifications(c echo.Context) error {
+	var (
+		typ = c.FormValue("type")
+		pg  = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+
+	res, total, err := a.core.QueryNotifications(typ, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	// No results.
+	if len(res) == 0 {
+		return c.JSON(http.StatusOK, okResp{models.PageResults{Results: []models.Notification{}}})
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// checkBounceSpikes notifies the admin of campaigns the recent bounces of which
+// are at least threshold percent of the messages they have sent.
+func checkBounceSpikes(co *core.Core, window time.Duration, threshold float64, i *i18n.I18n) {
+	rates, err := co.GetCampaignBounceRates(window, threshold, bounceSpikeMinSent)
+	if err != nil {
+		return
+	}
+
+	for _, r := range rates {
+		notifs.Alert(models.NotificationBounceSpike, strconv.Itoa(r.CampaignID),
+			i.Ts("notifications.bounceSpike", "name", r.Name, "rate", strconv.FormatFloat(r.Rate, 'f', -1, 64)),
+			map[string]any{
+				"campaign": r.Name,
+				"id":       r.CampaignID,
+				"sent":     r.Sent,
+				"bounces":  r.Bounces,
+				"rate":     r.Rate,
+				"window":   window.String(),
+			})
+	}
+}
+
+// reReceivedIP matches the IP addresses in a Received header, eg:
+// "from mx.example.com (mx.example.com [203.0.113.7])" or "[IPv6:2001:db8::1]".
+var reReceivedIP = regexp.MustCompile(`\[(?:IPv6:)?([0-9a-fA-F.:]+)\]`)
+
+// checkIPBounceSpikes aggregates the bounces recorded within the window by the IP
+// address they originated from, records the counts in the IP reputation stats,
+// and notifies the admin of the IPs with at least threshold bounces.
+func checkIPBounceSpikes(co *core.Core, window time.Duration, threshold int, i *i18n.I18n) {
+	headers, err := co.GetBounceReceivedHeaders(window)
+	if err != nil || len(headers) == 0 {
+		return
+	}
+
+	counts := map[string]int{}
+	for _, h := range headers {
+		if ip := originatingIP(h); ip != "" {
+			counts[ip]++
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+
+	out := make([]models.IPBounceCount, 0, len(counts))
+	for ip, n := range counts {
+		out = append(out, models.IPBounceCount{IP: ip, Bounces: n})
+	}
+	if err := co.RecordIPReputationStats(out, len(headers), window); err != nil {
+		return
+	}
+
+	for _, c := range out {
+		if c.Bounces < threshold {
+			continue
+		}
+
+		rate := math.Round(float64(c.Bounces)*10000/float64(len(headers))) / 100
+		notifs.Alert(models.NotificationIPBounceSpike, c.IP,
+			i.Ts("notifications.ipBounceSpike", "ip", c.IP, "num", strconv.Itoa(c.Bounces), "window", window.String()),
+			map[string]any{
+				"ip":            c.IP,
+				"bounces":       c.Bounces,
+				"total_bounces": len(headers),
+				"rate":          rate,
+				"window":        window.String(),
+			})
+	}
+}
+
+// originatingIP returns the first public IP address in the earliest hop of a bounce
+// message's Received headers, that is, the server the bounce originated from. As every
+// hop prepends its header, the earliest hop is the last header.
+func originatingIP(received []string) string {
+	for n := len(received) - 1; n >= 0; n-- {
+		for _, m := range reReceivedIP.FindAllStringSubmatch(received[n], -1) {
+			ip := net.ParseIP(m[1])
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+
+			return ip.String()
+		}
+	}
+
+	return ""
+}
+
+// monitorDBPool periodically checks the DB connection pool and notifies the admin
+// when all the connections are in use and queries have had to wait for one.
+func monitorDBPool(db *sqlx.DB, i *i18n.I18n) {
+	var lastWaits int64
+	for range time.Tick(dbPoolCheckInterval) {
+		s := db.Stats()
+
+		waits := s.WaitCount - lastWaits
+		lastWaits = s.WaitCount
+
+		if s.MaxOpenConnections < 1 || s.InUse < s.MaxOpenConnections || waits < 1 {
+			continue
+		}
+
+		notifs.Alert(models.NotificationDBPool, "",
+			i.Ts("notifications.dbPool", "num", strconv.Itoa(s.MaxOpenConnections)),
+			map[string]any{
+				"max_open":      s.MaxOpenConnections,
+				"in_use":        s.InUse,
+				"idle":          s.Idle,
+				"waits":         waits,
+				"wait_duration": s.WaitDuration.String(),
+			})
+	}
+}
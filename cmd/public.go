@@ -2,18 +2,24 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"html/template"
 	"image"
 	"image/png"
 	"io"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/knadh/listmonk/internal/captcha"
 	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/isp"
+	"github.com/knadh/listmonk/internal/mailclient"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/models"
@@ -23,6 +29,12 @@ import (
 
 const (
 	tplMessage = "message"
+
+	// Public subscription rate limits per IP and per e-mail.
+	subRateLimitIP          = 5
+	subRateLimitIPWindow    = time.Hour
+	subRateLimitEmail       = 2
+	subRateLimitEmailWindow = time.Hour * 24
 )
 
 // tplRenderer wraps a template.tplRenderer for echo.
@@ -62,7 +74,9 @@ type unsubTpl struct {
 	publicTpl
 	Subscriber       models.Subscriber
 	Subscriptions    []models.Subscription
+	Topics           []models.SubscriberTopic
 	SubUUID          string
+	List             *models.List
 	AllowBlocklist   bool
 	AllowExport      bool
 	AllowWipe        bool
@@ -74,6 +88,11 @@ type optinReq struct {
 	SubUUID   string
 	ListUUIDs []string      `query:"l" form:"l"`
 	Lists     []models.List `query:"-" form:"-"`
+
+	// ConfirmUUIDs are the lists checked on the multi-list confirmation
+	// form (Granular). Unchecked lists are declined.
+	ConfirmUUIDs []string `query:"-" form:"c"`
+	Granular     bool     `query:"-" form:"granular"`
 }
 
 type optinTpl struct {
@@ -98,6 +117,13 @@ type subFormTpl struct {
 	}
 }
 
+type landingPageTpl struct {
+	subFormTpl
+	List      models.List
+	PublicCSS template.CSS
+	CustomCSS template.CSS
+}
+
 var (
 	pixelPNG = drawTransparentImage(3, 14)
 )
@@ -162,8 +188,7 @@ func (a *App) ViewCampaignMessage(c echo.Context) error {
 	}
 
 	// Get the subscriber.
-	subUUID := c.Param("subUUID")
-	sub, err := a.core.GetSubscriber(0, subUUID, "")
+	sub, err := a.core.GetSubscriberByToken(c.Param("subUUID"))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.Render(http.StatusNotFound, tplMessage,
@@ -196,21 +221,18 @@ func (a *App) ViewCampaignMessage(c echo.Context) error {
 // This is the view that {{ UnsubscribeURL }} in campaigns link to.
 func (a *App) SubscriptionPage(c echo.Context) error {
 	var (
-		subUUID       = c.Param("subUUID")
+		s             = c.Get("sub").(models.Subscriber)
+		subUUID       = s.UUID
 		showManage, _ = strconv.ParseBool(c.FormValue("manage"))
 	)
 
-	// Get the subscriber from the DB.
-	s, err := a.core.GetSubscriber(0, subUUID, "")
-	if err != nil {
-		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
-	}
+	// Only expose the public attributes to the page.
+	s.Attribs = a.publicAttribs(s.Attribs)
 
 	// Prepare the public template.
 	out := unsubTpl{
 		Subscriber:       s,
-		SubUUID:          subUUID,
+		SubUUID:          s.UnsubscribeToken,
 		publicTpl:        publicTpl{Title: a.i18n.T("public.unsubscribeTitle")},
 		AllowBlocklist:   a.cfg.Privacy.AllowBlocklist,
 		AllowExport:      a.cfg.Privacy.AllowExport,
@@ -223,6 +245,36 @@ func (a *App) SubscriptionPage(c echo.Context) error {
 		return c.Render(http.StatusOK, tplMessage, makeMsgTpl(a.i18n.T("public.noSubTitle"), "", a.i18n.Ts("public.blocklisted")))
 	}
 
+	// A click on the unsubscribe link in a campaign is recorded and redirected to the
+	// confirmation page, where the unsubscription is confirmed. The redirect ensures
+	// that reloads of the confirmation page aren't recorded as clicks.
+	if confirm, _ := strconv.ParseBool(c.QueryParam("confirm")); !confirm && !showManage {
+		if campUUID := c.Param("campUUID"); campUUID != dummyUUID && !a.cfg.Privacy.DisableTracking {
+			if err := a.core.RegisterUnsubscribeClick(campUUID, s.ID); err != nil {
+				a.log.Printf("error registering unsubscribe click: %v", err)
+			}
+		}
+
+		q := c.QueryParams()
+		q.Set("confirm", "true")
+		return c.Redirect(http.StatusSeeOther, fmt.Sprintf(a.urlCfg.UnsubURL, c.Param("campUUID"), c.Param("subUUID"))+"?"+q.Encode())
+	}
+
+	// If it's a list-specific unsubscription ({{ unsubscribeURL .List }}), get the list.
+	if listUUID := c.QueryParam("list_uuid"); listUUID != "" {
+		if !reUUID.MatchString(listUUID) {
+			return c.Render(http.StatusBadRequest, tplMessage,
+				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("globals.messages.invalidUUID")))
+		}
+
+		l, err := a.core.GetList(0, listUUID)
+		if err != nil {
+			return c.Render(http.StatusBadRequest, tplMessage,
+				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorFetchingLists")))
+		}
+		out.List = &l
+	}
+
 	// Only show preference management if it's enabled in settings.
 	if a.cfg.Privacy.AllowPreferences {
 		out.ShowManage = showManage
@@ -242,6 +294,14 @@ func (a *App) SubscriptionPage(c echo.Context) error {
 
 			out.Subscriptions = append(out.Subscriptions, s)
 		}
+
+		// Get the topics and the subscriber's preference for them.
+		topics, err := a.core.GetSubscriberTopics(s.ID)
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, tplMessage,
+				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorProcessingRequest")))
+		}
+		out.Topics = topics
 	}
 
 	return c.Render(http.StatusOK, "subscription", out)
@@ -255,8 +315,12 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 	var req struct {
 		Name      string   `form:"name" json:"name"`
 		ListUUIDs []string `form:"l" json:"list_uuids"`
+		TopicIDs  []int    `form:"t" json:"topic_ids"`
 		Blocklist bool     `form:"blocklist" json:"blocklist"`
 		Manage    bool     `form:"manage" json:"manage"`
+
+		// Color scheme preferred by the subscriber's browser.
+		ColorScheme string `form:"prefers_color_scheme" json:"prefers_color_scheme"`
 	}
 	if err := c.Bind(&req); err != nil {
 		return c.Render(http.StatusBadRequest, tplMessage,
@@ -266,14 +330,41 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 	// Simple unsubscribe.
 	var (
 		campUUID  = c.Param("campUUID")
-		subUUID   = c.Param("subUUID")
+		sub       = c.Get("sub").(models.Subscriber)
 		blocklist = a.cfg.Privacy.AllowBlocklist && req.Blocklist
 	)
+	// Unsubscribe from a specific list ({{ unsubscribeURL .List }}).
+	if listUUID := c.QueryParam("list_uuid"); listUUID != "" && !req.Manage && !blocklist {
+		if !reUUID.MatchString(listUUID) {
+			return c.Render(http.StatusBadRequest, tplMessage,
+				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("globals.messages.invalidUUID")))
+		}
+
+		if err := a.core.UnsubscribeLists([]int{sub.ID}, nil, []string{listUUID}); err != nil {
+			return c.Render(http.StatusInternalServerError, tplMessage,
+				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorProcessingRequest")))
+		}
+		a.recordConsent(c, models.ConsentEvent{SubscriberIDs: []int{sub.ID}, Type: models.ConsentTypeUnsubscribe,
+			ListUUIDs: []string{listUUID}, CampaignUUID: campUUID, Text: a.i18n.T("subscribers.consentUnsubscribeList")})
+
+		return c.Render(http.StatusOK, tplMessage,
+			makeMsgTpl(a.i18n.T("public.unsubbedTitle"), "", a.i18n.T("public.unsubbedInfo")))
+	}
+
 	if !req.Manage || blocklist {
-		if err := a.core.UnsubscribeByCampaign(subUUID, campUUID, blocklist); err != nil {
+		// The lists are read before unsubscribing, as blocklisting unsubscribes from all of them.
+		cn := models.ConsentEvent{SubscriberIDs: []int{sub.ID}, Type: models.ConsentTypeUnsubscribe,
+			CampaignUUID: campUUID, Text: a.i18n.T("subscribers.consentUnsubscribe")}
+		if blocklist {
+			cn.AllLists = true
+			cn.Text = a.i18n.T("subscribers.consentBlocklist")
+		}
+
+		if err := a.core.UnsubscribeByCampaign(sub.UUID, campUUID, blocklist); err != nil {
 			return c.Render(http.StatusInternalServerError, tplMessage,
 				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorProcessingRequest")))
 		}
+		a.recordConsent(c, cn)
 
 		return c.Render(http.StatusOK, tplMessage,
 			makeMsgTpl(a.i18n.T("public.unsubbedTitle"), "", a.i18n.T("public.unsubbedInfo")))
@@ -292,14 +383,13 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("subscribers.invalidName")))
 	}
 
-	// Get the subscriber from the DB.
-	sub, err := a.core.GetSubscriber(0, subUUID, "")
-	if err != nil {
-		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("globals.messages.pFound",
-				"name", a.i18n.T("globals.terms.subscriber"))))
-	}
 	sub.Name = req.Name
+	if req.ColorScheme == models.ColorSchemeDark || req.ColorScheme == models.ColorSchemeLight {
+		if sub.Attribs == nil {
+			sub.Attribs = models.JSON{}
+		}
+		sub.Attribs[models.SubscriberAttribColorScheme] = req.ColorScheme
+	}
 
 	// Update the subscriber properties in the DB.
 	if _, err := a.core.UpdateSubscriber(sub.ID, sub); err != nil {
@@ -315,7 +405,7 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 	}
 
 	// Get subscription from teh DB.
-	subs, err := a.core.GetSubscriptions(0, subUUID, false)
+	subs, err := a.core.GetSubscriptions(0, sub.UUID, false)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.errorFetchingLists"))
 	}
@@ -337,6 +427,16 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorProcessingRequest")))
 
 	}
+	if len(unsubUUIDs) > 0 {
+		a.recordConsent(c, models.ConsentEvent{SubscriberIDs: []int{sub.ID}, Type: models.ConsentTypeUnsubscribe,
+			ListUUIDs: unsubUUIDs, CampaignUUID: campUUID, Text: a.i18n.T("subscribers.consentPreferences")})
+	}
+
+	// Record topic preferences. Unchecked topics are opted out of.
+	if err := a.core.UpdateSubscriberTopics(sub.ID, req.TopicIDs); err != nil {
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorProcessingRequest")))
+	}
 
 	return c.Render(http.StatusOK, tplMessage,
 		makeMsgTpl(a.i18n.T("globals.messages.done"), "", a.i18n.T("public.prefsSaved")))
@@ -347,7 +447,8 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 // notifications.
 func (a *App) OptinPage(c echo.Context) error {
 	var (
-		subUUID    = c.Param("subUUID")
+		sub        = c.Get("sub").(models.Subscriber)
+		subUUID    = sub.UUID
 		confirm, _ = strconv.ParseBool(c.FormValue("confirm"))
 		req        optinReq
 	)
@@ -379,26 +480,120 @@ func (a *App) OptinPage(c echo.Context) error {
 	}
 
 	if confirm || !a.cfg.ShowOptinPage {
-		return a.confirmOptinSubscription(c, subUUID, req.ListUUIDs, lists)
+		// On the multi-list form, only the checked lists are confirmed.
+		// Otherwise, all pending lists are confirmed in one click.
+		confirmUUIDs := make([]string, 0, len(lists))
+		if req.Granular {
+			for _, l := range req.ConfirmUUIDs {
+				if !reUUID.MatchString(l) {
+					return c.Render(http.StatusBadRequest, tplMessage,
+						makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("globals.messages.invalidUUID")))
+				}
+			}
+			confirmUUIDs = req.ConfirmUUIDs
+		} else {
+			for _, l := range lists {
+				confirmUUIDs = append(confirmUUIDs, l.UUID)
+			}
+		}
+
+		confirmed, _, err := a.confirmOptinSubscription(c, sub, lists, confirmUUIDs)
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, tplMessage,
+				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
+		}
+
+		// Every list was unchecked.
+		if len(confirmed) == 0 {
+			return c.Render(http.StatusOK, tplMessage,
+				makeMsgTpl(a.i18n.T("public.unsubbedTitle"), "", a.i18n.T("public.optinDeclined")))
+		}
+
+		return c.Render(http.StatusOK, tplMessage,
+			makeMsgTpl(a.i18n.T("public.subConfirmedTitle"), "", a.i18n.Ts("public.subConfirmed")))
 	}
 
 	var out optinTpl
 	out.Lists = lists
-	out.SubUUID = subUUID
+	out.SubUUID = sub.UnsubscribeToken
 	out.Title = a.i18n.T("public.confirmOptinSubTitle")
 
 	return c.Render(http.StatusOK, "optin", out)
 }
 
-func (a *App) confirmOptinSubscription(c echo.Context, subUUID string, listUUIDs []string, lists []models.List) error {
-	if len(listUUIDs) == 0 {
-		listUUIDs = make([]string, 0, len(lists))
-		for _, l := range lists {
-			listUUIDs = append(listUUIDs, l.UUID)
+// PublicOptin is the headless (JSON) variant of OptinPage. It confirms the
+// subscriber's pending opt-in lists given in list_uuids and unsubscribes
+// the remaining pending lists.
+func (a *App) PublicOptin(c echo.Context) error {
+	subToken := c.Param("subUUID")
+	if !reUUID.MatchString(subToken) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidUUID"))
+	}
+
+	var req struct {
+		ListUUIDs []string `json:"list_uuids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
+	}
+
+	if len(req.ListUUIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.missingFields", "name", "`list_uuids`"))
+	}
+	for _, l := range req.ListUUIDs {
+		if !reUUID.MatchString(l) {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidUUID"))
+		}
+	}
+
+	sub, err := a.core.GetSubscriberByToken(subToken)
+	if err != nil {
+		return err
+	}
+
+	// Get the list of subscription lists where the subscriber hasn't confirmed.
+	lists, err := a.core.GetSubscriberLists(0, sub.UUID, nil, nil, models.SubscriptionStatusUnconfirmed, "")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorFetchingLists"))
+	}
+	if len(lists) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.noSubInfo"))
+	}
+
+	confirmed, declined, err := a.confirmOptinSubscription(c, sub, lists, req.ListUUIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Confirmed []string `json:"confirmed"`
+		Declined  []string `json:"declined"`
+	}{confirmed, declined}})
+}
+
+// confirmOptinSubscription confirms the given list UUIDs among the subscriber's pending
+// (unconfirmed) lists and unsubscribes the rest. Every pending list gets a consent entry
+// in its subscription meta and in the consents audit trail. It returns the confirmed and declined list UUIDs.
+func (a *App) confirmOptinSubscription(c echo.Context, sub models.Subscriber, pending []models.List, confirmUUIDs []string) ([]string, []string, error) {
+	check := make(map[string]struct{}, len(confirmUUIDs))
+	for _, u := range confirmUUIDs {
+		check[u] = struct{}{}
+	}
+
+	var (
+		confirmed = make([]string, 0, len(pending))
+		declined  = make([]string, 0, len(pending))
+	)
+	for _, l := range pending {
+		if _, ok := check[l.UUID]; ok {
+			confirmed = append(confirmed, l.UUID)
+		} else {
+			declined = append(declined, l.UUID)
 		}
 	}
 
-	meta := models.JSON{}
+	meta := models.JSON{"optin_consent_at": time.Now()}
 	if a.cfg.Privacy.RecordOptinIP {
 		if h := c.Request().Header.Get("X-Forwarded-For"); h != "" {
 			meta["optin_ip"] = h
@@ -407,14 +602,27 @@ func (a *App) confirmOptinSubscription(c echo.Context, subUUID string, listUUIDs
 		}
 	}
 
-	if err := a.core.ConfirmOptionSubscription(subUUID, listUUIDs, meta); err != nil {
-		a.log.Printf("error confirming opt-in subscription: %v", err)
-		return c.Render(http.StatusInternalServerError, tplMessage,
-			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
+	if len(confirmed) > 0 {
+		meta["optin_consent"] = true
+		if err := a.core.ConfirmOptionSubscription(sub.UUID, confirmed, meta); err != nil {
+			a.log.Printf("error confirming opt-in subscription: %v", err)
+			return nil, nil, err
+		}
+		a.recordConsent(c, models.ConsentEvent{SubscriberIDs: []int{sub.ID}, Type: models.ConsentTypeMarketing,
+			ListUUIDs: confirmed, Text: a.i18n.T("subscribers.consentOptin")})
 	}
 
-	return c.Render(http.StatusOK, tplMessage,
-		makeMsgTpl(a.i18n.T("public.subConfirmedTitle"), "", a.i18n.Ts("public.subConfirmed")))
+	if len(declined) > 0 {
+		meta["optin_consent"] = false
+		if err := a.core.DeclineOptinSubscription(sub.UUID, declined, meta); err != nil {
+			a.log.Printf("error declining opt-in subscription: %v", err)
+			return nil, nil, err
+		}
+		a.recordConsent(c, models.ConsentEvent{SubscriberIDs: []int{sub.ID}, Type: models.ConsentTypeUnsubscribe,
+			ListUUIDs: declined, Text: a.i18n.T("subscribers.consentOptinDeclined")})
+	}
+
+	return confirmed, declined, nil
 }
 
 // SubscriptionFormPage handles subscription requests coming from public
@@ -432,6 +640,13 @@ func (a *App) SubscriptionFormPage(c echo.Context) error {
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingLists")))
 	}
 
+	// If list UUIDs are given (?l=uuid), only show those lists, eg: links from list QR codes.
+	if uuids := c.QueryParams()["l"]; len(uuids) > 0 {
+		lists = slices.DeleteFunc(lists, func(l models.List) bool {
+			return !slices.Contains(uuids, l.UUID)
+		})
+	}
+
 	// There are no public lists available for subscription.
 	if len(lists) == 0 {
 		return c.Render(http.StatusInternalServerError, tplMessage,
@@ -441,8 +656,13 @@ func (a *App) SubscriptionFormPage(c echo.Context) error {
 	out := subFormTpl{}
 	out.Title = a.i18n.T("public.sub")
 	out.Lists = lists
+	a.setSubFormCaptcha(&out)
 
-	// Captcha configuration for template rendering.
+	return c.Render(http.StatusOK, "subscription-form", out)
+}
+
+// setSubFormCaptcha sets the captcha configuration on a subscription form template.
+func (a *App) setSubFormCaptcha(out *subFormTpl) {
 	if a.cfg.Security.Captcha.Altcha.Enabled {
 		out.Captcha.Enabled = true
 		out.Captcha.Provider = "altcha"
@@ -452,8 +672,26 @@ func (a *App) SubscriptionFormPage(c echo.Context) error {
 		out.Captcha.Provider = "hcaptcha"
 		out.Captcha.Key = a.cfg.Security.Captcha.HCaptcha.Key
 	}
+}
 
-	return c.Render(http.StatusOK, "subscription-form", out)
+// LandingPage serves the standalone subscription landing page of a public list.
+func (a *App) LandingPage(c echo.Context) error {
+	if !a.cfg.EnablePublicSubPage {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.invalidFeature")))
+	}
+
+	lp, err := a.core.GetLandingPage(c.Param("uuid"))
+	if err != nil {
+		if e, ok := err.(*echo.HTTPError); ok && e.Code == http.StatusNotFound {
+			return c.Render(http.StatusNotFound, tplMessage,
+				makeMsgTpl("404 - "+a.i18n.T("public.notFoundTitle"), "", ""))
+		}
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
+	}
+
+	return c.HTML(http.StatusOK, lp.Body)
 }
 
 // SubscriptionForm handles subscription requests coming from public
@@ -500,7 +738,7 @@ func (a *App) SubscriptionForm(c echo.Context) error {
 		}
 	}
 
-	hasOptin, err := a.processSubForm(c)
+	hasOptin, email, err := a.processSubForm(c)
 	if err != nil {
 		e, ok := err.(*echo.HTTPError)
 		if !ok {
@@ -510,11 +748,12 @@ func (a *App) SubscriptionForm(c echo.Context) error {
 		return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", fmt.Sprintf("%s", e.Message)))
 	}
 
-	// Redirect to a custom page if a trusted '?next' is set.
+	// Redirect to a custom page if a trusted '?next' is set. The page gets the hash
+	// of the e-mail with which it can poll the status of the subscription.
 	if nextURL := strings.TrimSpace(c.FormValue("next")); nextURL != "" {
 		for _, d := range a.cfg.Security.TrustedURLs {
 			if d != "*" && nextURL == d {
-				return c.Redirect(http.StatusSeeOther, nextURL)
+				return c.Redirect(http.StatusSeeOther, a.addSubStatusHash(nextURL, email))
 			}
 		}
 	}
@@ -536,14 +775,15 @@ func (a *App) PublicSubscription(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.invalidFeature"))
 	}
 
-	hasOptin, err := a.processSubForm(c)
+	hasOptin, email, err := a.processSubForm(c)
 	if err != nil {
 		return err
 	}
 
 	return c.JSON(http.StatusOK, okResp{struct {
-		HasOptin bool `json:"has_optin"`
-	}{hasOptin}})
+		HasOptin   bool   `json:"has_optin"`
+		StatusHash string `json:"status_hash,omitempty"`
+	}{hasOptin, a.subStatusHash(email)}})
 }
 
 // LinkRedirect redirects a link UUID to its original underlying link
@@ -555,6 +795,11 @@ func (a *App) LinkRedirect(c echo.Context) error {
 		campUUID = c.Param("campUUID")
 	)
 
+	// The destination page of a click reports a conversion with the tracked link's URL.
+	if c.QueryParam("conv") == "1" {
+		return a.registerLinkConversion(c)
+	}
+
 	// If tracking is globally disabled, resolve the URL without recording a click.
 	if a.cfg.Privacy.DisableTracking {
 		url, err := a.core.GetLinkURL(linkUUID)
@@ -571,15 +816,56 @@ func (a *App) LinkRedirect(c echo.Context) error {
 		subUUID = ""
 	}
 
-	url, err := a.core.RegisterCampaignLinkClick(linkUUID, campUUID, subUUID)
+	// The content revision of the message, if the campaign's content was changed while it was running.
+	rev, _ := strconv.Atoi(c.QueryParam("r"))
+
+	// UTM params of the campaign, which are added to the URL and recorded along with the click.
+	utm := a.getCampaignUTMParams(campUUID)
+
+	url, err := a.core.RegisterCampaignLinkClick(linkUUID, campUUID, subUUID, rev, utm)
 	if err != nil {
 		e := err.(*echo.HTTPError)
 		return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", e.Error()))
 	}
+	url = a.addUTMParams(url, utm)
+	url = addConversionParams(url, linkUUID, campUUID, subUUID)
+
+	// Survey answer links ({{ surveyURL }}) are shared by all subscribers.
+	// Carry the subscriber over to the survey URL to record the answer against them.
+	if subUUID != "" && strings.HasPrefix(url, a.urlCfg.RootURL+"/survey/") {
+		url += "&s=" + subUUID
+	}
 
 	return c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
+// SurveyResponse records a subscriber's answer to a question in a campaign's
+// survey from the links generated by {{ surveyURL }} in campaigns.
+func (a *App) SurveyResponse(c echo.Context) error {
+	var (
+		campUUID   = c.Param("campUUID")
+		subToken   = c.QueryParam("s")
+		questionID = c.QueryParam("q")
+		answer     = c.QueryParam("a")
+	)
+
+	// If individual tracking is disabled, do not record the subscriber.
+	if !a.cfg.Privacy.IndividualTracking || subToken == dummyUUID || !reUUID.MatchString(subToken) {
+		subToken = ""
+	}
+
+	// Exclude dummy responses from template previews.
+	if campUUID != dummyUUID {
+		if err := a.core.RecordSurveyResponse(campUUID, subToken, questionID, answer); err != nil {
+			e := err.(*echo.HTTPError)
+			return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", e.Error()))
+		}
+	}
+
+	return c.Render(http.StatusOK, tplMessage,
+		makeMsgTpl(a.i18n.T("public.surveyThanksTitle"), "", a.i18n.T("public.surveyThanks")))
+}
+
 // RegisterCampaignView registers a campaign view which comes in
 // the form of an pixel image request. Regardless of errors, this handler
 // should always render the pixel image bytes. The pixel URL is generated by
@@ -600,7 +886,15 @@ func (a *App) RegisterCampaignView(c echo.Context) error {
 	// Exclude dummy hits from template previews.
 	campUUID := c.Param("campUUID")
 	if campUUID != dummyUUID && subUUID != dummyUUID {
-		if err := a.core.RegisterCampaignView(campUUID, subUUID); err != nil {
+		// The content revision of the message, if the campaign's content was changed while it was running.
+		rev, _ := strconv.Atoi(c.QueryParam("r"))
+		var (
+			client = mailclient.Parse(c.Request().UserAgent())
+
+			// The ISP whose tracking domain the pixel was requested on, if any.
+			ispName = isp.FromHost(a.cfg.Privacy.ISPTrackingDomain, c.Request().Host)
+		)
+		if err := a.core.RegisterCampaignView(campUUID, subUUID, client, ispName, rev); err != nil {
 			a.log.Printf("error registering campaign view: %s", err)
 		}
 	}
@@ -623,8 +917,8 @@ func (a *App) SelfExportSubscriberData(c echo.Context) error {
 	// Get the subscriber's data. A single query that gets the profile,
 	// list subscriptions, campaign views, and link clicks. Names of
 	// private lists are replaced with "Private list".
-	subUUID := c.Param("subUUID")
-	data, b, err := a.exportSubscriberData(0, subUUID, a.cfg.Privacy.Exportable)
+	sub := c.Get("sub").(models.Subscriber)
+	data, b, err := a.exportSubscriberData(sub.ID, "", a.cfg.Privacy.Exportable, a.publicAttribs)
 	if err != nil {
 		a.log.Printf("error exporting subscriber data: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
@@ -668,7 +962,8 @@ func (a *App) SelfExportSubscriberData(c echo.Context) error {
 
 // WipeSubscriberData allows a subscriber to delete their data. The
 // profile and subscriptions are deleted, while the campaign_views and link
-// clicks remain as orphan data unconnected to any subscriber.
+// clicks remain as orphan data unconnected to any subscriber (or under the
+// anonymized placeholder subscriber if the privacy mode is anonymize on delete).
 func (a *App) WipeSubscriberData(c echo.Context) error {
 	// Is wiping allowed?
 	if !a.cfg.Privacy.AllowWipe {
@@ -676,8 +971,8 @@ func (a *App) WipeSubscriberData(c echo.Context) error {
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.invalidFeature")))
 	}
 
-	subUUID := c.Param("subUUID")
-	if err := a.core.DeleteSubscribers(nil, []string{subUUID}); err != nil {
+	sub := c.Get("sub").(models.Subscriber)
+	if err := a.deleteSubscribers(nil, []string{sub.UUID}); err != nil {
 		a.log.Printf("error wiping subscriber data: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
@@ -720,39 +1015,52 @@ func drawTransparentImage(h, w int) []byte {
 
 // processSubForm processes an incoming form/public API subscription request.
 // The bool indicates whether there was subscription to an optin list so that
-// an appropriate message can be shown.
-func (a *App) processSubForm(c echo.Context) (bool, error) {
+// an appropriate message can be shown, and the string is the subscriber's e-mail.
+func (a *App) processSubForm(c echo.Context) (bool, string, error) {
+	if err := a.checkSubRateLimit(c, "sub:ip:"+c.RealIP(), subRateLimitIP, subRateLimitIPWindow); err != nil {
+		return false, "", err
+	}
+
 	// Get and validate fields.
 	var req struct {
 		Name          string   `form:"name" json:"name"`
 		Email         string   `form:"email" json:"email"`
 		FormListUUIDs []string `form:"l" json:"list_uuids"`
+
+		// IANA timezone of the subscriber's browser set by the form's JS.
+		Timezone string `form:"timezone" json:"timezone"`
 	}
 	if err := c.Bind(&req); err != nil {
-		return false, err
+		return false, "", err
 	}
 
 	if len(req.FormListUUIDs) == 0 {
-		return false, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.noListsSelected"))
+		return false, "", echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.noListsSelected"))
 	}
 
 	// Validate fields.
 	if len(req.Email) > 1000 {
-		return false, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidEmail"))
+		return false, "", echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidEmail"))
 	}
 
 	em, err := a.importer.SanitizeEmail(req.Email)
 	if err != nil {
-		return false, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return false, "", echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	req.Email = em
 
+	// The e-mail is hashed to not store it in the rate limit buckets.
+	h := sha256.Sum256([]byte(strings.ToLower(em)))
+	if err := a.checkSubRateLimit(c, "sub:email:"+hex.EncodeToString(h[:]), subRateLimitEmail, subRateLimitEmailWindow); err != nil {
+		return false, "", err
+	}
+
 	req.Name = strings.TrimSpace(req.Name)
 	if len(req.Name) == 0 {
 		// If there's no name, use the name bit from the e-mail.
 		req.Name = strings.Split(req.Email, "@")[0]
 	} else if len(req.Name) > stdInputMaxLen {
-		return false, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidName"))
+		return false, "", echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidName"))
 	}
 
 	listUUIDs := pq.StringArray(req.FormListUUIDs)
@@ -760,23 +1068,34 @@ func (a *App) processSubForm(c echo.Context) (bool, error) {
 	// Fetch the list types and ensure that they are not private.
 	listTypes, err := a.core.GetListTypes(nil, req.FormListUUIDs)
 	if err != nil {
-		return false, echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s", err.(*echo.HTTPError).Message))
+		return false, "", echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s", err.(*echo.HTTPError).Message))
 	}
 
 	for _, t := range listTypes {
 		if t == models.ListTypePrivate {
-			return false, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidUUID"))
+			return false, "", echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidUUID"))
 		}
 	}
 
+	// The timezone is stored for sending campaigns at the subscribers' local time.
+	// Invalid timezones are ignored instead of failing the subscription.
+	tz := validTimezone(req.Timezone)
+	var attribs models.JSON
+	if tz != "" {
+		attribs = models.JSON{"timezone": tz}
+	}
+
 	// Insert the subscriber into the DB.
-	_, hasOptin, err := a.core.InsertSubscriber(models.Subscriber{
-		Name:   req.Name,
-		Email:  req.Email,
-		Status: models.SubscriberStatusEnabled,
-	}, nil, listUUIDs, false, true)
+	sub, hasOptin, err := a.core.InsertSubscriber(models.Subscriber{
+		Name:    req.Name,
+		Email:   req.Email,
+		Attribs: attribs,
+		Status:  models.SubscriberStatusEnabled,
+	}, nil, listUUIDs, false, true, models.SubscribeSourceForm)
 	if err == nil {
-		return hasOptin, nil
+		a.recordConsent(c, models.ConsentEvent{SubscriberIDs: []int{sub.ID}, Type: models.ConsentTypeSubscribe,
+			ListUUIDs: listUUIDs, Text: a.i18n.T("subscribers.consentForm")})
+		return hasOptin, req.Email, nil
 	}
 
 	// Insert returned an error. Examine it.
@@ -787,20 +1106,65 @@ func (a *App) processSubForm(c echo.Context) (bool, error) {
 		// Get the subscriber from the DB by their email.
 		sub, err := a.core.GetSubscriber(0, "", req.Email)
 		if err != nil {
-			return false, err
+			return false, "", err
+		}
+
+		// Record the timezone if the subscriber doesn't have one already.
+		if _, ok := sub.Attribs["timezone"]; !ok && tz != "" {
+			if sub.Attribs == nil {
+				sub.Attribs = models.JSON{}
+			}
+			sub.Attribs["timezone"] = tz
 		}
 
 		// Update the subscriber's subscriptions in the DB.
-		_, hasOptin, err := a.core.UpdateSubscriberWithLists(sub.ID, sub, nil, listUUIDs, false, false, true, nil, true)
+		_, hasOptin, err := a.core.UpdateSubscriberWithLists(sub.ID, sub, nil, listUUIDs, false, false, true, nil, true, models.SubscribeSourceForm)
 		if err == nil {
-			return hasOptin, nil
+			a.recordConsent(c, models.ConsentEvent{SubscriberIDs: []int{sub.ID}, Type: models.ConsentTypeSubscribe,
+				ListUUIDs: listUUIDs, Text: a.i18n.T("subscribers.consentForm")})
+			return hasOptin, req.Email, nil
 		}
 		lastErr = err
 	}
 
 	// Something else went wrong.
 	if e, ok := lastErr.(*echo.HTTPError); ok {
-		return false, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s", e.Message))
+		return false, "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s", e.Message))
+	}
+	return false, "", echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorProcessingRequest"))
+}
+
+// validTimezone returns an IANA timezone name (eg: Asia/Kolkata) if it's valid,
+// or an empty string.
+func validTimezone(tz string) string {
+	tz = strings.TrimSpace(tz)
+	if tz == "" || tz == "Local" || len(tz) > 100 {
+		return ""
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return ""
+	}
+
+	return tz
+}
+
+// publicAttribs filters subscriber attributes that are exposed on the public pages
+// and APIs. They're limited to the attributes that are also available to templates.
+func (a *App) publicAttribs(attribs models.JSON) models.JSON {
+	return a.cfg.Privacy.PublicAttribs.Apply(a.manager.TemplateAttribs().Apply(attribs))
+}
+
+// checkSubRateLimit takes a token from a public subscription rate limit bucket and
+// returns a 429 error with a Retry-After header if there are none left. If the
+// bucket can't be checked, the request is allowed.
+func (a *App) checkSubRateLimit(c echo.Context, key string, max int, window time.Duration) error {
+	ok, retry, err := a.core.TakeRateLimitToken(key, max, window)
+	if err != nil || ok {
+		return nil
 	}
-	return false, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorProcessingRequest"))
+
+	a.log.Printf("WARNING: public subscription rate limit (%s) exceeded by IP %s", strings.SplitN(key, ":", 3)[1], c.RealIP())
+
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())))
+	return echo.NewHTTPError(http.StatusTooManyRequests, a.i18n.T("public.tooManySubscriptions"))
 }
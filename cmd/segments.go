@@ -0,0 +1,228 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// segQueryReq represents the param values and filters for executing a segment.
+type segQueryReq struct {
+	Params             models.JSON `json:"params"`
+	SubscriptionStatus string      `json:"subscription_status"`
+}
+
+// GetSegments handles retrieval of segments.
+func (a *App) GetSegments(c echo.Context) error {
+	out, err := a.core.GetSegments()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSegment handles retrieval of a segment.
+func (a *App) GetSegment(c echo.Context) error {
+	out, err := a.core.GetSegment(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateSegment handles segment creation. As segments are arbitrary SQL
+// expressions, creating them requires the subscribers:sql_query permission.
+func (a *App) CreateSegment(c echo.Context) error {
+	user := auth.GetUser(c)
+	if !user.HasPerm(auth.PermSubscribersSqlQuery) {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", auth.PermSubscribersSqlQuery))
+	}
+
+	var s models.Segment
+	if err := c.Bind(&s); err != nil {
+		return err
+	}
+	if err := a.validateSegment(&s); err != nil {
+		return err
+	}
+
+	out, err := a.core.CreateSegment(s, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateSegment handles segment modification. Changing the query or
+// the params of a segment revokes its approval.
+func (a *App) UpdateSegment(c echo.Context) error {
+	user := auth.GetUser(c)
+	if !user.HasPerm(auth.PermSubscribersSqlQuery) {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", auth.PermSubscribersSqlQuery))
+	}
+
+	var s models.Segment
+	if err := c.Bind(&s); err != nil {
+		return err
+	}
+	if err := a.validateSegment(&s); err != nil {
+		return err
+	}
+
+	out, err := a.core.UpdateSegment(getID(c), s)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// ApproveSegment handles the approval of a segment, after which it can be
+// used by all users, or the revocation of the approval.
+func (a *App) ApproveSegment(c echo.Context) error {
+	req := struct {
+		Approved bool `json:"approved"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	out, err := a.core.ApproveSegment(getID(c), auth.GetUser(c).ID, req.Approved)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteSegment handles segment deletion.
+func (a *App) DeleteSegment(c echo.Context) error {
+	if err := a.core.DeleteSegment(getID(c)); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// QuerySegment handles the execution of a segment with the given param values,
+// which are bound to the query server-side, and returns the matching subscribers.
+func (a *App) QuerySegment(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	// Filter list IDs by permission.
+	listIDs, err := a.filterListQueryByPerm("list_id", c.QueryParams(), user)
+	if err != nil {
+		return err
+	}
+
+	var req segQueryReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	seg, err := a.core.GetSegment(getID(c))
+	if err != nil {
+		return err
+	}
+	if !seg.CanUse(user.ID) {
+		return echo.NewHTTPError(http.StatusForbidden, a.i18n.T("segments.notApproved"))
+	}
+
+	pg := a.pg.NewFromURL(c.Request().URL.Query())
+	res, total, err := a.core.QuerySegmentSubscribers(seg, req.Params, listIDs, req.SubscriptionStatus, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	for i := range res {
+		maskRestrictedSubLists(user, &res[i])
+	}
+
+	out := models.PageResults{
+		Query:   seg.Query,
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// validateSegment validates segment fields and the segment's query.
+func (a *App) validateSegment(s *models.Segment) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if !strHasLen(s.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+	if len(s.Description) > 2000 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "description"))
+	}
+
+	s.Query = formatSQLExp(s.Query)
+	if s.Query == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "query"))
+	}
+	if s.Params == nil {
+		s.Params = models.SegmentParams{}
+	}
+
+	return a.core.ValidateSegment(*s)
+}
+
+// validateCampaignSegment checks that the given user can use the campaign's
+// segment, if there's one, and type-checks the param values that are bound to
+// it, which are stored on the campaign.
+func (a *App) validateCampaignSegment(c *models.Campaign, user auth.User) error {
+	if !c.SegmentID.Valid || c.SegmentID.Int < 1 {
+		c.SegmentID.Valid = false
+		c.SegmentParams = models.SegmentValues{}
+		return nil
+	}
+
+	seg, err := a.core.GetSegment(c.SegmentID.Int)
+	if err != nil {
+		return err
+	}
+	if !seg.CanUse(user.ID) {
+		return echo.NewHTTPError(http.StatusForbidden, a.i18n.T("segments.notApproved"))
+	}
+
+	vals, err := seg.BindValues(c.SegmentParams)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("segments.invalidParams", "error", err.Error()))
+	}
+	c.SegmentParams = vals
+
+	return nil
+}
+
+// checkCampaignSegmentUse checks that the given user can still use the segment
+// of a campaign that's being started or scheduled.
+func (a *App) checkCampaignSegmentUse(campID int, user auth.User) error {
+	camp, err := a.core.GetCampaign(campID, "", "")
+	if err != nil {
+		return err
+	}
+	if !camp.SegmentID.Valid {
+		return nil
+	}
+
+	seg, err := a.core.GetSegment(camp.SegmentID.Int)
+	if err != nil {
+		return err
+	}
+	if !seg.CanUse(user.ID) {
+		return echo.NewHTTPError(http.StatusForbidden, a.i18n.T("segments.notApproved"))
+	}
+
+	return nil
+}
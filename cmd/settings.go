@@ -3,11 +3,16 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"reflect"
 	"regexp"
 	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -20,13 +25,32 @@ import (
 	"github.com/knadh/koanf/providers/rawbytes"
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/bounce"
+	"github.com/knadh/listmonk/internal/bounce/mailbox"
+	"github.com/knadh/listmonk/internal/isp"
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/messenger/capture"
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/tpllint"
+	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
-const pwdMask = "•"
+const (
+	pwdMask = "•"
+
+	// Timeout for checking whether the URLs in settings are reachable.
+	settingsURLTimeout = 5 * time.Second
+
+	// Max. number of messages that the capture messenger retains.
+	maxCaptureSize = 100000
+
+	// Max. length of the custom delimiters of template expressions.
+	maxTemplateDelimLen = 5
+)
 
 type aboutHost struct {
 	OS       string `json:"os"`
@@ -62,26 +86,149 @@ func (a *App) GetSettings(c echo.Context) error {
 	}
 
 	// Empty out passwords.
+	redactSettings(&s, func(v string) string {
+		return strings.Repeat(pwdMask, utf8.RuneCountInString(v))
+	})
+
+	return c.JSON(http.StatusOK, okResp{s})
+}
+
+// ExportSettings returns the settings as a JSON file for backing up, or for
+// migrating to another instance. Passwords and secrets are left out.
+func (a *App) ExportSettings(c echo.Context) error {
+	s, err := a.core.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	redactSettings(&s, func(string) string {
+		return ""
+	})
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+	}
+
+	// Set headers to force the browser to prompt for download.
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="listmonk-settings.json"`)
+	return c.Blob(http.StatusOK, "application/json", b)
+}
+
+// ImportSettings updates the settings from an exported settings file. Keys that
+// aren't in the file are left as they are, and passwords and secrets that aren't
+// in it are retained from the current settings.
+func (a *App) ImportSettings(c echo.Context) error {
+	var in map[string]json.RawMessage
+	if err := c.Bind(&in); err != nil {
+		return err
+	}
+	if len(in) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
+	}
+
+	cur, err := a.core.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	// Overlay the imported keys on the current settings. Keys unknown to
+	// this version are skipped.
+	b, err := json.Marshal(cur)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+	}
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+	}
+
+	known := settingsKeys()
+	for k, v := range in {
+		if _, ok := known[k]; ok {
+			merged[k] = v
+		}
+	}
+
+	var set models.Settings
+	b, _ = json.Marshal(merged)
+	if err := json.Unmarshal(b, &set); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("settings.fieldInvalidValue", "error", err.Error()))
+	}
+
+	// Servers exported from another instance have UUIDs that don't match the
+	// current ones. Carry over their passwords from servers with the same host
+	// and username instead.
+	for i, s := range set.SMTP {
+		if s.Password != "" {
+			continue
+		}
+		for _, c := range cur.SMTP {
+			if c.UUID != s.UUID && c.Host == s.Host && c.Username == s.Username {
+				set.SMTP[i].Password = c.Password
+			}
+		}
+	}
+	for i, s := range set.BounceBoxes {
+		if s.Password != "" {
+			continue
+		}
+		for _, c := range cur.BounceBoxes {
+			if c.UUID != s.UUID && c.Host == s.Host && c.Username == s.Username {
+				set.BounceBoxes[i].Password = c.Password
+			}
+		}
+	}
+	for i, m := range set.Messengers {
+		if m.Password != "" {
+			continue
+		}
+		for _, c := range cur.Messengers {
+			if c.UUID != m.UUID && c.RootURL == m.RootURL && c.Username == m.Username {
+				set.Messengers[i].Password = c.Password
+			}
+		}
+	}
+
+	set, err = a.validateSettings(set, cur)
+	if err != nil {
+		return err
+	}
+
+	// Update all the settings in a single update.
+	if err := a.core.UpdateSettings(set); err != nil {
+		return err
+	}
+
+	return a.handleSettingsRestart(c)
+}
+
+// redactSettings replaces the passwords and secrets in the settings with the
+// values returned by fn.
+func redactSettings(s *models.Settings, fn func(string) string) {
 	for i := range s.SMTP {
-		s.SMTP[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SMTP[i].Password))
+		s.SMTP[i].Password = fn(s.SMTP[i].Password)
 	}
 	for i := range s.BounceBoxes {
-		s.BounceBoxes[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BounceBoxes[i].Password))
+		s.BounceBoxes[i].Password = fn(s.BounceBoxes[i].Password)
 	}
 	for i := range s.Messengers {
-		s.Messengers[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Messengers[i].Password))
+		s.Messengers[i].Password = fn(s.Messengers[i].Password)
 	}
 
-	s.UploadS3AwsSecretAccessKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadS3AwsSecretAccessKey))
-	s.SendgridKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SendgridKey))
-	s.BounceAzure.SharedSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BounceAzure.SharedSecret))
-	s.BouncePostmark.Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BouncePostmark.Password))
-	s.BounceForwardEmail.Key = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BounceForwardEmail.Key))
-	s.BounceLettermint.Key = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BounceLettermint.Key))
-	s.SecurityCaptcha.HCaptcha.Secret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SecurityCaptcha.HCaptcha.Secret))
-	s.OIDC.ClientSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.OIDC.ClientSecret))
-
-	return c.JSON(http.StatusOK, okResp{s})
+	s.UploadS3AwsSecretAccessKey = fn(s.UploadS3AwsSecretAccessKey)
+	s.SendgridKey = fn(s.SendgridKey)
+	s.BounceAzure.SharedSecret = fn(s.BounceAzure.SharedSecret)
+	s.BouncePostmark.Password = fn(s.BouncePostmark.Password)
+	s.BounceForwardEmail.Key = fn(s.BounceForwardEmail.Key)
+	s.BounceLettermint.Key = fn(s.BounceLettermint.Key)
+	s.SecurityCaptcha.HCaptcha.Secret = fn(s.SecurityCaptcha.HCaptcha.Secret)
+	s.OIDC.ClientSecret = fn(s.OIDC.ClientSecret)
+	s.SecurityCampaignGate.Secret = fn(s.SecurityCampaignGate.Secret)
+	s.SecuritySubscriberFeed.Token = fn(s.SecuritySubscriberFeed.Token)
+	s.SecurityGoogleSheets.Credentials = fn(s.SecurityGoogleSheets.Credentials)
+	s.NotificationsListWebhook.Secret = fn(s.NotificationsListWebhook.Secret)
 }
 
 // UpdateSettings returns settings from the DB.
@@ -98,18 +245,162 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		return err
 	}
 
+	set, err = a.validateSettings(set, cur)
+	if err != nil {
+		return err
+	}
+
+	// Update the settings in the DB.
+	if err := a.core.UpdateSettings(set); err != nil {
+		return err
+	}
+
+	return a.handleSettingsRestart(c)
+}
+
+// settingsFieldError is a validation error of a settings field. Field is the
+// settings key, or the path to a value in it, eg: smtp.0.idle_timeout.
+type settingsFieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// PatchSettings updates the settings keys in a partial settings object, leaving
+// the other settings as they are. The values are validated before any of them
+// are applied, and validation errors are returned for each field.
+func (a *App) PatchSettings(c echo.Context) error {
+	var patch map[string]json.RawMessage
+	if err := c.Bind(&patch); err != nil {
+		return err
+	}
+	if len(patch) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
+	}
+
+	// Get the existing settings and overlay the incoming keys on them.
+	cur, err := a.core.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(cur)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+	}
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+	}
+
+	var (
+		known = settingsKeys()
+		errs  []settingsFieldError
+		keys  = make([]string, 0, len(patch))
+	)
+	for k, v := range patch {
+		if _, ok := known[k]; !ok {
+			errs = append(errs, settingsFieldError{Field: k, Error: a.i18n.T("settings.fieldUnknown")})
+			continue
+		}
+
+		// Check that the value is of the right type for the key.
+		var s models.Settings
+		if err := json.Unmarshal(fmt.Appendf(nil, `{%q: %s}`, k, v), &s); err != nil {
+			errs = append(errs, settingsFieldError{Field: k, Error: a.i18n.Ts("settings.fieldInvalidValue", "error", err.Error())})
+			continue
+		}
+
+		merged[k] = v
+		keys = append(keys, k)
+	}
+	if len(errs) > 0 {
+		return a.settingsFieldErrors(c, errs)
+	}
+
+	var set models.Settings
+	b, _ = json.Marshal(merged)
+	if err := json.Unmarshal(b, &set); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("settings.fieldInvalidValue", "error", err.Error()))
+	}
+
+	if errs := a.validateSettingsFields(set, keys); len(errs) > 0 {
+		return a.settingsFieldErrors(c, errs)
+	}
+
+	set, err = a.validateSettings(set, cur)
+	if err != nil {
+		return err
+	}
+
+	// Update only the incoming keys, with their sanitized values.
+	if b, err = json.Marshal(set); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+	}
+
+	out := make(map[string]json.RawMessage, len(keys))
+	for _, k := range keys {
+		out[k] = all[k]
+	}
+	if err := a.core.PatchSettings(out); err != nil {
+		return err
+	}
+
+	return a.handleSettingsRestart(c)
+}
+
+// settingsKeys returns the keys of the settings from the JSON tags of the
+// fields in models.Settings.
+func settingsKeys() map[string]struct{} {
+	t := reflect.TypeOf(models.Settings{})
+
+	out := make(map[string]struct{}, t.NumField())
+	for i := range t.NumField() {
+		if k, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); k != "" && k != "-" {
+			out[k] = struct{}{}
+		}
+	}
+
+	return out
+}
+
+// settingsFieldErrors responds with the validation errors of settings fields.
+func (a *App) settingsFieldErrors(c echo.Context, errs []settingsFieldError) error {
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+
+	return c.JSON(http.StatusBadRequest, struct {
+		Message string               `json:"message"`
+		Errors  []settingsFieldError `json:"errors"`
+	}{a.i18n.T("settings.invalidFields"), errs})
+}
+
+// validateSettings validates and sanitizes incoming settings. Passwords and
+// secrets that aren't sent by the frontend are copied from the current settings.
+func (a *App) validateSettings(set, cur models.Settings) (models.Settings, error) {
 	// Validate and sanitize postback Messenger names along with SMTP names
 	// (where each SMTP is also considered as a standalone messenger).
-	// Duplicates are disallowed and "email" is a reserved name.
-	names := map[string]bool{emailMsgr: true}
+	// Duplicates are disallowed and "email" and "capture" are reserved names.
+	names := map[string]bool{emailMsgr: true, capture.MessengerName: true}
 
-	// There should be at least one SMTP block that's enabled.
+	// There should be at least one SMTP block that's enabled and not in shadow mode.
 	has := false
 	for i, s := range set.SMTP {
-		if s.Enabled {
+		if s.Enabled && !s.ShadowMode {
 			has = true
 		}
 
+		// Shadow copies of messages are sent to the shadow address.
+		set.SMTP[i].ShadowBccAddress = strings.TrimSpace(s.ShadowBccAddress)
+		if s.Enabled && s.ShadowMode && !utils.ValidateEmail(set.SMTP[i].ShadowBccAddress) {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.smtp.shadowBccAddress")))
+		}
+
 		// Sanitize and normalize the SMTP server name.
 		name := reAlphaNum.ReplaceAllString(strings.ToLower(strings.TrimSpace(s.Name)), "-")
 		if name != "" {
@@ -118,7 +409,7 @@ func (a *App) UpdateSettings(c echo.Context) error {
 			}
 
 			if _, ok := names[name]; ok {
-				return echo.NewHTTPError(http.StatusBadRequest,
+				return set, echo.NewHTTPError(http.StatusBadRequest,
 					a.i18n.Ts("settings.duplicateMessengerName", "name", name))
 			}
 
@@ -138,6 +429,11 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		// This is a common mistake when copy-pasting SMTP settings.
 		set.SMTP[i].Host = strings.TrimSpace(s.Host)
 
+		if err := validateWarmupSchedule(s.WarmupSchedule); err != nil {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.smtp.warmupSchedule"))+": "+err.Error())
+		}
+
 		// If there's no password coming in from the frontend, copy the existing
 		// password by matching the UUID.
 		if s.Password == "" {
@@ -149,7 +445,7 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		}
 	}
 	if !has {
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.errorNoSMTP"))
+		return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.errorNoSMTP"))
 	}
 
 	// Normalize `from_addresses``. Values are either an e-mail address
@@ -187,7 +483,10 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		set.BounceBoxes[i].Host = strings.TrimSpace(s.Host)
 
 		if d, _ := time.ParseDuration(s.ScanInterval); d.Minutes() < 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.bounces.invalidScanInterval"))
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.bounces.invalidScanInterval"))
+		}
+		if s.MaxMessageSize < 0 {
+			set.BounceBoxes[i].MaxMessageSize = 0
 		}
 
 		// If there's no password coming in from the frontend, copy the existing
@@ -201,6 +500,36 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		}
 	}
 
+	// Custom bounce reason rules should have valid reasons and patterns.
+	reasons := make([]bounce.ReasonRule, 0, len(set.BounceReasons))
+	for i, r := range set.BounceReasons {
+		set.BounceReasons[i].Pattern = strings.TrimSpace(r.Pattern)
+		if set.BounceReasons[i].Pattern == "" {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.bounces.reasons")))
+		}
+		reasons = append(reasons, bounce.ReasonRule{Reason: r.Reason, Pattern: set.BounceReasons[i].Pattern})
+	}
+	if _, err := bounce.NewClassifier(reasons); err != nil {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.bounces.reasons"))+": "+err.Error())
+	}
+
+	// Custom bounce classification rules should have valid types and patterns.
+	typeRules := make([]mailbox.TypeRule, 0, len(set.BounceClassificationRules))
+	for i, r := range set.BounceClassificationRules {
+		set.BounceClassificationRules[i].Pattern = strings.TrimSpace(r.Pattern)
+		if set.BounceClassificationRules[i].Pattern == "" {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.bounces.classificationRules")))
+		}
+		typeRules = append(typeRules, mailbox.TypeRule{Pattern: set.BounceClassificationRules[i].Pattern, Type: r.Type})
+	}
+	if _, err := mailbox.NewTypeRules(typeRules); err != nil {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.bounces.classificationRules"))+": "+err.Error())
+	}
+
 	for i, m := range set.Messengers {
 		// UUID to keep track of password changes similar to the SMTP logic above.
 		if m.UUID == "" {
@@ -217,15 +546,25 @@ func (a *App) UpdateSettings(c echo.Context) error {
 
 		name := reAlphaNum.ReplaceAllString(strings.ToLower(m.Name), "")
 		if _, ok := names[name]; ok {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return set, echo.NewHTTPError(http.StatusBadRequest,
 				a.i18n.Ts("settings.duplicateMessengerName", "name", name))
 		}
 		if len(name) == 0 {
-			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.invalidMessengerName"))
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.invalidMessengerName"))
 		}
 
 		set.Messengers[i].Name = name
 		names[name] = true
+
+		if err := validateWarmupSchedule(m.WarmupSchedule); err != nil {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.smtp.warmupSchedule"))+": "+err.Error())
+		}
+	}
+
+	// Capture messenger.
+	if set.CaptureMessenger.Size < 1 || set.CaptureMessenger.Size > maxCaptureSize {
+		set.CaptureMessenger.Size = 1000
 	}
 
 	// S3 password?
@@ -253,11 +592,23 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	if set.OIDC.ClientSecret == "" {
 		set.OIDC.ClientSecret = cur.OIDC.ClientSecret
 	}
+	if set.SecurityCampaignGate.Secret == "" {
+		set.SecurityCampaignGate.Secret = cur.SecurityCampaignGate.Secret
+	}
+	if set.SecuritySubscriberFeed.Token == "" {
+		set.SecuritySubscriberFeed.Token = cur.SecuritySubscriberFeed.Token
+	}
+	if set.SecurityGoogleSheets.Credentials == "" {
+		set.SecurityGoogleSheets.Credentials = cur.SecurityGoogleSheets.Credentials
+	}
+	if set.NotificationsListWebhook.Secret == "" {
+		set.NotificationsListWebhook.Secret = cur.NotificationsListWebhook.Secret
+	}
 
 	// OIDC user auto-creation is enabled. Validate.
 	if set.OIDC.AutoCreateUsers {
 		if set.OIDC.DefaultUserRoleID.Int < auth.SuperAdminRoleID {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return set, echo.NewHTTPError(http.StatusBadRequest,
 				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.security.OIDCDefaultRole")))
 		}
 	}
@@ -265,6 +616,10 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	for n, v := range set.UploadExtensions {
 		set.UploadExtensions[n] = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(v), "."))
 	}
+	if set.UploadMaxArchiveSizeMB < 1 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.media.upload.maxArchiveSize")))
+	}
 
 	// Domain blocklist / allowlist.
 	doms := make([]string, 0, len(set.DomainBlocklist))
@@ -283,6 +638,22 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	}
 	set.DomainAllowlist = doms
 
+	// E-mail allowlist / denylist patterns.
+	for _, list := range []*[]string{&set.EmailAllowlistPatterns, &set.EmailDenylistPatterns} {
+		pats := make([]string, 0, len(*list))
+		for _, p := range *list {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			if _, err := compileEmailPattern(p); err != nil {
+				return set, echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("settings.privacy.invalidEmailPattern", "pattern", p, "error", err.Error()))
+			}
+			pats = append(pats, p)
+		}
+		*list = pats
+	}
+
 	// Validate and clean trusted URLs.
 	urls := make([]string, 0, len(set.SecurityTrustedURLs))
 	for _, d := range set.SecurityTrustedURLs {
@@ -295,7 +666,7 @@ func (a *App) UpdateSettings(c echo.Context) error {
 			// Parse and validate the URL.
 			u, err := url.Parse(d)
 			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
-				return echo.NewHTTPError(http.StatusBadRequest,
+				return set, echo.NewHTTPError(http.StatusBadRequest,
 					a.i18n.Ts("globals.messages.invalidData")+": invalid trusted URL: "+d)
 			}
 			urls = append(urls, d)
@@ -306,16 +677,366 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	// Validate slow query caching cron.
 	if set.CacheSlowQueries {
 		if _, err := cron.ParseStandard(set.CacheSlowQueriesInterval); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": slow query cron: "+err.Error())
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": slow query cron: "+err.Error())
 		}
 	}
 
-	// Update the settings in the DB.
-	if err := a.core.UpdateSettings(set); err != nil {
+	// Admin notification e-mails.
+	emails, bad, ok := parseEmailList(set.AppNotifyEmails)
+	if !ok {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.general.adminNotifEmails")+": "+bad))
+	}
+	set.AppNotifyEmails = emails
+
+	// Default UTM template of campaigns.
+	if set.AppUTMTemplate == nil {
+		set.AppUTMTemplate = models.UTMTemplate{}
+	}
+	if _, err := set.AppUTMTemplate.Compile(a.manager.TemplateFuncs(nil)); err != nil {
+		return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("campaigns.invalidUTMTemplate", "error", err.Error()))
+	}
+
+	// List-Unsubscribe mailto: address.
+	set.PrivacyUnsubMailto.Address = strings.TrimSpace(set.PrivacyUnsubMailto.Address)
+	if set.PrivacyUnsubMailto.Enabled && !utils.ValidateEmail(set.PrivacyUnsubMailto.Address) {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.privacy.listUnsubMailtoAddress")))
+	}
+
+	if set.PrivacyMode != models.PrivacyModeAnonymizeOnDelete {
+		set.PrivacyMode = models.PrivacyModeDelete
+	}
+
+	set.PrivacyLegacyUUIDLinksUntil = strings.TrimSpace(set.PrivacyLegacyUUIDLinksUntil)
+	if set.PrivacyLegacyUUIDLinksUntil != "" {
+		if _, err := time.Parse(time.DateOnly, set.PrivacyLegacyUUIDLinksUntil); err != nil {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.privacy.legacyUUIDLinksUntil")))
+		}
+	}
+
+	if set.PrivacyResubscribeProtectionDays < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.privacy.resubscribeProtectionDays")))
+	}
+
+	// The ISP tracking domain template should have one {isp} placeholder and be a valid host[:port].
+	set.PrivacyISPTrackingDomain = strings.ToLower(strings.TrimSpace(set.PrivacyISPTrackingDomain))
+	if d := set.PrivacyISPTrackingDomain; d != "" {
+		h := isp.Host(d, "gmail")
+		if u, err := url.Parse("//" + h); strings.Count(d, isp.Placeholder) != 1 || err != nil || u.Host != h || u.Hostname() == "" {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.privacy.ispTrackingDomain")))
+		}
+	}
+
+	// Journal address and mode.
+	set.PrivacyJournal.Address = strings.TrimSpace(set.PrivacyJournal.Address)
+	if set.PrivacyJournal.Enabled && !utils.ValidateEmail(set.PrivacyJournal.Address) {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.privacy.journalAddress")))
+	}
+	if set.PrivacyJournal.Mode != manager.JournalModeBcc && set.PrivacyJournal.Mode != manager.JournalModeCopy {
+		set.PrivacyJournal.Mode = manager.JournalModeBcc
+	}
+
+	// Subscriber attributes available to templates and the public pages.
+	set.PrivacyTemplateAttribs = cleanAttribFilter(set.PrivacyTemplateAttribs, models.AttribFilterAll)
+	set.PrivacyPublicAttribs = cleanAttribFilter(set.PrivacyPublicAttribs, models.AttribFilterAllow)
+
+	// Validate campaign gates.
+	gateURLs := make([]string, 0, len(set.SecurityCampaignGate.URLs))
+	for _, d := range set.SecurityCampaignGate.URLs {
+		if d = strings.TrimSpace(d); d == "" {
+			continue
+		}
+
+		u, err := url.Parse(d)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.security.campaignGateURLs")))
+		}
+		gateURLs = append(gateURLs, d)
+	}
+	set.SecurityCampaignGate.URLs = gateURLs
+	if set.SecurityCampaignGate.Enabled {
+		if len(set.SecurityCampaignGate.URLs) == 0 || set.SecurityCampaignGate.Secret == "" {
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.security.campaignGateInvalid"))
+		}
+		if d, err := time.ParseDuration(set.SecurityCampaignGate.Timeout); err != nil || d < time.Second {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.security.campaignGateTimeout")))
+		}
+		if d, err := time.ParseDuration(set.SecurityCampaignGate.RetryInterval); err != nil || d < time.Minute {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.security.campaignGateRetry")))
+		}
+	}
+	if set.SecuritySubscriberFeed.Enabled && len(set.SecuritySubscriberFeed.Token) < minFeedTokenLen {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("settings.security.subscriberFeedTokenInvalid", "num", strconv.Itoa(minFeedTokenLen)))
+	}
+	if set.SecurityGoogleSheets.Enabled {
+		if _, err := subimporter.NewGoogleSheets([]byte(set.SecurityGoogleSheets.Credentials)); err != nil {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.security.googleSheetsCredentials")))
+		}
+	}
+
+	// Validate admin notifications.
+	if set.NotificationsWebhook.Enabled {
+		u, err := url.Parse(set.NotificationsWebhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.notifications.webhookURL")))
+		}
+	}
+	if set.NotificationsListWebhook.Enabled {
+		u, err := url.Parse(set.NotificationsListWebhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.notifications.listWebhookURL")))
+		}
+	}
+	if set.NotificationsEvents.BounceSpike.Enabled {
+		if d, err := time.ParseDuration(set.NotificationsEvents.BounceSpike.Window); err != nil || d < time.Minute {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.notifications.bounceSpikeWindow")))
+		}
+		if t := set.NotificationsEvents.BounceSpike.Threshold; t <= 0 || t > 100 {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.notifications.bounceSpikeThreshold")))
+		}
+	}
+	if set.NotificationsEvents.IPBounceSpike.Enabled {
+		if d, err := time.ParseDuration(set.NotificationsEvents.IPBounceSpike.Window); err != nil || d < time.Minute {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.notifications.ipBounceSpikeWindow")))
+		}
+		if set.NotificationsEvents.IPBounceSpike.Threshold < 1 {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.notifications.ipBounceSpikeThreshold")))
+		}
+	}
+	if set.AppTemplateMaxBodyBytes < 0 {
+		set.AppTemplateMaxBodyBytes = 0
+	}
+
+	// Custom template delimiters should both be set (or neither for the defaults) and
+	// shouldn't be the same or contain whitespace.
+	set.AppTemplateLeftDelim = strings.TrimSpace(set.AppTemplateLeftDelim)
+	set.AppTemplateRightDelim = strings.TrimSpace(set.AppTemplateRightDelim)
+	if l, r := set.AppTemplateLeftDelim, set.AppTemplateRightDelim; (l == "") != (r == "") || (l != "" && l == r) ||
+		len(l) > maxTemplateDelimLen || len(r) > maxTemplateDelimLen || strings.ContainsAny(l+r, " \t\r\n") {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.performance.templateDelims")))
+	}
+	if set.AppMaxConcurrentCampaigns < 0 {
+		set.AppMaxConcurrentCampaigns = 0
+	}
+	if set.AppImportArchiveRetention < 0 {
+		set.AppImportArchiveRetention = 0
+	}
+	if set.AppCampaignQueueDelay == "" {
+		set.AppCampaignQueueDelay = "1m"
+	}
+	if set.AppTemplateLintRules == nil {
+		set.AppTemplateLintRules = map[string]string{}
+	}
+	for rule, sev := range set.AppTemplateLintRules {
+		if _, ok := tpllint.DefaultSeverities[rule]; !ok || !tpllint.IsValidSeverity(sev) {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.performance.templateLintRules")+": "+rule))
+		}
+	}
+	if set.NotificationsEvents.CampaignFailure.Threshold < 0 {
+		set.NotificationsEvents.CampaignFailure.Threshold = 0
+	}
+	emails, bad, ok = parseEmailList(set.NotificationsEmail.Emails)
+	if !ok {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.notifications.emails")+": "+bad))
+	}
+	set.NotificationsEmail.Emails = emails
+
+	return set, nil
+}
+
+// validateSettingsFields validates the values of the given settings keys: e-mail
+// addresses, URLs and whether they're reachable, durations, and cron expressions.
+// Settings of features that are disabled aren't validated.
+func (a *App) validateSettingsFields(set models.Settings, keys []string) []settingsFieldError {
+	var out []settingsFieldError
+	add := func(field, err string) {
+		out = append(out, settingsFieldError{Field: field, Error: err})
+	}
+
+	// Addresses that can have names, eg: Name <name@site.com>.
+	checkAddrs := func(field string, addrs ...string) {
+		if _, bad, ok := parseEmailList(addrs); !ok {
+			add(field, a.i18n.Ts("settings.fieldInvalidEmail", "email", bad))
+		}
+	}
+	checkEmail := func(field, addr string) {
+		if !utils.ValidateEmail(strings.TrimSpace(addr)) {
+			add(field, a.i18n.Ts("settings.fieldInvalidEmail", "email", addr))
+		}
+	}
+	checkDuration := func(field, v string, min time.Duration) {
+		if d, err := time.ParseDuration(v); err != nil || d < min {
+			add(field, a.i18n.Ts("settings.fieldInvalidDuration", "value", v))
+		}
+	}
+	checkCron := func(field, v string) {
+		if _, err := cron.ParseStandard(v); err != nil {
+			add(field, a.i18n.Ts("settings.fieldInvalidCron", "error", err.Error()))
+		}
+	}
+	checkURL := func(field, v string, reachable bool) {
+		u, err := url.Parse(strings.TrimSpace(v))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(field, a.i18n.Ts("settings.fieldInvalidURL", "url", v))
+			return
+		}
+		if reachable {
+			if err := isURLReachable(u.String()); err != nil {
+				add(field, a.i18n.Ts("settings.fieldUnreachableURL", "error", err.Error()))
+			}
+		}
+	}
+
+	for _, k := range keys {
+		switch k {
+		case "app.root_url":
+			checkURL(k, set.AppRootURL, false)
+		case "app.logo_url", "app.favicon_url":
+			// Paths relative to the root URL aren't checked.
+			v := set.AppLogoURL
+			if k == "app.favicon_url" {
+				v = set.AppFaviconURL
+			}
+			if v != "" && !strings.HasPrefix(v, "/") {
+				checkURL(k, v, true)
+			}
+		case "app.from_email":
+			checkAddrs(k, set.AppFromEmail)
+		case "app.notify_emails":
+			checkAddrs(k, set.AppNotifyEmails...)
+		case "app.cache_slow_queries_interval":
+			if set.CacheSlowQueries {
+				checkCron(k, set.CacheSlowQueriesInterval)
+			}
+		case "app.message_sliding_window_duration":
+			if set.AppMessageSlidingWindow {
+				checkDuration(k, set.AppMessageSlidingWindowDuration, time.Second)
+			}
+		case "app.campaign_queue_delay":
+			if set.AppMaxConcurrentCampaigns > 0 {
+				checkDuration(k, set.AppCampaignQueueDelay, time.Second)
+			}
+		case "upload.s3.expiry":
+			if set.UploadProvider == "s3" {
+				checkDuration(k, set.UploadS3Expiry, 0)
+			}
+		case "privacy.unsubscribe_mailto":
+			if set.PrivacyUnsubMailto.Enabled {
+				checkEmail(k+".address", set.PrivacyUnsubMailto.Address)
+			}
+		case "privacy.journal":
+			if set.PrivacyJournal.Enabled {
+				checkEmail(k+".address", set.PrivacyJournal.Address)
+			}
+		case "security.oidc":
+			if set.OIDC.Enabled {
+				checkURL(k+".provider_url", set.OIDC.ProviderURL, true)
+			}
+		case "security.campaign_gate":
+			if set.SecurityCampaignGate.Enabled {
+				for i, u := range set.SecurityCampaignGate.URLs {
+					checkURL(fmt.Sprintf("%s.urls.%d", k, i), u, true)
+				}
+				checkDuration(k+".timeout", set.SecurityCampaignGate.Timeout, time.Second)
+				checkDuration(k+".retry_interval", set.SecurityCampaignGate.RetryInterval, time.Second)
+			}
+		case "smtp":
+			for i, s := range set.SMTP {
+				if !s.Enabled {
+					continue
+				}
+				f := fmt.Sprintf("%s.%d.", k, i)
+				checkDuration(f+"msg_retry_delay", s.MsgRetryDelay, 0)
+				checkDuration(f+"idle_timeout", s.IdleTimeout, time.Second)
+				checkDuration(f+"wait_timeout", s.WaitTimeout, time.Second)
+				if s.ShadowMode {
+					checkEmail(f+"shadow_bcc_address", s.ShadowBccAddress)
+				}
+			}
+		case "messengers":
+			for i, m := range set.Messengers {
+				if !m.Enabled {
+					continue
+				}
+				f := fmt.Sprintf("%s.%d.", k, i)
+				checkURL(f+"root_url", m.RootURL, true)
+				checkDuration(f+"timeout", m.Timeout, time.Second)
+			}
+		case "bounce.mailboxes":
+			for i, b := range set.BounceBoxes {
+				if b.Enabled {
+					checkDuration(fmt.Sprintf("%s.%d.scan_interval", k, i), b.ScanInterval, time.Minute)
+				}
+			}
+		case "maintenance.db":
+			if set.MaintenanceDB.Vacuum {
+				checkCron(k+".vacuum_cron_interval", set.MaintenanceDB.VacuumInterval)
+			}
+		case "maintenance.backup":
+			if set.MaintenanceBackup.Enabled {
+				checkCron(k+".cron_interval", set.MaintenanceBackup.CronInterval)
+			}
+		case "notifications.email":
+			if set.NotificationsEmail.Enabled {
+				checkAddrs(k+".emails", set.NotificationsEmail.Emails...)
+			}
+		case "notifications.webhook":
+			if set.NotificationsWebhook.Enabled {
+				checkURL(k+".url", set.NotificationsWebhook.URL, true)
+			}
+		case "notifications.list_webhook":
+			if set.NotificationsListWebhook.Enabled {
+				checkURL(k+".url", set.NotificationsListWebhook.URL, true)
+			}
+		case "notifications.events":
+			if ev := set.NotificationsEvents.BounceSpike; ev.Enabled {
+				checkDuration(k+".bounce_spike.window", ev.Window, time.Minute)
+			}
+			if ev := set.NotificationsEvents.IPBounceSpike; ev.Enabled {
+				checkDuration(k+".ip_bounce_spike.window", ev.Window, time.Minute)
+			}
+		}
+	}
+
+	return out
+}
+
+// isURLReachable checks whether the server at a URL responds to requests. Any
+// response, even with an error status, means that the URL is reachable.
+func isURLReachable(u string) error {
+	client := &http.Client{Timeout: settingsURLTimeout}
+	req, err := http.NewRequest(http.MethodHead, u, nil)
+	if err != nil {
 		return err
 	}
+	req.Header.Set("User-Agent", "listmonk")
 
-	return a.handleSettingsRestart(c)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
 }
 
 // UpdateSettingsByKey updates a single setting key-value in the DB.
@@ -399,7 +1120,10 @@ func (a *App) TestSMTPSettings(c echo.Context) error {
 	req.MaxConns = 1
 	req.IdleTimeout = time.Second * 2
 	req.PoolWaitTimeout = time.Second * 2
-	msgr, err := email.New("", req)
+
+	// The test message is sent through the server itself, even if it's in shadow mode.
+	req.ShadowMode = false
+	msgr, err := email.New("", a.log, req)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest,
 			a.i18n.Ts("globals.messages.errorCreating", "name", "SMTP", "error", err.Error()))
@@ -434,3 +1158,40 @@ func (a *App) GetAboutInfo(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, out)
 }
+
+// cleanAttribFilter trims and de-duplicates the keys of an attribute filter
+// and falls back to the given mode if the mode is invalid.
+func cleanAttribFilter(f models.AttribFilter, defMode string) models.AttribFilter {
+	switch f.Mode {
+	case models.AttribFilterAll, models.AttribFilterAllow, models.AttribFilterDeny:
+	default:
+		f.Mode = defMode
+	}
+
+	keys := make([]string, 0, len(f.Keys))
+	for _, k := range f.Keys {
+		if k = strings.TrimSpace(k); k != "" && !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	f.Keys = keys
+
+	return f
+}
+
+// validateWarmupSchedule validates the steps of a messenger's warm-up schedule
+// and sorts them by day.
+func validateWarmupSchedule(steps []models.WarmupStep) error {
+	slices.SortFunc(steps, func(a, b models.WarmupStep) int { return a.Day - b.Day })
+
+	for i, s := range steps {
+		if s.Day < 1 || s.MaxSends < 1 {
+			return fmt.Errorf("day %d: day and max_sends should be > 0", s.Day)
+		}
+		if i > 0 && steps[i-1].Day == s.Day {
+			return fmt.Errorf("day %d is repeated", s.Day)
+		}
+	}
+
+	return nil
+}
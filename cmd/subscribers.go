@@ -1,26 +1,44 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/tmptokens"
+	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 const (
 	dummyUUID = "00000000-0000-0000-0000-000000000000"
+
+	// subStatusTTL is the time within which a bulk status change has to be confirmed.
+	subStatusTTL      = 2 * time.Minute
+	subStatusTokenLen = 32
+
+	// attribStatsMaxValues is the max number of distinct values of an attribute
+	// that are counted in its stats, for high-cardinality attributes.
+	attribStatsMaxValues = 100
+	attribStatsMaxKeyLen = 200
 )
 
 // subQueryReq is a "catch all" struct for reading various
@@ -37,6 +55,26 @@ type subQueryReq struct {
 	All                bool   `json:"all"`
 }
 
+// subBulkStatusReq is a request for changing the status of subscribers
+// either by IDs or by a structured query.
+type subBulkStatusReq struct {
+	SubscriberIDs []int `json:"ids"`
+	Query         *struct {
+		ListID  int         `json:"list_id"`
+		Attribs models.JSON `json:"attrib"`
+	} `json:"query"`
+	Status string `json:"status"`
+	Token  string `json:"confirmation_token"`
+}
+
+// subStatusToken is the transient state of a pending bulk status change
+// confirmation stored in tmptokens. Hash is a hash of the normalized request
+// the token was issued for so that it can't be used for a different change.
+type subStatusToken struct {
+	Token string
+	Hash  string
+}
+
 // subOptin contains the data that's passed to the double opt-in e-mail template.
 type subOptin struct {
 	models.Subscriber
@@ -52,7 +90,14 @@ var (
 		Name:    "Demo Subscriber",
 		UUID:    dummyUUID,
 		Attribs: models.JSON{"city": "Bengaluru"},
+
+		UnsubscribeToken: dummyUUID,
 	}
+
+	// Default and all the fields in subscriber CSV exports. "attributes" is an
+	// alias of "attribs" for compatibility with older exports.
+	subExportFields    = []string{"uuid", "email", "name", "attributes", "status", "created_at", "updated_at"}
+	subExportAllFields = []string{"id", "uuid", "email", "name", "attribs", "attributes", "status", "created_at", "updated_at"}
 )
 
 // GetSubscriber handles the retrieval of a single subscriber by ID.
@@ -95,6 +140,197 @@ func (a *App) GetSubscriberActivity(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// GetSubscriberSends handles the retrieval of the campaigns sent to a subscriber.
+func (a *App) GetSubscriberSends(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	// Check if the user has access to at least one of the lists on the subscriber.
+	id := getID(c)
+	if err := a.hasSubPerm(user, []int{id}); err != nil {
+		return err
+	}
+
+	pg := a.pg.NewFromURL(c.Request().URL.Query())
+	res, total, err := a.core.GetSubscriberSends(id, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSubscriberConsents returns the paginated audit trail of a subscriber's consents.
+func (a *App) GetSubscriberConsents(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	// Check if the user has access to at least one of the lists on the subscriber.
+	id := getID(c)
+	if err := a.hasSubPerm(user, []int{id}); err != nil {
+		return err
+	}
+
+	pg := a.pg.NewFromURL(c.Request().URL.Query())
+	res, total, err := a.core.GetSubscriberConsents(id, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// recordConsent records a consent event with the user agent of the request, and its IP
+// if opt-in IPs are recorded. Failing to record it doesn't fail the action it's for.
+func (a *App) recordConsent(c echo.Context, e models.ConsentEvent) {
+	if a.cfg.Privacy.RecordOptinIP {
+		e.IP = c.RealIP()
+	}
+	e.UserAgent = c.Request().UserAgent()
+
+	_ = a.core.RecordConsent(e)
+}
+
+// PreviewSubscriberCampaign renders a campaign with a subscriber's data the way the
+// subscriber would receive it, for troubleshooting personalisation. The message is
+// only rendered and not sent.
+func (a *App) PreviewSubscriberCampaign(c echo.Context) error {
+	var (
+		user  = auth.GetUser(c)
+		subID = getID(c)
+	)
+
+	campID, err := strconv.Atoi(c.Param("campaign_id"))
+	if err != nil || campID < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+	}
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, campID, c); err != nil {
+		return err
+	}
+
+	sub, err := a.getPreviewSubscriber(user, subID)
+	if err != nil {
+		return err
+	}
+
+	camp, body, err := a.renderCampaignPreview(c, campID, sub)
+	if err != nil {
+		return err
+	}
+
+	// Record the subscriber's data being viewed in the campaign's audit trail.
+	a.recordSubscriberPreview(campID, subID, user)
+
+	// Plaintext headers for plain body.
+	if camp.ContentType == models.CampaignContentTypePlain {
+		return c.String(http.StatusOK, string(body))
+	}
+
+	return c.HTML(http.StatusOK, string(body))
+}
+
+// GetSubscriberCampaignHistory handles the retrieval of the campaign messages sent to a
+// subscriber from the send log with cursor based pagination (?cursor=&per_page=).
+func (a *App) GetSubscriberCampaignHistory(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	// Check if the user has access to at least one of the lists on the subscriber.
+	id := getID(c)
+	if err := a.hasSubPerm(user, []int{id}); err != nil {
+		return err
+	}
+
+	var (
+		cursor  null.Time
+		perPage = activityDefaultPerPage
+	)
+	if v := c.QueryParam("cursor"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "cursor"))
+		}
+		cursor = null.TimeFrom(t)
+	}
+
+	if v := c.QueryParam("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > activityMaxPerPage {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "per_page"))
+		}
+		perPage = n
+	}
+
+	out, err := a.core.GetSubscriberCampaignHistory(id, cursor, perPage)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSubscriberAttribHistory handles the retrieval of the attribute changelog of a subscriber.
+func (a *App) GetSubscriberAttribHistory(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	// Check if the user has access to at least one of the lists on the subscriber.
+	id := getID(c)
+	if err := a.hasSubPerm(user, []int{id}); err != nil {
+		return err
+	}
+
+	pg := a.pg.NewFromURL(c.Request().URL.Query())
+	res, total, err := a.core.GetAttribChangelog(id, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSubscriberAttribStats handles the retrieval of the aggregate statistics of the values
+// of a subscriber attribute on the subscribers the user has access to, optionally on the
+// given lists.
+func (a *App) GetSubscriberAttribStats(c echo.Context) error {
+	key := strings.TrimSpace(c.QueryParam("key"))
+	if key == "" || len(key) > attribStatsMaxKeyLen {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "key"))
+	}
+
+	// Filter list IDs by permission.
+	listIDs, err := a.filterListQueryByPerm("list_id", c.QueryParams(), auth.GetUser(c))
+	if err != nil {
+		return err
+	}
+
+	out, err := a.core.GetSubscriberAttribStats(key, listIDs, attribStatsMaxValues)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // QuerySubscribers handles querying subscribers based on an arbitrary SQL expression.
 func (a *App) QuerySubscribers(c echo.Context) error {
 	// Get the authenticated user.
@@ -145,7 +381,9 @@ func (a *App) QuerySubscribers(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// ExportSubscribers handles querying subscribers based on an arbitrary SQL expression.
+// ExportSubscribers handles the CSV export of subscribers. It accepts the same params
+// (search, query, list_id, subscription_status, order_by, order) as QuerySubscribers and
+// exports exactly the subscribers that it returns, optionally limited to the given IDs.
 func (a *App) ExportSubscribers(c echo.Context) error {
 	// Get the authenticated user.
 	user := auth.GetUser(c)
@@ -162,14 +400,17 @@ func (a *App) ExportSubscribers(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
 	}
 
-	// Filter by subscription status
-	subStatus := c.QueryParam("subscription_status")
+	// Columns to export.
+	fields, err := parseExportFields(c.QueryParam("fields"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", err.Error()))
+	}
+
+	// Export IDN domains in their ASCII (punycode) form for systems that don't support them?
+	asciiEmails := c.QueryParam("email_format") == "ascii"
 
 	// Does the user have the subscribers:sql_query permission?
-	var (
-		searchStr = strings.TrimSpace(c.FormValue("search"))
-		query     = formatSQLExp(c.FormValue("query"))
-	)
+	query := formatSQLExp(c.FormValue("query"))
 	if query != "" {
 		if !user.HasPerm(auth.PermSubscribersSqlQuery) {
 			return echo.NewHTTPError(http.StatusForbidden,
@@ -177,11 +418,19 @@ func (a *App) ExportSubscribers(c echo.Context) error {
 		}
 	}
 
+	var (
+		searchStr = strings.TrimSpace(c.FormValue("search"))
+		subStatus = c.FormValue("subscription_status")
+		order     = c.FormValue("order")
+		orderBy   = c.FormValue("order_by")
+	)
+
 	// Get the batched export iterator.
-	exp, err := a.core.ExportSubscribers(searchStr, query, subIDs, listIDs, subStatus, a.cfg.DBBatchSize)
+	next, done, err := a.core.ExportSubscribers(searchStr, query, subIDs, listIDs, subStatus, order, orderBy, a.cfg.DBBatchSize)
 	if err != nil {
 		return err
 	}
+	defer done()
 
 	var (
 		hdr = c.Response().Header()
@@ -193,12 +442,14 @@ func (a *App) ExportSubscribers(c echo.Context) error {
 	hdr.Set(echo.HeaderContentDisposition, "attachment; filename="+"subscribers.csv")
 	hdr.Set("Content-Transfer-Encoding", "binary")
 	hdr.Set("Cache-Control", "no-cache")
-	wr.Write([]string{"uuid", "email", "name", "attributes", "status", "created_at", "updated_at"})
+	wr.Write(fields)
+
+	row := make([]string, len(fields))
 
 loop:
 	// Iterate in batches until there are no more subscribers to export.
 	for {
-		out, err := exp()
+		out, err := next()
 		if err != nil {
 			return err
 		}
@@ -206,9 +457,18 @@ loop:
 			break
 		}
 
-		for _, r := range out {
-			if err = wr.Write([]string{r.UUID, r.Email, r.Name, r.Attribs, r.Status,
-				r.CreatedAt.Time.String(), r.UpdatedAt.Time.String()}); err != nil {
+		for _, s := range out {
+			if asciiEmails {
+				// Addresses with non-ASCII local parts can't be converted and are exported as-is.
+				if em, err := utils.EmailToASCII(s.Email); err == nil {
+					s.Email = em
+				}
+			}
+
+			for i, f := range fields {
+				row[i] = exportField(s, f)
+			}
+			if err = wr.Write(row); err != nil {
 				a.log.Printf("error streaming CSV export: %v", err)
 				break loop
 			}
@@ -235,7 +495,7 @@ func (a *App) CreateSubscriber(c echo.Context) error {
 	// Validate fields.
 	req, err := a.importer.ValidateFields(req)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return a.invalidEmailResp(c, err)
 	}
 
 	// Filter lists against the current user's permitted lists.
@@ -247,10 +507,14 @@ func (a *App) CreateSubscriber(c echo.Context) error {
 	}
 
 	// Insert the subscriber into the DB.
-	sub, _, err := a.core.InsertSubscriber(req.Subscriber, listIDs, nil, req.PreconfirmSubs, false)
+	sub, _, err := a.core.InsertSubscriber(req.Subscriber, listIDs, nil, req.PreconfirmSubs, false, models.SubscribeSourceAPI)
 	if err != nil {
 		return err
 	}
+	if len(listIDs) > 0 {
+		a.recordConsent(c, models.ConsentEvent{SubscriberIDs: []int{sub.ID}, Type: models.ConsentTypeSubscribe,
+			ListIDs: listIDs, Text: a.i18n.Ts("subscribers.consentSubscribedBy", "name", user.Username)})
+	}
 
 	return c.JSON(http.StatusOK, okResp{sub})
 }
@@ -272,7 +536,7 @@ func (a *App) UpdateSubscriber(c echo.Context) error {
 
 	// Sanitize and validate the email field.
 	if em, err := a.importer.SanitizeEmail(req.Email); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return a.invalidEmailResp(c, err)
 	} else {
 		req.Email = em
 	}
@@ -297,6 +561,12 @@ func (a *App) UpdateSubscriber(c echo.Context) error {
 		return err
 	}
 
+	// Get the existing attributes to record the changes.
+	sub, err := a.core.GetSubscriber(id, "", "")
+	if err != nil {
+		return err
+	}
+
 	// Get the user's permitted lists to pass to the update query so that lists on the subscribers
 	// to which they don't have permissions are preserved/left as-is when deleteLists=true.
 	allPerm, permittedLists := user.GetPermittedLists(auth.PermTypeManage)
@@ -304,11 +574,22 @@ func (a *App) UpdateSubscriber(c echo.Context) error {
 		permittedLists = []int{}
 	}
 
-	out, _, err := a.core.UpdateSubscriberWithLists(id, req.Subscriber, listIDs, nil, req.PreconfirmSubs, true, false, permittedLists, false)
+	// Adding new lists to a subscriber who recently unsubscribed from everything re-subscribes them.
+	if req.Status != models.SubscriberStatusBlockListed && hasNewLists(sub, listIDs) {
+		force, _ := strconv.ParseBool(c.QueryParam("force"))
+		if err := a.checkResubscribeProtection(user, []int{id}, force); err != nil {
+			return err
+		}
+	}
+
+	out, _, err := a.core.UpdateSubscriberWithLists(id, req.Subscriber, listIDs, nil, req.PreconfirmSubs, true, false, permittedLists, false, models.SubscribeSourceAPI)
 	if err != nil {
 		return err
 	}
 
+	// Record the attribute changes. Failures are logged and don't fail the update.
+	_ = a.core.LogAttribChanges(id, user.ID, sub.Attribs, out.Attribs)
+
 	maskRestrictedSubLists(user, &out)
 
 	return c.JSON(http.StatusOK, okResp{out})
@@ -332,6 +613,10 @@ func (a *App) PatchSubscriber(c echo.Context) error {
 		return err
 	}
 
+	// Binding the request into the existing attributes modifies them in place, so
+	// keep a copy to record the changes.
+	oldAttribs := maps.Clone(sub.Attribs)
+
 	// Prepopulate the incoming request struct with existing values.
 	// Rather than tediously and conditionally checking each incoming field, we can simply
 	// overwrite everything in the DB with the incoming fields+existing fields.
@@ -348,7 +633,7 @@ func (a *App) PatchSubscriber(c echo.Context) error {
 	}
 
 	if em, err := a.importer.SanitizeEmail(req.Email); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return a.invalidEmailResp(c, err)
 	} else {
 		req.Email = em
 	}
@@ -373,11 +658,21 @@ func (a *App) PatchSubscriber(c echo.Context) error {
 		permittedLists = []int{}
 	}
 
-	out, _, err := a.core.UpdateSubscriberWithLists(id, req.Subscriber, listIDs, nil, req.PreconfirmSubs, overwriteSubs, false, permittedLists, false)
+	if req.Status != models.SubscriberStatusBlockListed && hasNewLists(sub, listIDs) {
+		force, _ := strconv.ParseBool(c.QueryParam("force"))
+		if err := a.checkResubscribeProtection(user, []int{id}, force); err != nil {
+			return err
+		}
+	}
+
+	out, _, err := a.core.UpdateSubscriberWithLists(id, req.Subscriber, listIDs, nil, req.PreconfirmSubs, overwriteSubs, false, permittedLists, false, models.SubscribeSourceAPI)
 	if err != nil {
 		return err
 	}
 
+	// Record the attribute changes. Failures are logged and don't fail the update.
+	_ = a.core.LogAttribChanges(id, user.ID, oldAttribs, out.Attribs)
+
 	maskRestrictedSubLists(user, &out)
 
 	return c.JSON(http.StatusOK, okResp{out})
@@ -449,6 +744,88 @@ func (a *App) BlocklistSubscribers(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// UpdateSubscribersStatus handles bulk status changes of subscribers given
+// either a list of IDs or a query of a list and attributes. Destructive changes
+// (blocklisting) return a confirmation token and the number of subscribers that'd
+// be affected on the first request, and are only applied when the token is presented
+// with the same request again.
+func (a *App) UpdateSubscribersStatus(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	var req subBulkStatusReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	switch req.Status {
+	case models.SubscriberStatusEnabled, models.SubscriberStatusDisabled, models.SubscriberStatusBlockListed:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "status"))
+	}
+
+	var listIDs []int
+	if len(req.SubscriberIDs) > 0 {
+		if err := a.hasSubPerm(user, req.SubscriberIDs); err != nil {
+			return err
+		}
+	} else if req.Query != nil && (req.Query.ListID > 0 || len(req.Query.Attribs) > 0) {
+		// Filter the list against the current user's permitted lists.
+		if req.Query.ListID > 0 {
+			listIDs = user.FilterListsByPerm(auth.PermTypeManage, []int{req.Query.ListID})
+			if len(listIDs) == 0 {
+				return echo.NewHTTPError(http.StatusForbidden, a.i18n.Ts("globals.messages.permissionDenied", "name", "lists"))
+			}
+		} else {
+			listIDs = user.GetPermittedListIDs(nil)
+		}
+	} else {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "ids / query"))
+	}
+
+	var attribs models.JSON
+	if req.Query != nil && len(req.SubscriberIDs) == 0 {
+		attribs = req.Query.Attribs
+	}
+
+	// Blocklisting requires a confirmation. The first request only counts the subscribers
+	// and returns a token that has to be sent back with the same request.
+	if req.Status == models.SubscriberStatusBlockListed {
+		hash := hashSubStatusReq(req.SubscriberIDs, listIDs, attribs, req.Status)
+		if req.Token == "" {
+			count, err := a.core.UpdateSubscribersStatus(req.SubscriberIDs, listIDs, attribs, req.Status, false)
+			if err != nil {
+				return err
+			}
+
+			token, err := generateRandomString(subStatusTokenLen)
+			if err != nil {
+				a.log.Printf("error generating subscriber status token: %v", err)
+				return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+			}
+			tmptokens.Set(subStatusTokenKey(user.ID), subStatusTTL, subStatusToken{Token: token, Hash: hash})
+
+			return c.JSON(http.StatusAccepted, okResp{models.SubscriberStatusConfirmation{
+				Token:     token,
+				ExpiresAt: time.Now().Add(subStatusTTL),
+				Count:     count,
+			}})
+		}
+
+		if err := a.consumeSubStatusToken(user.ID, req.Token, hash); err != nil {
+			return err
+		}
+	}
+
+	count, err := a.core.UpdateSubscribersStatus(req.SubscriberIDs, listIDs, attribs, req.Status, true)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Count int `json:"count"`
+	}{count}})
+}
+
 // ManageSubscriberLists handles bulk addition or removal of subscribers
 // from or to one or more target lists.
 // It takes either an ID in the URI, or a list of IDs in the request body.
@@ -500,6 +877,13 @@ func (a *App) ManageSubscriberLists(c echo.Context) error {
 	var err error
 	switch req.Action {
 	case "add":
+		if req.Status != models.SubscriptionStatusUnsubscribed {
+			force, _ := strconv.ParseBool(c.QueryParam("force"))
+			if err := a.checkResubscribeProtection(user, subIDs, force); err != nil {
+				return err
+			}
+		}
+
 		err = a.core.AddSubscriptions(subIDs, listIDs, req.Status)
 	case "remove":
 		err = a.core.DeleteSubscriptions(subIDs, listIDs)
@@ -513,6 +897,14 @@ func (a *App) ManageSubscriberLists(c echo.Context) error {
 		return err
 	}
 
+	cn := models.ConsentEvent{SubscriberIDs: subIDs, Type: models.ConsentTypeUnsubscribe, ListIDs: listIDs,
+		Text: a.i18n.Ts("subscribers.consentUnsubscribedBy", "name", user.Username)}
+	if req.Action == "add" && req.Status != models.SubscriptionStatusUnsubscribed {
+		cn.Type = models.ConsentTypeSubscribe
+		cn.Text = a.i18n.Ts("subscribers.consentSubscribedBy", "name", user.Username)
+	}
+	a.recordConsent(c, cn)
+
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
@@ -526,13 +918,36 @@ func (a *App) DeleteSubscriber(c echo.Context) error {
 		return err
 	}
 
-	if err := a.core.DeleteSubscribers([]int{id}, nil); err != nil {
+	if err := a.deleteSubscribers([]int{id}, nil); err != nil {
 		return err
 	}
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// AnonymizeSubscriber handles the anonymization of a single subscriber. The subscriber
+// is deleted, but their views, clicks, sends, and bounces are retained under the
+// anonymized placeholder subscriber.
+func (a *App) AnonymizeSubscriber(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	id := getID(c)
+	if err := a.hasSubPerm(user, []int{id}); err != nil {
+		return err
+	}
+
+	n, err := a.core.AnonymizeSubscribers([]int{id}, nil)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return echo.NewHTTPError(http.StatusNotFound,
+			a.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.subscriber}"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
 // DeleteSubscribers handles bulk deletion of one or more subscribers.
 func (a *App) DeleteSubscribers(c echo.Context) error {
 	user := auth.GetUser(c)
@@ -553,7 +968,7 @@ func (a *App) DeleteSubscribers(c echo.Context) error {
 	}
 
 	// Delete the subscribers from the DB.
-	if err := a.core.DeleteSubscribers(ids, nil); err != nil {
+	if err := a.deleteSubscribers(ids, nil); err != nil {
 		return err
 	}
 
@@ -592,8 +1007,12 @@ func (a *App) DeleteSubscribersByQuery(c echo.Context) error {
 	// Filter list IDs against the current user's permitted lists.
 	listIDs := user.GetPermittedListIDs(req.ListIDs)
 
-	// Delete the subscribers from the DB.
-	if err := a.core.DeleteSubscribersByQuery(req.Search, req.Query, listIDs, req.SubscriptionStatus); err != nil {
+	// Delete the subscribers from the DB, or anonymize them if the privacy mode is set to.
+	if a.cfg.Privacy.Mode == models.PrivacyModeAnonymizeOnDelete {
+		if _, err := a.core.AnonymizeSubscribersByQuery(req.Search, req.Query, listIDs, req.SubscriptionStatus); err != nil {
+			return err
+		}
+	} else if err := a.core.DeleteSubscribersByQuery(req.Search, req.Query, listIDs, req.SubscriptionStatus); err != nil {
 		return err
 	}
 
@@ -721,7 +1140,7 @@ func (a *App) ExportSubscriberData(c echo.Context) error {
 		return err
 	}
 
-	_, b, err := a.exportSubscriberData(id, "", a.cfg.Privacy.Exportable)
+	_, b, err := a.exportSubscriberData(id, "", a.cfg.Privacy.Exportable, nil)
 	if err != nil {
 		a.log.Printf("error exporting subscriber data: %s", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -737,13 +1156,29 @@ func (a *App) ExportSubscriberData(c echo.Context) error {
 // exportSubscriberData collates the data of a subscriber including profile,
 // subscriptions, campaign_views, link_clicks (if they're enabled in the config)
 // and returns a formatted, indented JSON payload. Either takes a numeric id
-// and an empty subUUID or takes 0 and a string subUUID.
-func (a *App) exportSubscriberData(id int, subUUID string, exportables map[string]bool) (models.SubscriberExportProfile, []byte, error) {
+// and an empty subUUID or takes 0 and a string subUUID. If fnAttribs is set,
+// the attributes in the profile are filtered through it.
+func (a *App) exportSubscriberData(id int, subUUID string, exportables map[string]bool, fnAttribs func(models.JSON) models.JSON) (models.SubscriberExportProfile, []byte, error) {
 	data, err := a.core.GetSubscriberProfileForExport(id, subUUID)
 	if err != nil {
 		return data, nil, err
 	}
 
+	if fnAttribs != nil && len(data.Profile) > 0 {
+		var prof []map[string]any
+		if err := json.Unmarshal(data.Profile, &prof); err != nil {
+			a.log.Printf("error unmarshalling subscriber export profile: %v", err)
+			return data, nil, err
+		}
+		for _, p := range prof {
+			attribs, _ := p["attribs"].(map[string]any)
+			p["attribs"] = fnAttribs(attribs)
+		}
+		if data.Profile, err = json.Marshal(prof); err != nil {
+			return data, nil, err
+		}
+	}
+
 	// Filter out the non-exportable items.
 	if _, ok := exportables["profile"]; !ok {
 		data.Profile = nil
@@ -757,6 +1192,9 @@ func (a *App) exportSubscriberData(id int, subUUID string, exportables map[strin
 	if _, ok := exportables["link_clicks"]; !ok {
 		data.LinkClicks = nil
 	}
+	if _, ok := exportables["consents"]; !ok {
+		data.Consents = nil
+	}
 
 	// Marshal the data into an indented payload.
 	b, err := json.MarshalIndent(data, "", "  ")
@@ -822,6 +1260,56 @@ func (a *App) hasSubPerm(u auth.User, subIDs []int) error {
 	return nil
 }
 
+// checkResubscribeProtection returns a conflict error if any of the given subscribers
+// unsubscribed from all their lists within the configured protection period. Super
+// admins can bypass the check with force.
+func (a *App) checkResubscribeProtection(u auth.User, subIDs []int, force bool) error {
+	if force {
+		if u.UserRoleID != auth.SuperAdminRoleID {
+			return echo.NewHTTPError(http.StatusForbidden, a.i18n.Ts("globals.messages.permissionDenied", "name", "force"))
+		}
+		return nil
+	}
+
+	days := a.cfg.Privacy.ResubscribeProtectionDays
+	if days <= 0 || len(subIDs) == 0 {
+		return nil
+	}
+
+	ids, err := a.core.GetResubscribeProtected(subIDs, days)
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		return echo.NewHTTPError(http.StatusConflict,
+			a.i18n.Ts("subscribers.resubscribeProtected", "ids", fmt.Sprintf("%v", ids), "days", strconv.Itoa(days)))
+	}
+
+	return nil
+}
+
+// hasNewLists checks whether any of the given list IDs is not among the subscriber's
+// existing subscriptions.
+func hasNewLists(sub models.Subscriber, listIDs []int) bool {
+	var lists []struct {
+		ID int `json:"id"`
+	}
+	_ = sub.Lists.Unmarshal(&lists)
+
+	existing := make(map[int]struct{}, len(lists))
+	for _, l := range lists {
+		existing[l.ID] = struct{}{}
+	}
+
+	for _, id := range listIDs {
+		if _, ok := existing[id]; !ok {
+			return true
+		}
+	}
+
+	return false
+}
+
 // filterListQueryByPerm filters the list IDs in the query params and returns the list IDs to which the user has access.
 func (a *App) filterListQueryByPerm(param string, qp url.Values, user auth.User) ([]int, error) {
 	var listIDs []int
@@ -839,6 +1327,120 @@ func (a *App) filterListQueryByPerm(param string, qp url.Values, user auth.User)
 	return user.GetPermittedListIDs(listIDs), nil
 }
 
+// consumeSubStatusToken validates a bulk status change confirmation token against
+// the request it was issued for and deletes it so that it can't be used again.
+func (a *App) consumeSubStatusToken(userID int, token, hash string) error {
+	key := subStatusTokenKey(userID)
+
+	data, err := tmptokens.Check(key)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.statusConfirmInvalid"))
+	}
+	t, ok := data.(subStatusToken)
+	if !ok || t.Hash != hash || subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) != 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.statusConfirmInvalid"))
+	}
+
+	// Get() deletes the token. If it's already gone, a concurrent request has consumed it.
+	if _, err := tmptokens.Get(key); err != nil {
+		return echo.NewHTTPError(http.StatusConflict, a.i18n.T("subscribers.statusConfirmInvalid"))
+	}
+
+	return nil
+}
+
+// hashSubStatusReq returns a hash of a normalized bulk status change request.
+func hashSubStatusReq(subIDs, listIDs []int, attribs models.JSON, status string) string {
+	b, _ := json.Marshal([]any{subIDs, listIDs, attribs, status})
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func subStatusTokenKey(userID int) string {
+	return "subscriber-status:" + strconv.Itoa(userID)
+}
+
+// invalidEmailResp returns the error response for a subscriber e-mail that failed
+// validation. E-mails rejected by the allowlist/denylist patterns get a 422 with
+// an error code that API clients can check.
+func (a *App) invalidEmailResp(c echo.Context, err error) error {
+	if errors.Is(err, subimporter.ErrEmailNotAllowed) {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{
+			"error":   subimporter.ErrEmailNotAllowed.Error(),
+			"message": err.Error(),
+		})
+	}
+
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
+// parseExportFields parses the comma separated list of subscriber fields to export.
+// Individual attributes can be exported as attribs.<key>.
+func parseExportFields(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return subExportFields, nil
+	}
+
+	out := []string{}
+	for f := range strings.SplitSeq(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		if key, ok := strings.CutPrefix(f, "attribs."); ok {
+			if key == "" {
+				return nil, errors.New(f)
+			}
+		} else if !inArray(f, subExportAllFields) {
+			return nil, errors.New(f)
+		}
+
+		out = append(out, f)
+	}
+
+	if len(out) == 0 {
+		return subExportFields, nil
+	}
+
+	return out, nil
+}
+
+// exportField returns the CSV value of the given field of a subscriber.
+func exportField(s models.Subscriber, f string) string {
+	switch f {
+	case "id":
+		return strconv.Itoa(s.ID)
+	case "uuid":
+		return s.UUID
+	case "email":
+		return s.Email
+	case "name":
+		return s.Name
+	case "attribs", "attributes":
+		b, _ := json.Marshal(s.Attribs)
+		return string(b)
+	case "status":
+		return s.Status
+	case "created_at":
+		return s.CreatedAt.Time.String()
+	case "updated_at":
+		return s.UpdatedAt.Time.String()
+	}
+
+	// attribs.<key>
+	v, ok := s.Attribs[strings.TrimPrefix(f, "attribs.")]
+	if !ok || v == nil {
+		return ""
+	}
+	if str, ok := v.(string); ok {
+		return str
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
 // formatSQLExp does basic sanitisation on arbitrary
 // SQL query expressions coming from the frontend.
 func formatSQLExp(q string) string {
@@ -881,16 +1483,16 @@ func makeOptinNotifyHook(unsubHeader bool, u *UrlConfig, q *models.Queries, i *i
 		for _, l := range out.Lists {
 			qListIDs.Add("l", l.UUID)
 		}
-		out.OptinURL = fmt.Sprintf(u.OptinURL, sub.UUID, qListIDs.Encode())
-		out.UnsubURL = fmt.Sprintf(u.UnsubURL, dummyUUID, sub.UUID)
+		out.OptinURL = fmt.Sprintf(u.OptinURL, sub.UnsubscribeToken, qListIDs.Encode())
+		out.UnsubURL = fmt.Sprintf(u.UnsubURL, dummyUUID, sub.UnsubscribeToken)
 
 		// Unsub headers.
 		hdr := textproto.MIMEHeader{}
-		hdr.Set(models.EmailHeaderSubscriberUUID, sub.UUID)
+		hdr.Set(models.EmailHeaderSubscriberUUID, sub.UnsubscribeToken)
 
 		// Attach List-Unsubscribe headers?
 		if unsubHeader {
-			unsubURL := fmt.Sprintf(u.UnsubURL, dummyUUID, sub.UUID)
+			unsubURL := fmt.Sprintf(u.UnsubURL, dummyUUID, sub.UnsubscribeToken)
 			hdr.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
 			hdr.Set("List-Unsubscribe", `<`+unsubURL+`>`)
 		}
@@ -904,3 +1506,14 @@ func makeOptinNotifyHook(unsubHeader bool, u *UrlConfig, q *models.Queries, i *i
 		return len(lists), nil
 	}
 }
+
+// deleteSubscribers deletes subscribers by their IDs or UUIDs, or anonymizes them
+// instead if the privacy mode is set to anonymize on delete.
+func (a *App) deleteSubscribers(ids []int, uuids []string) error {
+	if a.cfg.Privacy.Mode == models.PrivacyModeAnonymizeOnDelete {
+		_, err := a.core.AnonymizeSubscribers(ids, uuids)
+		return err
+	}
+
+	return a.core.DeleteSubscribers(ids, uuids)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Rate limit of subscription status polling per IP.
+	subStatusRateLimitIP       = 10
+	subStatusRateLimitIPWindow = time.Minute
+
+	// Query param with which the e-mail hash is added to the thank-you page URL
+	// that a subscription form redirects to.
+	subStatusHashParam = "status_hash"
+
+	// Status of subscriptions that don't exist or have been unsubscribed.
+	subStatusNotFound = "not_found"
+)
+
+var reSubStatusHash = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// PublicSubscriptionStatus returns the status of a recent public subscription to
+// a list, for a thank-you page to poll whether the subscriber has confirmed it. The
+// subscriber is identified by the HMAC hash of their e-mail in ?email, which is returned
+// on subscription, instead of the e-mail so that subscriptions can't be enumerated.
+func (a *App) PublicSubscriptionStatus(c echo.Context) error {
+	if !a.cfg.EnablePublicSubPage || a.cfg.Security.SubStatusKey == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.invalidFeature"))
+	}
+
+	if err := a.checkSubRateLimit(c, "sub:status:"+c.RealIP(), subStatusRateLimitIP, subStatusRateLimitIPWindow); err != nil {
+		return err
+	}
+
+	var (
+		hash     = strings.ToLower(c.QueryParam("email"))
+		listUUID = c.QueryParam("list_uuid")
+	)
+	if !reSubStatusHash.MatchString(hash) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "email"))
+	}
+	if !reUUID.MatchString(listUUID) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidUUID"))
+	}
+
+	status, err := a.core.GetPublicSubscriptionStatus(listUUID, hash, a.cfg.Security.SubStatusKey)
+	if err != nil {
+		return err
+	}
+
+	out := subStatusNotFound
+	if status == models.SubscriptionStatusUnconfirmed || status == models.SubscriptionStatusConfirmed {
+		out = status
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Status string `json:"status"`
+	}{out}})
+}
+
+// subStatusHash returns the hex HMAC-SHA256 hash of a lowercased e-mail with
+// which the status of its public subscriptions is polled.
+func (a *App) subStatusHash(email string) string {
+	if a.cfg.Security.SubStatusKey == "" || email == "" {
+		return ""
+	}
+
+	h := hmac.New(sha256.New, []byte(a.cfg.Security.SubStatusKey))
+	h.Write([]byte(strings.ToLower(email)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// addSubStatusHash adds the status hash of an e-mail to a thank-you page URL.
+func (a *App) addSubStatusHash(link, email string) string {
+	hash := a.subStatusHash(email)
+	if hash == "" {
+		return link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+
+	q := u.Query()
+	q.Set(subStatusHashParam, hash)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
@@ -1,15 +1,33 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/internal/tpllint"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 const (
@@ -29,8 +47,42 @@ const (
 
 var (
 	regexpTplTag = regexp.MustCompile(`{{(\s+)?template\s+?"content"(\s+)?\.(\s+)?}}`)
+
+	// Local file references in the HTML of template bundles: the src, href, and
+	// background attributes and the CSS url()s.
+	regexpBundleRefs = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\b(?:src|href|background)\s*=\s*["']([^"']+)["']`),
+		regexp.MustCompile(`(?i)\burl\(\s*["']?([^"')\s]+)["']?\s*\)`),
+	}
+)
+
+const (
+	// maxTplBundleSize is the max size of an uploaded template bundle (zip) and
+	// maxTplBundleExtractedSize, the max size of all its files uncompressed.
+	maxTplBundleSize          = 20 * 1024 * 1024
+	maxTplBundleExtractedSize = 50 * 1024 * 1024
+	maxTplBundleFiles         = 500
+
+	tplBundleIndex = "index.html"
+)
+
+// maxTplTestVariants is the maximum number of subscriber variants that
+// can be rendered in a single template test matrix request.
+const maxTplTestVariants = 20
+
+// Default and maximum number of renders in a template benchmark request.
+const (
+	defaultTplBenchmarkRuns = 100
+	maxTplBenchmarkRuns     = 1000
 )
 
+// tplTestResult is the rendered result of a template for a subscriber variant.
+type tplTestResult struct {
+	Attribs models.JSON `json:"attribs"`
+	Body    string      `json:"body"`
+	Error   string      `json:"error,omitempty"`
+}
+
 // GetTemplate handles the retrieval of a template
 func (a *App) GetTemplate(c echo.Context) error {
 	// If no_body is true, blank out the body of the template from the response.
@@ -43,6 +95,9 @@ func (a *App) GetTemplate(c echo.Context) error {
 		return err
 	}
 
+	// Other users who are currently editing the template.
+	out.Editors = a.presence.Get(tplPresenceKey(id), auth.GetUser(c).ID)
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -60,6 +115,51 @@ func (a *App) GetTemplates(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// GetLibraryTemplates handles retrieval of the templates in the template library.
+func (a *App) GetLibraryTemplates(c echo.Context) error {
+	out, err := a.core.GetLibraryTemplates()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CloneLibraryTemplate handles the duplication of a template in the template
+// library into an editable campaign template.
+func (a *App) CloneLibraryTemplate(c echo.Context) error {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	tpl, err := a.core.GetTemplate(getID(c), false)
+	if err != nil {
+		return err
+	}
+	if !tpl.IsLibrary {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.template}"))
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = tpl.Name
+	}
+	if !strHasLen(name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.fieldInvalidName"))
+	}
+
+	out, err := a.core.CreateTemplate(name, tpl.Type, "", []byte(tpl.Body), tpl.BodySource, null.String{}, null.Int{})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // PreviewTemplate renders the HTML preview of a template in the DB.
 func (a *App) PreviewTemplate(c echo.Context) error {
 	// Fetch one template from the DB.
@@ -78,6 +178,97 @@ func (a *App) PreviewTemplate(c echo.Context) error {
 	return c.HTML(http.StatusOK, string(out))
 }
 
+// TemplateTestMatrix renders a template for each of the given sets of subscriber
+// attributes to test conditional template logic across subscriber variants.
+func (a *App) TemplateTestMatrix(c echo.Context) error {
+	var variants []models.JSON
+	if err := c.Bind(&variants); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "JSON"))
+	}
+	if len(variants) == 0 || len(variants) > maxTplTestVariants {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.testMatrixInvalid", "max", strconv.Itoa(maxTplTestVariants)))
+	}
+
+	tpl, err := a.core.GetTemplate(getID(c), false)
+	if err != nil {
+		return err
+	}
+
+	// Compile the template once and render it for every variant.
+	render, err := a.compileTemplatePreview(tpl)
+	if err != nil {
+		return err
+	}
+
+	out := make([]tplTestResult, 0, len(variants))
+	for _, v := range variants {
+		if v == nil {
+			v = models.JSON{}
+		}
+
+		sub := dummySubscriber
+		sub.Attribs = v
+
+		res := tplTestResult{Attribs: v}
+		b, err := render(sub)
+		if err != nil {
+			if e, ok := err.(*echo.HTTPError); ok {
+				res.Error = fmt.Sprintf("%v", e.Message)
+			} else {
+				res.Error = err.Error()
+			}
+		} else {
+			res.Body = string(b)
+		}
+		out = append(out, res)
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// BenchmarkTemplate renders a template repeatedly with a dummy subscriber and
+// returns the render timing and message size stats.
+func (a *App) BenchmarkTemplate(c echo.Context) error {
+	var req struct {
+		Iterations int `json:"iterations"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "JSON"))
+	}
+	if req.Iterations == 0 {
+		req.Iterations = defaultTplBenchmarkRuns
+	}
+	if req.Iterations < 1 || req.Iterations > maxTplBenchmarkRuns {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.benchmarkInvalid", "max", strconv.Itoa(maxTplBenchmarkRuns)))
+	}
+
+	tpl, err := a.core.GetTemplate(getID(c), false)
+	if err != nil {
+		return err
+	}
+
+	render, err := a.compileTemplatePreview(tpl)
+	if err != nil {
+		return err
+	}
+
+	samples := make([]manager.RenderSample, 0, req.Iterations)
+	for range req.Iterations {
+		start := time.Now()
+		b, err := render(dummySubscriber)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, manager.RenderSample{Duration: time.Since(start), Size: len(b)})
+	}
+
+	return c.JSON(http.StatusOK, okResp{manager.ComputeRenderStats(samples)})
+}
+
 // PreviewTemplateBody renders the HTML preview of a template given its type and body.
 func (a *App) PreviewTemplateBody(c echo.Context) error {
 	tpl := models.Template{
@@ -104,6 +295,29 @@ func (a *App) PreviewTemplateBody(c echo.Context) error {
 	return c.HTML(http.StatusOK, string(out))
 }
 
+// LintTemplate checks the HTML of a template for common e-mail coding mistakes
+// with the configured rule severities. If a body is posted, it's checked instead
+// of the saved body, eg: for unsaved changes in the editor.
+func (a *App) LintTemplate(c echo.Context) error {
+	var req struct {
+		Body string `json:"body"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	body := req.Body
+	if body == "" {
+		tpl, err := a.core.GetTemplate(getID(c), false)
+		if err != nil {
+			return err
+		}
+		body = tpl.Body
+	}
+
+	return c.JSON(http.StatusOK, okResp{tpllint.Lint(body, a.cfg.TemplateLintRules)})
+}
+
 // CreateTemplate handles template creation.
 func (a *App) CreateTemplate(c echo.Context) error {
 	var o models.Template
@@ -113,6 +327,9 @@ func (a *App) CreateTemplate(c echo.Context) error {
 	if err := a.validateTemplate(o); err != nil {
 		return err
 	}
+	if err := a.validateTemplateLang(0, &o); err != nil {
+		return err
+	}
 
 	// Subject is only relevant for fixed tx templates. For campaigns,
 	// the subject changes per campaign and is on models.Campaign.
@@ -130,7 +347,7 @@ func (a *App) CreateTemplate(c echo.Context) error {
 	}
 
 	// Create the template the in the DB.
-	out, err := a.core.CreateTemplate(o.Name, o.Type, o.Subject, []byte(o.Body), o.BodySource)
+	out, err := a.core.CreateTemplate(o.Name, o.Type, o.Subject, []byte(o.Body), o.BodySource, o.Lang, o.ParentTemplateID)
 	if err != nil {
 		return err
 	}
@@ -169,10 +386,29 @@ func (a *App) UpdateTemplate(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	// Update the template in the DB.
+	// Library templates can't be modified.
 	id := getID(c)
-	out, err := a.core.UpdateTemplate(id, o.Name, o.Subject, []byte(o.Body), o.BodySource)
-	if err != nil {
+	if err := a.checkLibraryTemplate(id); err != nil {
+		return err
+	}
+	if err := a.validateTemplateLang(id, &o); err != nil {
+		return err
+	}
+
+	// Update the template in the DB. The update is rejected if the template has been
+	// modified since updated_at (from when the template was read).
+	out, err := a.core.UpdateTemplate(id, o.Name, o.Subject, []byte(o.Body), o.BodySource, o.Lang, o.ParentTemplateID, o.UpdatedAt)
+	if err == core.ErrConflict {
+		// Return the current template so that the changes can be merged.
+		cur, err := a.core.GetTemplate(id, false)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusConflict, conflictResp{
+			Message: a.i18n.Ts("globals.messages.conflict", "name", cur.Name),
+			Data:    cur,
+		})
+	} else if err != nil {
 		return err
 	}
 
@@ -185,10 +421,36 @@ func (a *App) UpdateTemplate(c echo.Context) error {
 
 }
 
+// TouchTemplate records that the current user is editing a template and returns the other
+// users who are editing it. Editors should touch the template periodically while it's open
+// and DELETE it once they're done.
+func (a *App) TouchTemplate(c echo.Context) error {
+	var (
+		id   = getID(c)
+		user = auth.GetUser(c)
+	)
+	if c.Request().Method == http.MethodDelete {
+		a.presence.Release(tplPresenceKey(id), user.ID)
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	a.presence.Touch(tplPresenceKey(id), user.ID, user.Username)
+	return c.JSON(http.StatusOK, okResp{a.presence.Get(tplPresenceKey(id), user.ID)})
+}
+
+// tplPresenceKey returns the key of a template in the editor presence tracker.
+func tplPresenceKey(id int) string {
+	return "template:" + strconv.Itoa(id)
+}
+
 // TemplateSetDefault handles template modification.
 func (a *App) TemplateSetDefault(c echo.Context) error {
-	// Update the template in the DB.
 	id := getID(c)
+	if err := a.checkLibraryTemplate(id); err != nil {
+		return err
+	}
+
+	// Update the template in the DB.
 	if err := a.core.SetDefaultTemplate(id); err != nil {
 		return err
 	}
@@ -196,10 +458,408 @@ func (a *App) TemplateSetDefault(c echo.Context) error {
 	return a.GetTemplates(c)
 }
 
+// tplBundleAsset is a local file in a template bundle that's referenced in its HTML
+// and the media it's uploaded as.
+type tplBundleAsset struct {
+	Path    string `json:"path"`
+	URL     string `json:"url"`
+	MediaID int    `json:"media_id"`
+
+	// Reused is set if the file was already in the media library and wasn't uploaded again.
+	Reused bool `json:"reused"`
+}
+
+type tplBundleResp struct {
+	Template models.Template  `json:"template"`
+	Assets   []tplBundleAsset `json:"assets"`
+}
+
+// ImportTemplateBundle handles the creation of a template from a zip bundle of
+// an index.html file and its images. The images referenced in the HTML are uploaded
+// to the media store (or reused if they're already in it) and their relative
+// references are rewritten to the media URLs.
+func (a *App) ImportTemplateBundle(c echo.Context) error {
+	o := models.Template{
+		Name:    strings.TrimSpace(c.FormValue("name")),
+		Type:    c.FormValue("type"),
+		Subject: c.FormValue("subject"),
+	}
+	if o.Type == "" {
+		o.Type = models.TemplateTypeCampaign
+	}
+	if o.Type != models.TemplateTypeCampaign && o.Type != models.TemplateTypeTx {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("media.invalidFile", "error", err.Error()))
+	}
+	if file.Size > maxTplBundleSize {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.bundleTooBig", "max", strconv.Itoa(maxTplBundleSize/1024/1024)))
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("media.errorReadingFile", "error", err.Error()))
+	}
+	defer src.Close()
+
+	// Read and validate the files in the bundle.
+	files, index, err := a.readTplBundle(src, file.Size)
+	if err != nil {
+		return err
+	}
+	o.Body = string(files[index])
+	if o.Name == "" {
+		o.Name = strings.TrimSuffix(makeFilename(file.Filename), filepath.Ext(file.Filename))
+	}
+
+	// Validate and compile the template before the assets are uploaded.
+	if err := a.validateTemplate(o); err != nil {
+		return err
+	}
+	if err := a.compileTplBundle(&o); err != nil {
+		return err
+	}
+
+	// Collect the files that are referenced in the HTML relative to index.html
+	// without rewriting anything yet.
+	var (
+		base   = path.Dir(index)
+		refs   = map[string]string{}
+		paths  []string
+		assets = []tplBundleAsset{}
+	)
+	rewriteBundleRefs(o.Body, func(ref string) (string, bool) {
+		p, ok := bundleRefPath(base, ref)
+		if !ok || p == index {
+			return "", false
+		}
+		if _, ok := files[p]; !ok {
+			return "", false
+		}
+		if _, ok := refs[p]; !ok {
+			refs[p] = ""
+			paths = append(paths, p)
+		}
+		return "", false
+	})
+
+	// Only images are accepted as assets.
+	for _, p := range paths {
+		if err := a.validateTplBundleAsset(p, files[p]); err != nil {
+			return err
+		}
+	}
+
+	// Upload the assets. If anything fails, the media that was uploaded is deleted.
+	var (
+		store   = media.WithRetry(a.media, mediaRetryOpt)
+		ctx     = c.Request().Context()
+		created []media.Media
+		done    = false
+	)
+	defer func() {
+		if !done {
+			for _, m := range created {
+				a.deleteTplBundleMedia(store, m)
+			}
+		}
+	}()
+
+	for _, p := range paths {
+		m, reused, err := a.uploadTplBundleAsset(ctx, store, p, files[p])
+		if err != nil {
+			return err
+		}
+		if !reused {
+			created = append(created, m)
+		}
+
+		refs[p] = m.URL
+		assets = append(assets, tplBundleAsset{Path: p, URL: m.URL, MediaID: m.ID, Reused: reused})
+	}
+
+	// Rewrite the references to the media URLs.
+	o.Body = rewriteBundleRefs(o.Body, func(ref string) (string, bool) {
+		p, ok := bundleRefPath(base, ref)
+		if !ok {
+			return "", false
+		}
+		u, ok := refs[p]
+		return u, ok && u != ""
+	})
+	if err := a.compileTplBundle(&o); err != nil {
+		return err
+	}
+
+	// Create the template in the DB.
+	out, err := a.core.CreateTemplate(o.Name, o.Type, o.Subject, []byte(o.Body), o.BodySource, o.Lang, o.ParentTemplateID)
+	if err != nil {
+		return err
+	}
+	done = true
+
+	if o.Type == models.TemplateTypeTx {
+		a.manager.CacheTpl(out.ID, &o)
+	}
+
+	return c.JSON(http.StatusOK, okResp{tplBundleResp{Template: out, Assets: assets}})
+}
+
+// compileTplBundle compiles a template imported from a bundle to validate it.
+func (a *App) compileTplBundle(o *models.Template) error {
+	funcs := a.manager.GenericTemplateFuncs()
+	if o.Type == models.TemplateTypeCampaign {
+		o.Subject = ""
+		funcs = a.manager.TemplateFuncs(nil)
+	}
+
+	if err := o.Compile(funcs); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return nil
+}
+
+// readTplBundle reads the files in a template bundle (zip) and returns them by
+// their paths along with the path of the index.html file. Bundles with unsafe paths
+// (eg: ../) or that are too big when uncompressed are rejected. If index.html isn't
+// at the root of the bundle, the one closest to the root is picked.
+func (a *App) readTplBundle(src io.ReaderAt, size int64) (map[string][]byte, string, error) {
+	z, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, "", echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("media.invalidFile", "error", err.Error()))
+	}
+
+	var (
+		files = map[string][]byte{}
+		index = ""
+		total int64
+	)
+	for _, f := range z.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		// Reject absolute paths and paths that escape the bundle.
+		name := f.Name
+		if strings.Contains(name, `\`) || path.IsAbs(name) || !filepath.IsLocal(name) {
+			return nil, "", echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("templates.bundleUnsafeFile", "name", name))
+		}
+		name = path.Clean(name)
+
+		// Skip metadata added by macOS archivers.
+		if strings.HasPrefix(name, "__MACOSX/") {
+			continue
+		}
+
+		if len(files) >= maxTplBundleFiles {
+			return nil, "", echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("templates.bundleTooManyFiles", "max", strconv.Itoa(maxTplBundleFiles)))
+		}
+
+		// The declared sizes can't be trusted, so the actual sizes are capped too.
+		total += int64(f.UncompressedSize64)
+		if total > maxTplBundleExtractedSize {
+			return nil, "", echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("templates.bundleTooBig", "max", strconv.Itoa(maxTplBundleExtractedSize/1024/1024)))
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, "", echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("media.errorReadingFile", "error", err.Error()))
+		}
+		b, err := io.ReadAll(io.LimitReader(rc, int64(f.UncompressedSize64)+1))
+		rc.Close()
+		if err != nil {
+			return nil, "", echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("media.errorReadingFile", "error", err.Error()))
+		}
+		if uint64(len(b)) > f.UncompressedSize64 {
+			return nil, "", echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("templates.bundleTooBig", "max", strconv.Itoa(maxTplBundleExtractedSize/1024/1024)))
+		}
+		files[name] = b
+
+		if strings.EqualFold(path.Base(name), tplBundleIndex) {
+			if index == "" || strings.Count(name, "/") < strings.Count(index, "/") {
+				index = name
+			}
+		}
+	}
+
+	if index == "" {
+		return nil, "", echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.bundleNoIndex", "name", tplBundleIndex))
+	}
+
+	return files, index, nil
+}
+
+// validateTplBundleAsset checks whether a file referenced in a template bundle is
+// an image that can be uploaded as media. Other files, eg: scripts, are rejected.
+func (a *App) validateTplBundleAsset(name string, b []byte) error {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+
+	// SVGs aren't accepted as they can carry scripts.
+	if !inArray(ext, imageExts) || !strings.HasPrefix(http.DetectContentType(b), "image/") {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.bundleUnsafeFile", "name", name))
+	}
+	if !inArray("*", a.cfg.MediaUpload.Extensions) && !inArray(ext, a.cfg.MediaUpload.Extensions) {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("media.unsupportedFileType", "type", ext))
+	}
+
+	return nil
+}
+
+// uploadTplBundleAsset uploads an image from a template bundle to the media store
+// and returns the media. If a file with the same checksum is already in the media
+// library, it's returned instead.
+func (a *App) uploadTplBundleAsset(ctx context.Context, store media.Store, name string, b []byte) (media.Media, bool, error) {
+	sum := sha256.Sum256(b)
+	checksum := hex.EncodeToString(sum[:])
+
+	if m, err := a.core.GetMediaByChecksum(a.cfg.MediaUpload.Provider, checksum, a.media); err == nil {
+		return m, true, nil
+	} else if err != core.ErrNotFound {
+		return media.Media{}, false, err
+	}
+
+	// If the filename already exists in the DB, make it unique by adding a random suffix.
+	fName := makeFilename(path.Base(name))
+	if _, err := a.core.GetMedia(0, "", fName, a.media); err == nil {
+		suffix, err := generateRandomString(6)
+		if err != nil {
+			a.log.Printf("error generating random string: %v", err)
+			return media.Media{}, false, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+		}
+
+		fName = appendSuffixToFilename(fName, suffix)
+	}
+
+	contentType := http.DetectContentType(b)
+	fName, err := store.Put(ctx, fName, contentType, bytes.NewReader(b))
+	if err != nil {
+		a.log.Printf("error uploading file: %v", err)
+		return media.Media{}, false, echo.NewHTTPError(mediaErrStatus(err),
+			a.i18n.Ts("media.errorUploading", "error", err.Error()))
+	}
+
+	m := media.Media{Filename: fName}
+	thumb, width, height, err := processImage(bytes.NewReader(b))
+	if err != nil {
+		a.deleteTplBundleMedia(store, m)
+		a.log.Printf("error resizing image: %v", err)
+		return media.Media{}, false, echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("media.errorResizing", "error", err.Error()))
+	}
+
+	// A missing thumbnail doesn't fail the import.
+	if tf, err := store.Put(ctx, thumbPrefix+fName, contentType, thumb); err != nil {
+		a.log.Printf("error saving thumbnail: %v", err)
+	} else {
+		m.Thumb = tf
+	}
+
+	meta := models.JSON{"width": width, "height": height}
+	out, err := a.core.InsertMedia(fName, m.Thumb, contentType, meta, checksum, a.cfg.MediaUpload.Provider, a.media)
+	if err != nil {
+		a.deleteTplBundleMedia(store, m)
+		return media.Media{}, false, err
+	}
+
+	return out, false, nil
+}
+
+// deleteTplBundleMedia deletes the files of a media item uploaded from a template
+// bundle and its DB record, if it was inserted.
+func (a *App) deleteTplBundleMedia(store media.Store, m media.Media) {
+	// The request context may have been cancelled.
+	if err := store.Delete(context.Background(), m.Filename); err != nil {
+		a.log.Printf("error cleaning up file %s: %v", m.Filename, err)
+	}
+	if m.Thumb != "" && m.Thumb != m.Filename {
+		if err := store.Delete(context.Background(), m.Thumb); err != nil {
+			a.log.Printf("error cleaning up thumbnail %s: %v", m.Thumb, err)
+		}
+	}
+
+	if m.ID > 0 {
+		if _, err := a.core.DeleteMedia(m.ID); err != nil {
+			a.log.Printf("error deleting media %d: %v", m.ID, err)
+		}
+	}
+}
+
+// rewriteBundleRefs calls fn with every file reference in the HTML of a
+// template bundle and replaces the reference with the returned value if it's ok.
+func rewriteBundleRefs(body string, fn func(ref string) (string, bool)) string {
+	for _, re := range regexpBundleRefs {
+		var (
+			out  strings.Builder
+			last = 0
+		)
+		for _, m := range re.FindAllStringSubmatchIndex(body, -1) {
+			start, end := m[2], m[3]
+			if v, ok := fn(body[start:end]); ok {
+				out.WriteString(body[last:start])
+				out.WriteString(v)
+				last = end
+			}
+		}
+		out.WriteString(body[last:])
+		body = out.String()
+	}
+
+	return body
+}
+
+// bundleRefPath returns the path of the file in a template bundle that a relative
+// reference in its HTML points to, given the directory of the HTML file. URLs,
+// absolute paths, anchors, and template expressions are skipped.
+func bundleRefPath(base, ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "#") ||
+		strings.Contains(ref, ":") || strings.Contains(ref, "{{") {
+		return "", false
+	}
+
+	// Drop the query and fragment.
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		ref = ref[:i]
+	}
+
+	p, err := url.PathUnescape(ref)
+	if err != nil {
+		return "", false
+	}
+
+	p = path.Join(base, p)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+
+	return p, true
+}
+
 // DeleteTemplate handles template deletion.
 func (a *App) DeleteTemplate(c echo.Context) error {
-	// Delete the template from the DB.
 	id := getID(c)
+	if err := a.checkLibraryTemplate(id); err != nil {
+		return err
+	}
+
+	// Delete the template from the DB.
 	if err := a.core.DeleteTemplate(id); err != nil {
 		return err
 	}
@@ -210,12 +870,32 @@ func (a *App) DeleteTemplate(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// checkLibraryTemplate returns an error if a template is a read-only template
+// in the template library.
+func (a *App) checkLibraryTemplate(id int) error {
+	tpl, err := a.core.GetTemplate(id, true)
+	if err != nil {
+		return err
+	}
+	if tpl.IsLibrary {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("templates.libraryReadOnly"))
+	}
+
+	return nil
+}
+
 // compileTemplate validates template fields.
 func (a *App) validateTemplate(o models.Template) error {
 	if !strHasLen(o.Name, 1, stdInputMaxLen) {
 		return errors.New(a.i18n.T("campaigns.fieldInvalidName"))
 	}
 
+	// Very large bodies are expensive to render for every subscriber.
+	if n := a.cfg.TemplateMaxBodyBytes; n > 0 && len(o.Body) > n {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+			a.i18n.Ts("templates.bodyTooLarge", "size", strconv.Itoa(len(o.Body)), "max", strconv.Itoa(n)))
+	}
+
 	if o.Type == models.TemplateTypeCampaign && !regexpTplTag.MatchString(o.Body) {
 		return echo.NewHTTPError(http.StatusBadRequest,
 			a.i18n.Ts("templates.placeholderHelp", "placeholder", tplTag))
@@ -226,12 +906,76 @@ func (a *App) validateTemplate(o models.Template) error {
 			a.i18n.Ts("globals.messages.missingFields", "name", "subject"))
 	}
 
+	// Flag references to subscriber attributes that aren't available to templates.
+	if keys := a.manager.ExcludedAttribs(o.Subject, o.Body); len(keys) > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.excludedAttribs", "keys", strings.Join(keys, ", ")))
+	}
+
+	return nil
+}
+
+// validateTemplateLang validates the language and the parent of a language variant
+// of a campaign template (id, or 0 for a new template) and normalizes the language.
+func (a *App) validateTemplateLang(id int, o *models.Template) error {
+	o.Lang.String = strings.TrimSpace(o.Lang.String)
+	o.Lang.Valid = o.Lang.String != ""
+	if !o.Lang.Valid && !o.ParentTemplateID.Valid {
+		return nil
+	}
+
+	if !o.Lang.Valid || !o.ParentTemplateID.Valid || o.Type != models.TemplateTypeCampaign {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("templates.invalidVariant"))
+	}
+
+	tag, err := language.Parse(o.Lang.String)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "lang"))
+	}
+	o.Lang = null.StringFrom(tag.String())
+
+	// The parent should be a regular campaign template that isn't a variant itself.
+	parentID := o.ParentTemplateID.Int
+	if parentID == id {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("templates.invalidVariant"))
+	}
+	parent, err := a.core.GetTemplate(parentID, true)
+	if err != nil {
+		return err
+	}
+	if parent.Type != models.TemplateTypeCampaign || parent.IsLibrary || parent.ParentTemplateID.Valid {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("templates.invalidVariant"))
+	}
+
+	// A template with variants of its own can't become a variant.
+	if id > 0 {
+		tpls, err := a.core.GetTemplates(models.TemplateTypeCampaign, true)
+		if err != nil {
+			return err
+		}
+		for _, t := range tpls {
+			if t.ParentTemplateID.Valid && t.ParentTemplateID.Int == id {
+				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("templates.invalidVariant"))
+			}
+		}
+	}
+
 	return nil
 }
 
 // previewTemplate renders the HTML preview of a template.
 func (a *App) previewTemplate(tpl models.Template) ([]byte, error) {
-	var out []byte
+	render, err := a.compileTemplatePreview(tpl)
+	if err != nil {
+		return nil, err
+	}
+
+	return render(dummySubscriber)
+}
+
+// compileTemplatePreview compiles a template for previewing with dummy campaign
+// content and returns a function that renders it for a given subscriber.
+func (a *App) compileTemplatePreview(tpl models.Template) (func(models.Subscriber) ([]byte, error), error) {
 	if tpl.Type == models.TemplateTypeCampaign || tpl.Type == models.TemplateTypeCampaignVisual {
 		camp := models.Campaign{
 			UUID:         dummyUUID,
@@ -247,29 +991,32 @@ func (a *App) previewTemplate(tpl models.Template) ([]byte, error) {
 				a.i18n.Ts("templates.errorCompiling", "error", err.Error()))
 		}
 
-		// Render the message body.
-		msg, err := a.manager.NewCampaignMessage(&camp, dummySubscriber)
-		if err != nil {
-			return nil, echo.NewHTTPError(http.StatusBadRequest,
-				a.i18n.Ts("templates.errorRendering", "error", err.Error()))
-		}
-		out = msg.Body()
-	} else {
-		// Compile transactional template.
-		if err := tpl.Compile(a.manager.GenericTemplateFuncs()); err != nil {
-			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
-		}
+		return func(sub models.Subscriber) ([]byte, error) {
+			// Render the message body.
+			msg, err := a.manager.NewCampaignMessage(&camp, sub)
+			if err != nil {
+				return nil, echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("templates.errorRendering", "error", err.Error()))
+			}
+			return msg.Body(), nil
+		}, nil
+	}
+
+	// Compile transactional template.
+	if err := tpl.Compile(a.manager.GenericTemplateFuncs()); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 
+	return func(sub models.Subscriber) ([]byte, error) {
 		m := models.TxMessage{
 			Subject: tpl.Subject,
 		}
 
-		// Render the message.
-		if err := m.Render(dummySubscriber, &tpl, a.manager.GenericTemplateFuncs()); err != nil {
+		// Render the message with only the attributes available to templates.
+		sub.Attribs = a.manager.TemplateAttribs().Apply(sub.Attribs)
+		if err := m.Render(sub, &tpl, a.manager.GenericTemplateFuncs()); err != nil {
 			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
-		out = m.Body
-	}
-
-	return out, nil
+		return m.Body, nil
+	}, nil
 }
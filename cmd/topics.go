@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// GetTopics handles retrieval of topics.
+func (a *App) GetTopics(c echo.Context) error {
+	out, err := a.core.GetTopics()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetTopic handles retrieval of a topic.
+func (a *App) GetTopic(c echo.Context) error {
+	out, err := a.core.GetTopic(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateTopic handles topic creation.
+func (a *App) CreateTopic(c echo.Context) error {
+	var t models.Topic
+	if err := c.Bind(&t); err != nil {
+		return err
+	}
+	if err := a.validateTopic(t); err != nil {
+		return err
+	}
+
+	out, err := a.core.CreateTopic(t)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateTopic handles topic modification.
+func (a *App) UpdateTopic(c echo.Context) error {
+	var t models.Topic
+	if err := c.Bind(&t); err != nil {
+		return err
+	}
+	if err := a.validateTopic(t); err != nil {
+		return err
+	}
+
+	out, err := a.core.UpdateTopic(getID(c), t)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteTopic handles topic deletion.
+func (a *App) DeleteTopic(c echo.Context) error {
+	if err := a.core.DeleteTopic(getID(c)); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// validateTopic validates topic fields.
+func (a *App) validateTopic(t models.Topic) error {
+	if !strHasLen(t.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("topics.invalidName"))
+	}
+	if len(t.Description) > 2000 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "description"))
+	}
+
+	return nil
+}
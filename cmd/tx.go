@@ -128,8 +128,10 @@ func (a *App) SendTxMessage(c echo.Context) error {
 			}
 		}
 
-		// Render the message.
-		if err := m.Render(sub, tpl, a.manager.GenericTemplateFuncs()); err != nil {
+		// Render the message with only the attributes available to templates.
+		tplSub := sub
+		tplSub.Attribs = a.manager.TemplateAttribs().Apply(sub.Attribs)
+		if err := m.Render(tplSub, tpl, a.manager.GenericTemplateFuncs()); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest,
 				a.i18n.Ts("templates.errorRendering", "error", err.Error()))
 		}
@@ -164,6 +166,11 @@ func (a *App) SendTxMessage(c echo.Context) error {
 			}
 		}
 
+		// Journal a copy of the message as an envelope recipient.
+		if j := a.cfg.Privacy.Journal; j.Enabled && j.Tx && j.Address != "" {
+			msg.Bcc = []string{j.Address}
+		}
+
 		if err := a.manager.PushMessage(msg); err != nil {
 			a.log.Printf("error sending message (%s): %v", msg.Subject, err)
 			return err
@@ -3,12 +3,15 @@ package main
 import (
 	"crypto/rand"
 	"fmt"
+	"net/mail"
 	"net/url"
 	"path/filepath"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
 var (
@@ -112,3 +115,146 @@ func getQueryInts(param string, qp url.Values) ([]int, error) {
 
 	return out, nil
 }
+
+// parseEmailList splits the comma separated entries in a list of e-mail addresses
+// (optionally with display names), validates the addresses, and removes duplicates.
+// If there's an invalid entry, it's returned along with false.
+func parseEmailList(in []string) ([]string, string, bool) {
+	var (
+		out  = make([]string, 0, len(in))
+		seen = make(map[string]bool, len(in))
+	)
+	for _, s := range in {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		addrs, err := mail.ParseAddressList(s)
+		if err != nil {
+			return nil, s, false
+		}
+
+		for _, a := range addrs {
+			key := strings.ToLower(a.Address)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if a.Name == "" {
+				out = append(out, a.Address)
+			} else {
+				out = append(out, a.String())
+			}
+		}
+	}
+
+	return out, "", true
+}
+
+// makeUnifiedDiff returns a line-wise unified diff of two texts with three lines
+// of context around the changes. It's empty if the texts are identical.
+func makeUnifiedDiff(from, to, fromName, toName string) string {
+	const numCtx = 3
+
+	dmp := diffmatchpatch.New()
+	a, b, lines := dmp.DiffLinesToChars(from, to)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(a, b, false), lines)
+
+	// Flatten the diffs into individual lines prefixed with the unified diff op.
+	type diffLine struct {
+		op   byte
+		text string
+	}
+	var out []diffLine
+	for _, d := range diffs {
+		op := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			op = '+'
+		case diffmatchpatch.DiffDelete:
+			op = '-'
+		}
+
+		for _, l := range strings.SplitAfter(d.Text, "\n") {
+			if l != "" {
+				out = append(out, diffLine{op, l})
+			}
+		}
+	}
+
+	// Number of lines of the old and new texts preceding every line.
+	var (
+		nOld = make([]int, len(out)+1)
+		nNew = make([]int, len(out)+1)
+	)
+	for i, l := range out {
+		nOld[i+1], nNew[i+1] = nOld[i], nNew[i]
+		if l.op != '+' {
+			nOld[i+1]++
+		}
+		if l.op != '-' {
+			nNew[i+1]++
+		}
+	}
+
+	var (
+		sb strings.Builder
+		i  = 0
+	)
+	for {
+		// Skip to the next change.
+		for i < len(out) && out[i].op == ' ' {
+			i++
+		}
+		if i == len(out) {
+			break
+		}
+
+		// Extend the hunk over the changes that are within the context of each other.
+		start, end := max(i-numCtx, 0), i
+		for end < len(out) {
+			if out[end].op != ' ' {
+				end++
+				continue
+			}
+
+			j := end
+			for j < len(out) && out[j].op == ' ' {
+				j++
+			}
+			if j == len(out) || j-end > numCtx*2 {
+				end = min(end+numCtx, len(out))
+				break
+			}
+			end = j
+		}
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+
+		oldStart, oldLen := nOld[start], nOld[end]-nOld[start]
+		newStart, newLen := nNew[start], nNew[end]-nNew[start]
+		if oldLen > 0 {
+			oldStart++
+		}
+		if newLen > 0 {
+			newStart++
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldLen, newStart, newLen)
+
+		for _, l := range out[start:end] {
+			sb.WriteByte(l.op)
+			sb.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		i = end
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Duration for which the rendered UTM params of a campaign are cached
+// for link redirects.
+const utmCacheTTL = time.Minute * 5
+
+// utmCache caches the rendered UTM params of campaigns by their UUIDs.
+type utmCache struct {
+	items map[string]utmCacheItem
+	sync.Mutex
+}
+
+type utmCacheItem struct {
+	params  models.UTMParams
+	expires time.Time
+}
+
+// getCampaignUTMParams returns the rendered UTM params of a campaign. Campaigns
+// without a UTM template of their own use the global default template.
+func (a *App) getCampaignUTMParams(campUUID string) models.UTMParams {
+	a.utm.Lock()
+	defer a.utm.Unlock()
+
+	now := time.Now()
+	if it, ok := a.utm.items[campUUID]; ok && now.Before(it.expires) {
+		return it.params
+	}
+
+	params := a.renderCampaignUTMParams(campUUID)
+
+	// Drop the expired items before caching the new one.
+	if a.utm.items == nil {
+		a.utm.items = make(map[string]utmCacheItem)
+	}
+	for k, it := range a.utm.items {
+		if now.After(it.expires) {
+			delete(a.utm.items, k)
+		}
+	}
+	a.utm.items[campUUID] = utmCacheItem{params: params, expires: now.Add(utmCacheTTL)}
+
+	return params
+}
+
+// renderCampaignUTMParams renders the UTM template of a campaign. Errors are
+// logged and result in no params as they shouldn't break link redirects.
+func (a *App) renderCampaignUTMParams(campUUID string) models.UTMParams {
+	camp, err := a.core.GetCampaign(0, campUUID, "")
+	if err != nil {
+		return nil
+	}
+
+	tpl := camp.UTMTemplate
+	if tpl == nil {
+		tpl = a.cfg.UTMTemplate
+	}
+	if len(tpl) == 0 {
+		return nil
+	}
+
+	data := models.UTMTemplateData{Campaign: &camp}
+	var lists []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(camp.Lists, &lists); err == nil && len(lists) > 0 {
+		data.List.ID = lists[0].ID
+		data.List.Name = lists[0].Name
+	}
+
+	params, err := tpl.Render(a.manager.TemplateFuncs(&camp), data)
+	if err != nil {
+		a.log.Printf("error rendering UTM template of campaign (%s): %v", camp.Name, err)
+		return nil
+	}
+
+	return params
+}
+
+// addUTMParams adds UTM params to an http(s) URL. Params that are already
+// in the URL are left as is, as are links to listmonk's own pages.
+func (a *App) addUTMParams(link string, params models.UTMParams) string {
+	if len(params) == 0 || strings.HasPrefix(link, a.urlCfg.RootURL+"/") {
+		return link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return link
+	}
+
+	var (
+		existing = u.Query()
+		add      = url.Values{}
+	)
+	for k, v := range params {
+		if _, ok := existing[k]; !ok {
+			add.Set(k, v)
+		}
+	}
+	if len(add) == 0 {
+		return link
+	}
+
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += add.Encode()
+
+	return u.String()
+}
@@ -32,6 +32,11 @@ http.interceptors.response.use((resp) => {
     store.commit('setLoading', { model: resp.config.loading, status: false });
   }
 
+  // Files are returned as is.
+  if (resp.config.responseType === 'blob') {
+    return resp.data;
+  }
+
   let data = {};
   if (typeof resp.data.data === 'object') {
     if (resp.data.data.constructor === Object) {
@@ -103,14 +108,19 @@ export const getHealth = () => http.get(
 export const reloadApp = () => http.post('/api/admin/reload');
 
 // Dashboard
-export const getDashboardCounts = () => http.get(
+export const getDashboardCounts = (params) => http.get(
   '/api/dashboard/counts',
-  { loading: models.dashboard },
+  { params, loading: models.dashboard },
 );
 
-export const getDashboardCharts = () => http.get(
+export const getDashboardCharts = (params) => http.get(
   '/api/dashboard/charts',
-  { loading: models.dashboard },
+  { params, loading: models.dashboard },
+);
+
+export const getDashboardCalendar = (params) => http.get(
+  '/api/dashboard/calendar',
+  { params, loading: models.dashboard },
 );
 
 // Lists.
@@ -136,6 +146,11 @@ export const getList = async (id) => http.get(
   { loading: models.list },
 );
 
+export const getListsOverlap = async (ids) => http.get(
+  '/api/lists/overlap',
+  { params: { ids: ids.join(',') }, loading: models.lists },
+);
+
 export const createList = (data) => http.post(
   '/api/lists',
   data,
@@ -148,6 +163,18 @@ export const updateList = (data) => http.put(
   { loading: models.lists },
 );
 
+export const evaluateListRules = (id) => http.post(
+  `/api/lists/${id}/rules/evaluate`,
+  {},
+  { loading: models.lists },
+);
+
+export const mergeList = (id, targetListId) => http.post(
+  `/api/lists/${id}/merge`,
+  { target_list_id: targetListId },
+  { loading: models.lists },
+);
+
 export const deleteList = (id) => http.delete(
   `/api/lists/${id}`,
   { loading: models.lists },
@@ -179,6 +206,31 @@ export const getSubscriberActivity = async (id) => http.get(
   { loading: models.subscribers },
 );
 
+export const getSubscriberSends = async (id, params) => http.get(
+  `/api/subscribers/${id}/sends`,
+  { params, loading: models.subscribers },
+);
+
+export const getSubscriberConsents = async (id, params) => http.get(
+  `/api/subscribers/${id}/consents`,
+  { params, loading: models.subscribers },
+);
+
+// Attribute keys in the changes are left as-is.
+export const getSubscriberAttribHistory = async (id, params) => http.get(
+  `/api/subscribers/${id}/attrib_history`,
+  {
+    params,
+    loading: models.subscribers,
+    camelCase: (keyPath) => !keyPath.match(/^\.results\.\*\.(old|new)_value\./),
+  },
+);
+
+export const getSubscriberAttribStats = async (params) => http.get(
+  '/api/subscribers/attrib_stats',
+  { params, loading: models.subscribers },
+);
+
 export const getSubscriberBounces = async (id) => http.get(
   `/api/subscribers/${id}/bounces`,
   { loading: models.bounces },
@@ -227,6 +279,12 @@ export const deleteSubscriber = (id) => http.delete(
   { loading: models.subscribers },
 );
 
+export const anonymizeSubscriber = (id) => http.post(
+  `/api/subscribers/${id}/anonymize`,
+  {},
+  { loading: models.subscribers },
+);
+
 export const addSubscribersToLists = (data) => http.put(
   '/api/subscribers/lists',
   data,
@@ -264,7 +322,13 @@ export const deleteSubscribersByQuery = (data) => http.post(
 );
 
 // Subscriber import.
-export const importSubscribers = (data) => http.post('/api/import/subscribers', data);
+export const importSubscribers = (data, params) => http.post('/api/import/subscribers', data, { params });
+
+export const importGoogleSheet = (data) => http.post('/api/subscribers/import/google_sheets', data,
+  { camelCase: false });
+
+export const previewImport = (data) => http.post('/api/subscribers/import/preview', data,
+  { camelCase: false });
 
 export const getImportStatus = () => http.get('/api/import/subscribers');
 
@@ -281,19 +345,40 @@ export const getBounces = async (params) => http.get(
   { params, loading: models.bounces },
 );
 
+export const getBounceReasons = async (params) => http.get(
+  '/api/bounces/reasons',
+  { params, loading: models.bounces },
+);
+
+export const getBounceDomains = async (params) => http.get(
+  '/api/analytics/bounce_domains',
+  { params, loading: models.bounces },
+);
+
+export const getBounceMailbox = async () => http.get(
+  '/api/bounces/mailbox',
+  { loading: models.bounces },
+);
+
 // Campaigns.
 export const getCampaigns = async (params) => http.get('/api/campaigns', {
   params,
   loading: models.campaigns,
   store: models.campaigns,
-  camelCase: (keyPath) => !keyPath.startsWith('.results.*.headers'),
+  camelCase: (keyPath) => !keyPath.startsWith('.results.*.headers')
+    && !keyPath.startsWith('.results.*.segment_params.'),
 });
 
 export const getCampaign = async (id) => http.get(`/api/campaigns/${id}`, {
   loading: models.campaigns,
-  camelCase: (keyPath) => !keyPath.startsWith('.headers'),
+  camelCase: (keyPath) => !keyPath.startsWith('.headers') && !keyPath.startsWith('.segment_params.'),
 });
 
+export const getCampaignAudience = async (id, params) => http.get(
+  `/api/campaigns/${id}/audience`,
+  { params },
+);
+
 export const getCampaignStats = async () => http.get('/api/campaigns/running/stats', {});
 
 export const createCampaign = async (data) => http.post(
@@ -317,33 +402,161 @@ export const getCampaignBounceCounts = async (params) => http.get(
   { params, loading: models.campaigns },
 );
 
+export const getCampaignBounceReasonCounts = async (params) => http.get(
+  '/api/campaigns/analytics/bounce_reasons',
+  { params, loading: models.campaigns },
+);
+
+export const getCampaignBounceTypeCounts = async (params) => http.get(
+  '/api/campaigns/analytics/bounce_types',
+  { params, loading: models.campaigns },
+);
+
+export const getCampaignBounces = async (id, params) => http.get(
+  `/api/campaigns/${id}/bounces`,
+  { params, loading: models.bounces },
+);
+
+export const getCampaignEvents = async (id, type, params) => http.get(
+  `/api/campaigns/${id}/events/${type}`,
+  { params, loading: models.campaigns },
+);
+
 export const getCampaignLinkCounts = async (params) => http.get(
   '/api/campaigns/analytics/links',
   { params, loading: models.campaigns },
 );
 
+export const getEngagementHeatmap = async (params) => http.get(
+  '/api/analytics/engagement-heatmap',
+  { params, loading: models.campaigns },
+);
+
+export const getSendFrequency = async (params) => http.get(
+  '/api/analytics/send_frequency',
+  { params, loading: models.campaigns },
+);
+
+export const getSubscriberCohorts = async (params) => http.get(
+  '/api/analytics/cohorts',
+  { params, loading: models.subscribers },
+);
+
+export const getDisengagedSubscribersReport = async (params) => http.get(
+  '/api/reports/disengaged_subscribers',
+  { params, loading: models.subscribers },
+);
+
+export const getEngagementFunnelReport = async (params) => http.get(
+  '/api/reports/engagement_funnel',
+  { params, loading: models.campaigns },
+);
+
+export const getSubscriberGrowthReport = async (params) => http.get(
+  '/api/reports/subscriber_growth',
+  { params, loading: models.subscribers },
+);
+
 export const convertCampaignContent = async (data) => http.post(
   `/api/campaigns/${data.id}/content`,
   data,
   { loading: models.campaigns },
 );
 
+export const convertCampaign = async (id, data) => http.post(
+  `/api/campaigns/${id}/convert`,
+  data,
+  { loading: models.campaigns },
+);
+
 export const testCampaign = async (data) => http.post(
   `/api/campaigns/${data.id}/test`,
   data,
   { loading: models.campaigns },
 );
 
-export const updateCampaign = async (id, data) => http.put(
+export const spellCheckCampaign = async (id, data, lang) => http.post(
+  `/api/campaigns/${id}/spellcheck`,
+  data,
+  { params: { lang }, loading: models.campaigns },
+);
+
+// The content is posted as a form, like campaign previews.
+export const validateCampaign = async (id, data) => http.post(
+  `/api/campaigns/${id}/validate`,
+  new URLSearchParams(data),
+  { loading: models.campaigns },
+);
+
+export const getCampaignChecklist = async (id) => http.get(
+  `/api/campaigns/${id}/checklist`,
+  { loading: models.campaigns },
+);
+
+export const checkCampaignAccessibility = async (id, data) => http.post(
+  `/api/campaigns/${id}/accessibility_check`,
+  new URLSearchParams(data),
+  { loading: models.campaigns },
+);
+
+export const updateCampaign = async (id, data, params) => http.put(
   `/api/campaigns/${id}`,
   data,
+  { params, loading: models.campaigns },
+);
+
+// Content revisions of a campaign with their views and clicks.
+export const getCampaignRevisions = async (id) => http.get(
+  `/api/campaigns/${id}/revisions`,
   { loading: models.campaigns },
 );
 
-export const changeCampaignStatus = async (id, status) => http.put(
+export const getCampaignTemplateDiff = async (id) => http.get(
+  `/api/campaigns/${id}/template_diff`,
+  { loading: models.campaigns },
+);
+
+export const retryCampaignSendFailures = async (id) => http.post(
+  `/api/campaigns/${id}/retry_failures`,
+  {},
+  { loading: models.campaigns },
+);
+
+export const sendCampaignTestCohort = async (id, data) => http.post(
+  `/api/campaigns/${id}/test_send`,
+  data,
+  { loading: models.campaigns },
+);
+
+export const getCampaignPerformancePrediction = async (id, params) => http.get(
+  `/api/campaigns/${id}/performance_prediction`,
+  { params, loading: models.campaigns },
+);
+
+// If campaign start confirmation is enabled, starting a campaign returns a
+// confirmation token that has to be sent back to actually start it.
+export const overrideCampaignGate = async (id) => http.put(
+  `/api/campaigns/${id}/gate/override`,
+  {},
+  { loading: models.campaigns },
+);
+
+export const cancelCampaignAndReport = async (id) => http.post(
+  `/api/campaigns/${id}/cancel_and_report`,
+  {},
+  { loading: models.campaigns, camelCase: false },
+);
+
+export const changeCampaignStatus = async (id, status, token) => http.put(
   `/api/campaigns/${id}/status`,
-  { status },
+  { status, confirmation_token: token },
+
+  { loading: models.campaigns },
+);
 
+export const changeCampaignsStatus = async (ids, status) => http.post(
+  '/api/campaigns/batch_status',
+  { ids, status },
   { loading: models.campaigns },
 );
 
@@ -353,6 +566,28 @@ export const updateCampaignArchive = async (id, data) => http.put(
   { loading: models.campaigns },
 );
 
+export const updateCampaignSurvey = async (id, questions) => http.post(
+  `/api/campaigns/${id}/survey`,
+  { questions },
+  { loading: models.campaigns },
+);
+
+export const getCampaignSurveyResults = async (id) => http.get(
+  `/api/campaigns/${id}/survey/results`,
+  { loading: models.campaigns },
+);
+
+export const touchCampaign = async (id) => http.post(
+  `/api/campaigns/${id}/touch`,
+  null,
+  { disableToast: true },
+);
+
+export const releaseCampaign = async (id) => http.delete(
+  `/api/campaigns/${id}/touch`,
+  { disableToast: true },
+);
+
 export const deleteCampaign = async (id) => http.delete(
   `/api/campaigns/${id}`,
   { loading: models.campaigns },
@@ -375,11 +610,85 @@ export const uploadMedia = (data) => http.post(
   { loading: models.media },
 );
 
+export const uploadMediaBase64 = (data) => http.post(
+  '/api/media/base64',
+  data,
+  { loading: models.media },
+);
+
+export const downloadMediaArchive = (data) => http.post(
+  '/api/media/download_archive',
+  data,
+  { loading: models.media, responseType: 'blob' },
+);
+
 export const deleteMedia = (id) => http.delete(
   `/api/media/${id}`,
   { loading: models.media },
 );
 
+// Topics.
+export const getTopics = async () => http.get(
+  '/api/topics',
+  { loading: models.topics, store: models.topics },
+);
+
+export const createTopic = async (data) => http.post(
+  '/api/topics',
+  data,
+  { loading: models.topics },
+);
+
+export const updateTopic = async (data) => http.put(
+  `/api/topics/${data.id}`,
+  data,
+  { loading: models.topics },
+);
+
+export const deleteTopic = async (id) => http.delete(
+  `/api/topics/${id}`,
+  { loading: models.topics },
+);
+
+// Segments.
+export const getSegments = async () => http.get(
+  '/api/segments',
+  { loading: models.segments, store: models.segments },
+);
+
+export const createSegment = async (data) => http.post(
+  '/api/segments',
+  data,
+  { loading: models.segments },
+);
+
+export const updateSegment = async (data) => http.put(
+  `/api/segments/${data.id}`,
+  data,
+  { loading: models.segments },
+);
+
+export const approveSegment = async (id, approved) => http.put(
+  `/api/segments/${id}/approve`,
+  { approved },
+  { loading: models.segments },
+);
+
+export const deleteSegment = async (id) => http.delete(
+  `/api/segments/${id}`,
+  { loading: models.segments },
+);
+
+export const querySegment = async (id, data, params) => http.post(
+  `/api/segments/${id}/query`,
+  data,
+  {
+    params,
+    loading: models.segments,
+    camelCase: (keyPath) => !keyPath.startsWith('.results.*.attribs'),
+  },
+);
+
 // Templates.
 export const createTemplate = async (data) => http.post(
   '/api/templates',
@@ -387,11 +696,28 @@ export const createTemplate = async (data) => http.post(
   { loading: models.templates },
 );
 
+export const importTemplateBundle = async (data) => http.post(
+  '/api/templates/import-bundle',
+  data,
+  { loading: models.templates },
+);
+
 export const getTemplates = async () => http.get(
   '/api/templates',
   { loading: models.templates, store: models.templates },
 );
 
+export const getLibraryTemplates = async () => http.get(
+  '/api/templates/library',
+  { loading: models.templates },
+);
+
+export const cloneLibraryTemplate = async (id, data) => http.post(
+  `/api/templates/library/${id}/clone`,
+  data,
+  { loading: models.templates },
+);
+
 export const getTemplate = async (id) => http.get(
   `/api/templates/${id}`,
   { loading: models.templates },
@@ -403,12 +729,29 @@ export const updateTemplate = async (data) => http.put(
   { loading: models.templates },
 );
 
+export const lintTemplate = async (id, data) => http.post(
+  `/api/templates/${id}/lint`,
+  data,
+  { loading: models.templates, camelCase: false },
+);
+
 export const makeTemplateDefault = async (id) => http.put(
   `/api/templates/${id}/default`,
   {},
   { loading: models.templates },
 );
 
+export const touchTemplate = async (id) => http.post(
+  `/api/templates/${id}/touch`,
+  null,
+  { disableToast: true },
+);
+
+export const releaseTemplate = async (id) => http.delete(
+  `/api/templates/${id}/touch`,
+  { disableToast: true },
+);
+
 export const deleteTemplate = async (id) => http.delete(
   `/api/templates/${id}`,
   { loading: models.templates },
@@ -431,6 +774,12 @@ export const updateSettings = async (data) => http.put(
   { loading: models.settings },
 );
 
+export const importSettings = async (data) => http.post(
+  '/api/settings/import',
+  data,
+  { loading: models.settings },
+);
+
 export const updateSettingsByKey = async (key, data) => http.put(
   `/api/settings/${key}`,
   data,
@@ -448,6 +797,11 @@ export const getLogs = async () => http.get(
   { loading: models.logs, camelCase: false },
 );
 
+export const getNotifications = async (params) => http.get(
+  '/api/notifications',
+  { params, loading: models.notifications, camelCase: (keyPath) => !keyPath.startsWith('.results.*.data') },
+);
+
 export const getLang = async (lang) => http.get(
   `/api/lang/${lang}`,
   { loading: models.lang, camelCase: false },
@@ -470,6 +824,17 @@ export const deleteGCSubscriptions = async (beforeDate) => http.delete(
   { loading: models.maintenance, params: { before_date: beforeDate } },
 );
 
+export const getBackups = async () => http.get(
+  '/api/maintenance/backup',
+  { loading: models.maintenance },
+);
+
+export const createBackup = async () => http.post(
+  '/api/maintenance/backup',
+  {},
+  { loading: models.maintenance },
+);
+
 // Users.
 export const getUsers = () => http.get(
   '/api/users',
@@ -11,6 +11,8 @@ export const models = Object.freeze({
   subscribers: 'subscribers',
   campaigns: 'campaigns',
   templates: 'templates',
+  topics: 'topics',
+  segments: 'segments',
   media: 'media',
   bounces: 'bounces',
   users: 'users',
@@ -19,6 +21,7 @@ export const models = Object.freeze({
   listRoles: 'listRoles',
   settings: 'settings',
   logs: 'logs',
+  notifications: 'notifications',
   maintenance: 'maintenance',
 });
 
@@ -29,9 +32,13 @@ const baseURL = import.meta.env.BASE_URL.replace(/\/$/, '');
 export const uris = Object.freeze({
   previewCampaign: '/api/campaigns/:id/preview',
   previewCampaignArchive: '/api/campaigns/:id/preview/archive',
+  previewCampaignMarkdown: '/api/campaigns/:id/preview/markdown',
+  previewCampaignOutlook: '/api/campaigns/:id/outlook_preview',
+  previewSubscriberCampaign: '/api/subscribers/:subID/campaign_preview/:id',
   previewTemplate: '/api/templates/:id/preview',
   previewRawTemplate: '/api/templates/preview',
   exportSubscribers: '/api/subscribers/export',
+  quarantinedBounce: '/api/bounces/mailbox/quarantine/:uid',
   errorEvents: '/api/events?type=error',
   base: `${baseURL}/static`,
   root: rootURL,
@@ -51,3 +58,8 @@ export const colors = Object.freeze({
 });
 
 export const regDuration = '[0-9]+(ms|s|m|h|d)';
+
+// Normalized bounce reasons.
+export const bounceReasons = Object.freeze([
+  'invalid_recipient', 'mailbox_full', 'content_rejected', 'reputation_block', 'dmarc_policy', 'other',
+]);
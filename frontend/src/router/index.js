@@ -29,6 +29,12 @@ const routes = [
     meta: { title: 'forms.title', group: 'lists' },
     component: () => import('../views/Forms.vue'),
   },
+  {
+    path: '/lists/topics',
+    name: 'topics',
+    meta: { title: 'globals.terms.topics', group: 'lists' },
+    component: () => import('../views/Topics.vue'),
+  },
   {
     path: '/lists/:id',
     name: 'list',
@@ -47,6 +53,12 @@ const routes = [
     meta: { title: 'import.title', group: 'subscribers' },
     component: () => import('../views/Import.vue'),
   },
+  {
+    path: '/subscribers/segments',
+    name: 'segments',
+    meta: { title: 'globals.terms.segments', group: 'subscribers' },
+    component: () => import('../views/Segments.vue'),
+  },
   {
     path: '/subscribers/bounces',
     name: 'bounces',
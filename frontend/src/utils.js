@@ -122,6 +122,20 @@ export default class Utils {
     return this.intlNumFormat.format(v);
   }
 
+  // Formats a size in bytes to a human readable string (eg: 1.2 MB).
+  formatBytes = (n) => {
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+
+    let v = n || 0;
+    let i = 0;
+    while (v >= 1024 && i < units.length - 1) {
+      v /= 1024;
+      i += 1;
+    }
+
+    return `${i === 0 ? v : v.toFixed(1)} ${units[i]}`;
+  };
+
   // Parse one or more numeric ids as query params and return as an array of ints.
   parseQueryIDs = (ids) => {
     if (!ids) {
@@ -156,6 +170,40 @@ export default class Utils {
     });
   };
 
+  // Shows the snapshot of a campaign that's about to be started
+  // for a final confirmation.
+  confirmCampaignStart = (conf, onConfirm, onCancel) => {
+    const s = conf.snapshot;
+    const rows = [
+      [this.i18n.t('globals.fields.name'), s.name],
+      [this.i18n.t('campaigns.subject'), s.subject],
+      [this.i18n.t('campaigns.fromAddress'), s.fromEmail],
+      [this.i18n.tc('globals.terms.messenger'), s.messenger],
+      [this.i18n.tc('globals.terms.lists', 2), (s.lists || []).map((l) => l.name).join(', ')],
+      [this.i18n.t('campaigns.audienceCount'), this.formatNumber(s.audienceCount)],
+    ];
+    if (s.sendAt) {
+      rows.push([this.i18n.t('campaigns.status.scheduled'), this.niceDate(s.sendAt, true)]);
+    }
+
+    let msg = rows.map((r) => `<p><strong>${this.escapeHTML(r[0])}</strong>: ${this.escapeHTML(String(r[1]))}</p>`).join('');
+    if (s.warnings.length > 0) {
+      msg += `<ul class="mt-4 has-text-danger">${s.warnings.map((w) => `<li>${this.escapeHTML(w)}</li>`).join('')}</ul>`;
+    }
+    msg += `<p class="mt-4 is-size-7">${this.escapeHTML(this.i18n.t('campaigns.startConfirmExpires', { time: this.niceDate(conf.expiresAt, true) }))}</p>`;
+
+    Dialog.confirm({
+      scroll: 'keep',
+      title: this.i18n.t('campaigns.startConfirmTitle'),
+      message: msg,
+      type: s.warnings.length > 0 ? 'is-danger' : 'is-primary',
+      confirmText: this.i18n.t('campaigns.start'),
+      cancelText: this.i18n.t('globals.buttons.cancel'),
+      onConfirm,
+      onCancel,
+    });
+  };
+
   prompt = (msg, inputAttrs, onConfirm, onCancel, params) => {
     const p = params || {};
 
@@ -0,0 +1,417 @@
+// Package a11y checks the HTML content of campaigns for common accessibility
+// issues: images without alt text, links without descriptive text, insufficient
+// colour contrast, and a missing document language. Each issue is reported
+// with the WCAG 2.1 success criterion and conformance level it fails.
+package a11y
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// WCAG 2.1 conformance levels.
+const (
+	LevelA   = "A"
+	LevelAA  = "AA"
+	LevelAAA = "AAA"
+)
+
+// Rules that are checked.
+const (
+	RuleImgAlt   = "img_alt"
+	RuleLinkText = "link_text"
+	RuleContrast = "contrast"
+	RuleHTMLLang = "html_lang"
+)
+
+const (
+	// maxSnippetLen is the max length of the element snippet in an issue.
+	maxSnippetLen = 120
+
+	// Default text and background colours assumed by mail clients.
+	defaultFG = "#000000"
+	defaultBG = "#ffffff"
+
+	// defaultFontSize is the default font size (px) of text.
+	defaultFontSize = 16.0
+)
+
+// Score penalties for issues of each level.
+var penalties = map[string]int{
+	LevelA:   10,
+	LevelAA:  5,
+	LevelAAA: 2,
+}
+
+// nonDescriptive is the list of link texts that don't describe the link's target.
+var nonDescriptive = map[string]struct{}{
+	"click":      {},
+	"click here": {},
+	"here":       {},
+	"link":       {},
+	"this link":  {},
+	"this":       {},
+	"more":       {},
+	"read more":  {},
+	"learn more": {},
+	"go":         {},
+	"continue":   {},
+}
+
+// Issue represents a single accessibility issue in the content.
+type Issue struct {
+	Rule      string `json:"rule"`
+	Criterion string `json:"criterion"`
+	Level     string `json:"level"`
+	Element   string `json:"element"`
+
+	// Ratio is the colour contrast ratio of the element (for contrast issues).
+	Ratio float64 `json:"ratio,omitempty"`
+}
+
+// Result is the result of an accessibility check. Score is a number between
+// 0 and 100 that decreases with the number and severity of the issues.
+type Result struct {
+	Score  int     `json:"score"`
+	Issues []Issue `json:"issues"`
+}
+
+// textStyle is the computed (inherited) text style of an element.
+type textStyle struct {
+	fg       rgb
+	bg       rgb
+	fontSize float64
+	bold     bool
+	hidden   bool
+}
+
+// Check parses the given HTML and checks it for accessibility issues.
+func Check(body string) (Result, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+
+	var issues []Issue
+
+	// The parser always produces an <html> element, with the attributes of
+	// the one in the source, if any.
+	if h := findElement(doc, atom.Html); h != nil && !hasLang(h) {
+		issues = append(issues, Issue{
+			Rule:      RuleHTMLLang,
+			Criterion: "3.1.1",
+			Level:     LevelA,
+			Element:   "<html>",
+		})
+	}
+
+	fg, _ := parseColor(defaultFG)
+	bg, _ := parseColor(defaultBG)
+	walk(doc, textStyle{fg: fg, bg: bg, fontSize: defaultFontSize}, &issues)
+
+	out := Result{Score: 100, Issues: issues}
+	if out.Issues == nil {
+		out.Issues = []Issue{}
+	}
+	for _, i := range out.Issues {
+		out.Score -= penalties[i.Level]
+	}
+	out.Score = max(out.Score, 0)
+
+	return out, nil
+}
+
+// walk recursively checks the element n and its children.
+func walk(n *html.Node, st textStyle, issues *[]Issue) {
+	if n.Type == html.ElementNode {
+		switch n.DataAtom {
+		case atom.Head, atom.Script, atom.Style, atom.Template:
+			return
+		case atom.Img:
+			if _, ok := attr(n, "alt"); !ok {
+				*issues = append(*issues, Issue{
+					Rule:      RuleImgAlt,
+					Criterion: "1.1.1",
+					Level:     LevelA,
+					Element:   snippet(n),
+				})
+			}
+		case atom.A:
+			if _, ok := attr(n, "href"); ok && !isDescriptive(linkText(n)) {
+				*issues = append(*issues, Issue{
+					Rule:      RuleLinkText,
+					Criterion: "2.4.4",
+					Level:     LevelA,
+					Element:   snippet(n),
+				})
+			}
+		}
+
+		st = computeStyle(n, st)
+		if !st.hidden && hasOwnText(n) {
+			if iss, ok := checkContrast(n, st); ok {
+				*issues = append(*issues, iss)
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, st, issues)
+	}
+}
+
+// checkContrast checks the contrast of the text in an element against its
+// background. Text below the AA minimum fails 1.4.3 and text below the AAA
+// minimum fails 1.4.6.
+func checkContrast(n *html.Node, st textStyle) (Issue, bool) {
+	var (
+		ratio       = contrastRatio(st.fg, st.bg)
+		large       = st.fontSize >= 24 || (st.bold && st.fontSize >= 18.66)
+		minAA, minA = 4.5, 7.0
+	)
+	if large {
+		minAA, minA = 3.0, 4.5
+	}
+
+	iss := Issue{
+		Rule:    RuleContrast,
+		Element: snippet(n),
+		Ratio:   roundRatio(ratio),
+	}
+	switch {
+	case ratio < minAA:
+		iss.Criterion = "1.4.3"
+		iss.Level = LevelAA
+	case ratio < minA:
+		iss.Criterion = "1.4.6"
+		iss.Level = LevelAAA
+	default:
+		return Issue{}, false
+	}
+
+	return iss, true
+}
+
+// computeStyle returns the style of an element by applying its presentational
+// attributes and inline styles to the style inherited from its parent.
+func computeStyle(n *html.Node, parent textStyle) textStyle {
+	st := parent
+
+	switch n.DataAtom {
+	case atom.B, atom.Strong, atom.Th:
+		st.bold = true
+	case atom.H1:
+		st.bold, st.fontSize = true, parent.fontSize*2
+	case atom.H2:
+		st.bold, st.fontSize = true, parent.fontSize*1.5
+	case atom.H3:
+		st.bold, st.fontSize = true, parent.fontSize*1.17
+	case atom.H4, atom.H5, atom.H6:
+		st.bold = true
+	}
+
+	// Legacy presentational attributes that are common in e-mail HTML.
+	if v, ok := attr(n, "bgcolor"); ok {
+		if c, ok := parseColor(v); ok {
+			st.bg = c
+		}
+	}
+	if n.DataAtom == atom.Font {
+		if v, ok := attr(n, "color"); ok {
+			if c, ok := parseColor(v); ok {
+				st.fg = c
+			}
+		}
+	}
+	if n.DataAtom == atom.Body {
+		if v, ok := attr(n, "text"); ok {
+			if c, ok := parseColor(v); ok {
+				st.fg = c
+			}
+		}
+	}
+
+	style, _ := attr(n, "style")
+	for _, decl := range strings.Split(style, ";") {
+		prop, val, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		prop = strings.ToLower(strings.TrimSpace(prop))
+		val = strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(val), "!important")))
+
+		switch prop {
+		case "color":
+			if c, ok := parseColor(val); ok {
+				st.fg = c
+			}
+		case "background-color":
+			if c, ok := parseColor(val); ok {
+				st.bg = c
+			}
+		case "background":
+			// Pick the first colour in the shorthand.
+			for _, v := range splitCSSValues(val) {
+				if c, ok := parseColor(v); ok {
+					st.bg = c
+					break
+				}
+			}
+		case "font-size":
+			if s, ok := parseFontSize(val, parent.fontSize); ok {
+				st.fontSize = s
+			}
+		case "font-weight":
+			w, _ := strconv.Atoi(val)
+			st.bold = val == "bold" || val == "bolder" || w >= 600
+		case "display":
+			st.hidden = st.hidden || val == "none"
+		case "visibility":
+			st.hidden = st.hidden || val == "hidden"
+		case "opacity":
+			st.hidden = st.hidden || val == "0"
+		}
+	}
+
+	if st.fontSize == 0 {
+		st.hidden = true
+	}
+
+	return st
+}
+
+// linkText returns the accessible text of a link: its text content, the alt
+// text of images within it, or its aria-label or title.
+func linkText(n *html.Node) string {
+	if v, ok := attr(n, "aria-label"); ok && strings.TrimSpace(v) != "" {
+		return v
+	}
+
+	var b strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+			b.WriteString(" ")
+		case n.Type == html.ElementNode && n.DataAtom == atom.Img:
+			v, _ := attr(n, "alt")
+			b.WriteString(v)
+			b.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+
+	if s := strings.TrimSpace(b.String()); s != "" {
+		return s
+	}
+
+	v, _ := attr(n, "title")
+	return v
+}
+
+// isDescriptive checks whether a link text describes the link's target.
+func isDescriptive(s string) bool {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	s = strings.Trim(s, ".!?:»›→ ")
+	if s == "" {
+		return false
+	}
+
+	_, ok := nonDescriptive[s]
+	return !ok
+}
+
+// hasOwnText checks whether an element directly contains visible text.
+func hasOwnText(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// findElement returns the first element of the given type in the tree.
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if e := findElement(c, a); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// attr returns the value of an element's attribute and whether it exists.
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// hasLang checks whether an element has a non-empty lang attribute.
+func hasLang(n *html.Node) bool {
+	v, _ := attr(n, "lang")
+	return strings.TrimSpace(v) != ""
+}
+
+// snippet returns the opening tag of an element for identifying it in an issue.
+func snippet(n *html.Node) string {
+	var b strings.Builder
+	b.WriteString("<")
+	b.WriteString(n.Data)
+	for _, a := range n.Attr {
+		b.WriteString(" ")
+		b.WriteString(a.Key)
+		b.WriteString(`="`)
+		b.WriteString(html.EscapeString(a.Val))
+		b.WriteString(`"`)
+	}
+	b.WriteString(">")
+
+	s := b.String()
+	if r := []rune(s); len(r) > maxSnippetLen {
+		s = string(r[:maxSnippetLen-1]) + "…"
+	}
+	return s
+}
+
+// splitCSSValues splits a CSS shorthand value on spaces outside parentheses.
+func splitCSSValues(s string) []string {
+	var (
+		out   []string
+		depth = 0
+		start = 0
+	)
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ' ':
+			if depth == 0 {
+				if i > start {
+					out = append(out, s[start:i])
+				}
+				start = i + 1
+			}
+		}
+	}
+	if start < len(s) {
+		out = append(out, s[start:])
+	}
+	return out
+}
@@ -0,0 +1,195 @@
+package a11y
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// rgb is an sRGB colour with 0-255 components.
+type rgb struct {
+	r, g, b float64
+}
+
+// namedColors is the list of basic CSS colour keywords.
+var namedColors = map[string]string{
+	"black":   "#000000",
+	"silver":  "#c0c0c0",
+	"gray":    "#808080",
+	"grey":    "#808080",
+	"white":   "#ffffff",
+	"maroon":  "#800000",
+	"red":     "#ff0000",
+	"purple":  "#800080",
+	"fuchsia": "#ff00ff",
+	"magenta": "#ff00ff",
+	"green":   "#008000",
+	"lime":    "#00ff00",
+	"olive":   "#808000",
+	"yellow":  "#ffff00",
+	"navy":    "#000080",
+	"blue":    "#0000ff",
+	"teal":    "#008080",
+	"aqua":    "#00ffff",
+	"cyan":    "#00ffff",
+	"orange":  "#ffa500",
+	"pink":    "#ffc0cb",
+	"brown":   "#a52a2a",
+	"gold":    "#ffd700",
+	"beige":   "#f5f5dc",
+	"ivory":   "#fffff0",
+	"khaki":   "#f0e68c",
+	"salmon":  "#fa8072",
+	"tomato":  "#ff6347",
+	"coral":   "#ff7f50",
+	"crimson": "#dc143c",
+	"indigo":  "#4b0082",
+	"violet":  "#ee82ee",
+	"orchid":  "#da70d6",
+	"plum":    "#dda0dd",
+	"tan":     "#d2b48c",
+	"wheat":   "#f5deb3",
+	"linen":   "#faf0e6",
+	"snow":    "#fffafa",
+
+	"lightgray":  "#d3d3d3",
+	"lightgrey":  "#d3d3d3",
+	"darkgray":   "#a9a9a9",
+	"darkgrey":   "#a9a9a9",
+	"dimgray":    "#696969",
+	"dimgrey":    "#696969",
+	"whitesmoke": "#f5f5f5",
+	"gainsboro":  "#dcdcdc",
+}
+
+// parseColor parses a CSS colour: #rgb, #rrggbb, rgb(), rgba() and basic
+// colour keywords. Translucent colours can't be resolved without rendering
+// and are treated as unparseable.
+func parseColor(s string) (rgb, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if v, ok := namedColors[s]; ok {
+		s = v
+	}
+
+	switch {
+	case strings.HasPrefix(s, "#"):
+		h := s[1:]
+		if len(h) == 3 {
+			h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+		}
+		if len(h) != 6 {
+			return rgb{}, false
+		}
+
+		n, err := strconv.ParseUint(h, 16, 32)
+		if err != nil {
+			return rgb{}, false
+		}
+		return rgb{float64(n >> 16 & 0xff), float64(n >> 8 & 0xff), float64(n & 0xff)}, true
+
+	case strings.HasPrefix(s, "rgb(") || strings.HasPrefix(s, "rgba("):
+		_, args, _ := strings.Cut(strings.TrimSuffix(s, ")"), "(")
+		parts := strings.FieldsFunc(args, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '/'
+		})
+		if len(parts) < 3 || len(parts) > 4 {
+			return rgb{}, false
+		}
+
+		// Ignore translucent colours.
+		if len(parts) == 4 {
+			a, ok := parseComponent(parts[3], 1)
+			if !ok || a < 1 {
+				return rgb{}, false
+			}
+		}
+
+		var c [3]float64
+		for i := range c {
+			v, ok := parseComponent(parts[i], 255)
+			if !ok {
+				return rgb{}, false
+			}
+			c[i] = v
+		}
+		return rgb{c[0], c[1], c[2]}, true
+	}
+
+	return rgb{}, false
+}
+
+// parseComponent parses a colour component that's either a number or a
+// percentage of limit.
+func parseComponent(s string, limit float64) (float64, bool) {
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, false
+		}
+		return math.Min(math.Max(v/100*limit, 0), limit), true
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return math.Min(math.Max(v, 0), limit), true
+}
+
+// parseFontSize parses a CSS font size into pixels, relative to the parent's size.
+func parseFontSize(s string, parent float64) (float64, bool) {
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"px", 1},
+		{"pt", 4.0 / 3},
+		{"rem", defaultFontSize},
+		{"em", parent},
+		{"%", parent / 100},
+	}
+	for _, u := range units {
+		if v, ok := strings.CutSuffix(s, u.suffix); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || f < 0 {
+				return 0, false
+			}
+			return f * u.scale, true
+		}
+	}
+
+	if s == "0" {
+		return 0, true
+	}
+	return 0, false
+}
+
+// luminance returns the WCAG relative luminance of a colour.
+// https://www.w3.org/TR/WCAG21/#dfn-relative-luminance
+func luminance(c rgb) float64 {
+	lin := func(v float64) float64 {
+		v /= 255
+		if v <= 0.03928 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+
+	return 0.2126*lin(c.r) + 0.7152*lin(c.g) + 0.0722*lin(c.b)
+}
+
+// contrastRatio returns the WCAG contrast ratio (1 to 21) of two colours.
+// https://www.w3.org/TR/WCAG21/#dfn-contrast-ratio
+func contrastRatio(a, b rgb) float64 {
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// roundRatio rounds a contrast ratio to two decimal places.
+func roundRatio(r float64) float64 {
+	return math.Round(r*100) / 100
+}
@@ -0,0 +1,138 @@
+// Package attribcrypt encrypts and decrypts subscriber attributes at rest with
+// AES-GCM. Encrypted attributes are stored as a JSON object with a single key,
+// {"_encrypted": "base64(nonce + ciphertext)"}, so that they remain valid JSONB.
+package attribcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Key is the attribute key under which the encrypted attributes are stored.
+const Key = "_encrypted"
+
+var (
+	ErrNoKey      = errors.New("no attribute encryption key is configured")
+	ErrInvalidKey = errors.New("attribute encryption key should be 32 bytes (64 hex characters)")
+	ErrDecrypt    = errors.New("error decrypting attributes")
+)
+
+// Cipher encrypts and decrypts attributes. A nil *Cipher is valid and
+// returns ErrNoKey on any attempt to encrypt or decrypt.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// New returns a new Cipher with the given hex encoded 32 byte (AES-256) key.
+// An empty key returns a nil Cipher.
+func New(hexKey string) (*Cipher, error) {
+	if hexKey == "" {
+		return nil, nil
+	}
+
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+// IsEncrypted checks whether the given attributes are encrypted.
+func IsEncrypted(a models.JSON) bool {
+	if len(a) != 1 {
+		return false
+	}
+
+	_, ok := a[Key].(string)
+	return ok
+}
+
+// Encrypt encrypts the given attributes and returns the encrypted attribute object.
+// Already encrypted attributes are returned as-is.
+func (c *Cipher) Encrypt(a models.JSON) (models.JSON, error) {
+	if c == nil {
+		return nil, ErrNoKey
+	}
+	if IsEncrypted(a) {
+		return a, nil
+	}
+
+	if a == nil {
+		a = models.JSON{}
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := c.aead.Seal(nonce, nonce, b, nil)
+	return models.JSON{Key: base64.StdEncoding.EncodeToString(out)}, nil
+}
+
+// Decrypt decrypts the given attributes if they're encrypted.
+// Unencrypted attributes are returned as-is.
+func (c *Cipher) Decrypt(a models.JSON) (models.JSON, error) {
+	if !IsEncrypted(a) {
+		return a, nil
+	}
+	if c == nil {
+		return nil, ErrNoKey
+	}
+
+	b, err := base64.StdEncoding.DecodeString(a[Key].(string))
+	if err != nil || len(b) < c.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	n := c.aead.NonceSize()
+	b, err = c.aead.Open(nil, b[:n], b[n:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	var out models.JSON
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return out, nil
+}
+
+// DecryptRaw decrypts the given raw JSON attributes if they're encrypted.
+// Unencrypted attributes are returned as-is.
+func (c *Cipher) DecryptRaw(b []byte) ([]byte, error) {
+	var a models.JSON
+	if err := json.Unmarshal(b, &a); err != nil || !IsEncrypted(a) {
+		return b, nil
+	}
+
+	a, err := c.Decrypt(a)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(a)
+}
@@ -334,6 +334,28 @@ func (o *Auth) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// QueryTokenMiddleware is an HTTP middleware that authenticates API users with
+// credentials in the `token` query param (api_user:token) instead of the Authorization
+// header. This is for clients such as EventSource that can't set request headers.
+func (o *Auth) QueryTokenMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key, token, _ := strings.Cut(c.QueryParam("token"), ":")
+		if key == "" || token == "" {
+			return echo.NewHTTPError(http.StatusForbidden, "api_user:token missing")
+		}
+
+		// Validate the token.
+		user, ok := o.GetAPIToken(key, token)
+		if !ok {
+			return echo.NewHTTPError(http.StatusForbidden, "invalid API credentials")
+		}
+
+		// Set the user details on the handler context.
+		c.Set(UserHTTPCtxKey, user)
+		return next(c)
+	}
+}
+
 // Perm is an HTTP handler middleware that checks if the authenticated user has the required permissions.
 func (o *Auth) Perm(next echo.HandlerFunc, perms ...string) echo.HandlerFunc {
 	return func(c echo.Context) error {
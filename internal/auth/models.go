@@ -42,36 +42,42 @@ const (
 
 // List of all granular permissions.
 const (
-	PermListGetAll            = "lists:get_all"
-	PermListManageAll         = "lists:manage_all"
-	PermListManage            = "list:manage"
-	PermListGet               = "list:get"
-	PermSubscribersGet        = "subscribers:get"
-	PermSubscribersGetAll     = "subscribers:get_all"
-	PermSubscribersManage     = "subscribers:manage"
-	PermSubscribersImport     = "subscribers:import"
-	PermSubscribersSqlQuery   = "subscribers:sql_query"
-	PermTxSend                = "tx:send"
-	PermCampaignsGet          = "campaigns:get"
-	PermCampaignsGetAll       = "campaigns:get_all"
-	PermCampaignsGetAnalytics = "campaigns:get_analytics"
-	PermCampaignsManage       = "campaigns:manage"
-	PermCampaignsManageAll    = "campaigns:manage_all"
-	PermCampaignsSend         = "campaigns:send"
-	PermBouncesGet            = "bounces:get"
-	PermBouncesManage         = "bounces:manage"
-	PermWebhooksPostBounce    = "webhooks:post_bounce"
-	PermMediaGet              = "media:get"
-	PermMediaManage           = "media:manage"
-	PermTemplatesGet          = "templates:get"
-	PermTemplatesManage       = "templates:manage"
-	PermUsersGet              = "users:get"
-	PermUsersManage           = "users:manage"
-	PermRolesGet              = "roles:get"
-	PermRolesManage           = "roles:manage"
-	PermSettingsGet           = "settings:get"
-	PermSettingsManage        = "settings:manage"
-	PermSettingsMaintain      = "settings:maintain"
+	PermListGetAll              = "lists:get_all"
+	PermListManageAll           = "lists:manage_all"
+	PermListManage              = "list:manage"
+	PermListGet                 = "list:get"
+	PermSubscribersGet          = "subscribers:get"
+	PermSubscribersGetAll       = "subscribers:get_all"
+	PermSubscribersManage       = "subscribers:manage"
+	PermSubscribersImport       = "subscribers:import"
+	PermSubscribersSqlQuery     = "subscribers:sql_query"
+	PermTxSend                  = "tx:send"
+	PermSegmentsGet             = "segments:get"
+	PermSegmentsManage          = "segments:manage"
+	PermSegmentsApprove         = "segments:approve"
+	PermCampaignsGet            = "campaigns:get"
+	PermCampaignsGetAll         = "campaigns:get_all"
+	PermCampaignsGetAnalytics   = "campaigns:get_analytics"
+	PermCampaignsManage         = "campaigns:manage"
+	PermCampaignsManageAll      = "campaigns:manage_all"
+	PermCampaignsSend           = "campaigns:send"
+	PermCampaignsStartImmediate = "campaigns:start_immediate"
+	PermCampaignsOverrideGate   = "campaigns:override_gate"
+	PermBouncesGet              = "bounces:get"
+	PermBouncesManage           = "bounces:manage"
+	PermWebhooksPostBounce      = "webhooks:post_bounce"
+	PermWebhooksPostDelivery    = "webhooks:post_delivery"
+	PermMediaGet                = "media:get"
+	PermMediaManage             = "media:manage"
+	PermTemplatesGet            = "templates:get"
+	PermTemplatesManage         = "templates:manage"
+	PermUsersGet                = "users:get"
+	PermUsersManage             = "users:manage"
+	PermRolesGet                = "roles:get"
+	PermRolesManage             = "roles:manage"
+	PermSettingsGet             = "settings:get"
+	PermSettingsManage          = "settings:manage"
+	PermSettingsMaintain        = "settings:maintain"
 )
 
 // Base holds common fields shared across models.
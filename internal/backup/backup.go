@@ -0,0 +1,308 @@
+// Package backup takes gzipped SQL backups of the database, either with pg_dump
+// or with a pure-SQL data export of listmonk's tables, and uploads them to the
+// media store under the backups/ directory, rotating old backups.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/media"
+	"github.com/lib/pq"
+)
+
+const (
+	MethodPgDump = "pg_dump"
+	MethodSQL    = "sql"
+
+	// Backup file names are timestamped so that they sort chronologically.
+	fileFormat  = "listmonk-20060102-150405.sql.gz"
+	contentType = "application/gzip"
+)
+
+// ErrRunning is returned when a backup is requested while another is in progress.
+var ErrRunning = errors.New("a backup is already running")
+
+// DBOpt represents the database connection params passed to pg_dump.
+type DBOpt struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+	SSLMode  string
+}
+
+// Opt represents backup options.
+type Opt struct {
+	// Method is either pg_dump or sql.
+	Method string
+
+	// Path to the pg_dump binary.
+	PgDumpPath string
+
+	// Number of backups to retain. Older backups are deleted after a backup.
+	Keep int
+
+	DB DBOpt
+}
+
+// Backups takes database backups and stores them in a media store.
+type Backups struct {
+	opt   Opt
+	db    *sqlx.DB
+	store media.Store
+	lo    *log.Logger
+
+	mu sync.Mutex
+}
+
+// New returns a new instance of Backups.
+func New(opt Opt, db *sqlx.DB, store media.Store, lo *log.Logger) *Backups {
+	if opt.PgDumpPath == "" {
+		opt.PgDumpPath = "pg_dump"
+	}
+
+	return &Backups{
+		opt:   opt,
+		db:    db,
+		store: store,
+		lo:    lo,
+	}
+}
+
+// Run takes a backup of the database, uploads it to the store, and deletes
+// backups older than the last N. Only one backup runs at a time.
+func (b *Backups) Run(ctx context.Context) (media.Object, error) {
+	if !b.mu.TryLock() {
+		return media.Object{}, ErrRunning
+	}
+	defer b.mu.Unlock()
+
+	// Dump to a temp file as the store requires a seekable reader.
+	f, err := os.CreateTemp("", "listmonk-backup-*.sql.gz")
+	if err != nil {
+		return media.Object{}, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	gz := gzip.NewWriter(f)
+	switch b.Method() {
+	case MethodPgDump:
+		err = b.pgDump(ctx, gz)
+	case MethodSQL:
+		err = b.sqlDump(ctx, gz)
+	default:
+		err = fmt.Errorf("unknown backup method: %s", b.Method())
+	}
+	if err != nil {
+		return media.Object{}, err
+	}
+	if err := gz.Close(); err != nil {
+		return media.Object{}, err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return media.Object{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return media.Object{}, err
+	}
+
+	// Upload to the store.
+	now := time.Now()
+	name := path.Join(media.BackupsDir, now.UTC().Format(fileFormat))
+	if _, err := b.store.Put(ctx, name, contentType, f); err != nil {
+		return media.Object{}, fmt.Errorf("error uploading backup: %w", err)
+	}
+	b.lo.Printf("uploaded database backup %s (%d bytes)", name, size)
+
+	// Rotate old backups.
+	if err := b.rotate(ctx); err != nil {
+		b.lo.Printf("error deleting old backups: %v", err)
+	}
+
+	return media.Object{Name: name, Size: size, CreatedAt: now}, nil
+}
+
+// IsRunning checks if a backup is in progress.
+func (b *Backups) IsRunning() bool {
+	if b.mu.TryLock() {
+		b.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// Method returns the configured backup method.
+func (b *Backups) Method() string {
+	if b.opt.Method == "" {
+		return MethodSQL
+	}
+	return b.opt.Method
+}
+
+// List returns the list of backups in the store, latest first.
+func (b *Backups) List(ctx context.Context) ([]media.Object, error) {
+	out, err := b.store.List(ctx, media.BackupsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Timestamped file names sort chronologically.
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name > out[j].Name
+	})
+
+	return out, nil
+}
+
+// rotate deletes all but the latest N backups.
+func (b *Backups) rotate(ctx context.Context) error {
+	if b.opt.Keep < 1 {
+		return nil
+	}
+
+	list, err := b.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(list) <= b.opt.Keep {
+		return nil
+	}
+
+	for _, o := range list[b.opt.Keep:] {
+		if err := b.store.Delete(ctx, o.Name); err != nil {
+			return err
+		}
+		b.lo.Printf("deleted old database backup %s", o.Name)
+	}
+
+	return nil
+}
+
+// pgDump runs pg_dump and writes its output to w.
+func (b *Backups) pgDump(ctx context.Context, w io.Writer) error {
+	var (
+		cmd    = exec.CommandContext(ctx, b.opt.PgDumpPath, "--no-owner", "--no-privileges")
+		stderr bytes.Buffer
+	)
+
+	// Connection params are passed as environment variables to not
+	// expose the password in the process list.
+	cmd.Env = append(os.Environ(),
+		"PGHOST="+b.opt.DB.Host,
+		"PGUSER="+b.opt.DB.User,
+		"PGPASSWORD="+b.opt.DB.Password,
+		"PGDATABASE="+b.opt.DB.Database,
+	)
+	if b.opt.DB.Port > 0 {
+		cmd.Env = append(cmd.Env, "PGPORT="+strconv.Itoa(b.opt.DB.Port))
+	}
+	if b.opt.DB.SSLMode != "" {
+		cmd.Env = append(cmd.Env, "PGSSLMODE="+b.opt.DB.SSLMode)
+	}
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running pg_dump: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// sqlDump writes a data-only SQL export of all the tables in the current schema
+// to w. The export is meant to be restored on a fresh listmonk installation
+// (with the schema already created) of the same version.
+func (b *Backups) sqlDump(ctx context.Context, w io.Writer) error {
+	tx, err := b.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var tables []string
+	if err := tx.SelectContext(ctx, &tables, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = CURRENT_SCHEMA() AND table_type = 'BASE TABLE' ORDER BY table_name`); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "-- listmonk data export: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprint(w, "-- Restore (as a superuser) on a listmonk database of the same version created with --install.\n\n")
+	fmt.Fprint(w, "BEGIN;\nSET session_replication_role = replica;\n\n")
+
+	// Clear the tables (including the default data created on install) before restoring.
+	quoted := make([]string, len(tables))
+	for i, t := range tables {
+		quoted[i] = pq.QuoteIdentifier(t)
+	}
+	fmt.Fprintf(w, "TRUNCATE %s CASCADE;\n\n", strings.Join(quoted, ", "))
+
+	for _, t := range tables {
+		// Generated columns can't be inserted into.
+		var cols []string
+		if err := tx.SelectContext(ctx, &cols, `SELECT QUOTE_IDENT(column_name) FROM information_schema.columns
+			WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND is_generated = 'NEVER'
+			ORDER BY ordinal_position`, t); err != nil {
+			return err
+		}
+		colList := strings.Join(cols, ", ")
+
+		// Postgres generates an INSERT statement for every row.
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT FORMAT('INSERT INTO %%1$I (%[2]s) SELECT %[2]s FROM JSON_POPULATE_RECORD(NULL::%%1$I, %%2$L);', $1::TEXT, ROW_TO_JSON(t)::TEXT) FROM %[1]s t`,
+			pq.QuoteIdentifier(t), colList), t)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var stmt string
+			if err := rows.Scan(&stmt); err != nil {
+				rows.Close()
+				return err
+			}
+			if _, err := io.WriteString(w, stmt+"\n"); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// Reset the table's ID sequence if there's one.
+		var seq sql.NullString
+		if err := tx.GetContext(ctx, &seq, `SELECT PG_GET_SERIAL_SEQUENCE(QUOTE_IDENT($1), column_name)
+			FROM information_schema.columns WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND column_name = 'id'`, t); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if seq.Valid {
+			fmt.Fprintf(w, "SELECT SETVAL(%s, COALESCE((SELECT MAX(id) FROM %s), 0) + 1, false);\n",
+				pq.QuoteLiteral(seq.String), pq.QuoteIdentifier(t))
+		}
+		fmt.Fprint(w, "\n")
+	}
+
+	_, err = fmt.Fprint(w, "SET session_replication_role = DEFAULT;\nCOMMIT;\n")
+	return err
+}
@@ -3,6 +3,7 @@ package bounce
 import (
 	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -14,7 +15,19 @@ import (
 // Mailbox represents a POP/IMAP mailbox client that can scan messages and pass
 // them to a given channel.
 type Mailbox interface {
-	Scan(limit int, ch chan models.Bounce) error
+	Scan(limit int, ch chan models.Bounce, unsubCh chan models.MailtoUnsub) error
+
+	// Status returns the status of the scanner and the quarantined messages.
+	Status() mailbox.Status
+
+	// QuarantinedMessage returns the raw copy of a quarantined message.
+	QuarantinedMessage(uid string) ([]byte, bool)
+}
+
+// Waiter is implemented by mailboxes that can wait for new messages to arrive
+// (eg: IMAP IDLE) instead of being scanned at regular intervals.
+type Waiter interface {
+	Wait(timeout time.Duration) error
 }
 
 // Opt represents bounce processing options.
@@ -43,12 +56,25 @@ type Opt struct {
 		Key     string
 	}
 
+	// Reasons are custom rules for classifying bounces into normalized reasons
+	// that are matched before the built-in ones.
+	Reasons []ReasonRule
+
+	// TypeRules are custom rules for classifying mailbox bounces as hard or soft
+	// by their diagnostic texts before the SMTP status codes.
+	TypeRules []mailbox.TypeRule
+
 	RecordBounceCB func(models.Bounce) error
+
+	// UnsubscribeCB processes unsubscribe requests e-mailed to signed List-Unsubscribe
+	// mailto: addresses and received in the mailbox. If it's nil, they're not looked for.
+	UnsubscribeCB func(models.MailtoUnsub) error
 }
 
 // Manager handles e-mail bounces.
 type Manager struct {
 	queue        chan models.Bounce
+	unsubQueue   chan models.MailtoUnsub
 	mailbox      Mailbox
 	SES          *webhooks.SES
 	Azure        *webhooks.Azure
@@ -57,8 +83,10 @@ type Manager struct {
 	Forwardemail *webhooks.Forwardemail
 	Lettermint   *webhooks.Lettermint
 	queries      *Queries
+	classifier   *Classifier
 	opt          Opt
 	log          *log.Logger
+	scanOnce     sync.Once
 }
 
 // Queries contains the queries.
@@ -76,11 +104,31 @@ func New(opt Opt, q *Queries, lo *log.Logger) (*Manager, error) {
 		log:     lo,
 	}
 
+	// Invalid custom rules are ignored in favour of the built-in ones.
+	cl, err := NewClassifier(opt.Reasons)
+	if err != nil {
+		lo.Printf("error initializing bounce reasons: %v", err)
+		cl, _ = NewClassifier(nil)
+	}
+	m.classifier = cl
+
+	if opt.UnsubscribeCB != nil {
+		m.unsubQueue = make(chan models.MailtoUnsub, 1000)
+	}
+
 	// Is there a mailbox?
 	if opt.MailboxEnabled {
+		// Invalid custom rules are ignored in favour of the built-in classification.
+		rules, err := mailbox.NewTypeRules(opt.TypeRules)
+		if err != nil {
+			lo.Printf("error initializing bounce classification rules: %v", err)
+		}
+
 		switch opt.MailboxType {
-		case "pop":
-			m.mailbox = mailbox.NewPOP(opt.Mailbox, lo)
+		case "pop", "pop3":
+			m.mailbox = mailbox.NewPOP(opt.Mailbox, rules, lo)
+		case "imap":
+			m.mailbox = mailbox.NewIMAP(opt.Mailbox, rules, lo)
 		default:
 			return nil, errors.New("unknown bounce mailbox type")
 		}
@@ -120,36 +168,87 @@ func New(opt Opt, q *Queries, lo *log.Logger) (*Manager, error) {
 	return m, nil
 }
 
-// Run is a blocking function that listens for bounce events from webhooks and or mailboxes
-// and executes them on the DB.
-func (m *Manager) Run() {
-	if m.opt.MailboxEnabled {
-		go m.runMailboxScanner()
+// StartMailboxScanner starts scanning the bounce mailbox at regular intervals
+// if it's enabled. It's a no-op if the scanner is already running.
+func (m *Manager) StartMailboxScanner() {
+	if !m.opt.MailboxEnabled {
+		return
 	}
 
-	for b := range m.queue {
-		if b.CreatedAt.IsZero() {
-			b.CreatedAt = time.Now()
-		}
+	m.scanOnce.Do(func() {
+		go m.runMailboxScanner()
+	})
+}
 
-		if err := m.opt.RecordBounceCB(b); err != nil {
-			continue
+// Run is a blocking function that listens for bounce events from webhooks and or mailboxes,
+// and unsubscribe requests from mailboxes, and executes them on the DB.
+func (m *Manager) Run() {
+	for {
+		select {
+		case b := <-m.queue:
+			if b.CreatedAt.IsZero() {
+				b.CreatedAt = time.Now()
+			}
+			if b.Reason == "" {
+				b.Reason = m.classifier.Classify(b)
+			}
+
+			if err := m.opt.RecordBounceCB(b); err != nil {
+				continue
+			}
+
+		// unsubQueue is nil (and blocks forever) if unsubscribe requests aren't processed.
+		case u := <-m.unsubQueue:
+			if err := m.opt.UnsubscribeCB(u); err != nil {
+				continue
+			}
 		}
 	}
 }
 
-// runMailboxScanner runs a blocking loop that scans the mailbox at given intervals.
+// runMailboxScanner runs a blocking loop that scans the mailbox at given intervals,
+// or as soon as new messages arrive if the mailbox can wait for them.
 func (m *Manager) runMailboxScanner() {
 	for {
 		m.log.Printf("scanning bounce mailbox %s", m.opt.Mailbox.Host)
-		if err := m.mailbox.Scan(1000, m.queue); err != nil {
+		if err := m.mailbox.Scan(1000, m.queue, m.unsubQueue); err != nil {
 			m.log.Printf("error scanning bounce mailbox: %v", err)
 		}
 
+		if w, ok := m.mailbox.(Waiter); ok {
+			err := w.Wait(m.opt.Mailbox.ScanInterval)
+			if err == nil {
+				continue
+			}
+
+			// Fall back to scanning at the interval.
+			if !errors.Is(err, mailbox.ErrIdleUnsupported) {
+				m.log.Printf("error waiting for bounce mailbox messages: %v", err)
+			}
+		}
+
 		time.Sleep(m.opt.Mailbox.ScanInterval)
 	}
 }
 
+// MailboxStatus returns the status of the bounce mailbox scanner.
+func (m *Manager) MailboxStatus() mailbox.Status {
+	if m.mailbox == nil {
+		return mailbox.Status{Quarantine: []mailbox.QuarantinedMessage{}}
+	}
+
+	return m.mailbox.Status()
+}
+
+// QuarantinedMessage returns the raw copy of a quarantined mailbox message.
+func (m *Manager) QuarantinedMessage(uid string) ([]byte, bool) {
+	if m.mailbox == nil {
+		return nil, false
+	}
+
+	return m.mailbox.QuarantinedMessage(uid)
+}
+
 // Record records a new bounce event given the subscriber's email or UUID.
 func (m *Manager) Record(b models.Bounce) error {
 	m.queue <- b
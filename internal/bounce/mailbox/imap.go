@@ -0,0 +1,579 @@
+package mailbox
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// imapTimeout is the time within which the server has to respond to a command.
+	imapTimeout = 2 * time.Minute
+
+	// maxIdle is the duration after which IDLE is re-issued as servers drop
+	// idling clients after 30 minutes (RFC 2177).
+	maxIdle = 25 * time.Minute
+
+	// maxLiteralSize is the size limit of the literals (eg: message bodies) in
+	// server responses.
+	maxLiteralSize = 100 * 1024 * 1024
+)
+
+// ErrIdleUnsupported is returned by Wait if the IMAP server doesn't support IDLE.
+var ErrIdleUnsupported = errors.New("IMAP server doesn't support IDLE")
+
+var (
+	reIMAPLiteral = regexp.MustCompile(`\{(\d+)\+?\}$`)
+	reIMAPExists  = regexp.MustCompile(`(?i)^\* (\d+) EXISTS`)
+	reIMAPUID     = regexp.MustCompile(`(?i)\bUID (\d+)`)
+	reIMAPSize    = regexp.MustCompile(`(?i)\bRFC822\.SIZE (\d+)`)
+)
+
+// IMAP represents an IMAP mailbox. After scanning it, new messages are waited
+// for with IMAP IDLE (RFC 2177) so that they're processed as soon as they arrive.
+type IMAP struct {
+	opt   Opt
+	rules TypeRules
+	q     *quarantine
+	lo    *log.Logger
+
+	// Number of messages left in the folder after the last scan (eg: quarantined
+	// ones), and whether the scan stopped at its limit with more messages to scan.
+	remaining int
+	more      bool
+
+	// The server doesn't support IDLE.
+	noIdle bool
+}
+
+// imapConn is a minimal IMAP4rev1 client connection.
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+	caps map[string]bool
+}
+
+// imapResp is an untagged server response line along with the literals in it.
+type imapResp struct {
+	line     string
+	literals [][]byte
+}
+
+// NewIMAP returns a new instance of the IMAP mailbox client. The bounce type
+// rules are matched before the SMTP status codes in the messages.
+func NewIMAP(opt Opt, rules TypeRules, lo *log.Logger) *IMAP {
+	if opt.Folder == "" {
+		opt.Folder = "INBOX"
+	}
+
+	return &IMAP{
+		opt:   opt,
+		rules: rules,
+		q:     newQuarantine(),
+		lo:    lo,
+	}
+}
+
+// Scan scans the mailbox folder and pushes the downloaded messages into the given channel.
+// Messages sent to signed List-Unsubscribe mailto: addresses are pushed into unsubCh
+// instead, if it's not nil. The messages that are processed are deleted from the server.
+// If limit > 0, at most limit messages are processed in one scan.
+//
+// Messages that are bigger than the size limit, or that fail parsing in several
+// scans, are quarantined: they're skipped by their UID and left on the server.
+func (m *IMAP) Scan(limit int, ch chan models.Bounce, unsubCh chan models.MailtoUnsub) (err error) {
+	defer func() {
+		m.q.scanned(err)
+	}()
+
+	c, count, err := m.connect()
+	if err != nil {
+		return err
+	}
+	defer c.logout()
+
+	m.more = false
+	m.remaining = count
+
+	// No messages.
+	if count == 0 {
+		m.q.prune(nil)
+		return nil
+	}
+
+	// Get the unique IDs and the sizes of the messages.
+	res, err := c.cmd("UID FETCH 1:* (UID RFC822.SIZE)")
+	if err != nil {
+		return err
+	}
+
+	var (
+		uids  = make([]int, 0, count)
+		sizes = make(map[int]int, count)
+		all   = make(map[string]bool, count)
+	)
+	for _, r := range res {
+		u := reIMAPUID.FindStringSubmatch(r.line)
+		if u == nil {
+			continue
+		}
+		uid, _ := strconv.Atoi(u[1])
+		if _, ok := sizes[uid]; ok {
+			continue
+		}
+
+		size := 0
+		if s := reIMAPSize.FindStringSubmatch(r.line); s != nil {
+			size, _ = strconv.Atoi(s[1])
+		}
+
+		uids = append(uids, uid)
+		sizes[uid] = size
+		all[u[1]] = true
+	}
+	sort.Ints(uids)
+	m.q.prune(all)
+
+	var (
+		maxSize = m.maxMessageSize()
+		del     = make([]string, 0, len(uids))
+		num     = 0
+	)
+	for _, id := range uids {
+		uid := strconv.Itoa(id)
+		if m.q.isQuarantined(uid) {
+			continue
+		}
+		if limit > 0 && num >= limit {
+			m.more = true
+			break
+		}
+		num++
+
+		// Skip messages that are too big to be downloaded.
+		if sizes[id] > maxSize {
+			m.q.quarantineOversized(uid, sizes[id], maxSize)
+			m.lo.Printf("skipping bounce message %s: size %d bytes exceeds the limit of %d bytes", uid, sizes[id], maxSize)
+			continue
+		}
+
+		// Retrieve the raw bytes of the message without marking it as seen.
+		raw, err := c.fetchBody(uid)
+		if err != nil {
+			m.lo.Printf("error retrieving bounce message %s: %v", uid, err)
+			continue
+		}
+
+		// Parse the message. Messages that fail parsing are retried in the next
+		// scans until they're quarantined.
+		msg, err := parseWithTimeout(raw, m.opt.Host, unsubCh != nil, m.rules, parseTimeout)
+		if err != nil {
+			if m.q.fail(uid, raw, err) {
+				m.lo.Printf("error parsing bounce message %s. quarantined after %d attempts: %v", uid, maxParseAttempts, err)
+			} else {
+				m.lo.Printf("error parsing bounce message %s: %v", uid, err)
+			}
+			continue
+		}
+		m.q.done(uid)
+		del = append(del, uid)
+
+		// Is it an unsubscribe request sent to a List-Unsubscribe mailto: address?
+		if msg.unsub != nil {
+			select {
+			case unsubCh <- *msg.unsub:
+			default:
+			}
+			continue
+		}
+
+		select {
+		case ch <- msg.bounce:
+		default:
+		}
+	}
+
+	// Delete the processed messages.
+	if len(del) > 0 {
+		if _, err := c.cmd(`UID STORE ` + strings.Join(del, ",") + ` +FLAGS.SILENT (\Deleted)`); err != nil {
+			return err
+		}
+		if _, err := c.cmd("EXPUNGE"); err != nil {
+			return err
+		}
+		m.remaining -= len(del)
+	}
+
+	return nil
+}
+
+// Wait blocks until new messages arrive in the mailbox folder or the timeout elapses.
+// It returns ErrIdleUnsupported if the server doesn't support IDLE, in which case,
+// the mailbox should be scanned at regular intervals instead.
+func (m *IMAP) Wait(timeout time.Duration) error {
+	if m.noIdle {
+		return ErrIdleUnsupported
+	}
+
+	// The last scan stopped at its limit. Scan again right away.
+	if m.more {
+		return nil
+	}
+
+	c, count, err := m.connect()
+	if err != nil {
+		return err
+	}
+	defer c.logout()
+
+	if !c.caps["IDLE"] {
+		m.noIdle = true
+		m.lo.Printf("bounce mailbox %s doesn't support IMAP IDLE. scanning it every %s", m.opt.Host, m.opt.ScanInterval)
+		return ErrIdleUnsupported
+	}
+
+	// Messages arrived after the last scan.
+	if count > m.remaining {
+		return nil
+	}
+
+	for end := time.Now().Add(timeout); time.Now().Before(end); {
+		ok, err := c.idle(min(time.Until(end), maxIdle), count)
+		if err != nil || ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Status returns the status of the scanner and the quarantined messages.
+func (m *IMAP) Status() Status {
+	return m.q.getStatus()
+}
+
+// QuarantinedMessage returns the raw copy of a quarantined message by its UID.
+func (m *IMAP) QuarantinedMessage(uid string) ([]byte, bool) {
+	return m.q.getRaw(uid)
+}
+
+// maxMessageSize returns the size limit of messages in bytes.
+func (m *IMAP) maxMessageSize() int {
+	if m.opt.MaxMessageSize > 0 {
+		return m.opt.MaxMessageSize * 1024
+	}
+
+	return defaultMaxMessageSize * 1024
+}
+
+// connect connects and logs in to the server and selects the mailbox folder.
+// It returns the number of messages in the folder.
+func (m *IMAP) connect() (*imapConn, int, error) {
+	var (
+		addr = net.JoinHostPort(m.opt.Host, strconv.Itoa(m.opt.Port))
+		d    = &net.Dialer{Timeout: imapTimeout}
+
+		conn net.Conn
+		err  error
+	)
+	if m.opt.TLSEnabled {
+		conn, err = tls.DialWithDialer(d, "tcp", addr, &tls.Config{
+			ServerName:         m.opt.Host,
+			InsecureSkipVerify: m.opt.TLSSkipVerify,
+		})
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+
+	// Read the server greeting.
+	conn.SetDeadline(time.Now().Add(imapTimeout))
+	g, err := c.read()
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	if !strings.HasPrefix(strings.ToUpper(g.line), "* OK") && !strings.HasPrefix(strings.ToUpper(g.line), "* PREAUTH") {
+		conn.Close()
+		return nil, 0, fmt.Errorf("unexpected IMAP greeting: %s", g.line)
+	}
+
+	if err := c.auth(m.opt); err != nil {
+		c.logout()
+		return nil, 0, err
+	}
+
+	// The capabilities may change after authentication.
+	if err := c.capability(); err != nil {
+		c.logout()
+		return nil, 0, err
+	}
+
+	res, err := c.cmd("SELECT " + quote(m.opt.Folder))
+	if err != nil {
+		c.logout()
+		return nil, 0, err
+	}
+
+	count := 0
+	for _, r := range res {
+		if e := reIMAPExists.FindStringSubmatch(r.line); e != nil {
+			count, _ = strconv.Atoi(e[1])
+		}
+	}
+
+	return c, count, nil
+}
+
+// auth authenticates with the given auth protocol: none, plain, cram, or login
+// (the default, also used for userpass).
+func (c *imapConn) auth(opt Opt) error {
+	switch opt.AuthProtocol {
+	case "none":
+		return nil
+	case "plain":
+		return c.authenticate("PLAIN", func([]byte) []byte {
+			return []byte("\x00" + opt.Username + "\x00" + opt.Password)
+		})
+	case "cram":
+		return c.authenticate("CRAM-MD5", func(challenge []byte) []byte {
+			h := hmac.New(md5.New, []byte(opt.Password))
+			h.Write(challenge)
+			return []byte(opt.Username + " " + hex.EncodeToString(h.Sum(nil)))
+		})
+	default:
+		_, err := c.cmd("LOGIN " + quote(opt.Username) + " " + quote(opt.Password))
+		return err
+	}
+}
+
+// authenticate runs a SASL exchange (AUTHENTICATE) where resp returns the
+// response to every server challenge.
+func (c *imapConn) authenticate(mech string, resp func(challenge []byte) []byte) error {
+	tag, err := c.send("AUTHENTICATE " + mech)
+	if err != nil {
+		return err
+	}
+
+	for {
+		r, err := c.read()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasPrefix(r.line, "+"):
+			ch, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(r.line, "+")))
+			if _, err := io.WriteString(c.conn, base64.StdEncoding.EncodeToString(resp(ch))+"\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(r.line, tag+" "):
+			return checkStatus(r.line[len(tag)+1:])
+		}
+	}
+}
+
+// capability reads the server's capabilities.
+func (c *imapConn) capability() error {
+	res, err := c.cmd("CAPABILITY")
+	if err != nil {
+		return err
+	}
+
+	c.caps = make(map[string]bool)
+	for _, r := range res {
+		f := strings.Fields(strings.ToUpper(r.line))
+		if len(f) < 2 || f[1] != "CAPABILITY" {
+			continue
+		}
+		for _, cp := range f[2:] {
+			c.caps[cp] = true
+		}
+	}
+
+	return nil
+}
+
+// fetchBody retrieves the raw bytes of a message by its UID.
+func (c *imapConn) fetchBody(uid string) ([]byte, error) {
+	res, err := c.cmd("UID FETCH " + uid + " (BODY.PEEK[])")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range res {
+		if len(r.literals) > 0 {
+			return r.literals[0], nil
+		}
+	}
+
+	return nil, errors.New("message not found")
+}
+
+// idle waits (IDLE) for the number of messages in the selected folder to exceed count
+// or for the timeout to elapse. It returns true if new messages have arrived.
+func (c *imapConn) idle(timeout time.Duration, count int) (bool, error) {
+	tag, err := c.send("IDLE")
+	if err != nil {
+		return false, err
+	}
+
+	// Wait for the server to start idling.
+	for {
+		r, err := c.read()
+		if err != nil {
+			return false, err
+		}
+		if strings.HasPrefix(r.line, "+") {
+			break
+		}
+		if strings.HasPrefix(r.line, tag+" ") {
+			return false, checkStatus(r.line[len(tag)+1:])
+		}
+	}
+
+	arrived := false
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		r, err := c.read()
+		if err != nil {
+			var nErr net.Error
+			if errors.As(err, &nErr) && nErr.Timeout() {
+				break
+			}
+			return false, err
+		}
+
+		if e := reIMAPExists.FindStringSubmatch(r.line); e != nil {
+			if n, _ := strconv.Atoi(e[1]); n > count {
+				arrived = true
+				break
+			}
+		}
+	}
+
+	// A timed out read may have consumed a part of a response, which is harmless
+	// as the remaining responses until the tagged one are discarded.
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := io.WriteString(c.conn, "DONE\r\n"); err != nil {
+		return false, err
+	}
+	for {
+		r, err := c.read()
+		if err != nil {
+			return false, err
+		}
+		if strings.HasPrefix(r.line, tag+" ") {
+			return arrived, checkStatus(r.line[len(tag)+1:])
+		}
+	}
+}
+
+// logout logs out and closes the connection.
+func (c *imapConn) logout() {
+	c.cmd("LOGOUT")
+	c.conn.Close()
+}
+
+// cmd runs a command and returns its untagged responses.
+func (c *imapConn) cmd(cmd string) ([]imapResp, error) {
+	tag, err := c.send(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []imapResp
+	for {
+		r, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(r.line, tag+" ") {
+			return out, checkStatus(r.line[len(tag)+1:])
+		}
+		out = append(out, r)
+	}
+}
+
+// send writes a tagged command to the server and returns its tag.
+func (c *imapConn) send(cmd string) (string, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := io.WriteString(c.conn, tag+" "+cmd+"\r\n"); err != nil {
+		return "", err
+	}
+
+	return tag, nil
+}
+
+// read reads a server response line along with the literals ({size}) in it.
+func (c *imapConn) read() (imapResp, error) {
+	var (
+		out  imapResp
+		line strings.Builder
+	)
+	for {
+		l, err := c.r.ReadString('\n')
+		if err != nil {
+			return out, err
+		}
+		l = strings.TrimRight(l, "\r\n")
+		line.WriteString(l)
+
+		m := reIMAPLiteral.FindStringSubmatch(l)
+		if m == nil {
+			break
+		}
+
+		n, _ := strconv.Atoi(m[1])
+		if n > maxLiteralSize {
+			return out, fmt.Errorf("IMAP response literal of %d bytes exceeds the limit", n)
+		}
+
+		b := make([]byte, n)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return out, err
+		}
+		out.literals = append(out.literals, b)
+	}
+	out.line = line.String()
+
+	return out, nil
+}
+
+// checkStatus returns an error if the status of a tagged response isn't OK.
+func checkStatus(s string) error {
+	if strings.HasPrefix(strings.ToUpper(s), "OK") {
+		return nil
+	}
+
+	return fmt.Errorf("IMAP error: %s", s)
+}
+
+// quote returns s as an IMAP quoted string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -26,4 +26,8 @@ type Opt struct {
 	TLSSkipVerify bool `json:"tls_skip_verify"`
 
 	ScanInterval time.Duration `json:"scan_interval"`
+
+	// MaxMessageSize is the size limit (KB) of messages that are downloaded.
+	// Bigger messages are quarantined. 0 uses the default limit.
+	MaxMessageSize int `json:"max_message_size"`
 }
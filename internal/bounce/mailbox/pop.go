@@ -1,6 +1,7 @@
 package mailbox
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,16 +13,26 @@ import (
 	"github.com/emersion/go-message"
 	_ "github.com/emersion/go-message/charset"
 	"github.com/knadh/go-pop3"
+	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
 )
 
 // POP represents a POP mailbox.
 type POP struct {
 	opt    Opt
+	rules  TypeRules
 	client *pop3.Client
+	q      *quarantine
 	lo     *log.Logger
 }
 
+// parsedMsg represents a parsed mailbox message, which is either a bounce or
+// an unsubscribe request.
+type parsedMsg struct {
+	bounce models.Bounce
+	unsub  *models.MailtoUnsub
+}
+
 type bounceHeaders struct {
 	Header string
 	Regexp *regexp.Regexp
@@ -34,6 +45,9 @@ type bounceMeta struct {
 	DeliveredTo    string   `json:"delivered_to"`
 	Received       []string `json:"received"`
 	ClassifyReason string   `json:"classify_reason"`
+	Diagnostic     string   `json:"diagnostic,omitempty"`
+	Recipient      string   `json:"recipient,omitempty"`
+	Status         string   `json:"status,omitempty"`
 }
 
 var (
@@ -48,35 +62,56 @@ var (
 		{models.EmailHeaderDeliveredTo, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderDeliveredTo + `:\s+?)(.*)`)},
 	}
 
+	// Headers that may carry the address a message was sent to.
+	unsubRecipientHeaders = []string{"To", models.EmailHeaderDeliveredTo, "X-Original-To", "Envelope-To"}
+
 	reHdrReceived = regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderReceived + `:\s+?)(.*)`)
 
 	// SMTP status code (5.x.x or 4.x.x) to classify hard/soft bounces.
 	reSMTPStatus = regexp.MustCompile(`(?m)(?i)^(?:Status:\s*)?(?:\d{3}\s+)?([45]\.\d+\.\d+)`)
 
+	// Diagnostic-Code in delivery status notifications that carries the remote server's response.
+	reDiagnostic = regexp.MustCompile(`(?mi)^Diagnostic-Code:\s*(?:smtp;\s*)?(.+)$`)
+
+	// Recipient and Status fields of RFC 3464 delivery status notifications.
+	reDSNRecipient = regexp.MustCompile(`(?mi)^(Original|Final)-Recipient:\s*rfc822\s*;\s*<?([^\s<>;]+@[^\s<>;]+?)>?\s*$`)
+	reDSNStatus    = regexp.MustCompile(`(?mi)^Status:\s*([245]\.\d{1,3}\.\d{1,3})`)
+
 	// List of (conventional) strings to guess hard bounces.
 	reHardBounce = regexp.MustCompile(`(?i)(NXDOMAIN|user unknown|address not found|mailbox not found|address.*reject|does not exist|` +
 		`invalid recipient|no such user|recipient.*invalid|undeliverable|permanent.*failure|permanent.*error|` +
 		`bad.*address|unknown.*user|account.*disabled|address.*disabled)`)
 )
 
-// NewPOP returns a new instance of the POP mailbox client.
-func NewPOP(opt Opt, lo *log.Logger) *POP {
+// NewPOP returns a new instance of the POP mailbox client. The bounce type
+// rules are matched before the SMTP status codes in the messages.
+func NewPOP(opt Opt, rules TypeRules, lo *log.Logger) *POP {
 	return &POP{
-		opt: opt,
+		opt:   opt,
+		rules: rules,
 		client: pop3.New(pop3.Opt{
 			Host:          opt.Host,
 			Port:          opt.Port,
 			TLSEnabled:    opt.TLSEnabled,
 			TLSSkipVerify: opt.TLSSkipVerify,
 		}),
+		q:  newQuarantine(),
 		lo: lo,
 	}
 }
 
 // Scan scans the mailbox and pushes the downloaded messages into the given channel.
-// The messages that are downloaded are deleted from the server. If limit > 0,
-// all messages on the server are downloaded and deleted.
-func (p *POP) Scan(limit int, ch chan models.Bounce) error {
+// Messages sent to signed List-Unsubscribe mailto: addresses are pushed into unsubCh
+// instead, if it's not nil. The messages that are processed are deleted from the server.
+// If limit > 0, at most limit messages are processed in one scan.
+//
+// Messages that are bigger than the size limit, or that fail parsing in several
+// scans, are quarantined: they're skipped by their UIDL and left on the server.
+func (p *POP) Scan(limit int, ch chan models.Bounce, unsubCh chan models.MailtoUnsub) (err error) {
+	defer func() {
+		p.q.scanned(err)
+	}()
+
 	c, err := p.client.NewConn()
 	if err != nil {
 		return err
@@ -98,113 +133,275 @@ func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 
 	// No messages.
 	if count == 0 {
+		p.q.prune(nil)
 		return nil
 	}
 
-	if limit > 0 && count > limit {
-		count = limit
+	// Get the sizes and the unique IDs of the messages. Messages can't be
+	// quarantined if the server doesn't support UIDL.
+	sizes := make(map[int]int, count)
+	if l, err := c.List(0); err != nil {
+		p.lo.Printf("error listing bounce messages: %v", err)
+	} else {
+		for _, m := range l {
+			sizes[m.ID] = m.Size
+		}
+	}
+
+	uids := make(map[int]string, count)
+	if l, err := c.Uidl(0); err != nil {
+		p.lo.Printf("error listing bounce message UIDs. broken messages can't be quarantined: %v", err)
+	} else {
+		all := make(map[string]bool, len(l))
+		for _, m := range l {
+			uids[m.ID] = m.UID
+			all[m.UID] = true
+		}
+		p.q.prune(all)
 	}
 
-	// Download messages.
+	var (
+		maxSize = p.maxMessageSize()
+		del     = make([]int, 0, count)
+		num     = 0
+	)
 	for id := 1; id <= count; id++ {
+		if limit > 0 && num >= limit {
+			break
+		}
+
+		uid := uids[id]
+		if uid != "" && p.q.isQuarantined(uid) {
+			continue
+		}
+		num++
+
+		// Skip messages that are too big to be downloaded.
+		if sizes[id] > maxSize {
+			if uid != "" {
+				p.q.quarantineOversized(uid, sizes[id], maxSize)
+			}
+			p.lo.Printf("skipping bounce message %d (%s): size %d bytes exceeds the limit of %d bytes", id, uid, sizes[id], maxSize)
+			continue
+		}
+
 		// Retrieve the raw bytes of the message.
 		b, err := c.RetrRaw(id)
 		if err != nil {
 			p.lo.Printf("error retrieving bounce message %d: %v", id, err)
 			continue
 		}
+		raw := b.Bytes()
 
-		// Parse the message.
-		m, err := message.Read(b)
+		// Parse the message. Messages that fail parsing are retried in the next scans
+		// until they're quarantined. Without UIDs, they're deleted.
+		msg, err := parseWithTimeout(raw, p.opt.Host, unsubCh != nil, p.rules, parseTimeout)
 		if err != nil {
-			p.lo.Printf("error parsing bounce message %d: %v", id, err)
+			if uid == "" {
+				p.lo.Printf("error parsing bounce message %d. deleting: %v", id, err)
+				del = append(del, id)
+			} else if p.q.fail(uid, raw, err) {
+				p.lo.Printf("error parsing bounce message %d (%s). quarantined after %d attempts: %v", id, uid, maxParseAttempts, err)
+			} else {
+				p.lo.Printf("error parsing bounce message %d (%s): %v", id, uid, err)
+			}
+			continue
+		}
+		p.q.done(uid)
+		del = append(del, id)
+
+		// Is it an unsubscribe request sent to a List-Unsubscribe mailto: address?
+		if msg.unsub != nil {
+			select {
+			case unsubCh <- *msg.unsub:
+			default:
+			}
 			continue
 		}
 
-		h := m
+		select {
+		case ch <- msg.bounce:
+		default:
+		}
+	}
 
-		// If this is a multipart message, find the last part.
-		if mr := m.MultipartReader(); mr != nil {
-			for {
-				part, err := mr.NextPart()
-				if err == io.EOF {
-					break
-				} else if err != nil {
-					p.lo.Printf("error reading multipart bounce message %d: %v", id, err)
-					continue
-				}
-				h = part
-			}
+	// Delete the processed messages.
+	for _, id := range del {
+		if err := c.Dele(id); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
+
+// Status returns the status of the scanner and the quarantined messages.
+func (p *POP) Status() Status {
+	return p.q.getStatus()
+}
 
-		// Reset the "unread portion" pointer of the message buffer.
-		// If you don't do this, you can't read the entire body because the pointer will not point to the beginning.
-		b, _ = c.RetrRaw(id)
+// QuarantinedMessage returns the raw copy of a quarantined message by its UID.
+func (p *POP) QuarantinedMessage(uid string) ([]byte, bool) {
+	return p.q.getRaw(uid)
+}
 
-		// Lookup headers in the e-mail. If a header isn't found, fall back to regexp lookups.
-		hdr := make(map[string]string, 7)
-		for _, l := range headerLookups {
-			v := h.Header.Get(l.Header)
+// maxMessageSize returns the size limit of messages in bytes.
+func (p *POP) maxMessageSize() int {
+	if p.opt.MaxMessageSize > 0 {
+		return p.opt.MaxMessageSize * 1024
+	}
 
-			// Not in the header. Try regexp.
-			if v == "" {
-				if m := l.Regexp.FindAllSubmatch(b.Bytes(), -1); m != nil {
-					v = string(m[len(m)-1][1])
-				}
+	return defaultMaxMessageSize * 1024
+}
+
+// parseWithTimeout parses a message in a goroutine and gives up after the
+// given timeout so that a pathological message can't block the scanner.
+// Panics while parsing are recovered and returned as errors.
+func parseWithTimeout(raw []byte, source string, unsub bool, rules TypeRules, timeout time.Duration) (parsedMsg, error) {
+	type result struct {
+		msg parsedMsg
+		err error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				ch <- result{err: fmt.Errorf("panic parsing message: %v", r)}
 			}
+		}()
+
+		m, err := parseMessage(raw, source, unsub, rules)
+		ch <- result{m, err}
+	}()
 
-			hdr[l.Header] = strings.TrimSpace(v)
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case r := <-ch:
+		return r.msg, r.err
+	case <-t.C:
+		return parsedMsg{}, errParseTimeout
+	}
+}
+
+// parseMessage parses a raw bounce message into a bounce, or if unsub is true,
+// an unsubscribe request if it was sent to a signed List-Unsubscribe mailto: address.
+// The bounce type rules are matched before the built-in classification.
+func parseMessage(raw []byte, source string, unsub bool, rules TypeRules) (parsedMsg, error) {
+	m, err := message.Read(bytes.NewReader(raw))
+	if err != nil {
+		return parsedMsg{}, err
+	}
+
+	// Is it an unsubscribe request sent to a List-Unsubscribe mailto: address?
+	if unsub {
+		if u, ok := findUnsubMailto(m); ok {
+			return parsedMsg{unsub: &u}, nil
 		}
+	}
 
-		// Received is a []string header.
-		msgReceived := h.Header.Map()[models.EmailHeaderReceived]
-		if len(msgReceived) == 0 {
-			if u := reHdrReceived.FindAllSubmatch(b.Bytes(), -1); u != nil {
-				for i := range u {
-					msgReceived = append(msgReceived, string(u[i][1]))
-				}
+	h := m
+
+	// If this is a multipart message, find the last part.
+	if mr := m.MultipartReader(); mr != nil {
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return parsedMsg{}, fmt.Errorf("error reading multipart message: %w", err)
 			}
+			h = part
 		}
+	}
 
-		date, _ := time.Parse("Mon, 02 Jan 2006 15:04:05 -0700", hdr[models.EmailHeaderDate])
-		if date.IsZero() {
-			date = time.Now()
-		}
+	// Lookup headers in the e-mail. If a header isn't found, fall back to regexp lookups.
+	hdr := make(map[string]string, 7)
+	for _, l := range headerLookups {
+		v := h.Header.Get(l.Header)
 
-		// Classify the bounce type based on message content.
-		bounceType, bounceReason := classifyBounce(b.Bytes())
+		// Not in the header. Try regexp.
+		if v == "" {
+			if m := l.Regexp.FindAllSubmatch(raw, -1); m != nil {
+				v = string(m[len(m)-1][1])
+			}
+		}
 
-		// Additional bounce e-mail metadata.
-		meta, _ := json.Marshal(bounceMeta{
-			From:           hdr[models.EmailHeaderFrom],
-			Subject:        hdr[models.EmailHeaderSubject],
-			MessageID:      hdr[models.EmailHeaderMessageId],
-			DeliveredTo:    hdr[models.EmailHeaderDeliveredTo],
-			Received:       msgReceived,
-			ClassifyReason: bounceReason,
-		})
+		hdr[l.Header] = strings.TrimSpace(v)
+	}
 
-		select {
-		case ch <- models.Bounce{
-			Type:           bounceType,
-			CampaignUUID:   hdr[models.EmailHeaderCampaignUUID],
-			SubscriberUUID: hdr[models.EmailHeaderSubscriberUUID],
-			Source:         p.opt.Host,
-			CreatedAt:      date,
-			Meta:           meta,
-		}:
-		default:
+	// Received is a []string header.
+	msgReceived := h.Header.Map()[models.EmailHeaderReceived]
+	if len(msgReceived) == 0 {
+		if u := reHdrReceived.FindAllSubmatch(raw, -1); u != nil {
+			for i := range u {
+				msgReceived = append(msgReceived, string(u[i][1]))
+			}
 		}
 	}
 
-	// Delete the downloaded messages.
-	for id := 1; id <= count; id++ {
-		if err := c.Dele(id); err != nil {
-			return err
+	date, _ := time.Parse("Mon, 02 Jan 2006 15:04:05 -0700", hdr[models.EmailHeaderDate])
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	// Classify the bounce type with the custom rules on the diagnostic text, falling
+	// back to the message content.
+	diag := findDiagnostic(raw)
+	bounceType, bounceReason := classifyBounce(raw)
+	if r, ok := rules.match(diag); ok {
+		bounceType, bounceReason = r.Type, fmt.Sprintf("rule=%s", r.Pattern)
+	}
+
+	// The original recipient in a delivery status notification identifies the
+	// subscriber when the message doesn't carry the subscriber UUID header.
+	rcpt, status := findDSN(raw)
+
+	// Additional bounce e-mail metadata.
+	meta, _ := json.Marshal(bounceMeta{
+		From:           hdr[models.EmailHeaderFrom],
+		Subject:        hdr[models.EmailHeaderSubject],
+		MessageID:      hdr[models.EmailHeaderMessageId],
+		DeliveredTo:    hdr[models.EmailHeaderDeliveredTo],
+		Received:       msgReceived,
+		ClassifyReason: bounceReason,
+		Diagnostic:     diag,
+		Recipient:      rcpt,
+		Status:         status,
+	})
+
+	return parsedMsg{bounce: models.Bounce{
+		Type:           bounceType,
+		CampaignUUID:   hdr[models.EmailHeaderCampaignUUID],
+		SubscriberUUID: hdr[models.EmailHeaderSubscriberUUID],
+		Email:          rcpt,
+		Source:         source,
+		CreatedAt:      date,
+		Meta:           meta,
+	}}, nil
+}
+
+// findUnsubMailto looks for a signed unsubscribe address in the recipient headers of a message.
+func findUnsubMailto(m *message.Entity) (models.MailtoUnsub, bool) {
+	for _, hdr := range unsubRecipientHeaders {
+		for _, v := range m.Header.Map()[hdr] {
+			campUUID, subUUID, sig, ok := utils.FindUnsubMailto(v)
+			if !ok {
+				continue
+			}
+
+			return models.MailtoUnsub{
+				CampaignUUID:   campUUID,
+				SubscriberUUID: subUUID,
+				Signature:      sig,
+				From:           m.Header.Get(models.EmailHeaderFrom),
+			}, true
 		}
 	}
 
-	return nil
+	return models.MailtoUnsub{}, false
 }
 
 // classifyBounce analyzes the bounce message content and determines if it's a hard or soft bounce.
@@ -238,3 +435,43 @@ func classifyBounce(b []byte) (string, string) {
 
 	return models.BounceTypeSoft, "default"
 }
+
+// findDiagnostic returns the remote server's response in the Diagnostic-Code of a
+// delivery status notification, if there's one, for classifying the bounce reason.
+func findDiagnostic(b []byte) string {
+	m := reDiagnostic.FindSubmatch(b)
+	if m == nil {
+		return ""
+	}
+
+	d := strings.TrimSpace(string(m[1]))
+	if len(d) > 500 {
+		d = d[:500]
+	}
+
+	return d
+}
+
+// findDSN returns the recipient address and the status code in an RFC 3464 delivery
+// status notification, if the message is one. The Original-Recipient, which is the
+// address the message was sent to, is preferred over the Final-Recipient, which
+// may have been rewritten by the receiving server.
+func findDSN(b []byte) (string, string) {
+	var rcpt string
+	for _, m := range reDSNRecipient.FindAllSubmatch(b, -1) {
+		if strings.EqualFold(string(m[1]), "original") {
+			rcpt = strings.ToLower(string(m[2]))
+			break
+		}
+		if rcpt == "" {
+			rcpt = strings.ToLower(string(m[2]))
+		}
+	}
+
+	var status string
+	if m := reDSNStatus.FindSubmatch(b); m != nil {
+		status = string(m[1])
+	}
+
+	return rcpt, status
+}
@@ -0,0 +1,214 @@
+package mailbox
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxMessageSize is the size limit (KB) of messages that are downloaded
+	// and parsed when one isn't configured.
+	defaultMaxMessageSize = 10 * 1024
+
+	// parseTimeout is the time after which parsing a message is abandoned.
+	parseTimeout = 30 * time.Second
+
+	// maxParseAttempts is the number of scans in which a message has to fail
+	// parsing before it's quarantined.
+	maxParseAttempts = 3
+
+	// maxQuarantineCopies is the number of quarantined messages whose raw copies
+	// are retained in memory for debugging.
+	maxQuarantineCopies = 50
+)
+
+var errParseTimeout = errors.New("timed out parsing message")
+
+// Status represents the state of a mailbox scanner.
+type Status struct {
+	Enabled    bool       `json:"enabled"`
+	LastScanAt *time.Time `json:"last_scan_at"`
+	LastError  string     `json:"last_error"`
+
+	// Counters since the scanner started.
+	Processed   int `json:"processed"`
+	Failed      int `json:"failed"`
+	Quarantined int `json:"quarantined"`
+
+	// Messages that are currently quarantined.
+	Quarantine []QuarantinedMessage `json:"quarantine"`
+}
+
+// QuarantinedMessage represents a message in the mailbox that's skipped by the
+// scanner as it's too big or it repeatedly failed parsing. Quarantined messages
+// are left on the server.
+type QuarantinedMessage struct {
+	UID           string    `json:"uid"`
+	Size          int       `json:"size"`
+	Reason        string    `json:"reason"`
+	Attempts      int       `json:"attempts"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+	HasCopy       bool      `json:"has_copy"`
+
+	raw []byte
+}
+
+// quarantine keeps track of messages that fail parsing across scans, by their
+// unique (UIDL) IDs, and of the messages that are quarantined. It only lives in
+// memory, so after a restart, broken messages are retried before they're
+// quarantined again.
+type quarantine struct {
+	sync.Mutex
+
+	failures map[string]int
+	msgs     map[string]*QuarantinedMessage
+	order    []string
+
+	status Status
+}
+
+func newQuarantine() *quarantine {
+	return &quarantine{
+		failures: make(map[string]int),
+		msgs:     make(map[string]*QuarantinedMessage),
+	}
+}
+
+// isQuarantined checks whether the message with the given UID is quarantined.
+func (q *quarantine) isQuarantined(uid string) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	_, ok := q.msgs[uid]
+	return ok
+}
+
+// fail records a parsing failure of a message and quarantines it once it has
+// failed maxParseAttempts times. It returns true if the message was quarantined.
+func (q *quarantine) fail(uid string, raw []byte, err error) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	q.status.Failed++
+	q.failures[uid]++
+	if q.failures[uid] < maxParseAttempts {
+		return false
+	}
+
+	q.add(uid, len(raw), raw, fmt.Sprintf("parse failed %d times: %v", q.failures[uid], err))
+	return true
+}
+
+// quarantineOversized quarantines a message that's bigger than the size limit
+// without downloading it.
+func (q *quarantine) quarantineOversized(uid string, size, limit int) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.add(uid, size, nil, fmt.Sprintf("size %d bytes exceeds the limit of %d bytes", size, limit))
+}
+
+// add adds a message to the quarantine. Only the raw copies of the latest
+// maxQuarantineCopies messages are retained. The lock should be held.
+func (q *quarantine) add(uid string, size int, raw []byte, reason string) {
+	q.msgs[uid] = &QuarantinedMessage{
+		UID:           uid,
+		Size:          size,
+		Reason:        reason,
+		Attempts:      q.failures[uid],
+		QuarantinedAt: time.Now(),
+		HasCopy:       raw != nil,
+		raw:           raw,
+	}
+	delete(q.failures, uid)
+	q.order = append(q.order, uid)
+	q.status.Quarantined++
+
+	// Drop the oldest copies.
+	n := 0
+	for i := len(q.order) - 1; i >= 0; i-- {
+		m, ok := q.msgs[q.order[i]]
+		if !ok || m.raw == nil {
+			continue
+		}
+		if n++; n > maxQuarantineCopies {
+			m.raw = nil
+			m.HasCopy = false
+		}
+	}
+}
+
+// done clears the failure count of a message that was parsed.
+func (q *quarantine) done(uid string) {
+	q.Lock()
+	defer q.Unlock()
+
+	delete(q.failures, uid)
+	q.status.Processed++
+}
+
+// prune drops the messages that are no longer on the server, eg: deleted
+// manually, given the UIDs of the messages on the server.
+func (q *quarantine) prune(uids map[string]bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	for uid := range q.failures {
+		if !uids[uid] {
+			delete(q.failures, uid)
+		}
+	}
+
+	order := q.order[:0]
+	for _, uid := range q.order {
+		if !uids[uid] {
+			delete(q.msgs, uid)
+			continue
+		}
+		order = append(order, uid)
+	}
+	q.order = order
+}
+
+// scanned records the result of a scan.
+func (q *quarantine) scanned(err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	now := time.Now()
+	q.status.LastScanAt = &now
+	q.status.LastError = ""
+	if err != nil {
+		q.status.LastError = err.Error()
+	}
+}
+
+// getStatus returns the status of the scanner and the quarantined messages.
+func (q *quarantine) getStatus() Status {
+	q.Lock()
+	defer q.Unlock()
+
+	out := q.status
+	out.Enabled = true
+	out.Quarantine = make([]QuarantinedMessage, 0, len(q.order))
+	for _, uid := range q.order {
+		out.Quarantine = append(out.Quarantine, *q.msgs[uid])
+	}
+
+	return out
+}
+
+// getRaw returns the raw copy of a quarantined message.
+func (q *quarantine) getRaw(uid string) ([]byte, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	m, ok := q.msgs[uid]
+	if !ok || m.raw == nil {
+		return nil, false
+	}
+
+	return m.raw, true
+}
@@ -0,0 +1,191 @@
+package mailbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+func TestParseWithTimeout(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		wantErr string
+		typ     string
+		subUUID string
+	}{
+		{"valid delivery status notification", "dsn.eml", "", models.BounceTypeHard, "8b6a4f9a-1b2c-4d3e-9f8a-7b6c5d4e3f2a"},
+		{"unterminated multipart", "unterminated-multipart.eml", "NextPart: EOF", "", ""},
+		{"malformed header line", "malformed-header.eml", "malformed MIME header line", "", ""},
+		{"multipart without a boundary", "missing-boundary.eml", "boundary is empty", "", ""},
+		{"invalid transfer encoding", "bad-encoding.eml", "", models.BounceTypeSoft, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			raw, err := os.ReadFile(filepath.Join("testdata", c.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := parseWithTimeout(raw, "pop", false, nil, 5*time.Second)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if m.bounce.Type != c.typ {
+				t.Errorf("expected type %q, got %q", c.typ, m.bounce.Type)
+			}
+			if m.bounce.SubscriberUUID != c.subUUID {
+				t.Errorf("expected subscriber %q, got %q", c.subUUID, m.bounce.SubscriberUUID)
+			}
+		})
+	}
+}
+
+func TestParseWithTimeoutExpires(t *testing.T) {
+	// A message with a very large number of parts takes far longer than the timeout to parse.
+	var b bytes.Buffer
+	b.WriteString("Subject: Undelivered\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"b\"\r\n\r\n")
+	for i := range 200000 {
+		fmt.Fprintf(&b, "--b\r\nContent-Type: text/plain\r\n\r\npart %d\r\n", i)
+	}
+	b.WriteString("--b--\r\n")
+
+	if _, err := parseWithTimeout(b.Bytes(), "pop", false, nil, time.Nanosecond); !errors.Is(err, errParseTimeout) {
+		t.Fatalf("expected %v, got %v", errParseTimeout, err)
+	}
+}
+
+func TestQuarantineFail(t *testing.T) {
+	errParse := errors.New("broken MIME")
+
+	cases := []struct {
+		name            string
+		failures        int
+		wantQuarantined bool
+	}{
+		{"single failure is retried", 1, false},
+		{"failures below the limit are retried", maxParseAttempts - 1, false},
+		{"failures at the limit are quarantined", maxParseAttempts, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			q := newQuarantine()
+
+			var quarantined bool
+			for range c.failures {
+				quarantined = q.fail("uid1", []byte("raw"), errParse)
+			}
+
+			if quarantined != c.wantQuarantined || q.isQuarantined("uid1") != c.wantQuarantined {
+				t.Fatalf("expected quarantined=%v, got %v", c.wantQuarantined, quarantined)
+			}
+
+			st := q.getStatus()
+			if st.Failed != c.failures {
+				t.Errorf("expected %d failures, got %d", c.failures, st.Failed)
+			}
+			if c.wantQuarantined {
+				if st.Quarantined != 1 || len(st.Quarantine) != 1 {
+					t.Fatalf("expected 1 quarantined message, got %d", st.Quarantined)
+				}
+				if raw, ok := q.getRaw("uid1"); !ok || string(raw) != "raw" {
+					t.Errorf("expected the raw copy of the quarantined message")
+				}
+			}
+		})
+	}
+}
+
+func TestQuarantineDoneResetsFailures(t *testing.T) {
+	q := newQuarantine()
+	errParse := errors.New("broken MIME")
+
+	// A message that's parsed successfully in between failures starts over.
+	for range maxParseAttempts - 1 {
+		q.fail("uid1", nil, errParse)
+	}
+	q.done("uid1")
+	if q.fail("uid1", nil, errParse) {
+		t.Fatal("expected the failure count to be reset after a successful parse")
+	}
+	if st := q.getStatus(); st.Processed != 1 {
+		t.Errorf("expected 1 processed message, got %d", st.Processed)
+	}
+}
+
+func TestQuarantineOversized(t *testing.T) {
+	q := newQuarantine()
+	q.quarantineOversized("big", 60<<20, defaultMaxMessageSize*1024)
+
+	if !q.isQuarantined("big") {
+		t.Fatal("expected the oversized message to be quarantined")
+	}
+	if _, ok := q.getRaw("big"); ok {
+		t.Error("expected no raw copy of an oversized message")
+	}
+	if st := q.getStatus(); st.Quarantine[0].Size != 60<<20 {
+		t.Errorf("expected the size to be recorded, got %d", st.Quarantine[0].Size)
+	}
+}
+
+func TestQuarantinePrune(t *testing.T) {
+	q := newQuarantine()
+	q.quarantineOversized("gone", 1, 0)
+	q.quarantineOversized("kept", 1, 0)
+	q.fail("failing", nil, errors.New("broken MIME"))
+
+	q.prune(map[string]bool{"kept": true})
+
+	if q.isQuarantined("gone") {
+		t.Error("expected the message that's no longer on the server to be dropped")
+	}
+	if !q.isQuarantined("kept") {
+		t.Error("expected the message on the server to remain quarantined")
+	}
+	if _, ok := q.failures["failing"]; ok {
+		t.Error("expected the failure count of the message no longer on the server to be dropped")
+	}
+}
+
+func TestQuarantineCopiesLimit(t *testing.T) {
+	q := newQuarantine()
+	for i := range maxQuarantineCopies + 5 {
+		uid := fmt.Sprintf("uid%d", i)
+		for range maxParseAttempts {
+			q.fail(uid, []byte(uid), errors.New("broken MIME"))
+		}
+	}
+
+	copies := 0
+	for _, m := range q.getStatus().Quarantine {
+		if m.HasCopy {
+			copies++
+		}
+	}
+	if copies != maxQuarantineCopies {
+		t.Errorf("expected %d copies, got %d", maxQuarantineCopies, copies)
+	}
+
+	// The oldest copies are dropped.
+	if _, ok := q.getRaw("uid0"); ok {
+		t.Error("expected the copy of the oldest message to be dropped")
+	}
+	if _, ok := q.getRaw(fmt.Sprintf("uid%d", maxQuarantineCopies+4)); !ok {
+		t.Error("expected the copy of the latest message to be retained")
+	}
+}
@@ -0,0 +1,58 @@
+package mailbox
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// TypeRule classifies the bounces whose diagnostic texts (the remote server's
+// response in the Diagnostic-Code of delivery status notifications) match a
+// case-insensitive regexp as the given type, before the SMTP status codes.
+type TypeRule struct {
+	Pattern string `json:"pattern"`
+	Type    string `json:"type"`
+}
+
+// TypeRules are compiled bounce type rules that are matched in order.
+type TypeRules []typeRule
+
+type typeRule struct {
+	rule TypeRule
+	re   *regexp.Regexp
+}
+
+// NewTypeRules validates and compiles the given bounce type rules.
+func NewTypeRules(rules []TypeRule) (TypeRules, error) {
+	out := make(TypeRules, 0, len(rules))
+	for _, r := range rules {
+		if r.Type != models.BounceTypeHard && r.Type != models.BounceTypeSoft && r.Type != models.BounceTypeComplaint {
+			return nil, fmt.Errorf("unknown bounce type: %s", r.Type)
+		}
+
+		re, err := regexp.Compile("(?i)" + r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for bounce type %s: %v", r.Type, err)
+		}
+
+		out = append(out, typeRule{rule: r, re: re})
+	}
+
+	return out, nil
+}
+
+// match returns the first rule that matches the diagnostic text.
+func (rs TypeRules) match(diag string) (TypeRule, bool) {
+	if diag == "" {
+		return TypeRule{}, false
+	}
+
+	for _, r := range rs {
+		if r.re.MatchString(diag) {
+			return r.rule, true
+		}
+	}
+
+	return TypeRule{}, false
+}
@@ -0,0 +1,97 @@
+package bounce
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// ReasonRule maps the bounces whose texts (the raw metadata from the
+// source) match a case-insensitive regexp to a normalized reason.
+type ReasonRule struct {
+	Reason  string `json:"reason"`
+	Pattern string `json:"pattern"`
+}
+
+type reasonRule struct {
+	reason string
+	re     *regexp.Regexp
+}
+
+// statusCodes returns a pattern that matches the given SMTP enhanced status codes
+// (eg: 1.1 for 5.1.1 and 4.1.1, x for any number) without matching parts of IP
+// addresses or version numbers.
+func statusCodes(codes ...string) string {
+	for i, c := range codes {
+		c = strings.ReplaceAll(c, ".", `\.`)
+		codes[i] = strings.ReplaceAll(c, "x", `\d+`)
+	}
+
+	return `(?:^|[^\d.])[45]\.(?:` + strings.Join(codes, "|") + `)(?:[^\d.]|$)`
+}
+
+// defaultReasonRules are the built-in rules that are matched in order. The
+// status codes that are specific to a reason are matched before provider texts.
+// Generic policy rejections (5.7.x) that don't match anything else are
+// attributed to the sender's reputation.
+var defaultReasonRules = []ReasonRule{
+	{models.BounceReasonDMARCPolicy, statusCodes("7.20", "7.21", "7.22", "7.23", "7.24", "7.26", "7.509")},
+	{models.BounceReasonInvalidRecipient, statusCodes("1.0", "1.1", "1.2", "1.3", "1.6", "1.10", "2.1")},
+	{models.BounceReasonMailboxFull, statusCodes("2.2", "3.1")},
+	{models.BounceReasonContentRejected, statusCodes("2.3", "3.4", "6.x")},
+
+	{models.BounceReasonDMARCPolicy, `dmarc|unauthenticated|not authenticated|authentication (?:check )?fail|(?:spf|dkim) (?:check )?fail`},
+	{models.BounceReasonInvalidRecipient, `user unknown|unknown (?:user|recipient)|no such (?:user|mailbox|recipient|address)|` +
+		`(?:user|mailbox|recipient|address|account) (?:not found|does not exist|doesn't exist|is disabled|has been disabled)|` +
+		`invalid (?:recipient|mailbox|address)|(?:recipient|address) rejected|nxdomain|"noemail"`},
+	{models.BounceReasonMailboxFull, `mailbox ?(?:is )?full|over ?quota|quota exceeded|exceeded (?:storage|quota)|insufficient (?:storage|space)`},
+	{models.BounceReasonContentRejected, `content ?rejected|looks like spam|detected as spam|spam content|virus|malware|` +
+		`(?:message|attachment) (?:is )?too (?:large|big)|messagetoolarge|size limit`},
+	{models.BounceReasonReputationBlock, `block ?list|black ?list|spamhaus|spamcop|barracuda|reputation|\brbl\b|\bdnsbl\b|` +
+		`blocked|listed (?:at|on|in)`},
+	{models.BounceReasonReputationBlock, statusCodes("7.x")},
+}
+
+// Classifier maps bounces to normalized reasons based on the SMTP enhanced status
+// codes and the common provider texts in their metadata.
+type Classifier struct {
+	rules []reasonRule
+}
+
+// NewClassifier returns a bounce classifier. The given rules are matched before
+// the built-in ones, so that they can extend or override them.
+func NewClassifier(rules []ReasonRule) (*Classifier, error) {
+	c := &Classifier{}
+	for _, r := range append(slices.Clone(rules), defaultReasonRules...) {
+		if !slices.Contains(models.BounceReasons, r.Reason) {
+			return nil, fmt.Errorf("unknown bounce reason: %s", r.Reason)
+		}
+
+		re, err := regexp.Compile("(?i)" + r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for bounce reason %s: %v", r.Reason, err)
+		}
+
+		c.rules = append(c.rules, reasonRule{reason: r.Reason, re: re})
+	}
+
+	return c, nil
+}
+
+// Classify returns the normalized reason of a bounce.
+func (c *Classifier) Classify(b models.Bounce) string {
+	if len(b.Meta) == 0 {
+		return models.BounceReasonOther
+	}
+
+	for _, r := range c.rules {
+		if r.re.Match(b.Meta) {
+			return r.reason
+		}
+	}
+
+	return models.BounceReasonOther
+}
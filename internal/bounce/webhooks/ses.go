@@ -46,15 +46,23 @@ type sesNotif struct {
 
 type sesTimestamp time.Time
 
+// sesMail is an SES notification. Identity notifications have notificationType
+// and configuration set event notifications have eventType.
 type sesMail struct {
 	EventType string `json:"eventType"`
 	NotifType string `json:"notificationType"`
 	Bounce    struct {
 		BounceType        string `json:"bounceType"`
 		BouncedRecipients []struct {
-			Status string `json:"status"`
+			EmailAddress string `json:"emailAddress"`
+			Status       string `json:"status"`
 		} `json:"bouncedRecipients"`
 	} `json:"bounce"`
+	Complaint struct {
+		ComplainedRecipients []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
 	Mail struct {
 		Timestamp        sesTimestamp        `json:"timestamp"`
 		HeadersTruncated bool                `json:"headersTruncated"`
@@ -106,72 +114,101 @@ func (s *SES) ProcessSubscription(b []byte) error {
 	return nil
 }
 
-// ProcessBounce processes an SES bounce notification and returns a Bounce object.
-func (s *SES) ProcessBounce(b []byte) (models.Bounce, error) {
-	var (
-		bounce models.Bounce
-		n      sesNotif
-	)
+// ProcessBounce processes an SES bounce or complaint notification, either of an
+// identity or of a configuration set, and returns a Bounce for every recipient in it.
+func (s *SES) ProcessBounce(b []byte) ([]models.Bounce, error) {
+	var n sesNotif
 	if err := json.Unmarshal(b, &n); err != nil {
-		return bounce, fmt.Errorf("error unmarshalling SES notification: %v", err)
+		return nil, fmt.Errorf("error unmarshalling SES notification: %v", err)
 	}
 	if err := s.verifyNotif(n); err != nil {
-		return bounce, err
+		return nil, err
 	}
 
 	var m sesMail
 	if err := json.Unmarshal([]byte(n.Message), &m); err != nil {
-		return bounce, fmt.Errorf("error unmarshalling SES notification: %v", err)
+		return nil, fmt.Errorf("error unmarshalling SES notification: %v", err)
 	}
 
-	if (m.EventType != "" && m.EventType != "Bounce") ||
-		(m.NotifType != "" && (m.NotifType != "Bounce" && m.NotifType != "Complaint")) {
-		return bounce, errors.New("notification type is not bounce")
+	notifType := m.EventType
+	if notifType == "" {
+		notifType = m.NotifType
+	}
+	if notifType != "Bounce" && notifType != "Complaint" {
+		return nil, errors.New("notification type is not bounce")
 	}
 
-	if len(m.Mail.Destination) == 0 {
-		return bounce, errors.New("no destination e-mails found in SES notification")
+	// Look for the campaign and subscriber UUIDs in headers.
+	var campUUID, subUUID string
+	if !m.Mail.HeadersTruncated {
+		for _, h := range m.Mail.Headers {
+			switch h["name"] {
+			case models.EmailHeaderCampaignUUID:
+				campUUID = h["value"]
+			case models.EmailHeaderSubscriberUUID:
+				subUUID = h["value"]
+			}
+		}
 	}
 
-	typ := models.BounceTypeSoft
-	if m.Bounce.BounceType == "Permanent" {
-		typ = models.BounceTypeHard
+	// The recipients that bounced or complained along with the bounce types.
+	type rcpt struct {
+		email string
+		typ   string
 	}
-	if m.Bounce.BounceType == "Transient" && len(m.Bounce.BouncedRecipients) > 0 {
-		// "Invalid domain" bounce.
-		if m.Bounce.BouncedRecipients[0].Status == "5.4.4" {
-			typ = models.BounceTypeHard
+	var rcpts []rcpt
+	if notifType == "Complaint" {
+		for _, r := range m.Complaint.ComplainedRecipients {
+			rcpts = append(rcpts, rcpt{r.EmailAddress, models.BounceTypeComplaint})
+		}
+	} else {
+		for _, r := range m.Bounce.BouncedRecipients {
+			typ := models.BounceTypeSoft
+			if m.Bounce.BounceType == "Permanent" {
+				typ = models.BounceTypeHard
+			}
+			// "Invalid domain" bounce.
+			if m.Bounce.BounceType == "Transient" && r.Status == "5.4.4" {
+				typ = models.BounceTypeHard
+			}
+			rcpts = append(rcpts, rcpt{r.EmailAddress, typ})
 		}
 	}
-	if m.NotifType == "Complaint" {
-		typ = models.BounceTypeComplaint
+
+	// Fall back to the destination of the message.
+	if len(rcpts) == 0 {
+		if len(m.Mail.Destination) == 0 {
+			return nil, errors.New("no destination e-mails found in SES notification")
+		}
+
+		typ := models.BounceTypeSoft
+		if notifType == "Complaint" {
+			typ = models.BounceTypeComplaint
+		} else if m.Bounce.BounceType == "Permanent" {
+			typ = models.BounceTypeHard
+		}
+		rcpts = append(rcpts, rcpt{m.Mail.Destination[0], typ})
 	}
 
-	// Look for the campaign ID in headers.
-	campUUID := ""
-	if !m.Mail.HeadersTruncated {
-		for _, h := range m.Mail.Headers {
-			key, ok := h["name"]
-			if !ok || key != models.EmailHeaderCampaignUUID {
-				continue
-			}
+	out := make([]models.Bounce, 0, len(rcpts))
+	for _, r := range rcpts {
+		b := models.Bounce{
+			Email:        strings.ToLower(strings.TrimSpace(r.email)),
+			CampaignUUID: campUUID,
+			Type:         r.typ,
+			Source:       "ses",
+			Meta:         json.RawMessage(n.Message),
+			CreatedAt:    time.Time(m.Mail.Timestamp),
+		}
 
-			campUUID, ok = h["value"]
-			if !ok {
-				continue
-			}
-			break
+		// The subscriber header only identifies the recipient of a message sent to one recipient.
+		if len(rcpts) == 1 {
+			b.SubscriberUUID = subUUID
 		}
+		out = append(out, b)
 	}
 
-	return models.Bounce{
-		Email:        strings.ToLower(m.Mail.Destination[0]),
-		CampaignUUID: campUUID,
-		Type:         typ,
-		Source:       "ses",
-		Meta:         json.RawMessage(n.Message),
-		CreatedAt:    time.Time(m.Mail.Timestamp),
-	}, nil
+	return out, nil
 }
 
 func (s *SES) buildSignature(n sesNotif) []byte {
@@ -0,0 +1,191 @@
+// Package campgate implements external approval gates that campaigns have to
+// pass before they are sent. The campaign's summary is POSTed to the gate URL
+// as JSON, and the gate has to respond with 200 and a signed JSON body,
+// {"approved": true|false, "reason": "..."}. Both the request and the response
+// are signed with an HMAC-SHA256 of the body using a shared secret, which is
+// sent hex encoded in the SignatureHeader.
+package campgate
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// SignatureHeader is the HTTP header that carries the body signature.
+const SignatureHeader = "X-Listmonk-Signature"
+
+// maxRespSize is the maximum size of a gate response body that's read.
+const maxRespSize = 64 * 1024
+
+var (
+	ErrNotAllowed   = errors.New("gate URL is not in the allowlist")
+	ErrBadSignature = errors.New("gate response signature is invalid")
+)
+
+// Opt represents the gate options.
+type Opt struct {
+	// URLs is the allowlist of gate URLs that campaigns can use.
+	URLs []string
+
+	// Secret is the shared secret with which requests and responses are signed.
+	Secret string
+
+	// Timeout is the timeout for a gate request.
+	Timeout time.Duration
+}
+
+// Gate checks campaigns against external approval gates.
+type Gate struct {
+	list   []string
+	urls   map[string]struct{}
+	secret []byte
+	http   *http.Client
+}
+
+// Summary is the campaign summary that's posted to a gate.
+type Summary struct {
+	ID        int             `json:"id"`
+	UUID      string          `json:"uuid"`
+	Name      string          `json:"name"`
+	Subject   string          `json:"subject"`
+	FromEmail string          `json:"from_email"`
+	Lists     json.RawMessage `json:"lists"`
+	Tags      []string        `json:"tags"`
+	Body      string          `json:"body"`
+	SendAt    null.Time       `json:"send_at"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// Result is the response of a gate.
+type Result struct {
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason"`
+}
+
+// New returns a new Gate.
+func New(o Opt) *Gate {
+	var (
+		list = make([]string, 0, len(o.URLs))
+		urls = make(map[string]struct{}, len(o.URLs))
+	)
+	for _, u := range o.URLs {
+		if u = strings.TrimSpace(u); u != "" {
+			list = append(list, u)
+			urls[u] = struct{}{}
+		}
+	}
+
+	return &Gate{
+		list:   list,
+		urls:   urls,
+		secret: []byte(o.Secret),
+		http:   &http.Client{Timeout: o.Timeout},
+	}
+}
+
+// URLs returns the allowlisted gate URLs.
+func (g *Gate) URLs() []string {
+	if g == nil {
+		return []string{}
+	}
+
+	return g.list
+}
+
+// Allowed checks whether the given URL is in the gate allowlist.
+func (g *Gate) Allowed(url string) bool {
+	if g == nil {
+		return false
+	}
+
+	_, ok := g.urls[url]
+	return ok
+}
+
+// Check posts the summary of the given campaign to its gate and returns the gate's
+// result. Timeouts, non-200 responses, and invalid signatures are returned as errors.
+func (g *Gate) Check(c models.Campaign) (Result, error) {
+	if !g.Allowed(c.GateURL) {
+		return Result{}, ErrNotAllowed
+	}
+
+	s := Summary{
+		ID:        c.ID,
+		UUID:      c.UUID,
+		Name:      c.Name,
+		Subject:   c.Subject,
+		FromEmail: c.FromEmail,
+		Lists:     json.RawMessage(c.Lists),
+		Tags:      c.Tags,
+		Body:      c.Body,
+		SendAt:    c.SendAt,
+		Timestamp: time.Now(),
+	}
+	if len(s.Lists) == 0 {
+		s.Lists = json.RawMessage("[]")
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return Result{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.GateURL, bytes.NewReader(b))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, g.sign(b))
+
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRespSize))
+	if err != nil {
+		return Result{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("gate returned %s", resp.Status)
+	}
+
+	// Verify the response signature.
+	sig, err := hex.DecodeString(resp.Header.Get(SignatureHeader))
+	if err != nil || !hmac.Equal(sig, g.mac(body)) {
+		return Result{}, ErrBadSignature
+	}
+
+	var out Result
+	if err := json.Unmarshal(body, &out); err != nil {
+		return Result{}, fmt.Errorf("invalid gate response: %v", err)
+	}
+
+	return out, nil
+}
+
+// sign returns the hex encoded signature of the given body.
+func (g *Gate) sign(b []byte) string {
+	return hex.EncodeToString(g.mac(b))
+}
+
+// mac returns the HMAC-SHA256 of the given body.
+func (g *Gate) mac(b []byte) []byte {
+	h := hmac.New(sha256.New, g.secret)
+	h.Write(b)
+	return h.Sum(nil)
+}
@@ -0,0 +1,484 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"gopkg.in/volatiletech/null.v6"
+)
+
+// disengagedBuckets are the upper boundaries (days) of the buckets in the disengaged
+// subscribers report. The first bucket starts at the report's inactive days.
+var disengagedBuckets = []int{180, 365}
+
+// heatmapCacheTTL is the duration for which a computed engagement heatmap
+// is served from memory before it's queried from the DB again.
+const heatmapCacheTTL = time.Minute * 5
+
+const (
+	// predictionUnsubDays is the number of days after a campaign started within which
+	// unsubscriptions from its lists are attributed to it.
+	predictionUnsubDays = 7
+
+	// predictionDecay is the factor by which the weight of each successively older
+	// comparable campaign is reduced.
+	predictionDecay = 0.85
+
+	// predictionZ is the z-score for the 95% confidence intervals of predictions.
+	predictionZ = 1.96
+)
+
+// heatmapCache is a short-lived in-memory cache of engagement heatmaps
+// keyed by the query params.
+type heatmapCache struct {
+	m  map[string]models.EngagementHeatmap
+	mu sync.Mutex
+}
+
+// GetEngagementHeatmap returns the unique subscriber views and clicks over the last
+// n days aggregated by day-of-week and hour-of-day in the given timezone.
+// If listID > 0, only campaigns sent to the list are considered.
+func (c *Core) GetEngagementHeatmap(days int, tz string, listID int) (models.EngagementHeatmap, error) {
+	key := fmt.Sprintf("%d:%s:%d", days, tz, listID)
+
+	c.heatmaps.mu.Lock()
+	if out, ok := c.heatmaps.m[key]; ok && time.Since(out.UpdatedAt) < heatmapCacheTTL {
+		c.heatmaps.mu.Unlock()
+		return out, nil
+	}
+	c.heatmaps.mu.Unlock()
+
+	var res []models.HeatmapCount
+	if err := c.q.GetEngagementHeatmap.Select(&res, days, tz, listID); err != nil {
+		c.log.Printf("error fetching engagement heatmap: %v", err)
+		return models.EngagementHeatmap{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	out := models.EngagementHeatmap{
+		Days:      days,
+		Timezone:  tz,
+		ListID:    listID,
+		UpdatedAt: time.Now(),
+	}
+	for _, r := range res {
+		if r.DOW < 0 || r.DOW > 6 || r.Hour < 0 || r.Hour > 23 {
+			continue
+		}
+
+		switch r.Type {
+		case "views":
+			out.Views[r.DOW][r.Hour] = r.Count
+		case "clicks":
+			out.Clicks[r.DOW][r.Hour] = r.Count
+		}
+	}
+
+	c.heatmaps.mu.Lock()
+	// Evict stale entries so that arbitrary param combinations don't accumulate.
+	for k, v := range c.heatmaps.m {
+		if time.Since(v.UpdatedAt) >= heatmapCacheTTL {
+			delete(c.heatmaps.m, k)
+		}
+	}
+	c.heatmaps.m[key] = out
+	c.heatmaps.mu.Unlock()
+
+	return out, nil
+}
+
+// GetSubscriberCohorts returns the cumulative open (metric = "open_rate") or click
+// ("click_rate") rates of the weekly cohorts of the subscribers who subscribed (to the
+// given list, or to listmonk if listID is 0) in the last n weeks.
+func (c *Core) GetSubscriberCohorts(weeks, listID int, metric string) (models.SubscriberCohorts, error) {
+	var res []models.CohortCount
+	if err := c.q.GetSubscriberCohorts.Select(&res, weeks, listID); err != nil {
+		c.log.Printf("error fetching subscriber cohorts: %v", err)
+		return models.SubscriberCohorts{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	var (
+		now = time.Now()
+		out = models.SubscriberCohorts{
+			By:        "subscribe_week",
+			Metric:    metric,
+			Weeks:     weeks,
+			ListID:    listID,
+			Cohorts:   []models.Cohort{},
+			Matrix:    [][]null.Float64{},
+			UpdatedAt: now,
+		}
+	)
+
+	// The rows are ordered by cohort and week.
+	for i := 0; i < len(res); {
+		var (
+			co = res[i].Cohort
+
+			// Weeks elapsed since the cohort's week, including the current one.
+			n = max(int(now.Sub(co).Hours()/(24*7)), 0) + 1
+
+			row           = make([]null.Float64, n)
+			sent, matched int
+		)
+		out.Cohorts = append(out.Cohorts, models.Cohort{Week: co, Size: res[i].Size})
+
+		j := i
+		for w := range n {
+			for ; j < len(res) && res[j].Cohort.Equal(co) && res[j].Week <= w; j++ {
+				if res[j].Week < 0 {
+					continue
+				}
+
+				sent += res[j].Sent
+				if metric == "click_rate" {
+					matched += res[j].Clicks
+				} else {
+					matched += res[j].Views
+				}
+			}
+
+			if sent > 0 {
+				row[w] = null.Float64From(math.Round(float64(matched)/float64(sent)*10000) / 10000)
+			}
+		}
+		out.Matrix = append(out.Matrix, row)
+
+		// Skip to the next cohort.
+		for j < len(res) && res[j].Cohort.Equal(co) {
+			j++
+		}
+		i = j
+	}
+
+	return out, nil
+}
+
+// GetSendFrequency returns the number of campaigns sent each week in the last n days
+// (to the given list, or to any list if listID is 0) along with the unsubscribe rates
+// in those weeks and the correlation between the two.
+func (c *Core) GetSendFrequency(days, listID int) (models.SendFrequency, error) {
+	res := []models.SendFrequencyWeek{}
+	if err := c.q.GetSendFrequency.Select(&res, days, listID); err != nil {
+		c.log.Printf("error fetching send frequency: %v", err)
+		return models.SendFrequency{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	var (
+		sent  = make([]float64, len(res))
+		rates = make([]float64, len(res))
+	)
+	for i, w := range res {
+		if w.Subscriptions > 0 {
+			res[i].UnsubscribeRate = math.Round(float64(w.Unsubscribes)*10000/float64(w.Subscriptions)) / 100
+		}
+		sent[i] = float64(w.CampaignsSent)
+		rates[i] = res[i].UnsubscribeRate
+	}
+
+	out := models.SendFrequency{
+		Days:   days,
+		ListID: listID,
+		Weeks:  res,
+	}
+	if r, ok := correlation(sent, rates); ok {
+		out.Correlation = null.Float64From(math.Round(r*10000) / 10000)
+	}
+
+	return out, nil
+}
+
+// correlation returns the Pearson correlation coefficient of x and y. It's not
+// defined for fewer than three values or if either of them doesn't vary.
+func correlation(x, y []float64) (float64, bool) {
+	n := float64(len(x))
+	if len(x) < 3 || len(x) != len(y) {
+		return 0, false
+	}
+
+	var sx, sy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+	}
+	mx, my := sx/n, sy/n
+
+	var cov, vx, vy float64
+	for i := range x {
+		dx, dy := x[i]-mx, y[i]-my
+		cov += dx * dy
+		vx += dx * dx
+		vy += dy * dy
+	}
+	if vx == 0 || vy == 0 {
+		return 0, false
+	}
+
+	return cov / math.Sqrt(vx*vy), true
+}
+
+// GetDisengagedSubscribers returns the report of the subscribers who have been on a list
+// (or on listmonk if listID is 0) for at least inactiveDays days and have never viewed or
+// clicked a campaign, bucketed by how long they've been on it, along with a sample of them.
+func (c *Core) GetDisengagedSubscribers(inactiveDays, listID, sampleSize int) (models.DisengagedReport, error) {
+	// The bucket boundaries starting at the inactive days.
+	bounds := []float64{float64(inactiveDays)}
+	for _, b := range disengagedBuckets {
+		if b > inactiveDays {
+			bounds = append(bounds, float64(b))
+		}
+	}
+
+	var res []struct {
+		Bucket int `db:"bucket"`
+		Count  int `db:"count"`
+	}
+	if err := c.q.GetDisengagedSubscriberCounts.Select(&res, inactiveDays, listID, pq.Array(bounds)); err != nil {
+		c.log.Printf("error fetching disengaged subscriber counts: %v", err)
+		return models.DisengagedReport{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	out := models.DisengagedReport{
+		InactiveDays: inactiveDays,
+		ListID:       listID,
+		Buckets:      make([]models.DisengagedBucket, len(bounds)),
+		Sample:       []models.DisengagedSubscriber{},
+	}
+	for i, b := range bounds {
+		out.Buckets[i].FromDays = int(b)
+		if i < len(bounds)-1 {
+			out.Buckets[i].ToDays = null.IntFrom(int(bounds[i+1]))
+		}
+	}
+
+	// WIDTH_BUCKET() numbers the buckets from 1.
+	for _, r := range res {
+		if r.Bucket < 1 || r.Bucket > len(bounds) {
+			continue
+		}
+		out.Buckets[r.Bucket-1].Count = r.Count
+		out.Total += r.Count
+	}
+
+	if out.Total > 0 && sampleSize > 0 {
+		if err := c.q.GetDisengagedSubscribers.Select(&out.Sample, inactiveDays, listID, sampleSize); err != nil {
+			c.log.Printf("error fetching disengaged subscribers: %v", err)
+			return models.DisengagedReport{}, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+		}
+	}
+
+	return out, nil
+}
+
+// GetEngagementFunnel returns the funnel of a campaign's messages from sent, to delivered,
+// opened, and clicked.
+func (c *Core) GetEngagementFunnel(campID int) (models.EngagementFunnel, error) {
+	var r models.CampaignFunnelCounts
+	if err := c.q.GetCampaignFunnelCounts.Get(&r, campID); err != nil {
+		c.log.Printf("error fetching campaign funnel counts: %v", err)
+		return models.EngagementFunnel{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	// Without delivery notifications, every message that didn't bounce is assumed
+	// to have been delivered.
+	out := models.EngagementFunnel{CampaignID: campID, DeliveredConfirmed: r.Delivered > 0}
+	if !out.DeliveredConfirmed {
+		r.Delivered = max(r.Sent-r.Bounced, 0)
+	}
+
+	// Rates are against the previous stage, except for the unsubscribe link clicks,
+	// which branch off the opens, and are followed by the confirmed unsubscriptions.
+	counts := []struct {
+		stage string
+		count int
+		prev  int
+	}{
+		{"sent", r.Sent, 0},
+		{"delivered", r.Delivered, 0},
+		{"opened", r.Opened, 1},
+		{"clicked", r.Clicked, 2},
+		{"unsubscribe_clicked", r.UnsubscribeClicked, 2},
+		{"unsubscribed", r.Unsubscribed, 4},
+	}
+
+	out.Stages = make([]models.FunnelStage, len(counts))
+	for i, s := range counts {
+		st := models.FunnelStage{Stage: s.stage, Count: s.count}
+		if r.Sent > 0 {
+			st.Percent = math.Round(float64(s.count)/float64(r.Sent)*10000) / 100
+		}
+
+		prev := counts[s.prev].count
+		if prev > 0 {
+			st.Rate = math.Round(float64(s.count)/float64(prev)*10000) / 100
+		}
+
+		out.Stages[i] = st
+	}
+
+	return out, nil
+}
+
+// GetSubscriberGrowth returns the number of subscribers who subscribed to lists (or the
+// given list if listID > 0) between fromDate and toDate by day, week, or month (groupBy),
+// broken down by the source of the subscriptions.
+func (c *Core) GetSubscriberGrowth(fromDate, toDate, groupBy string, listID int) ([]models.SubscriberGrowth, error) {
+	var res []struct {
+		Date   time.Time   `db:"date"`
+		Source null.String `db:"source"`
+		Count  int         `db:"count"`
+	}
+	if err := c.q.GetSubscriberGrowth.Select(&res, fromDate, toDate, groupBy, listID); err != nil {
+		c.log.Printf("error fetching subscriber growth: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	// The rows are ordered by date with the total (NULL source) first.
+	out := []models.SubscriberGrowth{}
+	for _, r := range res {
+		if !r.Source.Valid {
+			out = append(out, models.SubscriberGrowth{
+				Date:     r.Date.Format("2006-01-02"),
+				Total:    r.Count,
+				BySource: map[string]int{},
+			})
+			continue
+		}
+
+		if len(out) > 0 {
+			out[len(out)-1].BySource[r.Source.String] = r.Count
+		}
+	}
+
+	return out, nil
+}
+
+// PredictCampaignPerformance predicts the open, click, and unsubscribe rates of a campaign
+// with a weighted average of the rates of the n most recent finished campaigns sent to
+// any of its lists. Campaigns sent on the same day of the week and around the same time
+// of the day as the campaign (its schedule, or now) are weighed higher.
+func (c *Core) PredictCampaignPerformance(camp models.Campaign, n int) (models.CampaignPrediction, error) {
+	var camps []models.ComparableCampaign
+	if err := c.q.GetComparableCampaigns.Select(&camps, camp.ID, n, predictionUnsubDays); err != nil {
+		c.log.Printf("error fetching comparable campaigns: %v", err)
+		return models.CampaignPrediction{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+	if len(camps) == 0 {
+		return models.CampaignPrediction{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.noComparable"))
+	}
+
+	// The time at which the campaign is (or will be) sent.
+	t := time.Now()
+	if camp.StartedAt.Valid {
+		t = camp.StartedAt.Time
+	} else if camp.SendAt.Valid {
+		t = camp.SendAt.Time
+	}
+	t = t.Local()
+
+	var (
+		weights = make([]float64, len(camps))
+		opens   = make([]float64, len(camps))
+		clicks  = make([]float64, len(camps))
+		unsubs  = make([]float64, len(camps))
+		ids     = make([]int, len(camps))
+	)
+	for i, cp := range camps {
+		// Campaigns are ordered newest first.
+		w := math.Pow(predictionDecay, float64(i))
+
+		st := cp.StartedAt.Local()
+		if st.Weekday() == t.Weekday() {
+			w *= 2
+		}
+		if d := (st.Hour() - t.Hour() + 24) % 24; d <= 1 || d >= 23 {
+			w *= 2
+		}
+
+		weights[i] = w
+		opens[i] = engagementRate(cp.Views, cp.Sent)
+		clicks[i] = engagementRate(cp.Clicks, cp.Sent)
+		unsubs[i] = engagementRate(cp.Unsubscribes, cp.Sent)
+		ids[i] = cp.ID
+	}
+
+	out := models.CampaignPrediction{
+		CampaignID:      camp.ID,
+		CampaignIDs:     ids,
+		OpenRate:        predictRate(weights, opens),
+		ClickRate:       predictRate(weights, clicks),
+		UnsubscribeRate: predictRate(weights, unsubs),
+	}
+
+	// Confidence grows with the effective number of comparable campaigns and
+	// shrinks with the spread of their open rates.
+	var sum, sumSq float64
+	for _, w := range weights {
+		sum += w
+		sumSq += w * w
+	}
+	nEff := sum * sum / sumSq
+
+	spread := 1.0
+	if o := out.OpenRate; o.Rate > 0 {
+		spread = math.Min(1, (o.High-o.Low)/2/o.Rate)
+	} else if o.High == 0 {
+		spread = 0
+	}
+	out.Confidence = math.Round(nEff/(nEff+2)*(1-spread)*1000) / 10
+
+	return out, nil
+}
+
+// predictRate returns the weighted average of the given rates and its confidence
+// interval based on the weighted standard error. With fewer than two rates,
+// the interval is the full range.
+func predictRate(weights, rates []float64) models.PredictedRate {
+	var sum, sumSq, mean float64
+	for i, w := range weights {
+		sum += w
+		sumSq += w * w
+		mean += w * rates[i]
+	}
+	mean /= sum
+
+	out := models.PredictedRate{Rate: mean, Low: 0, High: 1}
+	if len(rates) < 2 {
+		return out
+	}
+
+	// Unbiased weighted variance and the standard error of the mean with the
+	// effective sample size.
+	var v float64
+	for i, w := range weights {
+		v += w * (rates[i] - mean) * (rates[i] - mean)
+	}
+	v /= sum - sumSq/sum
+	se := math.Sqrt(v * sumSq / (sum * sum))
+
+	out.Low = math.Max(0, mean-predictionZ*se)
+	out.High = math.Min(1, mean+predictionZ*se)
+
+	return out
+}
+
+// engagementRate returns n/total capped at 1.
+func engagementRate(n, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return math.Min(1, float64(n)/float64(total))
+}
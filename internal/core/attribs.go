@@ -0,0 +1,312 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// csvAttribsCol is the position of the attribs column in the list export CSV.
+const csvAttribsCol = 3
+
+// CanEncryptAttribs checks whether an attribute encryption key is configured.
+func (c *Core) CanEncryptAttribs() bool {
+	return c.attribs != nil
+}
+
+// EncryptAttribs encrypts the given attributes if the given subscriber (by ID or e-mail)
+// is, or is about to be subscribed to (by list IDs or UUIDs) a sensitive list.
+func (c *Core) EncryptAttribs(subID int, email string, listIDs []int, listUUIDs []string, attribs models.JSON) (models.JSON, error) {
+	if len(attribs) == 0 {
+		return attribs, nil
+	}
+
+	ok, err := c.hasSensitiveLists(subID, email, listIDs, listUUIDs)
+	if err != nil || !ok {
+		return attribs, err
+	}
+
+	out, err := c.attribs.Encrypt(attribs)
+	if err != nil {
+		c.log.Printf("error encrypting subscriber attributes: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("subscribers.errorEncryptingAttribs", "error", err.Error()))
+	}
+
+	return out, nil
+}
+
+// DecryptSubscribers decrypts the encrypted attributes of the given subscribers in place.
+func (c *Core) DecryptSubscribers(subs []models.Subscriber) error {
+	for n := range subs {
+		a, err := c.attribs.Decrypt(subs[n].Attribs)
+		if err != nil {
+			c.log.Printf("error decrypting attributes of subscriber %d: %v", subs[n].ID, err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("subscribers.errorDecryptingAttribs", "error", err.Error()))
+		}
+		subs[n].Attribs = a
+	}
+
+	return nil
+}
+
+// EncryptSensitiveAttribs encrypts the unencrypted attributes of all existing subscribers
+// in sensitive lists. It's meant to be run after a list is flagged as sensitive.
+// It returns the number of subscribers whose attributes were encrypted.
+func (c *Core) EncryptSensitiveAttribs(batchSize int) (int, error) {
+	if c.attribs == nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("lists.sensitiveNoKey"))
+	}
+
+	var (
+		lastID = 0
+		total  = 0
+	)
+	for {
+		var rows []struct {
+			ID      int         `db:"id"`
+			Attribs models.JSON `db:"attribs"`
+		}
+		if err := c.q.GetSensitivePlainAttribs.Select(&rows, lastID, batchSize); err != nil {
+			c.log.Printf("error fetching subscriber attributes: %v", err)
+			return total, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		for _, r := range rows {
+			a, err := c.attribs.Encrypt(r.Attribs)
+			if err != nil {
+				return total, echo.NewHTTPError(http.StatusInternalServerError,
+					c.i18n.Ts("subscribers.errorEncryptingAttribs", "error", err.Error()))
+			}
+
+			if _, err := c.q.UpdateSubscriberAttribs.Exec(r.ID, a); err != nil {
+				c.log.Printf("error updating subscriber attributes: %v", err)
+				return total, echo.NewHTTPError(http.StatusInternalServerError,
+					c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+			}
+			total++
+		}
+
+		lastID = rows[len(rows)-1].ID
+	}
+
+	return total, nil
+}
+
+// encryptPlainAttribs encrypts the unencrypted attributes of the given subscribers in
+// the given transaction, eg: when they're subscribed to a sensitive list.
+func (c *Core) encryptPlainAttribs(tx *sqlx.Tx, subIDs []int) error {
+	var rows []struct {
+		ID      int         `db:"id"`
+		Attribs models.JSON `db:"attribs"`
+	}
+	if err := tx.Stmtx(c.q.GetPlainAttribs).Select(&rows, pq.Array(subIDs)); err != nil {
+		c.log.Printf("error fetching subscriber attributes: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	for _, r := range rows {
+		a, err := c.attribs.Encrypt(r.Attribs)
+		if err != nil {
+			c.log.Printf("error encrypting attributes of subscriber %d: %v", r.ID, err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("subscribers.errorEncryptingAttribs", "error", err.Error()))
+		}
+
+		if _, err := tx.Stmtx(c.q.UpdateSubscriberAttribs).Exec(r.ID, a); err != nil {
+			c.log.Printf("error updating subscriber attributes: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+		}
+	}
+
+	return nil
+}
+
+// hasSensitiveLists checks whether the given subscriber (by ID or e-mail) or any of the
+// given lists (by IDs or UUIDs) is flagged as sensitive.
+func (c *Core) hasSensitiveLists(subID int, email string, listIDs []int, listUUIDs []string) (bool, error) {
+	if listIDs == nil {
+		listIDs = []int{}
+	}
+	if listUUIDs == nil {
+		listUUIDs = []string{}
+	}
+
+	var ok bool
+	if err := c.q.HasSensitiveLists.Get(&ok, subID, email, pq.Array(listIDs), pq.Array(listUUIDs)); err != nil {
+		c.log.Printf("error checking sensitive lists: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	return ok, nil
+}
+
+// hasAnySensitiveList checks whether any list is flagged as sensitive.
+func (c *Core) hasAnySensitiveList() (bool, error) {
+	var ok bool
+	if err := c.q.HasAnySensitiveList.Get(&ok); err != nil {
+		c.log.Printf("error checking sensitive lists: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	return ok, nil
+}
+
+// checkSensitiveQuery disallows queries over subscriber attributes in sensitive
+// lists as their attributes are encrypted and can't be queried in the DB. If no
+// lists are given, the query runs over all subscribers, and so, all lists are checked.
+func (c *Core) checkSensitiveQuery(queryExp string, listIDs []int) error {
+	if !strings.Contains(strings.ToLower(queryExp), "attribs") {
+		return nil
+	}
+
+	var (
+		ok  bool
+		err error
+	)
+	if len(listIDs) == 0 {
+		ok, err = c.hasAnySensitiveList()
+	} else {
+		ok, err = c.hasSensitiveLists(0, "", listIDs, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if ok {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("subscribers.errorSensitiveQuery"))
+	}
+
+	return nil
+}
+
+// decryptRawAttribs decrypts raw JSON attributes if they're encrypted.
+func (c *Core) decryptRawAttribs(b []byte) ([]byte, error) {
+	out, err := c.attribs.DecryptRaw(b)
+	if err != nil {
+		c.log.Printf("error decrypting subscriber attributes: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("subscribers.errorDecryptingAttribs", "error", err.Error()))
+	}
+
+	return out, nil
+}
+
+// decryptProfileAttribs decrypts the attributes in an exported subscriber profile,
+// which is a JSON array of subscriber records.
+func (c *Core) decryptProfileAttribs(b json.RawMessage) (json.RawMessage, error) {
+	var recs []map[string]json.RawMessage
+	if err := json.Unmarshal(b, &recs); err != nil {
+		return b, nil
+	}
+
+	for _, r := range recs {
+		a, ok := r["attribs"]
+		if !ok {
+			continue
+		}
+
+		d, err := c.decryptRawAttribs(a)
+		if err != nil {
+			return nil, err
+		}
+		r["attribs"] = d
+	}
+
+	return json.Marshal(recs)
+}
+
+// copyDecryptCSV runs the given COPY function into a pipe and writes the CSV rows
+// it produces to w, decrypting the attribs column.
+func (c *Core) copyDecryptCSV(copyFn func(io.Writer) (int64, error), w io.Writer) (int64, error) {
+	pr, pw := io.Pipe()
+
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := copyFn(pw)
+		pw.CloseWithError(err)
+		done <- result{n, err}
+	}()
+
+	var (
+		rd = csv.NewReader(pr)
+		wr = csv.NewWriter(w)
+	)
+	for {
+		row, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			pr.CloseWithError(err)
+			<-done
+			return 0, err
+		}
+
+		if len(row) > csvAttribsCol {
+			b, err := c.attribs.DecryptRaw([]byte(row[csvAttribsCol]))
+			if err != nil {
+				pr.CloseWithError(err)
+				<-done
+				return 0, err
+			}
+			row[csvAttribsCol] = string(b)
+		}
+
+		if err := wr.Write(row); err != nil {
+			pr.CloseWithError(err)
+			<-done
+			return 0, err
+		}
+	}
+
+	wr.Flush()
+	if err := wr.Error(); err != nil {
+		return 0, err
+	}
+
+	res := <-done
+	return res.n, res.err
+}
+
+// diffAttribs returns the top level attributes whose values differ between old and
+// new attributes: their old values (for changed and removed ones) and their new
+// values (for changed and added ones).
+func diffAttribs(oldAttribs, newAttribs models.JSON) (models.JSON, models.JSON) {
+	var (
+		oldVal = models.JSON{}
+		newVal = models.JSON{}
+	)
+	for k, v := range oldAttribs {
+		if nv, ok := newAttribs[k]; !ok || !reflect.DeepEqual(v, nv) {
+			oldVal[k] = v
+		}
+	}
+	for k, v := range newAttribs {
+		if ov, ok := oldAttribs[k]; !ok || !reflect.DeepEqual(v, ov) {
+			newVal[k] = v
+		}
+	}
+
+	return oldVal, newVal
+}
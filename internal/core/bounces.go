@@ -9,11 +9,11 @@ import (
 	"github.com/lib/pq"
 )
 
-var bounceQuerySortFields = []string{"email", "campaign_name", "source", "created_at", "type"}
+var bounceQuerySortFields = []string{"email", "campaign_name", "source", "created_at", "type", "reason"}
 
 // QueryBounces retrieves paginated bounce entries based on the given params.
 // It also returns the total number of bounce records in the DB.
-func (c *Core) QueryBounces(campID, subID int, source, orderBy, order string, offset, limit int) ([]models.Bounce, int, error) {
+func (c *Core) QueryBounces(campID, subID int, source, reason, typ, orderBy, order string, offset, limit int) ([]models.Bounce, int, error) {
 	if !strSliceContains(orderBy, bounceQuerySortFields) {
 		orderBy = "created_at"
 	}
@@ -23,7 +23,7 @@ func (c *Core) QueryBounces(campID, subID int, source, orderBy, order string, of
 
 	out := []models.Bounce{}
 	stmt := strings.ReplaceAll(c.q.QueryBounces, "%order%", orderBy+" "+order)
-	if err := c.db.Select(&out, stmt, 0, campID, subID, source, offset, limit); err != nil {
+	if err := c.db.Select(&out, stmt, 0, campID, subID, source, reason, offset, limit, typ); err != nil {
 		c.log.Printf("error fetching bounces: %v", err)
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounce}", "error", pqErrMsg(err)))
@@ -41,7 +41,7 @@ func (c *Core) QueryBounces(campID, subID int, source, orderBy, order string, of
 func (c *Core) GetBounce(id int) (models.Bounce, error) {
 	var out []models.Bounce
 	stmt := strings.ReplaceAll(c.q.QueryBounces, "%order%", "id "+SortAsc)
-	if err := c.db.Select(&out, stmt, id, 0, 0, "", 0, 1); err != nil {
+	if err := c.db.Select(&out, stmt, id, 0, 0, "", "", 0, 1, ""); err != nil {
 		c.log.Printf("error fetching bounces: %v", err)
 		return models.Bounce{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounce}", "error", pqErrMsg(err)))
@@ -56,6 +56,32 @@ func (c *Core) GetBounce(id int) (models.Bounce, error) {
 	return out[0], nil
 }
 
+// GetBounceReasonCounts returns the number of bounces by reason, optionally
+// filtered by campaigns, source, and a date range.
+func (c *Core) GetBounceReasonCounts(campIDs []int, source, fromDate, toDate string) ([]models.BounceReasonCount, error) {
+	out := []models.BounceReasonCount{}
+	if err := c.q.GetBounceReasonCounts.Select(&out, pq.Array(campIDs), source, fromDate, toDate); err != nil {
+		c.log.Printf("error fetching bounce reasons: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounces}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetBounceDomainCounts returns the number of bounces by the domain of the
+// subscribers' e-mail addresses, optionally filtered by a date range.
+func (c *Core) GetBounceDomainCounts(fromDate, toDate string) ([]models.BounceDomainCount, error) {
+	out := []models.BounceDomainCount{}
+	if err := c.q.GetBounceDomainCounts.Select(&out, fromDate, toDate); err != nil {
+		c.log.Printf("error fetching bounce domains: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounces}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // RecordBounce records a new bounce.
 func (c *Core) RecordBounce(b models.Bounce) error {
 	action, ok := c.consts.BounceActions[b.Type]
@@ -63,7 +89,11 @@ func (c *Core) RecordBounce(b models.Bounce) error {
 		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("globals.messages.invalidData")+": "+b.Type)
 	}
 
-	_, err := c.q.RecordBounce.Exec(b.SubscriberUUID,
+	var res struct {
+		BlocklistedID  int `db:"blocklisted_id"`
+		UnsubscribedID int `db:"unsubscribed_id"`
+	}
+	err := c.q.RecordBounce.Get(&res, b.SubscriberUUID,
 		b.Email,
 		b.CampaignUUID,
 		b.Type,
@@ -71,7 +101,8 @@ func (c *Core) RecordBounce(b models.Bounce) error {
 		b.Meta,
 		b.CreatedAt,
 		action.Count,
-		action.Action)
+		action.Action,
+		b.Reason)
 
 	if err != nil {
 		// Ignore the error if it complained of no subscriber.
@@ -81,9 +112,18 @@ func (c *Core) RecordBounce(b models.Bounce) error {
 		}
 
 		c.log.Printf("error recording bounce: %v", err)
+		return err
+	}
+
+	// The bounce action may have blocklisted or unsubscribed the subscriber.
+	if res.BlocklistedID > 0 {
+		c.publishSubscriberEvent(models.SubscriberEventBlocklisted, []int{res.BlocklistedID}, nil)
+	}
+	if res.UnsubscribedID > 0 {
+		c.publishSubscriberEvent(models.SubscriberEventUnsubscribed, []int{res.UnsubscribedID}, nil)
 	}
 
-	return err
+	return nil
 }
 
 // BlocklistBouncedSubscribers blocklists all bounced subscribers.
@@ -1,29 +1,54 @@
 package core
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/isp"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
+	"gopkg.in/volatiletech/null.v6"
 )
 
 const (
 	CampaignAnalyticsViews   = "views"
 	CampaignAnalyticsClicks  = "clicks"
 	CampaignAnalyticsBounces = "bounces"
+	CampaignAnalyticsUnsubs  = "unsubscribes"
+
+	CampaignAnalyticsUnsubClicks = "unsubscribe_clicks"
 
 	campaignTplDefault = "default"
 	campaignTplArchive = "archive"
+
+	// audienceBatchSize is the number of subscribers in a frozen audience
+	// snapshot that are matched against the campaign's segment at a time.
+	audienceBatchSize = 5000
 )
 
+// campaignStatusFrom maps a campaign status to the statuses
+// from which a campaign can be moved to it.
+var campaignStatusFrom = map[string][]string{
+	models.CampaignStatusDraft:     {models.CampaignStatusScheduled},
+	models.CampaignStatusScheduled: {models.CampaignStatusDraft, models.CampaignStatusPaused},
+	models.CampaignStatusRunning:   {models.CampaignStatusPaused, models.CampaignStatusDraft},
+	models.CampaignStatusPaused:    {models.CampaignStatusRunning},
+	models.CampaignStatusCancelled: {models.CampaignStatusRunning, models.CampaignStatusPaused},
+}
+
 // QueryCampaigns retrieves paginated campaigns optionally filtering them by the given arbitrary
 // query expression. It also returns the total number of records in the DB.
-func (c *Core) QueryCampaigns(searchStr string, statuses, tags []string, orderBy, order string, getAll bool, permittedLists []int, offset, limit int) (models.Campaigns, int, error) {
+func (c *Core) QueryCampaigns(searchStr string, searchBody bool, statuses, tags []string, orderBy, order string, getAll bool, permittedLists []int, offset, limit int) (models.Campaigns, int, error) {
+	// Sort search results by relevance by default.
+	if searchStr != "" && orderBy == "" {
+		orderBy = "relevance"
+	}
 	queryStr, stmt := makeSearchQuery(searchStr, orderBy, order, c.q.QueryCampaigns, campQuerySortFields)
 
 	if statuses == nil {
@@ -36,7 +61,7 @@ func (c *Core) QueryCampaigns(searchStr string, statuses, tags []string, orderBy
 
 	// Unsafe to ignore scanning fields not present in models.Campaigns.
 	var out models.Campaigns
-	if err := c.db.Select(&out, stmt, 0, pq.StringArray(statuses), pq.StringArray(tags), queryStr, getAll, pq.Array(permittedLists), offset, limit); err != nil {
+	if err := c.db.Select(&out, stmt, 0, pq.StringArray(statuses), pq.StringArray(tags), queryStr, getAll, pq.Array(permittedLists), offset, limit, searchStr, searchBody); err != nil {
 		c.log.Printf("error fetching campaigns: %v", err)
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
@@ -142,10 +167,215 @@ func (c *Core) GetCampaignForPreview(id, tplID int) (models.Campaign, error) {
 	return out, nil
 }
 
+// GetCampaignAudienceCount returns the number of subscribers a campaign
+// would be sent to if it were started now.
+func (c *Core) GetCampaignAudienceCount(id int) (int, error) {
+	var out int
+	if err := c.q.GetCampaignAudienceCount.Get(&out, id); err != nil {
+		c.log.Printf("error fetching campaign audience count: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignSubscriberOverlap returns the number of subscribers in the audience of a
+// campaign who are also in the audience of each of the given campaigns. Campaigns that
+// don't exist are skipped.
+func (c *Core) GetCampaignSubscriberOverlap(id int, compareIDs []int) ([]models.CampaignOverlap, error) {
+	out := []models.CampaignOverlap{}
+	if err := c.q.GetCampaignSubOverlap.Select(&out, id, pq.Array(compareIDs)); err != nil {
+		c.log.Printf("error fetching campaign subscriber overlap: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignSendReport returns the breakdown of the subscribers of a campaign
+// who were sent it, skipped, or are yet to be sent it.
+func (c *Core) GetCampaignSendReport(id int) (models.CampaignSendReport, error) {
+	var out models.CampaignSendReport
+	if err := c.q.GetCampaignSendReport.Get(&out, id); err != nil {
+		c.log.Printf("error fetching campaign send report: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// QueueCampaign queues a running campaign as scheduled to be retried at the given
+// time if maxRunning or more other campaigns are running. It returns true if the
+// campaign was queued.
+func (c *Core) QueueCampaign(id, maxRunning int, retryAt time.Time) (bool, error) {
+	res, err := c.q.QueueCampaign.Exec(id, maxRunning, retryAt)
+	if err != nil {
+		c.log.Printf("error queuing campaign: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetCampaignQueuePosition returns the position of a scheduled campaign in the queue
+// of scheduled campaigns, or 0 if the campaign isn't scheduled.
+func (c *Core) GetCampaignQueuePosition(id int) (int, error) {
+	var out int
+	if err := c.q.GetCampaignQueuePosition.Get(&out, id); err != nil {
+		c.log.Printf("error fetching campaign queue position: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignAudienceTrend returns the approximate number of subscribers a campaign
+// would have been sent to at the end of each of the last n days.
+func (c *Core) GetCampaignAudienceTrend(id, days int) ([]models.CampaignAudienceDay, error) {
+	out := []models.CampaignAudienceDay{}
+	if err := c.q.GetCampaignAudienceTrend.Select(&out, id, days); err != nil {
+		c.log.Printf("error fetching campaign audience trend: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// FreezeCampaignAudience snapshots the subscribers a campaign would be sent to now,
+// replacing any previous snapshot. The campaign is then only sent to them, minus
+// the ones who unsubscribe or are blocklisted in the meantime. It returns the
+// number of subscribers in the snapshot.
+func (c *Core) FreezeCampaignAudience(id int) (int, error) {
+	cm, err := c.GetCampaign(id, "", "")
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := c.db.Beginx()
+	if err != nil {
+		c.log.Printf("error freezing campaign audience: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	n, err := c.freezeCampaignAudience(tx, cm)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.log.Printf("error freezing campaign audience: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return n, nil
+}
+
+// freezeCampaignAudience snapshots the audience of a campaign in the given transaction.
+// If the campaign targets a segment, the snapshot is narrowed down to the subscribers
+// that match it now, and the segment isn't evaluated again while sending.
+func (c *Core) freezeCampaignAudience(tx *sqlx.Tx, cm models.Campaign) (int, error) {
+	// Snapshotting large audiences is allowed the longer job timeout.
+	if err := c.setJobTimeout(tx); err != nil {
+		c.log.Printf("error freezing campaign audience: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if _, err := tx.Stmtx(c.q.UnfreezeCampaignAudience).Exec(cm.ID); err != nil {
+		c.log.Printf("error dropping campaign audience snapshot: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	var n int
+	if err := tx.Stmtx(c.q.FreezeCampaignAudience).Get(&n, cm.ID); err != nil {
+		c.log.Printf("error freezing campaign audience: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if !cm.SegmentID.Valid {
+		return n, nil
+	}
+
+	seg, err := c.GetSegment(cm.SegmentID.Int)
+	if err != nil {
+		return 0, err
+	}
+
+	// Drop the subscribers that don't match the segment, a batch at a time.
+	lastID := 0
+	for {
+		var ids []int
+		if err := tx.Stmtx(c.q.GetCampaignAudienceSnapshot).Select(&ids, cm.ID, lastID, audienceBatchSize); err != nil {
+			c.log.Printf("error fetching campaign audience snapshot: %v", err)
+			return 0, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+		}
+		if len(ids) == 0 {
+			break
+		}
+		lastID = ids[len(ids)-1]
+
+		matched, err := c.FilterSegmentSubscribers(seg, cm.SegmentParams, ids)
+		if err != nil {
+			return 0, err
+		}
+
+		ok := make(map[int]struct{}, len(matched))
+		for _, id := range matched {
+			ok[id] = struct{}{}
+		}
+
+		drop := make([]int, 0, len(ids)-len(matched))
+		for _, id := range ids {
+			if _, has := ok[id]; !has {
+				drop = append(drop, id)
+			}
+		}
+		if len(drop) == 0 {
+			continue
+		}
+
+		if _, err := tx.Stmtx(c.q.DeleteCampaignAudienceSnapshotSubscribers).Exec(cm.ID, pq.Array(drop)); err != nil {
+			c.log.Printf("error updating campaign audience snapshot: %v", err)
+			return 0, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+		}
+		n -= len(drop)
+	}
+
+	return n, nil
+}
+
+// GetCampaignListSendCounts returns the campaign's lists that have send frequency limits
+// with the number of other campaigns sent or scheduled to them in the week and month
+// the campaign will be sent in, if its status were changed to the given status.
+func (c *Core) GetCampaignListSendCounts(id int, status string) ([]models.ListSendCount, error) {
+	out := []models.ListSendCount{}
+	if err := c.q.GetCampaignListSendCounts.Select(&out, id, status); err != nil {
+		c.log.Printf("error fetching campaign list send counts: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // GetArchivedCampaigns retrieves campaigns with a template body.
-func (c *Core) GetArchivedCampaigns(offset, limit int) (models.Campaigns, int, error) {
+func (c *Core) GetArchivedCampaigns(offset, limit, listID int) (models.Campaigns, int, error) {
 	var out models.Campaigns
-	if err := c.q.GetArchivedCampaigns.Select(&out, offset, limit, campaignTplArchive); err != nil {
+	if err := c.q.GetArchivedCampaigns.Select(&out, offset, limit, campaignTplArchive, listID); err != nil {
 		c.log.Printf("error fetching public campaigns: %v", err)
 		return models.Campaigns{}, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
@@ -161,6 +391,13 @@ func (c *Core) GetArchivedCampaigns(offset, limit int) (models.Campaigns, int, e
 
 // CreateCampaign creates a new campaign.
 func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int) (models.Campaign, error) {
+	// Timezone waves are grouped by the timezone attribute, which is encrypted in sensitive lists.
+	if o.SendAtLocalTime != "" {
+		if err := c.checkSensitiveQuery("attribs", listIDs); err != nil {
+			return models.Campaign{}, err
+		}
+	}
+
 	uu, err := uuid.NewV4()
 	if err != nil {
 		c.log.Printf("error generating UUID: %v", err)
@@ -192,6 +429,22 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
 		o.BodySource,
+		o.ArchiveCoverMediaID,
+		o.ArchiveAccentColor,
+		o.ArchiveExcerpt,
+		o.ExcludeListIDs,
+		o.JournalAddress,
+		o.TopicIDs,
+		o.GateURL,
+		o.SendSpread,
+		o.SendSpreadCurve,
+		o.SegmentID,
+		o.SegmentParams,
+		o.FreezeAudience,
+		o.SendAtLocalTime,
+		o.TrackingDomain,
+		o.UTMTemplate,
+		o.BodyURL,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.noSubs"))
@@ -210,9 +463,23 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 	return out, nil
 }
 
-// UpdateCampaign updates a campaign.
+// UpdateCampaign updates a campaign. The campaign's updated_at (from when it was read)
+// is required and ErrConflict is returned if the campaign has been modified since then.
 func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs []int) (models.Campaign, error) {
-	_, err := c.q.UpdateCampaign.Exec(id,
+	if !o.UpdatedAt.Valid {
+		return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.missingFields", "name", "updated_at"))
+	}
+
+	// Timezone waves are grouped by the timezone attribute, which is encrypted in sensitive lists.
+	if o.SendAtLocalTime != "" {
+		if err := c.checkSensitiveQuery("attribs", listIDs); err != nil {
+			return models.Campaign{}, err
+		}
+	}
+
+	var n int
+	err := c.q.UpdateCampaign.Get(&n, id,
 		o.Name,
 		o.Subject,
 		o.FromEmail,
@@ -231,18 +498,94 @@ func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs
 		o.ArchiveTemplateID,
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
-		o.BodySource)
+		o.BodySource,
+		o.ArchiveCoverMediaID,
+		o.ArchiveAccentColor,
+		o.ArchiveExcerpt,
+		o.ExcludeListIDs,
+		o.JournalAddress,
+		o.TopicIDs,
+		o.GateURL,
+		o.SendSpread,
+		o.SendSpreadCurve,
+		o.SegmentID,
+		o.SegmentParams,
+		o.FreezeAudience,
+		o.SendAtLocalTime,
+		o.UpdatedAt,
+		o.TrackingDomain,
+		o.UTMTemplate,
+		o.BodyURL)
 	if err != nil {
 		c.log.Printf("error updating campaign: %v", err)
 		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
 	}
+	if n == 0 {
+		return models.Campaign{}, ErrConflict
+	}
 
 	out, err := c.GetCampaign(id, "", "")
 	if err != nil {
 		return models.Campaign{}, err
 	}
 
+	// The audience of a scheduled campaign is snapshotted again as the changes
+	// may have changed it. Otherwise, a stale snapshot is dropped.
+	if out.Status == models.CampaignStatusScheduled && out.FreezeAudience {
+		if _, err := c.FreezeCampaignAudience(id); err != nil {
+			return models.Campaign{}, err
+		}
+		return c.GetCampaign(id, "", "")
+	} else if !out.AudienceFrozenAt.Valid {
+		if _, err := c.q.UnfreezeCampaignAudience.Exec(id); err != nil {
+			c.log.Printf("error dropping campaign audience snapshot: %v", err)
+		}
+	}
+
+	return out, nil
+}
+
+// SwapCampaignContent replaces the content of a running campaign with a new revision
+// that's picked up for its subsequent messages and records the change as a campaign event.
+// If baseRevision is set, ErrConflict is returned if the campaign's content has been changed
+// since then, or if the campaign is no longer running.
+func (c *Core) SwapCampaignContent(id int, body string, altBody, bodySource null.String, baseRevision null.Int, userID int) (models.CampaignEvent, error) {
+	var out []models.CampaignEvent
+	if err := c.q.SwapCampaignContent.Select(&out, id, body, altBody, bodySource, baseRevision, userID); err != nil {
+		c.log.Printf("error updating campaign content: %v", err)
+		return models.CampaignEvent{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+	if len(out) == 0 {
+		return models.CampaignEvent{}, ErrConflict
+	}
+
+	return out[0], nil
+}
+
+// RecordCampaignEvent records an event of the given type in a campaign's
+// audit trail of events.
+func (c *Core) RecordCampaignEvent(id int, typ string, data models.JSON, userID int) error {
+	if _, err := c.q.InsertCampaignEvent.Exec(id, typ, data, userID); err != nil {
+		c.log.Printf("error recording campaign event: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetCampaignRevisions returns the content revisions of a campaign along with the views
+// and clicks of the messages in each revision.
+func (c *Core) GetCampaignRevisions(id int) ([]models.CampaignRevision, error) {
+	out := []models.CampaignRevision{}
+	if err := c.q.GetCampaignRevisions.Select(&out, id); err != nil {
+		c.log.Printf("error fetching campaign revisions: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
 	return out, nil
 }
 
@@ -253,58 +596,204 @@ func (c *Core) UpdateCampaignStatus(id int, status string) (models.Campaign, err
 		return models.Campaign{}, err
 	}
 
+	if errMsg := c.validateCampaignStatus(cm.Status, cm.SendAt.Valid, status); len(errMsg) > 0 {
+		return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, errMsg)
+	}
+
+	// A campaign with a frozen audience is snapshotted along with the status change
+	// when it's scheduled, so that it's never scheduled without a snapshot.
+	tx, err := c.db.Beginx()
+	if err != nil {
+		c.log.Printf("error updating campaign status: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Stmtx(c.q.UpdateCampaignStatus).Exec(cm.ID, status)
+	if err != nil {
+		c.log.Printf("error updating campaign status: %v", err)
+
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if cm.FreezeAudience && isScheduling(status, cm.SendAt.Valid) {
+		if _, err := c.freezeCampaignAudience(tx, cm); err != nil {
+			return models.Campaign{}, err
+		}
+		cm.AudienceFrozenAt = null.TimeFrom(time.Now())
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.log.Printf("error updating campaign status: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	cm.Status = status
+	return cm, nil
+}
+
+// UpdateCampaignGate records the result of a campaign's approval gate check.
+func (c *Core) UpdateCampaignGate(id int, status, reason string) error {
+	if _, err := c.q.UpdateCampaignGate.Exec(id, status, reason); err != nil {
+		c.log.Printf("error updating campaign gate: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetPendingGateCampaigns returns the IDs of campaigns that are awaiting approval
+// from their gates.
+func (c *Core) GetPendingGateCampaigns() ([]int, error) {
+	var out []int
+	if err := c.q.GetPendingGateCampaigns.Select(&out); err != nil {
+		c.log.Printf("error fetching pending gate campaigns: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// UpdateCampaignsStatus updates the status of multiple campaigns with a single query.
+// Campaigns that are not in a valid state for the requested status transition are
+// skipped. It returns the per-campaign results in the order of the given IDs.
+func (c *Core) UpdateCampaignsStatus(ids []int, status string) ([]models.CampaignStatusResult, error) {
+	if _, ok := campaignStatusFrom[status]; !ok {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("globals.messages.invalidFields", "name", "status"))
+	}
+
+	// Get the current statuses of the campaigns.
+	var camps []struct {
+		ID             int       `db:"id"`
+		Status         string    `db:"status"`
+		SendAt         null.Time `db:"send_at"`
+		FreezeAudience bool      `db:"freeze_audience"`
+	}
+	if err := c.q.GetCampaignStatuses.Select(&camps, pq.Array(ids)); err != nil {
+		c.log.Printf("error fetching campaign statuses: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+
+	var (
+		errs  = make(map[int]string, len(ids))
+		valid = make([]int, 0, len(ids))
+	)
+	for _, id := range ids {
+		errs[id] = c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}")
+	}
+	for _, cm := range camps {
+		if msg := c.validateCampaignStatus(cm.Status, cm.SendAt.Valid, status); msg != "" {
+			errs[cm.ID] = msg
+			continue
+		}
+		delete(errs, cm.ID)
+		valid = append(valid, cm.ID)
+	}
+
+	// Update all the valid campaigns in one go. The query re-checks the current status
+	// so that campaigns whose status changed in the meantime are not updated.
+	updated := make(map[int]struct{}, len(valid))
+	if len(valid) > 0 {
+		var res []int
+		if err := c.q.UpdateCampaignsStatus.Select(&res, pq.Array(valid), status, pq.Array(campaignStatusFrom[status])); err != nil {
+			c.log.Printf("error updating campaign statuses: %v", err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+		}
+		for _, id := range res {
+			updated[id] = struct{}{}
+		}
+	}
+
+	// Snapshot the audiences of the scheduled campaigns that freeze them.
+	warnings := make(map[int]string)
+	for _, cm := range camps {
+		if _, ok := updated[cm.ID]; !ok || !cm.FreezeAudience || !isScheduling(status, cm.SendAt.Valid) {
+			continue
+		}
+		if _, err := c.FreezeCampaignAudience(cm.ID); err != nil {
+			if e, ok := err.(*echo.HTTPError); ok {
+				warnings[cm.ID] = fmt.Sprintf("%v", e.Message)
+			} else {
+				warnings[cm.ID] = err.Error()
+			}
+		}
+	}
+
+	out := make([]models.CampaignStatusResult, 0, len(ids))
+	for _, id := range ids {
+		r := models.CampaignStatusResult{ID: id}
+		if _, ok := updated[id]; ok {
+			r.Success = true
+			r.Warning = warnings[id]
+		} else if msg, ok := errs[id]; ok {
+			r.Error = msg
+		} else {
+			// The campaign's status changed after it was validated.
+			r.Error = c.i18n.T("campaigns.statusChangedDuringUpdate")
+		}
+		out = append(out, r)
+	}
+
+	return out, nil
+}
+
+// isScheduling checks whether changing the status of a campaign to the given
+// status schedules it. Campaigns with a send_at date are scheduled when they're started.
+func isScheduling(status string, hasSendAt bool) bool {
+	return status == models.CampaignStatusScheduled || (status == models.CampaignStatusRunning && hasSendAt)
+}
+
+// validateCampaignStatus checks whether a campaign in the status `from` can be moved to
+// the status `to` and returns an error message if it can't.
+func (c *Core) validateCampaignStatus(from string, hasSendAt bool, to string) string {
 	errMsg := ""
-	switch status {
+	switch to {
 	case models.CampaignStatusDraft:
-		if cm.Status != models.CampaignStatusScheduled {
+		if from != models.CampaignStatusScheduled {
 			errMsg = c.i18n.T("campaigns.onlyScheduledAsDraft")
 		}
 	case models.CampaignStatusScheduled:
-		if cm.Status != models.CampaignStatusDraft && cm.Status != models.CampaignStatusPaused {
+		if from != models.CampaignStatusDraft && from != models.CampaignStatusPaused {
 			errMsg = c.i18n.T("campaigns.onlyDraftAsScheduled")
 		}
-		if !cm.SendAt.Valid {
+		if !hasSendAt {
 			errMsg = c.i18n.T("campaigns.needsSendAt")
 		}
 
 	case models.CampaignStatusRunning:
-		if cm.Status != models.CampaignStatusPaused && cm.Status != models.CampaignStatusDraft {
+		if from != models.CampaignStatusPaused && from != models.CampaignStatusDraft {
 			errMsg = c.i18n.T("campaigns.onlyPausedDraft")
 		}
 	case models.CampaignStatusPaused:
-		if cm.Status != models.CampaignStatusRunning {
+		if from != models.CampaignStatusRunning {
 			errMsg = c.i18n.T("campaigns.onlyActivePause")
 		}
 	case models.CampaignStatusCancelled:
-		if cm.Status != models.CampaignStatusRunning && cm.Status != models.CampaignStatusPaused {
+		if from != models.CampaignStatusRunning && from != models.CampaignStatusPaused {
 			errMsg = c.i18n.T("campaigns.onlyActiveCancel")
 		}
 	}
 
-	if len(errMsg) > 0 {
-		return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, errMsg)
-	}
-
-	res, err := c.q.UpdateCampaignStatus.Exec(cm.ID, status)
-	if err != nil {
-		c.log.Printf("error updating campaign status: %v", err)
-
-		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
-			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
-	}
-
-	if n, _ := res.RowsAffected(); n == 0 {
-		return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest,
-			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
-	}
-
-	cm.Status = status
-	return cm, nil
+	return errMsg
 }
 
-// UpdateCampaignArchive updates a campaign's archive properties.
-func (c *Core) UpdateCampaignArchive(id int, enabled bool, tplID int, meta models.JSON, archiveSlug string) error {
-	if _, err := c.q.UpdateCampaignArchive.Exec(id, enabled, archiveSlug, tplID, meta); err != nil {
+// UpdateCampaignArchive updates a campaign's archive properties. A null password
+// leaves the existing archive password unchanged and an empty one removes it.
+func (c *Core) UpdateCampaignArchive(id int, enabled bool, tplID int, meta models.JSON, archiveSlug string,
+	coverMediaID null.Int, accentColor, excerpt, password null.String) error {
+	if _, err := c.q.UpdateCampaignArchive.Exec(id, enabled, archiveSlug, tplID, meta, coverMediaID, accentColor, excerpt, password); err != nil {
 		c.log.Printf("error updating campaign: %v", err)
 
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -314,6 +803,19 @@ func (c *Core) UpdateCampaignArchive(id int, enabled bool, tplID int, meta model
 	return nil
 }
 
+// CheckCampaignArchivePassword checks whether the given password matches
+// the campaign's archive password.
+func (c *Core) CheckCampaignArchivePassword(id int, password string) (bool, error) {
+	var ok bool
+	if err := c.q.CheckCampaignArchivePassword.Get(&ok, id, password); err != nil {
+		c.log.Printf("error checking campaign archive password: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return ok, nil
+}
+
 // DeleteCampaign deletes a campaign.
 func (c *Core) DeleteCampaign(id int) error {
 	res, err := c.q.DeleteCampaign.Exec(id)
@@ -421,9 +923,76 @@ func (c *Core) GetCampaignAnalyticsLinks(campIDs []int, typ, fromDate, toDate st
 	return out, nil
 }
 
-// RegisterCampaignView registers a subscriber's view on a campaign.
-func (c *Core) RegisterCampaignView(campUUID, subUUID string) error {
-	if _, err := c.q.RegisterCampaignView.Exec(campUUID, subUUID); err != nil {
+// GetCampaignAnalyticsBounceTypes returns the number of bounces of each type on the
+// given campaigns in a date range.
+func (c *Core) GetCampaignAnalyticsBounceTypes(campIDs []int, fromDate, toDate string) ([]models.CampaignAnalyticsBounceType, error) {
+	out := []models.CampaignAnalyticsBounceType{}
+	if err := c.q.GetCampaignBounceTypes.Select(&out, pq.Array(campIDs), fromDate, toDate); err != nil {
+		c.log.Printf("error fetching campaign bounce types: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignActivity returns up to limit of the views, clicks, bounces, or unsubscriptions
+// (typ) of a campaign after the given cursor (the ID of the last record on the previous page).
+func (c *Core) GetCampaignActivity(campID int, typ string, cursor int64, limit int) (models.CampaignActivityPage, error) {
+	var stmt *sqlx.Stmt
+	switch typ {
+	case CampaignAnalyticsViews:
+		stmt = c.q.GetCampaignViewEvents
+	case CampaignAnalyticsClicks:
+		stmt = c.q.GetCampaignClickEvents
+	case CampaignAnalyticsBounces:
+		stmt = c.q.GetCampaignBounceEvents
+	case CampaignAnalyticsUnsubs:
+		stmt = c.q.GetCampaignUnsubEvents
+	case CampaignAnalyticsUnsubClicks:
+		stmt = c.q.GetCampaignUnsubClickEvents
+	default:
+		return models.CampaignActivityPage{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("globals.messages.invalidData"))
+	}
+
+	// Fetch one more than the limit to know whether there's a next page.
+	out := models.CampaignActivityPage{Results: []models.CampaignActivity{}, PerPage: limit}
+	if err := stmt.Select(&out.Results, campID, cursor, limit+1); err != nil {
+		c.log.Printf("error fetching campaign %s: %v", typ, err)
+		return models.CampaignActivityPage{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	if len(out.Results) > limit {
+		out.Results = out.Results[:limit]
+		out.NextCursor = null.IntFrom(int(out.Results[limit-1].ID))
+	}
+
+	return out, nil
+}
+
+// RecordCampaignDeliveries adds n confirmed deliveries to the delivered count of a campaign.
+func (c *Core) RecordCampaignDeliveries(campUUID string, n int) error {
+	var id int
+	if err := c.q.RecordCampaignDeliveries.Get(&id, campUUID, n); err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
+		}
+
+		c.log.Printf("error recording campaign deliveries: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// RegisterCampaignView registers a subscriber's view on a campaign's message
+// with the given content revision, the e-mail client it was opened in, and
+// the ISP whose tracking domain it was loaded from, if any.
+func (c *Core) RegisterCampaignView(campUUID, subUUID, client, ispName string, revision int) error {
+	if _, err := c.q.RegisterCampaignView.Exec(campUUID, subUUID, revision, client, ispName, c.allowLegacyUUIDLinks()); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "campaign_id" {
 			return nil
 		}
@@ -435,6 +1004,46 @@ func (c *Core) RegisterCampaignView(campUUID, subUUID string) error {
 	return nil
 }
 
+// RegisterUnsubscribeClick records a subscriber's click on the unsubscribe
+// link in a campaign's message, before the unsubscription is confirmed.
+func (c *Core) RegisterUnsubscribeClick(campUUID string, subID int) error {
+	if _, err := c.q.RegisterUnsubscribeClick.Exec(campUUID, subID); err != nil {
+		c.log.Printf("error registering unsubscribe click: %s", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetCampaignClientReport returns the number of views of a campaign in each
+// e-mail client, sorted by the number of views.
+func (c *Core) GetCampaignClientReport(id int) ([]models.CampaignClient, error) {
+	out := []models.CampaignClient{}
+	if err := c.q.GetCampaignClientReport.Select(&out, id); err != nil {
+		c.log.Printf("error fetching campaign client report: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignISPReport returns the number of subscribers of each ISP that a campaign
+// was sent to, bounced for, and was opened by, sorted by the number of sends.
+func (c *Core) GetCampaignISPReport(id int) ([]models.CampaignISP, error) {
+	doms, names := isp.Domains()
+
+	out := []models.CampaignISP{}
+	if err := c.q.GetCampaignISPReport.Select(&out, id, pq.Array(doms), pq.Array(names)); err != nil {
+		c.log.Printf("error fetching campaign ISP report: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // GetLinkURL returns the original URL for a link UUID without recording a click.
 func (c *Core) GetLinkURL(linkUUID string) (string, error) {
 	var url string
@@ -445,10 +1054,11 @@ func (c *Core) GetLinkURL(linkUUID string) (string, error) {
 	return url, nil
 }
 
-// RegisterCampaignLinkClick registers a subscriber's link click on a campaign.
-func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string) (string, error) {
+// RegisterCampaignLinkClick registers a subscriber's link click on a campaign's message
+// with the given content revision and the UTM params added to the link.
+func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string, revision int, utm models.UTMParams) (string, error) {
 	var url string
-	if err := c.q.RegisterLinkClick.Get(&url, linkUUID, campUUID, subUUID); err != nil {
+	if err := c.q.RegisterLinkClick.Get(&url, linkUUID, campUUID, subUUID, revision, utm, c.allowLegacyUUIDLinks()); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "link_id" {
 			return "", echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("public.invalidLink"))
 		}
@@ -460,6 +1070,17 @@ func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string) (st
 	return url, nil
 }
 
+// RegisterLinkConversion records a conversion on the latest click of a link in a
+// campaign by a subscriber.
+func (c *Core) RegisterLinkConversion(linkUUID, campUUID, subUUID string) error {
+	if _, err := c.q.RegisterLinkConversion.Exec(linkUUID, campUUID, subUUID, c.allowLegacyUUIDLinks()); err != nil {
+		c.log.Printf("error registering link conversion: %s", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
+	}
+
+	return nil
+}
+
 // ExportCampaignViews returns an iterator with campaign views for streaming/exporting.
 func (c *Core) ExportCampaignViews(since time.Time, batchSize int) func() ([]models.CampaignViewExport, error) {
 	offset := 0
@@ -475,6 +1096,40 @@ func (c *Core) ExportCampaignViews(since time.Time, batchSize int) func() ([]mod
 	}
 }
 
+// ExportCampaignSubscriberAnalytics streams the engagement of each subscriber a campaign
+// was sent to, calling fn for every row. Rows are read one at a time from the DB instead of
+// being loaded into memory. An error returned by fn stops the export and is returned as is.
+func (c *Core) ExportCampaignSubscriberAnalytics(ctx context.Context, id int, fn func(models.CampaignSubscriberAnalytics) error) error {
+	rows, err := c.q.ExportCampSubAnalytics.QueryxContext(ctx, id)
+	if err != nil {
+		c.log.Printf("error exporting campaign analytics: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.CampaignSubscriberAnalytics
+		if err := rows.StructScan(&r); err != nil {
+			c.log.Printf("error exporting campaign analytics: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		c.log.Printf("error exporting campaign analytics: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
 // ExportCampaignLinkClicks returns an iterator with campaign link click for streaming/exporting.
 func (c *Core) ExportCampaignLinkClicks(since time.Time, batchSize int) func() ([]models.CampaignClickExport, error) {
 	offset := 0
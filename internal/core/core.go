@@ -11,8 +11,10 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/attribcrypt"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
@@ -23,9 +25,11 @@ const (
 	SortAsc  = "asc"
 	SortDesc = "desc"
 
-	matDashboardCharts = "mat_dashboard_charts"
 	matDashboardCounts = "mat_dashboard_counts"
 	matListSubStats    = "mat_list_subscriber_stats"
+
+	// Postgres error code of queries cancelled, eg: by the statement timeout.
+	pqCodeQueryCanceled = "57014"
 )
 
 // Core represents the listmonk core with all shared, global functions.
@@ -35,8 +39,16 @@ type Core struct {
 	consts Constants
 	i18n   *i18n.I18n
 	db     *sqlx.DB
+	dsn    string
 	q      *models.Queries
 	log    *log.Logger
+
+	heatmaps   heatmapCache
+	listCounts listCountCache
+
+	// attribs encrypts the attributes of subscribers in sensitive lists.
+	// It's nil if no key is configured.
+	attribs *attribcrypt.Cipher
 }
 
 // Constants represents constant config.
@@ -47,11 +59,29 @@ type Constants struct {
 		Action string
 	}
 	CacheSlowQueries bool
+
+	// ExportTimeout is the statement timeout of long running jobs such as
+	// exports and materialized view refreshes, which are exempt from the
+	// (shorter) timeout of regular queries.
+	ExportTimeout time.Duration
+
+	// LegacyUUIDLinksUntil is the time until which subscriber UUIDs in the links in
+	// messages sent before subscriber tokens were introduced are accepted. Zero
+	// disables them.
+	LegacyUUIDLinksUntil time.Time
 }
 
 // Hooks contains external function hooks that are required by the core package.
 type Hooks struct {
 	SendOptinConfirmation func(models.Subscriber, []int) (int, error)
+
+	// SubscriberEvent is called with the subscribers (ID and e-mail) on
+	// lifecycle events (models.SubscriberEvent*). It's optional.
+	SubscriberEvent func(typ string, subs []models.Subscriber)
+
+	// HasSubscriberEventListeners, if set, tells whether anything is listening to
+	// subscriber events so that subscribers aren't looked up for events in vain.
+	HasSubscriberEventListeners func() bool
 }
 
 // Opt contains the controllers required to start the core.
@@ -61,16 +91,30 @@ type Opt struct {
 	DB        *sqlx.DB
 	Queries   *models.Queries
 	Log       *log.Logger
+
+	// DSN is the DB connection string used for streaming (COPY) connections.
+	DSN string
+
+	// AttribCipher encrypts the attributes of subscribers in sensitive lists.
+	AttribCipher *attribcrypt.Cipher
 }
 
+// ErrMsgQueryTimeout is the error message of queries that are cancelled
+// by Postgres for running beyond the statement timeout.
+const ErrMsgQueryTimeout = "query timed out"
+
 var (
 	ErrNotFound = echo.NewHTTPError(http.StatusNotFound, "not found")
+
+	// ErrConflict is returned when a resource can't be updated as it has been
+	// modified since it was read.
+	ErrConflict = echo.NewHTTPError(http.StatusConflict, "conflict")
 )
 
 var (
 	regexFullTextQuery  = regexp.MustCompile(`\s+`)
 	regexpSpaces        = regexp.MustCompile(`[\s]+`)
-	campQuerySortFields = []string{"name", "status", "created_at", "updated_at"}
+	campQuerySortFields = []string{"name", "status", "created_at", "updated_at", "relevance"}
 	subQuerySortFields  = []string{"email", "status", "name", "created_at", "updated_at"}
 	listQuerySortFields = []string{"name", "status", "created_at", "updated_at", "subscriber_count"}
 )
@@ -82,14 +126,19 @@ func New(o *Opt, h *Hooks) *Core {
 		consts: o.Constants,
 		i18n:   o.I18n,
 		db:     o.DB,
+		dsn:    o.DSN,
 		q:      o.Queries,
 		log:    o.Log,
+
+		attribs:    o.AttribCipher,
+		heatmaps:   heatmapCache{m: make(map[string]models.EngagementHeatmap)},
+		listCounts: listCountCache{m: make(map[int]listCount)},
 	}
 }
 
 // RefreshMatViews refreshes all materialized views.
 func (c *Core) RefreshMatViews(concurrent bool) error {
-	for _, v := range []string{matDashboardCharts, matDashboardCounts, matListSubStats} {
+	for _, v := range []string{matDashboardCounts, matListSubStats} {
 		_ = c.RefreshMatView(v, true)
 	}
 	return nil
@@ -104,12 +153,31 @@ func (c *Core) RefreshMatView(name string, concurrent bool) error {
 		q = fmt.Sprintf(q, "", name)
 	}
 
-	if _, err := c.db.Exec(q); err != nil {
+	tx, err := c.db.Beginx()
+	if err != nil {
 		c.log.Printf("error refreshing materialized view: %s: %v", name, err)
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	if err := c.setJobTimeout(tx); err != nil {
+		c.log.Printf("error refreshing materialized view: %s: %v", name, err)
+		return err
+	}
+
+	if _, err := tx.Exec(q); err != nil {
+		c.log.Printf("error refreshing materialized view: %s: %v", name, err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setJobTimeout sets the statement timeout of a transaction to the longer
+// timeout allowed for jobs such as exports.
+func (c *Core) setJobTimeout(tx *sqlx.Tx) error {
+	_, err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", c.consts.ExportTimeout.Milliseconds()))
+	return err
 }
 
 // refreshCache refreshes a Postgres materialized view if caching is disabled.
@@ -125,6 +193,9 @@ func (c *Core) refreshCache(name string, concurrent bool) error {
 // if it's a pq error.
 func pqErrMsg(err error) string {
 	if err, ok := err.(*pq.Error); ok {
+		if err.Code == pqCodeQueryCanceled {
+			return ErrMsgQueryTimeout
+		}
 		if err.Detail != "" {
 			return fmt.Sprintf("%s. %s", err, err.Detail)
 		}
@@ -137,18 +208,45 @@ func pqErrMsg(err error) string {
 // search query string along with the SQL expression.
 func makeSearchQuery(searchStr, orderBy, order, query string, querySortFields []string) (string, string) {
 	searchStr = makeSearchString(searchStr)
+	query = strings.ReplaceAll(query, "%order%", makeOrderBy(orderBy, order, querySortFields))
 
-	// Sort params.
-	if !strSliceContains(orderBy, querySortFields) {
-		orderBy = "created_at"
-	}
-	if order != SortAsc && order != SortDesc {
-		order = SortDesc
+	return searchStr, query
+}
+
+// makeOrderBy prepares an ORDER BY expression from comma separated sort fields
+// and their orders, eg: "status,created_at" and "asc,desc". Fields that aren't in
+// querySortFields are dropped, and fields without an order of their own take the
+// last given order. Without any valid fields, the results are sorted by created_at.
+func makeOrderBy(orderBy, order string, querySortFields []string) string {
+	var (
+		orders = strings.Split(order, ",")
+		seen   = make(map[string]bool)
+		out    []string
+		dir    = SortDesc
+	)
+	for i, f := range strings.Split(orderBy, ",") {
+		if i < len(orders) {
+			switch o := strings.ToLower(strings.TrimSpace(orders[i])); o {
+			case SortAsc, SortDesc:
+				dir = o
+			default:
+				dir = SortDesc
+			}
+		}
+
+		f = strings.TrimSpace(f)
+		if !strSliceContains(f, querySortFields) || seen[f] {
+			continue
+		}
+		seen[f] = true
+		out = append(out, f+" "+dir)
 	}
 
-	query = strings.ReplaceAll(query, "%order%", orderBy+" "+order)
+	if len(out) == 0 {
+		return "created_at " + dir
+	}
 
-	return searchStr, query
+	return strings.Join(out, ", ")
 }
 
 // makeSearchString prepares a search string for use in both tsquery and ILIKE queries.
@@ -0,0 +1,294 @@
+package core
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// fakeResult is the result of a statement executed on the fake DB.
+type fakeResult struct {
+	cols     []string
+	rows     [][]driver.Value
+	affected int64
+}
+
+// fakeHandler answers a statement executed on the fake DB with the given args.
+type fakeHandler func(args []driver.Value) fakeResult
+
+// fakeDBs holds the statement handlers of the fake DBs by their DSNs.
+var (
+	fakeDBs   = map[string]map[string]fakeHandler{}
+	fakeDBsMu sync.Mutex
+)
+
+func init() {
+	sql.Register("core-fake", fakeDriver{})
+}
+
+// newFakeDB returns an in-memory DB that stands in for Postgres in tests. Statements
+// are prepared with the name of a query, eg: "update-template", instead of SQL, and
+// are answered by the handler of the same name.
+func newFakeDB(t *testing.T, handlers map[string]fakeHandler) *sqlx.DB {
+	fakeDBsMu.Lock()
+	fakeDBs[t.Name()] = handlers
+	fakeDBsMu.Unlock()
+
+	db, err := sql.Open("core-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return sqlx.NewDb(db, "postgres")
+}
+
+// newTestCore returns a Core on the given fake DB.
+func newTestCore(t *testing.T, db *sqlx.DB) *Core {
+	i, err := i18n.New([]byte(`{"_.code": "en", "_.name": "English"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Core{db: db, i18n: i, log: log.New(io.Discard, "", 0)}
+}
+
+// prepare prepares the given fake query.
+func prepare(t *testing.T, db *sqlx.DB, name string) *sqlx.Stmt {
+	stmt, err := db.Preparex(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stmt
+}
+
+// httpCode returns the HTTP status code of an error returned by Core.
+func httpCode(err error) int {
+	var e *echo.HTTPError
+	if errors.As(err, &e) {
+		return e.Code
+	}
+
+	return http.StatusOK
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+
+	h, ok := fakeDBs[dsn]
+	if !ok {
+		return nil, errors.New("unknown fake DB: " + dsn)
+	}
+
+	return &fakeConn{handlers: h}, nil
+}
+
+type fakeConn struct {
+	handlers map[string]fakeHandler
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	h, ok := c.handlers[query]
+	if !ok {
+		return nil, errors.New("unknown fake query: " + query)
+	}
+
+	return &fakeStmt{h: h}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+// Begin returns a no-op transaction as statements are applied right away.
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+// CheckNamedValue passes args as they are (or their driver values) to the handlers,
+// as the args of the queries aren't all driver values, eg: []int.
+func (c *fakeConn) CheckNamedValue(v *driver.NamedValue) error {
+	if vl, ok := v.Value.(driver.Valuer); ok {
+		val, err := vl.Value()
+		if err != nil {
+			return err
+		}
+		v.Value = val
+	}
+
+	return nil
+}
+
+type fakeStmt struct {
+	h fakeHandler
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(s.h(args).affected), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{res: s.h(args)}, nil
+}
+
+type fakeRows struct {
+	res fakeResult
+	n   int
+}
+
+func (r *fakeRows) Columns() []string { return r.res.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.n >= len(r.res.rows) {
+		return io.EOF
+	}
+
+	copy(dest, r.res.rows[r.n])
+	r.n++
+
+	return nil
+}
+
+func TestUpdateConflict(t *testing.T) {
+	readAt := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// update updates the campaign or template with the given ID to the name "New"
+	// and returns the updated resource's name and updated_at.
+	type update func(c *Core, id int, updatedAt null.Time) (string, null.Time, error)
+
+	var (
+		updateCampaign = func(c *Core, id int, updatedAt null.Time) (string, null.Time, error) {
+			out, err := c.UpdateCampaign(id, models.Campaign{Base: models.Base{UpdatedAt: updatedAt}, Name: "New"}, []int{1}, nil)
+			return out.Name, out.UpdatedAt, err
+		}
+		updateTemplate = func(c *Core, id int, updatedAt null.Time) (string, null.Time, error) {
+			out, err := c.UpdateTemplate(id, "New", "", nil, null.String{}, null.String{}, null.Int{}, updatedAt)
+			return out.Name, out.UpdatedAt, err
+		}
+	)
+
+	cases := []struct {
+		name      string
+		update    update
+		id        int
+		updatedAt null.Time
+		wantCode  int
+	}{
+		{"campaign with matching updated_at", updateCampaign, 1, null.TimeFrom(readAt), http.StatusOK},
+		{"campaign with stale updated_at", updateCampaign, 1, null.TimeFrom(readAt.Add(-time.Minute)), http.StatusConflict},
+		{"campaign without updated_at", updateCampaign, 1, null.Time{}, http.StatusBadRequest},
+		{"template with matching updated_at", updateTemplate, 1, null.TimeFrom(readAt), http.StatusOK},
+		{"template with stale updated_at", updateTemplate, 1, null.TimeFrom(readAt.Add(-time.Minute)), http.StatusConflict},
+		{"template without updated_at", updateTemplate, 1, null.Time{}, http.StatusBadRequest},
+		{"unknown template", updateTemplate, 2, null.TimeFrom(readAt), http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The stored campaign or template (ID 1), which was last updated at readAt.
+			var (
+				name      = "Old"
+				updatedAt = readAt
+			)
+
+			// save updates the stored resource, like the queries, only if its updated_at
+			// (the arg at n) is unchanged.
+			save := func(args []driver.Value, n int) bool {
+				if at, ok := args[n].(time.Time); args[0] != 1 || !ok || !at.Equal(updatedAt) {
+					return false
+				}
+
+				name = args[1].(string)
+				updatedAt = updatedAt.Add(time.Minute)
+				return true
+			}
+			get := func(args []driver.Value, cols ...string) fakeResult {
+				out := fakeResult{cols: append([]string{"id", "name", "updated_at"}, cols...)}
+				if args[0] == 1 {
+					row := []driver.Value{int64(1), name, updatedAt}
+					if len(cols) > 0 {
+						row = append(row, models.CampaignStatusDraft)
+					}
+					out.rows = append(out.rows, row)
+				}
+				return out
+			}
+
+			db := newFakeDB(t, map[string]fakeHandler{
+				"update-campaign": func(args []driver.Value) fakeResult {
+					n := int64(0)
+					if save(args, 33) {
+						n = 1
+					}
+					return fakeResult{cols: []string{"count"}, rows: [][]driver.Value{{n}}}
+				},
+				"get-campaign": func(args []driver.Value) fakeResult {
+					return get(args, "status")
+				},
+				"get-campaign-stats": func(args []driver.Value) fakeResult {
+					return fakeResult{cols: []string{"campaign_id"}, rows: [][]driver.Value{{int64(1)}}}
+				},
+				"unfreeze-campaign-audience": func(args []driver.Value) fakeResult {
+					return fakeResult{}
+				},
+				"update-template": func(args []driver.Value) fakeResult {
+					if save(args, 5) {
+						return fakeResult{affected: 1}
+					}
+					return fakeResult{}
+				},
+				"get-templates": func(args []driver.Value) fakeResult {
+					return get(args)
+				},
+			})
+
+			c := newTestCore(t, db)
+			c.q = &models.Queries{
+				UpdateCampaign:           prepare(t, db, "update-campaign"),
+				GetCampaign:              prepare(t, db, "get-campaign"),
+				GetCampaignStats:         prepare(t, db, "get-campaign-stats"),
+				UnfreezeCampaignAudience: prepare(t, db, "unfreeze-campaign-audience"),
+				UpdateTemplate:           prepare(t, db, "update-template"),
+				GetTemplates:             prepare(t, db, "get-templates"),
+			}
+
+			outName, outUpdatedAt, err := tc.update(c, tc.id, tc.updatedAt)
+			if code := httpCode(err); code != tc.wantCode {
+				t.Fatalf("expected status %d, got %d (%v)", tc.wantCode, code, err)
+			}
+
+			if tc.wantCode != http.StatusOK {
+				if name != "Old" {
+					t.Errorf("expected no changes, got %q", name)
+				}
+				return
+			}
+			if outName != "New" || !outUpdatedAt.Time.After(readAt) {
+				t.Errorf("expected the update, got %q updated at %v", outName, outUpdatedAt.Time)
+			}
+		})
+	}
+}
@@ -2,17 +2,19 @@ package core
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/jmoiron/sqlx/types"
+	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 )
 
-// GetDashboardCharts returns chart data points to render on the dashboard.
-func (c *Core) GetDashboardCharts() (types.JSONText, error) {
-	_ = c.refreshCache(matDashboardCharts, false)
-
+// GetDashboardCharts returns the daily campaign views and link clicks in
+// the given date range to render on the dashboard.
+func (c *Core) GetDashboardCharts(from, to time.Time) (types.JSONText, error) {
 	var out types.JSONText
-	if err := c.q.GetDashboardCharts.Get(&out); err != nil {
+	if err := c.q.GetDashboardCharts.Get(&out, from, to); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "dashboard charts", "error", pqErrMsg(err)))
 	}
@@ -20,15 +22,63 @@ func (c *Core) GetDashboardCharts() (types.JSONText, error) {
 	return out, nil
 }
 
-// GetDashboardCounts returns stats counts to show on the dashboard.
-func (c *Core) GetDashboardCounts() (types.JSONText, error) {
+// GetDashboardCounts returns stats counts to show on the dashboard, along with
+// the counts of new subscribers, campaigns, and messages in the given date range.
+func (c *Core) GetDashboardCounts(from, to time.Time) (types.JSONText, error) {
 	_ = c.refreshCache(matDashboardCounts, false)
 
 	var out types.JSONText
-	if err := c.q.GetDashboardCounts.Get(&out); err != nil {
+	if err := c.q.GetDashboardCounts.Get(&out, from, to); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "dashboard stats", "error", pqErrMsg(err)))
 	}
 
 	return out, nil
 }
+
+// GetCampaignCalendar returns the sends of campaigns to their lists in the given month.
+// Sends of different campaigns to the same list on the same day are flagged as conflicts.
+// If getAll is false, only the campaigns on the given list IDs are returned.
+func (c *Core) GetCampaignCalendar(year int, month time.Month, getAll bool, listIDs []int) ([]models.CalendarEvent, error) {
+	_ = c.refreshCache(matListSubStats, false)
+
+	var (
+		from = time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
+		to   = from.AddDate(0, 1, 0)
+	)
+
+	out := []models.CalendarEvent{}
+	if err := c.q.GetCampaignCalendar.Select(&out, from, to, getAll, pq.Array(listIDs)); err != nil {
+		c.log.Printf("error fetching campaign calendar: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+
+	// Count the campaigns sent to each list on each day. Sends to deleted lists
+	// (ID 0) can't be compared.
+	type key struct {
+		listID int
+		day    string
+	}
+	camps := map[key]map[int]struct{}{}
+	for _, e := range out {
+		if e.ListID == 0 {
+			continue
+		}
+
+		k := key{e.ListID, e.SendAt.In(time.Local).Format(time.DateOnly)}
+		if camps[k] == nil {
+			camps[k] = map[int]struct{}{}
+		}
+		camps[k][e.CampaignID] = struct{}{}
+	}
+
+	for i, e := range out {
+		if e.ListID == 0 {
+			continue
+		}
+		out[i].Conflict = len(camps[key{e.ListID, e.SendAt.In(time.Local).Format(time.DateOnly)}]) > 1
+	}
+
+	return out, nil
+}
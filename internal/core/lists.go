@@ -1,14 +1,33 @@
 package core
 
 import (
+	"database/sql"
+	"encoding/json"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
+	"gopkg.in/volatiletech/null.v6"
 )
 
+// listCountCacheTTL is the duration for which a list's subscriber count is cached.
+const listCountCacheTTL = time.Second * 60
+
+// listCountCache is a short-lived in-memory cache of list subscriber counts keyed by list ID.
+type listCountCache struct {
+	m  map[int]listCount
+	mu sync.Mutex
+}
+
+type listCount struct {
+	count     int
+	updatedAt time.Time
+}
+
 type listType struct {
 	ID   int    `json:"id"`
 	UUID string `json:"uuid"`
@@ -41,9 +60,12 @@ func (c *Core) GetLists(typ, status string, getAll bool, permittedIDs []int) ([]
 }
 
 // QueryLists gets multiple lists based on multiple query params. Along with the  paginated and sliced
-// results, the total number of lists in the DB is returned.
-func (c *Core) QueryLists(searchStr, typ, optin, status string, tags []string, orderBy, order string, getAll bool, permittedIDs []int, offset, limit int) ([]models.List, int, error) {
-	_ = c.refreshCache(matListSubStats, false)
+// results, the total number of lists in the DB is returned. If includeCounts is false, the
+// subscriber counts of the lists are skipped and returned as 0.
+func (c *Core) QueryLists(searchStr, typ, optin, status string, tags []string, orderBy, order string, includeCounts, getAll bool, permittedIDs []int, offset, limit int) ([]models.List, int, error) {
+	if includeCounts {
+		_ = c.refreshCache(matListSubStats, false)
+	}
 
 	if tags == nil {
 		tags = []string{}
@@ -53,7 +75,7 @@ func (c *Core) QueryLists(searchStr, typ, optin, status string, tags []string, o
 		out            = []models.List{}
 		queryStr, stmt = makeSearchQuery(searchStr, orderBy, order, c.q.QueryLists, listQuerySortFields)
 	)
-	if err := c.db.Select(&out, stmt, 0, "", queryStr, typ, optin, status, pq.StringArray(tags), getAll, pq.Array(permittedIDs), offset, limit); err != nil {
+	if err := c.db.Select(&out, stmt, 0, "", queryStr, typ, optin, status, pq.StringArray(tags), getAll, pq.Array(permittedIDs), offset, limit, includeCounts); err != nil {
 		c.log.Printf("error fetching lists: %v", err)
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
@@ -83,7 +105,7 @@ func (c *Core) GetList(id int, uuid string) (models.List, error) {
 
 	var res []models.List
 	queryStr, stmt := makeSearchQuery("", "", "", c.q.QueryLists, nil)
-	if err := c.db.Select(&res, stmt, id, uu, queryStr, "", "", "", pq.StringArray{}, true, nil, 0, 1); err != nil {
+	if err := c.db.Select(&res, stmt, id, uu, queryStr, "", "", "", pq.StringArray{}, true, nil, 0, 1, true); err != nil {
 		c.log.Printf("error fetching lists: %v", err)
 		return models.List{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
@@ -106,6 +128,36 @@ func (c *Core) GetList(id int, uuid string) (models.List, error) {
 	return out, nil
 }
 
+// GetListSubscriberCount returns the total number of subscriptions to a list.
+// Counts are cached in memory for listCountCacheTTL.
+func (c *Core) GetListSubscriberCount(id int) (int, error) {
+	c.listCounts.mu.Lock()
+	if v, ok := c.listCounts.m[id]; ok && time.Since(v.updatedAt) < listCountCacheTTL {
+		c.listCounts.mu.Unlock()
+		return v.count, nil
+	}
+	c.listCounts.mu.Unlock()
+
+	var count int
+	if err := c.q.GetListSubCount.Get(&count, id); err != nil {
+		c.log.Printf("error fetching list subscriber count: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
+	}
+
+	c.listCounts.mu.Lock()
+	// Evict stale entries so that counts of deleted lists don't accumulate.
+	for k, v := range c.listCounts.m {
+		if time.Since(v.updatedAt) >= listCountCacheTTL {
+			delete(c.listCounts.m, k)
+		}
+	}
+	c.listCounts.m[id] = listCount{count: count, updatedAt: time.Now()}
+	c.listCounts.mu.Unlock()
+
+	return count, nil
+}
+
 // GetListsByOptin returns lists by optin type.
 func (c *Core) GetListsByOptin(ids []int, optinType string) ([]models.List, error) {
 	out := []models.List{}
@@ -145,6 +197,51 @@ func (c *Core) GetListTypes(ids []int, uuids []string) (map[any]string, error) {
 	return out, nil
 }
 
+// GetListsOverlap returns the matrix of common subscribers between every
+// pair of the given lists along with the unique subscribers in each list.
+func (c *Core) GetListsOverlap(ids []int) (models.ListOverlap, error) {
+	out := models.ListOverlap{Lists: []models.ListOverlapItem{}, Matrix: [][]int{}}
+
+	if err := c.q.GetListsUniqueSubscribers.Select(&out.Lists, pq.Array(ids)); err != nil {
+		c.log.Printf("error fetching list overlap: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	var pairs []struct {
+		ListA int `db:"list_a"`
+		ListB int `db:"list_b"`
+		Count int `db:"count"`
+	}
+	if err := c.q.GetListsOverlap.Select(&pairs, pq.Array(ids)); err != nil {
+		c.log.Printf("error fetching list overlap: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	// Map list IDs to their positions in the matrix.
+	idx := make(map[int]int, len(out.Lists))
+	for i, l := range out.Lists {
+		idx[l.ID] = i
+
+		row := make([]int, len(out.Lists))
+		row[i] = l.SubscriberCount
+		out.Matrix = append(out.Matrix, row)
+	}
+
+	for _, p := range pairs {
+		a, okA := idx[p.ListA]
+		b, okB := idx[p.ListB]
+		if !okA || !okB {
+			continue
+		}
+		out.Matrix[a][b] = p.Count
+		out.Matrix[b][a] = p.Count
+	}
+
+	return out, nil
+}
+
 // CreateList creates a new list.
 func (c *Core) CreateList(l models.List) (models.List, error) {
 	uu, err := uuid.NewV4()
@@ -167,7 +264,7 @@ func (c *Core) CreateList(l models.List) (models.List, error) {
 	// Insert and read ID.
 	var newID int
 	l.UUID = uu.String()
-	if err := c.q.CreateList.Get(&newID, l.UUID, l.Name, l.Type, l.Optin, l.Status, pq.StringArray(normalizeTags(l.Tags)), l.Description); err != nil {
+	if err := c.q.CreateList.Get(&newID, l.UUID, l.Name, l.Type, l.Optin, l.Status, pq.StringArray(normalizeTags(l.Tags)), l.Description, l.Sensitive, l.MaxCampsPerWeek, l.MaxCampsPerMonth, l.AutoAssignmentRules); err != nil {
 		c.log.Printf("error creating list: %v", err)
 		return models.List{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
@@ -178,7 +275,7 @@ func (c *Core) CreateList(l models.List) (models.List, error) {
 
 // UpdateList updates a given list.
 func (c *Core) UpdateList(id int, l models.List) (models.List, error) {
-	res, err := c.q.UpdateList.Exec(id, l.Name, l.Type, l.Optin, l.Status, pq.StringArray(normalizeTags(l.Tags)), l.Description)
+	res, err := c.q.UpdateList.Exec(id, l.Name, l.Type, l.Optin, l.Status, pq.StringArray(normalizeTags(l.Tags)), l.Description, l.Sensitive, l.MaxCampsPerWeek, l.MaxCampsPerMonth, l.AutoAssignmentRules)
 	if err != nil {
 		c.log.Printf("error updating list: %v", err)
 		return models.List{}, echo.NewHTTPError(http.StatusInternalServerError,
@@ -193,6 +290,87 @@ func (c *Core) UpdateList(id int, l models.List) (models.List, error) {
 	return c.GetList(id, "")
 }
 
+// ApplyListRules evaluates the auto-assignment rules of the given lists (or all lists
+// if listIDs is empty) against the given subscribers (or all subscribers if subIDs is
+// empty), subscribing the ones that match and unsubscribing the auto-assigned ones
+// that no longer do. If since is set, only the subscribers and lists modified since
+// then are evaluated.
+func (c *Core) ApplyListRules(listIDs, subIDs []int, since null.Time) (models.ListRuleResult, error) {
+	// NULL arrays disable the filters in the query.
+	if len(listIDs) == 0 {
+		listIDs = nil
+	}
+	if len(subIDs) == 0 {
+		subIDs = nil
+	}
+
+	var out models.ListRuleResult
+	if err := c.q.ApplyListRules.Get(&out, pq.Array(listIDs), pq.Array(subIDs), since); err != nil {
+		c.log.Printf("error applying list rules: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("lists.errorApplyingRules", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// MergeLists moves the subscribers and campaigns of a list to the target list and
+// archives the list. Subscribers who are already in the target list keep their
+// existing subscriptions.
+func (c *Core) MergeLists(id, targetID int) (models.ListMergeResult, error) {
+	var out models.ListMergeResult
+	if err := c.q.MergeLists.Get(&out, id, targetID); err != nil {
+		c.log.Printf("error merging lists: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("lists.errorMerging", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// applySubscriberListRules evaluates the list auto-assignment rules for a subscriber
+// whose attributes may have changed. Errors are only logged as they shouldn't fail
+// the subscriber update.
+func (c *Core) applySubscriberListRules(subID int) {
+	if _, err := c.ApplyListRules(nil, []int{subID}, null.Time{}); err != nil {
+		c.log.Printf("error applying list rules to subscriber %d: %v", subID, err)
+	}
+}
+
+// GetListEvents returns up to limit of the subscribers joining or leaving lists after
+// the given event in the order they happened. The subscribers' attributes are decrypted.
+func (c *Core) GetListEvents(after models.ListEvent, limit int) ([]models.ListEvent, error) {
+	out := []models.ListEvent{}
+	if err := c.q.GetListEvents.Select(&out, after.CreatedAt, after.SubscriberID, after.ListID, after.Event, limit); err != nil {
+		c.log.Printf("error fetching list events: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	if !c.CanEncryptAttribs() {
+		return out, nil
+	}
+
+	for i, e := range out {
+		var sub map[string]json.RawMessage
+		if err := json.Unmarshal(e.Subscriber, &sub); err != nil {
+			continue
+		}
+
+		a, err := c.decryptRawAttribs(sub["attribs"])
+		if err != nil {
+			return nil, err
+		}
+		sub["attribs"] = a
+
+		if b, err := json.Marshal(sub); err == nil {
+			out[i].Subscriber = b
+		}
+	}
+
+	return out, nil
+}
+
 // DeleteList deletes a list.
 func (c *Core) DeleteList(id int) error {
 	return c.DeleteLists([]int{id}, "", true, nil)
@@ -215,3 +393,40 @@ func (c *Core) DeleteLists(ids []int, query string, getAll bool, permittedIDs []
 	}
 	return nil
 }
+
+// UpsertLandingPage saves the generated landing page of a list. A new UUID is only
+// used when the list doesn't have a landing page yet so that its URL remains stable.
+func (c *Core) UpsertLandingPage(listID int, customCSS, body string) (models.LandingPage, error) {
+	uu, err := uuid.NewV4()
+	if err != nil {
+		c.log.Printf("error generating UUID: %v", err)
+		return models.LandingPage{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+	}
+
+	var out models.LandingPage
+	if err := c.q.UpsertLandingPage.Get(&out, uu.String(), listID, customCSS, body); err != nil {
+		c.log.Printf("error saving landing page: %v", err)
+		return models.LandingPage{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{lists.landingPage}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetLandingPage retrieves the landing page of an active public list by its UUID.
+func (c *Core) GetLandingPage(uuid string) (models.LandingPage, error) {
+	var out models.LandingPage
+	if err := c.q.GetLandingPage.Get(&out, uuid); err != nil {
+		if err == sql.ErrNoRows {
+			return models.LandingPage{}, echo.NewHTTPError(http.StatusNotFound,
+				c.i18n.Ts("globals.messages.notFound", "name", "{lists.landingPage}"))
+		}
+
+		c.log.Printf("error fetching landing page: %v", err)
+		return models.LandingPage{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{lists.landingPage}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
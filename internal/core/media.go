@@ -69,8 +69,29 @@ func (c *Core) GetMedia(id int, uuid, fileName string, s media.Store) (media.Med
 	return out, nil
 }
 
+// GetMediaByChecksum returns the first media item of a provider whose file has
+// the given (SHA-256) checksum.
+func (c *Core) GetMediaByChecksum(provider, checksum string, s media.Store) (media.Media, error) {
+	var out media.Media
+	if err := c.q.GetMediaByChecksum.Get(&out, provider, checksum); err != nil {
+		if err == sql.ErrNoRows {
+			return out, ErrNotFound
+		}
+
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
+	}
+
+	out.URL = s.GetURL(out.Filename)
+	if out.Thumb != "" {
+		out.ThumbURL = null.String{Valid: true, String: s.GetURL(out.Thumb)}
+	}
+
+	return out, nil
+}
+
 // InsertMedia inserts a new media file into the DB.
-func (c *Core) InsertMedia(fileName, thumbName, contentType string, meta models.JSON, provider string, s media.Store) (media.Media, error) {
+func (c *Core) InsertMedia(fileName, thumbName, contentType string, meta models.JSON, checksum, provider string, s media.Store) (media.Media, error) {
 	uu, err := uuid.NewV4()
 	if err != nil {
 		c.log.Printf("error generating UUID: %v", err)
@@ -80,7 +101,7 @@ func (c *Core) InsertMedia(fileName, thumbName, contentType string, meta models.
 
 	// Write to the DB.
 	var newID int
-	if err := c.q.InsertMedia.Get(&newID, uu, fileName, thumbName, contentType, provider, meta); err != nil {
+	if err := c.q.InsertMedia.Get(&newID, uu, fileName, thumbName, contentType, provider, meta, checksum); err != nil {
 		c.log.Printf("error inserting uploaded file to db: %v", err)
 		return media.Media{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
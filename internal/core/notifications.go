@@ -0,0 +1,93 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// QueryNotifications retrieves paginated admin notifications, optionally filtered by type.
+// It also returns the total number of notification records in the DB.
+func (c *Core) QueryNotifications(typ string, offset, limit int) ([]models.Notification, int, error) {
+	out := []models.Notification{}
+	if err := c.q.QueryNotifications.Select(&out, typ, offset, limit); err != nil {
+		c.log.Printf("error fetching notifications: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.notifications}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// LogNotification records a sent admin notification in the notifications log.
+func (c *Core) LogNotification(n models.Notification) error {
+	data := n.Data
+	if len(data) == 0 {
+		data = json.RawMessage("{}")
+	}
+
+	if _, err := c.q.InsertNotification.Exec(n.Type, n.Subject, []byte(data), pq.Array(n.Channels), n.Error.String); err != nil {
+		c.log.Printf("error recording notification: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.notifications}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetCampaignBounceRates returns campaigns that have sent at least minSent messages, and the
+// bounces of which recorded within the given window are at least threshold percent of the
+// messages sent.
+func (c *Core) GetCampaignBounceRates(window time.Duration, threshold float64, minSent int) ([]models.CampaignBounceRate, error) {
+	out := []models.CampaignBounceRate{}
+	if err := c.q.GetCampaignBounceRates.Select(&out, int(window.Seconds()), threshold, minSent); err != nil {
+		c.log.Printf("error fetching campaign bounce rates: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounces}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetBounceReceivedHeaders returns the Received headers of the bounce messages recorded
+// within the given window. Bounces without the headers (eg: from webhooks) have none.
+func (c *Core) GetBounceReceivedHeaders(window time.Duration) ([]pq.StringArray, error) {
+	out := []pq.StringArray{}
+	if err := c.q.GetBounceReceivedHeaders.Select(&out, int(window.Seconds())); err != nil {
+		c.log.Printf("error fetching bounce headers: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounces}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// RecordIPReputationStats records a snapshot of the number of bounces by originating IP
+// within the given window, out of the total number of bounces in the window.
+func (c *Core) RecordIPReputationStats(counts []models.IPBounceCount, total int, window time.Duration) error {
+	var (
+		ips     = make([]string, len(counts))
+		bounces = make([]int, len(counts))
+	)
+	for i, n := range counts {
+		ips[i] = n.IP
+		bounces[i] = n.Bounces
+	}
+
+	if _, err := c.q.InsertIPReputationStats.Exec(pq.Array(ips), pq.Array(bounces), total, int(window.Seconds())); err != nil {
+		c.log.Printf("error recording IP reputation stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.bounces}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TakeRateLimitToken takes a token from the rate limit bucket identified by key that
+// holds up to max tokens and is refilled fully over window. The buckets are in the DB so
+// that the limits apply across instances. If there are no tokens left, it returns false
+// and the duration after which a token will be available.
+func (c *Core) TakeRateLimitToken(key string, max int, window time.Duration) (bool, time.Duration, error) {
+	var res struct {
+		Allowed bool    `db:"allowed"`
+		Tokens  float64 `db:"tokens"`
+	}
+	if err := c.q.TakeRateLimitToken.Get(&res, key, max, window.Seconds()); err != nil {
+		c.log.Printf("error checking rate limit: %v", err)
+		return false, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.T("globals.messages.internalError"))
+	}
+
+	if res.Allowed {
+		return true, 0, nil
+	}
+
+	// Time to refill the fraction of the token that's missing.
+	secs := math.Ceil((1 - res.Tokens) * window.Seconds() / float64(max))
+	return false, time.Duration(secs) * time.Second, nil
+}
+
+// DeleteStaleRateLimits deletes the rate limit buckets that haven't been used for
+// longer than the given duration.
+func (c *Core) DeleteStaleRateLimits(age time.Duration) error {
+	if _, err := c.q.DeleteStaleRateLimits.Exec(int(age.Seconds())); err != nil {
+		c.log.Printf("error deleting stale rate limits: %v", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// GetSegments retrieves all segments.
+func (c *Core) GetSegments() ([]models.Segment, error) {
+	out := []models.Segment{}
+	if err := c.q.GetSegments.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching segments: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.segments}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetSegment retrieves a given segment.
+func (c *Core) GetSegment(id int) (models.Segment, error) {
+	var out []models.Segment
+	if err := c.q.GetSegments.Select(&out, id); err != nil {
+		c.log.Printf("error fetching segment: %v", err)
+		return models.Segment{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.segment}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.Segment{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.segment}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateSegment creates a new segment. The segment's query should be validated
+// with ValidateSegment first.
+func (c *Core) CreateSegment(s models.Segment, userID int) (models.Segment, error) {
+	var newID int
+	if err := c.q.CreateSegment.Get(&newID, s.Name, s.Description, s.Query, s.Params, userID); err != nil {
+		c.log.Printf("error creating segment: %v", err)
+		return models.Segment{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.segment}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetSegment(newID)
+}
+
+// UpdateSegment updates a given segment. Changing the query or the params
+// of a segment revokes its approval.
+func (c *Core) UpdateSegment(id int, s models.Segment) (models.Segment, error) {
+	res, err := c.q.UpdateSegment.Exec(id, s.Name, s.Description, s.Query, s.Params)
+	if err != nil {
+		c.log.Printf("error updating segment: %v", err)
+		return models.Segment{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.segment}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Segment{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.segment}"))
+	}
+
+	return c.GetSegment(id)
+}
+
+// ApproveSegment approves a given segment on behalf of the given user, or
+// revokes its approval.
+func (c *Core) ApproveSegment(id int, userID int, approve bool) (models.Segment, error) {
+	var by null.Int
+	if approve {
+		by = null.IntFrom(userID)
+	}
+
+	res, err := c.q.ApproveSegment.Exec(id, by)
+	if err != nil {
+		c.log.Printf("error approving segment: %v", err)
+		return models.Segment{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.segment}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Segment{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.segment}"))
+	}
+
+	return c.GetSegment(id)
+}
+
+// DeleteSegment deletes a given segment. Segments that are used by campaigns
+// can't be deleted.
+func (c *Core) DeleteSegment(id int) error {
+	if _, err := c.GetSegment(id); err != nil {
+		return err
+	}
+
+	res, err := c.q.DeleteSegment.Exec(id)
+	if err != nil {
+		c.log.Printf("error deleting segment: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.segment}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("segments.inUse"))
+	}
+
+	return nil
+}
+
+// ValidateSegment validates a segment's params and checks its query by
+// planning it with placeholder values bound to the params. Like the
+// arbitrary subscriber query, the query can only access the allowed tables.
+func (c *Core) ValidateSegment(s models.Segment) error {
+	if err := s.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("segments.invalidQuery", "error", err.Error()))
+	}
+
+	// query-subscribers has 6 positional arguments.
+	exp, args, err := s.Bind(segmentSampleValues(s), 6)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("segments.invalidQuery", "error", err.Error()))
+	}
+
+	stmt := strings.ReplaceAll(c.q.QuerySubscribers, "%query%", exp)
+	stmt = strings.ReplaceAll(stmt, "%order%", "subscribers.id "+SortAsc)
+
+	args = append([]any{pq.Array([]int{}), "", "", 0, 0, models.SubscriberAnonymizedEmail}, args...)
+	if err := validateQueryTables(c.db, stmt, allowedSubQueryTables, args...); err != nil {
+		c.log.Printf("error validating segment query: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("segments.invalidQuery", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// QuerySegmentSubscribers executes a segment with the given param values, which
+// are type-checked and bound to positional arguments, and returns a page of the
+// subscribers that match it, optionally filtered by lists and subscription status.
+func (c *Core) QuerySegmentSubscribers(s models.Segment, values map[string]any, listIDs []int, subStatus string, offset, limit int) (models.Subscribers, int, error) {
+	// Required for pq.Array()
+	if listIDs == nil {
+		listIDs = []int{}
+	}
+
+	// Attributes in sensitive lists are encrypted and can't be queried.
+	if err := c.checkSensitiveQuery(s.Query, listIDs); err != nil {
+		return nil, 0, err
+	}
+
+	// query-subscribers-count has 4 positional arguments and query-subscribers 6,
+	// after which the segment's arguments start.
+	countExp, countArgs, err := s.Bind(values, 4)
+	if err != nil {
+		return nil, 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("segments.invalidParams", "error", err.Error()))
+	}
+	exp, args, err := s.Bind(values, 6)
+	if err != nil {
+		return nil, 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("segments.invalidParams", "error", err.Error()))
+	}
+
+	stmt := strings.ReplaceAll(c.q.QuerySubscribers, "%query%", exp)
+	stmt = strings.ReplaceAll(stmt, "%order%", "subscribers.id "+SortAsc)
+	args = append([]any{pq.Array(listIDs), subStatus, "", offset, limit, models.SubscriberAnonymizedEmail}, args...)
+
+	// Validate the tables used in the query.
+	if err := validateQueryTables(c.db, stmt, allowedSubQueryTables, args...); err != nil {
+		c.log.Printf("error validating segment query: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("subscribers.errorPreparingQuery", "error", err.Error()))
+	}
+
+	total, err := c.getSubscriberCount("", countExp, subStatus, listIDs, countArgs...)
+	if err != nil {
+		c.log.Printf("error getting segment subscriber count: %v", err)
+		return nil, 0, err
+	}
+
+	// No results.
+	if total == 0 {
+		return models.Subscribers{}, 0, nil
+	}
+
+	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		c.log.Printf("error preparing segment query: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	var out models.Subscribers
+	if err := tx.Select(&out, stmt, args...); err != nil {
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	// Lazy load lists for each subscriber.
+	if err := out.LoadLists(c.q.GetSubscriberListsLazy); err != nil {
+		c.log.Printf("error fetching subscriber lists: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+	if err := c.DecryptSubscribers(out); err != nil {
+		return nil, 0, err
+	}
+
+	return out, total, nil
+}
+
+// FilterSegmentSubscribers returns the IDs among the given subscriber IDs that
+// match a segment with the given param values. It's used to narrow down the
+// subscribers of a campaign that targets a segment.
+func (c *Core) FilterSegmentSubscribers(s models.Segment, values map[string]any, subIDs []int) ([]int, error) {
+	// filter-segment-subscribers has 1 positional argument.
+	exp, args, err := s.Bind(values, 1)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("segments.invalidParams", "error", err.Error()))
+	}
+	stmt := strings.ReplaceAll(c.q.FilterSegmentSubscribers, "%query%", exp)
+
+	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		c.log.Printf("error preparing segment query: %v", err)
+		return nil, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	out := []int{}
+	if err := tx.Select(&out, stmt, append([]any{pq.Array(subIDs)}, args...)...); err != nil {
+		c.log.Printf("error filtering segment subscribers: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// segmentSampleValues returns a valid placeholder value for each of a
+// segment's params for planning its query.
+func segmentSampleValues(s models.Segment) map[string]any {
+	out := make(map[string]any, len(s.Params))
+	for _, p := range s.Params {
+		switch p.Type {
+		case models.SegmentParamInt:
+			out[p.Name] = 0
+		case models.SegmentParamDate:
+			out[p.Name] = time.Now().Format(models.SegmentDateFormat)
+		case models.SegmentParamEnum:
+			out[p.Name] = p.Options[0]
+		default:
+			out[p.Name] = ""
+		}
+	}
+
+	return out
+}
@@ -49,6 +49,22 @@ func (c *Core) UpdateSettings(s models.Settings) error {
 	return nil
 }
 
+// PatchSettings updates the settings with the given keys in a single update.
+func (c *Core) PatchSettings(s map[string]json.RawMessage) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+	}
+
+	if _, err := c.q.UpdateSettings.Exec(b); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
 // UpdateSettingsByKey updates a single setting by key.
 func (c *Core) UpdateSettingsByKey(key string, value json.RawMessage) error {
 	if _, err := c.q.UpdateSettingsByKey.Exec(key, value); err != nil {
@@ -5,15 +5,20 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jmoiron/sqlx"
 	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 var (
@@ -30,6 +35,30 @@ var (
 	}
 )
 
+// GetSubscriberByToken fetches a subscriber by the token in the links in the
+// messages sent to them.
+func (c *Core) GetSubscriberByToken(token string) (models.Subscriber, error) {
+	var ids []int
+	if err := c.q.GetSubscriberByToken.Select(&ids, token, c.allowLegacyUUIDLinks()); err != nil {
+		c.log.Printf("error fetching subscriber: %v", err)
+		return models.Subscriber{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching",
+				"name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+	}
+	if len(ids) == 0 {
+		return models.Subscriber{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.subscriber}"))
+	}
+
+	return c.GetSubscriber(ids[0], "", "")
+}
+
+// allowLegacyUUIDLinks tells whether the subscriber UUIDs in the links in messages sent
+// before subscriber tokens were introduced are still accepted in place of the tokens.
+func (c *Core) allowLegacyUUIDLinks() bool {
+	return time.Now().Before(c.consts.LegacyUUIDLinksUntil)
+}
+
 // GetSubscriber fetches a subscriber by one of the given params.
 func (c *Core) GetSubscriber(id int, uuid, email string) (models.Subscriber, error) {
 	var uu any
@@ -55,6 +84,9 @@ func (c *Core) GetSubscriber(id int, uuid, email string) (models.Subscriber, err
 			c.i18n.Ts("globals.messages.errorFetching",
 				"name", "{globals.terms.lists}", "error", pqErrMsg(err)))
 	}
+	if err := c.DecryptSubscribers(out); err != nil {
+		return models.Subscriber{}, err
+	}
 
 	return out[0], nil
 }
@@ -98,25 +130,25 @@ func (c *Core) GetSubscribersByEmail(emails []string) (models.Subscribers, error
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
 	}
+	if err := c.DecryptSubscribers(out); err != nil {
+		return nil, err
+	}
 
 	return out, nil
 }
 
 // QuerySubscribers queries and returns paginated subscrribers based on the given params including the total count.
 func (c *Core) QuerySubscribers(searchStr, queryExp string, listIDs []int, subStatus string, order, orderBy string, offset, limit int) (models.Subscribers, int, error) {
-	// Sort params.
-	if !strSliceContains(orderBy, subQuerySortFields) {
-		orderBy = "subscribers.id"
-	}
-	if order != SortAsc && order != SortDesc {
-		order = SortDesc
-	}
-
 	// Required for pq.Array()
 	if listIDs == nil {
 		listIDs = []int{}
 	}
 
+	// Attributes in sensitive lists are encrypted and can't be queried.
+	if err := c.checkSensitiveQuery(queryExp, listIDs); err != nil {
+		return nil, 0, err
+	}
+
 	// There's an arbitrary query condition.
 	cond := "TRUE"
 	if queryExp != "" {
@@ -124,11 +156,10 @@ func (c *Core) QuerySubscribers(searchStr, queryExp string, listIDs []int, subSt
 	}
 
 	// stmt is the raw SQL query.
-	stmt := strings.ReplaceAll(c.q.QuerySubscribers, "%query%", cond)
-	stmt = strings.ReplaceAll(stmt, "%order%", orderBy+" "+order)
+	stmt, args := c.makeSubQuery(searchStr, queryExp, nil, listIDs, subStatus, order, orderBy, offset, limit)
 
 	// Validate the tables used in the query.
-	if err := validateQueryTables(c.db, stmt, allowedSubQueryTables, pq.Array(listIDs), subStatus, searchStr, offset, limit); err != nil {
+	if err := validateQueryTables(c.db, stmt, allowedSubQueryTables, args...); err != nil {
 		c.log.Printf("error validating query tables: %v", err)
 		return nil, 0, echo.NewHTTPError(http.StatusBadRequest,
 			c.i18n.Ts("subscribers.errorPreparingQuery", "error", err.Error()))
@@ -155,7 +186,7 @@ func (c *Core) QuerySubscribers(searchStr, queryExp string, listIDs []int, subSt
 	defer tx.Rollback()
 
 	var out models.Subscribers
-	if err := tx.Select(&out, stmt, pq.Array(listIDs), subStatus, searchStr, offset, limit); err != nil {
+	if err := tx.Select(&out, stmt, args...); err != nil {
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
@@ -166,6 +197,9 @@ func (c *Core) QuerySubscribers(searchStr, queryExp string, listIDs []int, subSt
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
+	if err := c.DecryptSubscribers(out); err != nil {
+		return nil, 0, err
+	}
 
 	return out, total, nil
 }
@@ -212,6 +246,12 @@ func (c *Core) GetSubscriberProfileForExport(id int, uuid string) (models.Subscr
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", err.Error()))
 	}
 
+	prof, err := c.decryptProfileAttribs(out.Profile)
+	if err != nil {
+		return models.SubscriberExportProfile{}, err
+	}
+	out.Profile = prof
+
 	return out, nil
 }
 
@@ -228,53 +268,252 @@ func (c *Core) GetSubscriberActivity(id int) (models.SubscriberActivity, error)
 	return out, nil
 }
 
-// ExportSubscribers returns an iterator function that provides lists of subscribers based
-// on the given criteria in an exportable form. The iterator function returned can be called
-// repeatedly until there are nil subscribers. It's an iterator because exports can be extremely
-// large and may have to be fetched in batches from the DB and streamed somewhere.
-func (c *Core) ExportSubscribers(searchStr, query string, subIDs, listIDs []int, subStatus string, batchSize int) (func() ([]models.SubscriberExport, error), error) {
-	if subIDs == nil {
-		subIDs = []int{}
+// GetSubscriberSends returns the paginated list of campaigns sent to a subscriber.
+func (c *Core) GetSubscriberSends(id, offset, limit int) ([]models.SubscriberSend, int, error) {
+	out := []models.SubscriberSend{}
+	if err := c.q.GetSubscriberSends.Select(&out, id, offset, limit); err != nil {
+		c.log.Printf("error fetching subscriber sends: %v", err)
+
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// GetSubscriberCampaignHistory returns up to limit of the campaign messages sent to a
+// subscriber before the given cursor (the sent_at of the last record on the previous page).
+func (c *Core) GetSubscriberCampaignHistory(id int, cursor null.Time, limit int) (models.CampaignHistoryPage, error) {
+	// Fetch one more than the limit to know whether there's a next page.
+	out := models.CampaignHistoryPage{Results: []models.CampaignHistory{}, PerPage: limit}
+	if err := c.q.GetSubscriberCampaignHistory.Select(&out.Results, id, cursor, limit+1); err != nil {
+		c.log.Printf("error fetching subscriber campaign history: %v", err)
+
+		return models.CampaignHistoryPage{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
 	}
+
+	if len(out.Results) > limit {
+		out.Results = out.Results[:limit]
+		out.NextCursor = null.TimeFrom(out.Results[limit-1].SentAt.UTC())
+	}
+
+	return out, nil
+}
+
+// GetNewSubscriptions returns the latest n subscriptions to lists.
+func (c *Core) GetNewSubscriptions(n int) ([]models.NewSubscription, error) {
+	out := []models.NewSubscription{}
+	if err := c.q.GetNewSubscriptions.Select(&out, n); err != nil {
+		c.log.Printf("error fetching new subscriptions: %v", err)
+
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// LogAttribChanges records the changes between the old and new attributes of a subscriber
+// made by a user (0 for none) in the attribute changelog. Nothing is recorded if the
+// attributes are unchanged. The changes are encrypted like the subscriber's attributes.
+func (c *Core) LogAttribChanges(subID, userID int, oldAttribs, newAttribs models.JSON) error {
+	oldVal, newVal := diffAttribs(oldAttribs, newAttribs)
+	if len(oldVal) == 0 && len(newVal) == 0 {
+		return nil
+	}
+
+	oldVal, err := c.EncryptAttribs(subID, "", nil, nil, oldVal)
+	if err != nil {
+		return err
+	}
+	newVal, err = c.EncryptAttribs(subID, "", nil, nil, newVal)
+	if err != nil {
+		return err
+	}
+
+	var changedBy any
+	if userID > 0 {
+		changedBy = userID
+	}
+	if _, err := c.q.InsertAttribChange.Exec(subID, changedBy, oldVal, newVal); err != nil {
+		c.log.Printf("error recording subscriber attribute changes: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{subscribers.attribHistory}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetPublicSubscriptionStatus returns the status of a recent subscription to a public
+// list by the subscriber whose e-mail has the given HMAC hash, or an empty string
+// if there's none.
+func (c *Core) GetPublicSubscriptionStatus(listUUID, emailHash, key string) (string, error) {
+	var status string
+	if err := c.q.GetPublicSubscriptionStatus.Get(&status, listUUID, emailHash, key); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+
+		c.log.Printf("error fetching subscription status: %v", err)
+		return "", echo.NewHTTPError(http.StatusInternalServerError, c.i18n.T("public.errorProcessingRequest"))
+	}
+
+	return status, nil
+}
+
+// GetSubscriberAttribStats returns the aggregate statistics of the values of a top level
+// subscriber attribute on the given lists (or all subscribers if empty), with the counts
+// of at most maxValues of its most common values.
+func (c *Core) GetSubscriberAttribStats(key string, listIDs []int, maxValues int) (models.AttribStats, error) {
+	// Required for pq.Array()
 	if listIDs == nil {
 		listIDs = []int{}
 	}
 
-	// There's an arbitrary query condition.
-	cond := "TRUE"
-	if query != "" {
-		cond = query
+	var out models.AttribStats
+	if err := c.q.GetSubscriberAttribStats.Get(&out, key, pq.Array(listIDs), maxValues); err != nil {
+		c.log.Printf("error fetching subscriber attribute stats: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
+	out.Key = key
 
-	stmt := strings.ReplaceAll(c.q.QuerySubscribersForExport, "%query%", cond)
+	return out, nil
+}
+
+// GetAttribChangelog returns the paginated attribute changes of a subscriber, latest first.
+func (c *Core) GetAttribChangelog(subID, offset, limit int) ([]models.AttribChange, int, error) {
+	out := []models.AttribChange{}
+	if err := c.q.GetAttribChangelog.Select(&out, subID, offset, limit); err != nil {
+		c.log.Printf("error fetching subscriber attribute changes: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{subscribers.attribHistory}", "error", pqErrMsg(err)))
+	}
+
+	for n := range out {
+		for _, v := range []*models.JSON{&out[n].OldValue, &out[n].NewValue} {
+			a, err := c.attribs.Decrypt(*v)
+			if err != nil {
+				c.log.Printf("error decrypting attribute changes of subscriber %d: %v", subID, err)
+				return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+					c.i18n.Ts("subscribers.errorDecryptingAttribs", "error", err.Error()))
+			}
+			*v = a
+		}
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// ExportListSubscribers streams a CSV export (email, name, status, attribs) of all the
+// subscribers in a list to w. It uses COPY TO STDOUT on a dedicated connection instead
+// of the batched export query, which is much faster for large lists. It returns the
+// number of exported rows.
+func (c *Core) ExportListSubscribers(ctx context.Context, listID int, subStatus string, w io.Writer) (int64, error) {
+	conn, err := pgconn.Connect(ctx, c.dsn)
+	if err != nil {
+		c.log.Printf("error connecting to DB for list export: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", err.Error()))
+	}
+	defer conn.Close(context.Background())
+
+	q := strings.ReplaceAll(c.q.CopyListSubscribers, "%list_id%", strconv.Itoa(listID))
+	q = strings.ReplaceAll(q, "%status%", pq.QuoteLiteral(subStatus))
+
+	copyFn := func(w io.Writer) (int64, error) {
+		res, err := conn.CopyTo(ctx, w, q)
+		return res.RowsAffected(), err
+	}
+
+	// Subscribers in the list may have encrypted attributes because of their membership
+	// in other sensitive lists, so rows are decrypted on the way out whenever there's a key.
+	var n int64
+	if c.attribs != nil {
+		n, err = c.copyDecryptCSV(copyFn, w)
+	} else {
+		n, err = copyFn(w)
+	}
+	if err != nil {
+		c.log.Printf("error exporting list subscribers: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", err.Error()))
+	}
+
+	return n, nil
+}
+
+// ExportSubscribers returns an iterator function that provides lists of subscribers based
+// on the given criteria. The iterator function returned can be called repeatedly until there
+// are nil subscribers. It's an iterator because exports can be extremely large and may have
+// to be fetched in batches from the DB and streamed somewhere.
+//
+// The subscribers are queried with the exact query (filters and order) that QuerySubscribers
+// uses, through a cursor in a read-only transaction, so that an export contains exactly the
+// subscribers that are queried with the same params. The returned close function ends the
+// transaction and should always be called.
+func (c *Core) ExportSubscribers(searchStr, queryExp string, subIDs, listIDs []int, subStatus, order, orderBy string, batchSize int) (func() (models.Subscribers, error), func(), error) {
+	if listIDs == nil {
+		listIDs = []int{}
+	}
+
+	// Attributes in sensitive lists are encrypted and can't be queried.
+	if err := c.checkSensitiveQuery(queryExp, listIDs); err != nil {
+		return nil, nil, err
+	}
+
+	stmt, args := c.makeSubQuery(searchStr, queryExp, subIDs, listIDs, subStatus, order, orderBy, 0, 0)
 
 	// Validate the tables used in the query.
-	if err := validateQueryTables(c.db, stmt, allowedSubQueryTables,
-		pq.Array(listIDs), 0, pq.Array(subIDs), subStatus, searchStr, batchSize); err != nil {
+	if err := validateQueryTables(c.db, stmt, allowedSubQueryTables, args...); err != nil {
 		c.log.Printf("error validating query tables: %v", err)
-		return nil, echo.NewHTTPError(http.StatusBadRequest,
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest,
 			c.i18n.Ts("subscribers.errorPreparingQuery", "error", err.Error()))
 	}
 
-	// Create a readonly transaction that just does COUNT() to obtain the count of results
-	// and to ensure that the arbitrary query is indeed readonly.
-	if _, err := c.getSubscriberCount(searchStr, cond, subStatus, listIDs); err != nil {
-		c.log.Printf("error getting subscriber count: %v", err)
-		return nil, err
+	// The read-only transaction ensures that the arbitrary query is indeed readonly,
+	// and the snapshot keeps the batches consistent while the export is streamed.
+	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		c.log.Printf("error preparing subscriber export: %v", err)
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
 	}
 
-	// Prepare the actual query statement.
-	tx, err := c.db.Preparex(stmt)
-	if err != nil {
-		c.log.Printf("error preparing subscriber query: %v", err)
-		return nil, echo.NewHTTPError(http.StatusBadRequest,
+	// Exports are allowed the longer job timeout.
+	if err := c.setJobTimeout(tx); err != nil {
+		tx.Rollback()
+		c.log.Printf("error preparing subscriber export: %v", err)
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
 	}
 
-	id := 0
-	return func() ([]models.SubscriberExport, error) {
-		var out []models.SubscriberExport
-		if err := tx.Select(&out, pq.Array(listIDs), id, pq.Array(subIDs), subStatus, searchStr, batchSize); err != nil {
+	if _, err := tx.Exec("DECLARE subscriber_export NO SCROLL CURSOR FOR "+stmt, args...); err != nil {
+		tx.Rollback()
+		c.log.Printf("error preparing subscriber export: %v", err)
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+
+	if batchSize < 1 {
+		batchSize = 1000
+	}
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM subscriber_export", batchSize)
+
+	next := func() (models.Subscribers, error) {
+		var out models.Subscribers
+		if err := tx.Select(&out, fetch); err != nil {
 			c.log.Printf("error exporting subscribers by query: %v", err)
 			return nil, echo.NewHTTPError(http.StatusInternalServerError,
 				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
@@ -283,15 +522,20 @@ func (c *Core) ExportSubscribers(searchStr, query string, subIDs, listIDs []int,
 			return nil, nil
 		}
 
-		id = out[len(out)-1].ID
+		if err := c.DecryptSubscribers(out); err != nil {
+			return nil, err
+		}
+
 		return out, nil
-	}, nil
+	}
+
+	return next, func() { tx.Rollback() }, nil
 }
 
 // InsertSubscriber inserts a subscriber and returns the ID. The first bool indicates if
 // it was a new subscriber, and the second bool indicates if the subscriber was sent an optin confirmation.
-// bool = optinSent?
-func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs []string, preconfirm, assertOptin bool) (models.Subscriber, bool, error) {
+// bool = optinSent? source is the source of the subscriptions (models.SubscribeSource*).
+func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs []string, preconfirm, assertOptin bool, source string) (models.Subscriber, bool, error) {
 	uu, err := uuid.NewV4()
 	if err != nil {
 		c.log.Printf("error generating UUID: %v", err)
@@ -300,6 +544,15 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 	}
 	sub.UUID = uu.String()
 
+	// The token in the links in messages is independent of the UUID.
+	tok, err := uuid.NewV4()
+	if err != nil {
+		c.log.Printf("error generating UUID: %v", err)
+		return models.Subscriber{}, false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+	}
+	sub.UnsubscribeToken = tok.String()
+
 	subStatus := models.SubscriptionStatusUnconfirmed
 	if preconfirm {
 		subStatus = models.SubscriptionStatusConfirmed
@@ -316,15 +569,23 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 		listUUIDs = []string{}
 	}
 
+	// Encrypt the attributes if any of the lists is sensitive.
+	attribs, err := c.EncryptAttribs(0, sub.Email, listIDs, listUUIDs, sub.Attribs)
+	if err != nil {
+		return models.Subscriber{}, false, err
+	}
+
 	if err = c.q.InsertSubscriber.Get(&sub.ID,
 		sub.UUID,
 		sub.Email,
 		strings.TrimSpace(sub.Name),
 		sub.Status,
-		sub.Attribs,
+		attribs,
 		pq.Array(listIDs),
 		pq.Array(listUUIDs),
-		subStatus); err != nil {
+		subStatus,
+		sub.UnsubscribeToken,
+		source); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "subscribers_email_key" {
 			return models.Subscriber{}, false, echo.NewHTTPError(http.StatusConflict, c.i18n.T("subscribers.emailExists"))
 		} else {
@@ -341,6 +602,10 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 	if err != nil {
 		return models.Subscriber{}, false, err
 	}
+	c.applySubscriberListRules(out.ID)
+	if c.h.SubscriberEvent != nil {
+		c.h.SubscriberEvent(models.SubscriberEventCreated, []models.Subscriber{out})
+	}
 
 	hasOptin := false
 	if !preconfirm && c.consts.SendOptinConfirmation {
@@ -358,6 +623,13 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 
 // UpdateSubscriber updates a subscriber's properties.
 func (c *Core) UpdateSubscriber(id int, sub models.Subscriber) (models.Subscriber, error) {
+	// Encrypt the attributes if any of the subscriber's lists is sensitive.
+	a, err := c.EncryptAttribs(id, "", nil, nil, sub.Attribs)
+	if err != nil {
+		return models.Subscriber{}, err
+	}
+	sub.Attribs = a
+
 	// Format raw JSON attributes.
 	attribs := []byte("{}")
 	if len(sub.Attribs) > 0 {
@@ -370,7 +642,7 @@ func (c *Core) UpdateSubscriber(id int, sub models.Subscriber) (models.Subscribe
 		}
 	}
 
-	_, err := c.q.UpdateSubscriber.Exec(id,
+	_, err = c.q.UpdateSubscriber.Exec(id,
 		sub.Email,
 		strings.TrimSpace(sub.Name),
 		sub.Status,
@@ -386,19 +658,28 @@ func (c *Core) UpdateSubscriber(id int, sub models.Subscriber) (models.Subscribe
 	if err != nil {
 		return models.Subscriber{}, err
 	}
+	c.applySubscriberListRules(out.ID)
 
 	return out, nil
 }
 
 // UpdateSubscriberWithLists updates a subscriber's properties.
 // If deleteLists is set to true, all existing subscriptions are deleted and only
-// the ones provided are added or retained.
-func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs []int, listUUIDs []string, preconfirm, deleteLists, assertOptin bool, permittedListIDs []int, allowResubscribe bool) (models.Subscriber, bool, error) {
+// the ones provided are added or retained. source is the source of the new
+// subscriptions (models.SubscribeSource*).
+func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs []int, listUUIDs []string, preconfirm, deleteLists, assertOptin bool, permittedListIDs []int, allowResubscribe bool, source string) (models.Subscriber, bool, error) {
 	subStatus := models.SubscriptionStatusUnconfirmed
 	if preconfirm {
 		subStatus = models.SubscriptionStatusConfirmed
 	}
 
+	// Encrypt the attributes if any of the subscriber's existing or new lists is sensitive.
+	a, err := c.EncryptAttribs(id, "", listIDs, listUUIDs, sub.Attribs)
+	if err != nil {
+		return models.Subscriber{}, false, err
+	}
+	sub.Attribs = a
+
 	// Format raw JSON attributes.
 	attribs := []byte("{}")
 	if len(sub.Attribs) > 0 {
@@ -411,7 +692,7 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 		}
 	}
 
-	_, err := c.q.UpdateSubscriberWithLists.Exec(id,
+	_, err = c.q.UpdateSubscriberWithLists.Exec(id,
 		sub.Email,
 		strings.TrimSpace(sub.Name),
 		sub.Status,
@@ -421,7 +702,8 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 		subStatus,
 		deleteLists,
 		pq.Array(permittedListIDs),
-		allowResubscribe)
+		allowResubscribe,
+		source)
 	if err != nil {
 		c.log.Printf("error updating subscriber: %v", err)
 		return models.Subscriber{}, false, echo.NewHTTPError(http.StatusInternalServerError,
@@ -432,6 +714,7 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 	if err != nil {
 		return models.Subscriber{}, false, err
 	}
+	c.applySubscriberListRules(out.ID)
 
 	hasOptin := false
 	if !preconfirm && c.consts.SendOptinConfirmation {
@@ -453,17 +736,91 @@ func (c *Core) BlocklistSubscribers(subIDs []int) error {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("subscribers.errorBlocklisting", "error", err.Error()))
 	}
+	c.publishSubscriberEvent(models.SubscriberEventBlocklisted, subIDs, nil)
 
 	return nil
 }
 
+// BlocklistSubscribersByEmail blocklists the existing subscribers with the given
+// (lowercase) e-mails and returns the number of subscribers that were blocklisted.
+func (c *Core) BlocklistSubscribersByEmail(emails []string) (int, error) {
+	var ids []int
+	if err := c.q.BlocklistSubscribersByEmails.Select(&ids, pq.Array(emails)); err != nil {
+		c.log.Printf("error blocklisting subscribers: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("subscribers.errorBlocklisting", "error", pqErrMsg(err)))
+	}
+	if len(ids) > 0 {
+		c.publishSubscriberEvent(models.SubscriberEventBlocklisted, ids, nil)
+	}
+
+	return len(ids), nil
+}
+
+// SuppressSubscribers blocklists the existing subscribers with the given (lowercase)
+// e-mails and records the suppression on their subscriptions to the given list.
+// It returns the number of subscribers that were blocklisted and that already were.
+func (c *Core) SuppressSubscribers(listID int, emails []string) (int, int, error) {
+	var out struct {
+		Suppressed int `db:"suppressed"`
+		Existing   int `db:"existing"`
+	}
+	if err := c.q.SuppressSubscribers.Get(&out, pq.Array(emails), listID); err != nil {
+		c.log.Printf("error suppressing subscribers: %v", err)
+		return 0, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("subscribers.errorBlocklisting", "error", pqErrMsg(err)))
+	}
+
+	return out.Suppressed, out.Existing, nil
+}
+
+// UpdateSubscribersStatus updates the status of the given subscribers, or if there are no IDs,
+// of the subscribers in any of the given lists whose attributes contain attribs. If apply
+// is false, the subscribers are only counted. It returns the number of affected subscribers.
+func (c *Core) UpdateSubscribersStatus(subIDs, listIDs []int, attribs models.JSON, status string, apply bool) (int, error) {
+	if subIDs == nil {
+		subIDs = []int{}
+	}
+	if listIDs == nil {
+		listIDs = []int{}
+	}
+	if attribs == nil {
+		attribs = models.JSON{}
+	}
+
+	// Attributes in sensitive lists are encrypted and can't be queried.
+	if len(subIDs) == 0 && len(attribs) > 0 {
+		if err := c.checkSensitiveQuery("attribs", listIDs); err != nil {
+			return 0, err
+		}
+	}
+
+	var count int
+	if err := c.q.UpdateSubscribersStatus.Get(&count, pq.Array(subIDs), pq.Array(listIDs), attribs, status, apply); err != nil {
+		c.log.Printf("error updating subscriber status: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+	if apply && status == models.SubscriberStatusBlockListed && len(subIDs) > 0 {
+		c.publishSubscriberEvent(models.SubscriberEventBlocklisted, subIDs, nil)
+	}
+
+	return count, nil
+}
+
 // BlocklistSubscribersByQuery blocklists the given list of subscribers.
 func (c *Core) BlocklistSubscribersByQuery(searchStr, queryExp string, listIDs []int, subStatus string) error {
-	if err := c.q.ExecSubQueryTpl(searchStr, sanitizeSQLExp(queryExp), c.q.BlocklistSubscribersByQuery, listIDs, c.db, subStatus); err != nil {
+	if err := c.checkSensitiveQuery(queryExp, listIDs); err != nil {
+		return err
+	}
+
+	ids := []int{}
+	if err := c.q.SelectSubQueryTpl(&ids, searchStr, sanitizeSQLExp(queryExp), c.q.BlocklistSubscribersByQuery, listIDs, c.db, subStatus); err != nil {
 		c.log.Printf("error blocklisting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("subscribers.errorBlocklisting", "error", pqErrMsg(err)))
 	}
+	c.publishSubscriberEvent(models.SubscriberEventBlocklisted, ids, nil)
 
 	return nil
 }
@@ -477,7 +834,7 @@ func (c *Core) DeleteSubscribers(subIDs []int, subUUIDs []string) error {
 		subUUIDs = []string{}
 	}
 
-	if _, err := c.q.DeleteSubscribers.Exec(pq.Array(subIDs), pq.Array(subUUIDs)); err != nil {
+	if _, err := c.q.DeleteSubscribers.Exec(pq.Array(subIDs), pq.Array(subUUIDs), models.SubscriberAnonymizedEmail); err != nil {
 		c.log.Printf("error deleting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
@@ -486,9 +843,75 @@ func (c *Core) DeleteSubscribers(subIDs []int, subUUIDs []string) error {
 	return nil
 }
 
+// AnonymizeSubscribers deletes the given list of subscribers after re-pointing their
+// analytics records (views, clicks, sends, bounces) to the anonymized placeholder
+// subscriber, so that campaign analytics remain unchanged while their PII is removed.
+// It returns the number of subscribers that were anonymized.
+func (c *Core) AnonymizeSubscribers(subIDs []int, subUUIDs []string) (int, error) {
+	if subIDs == nil {
+		subIDs = []int{}
+	}
+	if subUUIDs == nil {
+		subUUIDs = []string{}
+	}
+
+	// UUID and token of the placeholder subscriber if it has to be created.
+	uu, err := uuid.NewV4()
+	if err != nil {
+		c.log.Printf("error generating UUID: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+	}
+	tok, err := uuid.NewV4()
+	if err != nil {
+		c.log.Printf("error generating UUID: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+	}
+
+	tx, err := c.db.Beginx()
+	if err != nil {
+		c.log.Printf("error anonymizing subscribers: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	var ids pq.Int64Array
+	if err := tx.Stmtx(c.q.AnonymizeSubscribers).Select(&ids, pq.Array(subIDs), pq.Array(subUUIDs),
+		models.SubscriberAnonymizedEmail, uu.String(), tok.String()); err != nil {
+		c.log.Printf("error anonymizing subscribers: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// Delete the subscribers along with the rest of their data (subscriptions, attributes etc.)
+	if _, err := tx.Stmtx(c.q.DeleteSubscribers).Exec(ids, pq.StringArray{}, models.SubscriberAnonymizedEmail); err != nil {
+		c.log.Printf("error anonymizing subscribers: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.log.Printf("error anonymizing subscribers: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return len(ids), nil
+}
+
 // DeleteSubscribersByQuery deletes subscribers by a given arbitrary query expression.
 func (c *Core) DeleteSubscribersByQuery(searchStr, queryExp string, listIDs []int, subStatus string) error {
-	err := c.q.ExecSubQueryTpl(searchStr, sanitizeSQLExp(queryExp), c.q.DeleteSubscribersByQuery, listIDs, c.db, subStatus)
+	if err := c.checkSensitiveQuery(queryExp, listIDs); err != nil {
+		return err
+	}
+
+	err := c.q.ExecSubQueryTpl(searchStr, sanitizeSQLExp(queryExp), c.q.DeleteSubscribersByQuery, listIDs, c.db, subStatus,
+		models.SubscriberAnonymizedEmail)
 	if err != nil {
 		c.log.Printf("error deleting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -498,7 +921,29 @@ func (c *Core) DeleteSubscribersByQuery(searchStr, queryExp string, listIDs []in
 	return err
 }
 
-// UnsubscribeByCampaign unsubscribes a given subscriber from lists in a given campaign.
+// AnonymizeSubscribersByQuery anonymizes (see AnonymizeSubscribers) the subscribers
+// matching a given arbitrary query expression.
+func (c *Core) AnonymizeSubscribersByQuery(searchStr, queryExp string, listIDs []int, subStatus string) (int, error) {
+	if err := c.checkSensitiveQuery(queryExp, listIDs); err != nil {
+		return 0, err
+	}
+
+	ids := []int{}
+	err := c.q.SelectSubQueryTpl(&ids, searchStr, sanitizeSQLExp(queryExp), c.q.QuerySubscriberIDsByQuery, listIDs, c.db, subStatus)
+	if err != nil {
+		c.log.Printf("error anonymizing subscribers: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	return c.AnonymizeSubscribers(ids, nil)
+}
+
+// UnsubscribeByCampaign unsubscribes a given subscriber from lists in a given campaign
+// and records the unsubscription on the campaign.
 func (c *Core) UnsubscribeByCampaign(subUUID, campUUID string, blocklist bool) error {
 	if _, err := c.q.UnsubscribeByCampaign.Exec(campUUID, subUUID, blocklist); err != nil {
 		c.log.Printf("error unsubscribing: %v", err)
@@ -506,9 +951,28 @@ func (c *Core) UnsubscribeByCampaign(subUUID, campUUID string, blocklist bool) e
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
 
+	ev := models.SubscriberEventUnsubscribed
+	if blocklist {
+		ev = models.SubscriberEventBlocklisted
+	}
+	c.publishSubscriberEvent(ev, nil, []string{subUUID})
+
 	return nil
 }
 
+// GetResubscribeProtected returns the IDs of the given subscribers who unsubscribed
+// from all their lists within the past given number of days.
+func (c *Core) GetResubscribeProtected(subIDs []int, days int) ([]int, error) {
+	out := []int{}
+	if err := c.q.GetResubscribeProtected.Select(&out, pq.Array(subIDs), days); err != nil {
+		c.log.Printf("error fetching resubscribe protected subscribers: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // ConfirmOptionSubscription confirms a subscriber's optin subscription.
 func (c *Core) ConfirmOptionSubscription(subUUID string, listUUIDs []string, meta models.JSON) error {
 	if meta == nil {
@@ -520,6 +984,23 @@ func (c *Core) ConfirmOptionSubscription(subUUID string, listUUIDs []string, met
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
+	c.publishSubscriberEvent(models.SubscriberEventConfirmed, nil, []string{subUUID})
+
+	return nil
+}
+
+// DeclineOptinSubscription unsubscribes a subscriber from the unconfirmed
+// lists that were declined on the opt-in confirmation page.
+func (c *Core) DeclineOptinSubscription(subUUID string, listUUIDs []string, meta models.JSON) error {
+	if meta == nil {
+		meta = models.JSON{}
+	}
+
+	if _, err := c.q.DeclineSubscriptionOptin.Exec(subUUID, pq.Array(listUUIDs), meta); err != nil {
+		c.log.Printf("error declining subscription: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
 
 	return nil
 }
@@ -542,7 +1023,7 @@ func (c *Core) DeleteSubscriberBounces(id int, uuid string) error {
 
 // DeleteOrphanSubscribers deletes orphan subscriber records (subscribers without lists).
 func (c *Core) DeleteOrphanSubscribers() (int, error) {
-	res, err := c.q.DeleteOrphanSubscribers.Exec()
+	res, err := c.q.DeleteOrphanSubscribers.Exec(models.SubscriberAnonymizedEmail)
 	if err != nil {
 		c.log.Printf("error deleting orphan subscribers: %v", err)
 		return 0, echo.NewHTTPError(http.StatusInternalServerError,
@@ -555,7 +1036,7 @@ func (c *Core) DeleteOrphanSubscribers() (int, error) {
 
 // DeleteBlocklistedSubscribers deletes blocklisted subscribers.
 func (c *Core) DeleteBlocklistedSubscribers() (int, error) {
-	res, err := c.q.DeleteBlocklistedSubscribers.Exec()
+	res, err := c.q.DeleteBlocklistedSubscribers.Exec(models.SubscriberAnonymizedEmail)
 	if err != nil {
 		c.log.Printf("error deleting blocklisted subscribers: %v", err)
 		return 0, echo.NewHTTPError(http.StatusInternalServerError,
@@ -566,7 +1047,76 @@ func (c *Core) DeleteBlocklistedSubscribers() (int, error) {
 	return int(n), nil
 }
 
-func (c *Core) getSubscriberCount(searchStr, queryExp, subStatus string, listIDs []int) (int, error) {
+// publishSubscriberEvent looks up the e-mails of the given subscribers (by IDs or
+// UUIDs) and publishes a lifecycle event (models.SubscriberEvent*) of them via the
+// SubscriberEvent hook.
+func (c *Core) publishSubscriberEvent(typ string, subIDs []int, subUUIDs []string) {
+	if c.h.SubscriberEvent == nil {
+		return
+	}
+	if c.h.HasSubscriberEventListeners != nil && !c.h.HasSubscriberEventListeners() {
+		return
+	}
+	if len(subIDs) == 0 && len(subUUIDs) == 0 {
+		return
+	}
+	if subIDs == nil {
+		subIDs = []int{}
+	}
+	if subUUIDs == nil {
+		subUUIDs = []string{}
+	}
+
+	var subs []models.Subscriber
+	if err := c.q.GetSubscriberEmails.Select(&subs, pq.Array(subIDs), pq.Array(subUUIDs)); err != nil {
+		c.log.Printf("error fetching subscribers for events: %v", err)
+		return
+	}
+	if len(subs) > 0 {
+		c.h.SubscriberEvent(typ, subs)
+	}
+}
+
+// makeSubQuery returns the raw SQL statement and the positional arguments for querying
+// subscribers with the given filters and order. It's shared by QuerySubscribers and
+// ExportSubscribers so that exports match queries exactly. If subIDs are given, the
+// results are limited to them. A limit < 1 returns all the results.
+func (c *Core) makeSubQuery(searchStr, queryExp string, subIDs, listIDs []int, subStatus, order, orderBy string, offset, limit int) (string, []any) {
+	// Sort params.
+	if !strSliceContains(orderBy, subQuerySortFields) {
+		orderBy = "subscribers.id"
+	}
+	if order != SortAsc && order != SortDesc {
+		order = SortDesc
+	}
+
+	// There's an arbitrary query condition.
+	cond := "TRUE"
+	if queryExp != "" {
+		cond = queryExp
+	}
+
+	args := []any{pq.Array(listIDs), subStatus, searchStr, offset, limit, models.SubscriberAnonymizedEmail}
+	if len(subIDs) > 0 {
+		cond = "subscribers.id = ANY($7::INT[]) AND (" + cond + "\n)"
+		args = append(args, pq.Array(subIDs))
+	}
+
+	// The ID breaks ties in the order so that pages (and export batches) are stable.
+	ord := orderBy + " " + order
+	if orderBy != "subscribers.id" {
+		ord += ", subscribers.id " + order
+	}
+
+	stmt := strings.ReplaceAll(c.q.QuerySubscribers, "%query%", cond)
+	stmt = strings.ReplaceAll(stmt, "%order%", ord)
+
+	return stmt, args
+}
+
+// getSubscriberCount returns the number of subscribers matching the given conditions.
+// args are the positional arguments of queryExp, if any, starting from $5.
+func (c *Core) getSubscriberCount(searchStr, queryExp, subStatus string, listIDs []int, args ...any) (int, error) {
 	// If there's no condition, it's a "get all" call which can probably be optionally pulled from cache.
 	if queryExp == "" {
 		_ = c.refreshCache(matListSubStats, false)
@@ -592,7 +1142,7 @@ func (c *Core) getSubscriberCount(searchStr, queryExp, subStatus string, listIDs
 
 	// Execute the readonly query and get the count of results.
 	total := 0
-	if err := tx.Get(&total, stmt, pq.Array(listIDs), subStatus, searchStr); err != nil {
+	if err := tx.Get(&total, stmt, append([]any{pq.Array(listIDs), subStatus, searchStr, models.SubscriberAnonymizedEmail}, args...)...); err != nil {
 		return 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
@@ -669,3 +1219,146 @@ func traverseQueryPlan(node map[string]any, tables map[string]struct{}) {
 		}
 	}
 }
+
+// CreateImportArchive records the archive of an import file of the given size.
+// The file is to be stored at the returned path under the given directory.
+func (c *Core) CreateImportArchive(filename, dir string, size int64) (models.ImportArchive, error) {
+	var out models.ImportArchive
+	if err := c.q.InsertImportArchive.Get(&out, filename, dir, size); err != nil {
+		c.log.Printf("error creating import archive: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{import.archive}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetImportArchive retrieves an import archive by its ID.
+func (c *Core) GetImportArchive(id int) (models.ImportArchive, error) {
+	var out models.ImportArchive
+	if err := c.q.GetImportArchive.Get(&out, id); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusNotFound,
+				c.i18n.Ts("globals.messages.notFound", "name", "{import.archive}"))
+		}
+
+		c.log.Printf("error fetching import archive: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{import.archive}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetExpiredImportArchives retrieves the import archives older than the given number of days.
+func (c *Core) GetExpiredImportArchives(days int) ([]models.ImportArchive, error) {
+	out := []models.ImportArchive{}
+	if err := c.q.GetExpiredImportArchives.Select(&out, days); err != nil {
+		c.log.Printf("error fetching expired import archives: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{import.archive}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// DeleteImportArchive deletes the record of an import archive.
+func (c *Core) DeleteImportArchive(id int) error {
+	if _, err := c.q.DeleteImportArchive.Exec(id); err != nil {
+		c.log.Printf("error deleting import archive: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{import.archive}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// CreateReconfirmations creates re-confirmations that expire in confirmDays for the subscribers
+// on the given lists who haven't interacted with any campaign in the last inactiveDays.
+func (c *Core) CreateReconfirmations(inactiveDays, confirmDays int, listIDs []int) ([]models.Reconfirmation, error) {
+	out := []models.Reconfirmation{}
+	if err := c.q.CreateReconfirmations.Select(&out, inactiveDays, pq.Array(listIDs), confirmDays); err != nil {
+		c.log.Printf("error creating re-confirmations: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{subscribers.reconfirmations}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetReconfirmation retrieves a re-confirmation by its UUID.
+func (c *Core) GetReconfirmation(uuid string) (models.Reconfirmation, error) {
+	var out models.Reconfirmation
+	if err := c.q.GetReconfirmation.Get(&out, uuid); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusNotFound,
+				c.i18n.Ts("globals.messages.notFound", "name", "{subscribers.reconfirmations}"))
+		}
+
+		c.log.Printf("error fetching re-confirmation: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{subscribers.reconfirmations}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// ConfirmReconfirmation confirms a pending re-confirmation that hasn't expired.
+// It returns false if there was no such re-confirmation.
+func (c *Core) ConfirmReconfirmation(uuid string) (bool, error) {
+	var out models.Reconfirmation
+	if err := c.q.ConfirmReconfirmation.Get(&out, uuid); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		c.log.Printf("error confirming re-confirmation: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{subscribers.reconfirmations}", "error", pqErrMsg(err)))
+	}
+
+	return true, nil
+}
+
+// ExpireReconfirmations expires the pending re-confirmations past their expiry
+// and unsubscribes their subscribers from the lists, recording the unsubscriptions
+// as consent events. It returns the number of re-confirmations that were expired.
+func (c *Core) ExpireReconfirmations() (int, error) {
+	var n int
+	if err := c.q.ExpireReconfirmations.Get(&n, c.i18n.T("subscribers.consentReconfirmExpired")); err != nil {
+		c.log.Printf("error expiring re-confirmations: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{subscribers.reconfirmations}", "error", pqErrMsg(err)))
+	}
+
+	return n, nil
+}
+
+// RecordConsent records a consent event of subscribers in the consents audit trail.
+func (c *Core) RecordConsent(e models.ConsentEvent) error {
+	if _, err := c.q.InsertConsents.Exec(pq.Array(e.SubscriberIDs), pq.Array(e.ListIDs), pq.Array(e.ListUUIDs),
+		e.Type, e.CampaignUUID, e.IP, e.UserAgent, e.Text, e.AllLists); err != nil {
+		c.log.Printf("error recording consent: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{subscribers.consents}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetSubscriberConsents returns a subscriber's consent events, the latest first.
+func (c *Core) GetSubscriberConsents(id, offset, limit int) ([]models.Consent, int, error) {
+	out := []models.Consent{}
+	if err := c.q.GetSubscriberConsents.Select(&out, id, offset, limit); err != nil {
+		c.log.Printf("error fetching subscriber consents: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{subscribers.consents}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
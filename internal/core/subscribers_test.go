@@ -0,0 +1,126 @@
+package core
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/lib/pq"
+)
+
+func TestMakeSubQuery(t *testing.T) {
+	c := &Core{q: &models.Queries{QuerySubscribers: "WHERE %query% ORDER BY %order%"}}
+
+	cases := []struct {
+		name     string
+		queryExp string
+		subIDs   []int
+		order    string
+		orderBy  string
+		wantStmt string
+		wantArgs int
+	}{
+		{"defaults", "", nil, "", "", "WHERE TRUE ORDER BY subscribers.id desc", 6},
+		{"unknown sort field", "", nil, "asc", "password", "WHERE TRUE ORDER BY subscribers.id asc", 6},
+		{"unknown sort order", "", nil, "sideways", "email", "WHERE TRUE ORDER BY email desc, subscribers.id desc", 6},
+		{"ties broken by ID", "", nil, "asc", "name", "WHERE TRUE ORDER BY name asc, subscribers.id asc", 6},
+		{"query expression", "subscribers.attribs->>'city' = 'Berlin'", nil, "", "",
+			"WHERE subscribers.attribs->>'city' = 'Berlin' ORDER BY subscribers.id desc", 6},
+		{"subscriber IDs", "subscribers.name ~* 'a'", []int{1, 2}, "", "",
+			"WHERE subscribers.id = ANY($7::INT[]) AND (subscribers.name ~* 'a'\n) ORDER BY subscribers.id desc", 7},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stmt, args := c.makeSubQuery("", tc.queryExp, tc.subIDs, []int{}, "", tc.order, tc.orderBy, 0, 0)
+			if stmt != tc.wantStmt {
+				t.Errorf("expected statement %q, got %q", tc.wantStmt, stmt)
+			}
+			if len(args) != tc.wantArgs {
+				t.Fatalf("expected %d args, got %d", tc.wantArgs, len(args))
+			}
+			if args[5] != models.SubscriberAnonymizedEmail {
+				t.Errorf("expected the anonymized e-mail as $6, got %v", args[5])
+			}
+			if tc.subIDs != nil && !reflect.DeepEqual(args[6], pq.Array(tc.subIDs)) {
+				t.Errorf("expected subscriber IDs %v as $7, got %v", tc.subIDs, args[6])
+			}
+		})
+	}
+}
+
+func TestMakeSubQueryExportParity(t *testing.T) {
+	c := &Core{q: &models.Queries{QuerySubscribers: "WHERE %query% ORDER BY %order% OFFSET $4 LIMIT $5"}}
+
+	var (
+		search  = "john"
+		query   = "subscribers.attribs->>'city' = 'Berlin'"
+		listIDs = []int{1, 2}
+		status  = "confirmed"
+	)
+
+	// The export (ExportSubscribers) has to select exactly what the query (QuerySubscribers)
+	// selects, without the pagination.
+	qStmt, qArgs := c.makeSubQuery(search, query, nil, listIDs, status, "asc", "created_at", 20, 10)
+	eStmt, eArgs := c.makeSubQuery(search, query, nil, listIDs, status, "asc", "created_at", 0, 0)
+
+	if qStmt != eStmt {
+		t.Fatalf("expected identical statements, got %q and %q", qStmt, eStmt)
+	}
+	if !strings.Contains(eStmt, "created_at asc, subscribers.id asc") {
+		t.Errorf("expected the export order to be stable, got %q", eStmt)
+	}
+
+	// Only the offset ($4) and limit ($5) differ.
+	if !reflect.DeepEqual(qArgs[:3], eArgs[:3]) {
+		t.Errorf("expected identical filter args, got %v and %v", qArgs[:3], eArgs[:3])
+	}
+	if eArgs[3] != 0 || eArgs[4] != 0 {
+		t.Errorf("expected an unpaginated export, got offset=%v limit=%v", eArgs[3], eArgs[4])
+	}
+}
+
+func TestGetSubscriberAttribStats(t *testing.T) {
+	cases := []struct {
+		name    string
+		listIDs []int
+		want    string
+	}{
+		// Admins without a list filter get nil list IDs, which should be all subscribers.
+		{"no lists", nil, "{}"},
+		{"lists", []int{1, 2}, "{1,2}"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got driver.Value
+			db := newFakeDB(t, map[string]fakeHandler{
+				"get-subscriber-attrib-stats": func(args []driver.Value) fakeResult {
+					// A NULL array makes CARDINALITY() NULL in the query, which matches nothing.
+					got = args[1]
+					total := int64(0)
+					if got != nil {
+						total = 3
+					}
+					return fakeResult{cols: []string{"type", "total"}, rows: [][]driver.Value{{"string", total}}}
+				},
+			})
+
+			c := newTestCore(t, db)
+			c.q = &models.Queries{GetSubscriberAttribStats: prepare(t, db, "get-subscriber-attrib-stats")}
+
+			out, err := c.GetSubscriberAttribStats("city", tc.listIDs, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("expected list IDs %q, got %v", tc.want, got)
+			}
+			if out.Total != 3 || out.Key != "city" {
+				t.Errorf("expected the stats of city, got %+v", out)
+			}
+		})
+	}
+}
@@ -22,9 +22,35 @@ func (c *Core) GetSubscriptions(subID int, subUUID string, allLists bool) ([]mod
 	return out, err
 }
 
-// AddSubscriptions adds list subscriptions to subscribers.
+// AddSubscriptions adds list subscriptions to subscribers. If any of the lists is sensitive,
+// the subscribers' attributes are encrypted along with the subscriptions.
 func (c *Core) AddSubscriptions(subIDs, listIDs []int, status string) error {
-	if _, err := c.q.AddSubscribersToLists.Exec(pq.Array(subIDs), pq.Array(listIDs), status); err != nil {
+	sensitive, err := c.hasSensitiveLists(0, "", listIDs, nil)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.Beginx()
+	if err != nil {
+		c.log.Printf("error adding subscriptions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Stmtx(c.q.AddSubscribersToLists).Exec(pq.Array(subIDs), pq.Array(listIDs), status); err != nil {
+		c.log.Printf("error adding subscriptions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
+	}
+
+	if sensitive {
+		if err := c.encryptPlainAttribs(tx, subIDs); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
 		c.log.Printf("error adding subscriptions: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
@@ -36,6 +62,10 @@ func (c *Core) AddSubscriptions(subIDs, listIDs []int, status string) error {
 // AddSubscriptionsByQuery adds list subscriptions to subscribers by a given arbitrary query expression.
 // sourceListIDs is the list of list IDs to filter the subscriber query with.
 func (c *Core) AddSubscriptionsByQuery(searchStr, queryExp string, sourceListIDs, targetListIDs []int, status string, subStatus string) error {
+	if err := c.checkSensitiveQuery(queryExp, sourceListIDs); err != nil {
+		return err
+	}
+
 	if sourceListIDs == nil {
 		sourceListIDs = []int{}
 	}
@@ -65,6 +95,10 @@ func (c *Core) DeleteSubscriptions(subIDs, listIDs []int) error {
 // DeleteSubscriptionsByQuery deletes list subscriptions from subscribers by a given arbitrary query expression.
 // sourceListIDs is the list of list IDs to filter the subscriber query with.
 func (c *Core) DeleteSubscriptionsByQuery(searchStr, queryExp string, sourceListIDs, targetListIDs []int, subStatus string) error {
+	if err := c.checkSensitiveQuery(queryExp, sourceListIDs); err != nil {
+		return err
+	}
+
 	if sourceListIDs == nil {
 		sourceListIDs = []int{}
 	}
@@ -86,6 +120,7 @@ func (c *Core) UnsubscribeLists(subIDs, listIDs []int, listUUIDs []string) error
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
 	}
+	c.publishSubscriberEvent(models.SubscriberEventUnsubscribed, subIDs, nil)
 
 	return nil
 }
@@ -93,16 +128,22 @@ func (c *Core) UnsubscribeLists(subIDs, listIDs []int, listUUIDs []string) error
 // UnsubscribeListsByQuery sets list subscriptions to 'unsubscribed' by a given arbitrary query expression.
 // sourceListIDs is the list of list IDs to filter the subscriber query with.
 func (c *Core) UnsubscribeListsByQuery(searchStr, queryExp string, sourceListIDs, targetListIDs []int, subStatus string) error {
+	if err := c.checkSensitiveQuery(queryExp, sourceListIDs); err != nil {
+		return err
+	}
+
 	if sourceListIDs == nil {
 		sourceListIDs = []int{}
 	}
 
-	err := c.q.ExecSubQueryTpl(searchStr, queryExp, c.q.UnsubscribeSubscribersFromListsByQuery, sourceListIDs, c.db, subStatus, pq.Array(targetListIDs))
+	ids := []int{}
+	err := c.q.SelectSubQueryTpl(&ids, searchStr, queryExp, c.q.UnsubscribeSubscribersFromListsByQuery, sourceListIDs, c.db, subStatus, pq.Array(targetListIDs))
 	if err != nil {
 		c.log.Printf("error unsubscribing from lists by query: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
+	c.publishSubscriberEvent(models.SubscriberEventUnsubscribed, ids, nil)
 
 	return nil
 }
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/attribcrypt"
+	"github.com/knadh/listmonk/models"
+)
+
+func TestAddSubscriptionsEncryptsAttribs(t *testing.T) {
+	cipher, err := attribcrypt.New(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name        string
+		listIDs     []int
+		wantEncrypt bool
+	}{
+		{"regular list", []int{1}, false},
+		{"sensitive list", []int{2}, true},
+		{"regular and sensitive lists", []int{1, 2}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				subscribed bool
+				saved      = map[int64][]byte{}
+			)
+
+			db := newFakeDB(t, map[string]fakeHandler{
+				"has-sensitive-lists": func(args []driver.Value) fakeResult {
+					// List 2 is sensitive.
+					return fakeResult{cols: []string{"exists"}, rows: [][]driver.Value{{strings.Contains(args[2].(string), "2")}}}
+				},
+				"add-subscribers-to-lists": func(args []driver.Value) fakeResult {
+					subscribed = true
+					return fakeResult{affected: 1}
+				},
+				"get-plain-attribs": func(args []driver.Value) fakeResult {
+					return fakeResult{
+						cols: []string{"id", "attribs"},
+						rows: [][]driver.Value{{int64(1), []byte(`{"city": "Berlin"}`)}},
+					}
+				},
+				"update-subscriber-attribs": func(args []driver.Value) fakeResult {
+					saved[int64(args[0].(int))] = args[1].([]byte)
+					return fakeResult{affected: 1}
+				},
+			})
+
+			c := newTestCore(t, db)
+			c.attribs = cipher
+			c.q = &models.Queries{
+				HasSensitiveLists:       prepare(t, db, "has-sensitive-lists"),
+				AddSubscribersToLists:   prepare(t, db, "add-subscribers-to-lists"),
+				GetPlainAttribs:         prepare(t, db, "get-plain-attribs"),
+				UpdateSubscriberAttribs: prepare(t, db, "update-subscriber-attribs"),
+			}
+
+			if err := c.AddSubscriptions([]int{1}, tc.listIDs, ""); err != nil {
+				t.Fatal(err)
+			}
+			if !subscribed {
+				t.Fatal("expected the subscriptions to be added")
+			}
+
+			b, ok := saved[1]
+			if ok != tc.wantEncrypt {
+				t.Fatalf("expected encrypted=%v, got %v", tc.wantEncrypt, ok)
+			}
+			if !tc.wantEncrypt {
+				return
+			}
+
+			var enc models.JSON
+			if err := json.Unmarshal(b, &enc); err != nil {
+				t.Fatal(err)
+			}
+			if !attribcrypt.IsEncrypted(enc) {
+				t.Fatalf("expected encrypted attributes, got %s", b)
+			}
+			if dec, err := cipher.Decrypt(enc); err != nil || dec["city"] != "Berlin" {
+				t.Errorf("expected the original attributes, got %v (%v)", dec, err)
+			}
+		})
+	}
+}
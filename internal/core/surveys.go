@@ -0,0 +1,85 @@
+package core
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/volatiletech/null.v6"
+)
+
+// UpdateCampaignSurvey sets the questions of a campaign's survey.
+func (c *Core) UpdateCampaignSurvey(id int, questions models.SurveyQuestions) error {
+	if _, err := c.q.UpdateCampaignSurvey.Exec(id, questions); err != nil {
+		c.log.Printf("error updating campaign survey: %v", err)
+
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// RecordSurveyResponse records the answer to a question in a campaign's survey.
+// subToken is the subscriber's token, or empty to record the answer anonymously.
+func (c *Core) RecordSurveyResponse(campUUID, subToken, questionID, answer string) error {
+	camp, err := c.GetCampaign(0, campUUID, "")
+	if err != nil {
+		return err
+	}
+
+	q, ok := camp.Survey.Get(questionID)
+	if !ok || !q.IsValidAnswer(answer) {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("public.invalidSurveyAnswer"))
+	}
+
+	if _, err := c.q.RecordSurveyResponse.Exec(camp.ID, subToken, questionID, answer, c.allowLegacyUUIDLinks()); err != nil {
+		c.log.Printf("error recording survey response: %v", err)
+
+		return echo.NewHTTPError(http.StatusInternalServerError, c.i18n.T("public.errorProcessingRequest"))
+	}
+
+	return nil
+}
+
+// GetSurveyResults returns the aggregated responses to each of the questions
+// in a campaign's survey.
+func (c *Core) GetSurveyResults(id int, questions models.SurveyQuestions) ([]models.SurveyResult, error) {
+	var counts []models.SurveyAnswerCount
+	if err := c.q.GetSurveyResults.Select(&counts, id); err != nil {
+		c.log.Printf("error fetching survey results: %v", err)
+
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	out := make([]models.SurveyResult, len(questions))
+	for i, q := range questions {
+		r := models.SurveyResult{SurveyQuestion: q, Answers: []models.SurveyAnswerCount{}}
+
+		sum := 0
+		for _, a := range counts {
+			if a.QuestionID != q.ID {
+				continue
+			}
+
+			r.Total += a.Count
+			r.Answers = append(r.Answers, a)
+
+			// Answers to rating questions are validated to be numbers when they're recorded.
+			if q.Type == models.SurveyQuestionRating {
+				n, _ := strconv.Atoi(a.Answer)
+				sum += n * a.Count
+			}
+		}
+
+		if q.Type == models.SurveyQuestionRating && r.Total > 0 {
+			r.Average = null.Float64From(float64(sum) / float64(r.Total))
+		}
+
+		out[i] = r
+	}
+
+	return out, nil
+}
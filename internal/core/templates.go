@@ -12,7 +12,18 @@ import (
 // GetTemplates retrieves all templates.
 func (c *Core) GetTemplates(status string, noBody bool) ([]models.Template, error) {
 	out := []models.Template{}
-	if err := c.q.GetTemplates.Select(&out, 0, noBody, status); err != nil {
+	if err := c.q.GetTemplates.Select(&out, 0, noBody, status, false); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.templates}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetLibraryTemplates retrieves the templates in the template library without their bodies.
+func (c *Core) GetLibraryTemplates() ([]models.Template, error) {
+	out := []models.Template{}
+	if err := c.q.GetTemplates.Select(&out, 0, true, "", true); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.templates}", "error", pqErrMsg(err)))
 	}
@@ -23,7 +34,7 @@ func (c *Core) GetTemplates(status string, noBody bool) ([]models.Template, erro
 // GetTemplate retrieves a given template.
 func (c *Core) GetTemplate(id int, noBody bool) (models.Template, error) {
 	var out []models.Template
-	if err := c.q.GetTemplates.Select(&out, id, noBody, ""); err != nil {
+	if err := c.q.GetTemplates.Select(&out, id, noBody, "", false); err != nil {
 		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.templates}", "error", pqErrMsg(err)))
 	}
@@ -36,10 +47,25 @@ func (c *Core) GetTemplate(id int, noBody bool) (models.Template, error) {
 	return out[0], nil
 }
 
+// GetLatestTemplateVersion retrieves the most recently saved previous version of a template.
+func (c *Core) GetLatestTemplateVersion(tplID int) (models.TemplateVersion, error) {
+	var out models.TemplateVersion
+	if err := c.q.GetLatestTemplateVersion.Get(&out, tplID); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("templates.noVersions"))
+		}
+
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // CreateTemplate creates a new template.
-func (c *Core) CreateTemplate(name, typ, subject string, body []byte, bodySource null.String) (models.Template, error) {
+func (c *Core) CreateTemplate(name, typ, subject string, body []byte, bodySource, lang null.String, parentID null.Int) (models.Template, error) {
 	var newID int
-	if err := c.q.CreateTemplate.Get(&newID, name, typ, subject, body, bodySource); err != nil {
+	if err := c.q.CreateTemplate.Get(&newID, name, typ, subject, body, bodySource, lang, parentID); err != nil {
 		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
 	}
@@ -47,17 +73,27 @@ func (c *Core) CreateTemplate(name, typ, subject string, body []byte, bodySource
 	return c.GetTemplate(newID, false)
 }
 
-// UpdateTemplate updates a given template.
-func (c *Core) UpdateTemplate(id int, name, subject string, body []byte, bodySource null.String) (models.Template, error) {
-	res, err := c.q.UpdateTemplate.Exec(id, name, subject, body, bodySource)
+// UpdateTemplate updates a given template. updatedAt (the time at which the template was
+// last updated when it was read) is required and ErrConflict is returned if the template
+// has been modified since then.
+func (c *Core) UpdateTemplate(id int, name, subject string, body []byte, bodySource, lang null.String, parentID null.Int, updatedAt null.Time) (models.Template, error) {
+	if !updatedAt.Valid {
+		return models.Template{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.missingFields", "name", "updated_at"))
+	}
+
+	res, err := c.q.UpdateTemplate.Exec(id, name, subject, body, bodySource, updatedAt, lang, parentID)
 	if err != nil {
 		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
 	}
 
 	if n, _ := res.RowsAffected(); n == 0 {
-		return models.Template{}, echo.NewHTTPError(http.StatusBadRequest,
-			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.template}"))
+		// The template either doesn't exist or has been modified.
+		if _, err := c.GetTemplate(id, true); err != nil {
+			return models.Template{}, err
+		}
+		return models.Template{}, ErrConflict
 	}
 
 	return c.GetTemplate(id, false)
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetTopics retrieves all topics.
+func (c *Core) GetTopics() ([]models.Topic, error) {
+	out := []models.Topic{}
+	if err := c.q.GetTopics.Select(&out, 0); err != nil {
+		c.log.Printf("error fetching topics: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.topics}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetTopic retrieves a given topic.
+func (c *Core) GetTopic(id int) (models.Topic, error) {
+	var out []models.Topic
+	if err := c.q.GetTopics.Select(&out, id); err != nil {
+		c.log.Printf("error fetching topic: %v", err)
+		return models.Topic{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.topic}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.Topic{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.topic}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateTopic creates a new topic.
+func (c *Core) CreateTopic(t models.Topic) (models.Topic, error) {
+	var newID int
+	if err := c.q.CreateTopic.Get(&newID, t.Name, t.Description); err != nil {
+		c.log.Printf("error creating topic: %v", err)
+		return models.Topic{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.topic}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetTopic(newID)
+}
+
+// UpdateTopic updates a given topic.
+func (c *Core) UpdateTopic(id int, t models.Topic) (models.Topic, error) {
+	res, err := c.q.UpdateTopic.Exec(id, t.Name, t.Description)
+	if err != nil {
+		c.log.Printf("error updating topic: %v", err)
+		return models.Topic{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.topic}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Topic{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.topic}"))
+	}
+
+	return c.GetTopic(id)
+}
+
+// DeleteTopic deletes a given topic and removes it from campaigns.
+func (c *Core) DeleteTopic(id int) error {
+	if _, err := c.q.DeleteTopic.Exec(id); err != nil {
+		c.log.Printf("error deleting topic: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.topic}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetSubscriberTopics retrieves all topics along with the given subscriber's
+// preference for each of them.
+func (c *Core) GetSubscriberTopics(subID int) ([]models.SubscriberTopic, error) {
+	out := []models.SubscriberTopic{}
+	if err := c.q.GetSubscriberTopics.Select(&out, subID); err != nil {
+		c.log.Printf("error fetching subscriber topics: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.topics}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// UpdateSubscriberTopics records a subscriber's topic preferences. The subscriber
+// is subscribed to the given topic IDs and opted out of all other topics.
+func (c *Core) UpdateSubscriberTopics(subID int, topicIDs []int) error {
+	if topicIDs == nil {
+		topicIDs = []int{}
+	}
+
+	if _, err := c.q.UpdateSubscriberTopics.Exec(subID, pq.Array(topicIDs)); err != nil {
+		c.log.Printf("error updating subscriber topics: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.topics}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
@@ -133,6 +133,19 @@ func (c *Core) UpdateUserLogin(id int, avatar string) error {
 	return nil
 }
 
+// RecordUserLoginIP records the IP a user logged in from and returns true if
+// the user has logged in before, but never from the IP.
+func (c *Core) RecordUserLoginIP(id int, ip string) (bool, error) {
+	var isNew bool
+	if err := c.q.RecordUserLoginIP.Get(&isNew, id, ip); err != nil {
+		c.log.Printf("error recording user login IP: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.user}", "error", pqErrMsg(err)))
+	}
+
+	return isNew, nil
+}
+
 // SetTwoFA sets or clears the 2FA configuration for a user.
 func (c *Core) SetTwoFA(id int, twofaType, twofaKey string) error {
 	if _, err := c.q.SetUserTwoFA.Exec(id, twofaType, twofaKey); err != nil {
@@ -7,11 +7,13 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"slices"
 	"sync"
 )
 
 const (
-	TypeError = "error"
+	TypeError      = "error"
+	TypeSubscriber = "subscriber"
 )
 
 // Event represents a single event in the system.
@@ -24,30 +26,36 @@ type Event struct {
 }
 
 type Events struct {
-	subs map[string]chan Event
+	subs map[string]sub
 	sync.RWMutex
 }
 
+// sub is a subscription to the events of the given types (all types if empty).
+type sub struct {
+	ch    chan Event
+	types []string
+}
+
 // New returns a new instance of Events.
 func New() *Events {
 	return &Events{
-		subs: make(map[string]chan Event),
+		subs: make(map[string]sub),
 	}
 }
 
 // Subscribe returns a channel to which the given event `types` are streamed.
-// id is the unique identifier for the caller. A caller can only register
-// for subscription once.
-func (ev *Events) Subscribe(id string) (chan Event, error) {
+// If no types are given, all events are streamed. id is the unique identifier
+// for the caller. A caller can only register for subscription once.
+func (ev *Events) Subscribe(id string, types ...string) (chan Event, error) {
 	ev.Lock()
 	defer ev.Unlock()
 
-	if ch, ok := ev.subs[id]; ok {
-		return ch, nil
+	if s, ok := ev.subs[id]; ok {
+		return s.ch, nil
 	}
 
 	ch := make(chan Event, 100)
-	ev.subs[id] = ch
+	ev.subs[id] = sub{ch: ch, types: types}
 
 	return ch, nil
 }
@@ -59,20 +67,40 @@ func (ev *Events) Unsubscribe(id string) {
 	delete(ev.subs, id)
 }
 
-// Publish publishes an event to all subscribers.
+// HasSubscribers checks whether there are any subscribers to events of the given type.
+func (ev *Events) HasSubscribers(typ string) bool {
+	ev.RLock()
+	defer ev.RUnlock()
+
+	for _, s := range ev.subs {
+		if len(s.types) == 0 || slices.Contains(s.types, typ) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Publish publishes an event to all subscribers of its type. A subscriber whose
+// queue is full misses the event.
 func (ev *Events) Publish(e Event) error {
 	ev.Lock()
 	defer ev.Unlock()
 
-	for _, ch := range ev.subs {
+	var err error
+	for _, s := range ev.subs {
+		if len(s.types) > 0 && !slices.Contains(s.types, e.Type) {
+			continue
+		}
+
 		select {
-		case ch <- e:
+		case s.ch <- e:
 		default:
-			return fmt.Errorf("event queue full for type: %s", e.Type)
+			err = fmt.Errorf("event queue full for type: %s", e.Type)
 		}
 	}
 
-	return nil
+	return err
 }
 
 // This implements an io.Writer specifically for receiving error messages
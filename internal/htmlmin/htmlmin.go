@@ -0,0 +1,107 @@
+// Package htmlmin implements a conservative minifier for e-mail HTML that is
+// safe for the Go template expressions in campaign and template bodies. Tags are
+// never rewritten, so template expressions in attributes are left as-is.
+package htmlmin
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// rawTags are the tags whose contents are whitespace sensitive or aren't HTML,
+// and are never minified.
+var rawTags = map[string]bool{
+	"pre":      true,
+	"textarea": true,
+	"script":   true,
+	"style":    true,
+}
+
+// Minify removes comments and collapses whitespace runs in the text of an HTML
+// document to a single space. Conditional comments (eg: <!--[if mso]>) that e-mail
+// clients such as Outlook rely on and comments with template expressions are retained.
+func Minify(s string) string {
+	var (
+		z   = html.NewTokenizer(strings.NewReader(s))
+		out strings.Builder
+
+		// Depth of the raw tags the tokenizer is in.
+		raw = 0
+	)
+	out.Grow(len(s))
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// The only error from a strings.Reader is io.EOF.
+			return out.String()
+		}
+
+		switch tt {
+		case html.CommentToken:
+			if b := z.Raw(); keepComment(b) {
+				out.Write(b)
+			}
+
+		case html.TextToken:
+			if raw > 0 {
+				out.Write(z.Raw())
+				continue
+			}
+
+			// Text around a removed comment may leave two spaces next to each other.
+			b := collapseSpace(z.Raw())
+			if len(b) > 0 && b[0] == ' ' && strings.HasSuffix(out.String(), " ") {
+				b = b[1:]
+			}
+			out.Write(b)
+
+		case html.StartTagToken, html.EndTagToken:
+			// TagName() lower-cases the token's buffer in place, so write the raw tag first.
+			out.Write(z.Raw())
+
+			name, _ := z.TagName()
+			if !rawTags[string(name)] {
+				continue
+			}
+			if tt == html.StartTagToken {
+				raw++
+			} else if raw > 0 {
+				raw--
+			}
+
+		default:
+			out.Write(z.Raw())
+		}
+	}
+}
+
+// keepComment returns true if the raw comment is a conditional comment or
+// has template expressions that removing it would break.
+func keepComment(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("<!--[if")) ||
+		bytes.Contains(b, []byte("<![endif]")) ||
+		bytes.Contains(b, []byte("{{"))
+}
+
+// collapseSpace replaces runs of whitespace in b with a single space.
+func collapseSpace(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	space := false
+	for _, c := range b {
+		switch c {
+		case ' ', '\t', '\n', '\r', '\f':
+			if !space {
+				out = append(out, ' ')
+			}
+			space = true
+		default:
+			out = append(out, c)
+			space = false
+		}
+	}
+
+	return out
+}
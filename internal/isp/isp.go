@@ -0,0 +1,116 @@
+// Package isp maps e-mail addresses to the major mailbox providers (ISPs) by their
+// domains. It's used to serve the campaign view tracking pixel of each ISP's
+// subscribers on a tracking domain of its own (eg: t-gmail.example.com), so that
+// views record the ISP the message was received at.
+package isp
+
+import (
+	"sort"
+	"strings"
+)
+
+// Placeholder is replaced with the name of an ISP in tracking domain templates.
+const Placeholder = "{isp}"
+
+// domains maps the e-mail domains of the major ISPs to their names.
+var domains = map[string]string{
+	"gmail.com":      "gmail",
+	"googlemail.com": "gmail",
+
+	"yahoo.com":      "yahoo",
+	"yahoo.co.uk":    "yahoo",
+	"yahoo.co.in":    "yahoo",
+	"yahoo.co.jp":    "yahoo",
+	"yahoo.fr":       "yahoo",
+	"yahoo.de":       "yahoo",
+	"ymail.com":      "yahoo",
+	"rocketmail.com": "yahoo",
+
+	"outlook.com": "outlook",
+	"hotmail.com": "outlook",
+	"hotmail.fr":  "outlook",
+	"live.com":    "outlook",
+	"msn.com":     "outlook",
+
+	"aol.com": "aol",
+
+	"icloud.com": "icloud",
+	"me.com":     "icloud",
+	"mac.com":    "icloud",
+
+	"gmx.com": "gmx",
+	"gmx.de":  "gmx",
+	"gmx.net": "gmx",
+
+	"yandex.com": "yandex",
+	"yandex.ru":  "yandex",
+
+	"proton.me":      "proton",
+	"protonmail.com": "proton",
+}
+
+// FromEmail returns the name of the ISP of an e-mail address,
+// or an empty string if its domain isn't of a known ISP.
+func FromEmail(email string) string {
+	i := strings.LastIndexByte(email, '@')
+	if i < 0 {
+		return ""
+	}
+
+	return domains[strings.ToLower(email[i+1:])]
+}
+
+// IsKnown checks if the given name is of a known ISP.
+func IsKnown(name string) bool {
+	for _, n := range domains {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Domains returns the known e-mail domains and the names of their ISPs
+// as two slices of the same length, sorted by the domains.
+func Domains() ([]string, []string) {
+	doms := make([]string, 0, len(domains))
+	for d := range domains {
+		doms = append(doms, d)
+	}
+	sort.Strings(doms)
+
+	names := make([]string, len(doms))
+	for i, d := range doms {
+		names[i] = domains[d]
+	}
+
+	return doms, names
+}
+
+// Host returns the tracking host of an ISP from a tracking domain
+// template, eg: t-{isp}.example.com => t-gmail.example.com.
+func Host(tpl, name string) string {
+	return strings.Replace(tpl, Placeholder, name, 1)
+}
+
+// FromHost returns the name of the ISP in a host that matches a tracking domain
+// template, or an empty string if it doesn't match or the ISP isn't known.
+func FromHost(tpl, host string) string {
+	prefix, suffix, ok := strings.Cut(tpl, Placeholder)
+	if !ok {
+		return ""
+	}
+
+	host = strings.ToLower(host)
+	if len(host) <= len(prefix)+len(suffix) || !strings.HasPrefix(host, prefix) || !strings.HasSuffix(host, suffix) {
+		return ""
+	}
+
+	name := host[len(prefix) : len(host)-len(suffix)]
+	if !IsKnown(name) {
+		return ""
+	}
+
+	return name
+}
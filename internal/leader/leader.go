@@ -0,0 +1,211 @@
+// Package leader implements leader election between multiple instances of the
+// app connected to the same database using a Postgres session level advisory
+// lock. Only the instance holding the lock is the leader. As the lock is tied
+// to a database connection, Postgres releases it when the leader dies, and
+// another instance acquires it on its next attempt.
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultLockID is the advisory lock key used for the election.
+	DefaultLockID int64 = 0x6c697374
+
+	// appNamePrefix is prefixed to the instance ID in the application_name
+	// of the connection holding the lock, which identifies the leader.
+	appNamePrefix = "listmonk:"
+
+	queryTimeout = 5 * time.Second
+)
+
+// Opt represents leader election options.
+type Opt struct {
+	// ID uniquely identifies this instance.
+	ID string
+
+	// LockID is the advisory lock key.
+	LockID int64
+
+	// Interval at which followers attempt to acquire the lock
+	// and the leader checks its connection.
+	Interval time.Duration
+}
+
+// Leader elects and tracks the leader instance.
+type Leader struct {
+	opt Opt
+	db  *sql.DB
+	lo  *log.Logger
+
+	// conn is the dedicated connection holding the lock.
+	conn     *sql.Conn
+	isLeader atomic.Bool
+	mu       sync.Mutex
+}
+
+// New returns a new instance of Leader.
+func New(opt Opt, db *sql.DB, lo *log.Logger) *Leader {
+	if opt.LockID == 0 {
+		opt.LockID = DefaultLockID
+	}
+	if opt.Interval == 0 {
+		opt.Interval = 3 * time.Second
+	}
+
+	return &Leader{
+		opt: opt,
+		db:  db,
+		lo:  lo,
+	}
+}
+
+// Run is a blocking function that attempts to acquire the lock at every interval.
+// onElect is called when this instance becomes the leader. onLost is called
+// if the leader's connection (and thereby the lock) is lost, after which Run returns.
+func (l *Leader) Run(ctx context.Context, onElect, onLost func()) {
+	t := time.NewTicker(l.opt.Interval)
+	defer t.Stop()
+
+	for {
+		if l.IsLeader() {
+			if err := l.check(ctx); err != nil {
+				l.lo.Printf("lost leadership: %v", err)
+				l.Close()
+				onLost()
+				return
+			}
+		} else {
+			ok, err := l.acquire(ctx)
+			if err != nil {
+				l.lo.Printf("error acquiring leader lock: %v", err)
+			} else if ok {
+				l.lo.Printf("instance %s elected as the leader", l.opt.ID)
+				onElect()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			l.Close()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// ID returns the ID of this instance.
+func (l *Leader) ID() string {
+	return l.opt.ID
+}
+
+// IsLeader checks if this instance is the leader.
+func (l *Leader) IsLeader() bool {
+	return l.isLeader.Load()
+}
+
+// Current returns the ID of the current leader instance.
+// It's empty if there's no leader.
+func (l *Leader) Current(ctx context.Context) (string, error) {
+	if l.IsLeader() {
+		return l.opt.ID, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	// A bigint advisory lock key is split into classid (high 32 bits) and objid (low 32 bits).
+	var name string
+	err := l.db.QueryRowContext(ctx, `SELECT a.application_name FROM pg_locks l
+		JOIN pg_stat_activity a ON (a.pid = l.pid)
+		WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+		AND l.classid = $1::BIGINT::OID AND l.objid = $2::BIGINT::OID
+		AND l.database = (SELECT oid FROM pg_database WHERE datname = CURRENT_DATABASE())`,
+		uint32(l.opt.LockID>>32), uint32(l.opt.LockID)).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if len(name) > len(appNamePrefix) && name[:len(appNamePrefix)] == appNamePrefix {
+		return name[len(appNamePrefix):], nil
+	}
+	return name, nil
+}
+
+// Close releases the lock if it's held.
+func (l *Leader) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return
+	}
+
+	// Discard the underlying connection instead of returning it to the pool,
+	// which releases the lock.
+	_ = l.conn.Raw(func(any) error { return driver.ErrBadConn })
+	l.conn.Close()
+	l.conn = nil
+	l.isLeader.Store(false)
+}
+
+// acquire attempts to acquire the lock on a dedicated connection.
+func (l *Leader) acquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var ok bool
+	if err := conn.QueryRowContext(ctx, `SELECT PG_TRY_ADVISORY_LOCK($1)`, l.opt.LockID).Scan(&ok); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !ok {
+		conn.Close()
+		return false, nil
+	}
+
+	// Set the application_name on the connection so that
+	// the other instances can identify the leader.
+	if _, err := conn.ExecContext(ctx, `SELECT SET_CONFIG('application_name', $1, false)`, appNamePrefix+l.opt.ID); err != nil {
+		l.lo.Printf("error setting leader application_name: %v", err)
+	}
+
+	l.conn = conn
+	l.isLeader.Store(true)
+
+	return true, nil
+}
+
+// check checks that the connection holding the lock is alive.
+func (l *Leader) check(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return errors.New("no connection")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	_, err := l.conn.ExecContext(ctx, `SELECT 1`)
+	return err
+}
@@ -0,0 +1,69 @@
+// Package mailclient classifies the user agents of the requests for campaign
+// tracking pixels into the e-mail clients the messages were opened in. Webmail
+// providers that proxy images, such as Gmail and Yahoo Mail, are identified by
+// their proxies' user agents.
+package mailclient
+
+import "strings"
+
+const (
+	Gmail       = "Gmail"
+	AppleMail   = "Apple Mail"
+	Outlook     = "Outlook"
+	YahooMail   = "Yahoo Mail"
+	Thunderbird = "Thunderbird"
+	WebBrowser  = "Web browser"
+	Other       = "Other"
+	Unknown     = "Unknown"
+)
+
+// rule maps the user agents that contain any of the substrings (lowercase) to a client.
+type rule struct {
+	client string
+	match  []string
+}
+
+// rules are in the order of precedence as webmail proxies and desktop clients
+// often include the tokens of the browser engines they're based on.
+var rules = []rule{
+	{Gmail, []string{"googleimageproxy", "ggpht.com"}},
+	{YahooMail, []string{"yahoomailproxy"}},
+	{Outlook, []string{"outlook", "ms-office", "msoffice", "microsoft office"}},
+	{Thunderbird, []string{"thunderbird"}},
+}
+
+// Parse returns the e-mail client of a user agent.
+func Parse(ua string) string {
+	ua = strings.TrimSpace(ua)
+	if ua == "" {
+		return Unknown
+	}
+
+	// Apple Mail Privacy Protection fetches images with this bare user agent.
+	if ua == "Mozilla/5.0" {
+		return AppleMail
+	}
+
+	l := strings.ToLower(ua)
+	for _, r := range rules {
+		for _, m := range r.match {
+			if strings.Contains(l, m) {
+				return r.client
+			}
+		}
+	}
+
+	// Apple Mail on macOS and iOS use WebKit without identifying as Safari.
+	isBrowser := strings.Contains(l, "safari") || strings.Contains(l, "chrome") ||
+		strings.Contains(l, "firefox") || strings.Contains(l, "edg/")
+	if strings.Contains(l, "applewebkit") && !isBrowser &&
+		(strings.Contains(l, "macintosh") || strings.Contains(l, "iphone") || strings.Contains(l, "ipad")) {
+		return AppleMail
+	}
+
+	if isBrowser {
+		return WebBrowser
+	}
+
+	return Other
+}
@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"regexp"
+	"slices"
+
+	"github.com/knadh/listmonk/models"
+)
+
+var (
+	// References to subscriber attributes in templates, eg: {{ .Subscriber.Attribs.city }}
+	// and {{ index .Subscriber.Attribs "city" }}.
+	reAttribField = regexp.MustCompile(`\.Subscriber\.Attribs\.([A-Za-z0-9_]+)`)
+	reAttribIndex = regexp.MustCompile(`index\s+\$?\.Subscriber\.Attribs\s+"([^"]+)"`)
+)
+
+// TemplateAttribs returns the filter of subscriber attributes that are available
+// to message templates. It's refreshed from the settings every time a campaign starts.
+func (m *Manager) TemplateAttribs() models.AttribFilter {
+	m.attribsMut.RLock()
+	defer m.attribsMut.RUnlock()
+
+	return m.attribs
+}
+
+// loadTemplateAttribs fetches the latest attribute filter from the store so that
+// changes to it are applied to campaigns without a restart. On error, the last
+// known filter is retained.
+func (m *Manager) loadTemplateAttribs() models.AttribFilter {
+	f, err := m.store.GetTemplateAttribs()
+	if err != nil {
+		m.log.Printf("error fetching template attribute filter: %v", err)
+		return m.TemplateAttribs()
+	}
+
+	m.attribsMut.Lock()
+	m.attribs = f
+	m.attribsMut.Unlock()
+
+	return f
+}
+
+// ExcludedAttribs returns the subscriber attribute keys referenced in the given
+// template bodies that are excluded from templates by the attribute filter.
+func (m *Manager) ExcludedAttribs(bodies ...string) []string {
+	f := m.TemplateAttribs()
+
+	var out []string
+	for _, b := range bodies {
+		for _, re := range []*regexp.Regexp{reAttribField, reAttribIndex} {
+			for _, match := range re.FindAllStringSubmatch(b, -1) {
+				if k := match[1]; !f.Allows(k) && !slices.Contains(out, k) {
+					out = append(out, k)
+				}
+			}
+		}
+	}
+
+	return out
+}
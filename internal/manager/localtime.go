@@ -0,0 +1,137 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// maxWaveWait is the max duration for which a campaign's pipe waits for its next
+// timezone wave before it's re-evaluated, so that pauses and cancellations are
+// picked up promptly.
+const maxWaveWait = time.Second * 5
+
+// TimezoneFilter narrows down the subscribers of a campaign to the ones in a
+// timezone wave by the timezone in their `timezone` attribute. If Exclude is set,
+// the subscribers that are not in any of the timezones are picked instead.
+type TimezoneFilter struct {
+	Timezones []string
+	Exclude   bool
+}
+
+// localWave is a group of a campaign's subscribers by timezone whose messages
+// are due at the same time.
+type localWave struct {
+	due    time.Time
+	filter TimezoneFilter
+}
+
+// localWaves sends a campaign's messages to subscribers at a local time (send_at_local_time)
+// in the timezones in their `timezone` attribute. Every subscriber gets the message at the first
+// occurrence of the local time at or after the campaign's send_at. Subscribers are grouped into
+// waves by the time their messages are due and the campaign's lists are processed once for every
+// wave. The first wave, due at send_at, has all the subscribers that aren't in the other waves,
+// including the ones without a (valid) timezone.
+type localWaves struct {
+	waves []localWave
+	cur   int
+}
+
+// newLocalWaves returns the timezone waves of a campaign given the distinct timezones of
+// its subscribers, or nil if the campaign isn't sent at a local time. The current wave
+// is the one the campaign was last sent to, if it was started before.
+func newLocalWaves(c *models.Campaign, timezones []string) (*localWaves, error) {
+	if c.SendAtLocalTime == "" || !c.SendAt.Valid {
+		return nil, nil
+	}
+
+	hm, err := time.Parse("15:04", c.SendAtLocalTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local send time %s: %v", c.SendAtLocalTime, err)
+	}
+
+	// Group the timezones by the time their messages are due.
+	var (
+		sendAt = c.SendAt.Time
+		byDue  = make(map[time.Time][]string)
+		others []string
+	)
+	for _, tz := range timezones {
+		// Subscribers with invalid timezones are in the first wave.
+		loc, err := time.LoadLocation(tz)
+		if err != nil || tz == "" || tz == "Local" {
+			continue
+		}
+
+		due := localDue(sendAt, hm.Hour(), hm.Minute(), loc)
+		if due.Equal(sendAt) {
+			continue
+		}
+
+		due = due.UTC()
+		byDue[due] = append(byDue[due], tz)
+		others = append(others, tz)
+	}
+
+	out := &localWaves{
+		waves: []localWave{{due: sendAt, filter: TimezoneFilter{Timezones: others, Exclude: true}}},
+	}
+	for due, tzs := range byDue {
+		out.waves = append(out.waves, localWave{due: due, filter: TimezoneFilter{Timezones: tzs}})
+	}
+	sort.SliceStable(out.waves[1:], func(i, j int) bool {
+		return out.waves[i+1].due.Before(out.waves[j+1].due)
+	})
+
+	// Resume from the wave the campaign was last sent to.
+	if c.LocalWaveAt.Valid {
+		out.cur = len(out.waves) - 1
+		for i, w := range out.waves {
+			if !w.due.Before(c.LocalWaveAt.Time) {
+				out.cur = i
+				break
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// localDue returns the first occurrence of the local time hour:minute in the
+// given location at or after t.
+func localDue(t time.Time, hour, minute int, loc *time.Location) time.Time {
+	lt := t.In(loc)
+	due := time.Date(lt.Year(), lt.Month(), lt.Day(), hour, minute, 0, 0, loc)
+	if due.Before(t) {
+		due = time.Date(lt.Year(), lt.Month(), lt.Day()+1, hour, minute, 0, 0, loc)
+	}
+
+	return due
+}
+
+// filter returns the subscriber filter of the current wave.
+func (w *localWaves) filter() *TimezoneFilter {
+	return &w.waves[w.cur].filter
+}
+
+// due returns the time at which the messages of the current wave are due.
+func (w *localWaves) due() time.Time {
+	return w.waves[w.cur].due
+}
+
+// next moves on to the next wave. It returns false if there are no more waves.
+func (w *localWaves) next() bool {
+	if w.cur >= len(w.waves)-1 {
+		return false
+	}
+
+	w.cur++
+	return true
+}
+
+// wait returns the duration to wait before the messages of the current wave are due.
+func (w *localWaves) wait(now time.Time) time.Duration {
+	return min(max(w.due().Sub(now), 0), maxWaveWait)
+}
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,8 +19,11 @@ import (
 	"maps"
 
 	"github.com/Masterminds/sprig/v3"
+	"github.com/knadh/listmonk/internal/htmlmin"
 	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/isp"
 	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
@@ -32,6 +36,11 @@ const attribInlineEmbed = "data-embed"
 var (
 	reInlineImage = regexp.MustCompile(`(?is)<img\b[^>]*\b` + attribInlineEmbed + `\b[^>]*>`)
 	reImgSrc      = regexp.MustCompile(`(?is)(\s)src\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+	// tempFailureRetryDelays are the delays after which campaign messages that fail
+	// with temporary errors (eg: SMTP 4xx) are retried, one per attempt, before
+	// they're marked as failed.
+	tempFailureRetryDelays = []time.Duration{time.Second * 5, time.Second * 30, time.Second * 120}
 )
 
 const (
@@ -42,19 +51,41 @@ const (
 	ContentTpl = "content"
 
 	dummyUUID = "00000000-0000-0000-0000-000000000000"
+
+	// JournalModeBcc adds the journal address as an envelope recipient of every message.
+	JournalModeBcc = "bcc"
+
+	// JournalModeCopy sends a single untracked copy of a campaign to the journal address.
+	JournalModeCopy = "copy"
 )
 
 // Store represents a data backend, such as a database,
 // that provides subscriber and campaign records.
 type Store interface {
-	NextCampaigns(currentIDs []int64, sentCounts []int64) ([]*models.Campaign, error)
-	NextSubscribers(campID, limit int) ([]models.Subscriber, error)
+	NextCampaigns(currentIDs []int64, sentCounts []int64, limit int) ([]*models.Campaign, error)
+	QueueCampaigns(currentIDs []int64, retryAt time.Time) (int, error)
+	NextSubscribers(campID, limit int, tz *TimezoneFilter) ([]models.Subscriber, error)
 	GetCampaign(campID int) (*models.Campaign, error)
+	GetCampaignLists(campID int) ([]models.List, error)
 	GetAttachment(mediaID int) (models.Attachment, error)
 	GetInlineAttachmentByFilename(filename string) (models.Attachment, string, error)
 	UpdateCampaignStatus(campID int, status string) error
+	GetCampaignTimezones(campID int) ([]string, error)
+	UpdateCampaignWave(campID int, due time.Time) (bool, error)
+	UpdateCampaignRemoteBody(campID int, body string) error
 	UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID int) error
+	UpdateCampaignRenderStats(campID int, s models.RenderStats) error
+	GetCampaignRevision(campID int) (int, error)
+	GetTemplateAttribs() (models.AttribFilter, error)
+	ApplyCampaignRevision(campID int, revision int) error
 	CreateLink(url string) (string, error)
+	RecordSendFailure(campID, subID, retries int, sendErr string) error
+	RecordCampaignSends(sends []models.CampaignSend) error
+	GetMessengerDailyStats(messenger string) (models.MessengerDailyStats, error)
+	RecordMessengerSends(messenger string, n int) error
+	DeleteSendFailure(campID, subID int) error
+	RetrySendFailures(campID int) ([]models.Subscriber, error)
+	GetTestCohort(campID, listID, limit int) ([]models.Subscriber, error)
 	BlocklistSubscriber(id int64) error
 	DeleteSubscriber(id int64) error
 }
@@ -68,9 +99,20 @@ type Messenger interface {
 	Close() error
 }
 
+// SourceMessenger is an optional interface implemented by messengers that can
+// return the raw source of a message exactly as it's sent.
+type SourceMessenger interface {
+	PushWithSource(models.Message) ([]byte, error)
+}
+
 // CampStats contains campaign stats like per minute send rate.
 type CampStats struct {
-	SendRate int
+	SendRate    int
+	RenderStats models.RenderStats
+
+	// SpreadEnd is the end of the window over which the campaign's messages
+	// are spread out. It's zero if the campaign isn't spread out.
+	SpreadEnd time.Time
 }
 
 // Manager handles the scheduling, processing, and queuing of campaigns
@@ -90,6 +132,14 @@ type Manager struct {
 	tpls    map[int]*models.Template
 	tplsMut sync.RWMutex
 
+	// Bodies of running campaigns fetched from their body URLs.
+	remoteBodies    map[int]remoteBody
+	remoteBodiesMut sync.RWMutex
+
+	// Filter of subscriber attributes available to templates.
+	attribs    models.AttribFilter
+	attribsMut sync.RWMutex
+
 	// Links generated using Track() are cached here so as to not query
 	// the database for the link UUID for every message sent. This has to
 	// be locked as it may be used externally when previewing campaigns.
@@ -100,6 +150,14 @@ type Manager struct {
 	campMsgQ  chan CampaignMessage
 	msgQ      chan models.Message
 
+	// scanOnce ensures that the campaign scanner is started only once.
+	scanOnce sync.Once
+
+	// closed indicates that the queues have been closed. Pipes that are requeued
+	// asynchronously (eg: spread out campaigns) check it before queuing.
+	closed   bool
+	closeMut sync.RWMutex
+
 	// Sliding window keeps track of the total number of messages sent in a period
 	// and on reaching the specified limit, waits until the window is over before
 	// sending further messages.
@@ -107,6 +165,12 @@ type Manager struct {
 	slidingStart time.Time
 
 	tplFuncs template.FuncMap
+
+	// Buffered log of the campaign messages sent to subscribers.
+	sendLog sendLog
+
+	// Daily limits of messengers on warm-up schedules.
+	warmup warmup
 }
 
 // CampaignMessage represents an instance of campaign message to be pushed out,
@@ -115,6 +179,11 @@ type CampaignMessage struct {
 	Campaign   *models.Campaign
 	Subscriber models.Subscriber
 
+	// List is the campaign list through which the message is being sent
+	// to the subscriber. It is empty when the list is not known, for instance,
+	// in previews.
+	List MessageList
+
 	from     string
 	to       string
 	subject  string
@@ -123,9 +192,31 @@ type CampaignMessage struct {
 	unsubURL string
 	headers  models.Headers
 
+	// untracked disables view and click tracking in the message, eg: in journal copies.
+	untracked bool
+
+	// retry indicates that the message is a retry of a failed message.
+	retry bool
+
+	// test indicates that the message is a test of the campaign
+	// to a cohort of subscribers before it's sent in full.
+	test bool
+
+	// attempts is the number of times the message has been retried
+	// after temporary failures in the send session.
+	attempts int
+
 	pipe *pipe
 }
 
+// MessageList represents the campaign list that a CampaignMessage
+// is being sent through.
+type MessageList struct {
+	models.List
+
+	unsubURL string
+}
+
 // Config has parameters for configuring the manager.
 type Config struct {
 	// Number of subscribers to pull from the DB in a single iteration.
@@ -145,10 +236,48 @@ type Config struct {
 	OptinURL              string
 	MessageURL            string
 	ViewTrackURL          string
+	SurveyURL             string
 	ArchiveURL            string
 	RootURL               string
 	UnsubHeader           bool
 
+	// Template (eg: t-{isp}.example.com) of the tracking domains on which the view
+	// tracking pixels of the subscribers of major ISPs are served. Empty disables it.
+	ISPTrackingDomain string
+
+	// MinifyHTML minifies the HTML of campaigns and their templates before they're rendered.
+	MinifyHTML bool
+
+	// Filter of subscriber attributes available to templates on startup.
+	// It's refreshed from the store when campaigns start.
+	TemplateAttribs models.AttribFilter
+
+	// Address (eg: unsub@site.com) on the bounce mailbox that's plus-addressed with
+	// signed campaign and subscriber UUIDs in the List-Unsubscribe mailto: header.
+	// Empty disables the mailto: target.
+	UnsubMailto    string
+	UnsubMailtoKey []byte
+
+	// Address to which copies of campaign messages are journaled (archived) and
+	// how (JournalModeBcc or JournalModeCopy). A campaign's own journal address
+	// overrides it. Empty disables journaling for campaigns that don't have one.
+	JournalAddress string
+	JournalMode    string
+
+	// Number of messenger errors on a campaign after which the admin is notified.
+	// 0 disables the notification.
+	AlertErrorThreshold int
+
+	// Maximum number of campaigns that are processed concurrently. Campaigns that are
+	// due beyond it are queued as scheduled and retried after CampaignQueueDelay.
+	// 0 disables the limit.
+	MaxConcurrentCampaigns int
+	CampaignQueueDelay     time.Duration
+
+	// Warm-up schedules (sorted by day) of messengers, keyed by the messenger name.
+	// Campaign messages to a messenger on a schedule are limited to its daily limit.
+	WarmupSchedules map[string][]models.WarmupStep
+
 	// Interval to scan the DB for active campaign checkpoints.
 	ScanInterval time.Duration
 
@@ -173,11 +302,15 @@ func New(cfg Config, store Store, i *i18n.I18n, l *log.Logger) *Manager {
 	if cfg.MessageRate < 1 {
 		cfg.MessageRate = 1
 	}
+	if cfg.CampaignQueueDelay <= 0 {
+		cfg.CampaignQueueDelay = time.Minute
+	}
 
 	m := &Manager{
-		cfg:   cfg,
-		store: store,
-		i18n:  i,
+		cfg:     cfg,
+		store:   store,
+		i18n:    i,
+		attribs: cfg.TemplateAttribs,
 		fnNotify: func(subject string, data any) error {
 			return notifs.NotifySystem(subject, notifs.TplCampaignStatus, data, nil)
 		},
@@ -185,11 +318,17 @@ func New(cfg Config, store Store, i *i18n.I18n, l *log.Logger) *Manager {
 		messengers:   make(map[string]Messenger),
 		pipes:        make(map[int]*pipe),
 		tpls:         make(map[int]*models.Template),
+		remoteBodies: make(map[int]remoteBody),
 		links:        make(map[string]string),
 		nextPipes:    make(chan *pipe, 1000),
 		campMsgQ:     make(chan CampaignMessage, cfg.Concurrency*cfg.MessageRate*2),
 		msgQ:         make(chan models.Message, cfg.Concurrency*cfg.MessageRate*2),
 		slidingStart: time.Now(),
+		warmup: warmup{
+			schedules: cfg.WarmupSchedules,
+			pending:   make(map[string]int),
+			alerted:   make(map[string]int),
+		},
 	}
 	m.tplFuncs = m.makeGnericFuncMap()
 
@@ -244,6 +383,162 @@ func (m *Manager) PushCampaignMessage(msg CampaignMessage) error {
 	return nil
 }
 
+// RetrySendFailures queues a campaign's messages to the subscribers to whom they failed
+// to be sent earlier, eg: on temporary SMTP errors. The messages are sent by the workers
+// like the messages of a running campaign and the failures are cleared as they're sent.
+// It returns the number of messages queued.
+func (m *Manager) RetrySendFailures(campID int) (int, error) {
+	c, lists, err := m.prepareCampaign(campID)
+	if err != nil {
+		return 0, err
+	}
+
+	subs, err := m.store.RetrySendFailures(c.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	m.queueMessages(c, lists, subs, func(msg *CampaignMessage) {
+		msg.retry = true
+	})
+
+	return len(subs), nil
+}
+
+// SendTestCohort queues a campaign's messages to the first limit subscribers (by creation)
+// of one of its lists who haven't been sent a test of the campaign yet. The messages are
+// sent by the workers with the campaign's messenger and tracking like the messages of a
+// running campaign, and are marked as tests in the send log so that the subscribers are
+// skipped when the campaign is sent in full. It returns the number of messages queued.
+func (m *Manager) SendTestCohort(campID, listID, limit int) (int, error) {
+	c, lists, err := m.prepareCampaign(campID)
+	if err != nil {
+		return 0, err
+	}
+
+	subs, err := m.store.GetTestCohort(c.ID, listID, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	m.queueMessages(c, lists, subs, func(msg *CampaignMessage) {
+		msg.test = true
+	})
+
+	return len(subs), nil
+}
+
+// prepareCampaign fetches a campaign and prepares it for sending like a pipe does,
+// and returns it with its lists.
+func (m *Manager) prepareCampaign(campID int) (*models.Campaign, map[int]models.List, error) {
+	c, err := m.store.GetCampaign(campID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := m.messengers[c.Messenger]; !ok {
+		return nil, nil, fmt.Errorf("unknown messenger %s on campaign %s", c.Messenger, c.Name)
+	}
+
+	if err := m.LoadRemoteBody(c); err != nil {
+		return nil, nil, err
+	}
+	if err := m.LoadInlineImages(c); err != nil {
+		return nil, nil, err
+	}
+	if err := c.CompileTemplate(m.TemplateFuncs(c)); err != nil {
+		return nil, nil, err
+	}
+	if err := m.attachMedia(c); err != nil {
+		return nil, nil, err
+	}
+
+	lists, err := m.store.GetCampaignLists(c.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	listMap := make(map[int]models.List, len(lists))
+	for _, l := range lists {
+		listMap[l.ID] = l
+	}
+
+	return c, listMap, nil
+}
+
+// queueMessages renders and queues a prepared campaign's messages to the given subscribers
+// outside of a pipe, in the background as there may be many. set marks each message.
+func (m *Manager) queueMessages(c *models.Campaign, lists map[int]models.List, subs []models.Subscriber, set func(*CampaignMessage)) {
+	go func() {
+		attribs := m.loadTemplateAttribs()
+
+		for _, s := range subs {
+			s.Attribs = attribs.Apply(s.Attribs)
+			msg, err := m.newCampaignMessage(c, s, lists[s.CampaignListID])
+			if err != nil {
+				m.log.Printf("error rendering message (%s) (%s): %v", c.Name, s.Email, err)
+				continue
+			}
+			set(&msg)
+
+			if !m.queueRetry(msg) {
+				return
+			}
+		}
+	}()
+}
+
+// queueRetry queues a campaign message outside of a pipe, eg: a retried one,
+// unless the queues have been closed.
+func (m *Manager) queueRetry(msg CampaignMessage) bool {
+	m.closeMut.RLock()
+	defer m.closeMut.RUnlock()
+
+	if m.closed {
+		return false
+	}
+
+	m.campMsgQ <- msg
+	return true
+}
+
+// retryLater queues a campaign message that failed with a temporary error again
+// after the delay of its next attempt.
+func (m *Manager) retryLater(msg CampaignMessage) {
+	delay := tempFailureRetryDelays[msg.attempts]
+	msg.attempts++
+
+	time.AfterFunc(delay, func() {
+		if !m.queueRetry(msg) && msg.pipe != nil {
+			msg.pipe.wg.Done()
+		}
+	})
+}
+
+// SendCampaignMessage synchronously sends a single campaign message (eg: a test message)
+// constructed exactly like the messages of a running campaign, bypassing the queue.
+// If withSource is true and the messenger supports it, the raw source of the sent
+// message is returned.
+func (m *Manager) SendCampaignMessage(msg CampaignMessage, withSource bool) (models.Message, []byte, error) {
+	// Load any media/attachments.
+	if err := m.attachMedia(msg.Campaign); err != nil {
+		return models.Message{}, nil, err
+	}
+
+	msgr, ok := m.messengers[msg.Campaign.Messenger]
+	if !ok {
+		return models.Message{}, nil, fmt.Errorf("unknown messenger %s", msg.Campaign.Messenger)
+	}
+
+	out := m.makeMessage(msg)
+	if withSource {
+		if sm, ok := msgr.(SourceMessenger); ok {
+			src, err := sm.PushWithSource(out)
+			return out, src, err
+		}
+	}
+
+	return out, nil, msgr.Push(out)
+}
+
 // HasMessenger checks if a given messenger is registered.
 func (m *Manager) HasMessenger(id string) bool {
 	_, ok := m.messengers[id]
@@ -261,38 +556,61 @@ func (m *Manager) HasRunningCampaigns() bool {
 
 // GetCampaignStats returns campaign statistics.
 func (m *Manager) GetCampaignStats(id int) CampStats {
-	n := 0
+	var out CampStats
 
 	m.pipesMut.Lock()
 	if c, ok := m.pipes[id]; ok {
-		n = int(c.rate.Rate())
+		out.SendRate = int(c.rate.Rate())
+		out.RenderStats = c.render.stats()
+		if c.spread != nil {
+			out.SpreadEnd = c.spread.end()
+		}
 	}
 	m.pipesMut.Unlock()
 
-	return CampStats{SendRate: n}
+	return out
+}
+
+// StartCampaignScanner starts scanning the data source at regular intervals
+// for pending campaigns and queues them for processing in Run(). It's a no-op
+// if campaign scanning is disabled or if the scanner is already running.
+func (m *Manager) StartCampaignScanner() {
+	if !m.cfg.ScanCampaigns {
+		return
+	}
+
+	m.scanOnce.Do(func() {
+		// Periodically scan campaigns and push running campaigns to nextPipes
+		// to fetch subscribers from the campaign.
+		go m.scanCampaigns(m.cfg.ScanInterval)
+	})
 }
 
 // Run is a blocking function (that should be invoked as a goroutine)
-// that scans the data source at regular intervals for pending campaigns,
-// and queues them for processing. The process queue fetches batches of
+// that processes campaigns queued by the campaign scanner (StartCampaignScanner)
+// and arbitrary messages. The process queue fetches batches of
 // subscribers and pushes messages to them for each queued campaign
 // until all subscribers are exhausted, at which point, a campaign is marked
 // as "finished".
 func (m *Manager) Run() {
-	if m.cfg.ScanCampaigns {
-		// Periodically scan campaigns and push running campaigns to nextPipes
-		// to fetch subscribers from the campaign.
-		go m.scanCampaigns(m.cfg.ScanInterval)
-	}
-
 	// Spawn N message workers.
 	for i := 0; i < m.cfg.Concurrency; i++ {
 		go m.worker()
 	}
+	go m.runSendLog()
 
 	// Indefinitely wait on the pipe queue to fetch the next set of subscribers
 	// for any active campaigns.
 	for p := range m.nextPipes {
+		// The messages of a spread out campaign or its next timezone wave aren't due
+		// yet. Requeue it after a while instead of blocking the other campaigns.
+		if wait := p.wait(); wait > 0 {
+			time.AfterFunc(wait, func() {
+				m.requeuePipe(p)
+			})
+			continue
+		}
+
 		has, err := p.NextSubscribers()
 		if err != nil {
 			m.log.Printf("error processing campaign batch (%s): %v", p.camp.Name, err)
@@ -306,15 +624,7 @@ func (m *Manager) Run() {
 
 		if has {
 			// There are more subscribers to fetch. Queue again.
-			select {
-			case m.nextPipes <- p:
-			default:
-				// If the queue is full for any reason, stop the pipe and release it.
-				// The cleanup() records the state in DB and scanCampaigns() picks it up
-				// at a later point.
-				p.Stop(false)
-				p.wg.Done()
-			}
+			m.requeuePipe(p)
 		} else {
 			// The pipe is created with a +1 on the waitgroup pseudo counter
 			// so that it immediately waits. Subsequently, every message created
@@ -330,6 +640,26 @@ func (m *Manager) Run() {
 	}
 }
 
+// requeuePipe queues a pipe again to fetch its next set of subscribers.
+func (m *Manager) requeuePipe(p *pipe) {
+	m.closeMut.RLock()
+	defer m.closeMut.RUnlock()
+
+	if m.closed {
+		return
+	}
+
+	select {
+	case m.nextPipes <- p:
+	default:
+		// If the queue is full for any reason, stop the pipe and release it.
+		// The cleanup() records the state in DB and scanCampaigns() picks it up
+		// at a later point.
+		p.Stop(false)
+		p.wg.Done()
+	}
+}
+
 // CacheTpl caches a template for ad-hoc use. This is currently only used by tx templates.
 func (m *Manager) CacheTpl(id int, tpl *models.Template) {
 	if body, atts := m.ApplyInlineImages(tpl.Body); len(atts) > 0 {
@@ -371,43 +701,54 @@ func (m *Manager) GetTpl(id int) (*models.Template, error) {
 func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 	f := template.FuncMap{
 		"TrackLink": func(url string, msg *CampaignMessage) string {
-			if m.cfg.DisableTracking {
+			if m.cfg.DisableTracking || msg.untracked {
 				return url
 			}
 
-			subUUID := msg.Subscriber.UUID
+			subToken := msg.Subscriber.UnsubscribeToken
 			if !m.cfg.IndividualTracking {
-				subUUID = dummyUUID
+				subToken = dummyUUID
 			}
 
-			return m.trackLink(url, msg.Campaign.UUID, subUUID)
+			return m.trackLink(url, msg.Campaign, subToken)
 		},
 		"TrackView": func(msg *CampaignMessage) template.HTML {
-			if m.cfg.DisableTracking {
+			if m.cfg.DisableTracking || msg.untracked {
 				return template.HTML("")
 			}
 
-			subUUID := msg.Subscriber.UUID
+			subToken := msg.Subscriber.UnsubscribeToken
+			if !m.cfg.IndividualTracking {
+				subToken = dummyUUID
+			}
+
+			u := fmt.Sprintf(m.cfg.ViewTrackURL, msg.Campaign.UUID, subToken) + revisionQuery(msg.Campaign.ContentRevision)
+			return template.HTML(fmt.Sprintf(`<img src="%s" alt="" />`, m.ispTrackingURL(m.trackingURL(u, msg.Campaign), msg.Subscriber.Email)))
+		},
+		"surveyURL": func(camp *models.Campaign, sub models.Subscriber, questionID, answer string) string {
+			subToken := sub.UnsubscribeToken
 			if !m.cfg.IndividualTracking {
-				subUUID = dummyUUID
+				subToken = dummyUUID
 			}
 
-			return template.HTML(fmt.Sprintf(`<img src="%s" alt="" />`,
-				fmt.Sprintf(m.cfg.ViewTrackURL, msg.Campaign.UUID, subUUID)))
+			return m.surveyURL(camp, subToken, questionID, answer)
 		},
 		"UnsubscribeURL": func(msg *CampaignMessage) string {
 			return msg.unsubURL
 		},
+		"unsubscribeURL": func(l MessageList) string {
+			return l.unsubURL
+		},
 		"ManageURL": func(msg *CampaignMessage) string {
 			return msg.unsubURL + "?manage=true"
 		},
 		"OptinURL": func(msg *CampaignMessage) string {
 			// Add list IDs.
 			// TODO: Show private lists list on optin e-mail
-			return fmt.Sprintf(m.cfg.OptinURL, msg.Subscriber.UUID, "")
+			return fmt.Sprintf(m.cfg.OptinURL, msg.Subscriber.UnsubscribeToken, "")
 		},
 		"MessageURL": func(msg *CampaignMessage) string {
-			return fmt.Sprintf(m.cfg.MessageURL, c.UUID, msg.Subscriber.UUID)
+			return fmt.Sprintf(m.cfg.MessageURL, c.UUID, msg.Subscriber.UnsubscribeToken)
 		},
 		"ArchiveURL": func() string {
 			return m.cfg.ArchiveURL
@@ -437,8 +778,14 @@ func (m *Manager) StopCampaign(id int) {
 
 // Close closes and exits the campaign manager.
 func (m *Manager) Close() {
+	m.closeMut.Lock()
+	defer m.closeMut.Unlock()
+
+	m.closed = true
 	close(m.nextPipes)
 	close(m.msgQ)
+
+	m.flushSendLog()
 }
 
 // scanCampaigns is a blocking function that periodically scans the data source
@@ -451,13 +798,23 @@ func (m *Manager) scanCampaigns(tick time.Duration) {
 	// Periodically scan the data source for campaigns to process.
 	for range t.C {
 		ids, counts := m.getCurrentCampaigns()
-		campaigns, err := m.store.NextCampaigns(ids, counts)
+
+		// If the number of concurrent campaigns is limited, only pick up as many
+		// campaigns as there are free slots.
+		limit := -1
+		if m.cfg.MaxConcurrentCampaigns > 0 {
+			limit = max(m.cfg.MaxConcurrentCampaigns-len(ids), 0)
+		}
+
+		campaigns, err := m.store.NextCampaigns(ids, counts, limit)
 		if err != nil {
 			m.log.Printf("error fetching campaigns: %v", err)
 			continue
 		}
 
 		for _, c := range campaigns {
+			ids = append(ids, int64(c.ID))
+
 			// Create a new pipe that'll handle this campaign's states.
 			p, err := m.newPipe(c)
 			if err != nil {
@@ -479,6 +836,16 @@ func (m *Manager) scanCampaigns(tick time.Duration) {
 				p.wg.Done()
 			}
 		}
+
+		// At the limit, queue the rest of the campaigns that are due to be retried later.
+		if limit >= 0 && len(ids) >= m.cfg.MaxConcurrentCampaigns {
+			n, err := m.store.QueueCampaigns(ids, time.Now().Add(m.cfg.CampaignQueueDelay))
+			if err != nil {
+				m.log.Printf("error queuing campaigns: %v", err)
+			} else if n > 0 {
+				m.log.Printf("queued %d campaign(s) as %d campaigns are running", n, len(ids))
+			}
+		}
 	}
 }
 
@@ -509,43 +876,42 @@ func (m *Manager) worker() {
 			}
 			numMsg++
 
-			// Outgoing message.
-			out := models.Message{
-				From:        msg.from,
-				To:          []string{msg.to},
-				Subject:     msg.subject,
-				ContentType: msg.Campaign.ContentType,
-				Body:        msg.body,
-				AltBody:     msg.altBody,
-				Subscriber:  msg.Subscriber,
-				Campaign:    msg.Campaign,
-				Attachments: msg.Campaign.Attachments,
+			// Push the message to the messenger. In the bcc journal mode, the journal
+			// address is an envelope recipient of the same message, so it doesn't
+			// count towards the sent count.
+			out := m.makeMessage(msg)
+			if addr := m.journalAddress(msg.Campaign); addr != "" && m.cfg.JournalMode == JournalModeBcc {
+				out.Bcc = []string{addr}
 			}
-
-			h := textproto.MIMEHeader{}
-			h.Set(models.EmailHeaderCampaignUUID, msg.Campaign.UUID)
-			h.Set(models.EmailHeaderSubscriberUUID, msg.Subscriber.UUID)
-
-			// Attach List-Unsubscribe headers?
-			if m.cfg.UnsubHeader {
-				h.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
-				h.Set("List-Unsubscribe", `<`+msg.unsubURL+`>`)
+			err := m.messengers[msg.Campaign.Messenger].Push(out)
+			if err != nil {
+				m.log.Printf("error sending message in campaign %s: subscriber %d: %v", msg.Campaign.Name, msg.Subscriber.ID, err)
 			}
 
-			// Attach any custom headers.
-			for _, set := range msg.headers {
-				for hdr, val := range set {
-					h.Add(hdr, val)
-				}
+			// Retry temporary failures after a delay. The message stays pending
+			// on its pipe until it's sent or the retries are exhausted.
+			if err != nil && errors.Is(err, models.ErrTemporaryFailure) && msg.attempts < len(tempFailureRetryDelays) {
+				m.retryLater(msg)
+				continue
 			}
 
-			// Set the headers.
-			out.Headers = h
+			// Record the failures so that they can be retried later. Addresses that the
+			// messenger can't deliver to at all aren't worth retrying. Failed test messages
+			// aren't recorded as the subscribers get the campaign when it's sent in full.
+			if msg.Subscriber.ID > 0 {
+				if err != nil && !errors.Is(err, models.ErrUnsupportedRecipient) && !msg.test {
+					if err := m.store.RecordSendFailure(msg.Campaign.ID, msg.Subscriber.ID, msg.attempts, err.Error()); err != nil {
+						m.log.Printf("error recording send failure in campaign %s: %v", msg.Campaign.Name, err)
+					}
+				} else if err == nil && msg.retry {
+					if err := m.store.DeleteSendFailure(msg.Campaign.ID, msg.Subscriber.ID); err != nil {
+						m.log.Printf("error deleting send failure in campaign %s: %v", msg.Campaign.Name, err)
+					}
+				}
 
-			// Push the message to the messenger.
-			err := m.messengers[msg.Campaign.Messenger].Push(out)
-			if err != nil {
-				m.log.Printf("error sending message in campaign %s: subscriber %d: %v", msg.Campaign.Name, msg.Subscriber.ID, err)
+				if err == nil {
+					m.logSend(msg)
+				}
 			}
 
 			// Increment the send rate or the error counter if there was an error.
@@ -556,7 +922,12 @@ func (m *Manager) worker() {
 				if err != nil {
 					// Call the error callback, which keeps track of the error count
 					// and stops the campaign if the error count exceeds the threshold.
-					msg.pipe.OnError()
+					// Addresses that the messenger can't deliver to at all (eg:
+					// internationalized addresses through a server without SMTPUTF8)
+					// are skipped and don't count towards the threshold.
+					if !errors.Is(err, models.ErrUnsupportedRecipient) {
+						msg.pipe.OnError()
+					}
 				} else {
 					id := uint64(msg.Subscriber.ID)
 					if id > msg.pipe.lastID.Load() {
@@ -581,6 +952,78 @@ func (m *Manager) worker() {
 	}
 }
 
+// makeMessage creates the outgoing message with all its headers for a campaign message.
+// Both campaign and test sends use it so that test messages are identical to the real ones.
+func (m *Manager) makeMessage(msg CampaignMessage) models.Message {
+	out := models.Message{
+		From:        msg.from,
+		To:          []string{msg.to},
+		Subject:     msg.subject,
+		ContentType: msg.Campaign.ContentType,
+		Body:        msg.body,
+		AltBody:     msg.altBody,
+		Subscriber:  msg.Subscriber,
+		Campaign:    msg.Campaign,
+		Attachments: msg.Campaign.Attachments,
+	}
+
+	h := textproto.MIMEHeader{}
+	h.Set(models.EmailHeaderCampaignUUID, msg.Campaign.UUID)
+	h.Set(models.EmailHeaderSubscriberUUID, msg.Subscriber.UnsubscribeToken)
+
+	// Attach List-Unsubscribe headers?
+	if m.cfg.UnsubHeader {
+		h.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+		if m.cfg.UnsubMailto != "" {
+			to := utils.MakeUnsubMailto(m.cfg.UnsubMailto, msg.Campaign.UUID, msg.Subscriber.UnsubscribeToken, m.cfg.UnsubMailtoKey)
+			h.Set("List-Unsubscribe", `<`+msg.unsubURL+`>, <mailto:`+to+`?subject=unsubscribe>`)
+		} else {
+			h.Set("List-Unsubscribe", `<`+msg.unsubURL+`>`)
+		}
+	}
+
+	// Attach any custom headers.
+	for _, set := range msg.headers {
+		for hdr, val := range set {
+			h.Add(hdr, val)
+		}
+	}
+
+	// Set the headers.
+	out.Headers = h
+
+	return out
+}
+
+// journalAddress returns the address to which the messages of a campaign are journaled.
+func (m *Manager) journalAddress(c *models.Campaign) string {
+	if c.JournalAddress != "" {
+		return c.JournalAddress
+	}
+
+	return m.cfg.JournalAddress
+}
+
+// sendJournalCopy queues a single untracked copy of a campaign to the journal address.
+// It's pushed as an arbitrary message, so it doesn't count towards the campaign's stats.
+func (m *Manager) sendJournalCopy(c *models.Campaign, addr string) error {
+	msg, err := m.newJournalMessage(c, addr)
+	if err != nil {
+		return err
+	}
+
+	out := m.makeMessage(msg)
+	out.Messenger = c.Messenger
+	if !strings.HasPrefix(out.Messenger, "email") {
+		out.Messenger = "email"
+	}
+	if _, ok := m.messengers[out.Messenger]; !ok {
+		return fmt.Errorf("unknown messenger %s", out.Messenger)
+	}
+
+	return m.PushMessage(out)
+}
+
 // getCurrentCampaigns returns the IDs of campaigns currently being processed
 // and their sent counts.
 func (m *Manager) getCurrentCampaigns() ([]int64, []int64) {
@@ -605,8 +1048,8 @@ func (m *Manager) getCurrentCampaigns() ([]int64, []int64) {
 }
 
 // trackLink register a URL and return its UUID to be used in message templates
-// for tracking links.
-func (m *Manager) trackLink(url, campUUID, subUUID string) string {
+// for tracking links. The content revision of the message is carried in the URL.
+func (m *Manager) trackLink(url string, c *models.Campaign, subToken string) string {
 	if m.cfg.DisableTracking {
 		return url
 	}
@@ -616,7 +1059,7 @@ func (m *Manager) trackLink(url, campUUID, subUUID string) string {
 	m.linksMut.RLock()
 	if uu, ok := m.links[url]; ok {
 		m.linksMut.RUnlock()
-		return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+		return m.trackingURL(fmt.Sprintf(m.cfg.LinkTrackURL, uu, c.UUID, subToken)+revisionQuery(c.ContentRevision), c)
 	}
 	m.linksMut.RUnlock()
 
@@ -633,7 +1076,66 @@ func (m *Manager) trackLink(url, campUUID, subUUID string) string {
 	m.links[url] = uu
 	m.linksMut.Unlock()
 
-	return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+	return m.trackingURL(fmt.Sprintf(m.cfg.LinkTrackURL, uu, c.UUID, subToken)+revisionQuery(c.ContentRevision), c)
+}
+
+// surveyURL returns the click tracked URL that records an answer to a question in
+// the campaign's survey. The tracked link is shared by all subscribers and the
+// subscriber's token is carried over to the survey URL when it's clicked.
+func (m *Manager) surveyURL(c *models.Campaign, subToken, questionID, answer string) string {
+	u := fmt.Sprintf(m.cfg.SurveyURL, c.UUID) + "?" + url.Values{"q": {questionID}, "a": {answer}}.Encode()
+	if m.cfg.DisableTracking {
+		return u + "&s=" + subToken
+	}
+
+	return m.trackLink(u, c, subToken)
+}
+
+// trackingURL returns the tracking URL u, that's on the root URL, on the
+// campaign's tracking domain if it has one.
+func (m *Manager) trackingURL(u string, c *models.Campaign) string {
+	if !c.TrackingDomain.Valid || c.TrackingDomain.String == "" {
+		return u
+	}
+
+	root, err := url.Parse(m.cfg.RootURL)
+	if err != nil {
+		return u
+	}
+	root.Host = c.TrackingDomain.String
+
+	return root.String() + strings.TrimPrefix(u, m.cfg.RootURL)
+}
+
+// ispTrackingURL replaces the host of a tracking URL with the tracking domain of
+// the ISP of the given e-mail address, if the ISP tracking domains are enabled.
+func (m *Manager) ispTrackingURL(u, email string) string {
+	if m.cfg.ISPTrackingDomain == "" {
+		return u
+	}
+
+	name := isp.FromEmail(email)
+	if name == "" {
+		return u
+	}
+
+	p, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	p.Host = isp.Host(m.cfg.ISPTrackingDomain, name)
+
+	return p.String()
+}
+
+// revisionQuery returns the query string that carries a campaign's content revision in
+// its tracking URLs. It's empty for the original content so that the URLs are unchanged.
+func revisionQuery(revision int) string {
+	if revision == 0 {
+		return ""
+	}
+
+	return "?r=" + strconv.Itoa(revision)
 }
 
 // sendNotif sends a notification to registered admin e-mails.
@@ -669,6 +1171,9 @@ func (m *Manager) makeGnericFuncMap() template.FuncMap {
 		"Safe": func(safeHTML string) template.HTML {
 			return template.HTML(safeHTML)
 		},
+		"darkMode": func(sub models.Subscriber) bool {
+			return sub.Attribs[models.SubscriberAttribColorScheme] == models.ColorSchemeDark
+		},
 	}
 
 	// Copy spring functions.
@@ -704,6 +1209,20 @@ func (m *Manager) attachMedia(c *models.Campaign) error {
 	return nil
 }
 
+// minifyHTML minifies the HTML body of a campaign, its template, and the template's
+// language variants one time before CompileTemplate.
+func minifyHTML(c *models.Campaign) {
+	switch c.ContentType {
+	case models.CampaignContentTypeHTML, models.CampaignContentTypeRichtext, models.CampaignContentTypeVisual:
+		c.Body = htmlmin.Minify(c.Body)
+	}
+
+	c.TemplateBody = htmlmin.Minify(c.TemplateBody)
+	for lang, b := range c.TemplateVariants {
+		c.TemplateVariants[lang] = htmlmin.Minify(b)
+	}
+}
+
 // LoadInlineImages resolves any <img ... data-embed ...> tags in the campaign
 // body and template body one time before CompileTemplate.
 func (m *Manager) LoadInlineImages(c *models.Campaign) error {
@@ -719,6 +1238,12 @@ func (m *Manager) LoadInlineImages(c *models.Campaign) error {
 	c.TemplateBody = tplBody
 	atts = append(atts, tplAtts...)
 
+	for lang, b := range c.TemplateVariants {
+		b, a := m.applyInlineImages(b, cidCache)
+		c.TemplateVariants[lang] = b
+		atts = append(atts, a...)
+	}
+
 	c.Attachments = append(c.Attachments, atts...)
 	return nil
 }
@@ -3,6 +3,7 @@ package manager
 import (
 	"bytes"
 	"fmt"
+	"net/url"
 
 	"github.com/knadh/listmonk/models"
 )
@@ -11,6 +12,52 @@ import (
 // to message templates while they're compiled. It represents a message from
 // a campaign that's bound to a single Subscriber.
 func (m *Manager) NewCampaignMessage(c *models.Campaign, s models.Subscriber) (CampaignMessage, error) {
+	s.Attribs = m.TemplateAttribs().Apply(s.Attribs)
+	return m.newCampaignMessage(c, s, models.List{})
+}
+
+// NewTestCampaignMessage creates a CampaignMessage for a test send. Like in a campaign
+// send, the message is bound to a campaign list, the first of the campaign's lists that
+// the subscriber is subscribed to (or the campaign's first list), so that the list
+// specific values such as the list unsubscribe URL are identical to the real messages.
+func (m *Manager) NewTestCampaignMessage(c *models.Campaign, s models.Subscriber) (CampaignMessage, error) {
+	lists, err := m.store.GetCampaignLists(c.ID)
+	if err != nil {
+		return CampaignMessage{}, err
+	}
+
+	var subLists []struct {
+		ID     int    `json:"id"`
+		Status string `json:"subscription_status"`
+	}
+	if len(s.Lists) > 0 {
+		if err := s.Lists.Unmarshal(&subLists); err != nil {
+			return CampaignMessage{}, err
+		}
+	}
+
+	var list models.List
+	if len(lists) > 0 {
+		list = lists[0]
+	}
+loop:
+	for _, l := range lists {
+		for _, sl := range subLists {
+			if sl.ID == l.ID && sl.Status != models.SubscriptionStatusUnsubscribed {
+				list = l
+				break loop
+			}
+		}
+	}
+
+	s.Attribs = m.TemplateAttribs().Apply(s.Attribs)
+	return m.newCampaignMessage(c, s, list)
+}
+
+// newCampaignMessage creates a CampaignMessage that's sent to the subscriber via
+// the given campaign list. If the list is empty, the list-specific unsubscribe URL
+// falls back to the campaign's unsubscribe URL.
+func (m *Manager) newCampaignMessage(c *models.Campaign, s models.Subscriber, l models.List) (CampaignMessage, error) {
 	msg := CampaignMessage{
 		Campaign:   c,
 		Subscriber: s,
@@ -18,8 +65,35 @@ func (m *Manager) NewCampaignMessage(c *models.Campaign, s models.Subscriber) (C
 		subject:  c.Subject,
 		from:     c.FromEmail,
 		to:       s.Email,
-		unsubURL: fmt.Sprintf(m.cfg.UnsubURL, c.UUID, s.UUID),
+		unsubURL: fmt.Sprintf(m.cfg.UnsubURL, c.UUID, s.UnsubscribeToken),
+	}
+
+	msg.List = MessageList{List: l, unsubURL: msg.unsubURL}
+	if l.UUID != "" {
+		msg.List.unsubURL = msg.unsubURL + "?list_uuid=" + url.QueryEscape(l.UUID)
+	}
+
+	if err := msg.render(); err != nil {
+		return msg, err
+	}
+
+	return msg, nil
+}
+
+// newJournalMessage creates an untracked copy of a campaign's message addressed to the
+// journal address. It's bound to a dummy subscriber so that its unsubscribe links do nothing.
+func (m *Manager) newJournalMessage(c *models.Campaign, addr string) (CampaignMessage, error) {
+	msg := CampaignMessage{
+		Campaign:   c,
+		Subscriber: models.Subscriber{UUID: dummyUUID, UnsubscribeToken: dummyUUID, Email: addr},
+
+		subject:   c.Subject,
+		from:      c.FromEmail,
+		to:        addr,
+		unsubURL:  fmt.Sprintf(m.cfg.UnsubURL, c.UUID, dummyUUID),
+		untracked: true,
 	}
+	msg.List = MessageList{unsubURL: msg.unsubURL}
 
 	if err := msg.render(); err != nil {
 		return msg, err
@@ -28,7 +102,7 @@ func (m *Manager) NewCampaignMessage(c *models.Campaign, s models.Subscriber) (C
 	return msg, nil
 }
 
-// render takes a Message, executes its pre-compiled Campaign.Tpl
+// render takes a Message, executes its pre-compiled Campaign.Tpl (or its language variant)
 // and applies the resultant bytes to Message.body to be used in messages.
 func (m *CampaignMessage) render() error {
 	out := bytes.Buffer{}
@@ -42,14 +116,14 @@ func (m *CampaignMessage) render() error {
 		out.Reset()
 	}
 
-	// Compile the main template.
-	if err := m.Campaign.Tpl.ExecuteTemplate(&out, models.BaseTpl, m); err != nil {
+	// Compile the main template in the language variant for the subscriber, if any.
+	if err := m.Campaign.LangTpl(m.Subscriber.Lang()).ExecuteTemplate(&out, models.BaseTpl, m); err != nil {
 		return err
 	}
 	m.body = out.Bytes()
 
 	// Is there an alt body?
-	if m.Campaign.ContentType != models.CampaignContentTypePlain && m.Campaign.AltBody.Valid {
+	if alt, ok := m.Campaign.AltBodyText(); ok && m.Campaign.ContentType != models.CampaignContentTypePlain {
 		if m.Campaign.AltBodyTpl != nil {
 			b := bytes.Buffer{}
 			if err := m.Campaign.AltBodyTpl.ExecuteTemplate(&b, models.ContentTpl, m); err != nil {
@@ -57,7 +131,7 @@ func (m *CampaignMessage) render() error {
 			}
 			m.altBody = b.Bytes()
 		} else {
-			m.altBody = []byte(m.Campaign.AltBody.String)
+			m.altBody = []byte(alt)
 		}
 	}
 
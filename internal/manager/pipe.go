@@ -2,16 +2,19 @@ package manager
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/models"
 	"github.com/paulbellamy/ratecounter"
 )
 
 type pipe struct {
 	camp       *models.Campaign
+	lists      map[int]models.List
 	rate       *ratecounter.RateCounter
 	wg         *sync.WaitGroup
 	sent       atomic.Int64
@@ -20,6 +23,28 @@ type pipe struct {
 	stopped    atomic.Bool
 	withErrors atomic.Bool
 
+	// Sampled render timings and sizes of the campaign's messages.
+	render renderSampler
+
+	// Optional window over which the campaign's messages are spread out.
+	spread *sendSpread
+
+	// Optional timezone waves of a campaign that's sent at a local time.
+	waves *localWaves
+
+	// Time until which the campaign waits as its messenger has reached its
+	// daily warm-up limit. It's only accessed from Run().
+	warmupUntil time.Time
+
+	// Filter of subscriber attributes available to the campaign's templates,
+	// fixed for the run of the campaign.
+	attribs models.AttribFilter
+
+	// The campaign with the content revision that its messages are rendered with.
+	// It's replaced when the content of the running campaign changes, so that the
+	// messages already queued retain their revision. It's only accessed from Run().
+	content *models.Campaign
+
 	m *Manager
 }
 
@@ -31,11 +56,20 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 		return nil, fmt.Errorf("unknown messenger %s on campaign %s", c.Messenger, c.Name)
 	}
 
+	// Fetch the body of the campaign from its body URL for this run.
+	if err := m.cacheRemoteBody(c); err != nil {
+		return nil, err
+	}
+
 	// Resolve any inline images before compiling the template.
 	if err := m.LoadInlineImages(c); err != nil {
 		return nil, err
 	}
 
+	if m.cfg.MinifyHTML {
+		minifyHTML(c)
+	}
+
 	// Load the template.
 	if err := c.CompileTemplate(m.TemplateFuncs(c)); err != nil {
 		return nil, err
@@ -46,12 +80,54 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 		return nil, err
 	}
 
+	// Load the campaign's lists that are made available to messages.
+	lists, err := m.store.GetCampaignLists(c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Journal a single copy of the campaign when it first starts (and not on resumption).
+	if addr := m.journalAddress(c); addr != "" && m.cfg.JournalMode == JournalModeCopy && !c.StartedAt.Valid {
+		if err := m.sendJournalCopy(c, addr); err != nil {
+			m.log.Printf("error sending journal copy of campaign %s: %v", c.Name, err)
+		}
+	}
+
 	// Add the campaign to the active map.
 	p := &pipe{
-		camp: c,
-		rate: ratecounter.NewRateCounter(time.Minute),
-		wg:   &sync.WaitGroup{},
-		m:    m,
+		camp:    c,
+		content: c,
+		lists:   make(map[int]models.List, len(lists)),
+		rate:    ratecounter.NewRateCounter(time.Minute),
+		wg:      &sync.WaitGroup{},
+		attribs: m.loadTemplateAttribs(),
+		m:       m,
+	}
+
+	// If the campaign's messages are spread out, fetch its up-to-date counts and
+	// start time, which are updated in the DB as the campaign is picked up.
+	if c.SendSpread > 0 {
+		cur, err := m.store.GetCampaign(c.ID)
+		if err != nil {
+			return nil, err
+		}
+		p.spread = newSendSpread(cur)
+	}
+
+	// If the campaign is sent at the local time of subscribers, group them into
+	// waves by their timezones.
+	if c.SendAtLocalTime != "" {
+		tzs, err := m.store.GetCampaignTimezones(c.ID)
+		if err != nil {
+			return nil, err
+		}
+		if p.waves, err = newLocalWaves(c, tzs); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, l := range lists {
+		p.lists[l.ID] = l
 	}
 
 	// Increment the waitgroup so that Wait() blocks immediately. This is necessary
@@ -79,16 +155,66 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 // in the current batch or not. A false indicates that all subscribers
 // have been processed, or that a campaign has been paused or cancelled.
 func (p *pipe) NextSubscribers() (bool, error) {
+	// If the campaign is spread out, only fetch the subscribers whose messages are due.
+	limit := p.m.cfg.BatchSize
+	if p.spread != nil {
+		limit = max(p.spread.due(time.Now(), limit), 1)
+	}
+
+	// If the campaign's messenger is on a warm-up schedule, only fetch as many
+	// subscribers as the messenger can send to today. A stopped campaign skips
+	// this so that its pipe is released right away.
+	if !p.stopped.Load() {
+		n, err := p.m.warmupRemaining(p.camp.Messenger)
+		if err != nil {
+			return false, fmt.Errorf("error fetching messenger daily stats (%s): %v", p.camp.Name, err)
+		}
+		if n == 0 {
+			p.warmupUntil = time.Now().Add(maxWarmupWait)
+			return true, nil
+		}
+		if n > 0 {
+			limit = min(limit, n)
+		}
+	}
+
+	// If the content of the campaign was changed while it's running, render
+	// the rest of the messages with the new revision.
+	if err := p.loadRevision(); err != nil {
+		return false, fmt.Errorf("error loading campaign content revision (%s): %v", p.camp.Name, err)
+	}
+
+	// If the campaign is sent in timezone waves, only fetch the subscribers in the current wave.
+	var tz *TimezoneFilter
+	if p.waves != nil {
+		tz = p.waves.filter()
+	}
+
 	// Fetch the next batch of subscribers from a 'running' campaign.
-	subs, err := p.m.store.NextSubscribers(p.camp.ID, p.m.cfg.BatchSize)
+	subs, err := p.m.store.NextSubscribers(p.camp.ID, limit, tz)
 	if err != nil {
 		return false, fmt.Errorf("error fetching campaign subscribers (%s): %v", p.camp.Name, err)
 	}
+	if p.spread != nil {
+		p.spread.queued += len(subs)
+	}
 
 	// There are no subscribers from the query. Either all subscribers on the campaign
-	// have been processed, or the campaign has changed from 'running' to 'paused' or 'cancelled'.
+	// (or the current timezone wave) have been processed, or the campaign has changed
+	// from 'running' to 'paused' or 'cancelled'.
 	if len(subs) == 0 {
-		return false, nil
+		if p.waves == nil || p.stopped.Load() || !p.waves.next() {
+			return false, nil
+		}
+
+		// Move on to the next wave. If the campaign is no longer running, it's done.
+		ok, err := p.m.store.UpdateCampaignWave(p.camp.ID, p.waves.due())
+		if err != nil {
+			return false, fmt.Errorf("error updating campaign wave (%s): %v", p.camp.Name, err)
+		}
+		p.lastID.Store(0)
+
+		return ok, nil
 	}
 
 	// Is there a sliding window limit configured?
@@ -107,6 +233,7 @@ func (p *pipe) NextSubscribers() (bool, error) {
 		// Push the message to the queue while blocking and waiting until
 		// the queue is drained.
 		p.m.campMsgQ <- msg
+		p.m.addWarmupSends(p.camp.Messenger, 1)
 
 		// Check if the sliding window is active.
 		if hasSliding {
@@ -138,15 +265,51 @@ func (p *pipe) NextSubscribers() (bool, error) {
 	return true, nil
 }
 
-// OnError keeps track of the number of errors that occur while sending messages
-// and pauses the campaign if the error threshold is met.
+// wait returns the duration to wait before the next batch of a spread out
+// campaign, or of the current timezone wave of a campaign, is due. It's 0 if
+// the campaign has been stopped, in which case the pipe should be processed
+// right away.
+func (p *pipe) wait() time.Duration {
+	if p.stopped.Load() {
+		return 0
+	}
+
+	var wait time.Duration
+	if p.spread != nil {
+		wait = p.spread.wait(time.Now())
+	}
+	if p.waves != nil {
+		wait = max(wait, p.waves.wait(time.Now()))
+	}
+	if !p.warmupUntil.IsZero() {
+		wait = max(wait, time.Until(p.warmupUntil))
+	}
+
+	return wait
+}
+
+// OnError keeps track of the number of errors that occur while sending messages,
+// notifies the admin if the alert threshold is exceeded, and pauses the campaign
+// if the error threshold is met.
 func (p *pipe) OnError() {
+	count := p.errors.Add(1)
+
+	if p.m.cfg.AlertErrorThreshold > 0 && int(count) > p.m.cfg.AlertErrorThreshold {
+		notifs.Alert(models.NotificationCampaignFailure, strconv.Itoa(p.camp.ID),
+			p.m.i18n.Ts("notifications.campaignFailure", "name", p.camp.Name), map[string]any{
+				"campaign":  p.camp.Name,
+				"id":        p.camp.ID,
+				"messenger": p.camp.Messenger,
+				"errors":    count,
+				"threshold": p.m.cfg.AlertErrorThreshold,
+			})
+	}
+
 	if p.m.cfg.MaxSendErrors < 1 {
 		return
 	}
 
 	// If the error threshold is met, pause the campaign.
-	count := p.errors.Add(1)
 	if int(count) < p.m.cfg.MaxSendErrors {
 		return
 	}
@@ -171,14 +334,71 @@ func (p *pipe) Stop(withErrors bool) {
 	p.stopped.Store(true)
 }
 
+// loadRevision checks whether the content of the running campaign has been changed
+// since its pipe was created and if yes, compiles the latest revision for the
+// subsequent messages. The subscriber checkpoint at which the revision was picked
+// up is recorded on the change's event.
+func (p *pipe) loadRevision() error {
+	rev, err := p.m.store.GetCampaignRevision(p.camp.ID)
+	if err != nil {
+		return err
+	}
+	if rev == p.content.ContentRevision {
+		return nil
+	}
+
+	cur, err := p.m.store.GetCampaign(p.camp.ID)
+	if err != nil {
+		return err
+	}
+
+	// Only the content changes. The media attachments are retained and the
+	// inline images are resolved again.
+	c := *p.content
+	c.Body = cur.Body
+	c.AltBody = cur.AltBody
+	c.BodySource = cur.BodySource
+	c.TemplateBody = cur.TemplateBody
+	c.TemplateVariants = cur.TemplateVariants
+	c.ContentRevision = cur.ContentRevision
+	c.Attachments = nil
+	for _, a := range p.content.Attachments {
+		if !a.IsInline {
+			c.Attachments = append(c.Attachments, a)
+		}
+	}
+
+	// The body fetched from the body URL at the start of the run is retained.
+	if err := p.m.LoadRemoteBody(&c); err != nil {
+		return err
+	}
+	if err := p.m.LoadInlineImages(&c); err != nil {
+		return err
+	}
+	if err := c.CompileTemplate(p.m.TemplateFuncs(&c)); err != nil {
+		return err
+	}
+	p.content = &c
+
+	if err := p.m.store.ApplyCampaignRevision(p.camp.ID, c.ContentRevision); err != nil {
+		p.m.log.Printf("error recording campaign content revision (%s): %v", p.camp.Name, err)
+	}
+	p.m.log.Printf("switched campaign (%s) to content revision %d", p.camp.Name, c.ContentRevision)
+
+	return nil
+}
+
 // newMessage returns a campaign message while internally incrementing the
 // number of messages in the pipe wait group so that the status of every
 // message can be atomically tracked.
 func (p *pipe) newMessage(s models.Subscriber) (CampaignMessage, error) {
-	msg, err := p.m.NewCampaignMessage(p.camp, s)
+	start := time.Now()
+	s.Attribs = p.attribs.Apply(s.Attribs)
+	msg, err := p.m.newCampaignMessage(p.content, s, p.lists[s.CampaignListID])
 	if err != nil {
 		return msg, err
 	}
+	p.render.add(time.Since(start), len(msg.body)+len(msg.altBody))
 
 	msg.pipe = p
 	p.wg.Add(1)
@@ -194,6 +414,8 @@ func (p *pipe) cleanup() {
 		p.m.pipesMut.Lock()
 		delete(p.m.pipes, p.camp.ID)
 		p.m.pipesMut.Unlock()
+
+		p.m.dropRemoteBody(p.camp.ID)
 	}()
 
 	// Update campaign's 'sent count.
@@ -201,6 +423,13 @@ func (p *pipe) cleanup() {
 		p.m.log.Printf("error updating campaign counts (%s): %v", p.camp.Name, err)
 	}
 
+	// Record the render stats of the messages processed in this run.
+	if st := p.render.stats(); st.Samples > 0 {
+		if err := p.m.store.UpdateCampaignRenderStats(p.camp.ID, st); err != nil {
+			p.m.log.Printf("error updating campaign render stats (%s): %v", p.camp.Name, err)
+		}
+	}
+
 	// The campaign was auto-paused due to errors.
 	if p.withErrors.Load() {
 		if err := p.m.store.UpdateCampaignStatus(p.camp.ID, models.CampaignStatusPaused); err != nil {
@@ -0,0 +1,123 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// remoteBodyTimeout is the timeout for fetching a campaign's body from its body URL.
+	remoteBodyTimeout = time.Second * 10
+
+	// Max size of a campaign body fetched from its body URL.
+	remoteBodyMaxBytes = 10 << 20
+)
+
+var remoteBodyClient = &http.Client{
+	Timeout: remoteBodyTimeout,
+
+	// Body URLs are https only, which redirects shouldn't get around.
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to non-https URL %s", req.URL.Redacted())
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	},
+}
+
+// remoteBody is the body of a running campaign fetched from its body URL.
+type remoteBody struct {
+	url  string
+	body string
+}
+
+// FetchRemoteBody fetches a campaign body from the given URL.
+func FetchRemoteBody(u string) (string, error) {
+	resp, err := remoteBodyClient.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, remoteBodyMaxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > remoteBodyMaxBytes {
+		return "", fmt.Errorf("%s returned a body larger than %d bytes", u, remoteBodyMaxBytes)
+	}
+
+	return string(b), nil
+}
+
+// LoadRemoteBody sets the body of a campaign that has a body URL to the body fetched
+// from it. If the campaign is running, the body fetched when it started is used so that
+// all its messages have the same body. Campaigns that have started have the fetched
+// body saved as their body, which is used as is. It's to be called before CompileTemplate.
+func (m *Manager) LoadRemoteBody(c *models.Campaign) error {
+	if c.BodyURL == "" {
+		return nil
+	}
+
+	m.remoteBodiesMut.RLock()
+	r, ok := m.remoteBodies[c.ID]
+	m.remoteBodiesMut.RUnlock()
+	if ok && r.url == c.BodyURL {
+		c.Body = r.body
+		return nil
+	}
+	if c.Status != models.CampaignStatusDraft && c.Status != models.CampaignStatusScheduled {
+		return nil
+	}
+
+	body, err := FetchRemoteBody(c.BodyURL)
+	if err != nil {
+		return fmt.Errorf("error fetching body of campaign %s: %v", c.Name, err)
+	}
+	c.Body = body
+
+	return nil
+}
+
+// cacheRemoteBody fetches the body of a campaign that has a body URL as it starts
+// running, saves it as the campaign's body, and caches it for the duration of the run.
+func (m *Manager) cacheRemoteBody(c *models.Campaign) error {
+	if c.BodyURL == "" {
+		return nil
+	}
+
+	body, err := FetchRemoteBody(c.BodyURL)
+	if err != nil {
+		return fmt.Errorf("error fetching body of campaign %s: %v", c.Name, err)
+	}
+	c.Body = body
+
+	if err := m.store.UpdateCampaignRemoteBody(c.ID, body); err != nil {
+		return fmt.Errorf("error saving body of campaign %s: %v", c.Name, err)
+	}
+
+	m.remoteBodiesMut.Lock()
+	m.remoteBodies[c.ID] = remoteBody{url: c.BodyURL, body: body}
+	m.remoteBodiesMut.Unlock()
+
+	return nil
+}
+
+// dropRemoteBody removes the cached body of a campaign once it stops running.
+func (m *Manager) dropRemoteBody(campID int) {
+	m.remoteBodiesMut.Lock()
+	delete(m.remoteBodies, campID)
+	m.remoteBodiesMut.Unlock()
+}
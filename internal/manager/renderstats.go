@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// renderSampleRate is the interval (every Nth message) at which the render
+	// time and size of a campaign's messages are sampled.
+	renderSampleRate = 10
+
+	// maxRenderSamples is the maximum number of samples held per campaign.
+	// Beyond this, samples are replaced at random (reservoir sampling) so that
+	// the stats remain representative of the whole campaign.
+	maxRenderSamples = 5000
+)
+
+// RenderSample is the render time and size of a single rendered message.
+type RenderSample struct {
+	Duration time.Duration
+	Size     int
+}
+
+// renderSampler collects sampled render timings of a campaign's messages.
+type renderSampler struct {
+	sync.Mutex
+
+	count   int
+	seen    int
+	samples []RenderSample
+}
+
+// add records a message render. Only every renderSampleRate-th render is sampled.
+func (r *renderSampler) add(d time.Duration, size int) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.count++
+	if r.count%renderSampleRate != 1 {
+		return
+	}
+
+	r.seen++
+	s := RenderSample{Duration: d, Size: size}
+	if len(r.samples) < maxRenderSamples {
+		r.samples = append(r.samples, s)
+		return
+	}
+
+	if i := rand.Intn(r.seen); i < maxRenderSamples {
+		r.samples[i] = s
+	}
+}
+
+// stats returns the stats of the samples collected so far.
+func (r *renderSampler) stats() models.RenderStats {
+	r.Lock()
+	defer r.Unlock()
+
+	return ComputeRenderStats(r.samples)
+}
+
+// ComputeRenderStats computes the render timing percentiles and message size
+// stats of the given render samples.
+func ComputeRenderStats(samples []RenderSample) models.RenderStats {
+	if len(samples) == 0 {
+		return models.RenderStats{}
+	}
+
+	var (
+		durs  = make([]time.Duration, len(samples))
+		total = 0
+		out   = models.RenderStats{Samples: len(samples)}
+	)
+	for i, s := range samples {
+		durs[i] = s.Duration
+		total += s.Size
+		out.SizeMax = max(out.SizeMax, s.Size)
+	}
+	slices.Sort(durs)
+
+	out.P50 = toMillis(percentile(durs, 50))
+	out.P95 = toMillis(percentile(durs, 95))
+	out.Max = toMillis(durs[len(durs)-1])
+	out.SizeAvg = total / len(samples)
+
+	return out
+}
+
+// percentile returns the nearest-rank percentile p of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	n := int(math.Ceil(float64(p)/100*float64(len(sorted)))) - 1
+	return sorted[max(n, 0)]
+}
+
+// toMillis converts a duration to milliseconds rounded to three decimal places.
+func toMillis(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*1000) / 1000
+}
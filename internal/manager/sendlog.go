@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// Interval at which the send log is written to the store, and the number of
+	// buffered messages at which it's written right away.
+	sendLogFlushInterval = time.Second * 5
+	sendLogBatchSize     = 1000
+)
+
+// sendLog buffers the campaign messages sent to subscribers so that they're
+// written to the store in batches instead of one query per message.
+type sendLog struct {
+	items []models.CampaignSend
+	sync.Mutex
+}
+
+// logSend adds a message that was sent to the send log.
+func (m *Manager) logSend(msg CampaignMessage) {
+	m.sendLog.Lock()
+	m.sendLog.items = append(m.sendLog.items, models.CampaignSend{
+		CampaignID:   msg.Campaign.ID,
+		SubscriberID: msg.Subscriber.ID,
+		Subject:      msg.subject,
+		SentAt:       time.Now(),
+		Test:         msg.test,
+	})
+	full := len(m.sendLog.items) >= sendLogBatchSize
+	m.sendLog.Unlock()
+
+	if full {
+		go m.flushSendLog()
+	}
+}
+
+// FlushSendLog writes the buffered messages in the send log to the store right away,
+// eg: before reporting on the messages sent by a campaign.
+func (m *Manager) FlushSendLog() {
+	m.flushSendLog()
+}
+
+// flushSendLog writes the buffered messages in the send log, along with the
+// counts of the messages sent by messengers on warm-up schedules, to the store.
+func (m *Manager) flushSendLog() {
+	m.flushWarmupSends()
+
+	m.sendLog.Lock()
+	items := m.sendLog.items
+	m.sendLog.items = nil
+	m.sendLog.Unlock()
+
+	if len(items) == 0 {
+		return
+	}
+
+	if err := m.store.RecordCampaignSends(items); err != nil {
+		m.log.Printf("error recording %d messages in the send log: %v", len(items), err)
+	}
+}
+
+// runSendLog is a blocking function that periodically writes the send log to the store.
+func (m *Manager) runSendLog() {
+	t := time.NewTicker(sendLogFlushInterval)
+	defer t.Stop()
+
+	for range t.C {
+		m.flushSendLog()
+	}
+}
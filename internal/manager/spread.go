@@ -0,0 +1,122 @@
+package manager
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// maxSpreadWait is the max duration for which a spread out campaign's pipe
+// waits before it's re-evaluated, so that pauses and cancellations are
+// picked up promptly even when the next release is far away.
+const maxSpreadWait = time.Second * 5
+
+// sendSpread releases a campaign's messages gradually over a time window along
+// a curve instead of sending them as fast as possible. Message k of n is due at
+// start + inverse(k/n) * window, where the curve maps the elapsed fraction of
+// the window to the fraction of messages that should have been released by then.
+//
+// The window is anchored to the campaign's original start time. When a paused
+// campaign is resumed, the messages that fell due during the pause are released
+// right away (subject to the rate limits) and the rest remain on the original
+// schedule. After the window has passed, all remaining messages are due.
+type sendSpread struct {
+	start  time.Time
+	window time.Duration
+	curve  string
+
+	// Total number of messages in the campaign and the number of messages that
+	// have been released so far (including those of previous runs).
+	total  int
+	queued int
+}
+
+// newSendSpread returns a sendSpread for a campaign or nil if the campaign
+// isn't spread out.
+func newSendSpread(c *models.Campaign) *sendSpread {
+	if c.SendSpread < 1 {
+		return nil
+	}
+
+	start := time.Now()
+	if c.StartedAt.Valid {
+		start = c.StartedAt.Time
+	}
+
+	return &sendSpread{
+		start:  start,
+		window: time.Duration(c.SendSpread) * time.Minute,
+		curve:  c.SendSpreadCurve,
+		total:  c.ToSend,
+		queued: c.Sent,
+	}
+}
+
+// end returns the time at which the window ends.
+func (s *sendSpread) end() time.Time {
+	return s.start.Add(s.window)
+}
+
+// due returns the number of messages that are due for release at the given time.
+// Once the window has passed or if all the messages in the window have been
+// released (the total can grow as subscribers are added), limit is returned.
+func (s *sendSpread) due(now time.Time, limit int) int {
+	x := float64(now.Sub(s.start)) / float64(s.window)
+	if x >= 1 || s.queued >= s.total {
+		return limit
+	}
+
+	n := int(math.Ceil(float64(s.total)*spreadCurve(s.curve, math.Max(x, 0)))) - s.queued
+	return min(max(n, 0), limit)
+}
+
+// wait returns the duration to wait before the next message is due. A random
+// jitter of up to the average interval between messages is added so that
+// releases don't land on predictable boundaries.
+func (s *sendSpread) wait(now time.Time) time.Duration {
+	if s.due(now, 1) > 0 {
+		return 0
+	}
+
+	var (
+		next = s.start.Add(time.Duration(spreadCurveInverse(s.curve, float64(s.queued+1)/float64(s.total)) * float64(s.window)))
+		wait = next.Sub(now)
+	)
+	if slot := s.window / time.Duration(s.total); slot > 0 {
+		wait += time.Duration(rand.Int63n(int64(min(slot, maxSpreadWait))))
+	}
+
+	return min(max(wait, time.Millisecond), maxSpreadWait)
+}
+
+// spreadCurve returns the fraction of messages that should have been released
+// after the fraction x (0-1) of the window has elapsed.
+func spreadCurve(curve string, x float64) float64 {
+	switch curve {
+	case models.CampaignSpreadRampUp:
+		// The send rate increases linearly across the window.
+		return x * x
+	case models.CampaignSpreadRampDown:
+		// The send rate decreases linearly across the window.
+		return 1 - (1-x)*(1-x)
+	default:
+		return x
+	}
+}
+
+// spreadCurveInverse returns the fraction of the window after which the
+// fraction y (0-1) of messages should have been released.
+func spreadCurveInverse(curve string, y float64) float64 {
+	y = math.Min(math.Max(y, 0), 1)
+
+	switch curve {
+	case models.CampaignSpreadRampUp:
+		return math.Sqrt(y)
+	case models.CampaignSpreadRampDown:
+		return 1 - math.Sqrt(1-y)
+	default:
+		return y
+	}
+}
@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/models"
+)
+
+// maxWarmupWait is the max duration for which the pipe of a campaign whose messenger
+// has reached its daily warm-up limit waits before the limit is checked again,
+// so that the campaign resumes shortly after the day changes.
+const maxWarmupWait = time.Minute
+
+// warmup enforces the daily limits of messengers that are on a warm-up schedule,
+// eg: for a new sending IP. The messages released to such messengers are counted
+// as they're queued and the counts are periodically written to the store, where
+// they're kept per day.
+type warmup struct {
+	schedules map[string][]models.WarmupStep
+
+	// Number of messages released to messengers that are yet to be written to the store.
+	pending map[string]int
+
+	// Warm-up day on which the admin was last alerted of a messenger reaching its limit.
+	alerted map[string]int
+
+	sync.Mutex
+}
+
+// warmupLimit returns the max number of messages that can be sent on a given
+// day of a warm-up schedule (sorted by day), or -1 if the warm-up is over.
+// Days before the first step of the schedule are limited to the first step.
+func warmupLimit(steps []models.WarmupStep, day int) int {
+	if len(steps) == 0 || day > steps[len(steps)-1].Day {
+		return -1
+	}
+
+	n := steps[0].MaxSends
+	for _, s := range steps {
+		if s.Day > day {
+			break
+		}
+		n = s.MaxSends
+	}
+
+	return n
+}
+
+// warmupRemaining returns the number of messages that a messenger can still send
+// today as per its warm-up schedule, or -1 if it's not limited. The admin is alerted
+// the first time the messenger reaches its limit on a day.
+func (m *Manager) warmupRemaining(messenger string) (int, error) {
+	steps, ok := m.warmup.schedules[messenger]
+	if !ok {
+		return -1, nil
+	}
+
+	m.warmup.Lock()
+	defer m.warmup.Unlock()
+
+	st, err := m.store.GetMessengerDailyStats(messenger)
+	if err != nil {
+		return 0, err
+	}
+
+	limit := warmupLimit(steps, st.Day)
+	if limit < 0 {
+		return -1, nil
+	}
+
+	n := max(limit-st.Sent-m.warmup.pending[messenger], 0)
+	if n == 0 && m.warmup.alerted[messenger] != st.Day {
+		m.warmup.alerted[messenger] = st.Day
+		m.log.Printf("messenger %s reached its warm-up limit of %d messages for day %d. Pausing sends until tomorrow.", messenger, limit, st.Day)
+
+		notifs.Alert(models.NotificationWarmupLimit, messenger+":"+strconv.Itoa(st.Day),
+			m.i18n.Ts("notifications.warmupLimit", "name", messenger), map[string]any{
+				"messenger": messenger,
+				"day":       st.Day,
+				"limit":     limit,
+			})
+	}
+
+	return n, nil
+}
+
+// addWarmupSends counts the messages released to a messenger that's on a warm-up schedule.
+func (m *Manager) addWarmupSends(messenger string, n int) {
+	if _, ok := m.warmup.schedules[messenger]; !ok || n < 1 {
+		return
+	}
+
+	m.warmup.Lock()
+	m.warmup.pending[messenger] += n
+	m.warmup.Unlock()
+}
+
+// flushWarmupSends writes the counts of the messages released to messengers on
+// warm-up schedules to the store. Counts that fail to be written are retained
+// and retried on the next flush.
+func (m *Manager) flushWarmupSends() {
+	m.warmup.Lock()
+	defer m.warmup.Unlock()
+
+	for name, n := range m.warmup.pending {
+		if err := m.store.RecordMessengerSends(name, n); err != nil {
+			m.log.Printf("error recording %d messages sent by messenger %s: %v", n, name, err)
+			continue
+		}
+		delete(m.warmup.pending, name)
+	}
+}
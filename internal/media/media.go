@@ -1,7 +1,11 @@
 package media
 
 import (
+	"context"
+	"errors"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/knadh/listmonk/models"
 	"gopkg.in/volatiletech/null.v6"
@@ -18,15 +22,88 @@ type Media struct {
 	ThumbURL    null.String `json:"thumb_url"`
 	Provider    string      `json:"provider"`
 	Meta        models.JSON `db:"meta" json:"meta"`
+	Checksum    string      `db:"checksum" json:"-"`
 	URL         string      `json:"url"`
 
 	Total int `db:"total" json:"-"`
 }
 
+// BackupsDir is the directory (prefix) in the store under which database
+// backups are stored. Files in it are never publicly accessible.
+const BackupsDir = "backups"
+
+// ImportsDir is the directory (prefix) in the store under which the original
+// files of subscriber imports are archived. Files in it are never publicly accessible.
+const ImportsDir = "imports"
+
+// Object represents a file in the store.
+type Object struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // Store represents functions to store and retrieve media (files).
+// Put and Delete return an *Error indicating whether a failure is
+// transient (and worth retrying) or permanent.
 type Store interface {
-	Put(string, string, io.ReadSeeker) (string, error)
-	Delete(string) error
+	Put(context.Context, string, string, io.ReadSeeker) (string, error)
+	Delete(context.Context, string) error
+	List(context.Context, string) ([]Object, error)
 	GetURL(string) string
 	GetBlob(string) ([]byte, error)
 }
+
+// IsPrivate checks if a file name is in a private directory
+// in the store (eg: backups/) that should never be publicly served.
+func IsPrivate(name string) bool {
+	name = strings.TrimLeft(name, "/")
+	for _, dir := range []string{BackupsDir, ImportsDir} {
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Error is a media store error that is either transient (eg: network errors,
+// timeouts, S3 5xx responses) or permanent (eg: invalid credentials, permissions).
+type Error struct {
+	Err       error
+	Transient bool
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// TransientErr wraps an error as a transient store error.
+func TransientErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Err: err, Transient: true}
+}
+
+// PermanentErr wraps an error as a permanent store error.
+func PermanentErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Err: err, Transient: false}
+}
+
+// IsTransient checks if an error is a transient store error. Context
+// deadline errors are considered transient.
+func IsTransient(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Transient
+	}
+
+	return errors.Is(err, context.DeadlineExceeded)
+}
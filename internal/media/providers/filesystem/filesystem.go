@@ -1,9 +1,13 @@
 package filesystem
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 
 	"github.com/knadh/listmonk/internal/media"
@@ -29,20 +33,29 @@ func New(opts Opts) (media.Store, error) {
 }
 
 // Put accepts the filename, the content type and file object itself and stores the file in disk.
-func (c *Client) Put(filename string, cType string, src io.ReadSeeker) (string, error) {
+func (c *Client) Put(ctx context.Context, filename string, cType string, src io.ReadSeeker) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", media.TransientErr(err)
+	}
+
 	// Get the directory path
-	dir := getDir(c.opts.UploadPath)
+	fPath := filepath.Join(getDir(c.opts.UploadPath), filename)
+
+	// Create sub-directories in the file name (eg: backups/).
+	if err := os.MkdirAll(filepath.Dir(fPath), 0755); err != nil {
+		return "", media.PermanentErr(err)
+	}
 
 	// Read the  file contents.
-	out, err := os.OpenFile(filepath.Join(dir, filename), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	out, err := os.OpenFile(fPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
 	if err != nil {
-		return "", err
+		return "", media.PermanentErr(err)
 	}
 	defer out.Close()
 
 	// Copy it to the target location.
 	if _, err := io.Copy(out, src); err != nil {
-		return "", err
+		return "", media.PermanentErr(err)
 	}
 
 	return filename, nil
@@ -53,18 +66,58 @@ func (c *Client) GetURL(name string) string {
 	return fmt.Sprintf("%s%s/%s", c.opts.RootURL, c.opts.UploadURI, name)
 }
 
-// GetBlob accepts a URL, reads the file, and returns the blob.
+// GetBlob accepts a URL, reads the file, and returns the blob. Files in
+// private directories (eg: imports/) are read by their names in the store.
 func (c *Client) GetBlob(url string) ([]byte, error) {
-	b, err := os.ReadFile(filepath.Join(getDir(c.opts.UploadPath), filepath.Base(url)))
+	name := filepath.Base(url)
+	if p := path.Clean("/" + url); media.IsPrivate(p) {
+		name = p
+	}
+
+	b, err := os.ReadFile(filepath.Join(getDir(c.opts.UploadPath), name))
 	return b, err
 }
 
 // Delete accepts a filename and removes it from disk.
-func (c *Client) Delete(file string) error {
+func (c *Client) Delete(ctx context.Context, file string) error {
+	if err := ctx.Err(); err != nil {
+		return media.TransientErr(err)
+	}
+
 	dir := getDir(c.opts.UploadPath)
 
-	err := os.Remove(filepath.Join(dir, file))
-	return err
+	return media.PermanentErr(os.Remove(filepath.Join(dir, file)))
+}
+
+// List returns the files in the given directory in the upload path.
+func (c *Client) List(ctx context.Context, dir string) ([]media.Object, error) {
+	entries, err := os.ReadDir(filepath.Join(getDir(c.opts.UploadPath), dir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []media.Object{}, nil
+		}
+		return nil, media.PermanentErr(err)
+	}
+
+	out := make([]media.Object, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		out = append(out, media.Object{
+			Name:      path.Join(dir, e.Name()),
+			Size:      info.Size(),
+			CreatedAt: info.ModTime(),
+		})
+	}
+
+	return out, nil
 }
 
 // getDir returns the current working directory path if no directory is specified,
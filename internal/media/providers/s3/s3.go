@@ -1,10 +1,17 @@
 package s3
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/url"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,6 +19,9 @@ import (
 	"github.com/rhnvrm/simples3"
 )
 
+// reStatus extracts the HTTP status code from simples3 errors.
+var reStatus = regexp.MustCompile(`status code: (\d{3})`)
+
 // Opt represents AWS S3 specific params
 type Opt struct {
 	URL        string        `koanf:"url"`
@@ -64,24 +74,35 @@ func NewS3Store(opt Opt) (media.Store, error) {
 }
 
 // Put takes in the filename, the content type and file object itself and uploads to S3.
-func (c *Client) Put(name string, cType string, file io.ReadSeeker) (string, error) {
+func (c *Client) Put(ctx context.Context, name string, cType string, file io.ReadSeeker) (string, error) {
+	// Read the file into memory so that an abandoned (timed out) upload
+	// doesn't share the reader with a subsequent retry.
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return "", media.PermanentErr(err)
+	}
+
 	// Upload input parameters
 	p := simples3.UploadInput{
 		Bucket:      c.opts.Bucket,
 		ContentType: cType,
 		FileName:    name,
-		Body:        file,
+		Body:        bytes.NewReader(b),
 
 		// Paths inside the bucket should not start with /.
 		ObjectKey: c.makeBucketPath(name),
 	}
 
-	if c.opts.BucketType == "public" {
+	// Private files (eg: backups) are never made public.
+	if c.opts.BucketType == "public" && !media.IsPrivate(name) {
 		p.ACL = "public-read"
 	}
 
 	// Upload.
-	if _, err := c.s3.FilePut(p); err != nil {
+	if err := c.do(ctx, func() error {
+		_, err := c.s3.FilePut(p)
+		return err
+	}); err != nil {
 		return "", err
 	}
 	return name, nil
@@ -110,7 +131,10 @@ func (c *Client) GetURL(name string) string {
 
 // GetBlob reads a file from S3 and returns the raw bytes.
 func (c *Client) GetBlob(uurl string) ([]byte, error) {
-	if p, err := url.Parse(uurl); err != nil {
+	// Files in private directories (eg: imports/) are read by their names in the store.
+	if p := path.Clean("/" + uurl); media.IsPrivate(p) {
+		uurl = strings.TrimPrefix(p, "/")
+	} else if p, err := url.Parse(uurl); err != nil {
 		uurl = filepath.Base(uurl)
 	} else {
 		uurl = filepath.Base(p.Path)
@@ -119,7 +143,7 @@ func (c *Client) GetBlob(uurl string) ([]byte, error) {
 	// Download the file from S3.
 	file, err := c.s3.FileDownload(simples3.DownloadInput{
 		Bucket:    c.opts.Bucket,
-		ObjectKey: c.makeBucketPath(filepath.Base(uurl)),
+		ObjectKey: c.makeBucketPath(uurl),
 	})
 	if err != nil {
 		return nil, err
@@ -136,13 +160,93 @@ func (c *Client) GetBlob(uurl string) ([]byte, error) {
 }
 
 // Delete accepts the filename of the object and deletes from S3.
-func (c *Client) Delete(name string) error {
-	err := c.s3.FileDelete(simples3.DeleteInput{
-		Bucket:    c.opts.Bucket,
-		ObjectKey: c.makeBucketPath(name),
+func (c *Client) Delete(ctx context.Context, name string) error {
+	return c.do(ctx, func() error {
+		return c.s3.FileDelete(simples3.DeleteInput{
+			Bucket:    c.opts.Bucket,
+			ObjectKey: c.makeBucketPath(name),
+		})
 	})
+}
+
+// List returns the objects in the given directory (prefix) in the bucket.
+func (c *Client) List(ctx context.Context, dir string) ([]media.Object, error) {
+	dir = strings.TrimSuffix(dir, "/")
+
+	var (
+		prefix = c.makeBucketPath(dir + "/")
+		out    = []media.Object{}
+		token  string
+	)
+	for {
+		var res simples3.ListResponse
+		if err := c.do(ctx, func() error {
+			r, err := c.s3.List(simples3.ListInput{
+				Bucket:            c.opts.Bucket,
+				Prefix:            prefix,
+				ContinuationToken: token,
+			})
+			res = r
+			return err
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, o := range res.Objects {
+			t, _ := time.Parse(time.RFC3339, o.LastModified)
+			out = append(out, media.Object{
+				Name:      dir + "/" + strings.TrimPrefix(o.Key, prefix),
+				Size:      o.Size,
+				CreatedAt: t,
+			})
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+
+	return out, nil
+}
+
+// do runs an S3 request and returns its error classified as transient or permanent.
+// simples3 doesn't support contexts, so if the context is done before the request
+// completes, the request is abandoned and a transient error is returned.
+func (c *Client) do(ctx context.Context, fn func() error) error {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- fn()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return media.TransientErr(ctx.Err())
+	case err := <-ch:
+		return classifyErr(err)
+	}
+}
+
+// classifyErr marks network errors and 5xx, 408, 429 responses as transient
+// errors and everything else as permanent.
+func classifyErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var nErr net.Error
+	if errors.As(err, &nErr) {
+		return media.TransientErr(err)
+	}
+
+	if m := reStatus.FindStringSubmatch(err.Error()); len(m) == 2 {
+		code, _ := strconv.Atoi(m[1])
+		if code >= 500 || code == 408 || code == 429 {
+			return media.TransientErr(err)
+		}
+	}
 
-	return err
+	return media.PermanentErr(err)
 }
 
 // makeBucketPath returns the file path inside the bucket. The path should not
@@ -0,0 +1,95 @@
+package media
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// RetryOpt represents the retry options for a Store.
+type RetryOpt struct {
+	// Max number of attempts (including the first one).
+	MaxTries int
+
+	// Time to wait before the first retry. It's doubled on every subsequent retry.
+	Wait time.Duration
+
+	// Timeout for each individual attempt.
+	Timeout time.Duration
+}
+
+// retryStore wraps a Store and retries transient Put and Delete failures.
+type retryStore struct {
+	Store
+	opt RetryOpt
+}
+
+// WithRetry returns a Store that makes best-effort retries on transient
+// Put and Delete failures with exponential backoff. Permanent failures
+// are returned immediately.
+func WithRetry(s Store, o RetryOpt) Store {
+	if o.MaxTries < 1 {
+		o.MaxTries = 1
+	}
+
+	return &retryStore{Store: s, opt: o}
+}
+
+// Put uploads a file retrying on transient errors. The source is rewound
+// before every attempt.
+func (r *retryStore) Put(ctx context.Context, name, cType string, src io.ReadSeeker) (string, error) {
+	var out string
+	err := r.retry(ctx, func(ctx context.Context) error {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return PermanentErr(err)
+		}
+
+		n, err := r.Store.Put(ctx, name, cType, src)
+		out = n
+		return err
+	})
+
+	return out, err
+}
+
+// Delete deletes a file retrying on transient errors.
+func (r *retryStore) Delete(ctx context.Context, name string) error {
+	return r.retry(ctx, func(ctx context.Context) error {
+		return r.Store.Delete(ctx, name)
+	})
+}
+
+func (r *retryStore) retry(ctx context.Context, fn func(context.Context) error) error {
+	var (
+		err  error
+		wait = r.opt.Wait
+	)
+	for n := 0; n < r.opt.MaxTries; n++ {
+		if n > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+
+		err = r.attempt(ctx, fn)
+		if err == nil || !IsTransient(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (r *retryStore) attempt(ctx context.Context, fn func(context.Context) error) error {
+	if r.opt.Timeout <= 0 {
+		return fn(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.opt.Timeout)
+	defer cancel()
+
+	return fn(ctx)
+}
@@ -0,0 +1,173 @@
+// Package capture implements a messenger that doesn't deliver messages, but
+// records the last N messages pushed to it in memory so that they can be
+// inspected, for instance, by end-to-end tests that assert that a subscriber
+// would have received a particular message.
+package capture
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// MessengerName is the name of the capture messenger.
+const MessengerName = "capture"
+
+// Message is a message recorded by the messenger.
+type Message struct {
+	ID             int64                `json:"id"`
+	From           string               `json:"from"`
+	To             []string             `json:"to"`
+	Subject        string               `json:"subject"`
+	ContentType    string               `json:"content_type"`
+	Body           string               `json:"body"`
+	BodyHash       string               `json:"body_hash"`
+	Headers        textproto.MIMEHeader `json:"headers"`
+	SubscriberUUID string               `json:"subscriber_uuid"`
+	CampaignUUID   string               `json:"campaign_uuid"`
+	CreatedAt      time.Time            `json:"created_at"`
+}
+
+// Query filters the recorded messages. Empty fields are ignored.
+type Query struct {
+	// Recipient e-mail (case-insensitive).
+	Email          string
+	SubscriberUUID string
+	CampaignUUID   string
+
+	// Text that the subject or the body contain.
+	Search string
+}
+
+// Capture is an in-memory ring buffer of messages.
+type Capture struct {
+	msgs []Message
+	size int
+
+	// Index in msgs at which the next message is written.
+	cursor int
+	lastID int64
+
+	sync.RWMutex
+}
+
+// New returns a new capture messenger that retains the last size messages.
+func New(size int) *Capture {
+	if size < 1 {
+		size = 1000
+	}
+
+	return &Capture{
+		msgs: make([]Message, 0, size),
+		size: size,
+	}
+}
+
+// Name returns the messenger's name.
+func (c *Capture) Name() string {
+	return MessengerName
+}
+
+// Push records a message, replacing the oldest one if the buffer is full.
+func (c *Capture) Push(m models.Message) error {
+	h := sha256.Sum256(m.Body)
+
+	msg := Message{
+		From:           m.From,
+		To:             m.To,
+		Subject:        m.Subject,
+		ContentType:    m.ContentType,
+		Body:           string(m.Body),
+		BodyHash:       hex.EncodeToString(h[:]),
+		Headers:        m.Headers,
+		SubscriberUUID: m.Subscriber.UUID,
+		CreatedAt:      time.Now(),
+	}
+	if m.Campaign != nil {
+		msg.CampaignUUID = m.Campaign.UUID
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.lastID++
+	msg.ID = c.lastID
+
+	if len(c.msgs) < c.size {
+		c.msgs = append(c.msgs, msg)
+	} else {
+		c.msgs[c.cursor] = msg
+	}
+	c.cursor = (c.cursor + 1) % c.size
+
+	return nil
+}
+
+// Flush is a no-op as messages are recorded immediately.
+func (c *Capture) Flush() error {
+	return nil
+}
+
+// Close is a no-op.
+func (c *Capture) Close() error {
+	return nil
+}
+
+// Get returns the recorded messages that match the query, newest first.
+func (c *Capture) Get(q Query) []Message {
+	c.RLock()
+	defer c.RUnlock()
+
+	var (
+		email  = strings.ToLower(q.Email)
+		search = strings.ToLower(q.Search)
+		out    = []Message{}
+	)
+	for i := 0; i < len(c.msgs); i++ {
+		// Walk backwards from the last written message.
+		m := c.msgs[(c.cursor-1-i+len(c.msgs))%len(c.msgs)]
+
+		if q.SubscriberUUID != "" && m.SubscriberUUID != q.SubscriberUUID {
+			continue
+		}
+		if q.CampaignUUID != "" && m.CampaignUUID != q.CampaignUUID {
+			continue
+		}
+		if email != "" && !hasRecipient(m.To, email) {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(m.Subject), search) &&
+			!strings.Contains(strings.ToLower(m.Body), search) {
+			continue
+		}
+
+		out = append(out, m)
+	}
+
+	return out
+}
+
+// Clear removes all recorded messages.
+func (c *Capture) Clear() {
+	c.Lock()
+	c.msgs = c.msgs[:0]
+	c.cursor = 0
+	c.Unlock()
+}
+
+// hasRecipient checks if the lowercased e-mail is in the list of recipients,
+// which can be plain e-mails or `Name <email>` addresses.
+func hasRecipient(to []string, email string) bool {
+	for _, t := range to {
+		t = strings.ToLower(t)
+		if t == email || strings.HasSuffix(t, "<"+email+">") {
+			return true
+		}
+	}
+	return false
+}
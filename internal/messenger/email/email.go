@@ -2,12 +2,18 @@ package email
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"log"
 	"math/rand"
+	"net"
 	"net/mail"
 	"net/smtp"
 	"net/textproto"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
@@ -21,8 +27,22 @@ const (
 	hdrBcc        = "Bcc"
 	hdrCc         = "Cc"
 	hdrMessageID  = "Message-Id"
+
+	// hdrShadowTo is set on shadow copies of messages to the original recipients.
+	hdrShadowTo = "X-Listmonk-Shadow-To"
+
+	// utf8ProbeTimeout is the timeout for checking a server's SMTPUTF8 support.
+	utf8ProbeTimeout = 10 * time.Second
+
+	// shadowQueueSize is the number of messages that can wait to be shadowed.
+	// Messages are not shadowed when the queue is full.
+	shadowQueueSize = 1000
 )
 
+// ErrNoSMTPUTF8 is returned when an address with a non-ASCII local part is
+// sent through a server that doesn't support SMTPUTF8.
+var ErrNoSMTPUTF8 = fmt.Errorf("%w: no SMTPUTF8 support", models.ErrUnsupportedRecipient)
+
 // Server represents an SMTP server's credentials.
 type Server struct {
 	// Name is a unique identifier for the server.
@@ -35,12 +55,26 @@ type Server struct {
 	EmailHeaders  map[string]string `json:"email_headers"`
 	FromAddresses []string          `json:"from_addresses"`
 
+	// A server in shadow mode doesn't send live messages. Instead, a copy of every
+	// message sent by the live servers is sent through it to ShadowBccAddress.
+	ShadowMode       bool   `json:"shadow_mode"`
+	ShadowBccAddress string `json:"shadow_bcc_address"`
+
 	// Rest of the options are embedded directly from the smtppool lib.
 	// The JSON tag is for config unmarshal to work.
 	//lint:ignore SA5008 ,squash is needed by koanf/mapstructure config unmarshal.
 	smtppool.Opt `json:",squash"`
 
 	pool *smtppool.Pool
+
+	// utf8 holds whether the server advertises SMTPUTF8, which is probed
+	// once on the first message with an internationalized address.
+	utf8 *utf8Support
+}
+
+type utf8Support struct {
+	once sync.Once
+	ok   bool
 }
 
 // Emailer is the SMTP e-mail messenger.
@@ -51,6 +85,21 @@ type Emailer struct {
 	// or a domain set per SMTPs server). An empty key holds all servers
 	// and is the fallback round-robin when there's no match (old behaviour).
 	pools map[string][]*Server
+
+	// shadows are the servers in shadow mode and shadowQ, the queue of the
+	// messages to be shadowed along with the result of their live sends.
+	shadows    []*Server
+	shadowQ    chan shadowMsg
+	shadowDone chan struct{}
+	wg         sync.WaitGroup
+
+	log *log.Logger
+}
+
+// shadowMsg is a message that was sent live and is to be shadowed.
+type shadowMsg struct {
+	msg     models.Message
+	liveErr error
 }
 
 // NormalizeAddr normalizes an e-mail address (strip spaces, lowercase).
@@ -60,11 +109,14 @@ func NormalizeAddr(s string) string {
 
 // New returns an SMTP e-mail Messenger backend with the given SMTP servers.
 // Group indicates whether the messenger represents a group of SMTP servers (1 or more)
-// that are used as a round-robin pool, or a single server.
-func New(name string, servers ...Server) (*Emailer, error) {
+// that are used as a round-robin pool, or a single server. Servers in shadow mode
+// only receive copies of the messages sent by the others, and the differences in
+// the results of the live and shadow sends are logged to lo.
+func New(name string, lo *log.Logger, servers ...Server) (*Emailer, error) {
 	e := &Emailer{
 		name:  name,
 		pools: make(map[string][]*Server),
+		log:   lo,
 	}
 
 	for _, srv := range servers {
@@ -109,6 +161,13 @@ func New(name string, servers ...Server) (*Emailer, error) {
 		}
 
 		s.pool = pool
+		s.utf8 = &utf8Support{}
+
+		// Shadow servers aren't in the pools of live servers.
+		if s.ShadowMode {
+			e.shadows = append(e.shadows, &s)
+			continue
+		}
 
 		// Add to the global list (empty key) and to each from-address
 		// bucket. Duplicate keys across servers are fine and get round-robin'd.
@@ -120,6 +179,18 @@ func New(name string, servers ...Server) (*Emailer, error) {
 		}
 	}
 
+	if len(e.pools[""]) == 0 && len(e.shadows) > 0 {
+		e.closePools()
+		return nil, fmt.Errorf("no SMTP servers that aren't in shadow mode")
+	}
+
+	if len(e.shadows) > 0 {
+		e.shadowQ = make(chan shadowMsg, shadowQueueSize)
+		e.shadowDone = make(chan struct{})
+		e.wg.Add(1)
+		go e.runShadows()
+	}
+
 	return e, nil
 }
 
@@ -130,6 +201,39 @@ func (e *Emailer) Name() string {
 
 // Push pushes a message to the server.
 func (e *Emailer) Push(m models.Message) error {
+	srv, em, err := e.makeEmail(m)
+	if err != nil {
+		return err
+	}
+
+	err = srv.pool.Send(em)
+	e.shadow(m, err)
+
+	return temporaryErr(err)
+}
+
+// PushWithSource pushes a message to the server and returns the raw source of the
+// e-mail. The source is rendered separately from the sent e-mail, so apart from the
+// envelope, only the Date header and the randomly generated MIME boundaries differ.
+func (e *Emailer) PushWithSource(m models.Message) ([]byte, error) {
+	srv, em, err := e.makeEmail(m)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := em.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv.pool.Send(em)
+	e.shadow(m, err)
+
+	return src, temporaryErr(err)
+}
+
+// makeEmail picks the server for a message and creates the e-mail to be sent.
+func (e *Emailer) makeEmail(m models.Message) (*Server, smtppool.Email, error) {
 	// Pick the from-address-routed pool if there is one, else default
 	// to the full pool (empty key) for roundrobin.
 	pool := e.pools[""]
@@ -140,6 +244,12 @@ func (e *Emailer) Push(m models.Message) error {
 	}
 	srv := pool[rand.Intn(len(pool))]
 
+	em, err := srv.makeEmail(m)
+	return srv, em, err
+}
+
+// makeEmail creates the e-mail for a message to be sent through the server.
+func (srv *Server) makeEmail(m models.Message) (smtppool.Email, error) {
 	// Are there attachments?
 	var files []smtppool.Attachment
 	if m.Attachments != nil {
@@ -202,6 +312,9 @@ func (e *Emailer) Push(m models.Message) error {
 		em.Headers.Del(hdrBcc)
 	}
 
+	// Envelope-only recipients.
+	em.Bcc = append(em.Bcc, m.Bcc...)
+
 	// If the `Cc` header is set, it should be set on the Envelope
 	if cc := em.Headers.Get(hdrCc); cc != "" {
 		for _, part := range strings.Split(cc, ",") {
@@ -220,7 +333,15 @@ func (e *Emailer) Push(m models.Message) error {
 		}
 	}
 
-	return srv.pool.Send(em)
+	// Servers that don't support SMTPUTF8 can't accept internationalized
+	// addresses. Convert their IDN domains to punycode.
+	if hasUnicodeAddrs(em) && !srv.supportsSMTPUTF8() {
+		if err := asciiAddrs(&em); err != nil {
+			return em, err
+		}
+	}
+
+	return em, nil
 }
 
 // Flush flushes the message queue to the server.
@@ -230,12 +351,211 @@ func (e *Emailer) Flush() error {
 
 // Close closes the SMTP pools.
 func (e *Emailer) Close() error {
+	// Messages still in the shadow queue are dropped.
+	if e.shadowDone != nil {
+		close(e.shadowDone)
+		e.wg.Wait()
+	}
+
+	e.closePools()
+	return nil
+}
+
+// closePools closes the pools of the live and shadow servers.
+func (e *Emailer) closePools() {
 	for _, s := range e.pools[""] {
 		s.pool.Close()
 	}
+	for _, s := range e.shadows {
+		s.pool.Close()
+	}
+}
+
+// shadow queues a message that was sent live, with the result of the send, to
+// be sent through the shadow servers. If the queue is full, it's skipped.
+func (e *Emailer) shadow(m models.Message, liveErr error) {
+	if e.shadowQ == nil {
+		return
+	}
+
+	select {
+	case e.shadowQ <- shadowMsg{msg: m, liveErr: liveErr}:
+	default:
+		e.log.Printf("shadow queue of messenger %s is full. skipping message to %v", e.name, m.To)
+	}
+}
+
+// runShadows sends the queued messages through every shadow server to its shadow
+// address, and logs the ones whose live and shadow sends had different results.
+func (e *Emailer) runShadows() {
+	defer e.wg.Done()
+
+	for {
+		var s shadowMsg
+		select {
+		case <-e.shadowDone:
+			return
+		case s = <-e.shadowQ:
+		}
+
+		for _, srv := range e.shadows {
+			err := srv.sendShadow(s.msg)
+			if (err == nil) == (s.liveErr == nil) {
+				continue
+			}
+
+			e.log.Printf("shadow discrepancy on messenger %s (%s) for message to %v: live: %v, shadow: %v",
+				e.name, srv.Host, s.msg.To, errOrOK(s.liveErr), errOrOK(err))
+		}
+	}
+}
+
+// sendShadow sends a copy of a message through the server to its shadow address
+// instead of the message's recipients. The recipients are set in a header.
+func (srv *Server) sendShadow(m models.Message) error {
+	em, err := srv.makeEmail(m)
+	if err != nil {
+		return err
+	}
+
+	em.Headers.Set(hdrShadowTo, strings.Join(m.To, ", "))
+	em.To = []string{srv.ShadowBccAddress}
+	em.Cc = nil
+	em.Bcc = nil
+
+	return srv.pool.Send(em)
+}
+
+// temporaryErr wraps 4xx SMTP errors, which are temporary failures
+// that may succeed on retrying, with models.ErrTemporaryFailure.
+func temporaryErr(err error) error {
+	var tErr *textproto.Error
+	if errors.As(err, &tErr) && tErr.Code >= 400 && tErr.Code < 500 {
+		return fmt.Errorf("%w: %w", models.ErrTemporaryFailure, err)
+	}
+
+	return err
+}
+
+// errOrOK returns the error message or "ok" if there's no error.
+func errOrOK(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return err.Error()
+}
+
+// supportsSMTPUTF8 checks whether the server advertises the SMTPUTF8 extension.
+// The server is probed once and the result is cached. If the server can't be
+// reached, it's assumed to not support it.
+func (s *Server) supportsSMTPUTF8() bool {
+	s.utf8.once.Do(func() {
+		s.utf8.ok, _ = s.probeSMTPUTF8()
+	})
+
+	return s.utf8.ok
+}
+
+// probeSMTPUTF8 connects to the server and checks its EHLO response for SMTPUTF8.
+func (s *Server) probeSMTPUTF8() (bool, error) {
+	var (
+		addr   = net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+		dialer = &net.Dialer{Timeout: utf8ProbeTimeout}
+
+		conn net.Conn
+		err  error
+	)
+	if s.Opt.SSL == smtppool.SSLTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, s.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return false, err
+	}
+	conn.SetDeadline(time.Now().Add(utf8ProbeTimeout))
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return false, err
+	}
+	defer c.Close()
+
+	if s.HelloHostname != "" {
+		if err := c.Hello(s.HelloHostname); err != nil {
+			return false, err
+		}
+	}
+
+	ok, _ := c.Extension("SMTPUTF8")
+	_ = c.Quit()
+
+	return ok, nil
+}
+
+// hasUnicodeAddrs checks whether any of the addresses of an e-mail are non-ASCII.
+func hasUnicodeAddrs(em smtppool.Email) bool {
+	if !utils.IsASCII(em.From) || !utils.IsASCII(em.Sender) {
+		return true
+	}
+	for _, list := range [][]string{em.To, em.Cc, em.Bcc} {
+		for _, a := range list {
+			if !utils.IsASCII(a) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// asciiAddrs converts the IDN domains of all the addresses of an e-mail to punycode.
+func asciiAddrs(em *smtppool.Email) error {
+	var err error
+	if em.From, err = toASCIIAddr(em.From); err != nil {
+		return err
+	}
+	if em.Sender, err = toASCIIAddr(em.Sender); err != nil {
+		return err
+	}
+
+	for _, list := range []*[]string{&em.To, &em.Cc, &em.Bcc} {
+		for i, a := range *list {
+			if (*list)[i], err = toASCIIAddr(a); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
+// toASCIIAddr converts the IDN domain of an address, optionally with a display
+// name ("Name <addr>"), to punycode. Addresses with non-ASCII local parts can't
+// be converted and return an error.
+func toASCIIAddr(s string) (string, error) {
+	if utils.IsASCII(s) {
+		return s, nil
+	}
+
+	a, err := mail.ParseAddress(s)
+	if err != nil {
+		return "", err
+	}
+
+	addr, err := utils.EmailToASCII(a.Address)
+	if err != nil {
+		return "", fmt.Errorf("%w: SMTP server doesn't support internationalized (SMTPUTF8) address %s", ErrNoSMTPUTF8, a.Address)
+	}
+
+	if a.Name == "" {
+		return addr, nil
+	}
+	a.Address = addr
+	return a.String(), nil
+}
+
 // getPool returns the pool of servers configured to handle the given From
 // header, matched by full e-mail and then by domain.
 // Returns nil if no mapping matches.
@@ -0,0 +1,919 @@
+package migrations
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/listmonk/internal/bounce"
+	"github.com/knadh/listmonk/internal/utils"
+	"github.com/knadh/listmonk/models"
+	"github.com/knadh/stuffbin"
+	"github.com/lib/pq"
+)
+
+func V6_3_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	// Media IDs of custom spellcheck dictionaries.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES ('spellcheck.dictionary_ids', '[]') ON CONFLICT (key) DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Full-text search vector on campaign name, subject, and body.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS search_tsv TSVECTOR GENERATED ALWAYS AS (
+			SETWEIGHT(TO_TSVECTOR('simple', name), 'A') ||
+			SETWEIGHT(TO_TSVECTOR('simple', subject), 'B') ||
+			SETWEIGHT(TO_TSVECTOR('simple', body), 'C')
+		) STORED;
+		CREATE INDEX IF NOT EXISTS idx_camps_search ON campaigns USING GIN(search_tsv);
+	`); err != nil {
+		return err
+	}
+
+	// Scheduled database backups to the media store.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES ('maintenance.backup', '{"enabled": false, "cron_interval": "0 3 * * *", "method": "sql", "keep": 7}') ON CONFLICT (key) DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Two-phase campaign start confirmation.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES ('app.confirm_campaign_start', 'false') ON CONFLICT (key) DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Number of rows committed per transaction in subscriber imports.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES ('app.import_batch_size', '1000') ON CONFLICT (key) DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Maximum size (MB) of the CSV file of rows that failed to import.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES ('app.import_error_file_size', '10') ON CONFLICT (key) DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Archive cover image, accent color, and excerpt on campaigns.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns
+			ADD COLUMN IF NOT EXISTS archive_cover_media_id INTEGER NULL REFERENCES media(id) ON DELETE SET NULL,
+			ADD COLUMN IF NOT EXISTS archive_accent_color TEXT NULL,
+			ADD COLUMN IF NOT EXISTS archive_excerpt TEXT NULL;
+	`); err != nil {
+		return err
+	}
+
+	// Admin notifications for critical events.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('notifications.email', '{"enabled": false, "emails": []}'),
+			('notifications.webhook', '{"enabled": false, "url": ""}'),
+			('notifications.events', '{"campaign_failure": {"enabled": true, "threshold": 100}, "bounce_spike": {"enabled": true, "threshold": 5, "window": "1h"}, "new_login": {"enabled": true}, "db_pool": {"enabled": true}}')
+		ON CONFLICT (key) DO NOTHING;
+
+		CREATE TABLE IF NOT EXISTS user_login_ips (
+			user_id          INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			ip               TEXT NOT NULL,
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+			PRIMARY KEY (user_id, ip)
+		);
+
+		CREATE TABLE IF NOT EXISTS notifications_log (
+			id               BIGSERIAL PRIMARY KEY,
+			type             TEXT NOT NULL,
+			subject          TEXT NOT NULL,
+			data             JSONB NOT NULL DEFAULT '{}',
+			channels         TEXT[] NOT NULL DEFAULT '{}',
+			error            TEXT NULL,
+			created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_notifications_log_type ON notifications_log(type);
+		CREATE INDEX IF NOT EXISTS idx_notifications_log_date ON notifications_log(created_at);
+	`); err != nil {
+		return err
+	}
+
+	// Per-list encryption of subscriber attributes at rest.
+	if _, err := db.Exec(`ALTER TABLE lists ADD COLUMN IF NOT EXISTS sensitive BOOLEAN NOT NULL DEFAULT false;`); err != nil {
+		return err
+	}
+
+	// List-level send frequency limits.
+	if _, err := db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS max_campaigns_per_week INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS max_campaigns_per_month INTEGER NOT NULL DEFAULT 0;
+		INSERT INTO settings (key, value) VALUES ('app.enforce_list_send_limits', 'false') ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Signed List-Unsubscribe mailto: addresses processed by the bounce mailbox.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('privacy.unsubscribe_mailto', '{"enabled": false, "address": ""}'),
+			('security.unsubscribe_mailto_key', TO_JSONB(ENCODE(GEN_RANDOM_BYTES(32), 'hex')))
+		ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Lists whose subscribers are excluded from a campaign.
+	if _, err := db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS exclude_list_ids INTEGER[] NOT NULL DEFAULT '{}'`); err != nil {
+		return err
+	}
+
+	// Journaling (archiving) copies of campaign and transactional messages.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS journal_address TEXT NOT NULL DEFAULT '';
+		INSERT INTO settings (key, value) VALUES ('privacy.journal', '{"enabled": false, "address": "", "mode": "bcc", "tx": false}')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Topics and subscriber topic preferences.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS topics (
+			id              SERIAL PRIMARY KEY,
+			name            TEXT NOT NULL UNIQUE,
+			description     TEXT NOT NULL DEFAULT '',
+			created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE TABLE IF NOT EXISTS subscriber_topics (
+			subscriber_id   INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			topic_id        INTEGER NOT NULL REFERENCES topics(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscribed      BOOLEAN NOT NULL DEFAULT true,
+			updated_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY(subscriber_id, topic_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_sub_topics_topic_id ON subscriber_topics(topic_id);
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS topic_ids INTEGER[] NOT NULL DEFAULT '{}';
+	`); err != nil {
+		return err
+	}
+
+	// External campaign approval gates.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS gate_url TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS gate_status TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS gate_reason TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS gate_checked_at TIMESTAMP WITH TIME ZONE NULL;
+		INSERT INTO settings (key, value) VALUES
+			('security.campaign_gate', '{"enabled": false, "urls": [], "secret": "", "timeout": "10s", "retry_interval": "5m"}')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Campaign render stats.
+	if _, err := db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS render_stats JSONB NOT NULL DEFAULT '{}'`); err != nil {
+		return err
+	}
+
+	// Internationalized (EAI) e-mail addresses.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES ('privacy.strict_ascii_email', 'false') ON CONFLICT DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Normalize existing IDN and non-ASCII addresses (NFC, Unicode domains) so that the
+	// Unicode and punycode forms of an address can't both exist. Addresses whose normalized
+	// form already exists are left as-is.
+	var subs []struct {
+		ID    int    `db:"id"`
+		Email string `db:"email"`
+	}
+	if err := db.Select(&subs, `SELECT id, email FROM subscribers WHERE email ~ '[^[:ascii:]]' OR email ILIKE '%@%xn--%'`); err != nil {
+		return err
+	}
+	for _, s := range subs {
+		em, err := utils.SanitizeEmail(s.Email)
+		if err != nil || em == s.Email {
+			continue
+		}
+
+		res, err := db.Exec(`UPDATE subscribers SET email=$2 WHERE id=$1
+			AND NOT EXISTS (SELECT 1 FROM subscribers WHERE LOWER(email) = LOWER($2))`, s.ID, em)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			lo.Printf("subscriber %d: normalized e-mail %s already exists, not updating", s.ID, em)
+		}
+	}
+
+	// Password protected archive pages.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS archive_password TEXT NULL;
+		INSERT INTO settings (key, value) VALUES ('security.archive_key', TO_JSONB(ENCODE(GEN_RANDOM_BYTES(32), 'hex')))
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Spreading out of campaign messages over a time window.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS send_spread INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS send_spread_curve TEXT NOT NULL DEFAULT 'uniform';
+	`); err != nil {
+		return err
+	}
+
+	// Saved segments with typed params.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS segments (
+			id              SERIAL PRIMARY KEY,
+			name            TEXT NOT NULL UNIQUE,
+			description     TEXT NOT NULL DEFAULT '',
+			query           TEXT NOT NULL,
+			params          JSONB NOT NULL DEFAULT '[]',
+			created_by      INTEGER NULL,
+			approved_by     INTEGER NULL,
+			approved_at     TIMESTAMP WITH TIME ZONE NULL,
+			created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS segment_id INTEGER NULL REFERENCES segments(id) ON UPDATE CASCADE;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS segment_params JSONB NOT NULL DEFAULT '{}';
+	`); err != nil {
+		return err
+	}
+
+	// Size limit of bounce mailbox messages.
+	if _, err := db.Exec(`
+		UPDATE settings SET value = (
+			SELECT COALESCE(JSONB_AGG(
+				CASE WHEN b ? 'max_message_size' THEN b ELSE b || '{"max_message_size": 10240}' END ORDER BY n
+			), '[]')
+			FROM JSONB_ARRAY_ELEMENTS(value) WITH ORDINALITY AS t(b, n)
+		) WHERE key = 'bounce.mailboxes';
+	`); err != nil {
+		return err
+	}
+
+	// Frozen campaign audiences.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS freeze_audience BOOLEAN NOT NULL DEFAULT false;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS audience_frozen_at TIMESTAMP WITH TIME ZONE NULL;
+
+		CREATE TABLE IF NOT EXISTS campaign_audience_snapshots (
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			PRIMARY KEY (campaign_id, subscriber_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_audience_sub_id ON campaign_audience_snapshots(subscriber_id);
+	`); err != nil {
+		return err
+	}
+
+	// Delivery at the local time of subscribers.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS send_at_local_time TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS local_wave_at TIMESTAMP WITH TIME ZONE NULL;
+	`); err != nil {
+		return err
+	}
+
+	// Normalized bounce reasons.
+	if _, err := db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'bounce_reason') THEN
+				CREATE TYPE bounce_reason AS ENUM ('invalid_recipient', 'mailbox_full', 'content_rejected', 'reputation_block', 'dmarc_policy', 'other');
+			END IF;
+		END$$;
+
+		ALTER TABLE bounces ADD COLUMN IF NOT EXISTS reason bounce_reason NOT NULL DEFAULT 'other';
+		CREATE INDEX IF NOT EXISTS idx_bounces_reason ON bounces(reason);
+		INSERT INTO settings (key, value) VALUES ('bounce.reasons', '[]') ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Classify the existing bounces with the built-in rules in batches.
+	cl, err := bounce.NewClassifier(nil)
+	if err != nil {
+		return err
+	}
+	lastID := 0
+	for {
+		var rows []struct {
+			ID   int             `db:"id"`
+			Meta json.RawMessage `db:"meta"`
+		}
+		if err := db.Select(&rows, `SELECT id, meta FROM bounces WHERE id > $1 AND reason = 'other' ORDER BY id LIMIT 5000`, lastID); err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		var (
+			ids     = make([]int, 0, len(rows))
+			reasons = make([]string, 0, len(rows))
+		)
+		for _, r := range rows {
+			lastID = r.ID
+			if reason := cl.Classify(models.Bounce{Meta: r.Meta}); reason != models.BounceReasonOther {
+				ids = append(ids, r.ID)
+				reasons = append(reasons, reason)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		if _, err := db.Exec(`UPDATE bounces SET reason = u.reason::bounce_reason
+			FROM UNNEST($1::INT[], $2::TEXT[]) AS u(id, reason) WHERE bounces.id = u.id`, pq.Array(ids), pq.Array(reasons)); err != nil {
+			return err
+		}
+	}
+
+	// Media checksums for deduplicating uploads.
+	if _, err := db.Exec(`
+		ALTER TABLE media ADD COLUMN IF NOT EXISTS checksum TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS idx_media_checksum ON media(provider, checksum);
+	`); err != nil {
+		return err
+	}
+
+	// Subscriber attribute changelog.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS attrib_changelog (
+			id              BIGSERIAL PRIMARY KEY,
+			subscriber_id   INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			changed_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			changed_by      INTEGER NULL,
+			old_value       JSONB NOT NULL DEFAULT '{}',
+			new_value       JSONB NOT NULL DEFAULT '{}'
+		);
+		CREATE INDEX IF NOT EXISTS idx_attrib_changelog_sub ON attrib_changelog(subscriber_id, changed_at);
+	`); err != nil {
+		return err
+	}
+
+	// Content revisions of running campaigns and campaign events.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS content_revision INT NOT NULL DEFAULT 0;
+		ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 0;
+
+		CREATE TABLE IF NOT EXISTS campaign_events (
+			id               BIGSERIAL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			type             TEXT NOT NULL,
+			data             JSONB NOT NULL DEFAULT '{}',
+			created_by       INTEGER NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_events_camp_id ON campaign_events(campaign_id, type);
+	`); err != nil {
+		return err
+	}
+
+	// Subscriber tokens for the links in messages, independent of UUIDs. The tokens of
+	// the existing subscribers are generated in batches.
+	if _, err := db.Exec(`ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS unsubscribe_token UUID NULL`); err != nil {
+		return err
+	}
+	for {
+		var ids []int
+		if err := db.Select(&ids, `SELECT id FROM subscribers WHERE unsubscribe_token IS NULL ORDER BY id LIMIT 5000`); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		tokens := make([]string, len(ids))
+		for i := range ids {
+			uu, err := uuid.NewV4()
+			if err != nil {
+				return err
+			}
+			tokens[i] = uu.String()
+		}
+
+		if _, err := db.Exec(`UPDATE subscribers SET unsubscribe_token = u.token
+			FROM UNNEST($1::INT[], $2::UUID[]) AS u(id, token) WHERE subscribers.id = u.id`, pq.Array(ids), pq.Array(tokens)); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(`
+		ALTER TABLE subscribers ALTER COLUMN unsubscribe_token SET NOT NULL;
+		CREATE UNIQUE INDEX IF NOT EXISTS subscribers_unsubscribe_token_key ON subscribers(unsubscribe_token);
+	`); err != nil {
+		return err
+	}
+
+	// Subscriber attributes available to templates and to the public pages and APIs.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('privacy.template_attribs', '{"mode": "all", "keys": []}'),
+			('privacy.public_attribs', '{"mode": "allow", "keys": []}')
+		ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Previous versions of templates.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS template_versions (
+			id              BIGSERIAL PRIMARY KEY,
+			template_id     INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subject         TEXT NOT NULL,
+			body            TEXT NOT NULL,
+			body_source     TEXT NULL,
+			created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_tpl_versions_tpl_id ON template_versions(template_id);
+	`); err != nil {
+		return err
+	}
+
+	// Capture messenger for end-to-end tests.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES ('capture_messenger', '{"enabled": false, "size": 1000}') ON CONFLICT DO NOTHING`); err != nil {
+		return err
+	}
+
+	// List auto-assignment rules.
+	if _, err := db.Exec(`ALTER TABLE lists ADD COLUMN IF NOT EXISTS auto_assignment_rules JSONB NOT NULL DEFAULT '[]'`); err != nil {
+		return err
+	}
+
+	// Token buckets for rate limiting.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS rate_limits (
+			key              TEXT NOT NULL PRIMARY KEY,
+			tokens           DOUBLE PRECISION NOT NULL,
+			allowed          BOOLEAN NOT NULL DEFAULT TRUE,
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_rate_limits_date ON rate_limits(updated_at);
+	`); err != nil {
+		return err
+	}
+
+	// Failed campaign messages that can be retried.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_send_failures (
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			error            TEXT NOT NULL DEFAULT '',
+			retry_count      INTEGER NOT NULL DEFAULT 0,
+			last_attempt_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			PRIMARY KEY (campaign_id, subscriber_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_send_failures_sub_id ON campaign_send_failures(subscriber_id);
+	`); err != nil {
+		return err
+	}
+
+	// New subscribers feed.
+	if _, err := db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_sub_lists_created_at ON subscriber_lists(created_at);
+		INSERT INTO settings (key, value) VALUES
+			('security.subscriber_feed', '{"enabled": false, "token": ""}')
+			ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Campaign tracking domains.
+	if _, err := db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS tracking_domain TEXT NULL`); err != nil {
+		return err
+	}
+
+	// Campaign surveys.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS survey JSONB NOT NULL DEFAULT '[]';
+		CREATE TABLE IF NOT EXISTS survey_responses (
+			id               BIGSERIAL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			question_id      TEXT NOT NULL,
+			answer           TEXT NOT NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_survey_responses ON survey_responses(campaign_id, subscriber_id, question_id);
+	`); err != nil {
+		return err
+	}
+
+	// Per-IP bounce notifications and stats.
+	if _, err := db.Exec(`
+		UPDATE settings SET value = value || '{"ip_bounce_spike": {"enabled": true, "threshold": 50, "window": "1h"}}'
+			WHERE key = 'notifications.events' AND NOT value ? 'ip_bounce_spike';
+
+		CREATE TABLE IF NOT EXISTS ip_reputation_stats (
+			id               BIGSERIAL PRIMARY KEY,
+			ip               TEXT NOT NULL,
+			bounces          INTEGER NOT NULL,
+			total_bounces    INTEGER NOT NULL,
+			window_seconds   INTEGER NOT NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_ip_rep_stats_ip ON ip_reputation_stats(ip, created_at);
+	`); err != nil {
+		return err
+	}
+
+	// Max. size of template bodies.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES ('app.template_max_body_bytes', '512000') ON CONFLICT (key) DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Sources of subscriptions. The column is added without a default first so that
+	// existing subscriptions, whose sources aren't known, are left NULL.
+	if _, err := db.Exec(`
+		ALTER TABLE subscriber_lists ADD COLUMN IF NOT EXISTS subscribe_source TEXT NULL;
+		ALTER TABLE subscriber_lists ALTER COLUMN subscribe_source SET DEFAULT 'api';
+	`); err != nil {
+		return err
+	}
+
+	// Delivered counts of campaigns, confirmed by the new 'webhooks:post_delivery' permission
+	// that's granted to the roles that can post bounces.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS delivered INT NOT NULL DEFAULT 0;
+
+		UPDATE roles SET permissions = permissions || '{webhooks:post_delivery}'
+		WHERE permissions @> '{webhooks:post_bounce}' AND NOT permissions @> '{webhooks:post_delivery}';
+	`); err != nil {
+		return err
+	}
+
+	// Indexes for paginating the views and clicks of campaigns by ID.
+	if _, err := db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_views_camp_id_id ON campaign_views(campaign_id, id);
+		CREATE INDEX IF NOT EXISTS idx_clicks_camp_id_id ON link_clicks(campaign_id, id);
+	`); err != nil {
+		return err
+	}
+
+	// Webhook for subscribers joining and leaving lists.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('notifications.list_webhook', '{"enabled": false, "url": "", "secret": ""}')
+			ON CONFLICT (key) DO NOTHING;
+		CREATE INDEX IF NOT EXISTS idx_sub_lists_unsub_updated_at ON subscriber_lists(updated_at) WHERE status = 'unsubscribed';
+	`); err != nil {
+		return err
+	}
+
+	// Time of unsubscription on subscriptions, backfilled from the last update of the
+	// existing unsubscribed ones, and the re-subscribe protection period.
+	if _, err := db.Exec(`
+		ALTER TABLE subscriber_lists ADD COLUMN IF NOT EXISTS unsubscribed_at TIMESTAMP WITH TIME ZONE NULL;
+		UPDATE subscriber_lists SET unsubscribed_at = updated_at WHERE status = 'unsubscribed' AND unsubscribed_at IS NULL;
+
+		INSERT INTO settings (key, value) VALUES ('privacy.resubscribe_protection_days', '30')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// UTM param templates of campaigns, the global default template, and the
+	// UTM params recorded on link clicks.
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS utm_template JSONB NULL;
+		ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS utm JSONB NULL;
+
+		INSERT INTO settings (key, value) VALUES ('app.utm_template', '{}')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Template library of read-only templates in categories that are cloned to be used.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS template_categories (
+			id              SERIAL PRIMARY KEY,
+			name            TEXT NOT NULL UNIQUE,
+			created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+
+		ALTER TABLE templates ADD COLUMN IF NOT EXISTS is_library BOOLEAN NOT NULL DEFAULT false;
+		ALTER TABLE templates ADD COLUMN IF NOT EXISTS category_id INTEGER NULL REFERENCES template_categories(id) ON DELETE SET NULL ON UPDATE CASCADE;
+		ALTER TABLE templates ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+	`); err != nil {
+		return err
+	}
+
+	var hasLibrary bool
+	if err := db.Get(&hasLibrary, `SELECT EXISTS(SELECT 1 FROM templates WHERE is_library = true)`); err != nil {
+		return err
+	}
+	if !hasLibrary {
+		b, err := fs.Read("/static/email-templates/library/library.json")
+		if err != nil {
+			return err
+		}
+
+		var lib []struct {
+			Name        string `json:"name"`
+			Category    string `json:"category"`
+			Description string `json:"description"`
+			File        string `json:"file"`
+		}
+		if err := json.Unmarshal(b, &lib); err != nil {
+			return err
+		}
+
+		for _, t := range lib {
+			body, err := fs.Read("/static/email-templates/library/" + t.File)
+			if err != nil {
+				return err
+			}
+
+			if _, err := db.Exec(`
+				WITH cat AS (
+					INSERT INTO template_categories (name) VALUES($4)
+						ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id
+				)
+				INSERT INTO templates (name, type, subject, body, is_library, category_id, description)
+					VALUES($1, 'campaign', '', $2, true, (SELECT id FROM cat), $3)
+			`, t.Name, body, t.Description, t.Category); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Max. size of media ZIP archive downloads.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('upload.max_archive_size_mb', '100')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// E-mail allowlist / denylist patterns.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('privacy.email_allowlist_patterns', '[]'),
+			('privacy.email_denylist_patterns', '[]')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Dashboard charts are queried live for the selected date range.
+	if _, err := db.Exec(`DROP MATERIALIZED VIEW IF EXISTS mat_dashboard_charts;`); err != nil {
+		return err
+	}
+
+	// Language variants of campaign templates.
+	if _, err := db.Exec(`
+		ALTER TABLE templates ADD COLUMN IF NOT EXISTS lang TEXT NULL;
+		ALTER TABLE templates ADD COLUMN IF NOT EXISTS parent_template_id INTEGER NULL
+			REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_templates_lang ON templates (parent_template_id, LOWER(lang))
+			WHERE parent_template_id IS NOT NULL;
+	`); err != nil {
+		return err
+	}
+
+	// Log of campaign messages sent to subscribers.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_sends (
+			id               BIGSERIAL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subject          TEXT NOT NULL DEFAULT '',
+			sent_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_camp_sends_sub_id ON campaign_sends(subscriber_id, sent_at);
+	`); err != nil {
+		return err
+	}
+
+	// Severities of the template lint rules.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('app.template_lint_rules',
+			'{"div_layout": "warning", "unsupported_css": "error", "head_styles": "warning", "viewport_meta": "notice"}')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Send reports of campaigns count the messages sent by campaign.
+	if _, err := db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_camp_sends_camp_id ON campaign_sends(campaign_id);
+	`); err != nil {
+		return err
+	}
+
+	// Service account for importing subscribers from Google Sheets.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('security.google_sheets', '{"enabled": false, "credentials": ""}')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Limit of concurrently running campaigns.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('app.max_concurrent_campaigns', '5'),
+			('app.campaign_queue_delay', '"1m"')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Privacy mode that decides whether deleting a subscriber anonymizes them.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('privacy.mode', '"delete"')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Minification of the HTML of campaigns.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('app.campaign_minify_html', 'false')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Messenger warm-up schedules.
+	if _, err := db.Exec(`
+		UPDATE settings SET value = value || '{"warmup_limit": {"enabled": true}}'
+			WHERE key = 'notifications.events' AND NOT value ? 'warmup_limit';
+
+		CREATE TABLE IF NOT EXISTS messenger_daily_stats (
+			messenger        TEXT NOT NULL,
+			day              DATE NOT NULL,
+			sent             INTEGER NOT NULL DEFAULT 0,
+
+			PRIMARY KEY (messenger, day)
+		);
+	`); err != nil {
+		return err
+	}
+
+	// Subscription landing pages for lists.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS landing_pages (
+			id               SERIAL PRIMARY KEY,
+			uuid             UUID NOT NULL UNIQUE,
+			list_id          INTEGER NOT NULL UNIQUE REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			custom_css       TEXT NOT NULL DEFAULT '',
+			body             TEXT NOT NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+	`); err != nil {
+		return err
+	}
+
+	// E-mail clients of campaign views.
+	if _, err := db.Exec(`ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS client TEXT NULL;`); err != nil {
+		return err
+	}
+
+	// Archives of the original files of subscriber imports.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS import_archives (
+			id               SERIAL PRIMARY KEY,
+			filename         TEXT NOT NULL,
+			path             TEXT NOT NULL,
+			size             BIGINT NOT NULL DEFAULT 0,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_import_archives_created_at ON import_archives(created_at);
+	`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('app.archive_imports', 'false'),
+			('app.import_archive_retention_days', '90')
+		ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// ISPs of campaign views recorded on per-ISP tracking domains.
+	if _, err := db.Exec(`ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS isp TEXT NULL;`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES ('privacy.isp_tracking_domain', '""') ON CONFLICT (key) DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Re-confirmations of the subscriptions of inactive subscribers.
+	if _, err := db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'reconfirmation_status') THEN
+				CREATE TYPE reconfirmation_status AS ENUM ('pending', 'confirmed', 'expired');
+			END IF;
+		END$$;
+
+		CREATE TABLE IF NOT EXISTS reconfirmations (
+			id               SERIAL PRIMARY KEY,
+			uuid             UUID NOT NULL UNIQUE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			list_ids         INTEGER[] NOT NULL,
+			status           reconfirmation_status NOT NULL DEFAULT 'pending',
+			expires_at       TIMESTAMP WITH TIME ZONE NOT NULL,
+			confirmed_at     TIMESTAMP WITH TIME ZONE NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_reconfirmations_sub_id ON reconfirmations(subscriber_id);
+		CREATE INDEX IF NOT EXISTS idx_reconfirmations_status ON reconfirmations(status, expires_at);
+	`); err != nil {
+		return err
+	}
+
+	// Audit trail of the consents of subscribers to lists.
+	if _, err := db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'consent_type') THEN
+				CREATE TYPE consent_type AS ENUM ('subscribe', 'unsubscribe', 'marketing');
+			END IF;
+		END$$;
+
+		CREATE TABLE IF NOT EXISTS consents (
+			id                     BIGSERIAL PRIMARY KEY,
+			subscriber_id          INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			list_id                INTEGER NULL REFERENCES lists(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			campaign_id            INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			consent_type           consent_type NOT NULL,
+			ip                     TEXT NULL,
+			user_agent             TEXT NULL,
+			consent_text_snapshot  TEXT NOT NULL DEFAULT '',
+			created_at             TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_consents_sub_id ON consents(subscriber_id, created_at);
+	`); err != nil {
+		return err
+	}
+
+	// Consents are exportable by subscribers.
+	if _, err := db.Exec(`
+		UPDATE settings SET value = value || '["consents"]'
+			WHERE key = 'privacy.exportable' AND NOT value ? 'consents';
+	`); err != nil {
+		return err
+	}
+
+	// Delimiters of template expressions in campaign bodies (empty for the default {{ and }}).
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('app.template_left_delim', '""'),
+			('app.template_right_delim', '""')
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Custom rules for classifying mailbox bounces as hard or soft.
+	if _, err := db.Exec(`INSERT INTO settings (key, value) VALUES ('bounce.classification_rules', '[]') ON CONFLICT (key) DO NOTHING`); err != nil {
+		return err
+	}
+
+	// Messages sent to test cohorts of campaigns in the send log.
+	if _, err := db.Exec(`
+		ALTER TABLE campaign_sends ADD COLUMN IF NOT EXISTS test BOOLEAN NOT NULL DEFAULT false;
+		CREATE INDEX IF NOT EXISTS idx_camp_sends_test ON campaign_sends(campaign_id, subscriber_id) WHERE test;
+	`); err != nil {
+		return err
+	}
+
+	// URL from which campaign bodies are fetched at send time.
+	if _, err := db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS body_url TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+
+	// Conversions reported by the destination pages of link clicks.
+	if _, err := db.Exec(`ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS converted_at TIMESTAMP WITH TIME ZONE NULL`); err != nil {
+		return err
+	}
+
+	// Key of the e-mail hashes with which the status of public subscriptions is polled.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES ('security.sub_status_key', TO_JSONB(ENCODE(GEN_RANDOM_BYTES(32), 'hex')))
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	// Subscriber UUIDs in the links in messages sent before subscriber tokens were
+	// introduced are accepted for 90 days after the upgrade.
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value)
+			VALUES ('privacy.legacy_uuid_links_until', TO_JSONB(TO_CHAR(NOW() + INTERVAL '90 days', 'YYYY-MM-DD')))
+			ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	return nil
+}
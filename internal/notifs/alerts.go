@@ -0,0 +1,144 @@
+package notifs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	// alertCooldown is the period within which repeated alerts of the same
+	// type and key are dropped.
+	alertCooldown = time.Hour
+
+	// webhookTimeout is the timeout for posting alerts to the webhook.
+	webhookTimeout = time.Second * 10
+)
+
+// AlertOpt represents the options for admin notifications of critical events.
+type AlertOpt struct {
+	// Types of events (models.Notification*) that are enabled.
+	Events map[string]bool
+
+	// E-mails to send alerts to. If empty, e-mail alerts are disabled.
+	Emails []string
+
+	// URL to post alerts to as JSON. If empty, webhook alerts are disabled.
+	WebhookURL string
+
+	// Log records every sent alert in the notifications log.
+	Log func(models.Notification) error
+}
+
+// alertPayload is the JSON body posted to the alert webhook.
+type alertPayload struct {
+	Type      string         `json:"type"`
+	Subject   string         `json:"subject"`
+	Data      map[string]any `json:"data"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// Alert sends out an admin notification for a critical event to the configured e-mail
+// and webhook targets and records it in the notifications log. key identifies the
+// source of the event (eg: a campaign ID). Repeated alerts of the same type and key
+// within the cooldown period are dropped. The alert is sent asynchronously.
+func Alert(typ, key, subject string, data map[string]any) {
+	if no == nil || !no.opt.Alerts.Events[typ] {
+		return
+	}
+
+	// Drop the alert if it was already sent recently.
+	k := typ + ":" + key
+	no.alertMut.Lock()
+	if t, ok := no.alerts[k]; ok && time.Since(t) < alertCooldown {
+		no.alertMut.Unlock()
+		return
+	}
+	no.alerts[k] = time.Now()
+	no.alertMut.Unlock()
+
+	go no.sendAlert(typ, subject, data)
+}
+
+// sendAlert sends an alert to all the configured targets and logs it.
+func (n *Notifs) sendAlert(typ, subject string, data map[string]any) {
+	var (
+		opt      = n.opt.Alerts
+		channels = []string{}
+		errs     []string
+	)
+
+	// E-mail.
+	if len(opt.Emails) > 0 {
+		channels = append(channels, models.NotificationChannelEmail)
+
+		if err := Notify(opt.Emails, subject, TplAdminNotification, map[string]any{
+			"Type":    typ,
+			"Subject": subject,
+			"Data":    data,
+		}, nil); err != nil {
+			errs = append(errs, models.NotificationChannelEmail+": "+err.Error())
+		}
+	}
+
+	// Webhook.
+	if opt.WebhookURL != "" {
+		channels = append(channels, models.NotificationChannelWebhook)
+
+		if err := n.postAlert(opt.WebhookURL, typ, subject, data); err != nil {
+			n.lo.Printf("error posting admin notification (%s) to webhook: %v", subject, err)
+			errs = append(errs, models.NotificationChannelWebhook+": "+err.Error())
+		}
+	}
+
+	if opt.Log == nil {
+		return
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		n.lo.Printf("error marshalling admin notification data (%s): %v", subject, err)
+	}
+
+	rec := models.Notification{
+		Type:     typ,
+		Subject:  subject,
+		Data:     b,
+		Channels: channels,
+	}
+	if len(errs) > 0 {
+		rec.Error = null.StringFrom(strings.Join(errs, "; "))
+	}
+
+	_ = opt.Log(rec)
+}
+
+// postAlert posts an alert as JSON to the given webhook URL.
+func (n *Notifs) postAlert(url, typ, subject string, data map[string]any) error {
+	b, err := json.Marshal(alertPayload{
+		Type:      typ,
+		Subject:   subject,
+		Data:      data,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
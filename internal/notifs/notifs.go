@@ -8,9 +8,12 @@ import (
 	"bytes"
 	"html/template"
 	"log"
+	"net/http"
 	"net/textproto"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/models"
@@ -22,6 +25,9 @@ const (
 	TplSubscriberOptin = "subscriber-optin"
 	TplSubscriberData  = "subscriber-data"
 	TplForgotPassword  = "forgot-password"
+	TplBackupStatus    = "backup-status"
+
+	TplAdminNotification = "admin-notification"
 )
 
 type FuncPush func(msg models.Message) error
@@ -32,6 +38,9 @@ type Opt struct {
 	FromEmail    string
 	SystemEmails []string
 	ContentType  string
+
+	// Admin notifications of critical events.
+	Alerts AlertOpt
 }
 
 type Notifs struct {
@@ -39,6 +48,11 @@ type Notifs struct {
 	lo *log.Logger
 
 	opt Opt
+
+	// Time at which each alert (type:key) was last sent.
+	alerts   map[string]time.Time
+	alertMut sync.Mutex
+	http     *http.Client
 }
 
 var (
@@ -56,9 +70,11 @@ func Initialize(opt Opt, tpls *template.Template, em *email.Emailer, lo *log.Log
 
 	Tpls = tpls
 	no = &Notifs{
-		opt: opt,
-		em:  em,
-		lo:  lo,
+		opt:    opt,
+		em:     em,
+		lo:     lo,
+		alerts: make(map[string]time.Time),
+		http:   &http.Client{Timeout: webhookTimeout},
 	}
 }
 
@@ -67,7 +83,10 @@ func NotifySystem(subject, tplName string, data any, hdr textproto.MIMEHeader) e
 	return Notify(no.opt.SystemEmails, subject, tplName, data, hdr)
 }
 
-// Notify sends out an e-mail notification.
+// Notify sends out an e-mail notification. A separate message is sent to each
+// of the e-mails so that recipients don't see each other's addresses and a
+// failure to send to one of them doesn't affect the others. The last error,
+// if any, is returned.
 func Notify(toEmails []string, subject, tplName string, data any, hdr textproto.MIMEHeader) error {
 	if len(toEmails) == 0 {
 		return nil
@@ -82,23 +101,26 @@ func Notify(toEmails []string, subject, tplName string, data any, hdr textproto.
 
 	subject, body = GetTplSubject(subject, body)
 
-	m := models.Message{
-		Messenger:   "email",
-		ContentType: no.opt.ContentType,
-		From:        no.opt.FromEmail,
-		To:          toEmails,
-		Subject:     subject,
-		Body:        body,
-		Headers:     hdr,
-	}
-
-	// Send the message.
-	if err := no.em.Push(m); err != nil {
-		no.lo.Printf("error sending admin notification (%s): %v", subject, err)
-		return err
+	var lastErr error
+	for _, to := range toEmails {
+		m := models.Message{
+			Messenger:   "email",
+			ContentType: no.opt.ContentType,
+			From:        no.opt.FromEmail,
+			To:          []string{to},
+			Subject:     subject,
+			Body:        body,
+			Headers:     hdr,
+		}
+
+		// Send the message.
+		if err := no.em.Push(m); err != nil {
+			no.lo.Printf("error sending admin notification (%s) to %s: %v", subject, to, err)
+			lastErr = err
+		}
 	}
 
-	return nil
+	return lastErr
 }
 
 // GetTplSubject extracts any custom i18n subject rendered in the given rendered
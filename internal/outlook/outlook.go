@@ -0,0 +1,623 @@
+// Package outlook converts the HTML of e-mails to the HTML that Outlook on Windows
+// renders with its Word (MSHTML) engine, which ignores most of modern CSS, for
+// previewing how a message looks in Outlook. <style> rules are inlined, layout
+// <div>s are converted to tables, CSS grid and flexbox are removed, and background
+// images get VML fallbacks.
+package outlook
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// defaultVMLWidth is the width (px) of the VML fallbacks of background images
+// of elements without a width. It's the common width of e-mail layouts.
+const defaultVMLWidth = 600
+
+var (
+	reCSSComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	reSelector   = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9]*|\*)?((?:[.#][a-zA-Z_-][\w-]*)*)$`)
+	reSelPart    = regexp.MustCompile(`[.#][\w-]+`)
+	reURL        = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")]+)['"]?\s*\)`)
+	rePx         = regexp.MustCompile(`^\s*(\d+)(px)?\s*$`)
+	rePercent    = regexp.MustCompile(`^\s*\d+%\s*$`)
+
+	// Flexbox and grid declarations in <style> blocks that are left after inlining.
+	reLayoutCSS = regexp.MustCompile(`(?i)(display\s*:\s*(inline-)?(flex|grid)|(flex(-[a-z]+)?|justify-content|align-items|align-self|align-content|order|gap|(row|column)-gap|grid(-[a-z-]+)?)\s*:)[^;}]*;?`)
+)
+
+// Transform converts an HTML e-mail to Outlook (Word) compatible HTML.
+func Transform(body string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	inlineStyles(doc)
+	convertDivs(doc)
+	stripLayoutCSS(doc)
+	addVML(doc)
+
+	var b bytes.Buffer
+	if err := html.Render(&b, doc); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// decl is a CSS declaration.
+type decl struct {
+	prop string
+	val  string
+}
+
+// parseDecls parses the CSS declarations in a style attribute or a rule block.
+// Semicolons in quotes and parentheses (eg: data: URLs) don't end a declaration.
+func parseDecls(s string) []decl {
+	var (
+		out   []decl
+		depth = 0
+		quote = byte(0)
+		start = 0
+	)
+	add := func(d string) {
+		prop, val, ok := strings.Cut(d, ":")
+		prop = strings.ToLower(strings.TrimSpace(prop))
+		if val = strings.TrimSpace(val); ok && prop != "" && val != "" {
+			out = append(out, decl{prop: prop, val: val})
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ';' && depth == 0:
+			add(s[start:i])
+			start = i + 1
+		}
+	}
+	add(s[start:])
+
+	return out
+}
+
+// mergeDecls merges declarations in the order of precedence. A property that's
+// declared again replaces the earlier declaration.
+func mergeDecls(decls []decl) []decl {
+	out := make([]decl, 0, len(decls))
+	for _, d := range decls {
+		for i, o := range out {
+			if o.prop == d.prop {
+				out = append(out[:i], out[i+1:]...)
+				break
+			}
+		}
+		out = append(out, d)
+	}
+
+	return out
+}
+
+func renderDecls(decls []decl) string {
+	s := make([]string, 0, len(decls))
+	for _, d := range decls {
+		s = append(s, d.prop+": "+d.val)
+	}
+
+	return strings.Join(s, "; ")
+}
+
+// getDecl returns the value of a property in a list of declarations.
+func getDecl(decls []decl, prop string) string {
+	for i := len(decls) - 1; i >= 0; i-- {
+		if decls[i].prop == prop {
+			return decls[i].val
+		}
+	}
+
+	return ""
+}
+
+// selector is a simple CSS selector (eg: td, .btn, p.note, #header) that can be inlined.
+type selector struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+// specificity returns the CSS specificity of a selector as a comparable number.
+func (s selector) specificity() int {
+	n := len(s.classes) * 100
+	if s.id != "" {
+		n += 10000
+	}
+	if s.tag != "" && s.tag != "*" {
+		n++
+	}
+
+	return n
+}
+
+func (s selector) matches(n *html.Node) bool {
+	if s.tag != "" && s.tag != "*" && s.tag != n.Data {
+		return false
+	}
+	if s.id != "" && getAttr(n, "id") != s.id {
+		return false
+	}
+
+	classes := strings.Fields(getAttr(n, "class"))
+	for _, c := range s.classes {
+		found := false
+		for _, o := range classes {
+			if o == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseSelectors parses a comma separated group of selectors. It returns false
+// if any of the selectors isn't a simple selector that can be inlined, eg: one
+// with combinators or pseudo classes.
+func parseSelectors(s string) ([]selector, bool) {
+	var out []selector
+	for _, p := range strings.Split(s, ",") {
+		m := reSelector.FindStringSubmatch(strings.TrimSpace(p))
+		if m == nil || (m[1] == "" && m[2] == "") {
+			return nil, false
+		}
+
+		sel := selector{tag: strings.ToLower(m[1])}
+		for _, part := range reSelPart.FindAllString(m[2], -1) {
+			if part[0] == '#' {
+				sel.id = part[1:]
+			} else {
+				sel.classes = append(sel.classes, part[1:])
+			}
+		}
+		out = append(out, sel)
+	}
+
+	return out, true
+}
+
+// rule is a CSS rule with a simple selector that can be inlined.
+type rule struct {
+	sel   selector
+	decls []decl
+	order int
+}
+
+// parseStylesheet parses the rules with simple selectors in a stylesheet that can
+// be inlined. The rest of the stylesheet (at-rules such as @media queries and rules
+// with complex selectors) is returned as is.
+func parseStylesheet(css string, order int) ([]rule, string) {
+	var (
+		rules []rule
+		rest  strings.Builder
+	)
+
+	css = reCSSComment.ReplaceAllString(css, "")
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			break
+		}
+
+		// Statement at-rules without blocks, eg: @import.
+		open := strings.IndexByte(css, '{')
+		if semi := strings.IndexByte(css, ';'); css[0] == '@' && semi >= 0 && (open < 0 || semi < open) {
+			rest.WriteString(css[:semi+1] + "\n")
+			css = css[semi+1:]
+			continue
+		}
+		if open < 0 {
+			break
+		}
+
+		// Find the end of the block. An unterminated block runs till the end.
+		end, depth := len(css), 0
+		for i := open; i < len(css); i++ {
+			if css[i] == '{' {
+				depth++
+			} else if css[i] == '}' {
+				if depth--; depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+
+		var (
+			pre   = strings.TrimSpace(css[:open])
+			block = css[open+1 : end]
+			full  = css[:min(end+1, len(css))]
+		)
+		css = css[min(end+1, len(css)):]
+
+		sels, ok := parseSelectors(pre)
+		if strings.HasPrefix(pre, "@") || !ok {
+			rest.WriteString(full + "\n")
+			continue
+		}
+
+		decls := parseDecls(block)
+		for _, s := range sels {
+			rules = append(rules, rule{sel: s, decls: decls, order: order})
+			order++
+		}
+	}
+
+	return rules, rest.String()
+}
+
+// inlineStyles moves the rules with simple selectors in <style> blocks to the
+// style attributes of the elements they match. The declarations are applied in the
+// order of their specificity, followed by the element's existing inline style.
+func inlineStyles(doc *html.Node) {
+	var rules []rule
+	for _, n := range findAll(doc, atom.Style) {
+		// Styles for specific media (eg: print) don't apply to every element.
+		if m := strings.TrimSpace(getAttr(n, "media")); m != "" && m != "all" && m != "screen" {
+			continue
+		}
+
+		var css strings.Builder
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				css.WriteString(c.Data)
+			}
+		}
+
+		r, rest := parseStylesheet(css.String(), len(rules))
+		rules = append(rules, r...)
+
+		// Remove the style block if everything in it was inlined.
+		if strings.TrimSpace(rest) == "" {
+			n.Parent.RemoveChild(n)
+			continue
+		}
+		for n.FirstChild != nil {
+			n.RemoveChild(n.FirstChild)
+		}
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: rest})
+	}
+	body := findAll(doc, atom.Body)
+	if len(rules) == 0 || len(body) == 0 {
+		return
+	}
+
+	walk(body[0], func(n *html.Node) {
+		var matched []rule
+		for _, r := range rules {
+			if r.sel.matches(n) {
+				matched = append(matched, r)
+			}
+		}
+		if len(matched) == 0 {
+			return
+		}
+
+		// Sort by specificity and then by the order in the stylesheet (insertion sort as
+		// the matches are few and already in the order of the stylesheet).
+		for i := 1; i < len(matched); i++ {
+			for j := i; j > 0 && matched[j-1].sel.specificity() > matched[j].sel.specificity(); j-- {
+				matched[j-1], matched[j] = matched[j], matched[j-1]
+			}
+		}
+
+		var decls []decl
+		for _, r := range matched {
+			decls = append(decls, r.decls...)
+		}
+		decls = append(decls, parseDecls(getAttr(n, "style"))...)
+		if len(decls) > 0 {
+			setAttr(n, "style", renderDecls(mergeDecls(decls)))
+		}
+	})
+}
+
+// convertDivs converts <div>s to single cell tables that Outlook lays out reliably.
+// The children of flexbox and grid rows are laid out as the cells of a table row.
+func convertDivs(doc *html.Node) {
+	for _, n := range findAll(doc, atom.Div) {
+		var (
+			decls   = parseDecls(getAttr(n, "style"))
+			display = strings.ToLower(getDecl(decls, "display"))
+			dir     = strings.ToLower(getDecl(decls, "flex-direction"))
+			isRow   = (strings.HasSuffix(display, "flex") && !strings.HasPrefix(dir, "column")) || strings.HasSuffix(display, "grid")
+		)
+
+		table := newElement(atom.Table, "role", "presentation", "border", "0", "cellpadding", "0", "cellspacing", "0")
+
+		// Outlook ignores max-width. Fixed widths are set on the table instead.
+		width := "100%"
+		if w := getDecl(decls, "max-width"); rePx.MatchString(w) {
+			width = rePx.FindStringSubmatch(w)[1]
+		} else if w := getDecl(decls, "width"); rePx.MatchString(w) || rePercent.MatchString(w) {
+			width = strings.TrimSuffix(strings.TrimSpace(w), "px")
+		}
+		setAttr(table, "width", width)
+		if m := getDecl(decls, "margin"); strings.Contains(m, "auto") || getDecl(decls, "margin-left") == "auto" {
+			setAttr(table, "align", "center")
+		}
+
+		// The div's styles and attributes, except the ones that are on the table, move to the cell.
+		var cellDecls []decl
+		for _, d := range decls {
+			switch d.prop {
+			case "width", "max-width", "margin", "margin-left", "margin-right":
+			default:
+				cellDecls = append(cellDecls, d)
+			}
+		}
+
+		tr := newElement(atom.Tr)
+		table.AppendChild(tr)
+
+		// In rows, the styles stay on the table as every child is a cell of its own.
+		td := newElement(atom.Td)
+		box := td
+		if isRow {
+			box = table
+		} else {
+			tr.AppendChild(td)
+		}
+		for _, a := range n.Attr {
+			if a.Key != "style" {
+				box.Attr = append(box.Attr, a)
+			}
+		}
+		if len(cellDecls) > 0 {
+			setAttr(box, "style", renderDecls(cellDecls))
+		}
+
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			n.RemoveChild(c)
+
+			// Every child of a row is a cell of its own. Whitespace between the children is dropped.
+			if !isRow {
+				td.AppendChild(c)
+			} else if !(c.Type == html.TextNode && strings.TrimSpace(c.Data) == "") && c.Type != html.CommentNode {
+				// The width of the child moves to its cell.
+				cell := newElement(atom.Td, "valign", "top")
+				if c.Type == html.ElementNode {
+					var (
+						cd = parseDecls(getAttr(c, "style"))
+						w  = getDecl(cd, "width")
+					)
+					if rePx.MatchString(w) || rePercent.MatchString(w) {
+						setAttr(cell, "width", strings.TrimSuffix(strings.TrimSpace(w), "px"))
+
+						var rest []decl
+						for _, d := range cd {
+							if d.prop != "width" {
+								rest = append(rest, d)
+							}
+						}
+						if len(rest) > 0 {
+							setAttr(c, "style", renderDecls(rest))
+						} else {
+							removeAttr(c, "style")
+						}
+					}
+				}
+				cell.AppendChild(c)
+				tr.AppendChild(cell)
+			}
+
+			c = next
+		}
+
+		n.Parent.InsertBefore(table, n)
+		n.Parent.RemoveChild(n)
+	}
+}
+
+// isLayoutDecl returns true if a declaration is a flexbox or grid declaration
+// that Outlook doesn't support.
+func isLayoutDecl(d decl) bool {
+	switch d.prop {
+	case "display":
+		v := strings.ToLower(d.val)
+		return strings.HasSuffix(v, "flex") || strings.HasSuffix(v, "grid")
+	case "justify-content", "align-items", "align-self", "align-content", "order", "gap", "row-gap", "column-gap":
+		return true
+	}
+
+	return d.prop == "flex" || strings.HasPrefix(d.prop, "flex-") || strings.HasPrefix(d.prop, "grid")
+}
+
+// stripLayoutCSS removes the flexbox and grid declarations from inline styles
+// and the remaining <style> blocks.
+func stripLayoutCSS(doc *html.Node) {
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom == atom.Style {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.TextNode {
+					c.Data = reLayoutCSS.ReplaceAllString(c.Data, "")
+				}
+			}
+			return
+		}
+
+		style := getAttr(n, "style")
+		if style == "" {
+			return
+		}
+
+		var decls []decl
+		for _, d := range parseDecls(style) {
+			if !isLayoutDecl(d) {
+				decls = append(decls, d)
+			}
+		}
+		if len(decls) == 0 {
+			removeAttr(n, "style")
+		} else {
+			setAttr(n, "style", renderDecls(decls))
+		}
+	})
+}
+
+// addVML adds the VML fallbacks that Outlook needs to render the background
+// images of table cells, along with the Office namespaces and settings.
+func addVML(doc *html.Node) {
+	hasVML := false
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom != atom.Td && n.DataAtom != atom.Th {
+			return
+		}
+
+		var (
+			decls = parseDecls(getAttr(n, "style"))
+			src   = getAttr(n, "background")
+		)
+		if m := reURL.FindStringSubmatch(getDecl(decls, "background-image") + " " + getDecl(decls, "background")); m != nil {
+			src = m[1]
+		}
+		if src == "" {
+			return
+		}
+		if getAttr(n, "background") == "" {
+			setAttr(n, "background", src)
+		}
+
+		// The width of the cell, or else, of its table.
+		width := defaultVMLWidth
+		if m := rePx.FindStringSubmatch(getAttr(n, "width")); m != nil {
+			width, _ = strconv.Atoi(m[1])
+		} else if m := rePx.FindStringSubmatch(getDecl(decls, "width")); m != nil {
+			width, _ = strconv.Atoi(m[1])
+		} else if t := closest(n, atom.Table); t != nil {
+			if m := rePx.FindStringSubmatch(getAttr(t, "width")); m != nil {
+				width, _ = strconv.Atoi(m[1])
+			}
+		}
+
+		color := getDecl(decls, "background-color")
+		if color == "" {
+			color = getAttr(n, "bgcolor")
+		}
+		fill := fmt.Sprintf(`<v:fill type="tile" src="%s"`, html.EscapeString(src))
+		if color != "" {
+			fill += fmt.Sprintf(` color="%s"`, html.EscapeString(color))
+		}
+
+		n.InsertBefore(&html.Node{Type: html.CommentNode,
+			Data: fmt.Sprintf(`[if gte mso 9]><v:rect xmlns:v="urn:schemas-microsoft-com:vml" fill="true" stroke="false" style="width:%dpx;">%s /><v:textbox inset="0,0,0,0"><![endif]`, width, fill),
+		}, n.FirstChild)
+		n.AppendChild(&html.Node{Type: html.CommentNode, Data: `[if gte mso 9]></v:textbox></v:rect><![endif]`})
+		hasVML = true
+	})
+	if !hasVML {
+		return
+	}
+
+	// Declare the Office namespaces and settings that VML needs.
+	if h := findAll(doc, atom.Html); len(h) > 0 {
+		setAttr(h[0], "xmlns:v", "urn:schemas-microsoft-com:vml")
+		setAttr(h[0], "xmlns:o", "urn:schemas-microsoft-com:office:office")
+	}
+	if h := findAll(doc, atom.Head); len(h) > 0 {
+		h[0].AppendChild(&html.Node{Type: html.CommentNode,
+			Data: `[if gte mso 9]><xml><o:OfficeDocumentSettings><o:AllowPNG/><o:PixelsPerInch>96</o:PixelsPerInch></o:OfficeDocumentSettings></xml><![endif]`,
+		})
+	}
+}
+
+// walk calls fn on every element in the tree in document order.
+func walk(n *html.Node, fn func(*html.Node)) {
+	if n.Type == html.ElementNode {
+		fn(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+// findAll returns all the elements of a type in the tree in document order.
+func findAll(doc *html.Node, a atom.Atom) []*html.Node {
+	var out []*html.Node
+	walk(doc, func(n *html.Node) {
+		if n.DataAtom == a {
+			out = append(out, n)
+		}
+	})
+
+	return out
+}
+
+// closest returns the closest ancestor of an element of a type.
+func closest(n *html.Node, a atom.Atom) *html.Node {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == a {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// newElement returns an element with the given attribute key-value pairs.
+func newElement(a atom.Atom, attrs ...string) *html.Node {
+	n := &html.Node{Type: html.ElementNode, Data: a.String(), DataAtom: a}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		n.Attr = append(n.Attr, html.Attribute{Key: attrs[i], Val: attrs[i+1]})
+	}
+
+	return n
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttr(n *html.Node, key string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
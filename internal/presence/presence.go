@@ -0,0 +1,96 @@
+// Package presence keeps track of the users who are currently editing resources,
+// eg: campaigns and templates, so that others opening them can be warned before
+// making conflicting changes. Editors "touch" a resource periodically while they
+// have it open and are dropped once they stop. It's advisory and in-memory, that
+// is, it's lost on restarts and isn't shared between instances.
+package presence
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Presence tracks the editors of resources by arbitrary keys, eg: campaign:1.
+type Presence struct {
+	ttl time.Duration
+
+	// key => user ID => editor.
+	editors map[string]map[int]models.Editor
+	mu      sync.Mutex
+}
+
+// New returns a new Presence where editors are dropped if they haven't
+// touched a resource for the given duration.
+func New(ttl time.Duration) *Presence {
+	return &Presence{
+		ttl:     ttl,
+		editors: make(map[string]map[int]models.Editor),
+	}
+}
+
+// Touch records that a user is editing the resource with the given key.
+func (p *Presence) Touch(key string, userID int, username string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prune(time.Now())
+
+	eds, ok := p.editors[key]
+	if !ok {
+		eds = make(map[int]models.Editor)
+		p.editors[key] = eds
+	}
+	eds[userID] = models.Editor{UserID: userID, Username: username, TouchedAt: time.Now()}
+}
+
+// Release records that a user has stopped editing the resource with the given key.
+func (p *Presence) Release(key string, userID int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if eds, ok := p.editors[key]; ok {
+		delete(eds, userID)
+		if len(eds) == 0 {
+			delete(p.editors, key)
+		}
+	}
+}
+
+// Get returns the users other than the given one who are currently
+// editing the resource with the given key, latest first.
+func (p *Presence) Get(key string, exceptUserID int) []models.Editor {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prune(time.Now())
+
+	out := []models.Editor{}
+	for id, e := range p.editors[key] {
+		if id != exceptUserID {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].TouchedAt.After(out[j].TouchedAt)
+	})
+
+	return out
+}
+
+// prune drops the editors who haven't touched their resources within the TTL.
+// The lock should be held.
+func (p *Presence) prune(now time.Time) {
+	for key, eds := range p.editors {
+		for id, e := range eds {
+			if now.Sub(e.TouchedAt) > p.ttl {
+				delete(eds, id)
+			}
+		}
+		if len(eds) == 0 {
+			delete(p.editors, key)
+		}
+	}
+}
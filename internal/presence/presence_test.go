@@ -0,0 +1,71 @@
+package presence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPresence(t *testing.T) {
+	p := New(time.Minute)
+	p.Touch("campaign:1", 1, "alice")
+	p.Touch("campaign:1", 2, "bob")
+	p.Touch("campaign:2", 3, "carol")
+
+	// alice opened the campaign before bob.
+	e := p.editors["campaign:1"][1]
+	e.TouchedAt = e.TouchedAt.Add(-time.Second)
+	p.editors["campaign:1"][1] = e
+
+	cases := []struct {
+		name   string
+		key    string
+		userID int
+		want   []string
+	}{
+		{"other editors", "campaign:1", 1, []string{"bob"}},
+		{"all editors", "campaign:1", 0, []string{"bob", "alice"}},
+		{"only editor", "campaign:2", 3, []string{}},
+		{"unknown resource", "campaign:3", 1, []string{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := p.Get(tc.key, tc.userID)
+			if len(got) != len(tc.want) {
+				t.Fatalf("expected %d editors, got %v", len(tc.want), got)
+			}
+			for i, e := range got {
+				if e.Username != tc.want[i] {
+					t.Errorf("expected editor %q at %d, got %q", tc.want[i], i, e.Username)
+				}
+			}
+		})
+	}
+}
+
+func TestPresenceRelease(t *testing.T) {
+	p := New(time.Minute)
+	p.Touch("template:1", 1, "alice")
+	p.Touch("template:1", 2, "bob")
+
+	p.Release("template:1", 2)
+	if got := p.Get("template:1", 0); len(got) != 1 || got[0].UserID != 1 {
+		t.Fatalf("expected only alice to be editing, got %v", got)
+	}
+
+	p.Release("template:1", 1)
+	if _, ok := p.editors["template:1"]; ok {
+		t.Error("expected the resource without editors to be dropped")
+	}
+}
+
+func TestPresenceExpiry(t *testing.T) {
+	p := New(time.Minute)
+	p.Touch("campaign:1", 1, "alice")
+
+	// Editors who haven't touched the resource within the TTL are dropped.
+	p.prune(time.Now().Add(2 * time.Minute))
+	if got := p.Get("campaign:1", 0); len(got) != 0 {
+		t.Fatalf("expected the expired editor to be dropped, got %v", got)
+	}
+}
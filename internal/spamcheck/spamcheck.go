@@ -0,0 +1,250 @@
+// Package spamcheck scores the subject and HTML content of campaigns with a
+// few content heuristics commonly used by spam filters: shouting, excessive
+// punctuation, spammy phrases, and image heavy messages with little text.
+// Like SpamAssassin, every rule that matches adds to the score, and content
+// that scores Threshold or more is likely to be marked as spam.
+package spamcheck
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Threshold is the score at or above which content is considered spammy.
+const Threshold = 5.0
+
+// Rules that are checked.
+const (
+	RuleSubjectCaps    = "subject_caps"
+	RuleSubjectExclaim = "subject_exclaim"
+	RuleSubjectPhrases = "subject_phrases"
+	RuleBodyPhrases    = "body_phrases"
+	RuleBodyCaps       = "body_caps"
+	RuleBodyExclaim    = "body_exclaim"
+	RuleImageRatio     = "image_ratio"
+	RuleNoText         = "no_text"
+	RuleMoneySigns     = "money_signs"
+	RuleHiddenText     = "hidden_text"
+	RuleURLShorteners  = "url_shorteners"
+)
+
+const (
+	// Min. number of letters in a text for its ratio of capitals to be considered.
+	minCapsLetters = 10
+
+	// Min. number of words of text expected per image.
+	minWordsPerImage = 50
+
+	// maxPhrasesScore is the max score added by the spam phrases in a subject or a body.
+	maxPhrasesScore = 3.0
+)
+
+// phrases are common spam trigger phrases. Each occurrence adds to the score.
+var phrases = []string{
+	"100% free",
+	"act now",
+	"apply now",
+	"as seen on",
+	"buy direct",
+	"cash bonus",
+	"click below",
+	"click here",
+	"congratulations",
+	"dear friend",
+	"double your",
+	"earn extra cash",
+	"eliminate debt",
+	"extra income",
+	"fast cash",
+	"free access",
+	"free gift",
+	"free money",
+	"guaranteed",
+	"increase sales",
+	"limited time",
+	"lowest price",
+	"make money",
+	"million dollars",
+	"no cost",
+	"no credit check",
+	"no obligation",
+	"once in a lifetime",
+	"order now",
+	"risk-free",
+	"special promotion",
+	"this is not spam",
+	"urgent",
+	"winner",
+	"you have been selected",
+}
+
+var (
+	reExclaim    = regexp.MustCompile(`!{2,}`)
+	reMoney      = regexp.MustCompile(`\${2,}|€{2,}|£{2,}`)
+	reShorteners = regexp.MustCompile(`(?i)https?://(bit\.ly|tinyurl\.com|goo\.gl|t\.co|ow\.ly|is\.gd|buff\.ly|cutt\.ly)/`)
+	reHidden     = regexp.MustCompile(`(?i)display\s*:\s*none|font-size\s*:\s*[01](px|pt)?\s*(;|$)|visibility\s*:\s*hidden`)
+	reSpaces     = regexp.MustCompile(`\s+`)
+)
+
+// Rule is a rule that matched the content along with the score it added.
+type Rule struct {
+	Rule  string  `json:"rule"`
+	Score float64 `json:"score"`
+}
+
+// Result is the result of a spam check.
+type Result struct {
+	Score float64 `json:"score"`
+	Spam  bool    `json:"spam"`
+	Rules []Rule  `json:"rules"`
+}
+
+// Check scores the subject and the HTML body of a message.
+func Check(subject, body string) Result {
+	var (
+		out  = Result{Rules: []Rule{}}
+		text = extractText(body)
+	)
+
+	add := func(rule string, score float64) {
+		if score <= 0 {
+			return
+		}
+		out.Rules = append(out.Rules, Rule{Rule: rule, Score: score})
+		out.Score += score
+	}
+
+	// Subject.
+	if capsRatio(subject) > 0.5 {
+		add(RuleSubjectCaps, 1.5)
+	}
+	if reExclaim.MatchString(subject) || strings.Count(subject, "!") > 1 {
+		add(RuleSubjectExclaim, 1)
+	}
+	add(RuleSubjectPhrases, math.Min(float64(countPhrases(subject)), maxPhrasesScore))
+
+	// Body.
+	add(RuleBodyPhrases, math.Min(float64(countPhrases(text.text))*0.5, maxPhrasesScore))
+	if capsRatio(text.text) > 0.3 {
+		add(RuleBodyCaps, 1.5)
+	}
+	if n := len(reExclaim.FindAllString(text.text, -1)); n > 0 {
+		add(RuleBodyExclaim, math.Min(float64(n)*0.5, 2))
+	}
+	if reMoney.MatchString(subject + " " + text.text) {
+		add(RuleMoneySigns, 1)
+	}
+
+	words := len(strings.Fields(text.text))
+	if words == 0 {
+		add(RuleNoText, 2.5)
+	} else if text.images > 0 && words/text.images < minWordsPerImage {
+		add(RuleImageRatio, 1.5)
+	}
+
+	if text.hidden {
+		add(RuleHiddenText, 2)
+	}
+	if reShorteners.MatchString(body) {
+		add(RuleURLShorteners, 1)
+	}
+
+	out.Score = math.Round(out.Score*10) / 10
+	out.Spam = out.Score >= Threshold
+
+	return out
+}
+
+// content is the text and the elements of interest in an HTML body.
+type content struct {
+	text   string
+	images int
+	hidden bool
+}
+
+// extractText extracts the visible text, and counts the images in an HTML body.
+func extractText(body string) content {
+	var (
+		out   content
+		sb    strings.Builder
+		skip  = 0
+		token = html.NewTokenizer(strings.NewReader(body))
+	)
+
+	for {
+		switch token.Next() {
+		case html.ErrorToken:
+			out.text = strings.TrimSpace(reSpaces.ReplaceAllString(sb.String(), " "))
+			return out
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := token.Token()
+			switch t.DataAtom {
+			case atom.Script, atom.Style, atom.Head:
+				if t.Type == html.StartTagToken {
+					skip++
+				}
+			case atom.Img:
+				out.images++
+			}
+
+			for _, a := range t.Attr {
+				if a.Key == "style" && reHidden.MatchString(a.Val) {
+					out.hidden = true
+				}
+			}
+
+		case html.EndTagToken:
+			t := token.Token()
+			switch t.DataAtom {
+			case atom.Script, atom.Style, atom.Head:
+				if skip > 0 {
+					skip--
+				}
+			}
+
+		case html.TextToken:
+			if skip == 0 {
+				sb.Write(token.Text())
+				sb.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// capsRatio returns the ratio of upper case letters to all letters in a text.
+func capsRatio(s string) float64 {
+	var letters, upper int
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+
+	if letters < minCapsLetters {
+		return 0
+	}
+
+	return float64(upper) / float64(letters)
+}
+
+// countPhrases returns the number of occurrences of spam phrases in a text.
+func countPhrases(s string) int {
+	s = strings.ToLower(s)
+
+	n := 0
+	for _, p := range phrases {
+		n += strings.Count(s, p)
+	}
+
+	return n
+}
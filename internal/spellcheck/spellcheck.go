@@ -0,0 +1,229 @@
+// Package spellcheck provides a simple dictionary based spell checker for
+// campaign content. Words that are not found in a language's dictionary
+// (or in any of the custom dictionaries) are reported along with suggested
+// corrections.
+package spellcheck
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sajari/fuzzy"
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxSuggestions is the max number of corrections suggested for a word.
+	maxSuggestions = 5
+
+	// minWordLen is the minimum length of a word to be checked.
+	minWordLen = 3
+)
+
+var (
+	reTplExp = regexp.MustCompile(`(?s){{.*?}}`)
+	reURL    = regexp.MustCompile(`(?i)(https?://|www\.)\S+|\S+@\S+\.\S+`)
+	reWord   = regexp.MustCompile(`\p{L}+`)
+)
+
+// Result represents a misspelled word and its suggested corrections.
+type Result struct {
+	Word        string   `json:"word"`
+	Count       int      `json:"count"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// dict is the dictionary of a single language.
+type dict struct {
+	words map[string]struct{}
+	model *fuzzy.Model
+}
+
+// Checker holds language dictionaries and custom word dictionaries
+// that are applicable to all languages.
+type Checker struct {
+	langs  map[string]*dict
+	custom map[string]struct{}
+
+	mu sync.RWMutex
+}
+
+// New returns a new instance of Checker.
+func New() *Checker {
+	return &Checker{
+		langs:  make(map[string]*dict),
+		custom: make(map[string]struct{}),
+	}
+}
+
+// LoadLang loads the dictionary for a language from a reader with one word
+// per line, optionally followed by a space and its frequency which is used to
+// rank suggestions.
+func (c *Checker) LoadLang(lang string, r io.Reader) error {
+	m := fuzzy.NewModel()
+	m.SetThreshold(0)
+	m.SetDepth(1)
+	m.SetUseAutocomplete(false)
+
+	d := &dict{words: make(map[string]struct{}), model: m}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var (
+			word, freq, _ = strings.Cut(strings.TrimSpace(sc.Text()), " ")
+			count         = 1
+		)
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		if n, err := strconv.Atoi(freq); err == nil && n > 0 {
+			count = n
+		}
+
+		word = strings.ToLower(word)
+		d.words[word] = struct{}{}
+		m.SetCount(word, count, true)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.langs[lang] = d
+	c.mu.Unlock()
+
+	return nil
+}
+
+// SetCustomWords replaces the custom dictionary words that are
+// considered valid in all languages.
+func (c *Checker) SetCustomWords(words []string) {
+	custom := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			custom[w] = struct{}{}
+		}
+	}
+
+	c.mu.Lock()
+	c.custom = custom
+	c.mu.Unlock()
+}
+
+// HasLang checks if a dictionary for the given language is loaded.
+func (c *Checker) HasLang(lang string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.langs[lang]
+	return ok
+}
+
+// Check extracts text from the given HTML body and returns the list of unknown
+// words in the given language sorted by their number of occurrences.
+func (c *Checker) Check(lang, body string) []Result {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	d, ok := c.langs[lang]
+	if !ok {
+		return nil
+	}
+
+	var (
+		out  = []Result{}
+		seen = make(map[string]int)
+	)
+	for _, w := range reWord.FindAllString(ExtractText(body), -1) {
+		if len([]rune(w)) < minWordLen || isAcronym(w) {
+			continue
+		}
+
+		lw := strings.ToLower(w)
+		if idx, ok := seen[lw]; ok {
+			if idx >= 0 {
+				out[idx].Count++
+			}
+			continue
+		}
+
+		if c.isKnown(d, lw) {
+			seen[lw] = -1
+			continue
+		}
+
+		seen[lw] = len(out)
+		out = append(out, Result{
+			Word:        w,
+			Count:       1,
+			Suggestions: d.model.SpellCheckSuggestions(lw, maxSuggestions),
+		})
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Count > out[j].Count
+	})
+
+	return out
+}
+
+func (c *Checker) isKnown(d *dict, w string) bool {
+	if _, ok := d.words[w]; ok {
+		return true
+	}
+	_, ok := c.custom[w]
+	return ok
+}
+
+// ExtractText returns the text content from an HTML body excluding
+// tags, scripts, styles, template expressions, URLs, and e-mail addresses.
+func ExtractText(body string) string {
+	body = reTplExp.ReplaceAllString(body, " ")
+
+	var (
+		buf  bytes.Buffer
+		skip = 0
+		z    = html.NewTokenizer(strings.NewReader(body))
+	)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return reURL.ReplaceAllString(buf.String(), " ")
+
+		case html.StartTagToken:
+			if name, _ := z.TagName(); isSkipTag(name) {
+				skip++
+			}
+
+		case html.EndTagToken:
+			if name, _ := z.TagName(); isSkipTag(name) && skip > 0 {
+				skip--
+			}
+
+		case html.TextToken:
+			if skip == 0 {
+				buf.Write(z.Text())
+				buf.WriteByte(' ')
+			}
+		}
+	}
+}
+
+func isSkipTag(name []byte) bool {
+	switch string(name) {
+	case "script", "style", "code", "pre":
+		return true
+	}
+	return false
+}
+
+// isAcronym checks if a word is all upper case (eg: HTML, API).
+func isAcronym(w string) bool {
+	return strings.ToUpper(w) == w
+}
@@ -0,0 +1,180 @@
+package subimporter
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	sheetsValuesURL = "https://sheets.googleapis.com/v4/spreadsheets/%s/values/%s"
+	sheetsScope     = "https://www.googleapis.com/auth/spreadsheets.readonly"
+	sheetsTokenURL  = "https://oauth2.googleapis.com/token"
+	sheetsTimeout   = time.Second * 30
+
+	// Max size of a Google Sheets API response.
+	sheetsMaxBytes = 100 << 20
+)
+
+// reSheetID matches the ID of a spreadsheet in its URL,
+// eg: https://docs.google.com/spreadsheets/d/{id}/edit#gid=0
+var reSheetID = regexp.MustCompile(`/spreadsheets/d/([a-zA-Z0-9_-]+)`)
+
+// GoogleSheets fetches spreadsheets from the Google Sheets API v4 as a
+// Google Cloud service account. Spreadsheets have to be shared with the
+// service account's e-mail.
+type GoogleSheets struct {
+	client *http.Client
+}
+
+// serviceAccount is the JSON key of a Google Cloud service account.
+type serviceAccount struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// NewGoogleSheets returns a Google Sheets client that authenticates with the
+// given service account JSON key.
+func NewGoogleSheets(credentials []byte) (*GoogleSheets, error) {
+	var sa serviceAccount
+	if err := json.Unmarshal(credentials, &sa); err != nil {
+		return nil, fmt.Errorf("invalid service account key: %v", err)
+	}
+	if sa.Type != "service_account" || sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return nil, errors.New("invalid service account key: not a service account key")
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = sheetsTokenURL
+	}
+
+	cfg := &jwt.Config{
+		Email:        sa.ClientEmail,
+		PrivateKey:   []byte(sa.PrivateKey),
+		PrivateKeyID: sa.PrivateKeyID,
+		Scopes:       []string{sheetsScope},
+		TokenURL:     sa.TokenURI,
+	}
+
+	c := cfg.Client(context.Background())
+	c.Timeout = sheetsTimeout
+
+	return &GoogleSheets{client: c}, nil
+}
+
+// ParseSheetID returns the ID of a spreadsheet from its URL. A bare ID is
+// returned as is.
+func ParseSheetID(u string) (string, error) {
+	u = strings.TrimSpace(u)
+	if m := reSheetID.FindStringSubmatch(u); m != nil {
+		return m[1], nil
+	}
+	if u != "" && !strings.ContainsAny(u, "/:?#") {
+		return u, nil
+	}
+
+	return "", errors.New("invalid spreadsheet URL")
+}
+
+// Fetch returns the rows in a range (eg: Sheet1!A1:D1000) of a spreadsheet.
+// Rows are padded to the width of the first row (the header) as the API omits
+// trailing empty cells.
+func (g *GoogleSheets) Fetch(sheetID, rng string) ([][]string, error) {
+	u := fmt.Sprintf(sheetsValuesURL, url.PathEscape(sheetID), url.PathEscape(rng))
+	resp, err := g.client.Get(u + "?majorDimension=ROWS&valueRenderOption=FORMATTED_VALUE")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, sheetsMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Error.Message != "" {
+			return nil, fmt.Errorf("google sheets: %s", e.Error.Message)
+		}
+		return nil, fmt.Errorf("google sheets: %s", resp.Status)
+	}
+
+	var out struct {
+		Values [][]any `json:"values"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("google sheets: invalid response: %v", err)
+	}
+	if len(out.Values) == 0 {
+		return nil, errors.New("empty sheet")
+	}
+
+	var (
+		rows  = make([][]string, 0, len(out.Values))
+		width = len(out.Values[0])
+	)
+	for _, v := range out.Values {
+		row := make([]string, max(width, len(v)))
+		for i, c := range v {
+			row[i] = fmt.Sprintf("%v", c)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// PreviewRows auto-detects the mapping of the columns in the header (first row)
+// of rows and returns it with the first numRows rows after the header.
+func PreviewRows(rows [][]string, listsCol string, numRows int) Preview {
+	out := Preview{Rows: [][]string{}}
+	if len(rows) == 0 {
+		return out
+	}
+
+	out.Mapping = DetectMapping(rows[0], listsCol)
+	for _, r := range rows[1:] {
+		if len(out.Rows) >= numRows {
+			break
+		}
+		out.Rows = append(out.Rows, r)
+	}
+
+	return out
+}
+
+// WriteCSV writes rows to a new temporary CSV file (comma delimited) that can be
+// loaded into an import session with LoadCSV, and returns its path.
+func WriteCSV(rows [][]string) (string, error) {
+	f, err := os.CreateTemp("", "listmonk")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(rows); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
@@ -16,24 +16,32 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/utils"
 	"github.com/knadh/listmonk/models"
 	"github.com/lib/pq"
+	"golang.org/x/net/idna"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
 const (
-	// commitBatchSize is the number of inserts to commit in a single SQL transaction.
-	commitBatchSize = 10000
+	// defaultBatchSize is the default number of inserts to commit in a single SQL transaction.
+	defaultBatchSize = 1000
+
+	// maxDryRunErrors is the number of invalid rows that are listed in a dry run report.
+	maxDryRunErrors = 1000
 )
 
 // Various import statuses.
@@ -62,8 +70,9 @@ type Importer struct {
 	hasAllowlistWildcards bool
 	hasAllowlist          bool
 
-	stop   chan bool
-	status Status
+	stop    chan bool
+	status  Status
+	errFile *errorFile
 	sync.RWMutex
 }
 
@@ -74,8 +83,41 @@ type Options struct {
 	UpdateListDateStmt *sql.Stmt
 	PostCB             func(subject string, data any) error
 
+	// EncryptAttribsCB encrypts the attributes of a subscriber (by e-mail) that's in,
+	// or is being imported into one of the given lists if any of them is sensitive.
+	EncryptAttribsCB func(email string, listIDs []int, attribs models.JSON) (models.JSON, error)
+
+	// ArchiveCB, if set, archives the original CSV file (by its name and path)
+	// of a successful import and returns the ID of the archive.
+	ArchiveCB func(filename, path string) (int, error)
+
+	// SubscriberEventCB, if set, is called with the subscribers (ID and e-mail) that
+	// were created or blocklisted (models.SubscriberEvent*) after each committed batch.
+	SubscriberEventCB func(typ string, subs []models.Subscriber)
+
+	// BatchSize is the number of rows to commit in a single SQL transaction.
+	BatchSize int
+
+	// Timeout is the statement timeout of the import transactions.
+	Timeout time.Duration
+
+	// ErrorFileMaxSize is the maximum size in bytes of the CSV file of rows that
+	// failed to import. Rows beyond it are not recorded. 0 disables the file.
+	ErrorFileMaxSize int64
+
 	DomainBlocklist []string
 	DomainAllowlist []string
+
+	// EmailDenylistPatterns and EmailAllowlistPatterns are regexp patterns that
+	// e-mail addresses are matched against. An address that matches a denylist
+	// pattern is rejected. If there are allowlist patterns, an address has to
+	// match one of them.
+	EmailDenylistPatterns  []*regexp.Regexp
+	EmailAllowlistPatterns []*regexp.Regexp
+
+	// StrictASCIIEmail rejects internationalized (EAI) e-mail addresses and
+	// stores IDN domains in their ASCII (punycode) form.
+	StrictASCIIEmail bool
 }
 
 // Session represents a single import session.
@@ -83,8 +125,25 @@ type Session struct {
 	im       *Importer
 	subQueue chan SubReq
 	log      *log.Logger
+	errFile  *errorFile
 
 	opt SessionOpt
+
+	// Lists that can be referenced in the lists column,
+	// mapped by ID and by their lowercased names.
+	listIDs   map[int]struct{}
+	listNames map[string][]int
+
+	// dryRun sessions only validate rows and don't touch the importer's state.
+	dryRun bool
+
+	// Columns mapped to the name and attributes with a column mapping.
+	fields []field
+
+	// Path of the CSV file being imported, and whether the import was stopped
+	// before reading all of it.
+	srcPath string
+	stopped bool
 }
 
 // SessionOpt represents the options for an importer session.
@@ -97,15 +156,53 @@ type SessionOpt struct {
 	OverwriteSubStatus bool   `json:"overwrite_subscription_status"`
 	Delim              string `json:"delim"`
 	ListIDs            []int  `json:"lists"`
+
+	// ListsColumn is the optional name of a CSV column with comma separated list
+	// names or IDs to subscribe each row to. Rows with an empty value are
+	// subscribed to ListIDs.
+	ListsColumn string `json:"lists_column"`
+
+	// Mapping maps the CSV columns to subscriber fields. If it's empty, the
+	// email, name, and attributes columns are picked by their names.
+	Mapping []ColumnMapping `json:"mapping"`
+
+	// Lists are the lists that can be referenced in ListsColumn.
+	// They are also used to name lists in the import report.
+	Lists []models.List `json:"-"`
 }
 
 // Status represents statistics from an ongoing import session.
 type Status struct {
-	Name     string `json:"name"`
-	Total    int    `json:"total"`
-	Imported int    `json:"imported"`
-	Status   string `json:"status"`
-	logBuf   *bytes.Buffer
+	Name     string      `json:"name"`
+	Total    int         `json:"total"`
+	Imported int         `json:"imported"`
+	Status   string      `json:"status"`
+	Lists    []ListCount `json:"lists"`
+
+	// Batch is the number of the last successfully committed batch.
+	Batch int `json:"batch"`
+
+	// Errors is the number of rows that failed to import. ErrorFile is the
+	// URI to download them from once the import is done.
+	Errors             int    `json:"errors"`
+	ErrorFile          string `json:"error_file"`
+	ErrorFileTruncated bool   `json:"error_file_truncated"`
+
+	// ArchiveID is the ID of the archived original file of a finished import.
+	ArchiveID int `json:"archive_id"`
+
+	logBuf *bytes.Buffer
+
+	// Number of subscriptions created per list ID, and list names.
+	listCounts map[int]int
+	listNames  map[int]string
+}
+
+// ListCount is the number of subscriptions created on a list by an import.
+type ListCount struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
 }
 
 // SubReq is a wrapper over the Subscriber model.
@@ -114,6 +211,26 @@ type SubReq struct {
 	Lists          []int    `json:"lists"`
 	ListUUIDs      []string `json:"list_uuids"`
 	PreconfirmSubs bool     `json:"preconfirm_subscriptions"`
+
+	// Original CSV columns of the row, recorded in the error file if it fails.
+	row []string
+}
+
+// DryRunReport is the result of validating a CSV without importing it.
+type DryRunReport struct {
+	Total   int `json:"total"`
+	Valid   int `json:"valid"`
+	Invalid int `json:"invalid"`
+
+	InvalidRows          []InvalidRow `json:"invalid_rows"`
+	InvalidRowsTruncated bool         `json:"invalid_rows_truncated"`
+}
+
+// InvalidRow is a row in a CSV (numbered from 1, excluding the header) that
+// failed validation.
+type InvalidRow struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
 }
 
 type importStatusTpl struct {
@@ -121,6 +238,7 @@ type importStatusTpl struct {
 	Status   string
 	Imported int
 	Total    int
+	Lists    []ListCount
 }
 
 var (
@@ -128,6 +246,12 @@ var (
 	// import is already running.
 	ErrIsImporting = errors.New("import is already running")
 
+	// errInvalidAttribs is returned for rows with invalid attributes JSON.
+	errInvalidAttribs = errors.New("invalid attributes JSON")
+
+	// ErrorFileURI is the URI to download the CSV file of failed rows from.
+	ErrorFileURI = "/api/import/subscribers/errors"
+
 	csvHeaders = map[string]bool{
 		"email":      true,
 		"name":       true,
@@ -138,6 +262,10 @@ var (
 
 // New returns a new instance of Importer.
 func New(opt Options, db *sql.DB, i *i18n.I18n) *Importer {
+	if opt.BatchSize < 1 {
+		opt.BatchSize = defaultBatchSize
+	}
+
 	im := Importer{
 		opt:             opt,
 		db:              db,
@@ -177,34 +305,119 @@ func (im *Importer) NewSession(opt SessionOpt) (*Session, error) {
 		opt.OverwriteSubStatus = true
 	}
 
+	listIDs, listNames, names := mapLists(opt.Lists)
+
 	im.Lock()
 	im.status = Status{Status: StatusImporting,
-		Name:   opt.Filename,
-		logBuf: bytes.NewBuffer(nil)}
+		Name:       opt.Filename,
+		logBuf:     bytes.NewBuffer(nil),
+		listCounts: make(map[int]int),
+		listNames:  names}
+	im.errFile.remove()
+	im.errFile = &errorFile{maxSize: im.opt.ErrorFileMaxSize}
 	im.Unlock()
 
 	s := &Session{
-		im:       im,
-		log:      log.New(im.status.logBuf, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile),
-		subQueue: make(chan SubReq, commitBatchSize),
-		opt:      opt,
+		im:        im,
+		log:       log.New(im.status.logBuf, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile),
+		subQueue:  make(chan SubReq, im.opt.BatchSize),
+		errFile:   im.errFile,
+		opt:       opt,
+		listIDs:   listIDs,
+		listNames: listNames,
 	}
 
 	s.log.Printf("processing '%s'", opt.Filename)
 	return s, nil
 }
 
+// NewDryRunSession returns a session that validates the rows of a CSV with DryRun
+// without importing them. It doesn't affect the state of the importer and can be
+// used while an import is running.
+func (im *Importer) NewDryRunSession(opt SessionOpt) *Session {
+	if opt.Overwrite {
+		opt.OverwriteUserInfo = true
+		opt.OverwriteSubStatus = true
+	}
+
+	listIDs, listNames, _ := mapLists(opt.Lists)
+	return &Session{
+		im:        im,
+		log:       log.New(io.Discard, "", 0),
+		opt:       opt,
+		listIDs:   listIDs,
+		listNames: listNames,
+		dryRun:    true,
+	}
+}
+
+// mapLists maps the given lists by ID, by their lowercased names, and their names by ID.
+func mapLists(lists []models.List) (map[int]struct{}, map[string][]int, map[int]string) {
+	var (
+		listIDs   = make(map[int]struct{}, len(lists))
+		listNames = make(map[string][]int, len(lists))
+		names     = make(map[int]string, len(lists))
+	)
+	for _, l := range lists {
+		listIDs[l.ID] = struct{}{}
+		n := strings.ToLower(strings.TrimSpace(l.Name))
+		listNames[n] = append(listNames[n], l.ID)
+		names[l.ID] = l.Name
+	}
+
+	return listIDs, listNames, names
+}
+
 // GetStats returns the global Stats of the importer.
 func (im *Importer) GetStats() Status {
 	im.RLock()
 	defer im.RUnlock()
 
-	return Status{
+	lists := make([]ListCount, 0, len(im.status.listCounts))
+	for _, id := range slices.Sorted(maps.Keys(im.status.listCounts)) {
+		lists = append(lists, ListCount{ID: id, Name: im.status.listNames[id], Count: im.status.listCounts[id]})
+	}
+
+	out := Status{
 		Name:     im.status.Name,
 		Status:   im.status.Status,
 		Total:    im.status.Total,
 		Imported: im.status.Imported,
+		Lists:    lists,
+		Batch:    im.status.Batch,
+	}
+
+	if f := im.errFile; f != nil {
+		f.Lock()
+		out.Errors = f.count
+		out.ErrorFileTruncated = f.truncated
+		if f.path != "" && im.status.Status != StatusImporting && im.status.Status != StatusStopping {
+			out.ErrorFile = ErrorFileURI
+		}
+		f.Unlock()
+	}
+
+	return out
+}
+
+// GetErrorFile returns the path to the CSV file of rows that failed to import
+// in the last import session. It's only available once the import is done.
+func (im *Importer) GetErrorFile() (string, bool) {
+	if !im.isDone() {
+		return "", false
+	}
+
+	im.RLock()
+	defer im.RUnlock()
+
+	if im.errFile == nil {
+		return "", false
 	}
+
+	im.errFile.Lock()
+	defer im.errFile.Unlock()
+
+	return im.errFile.path, im.errFile.path != ""
 }
 
 // GetLogs returns the log entries of the last import session.
@@ -246,10 +459,15 @@ func (im *Importer) isDone() bool {
 	return s
 }
 
-// incrementImportCount sets the Importer's "imported" counter.
-func (im *Importer) incrementImportCount(n int) {
+// incrementImportCount sets the Importer's "imported" counter, the number
+// of subscriptions created per list, and the last committed batch.
+func (im *Importer) incrementImportCount(n int, lists map[int]int) {
 	im.Lock()
 	im.status.Imported += n
+	im.status.Batch++
+	for id, c := range lists {
+		im.status.listCounts[id] += c
+	}
 	im.Unlock()
 }
 
@@ -262,6 +480,7 @@ func (im *Importer) sendNotif(status string) error {
 			Status:   status,
 			Imported: s.Imported,
 			Total:    s.Total,
+			Lists:    s.Lists,
 		}
 		subject = fmt.Sprintf("%s: %s import", cases.Title(language.Und).String(status), s.Name)
 	)
@@ -273,15 +492,27 @@ func (im *Importer) sendNotif(status string) error {
 // invoked as a goroutine.
 func (s *Session) Start() {
 	var (
-		tx    *sql.Tx
-		stmt  *sql.Stmt
-		err   error
-		total = 0
-		cur   = 0
+		tx     *sql.Tx
+		stmt   *sql.Stmt
+		err    error
+		total  = 0
+		cur    = 0
+		failed = false
+
+		// Subscriptions created per list in the current batch, and
+		// all the lists that subscribers were imported into.
+		counts  = make(map[int]int)
+		touched = make(map[int]struct{})
+
+		// Subscribers created or blocklisted in the current batch by event type.
+		evs = make(map[string][]models.Subscriber)
 	)
 
 	listIDs := make([]int, len(s.opt.ListIDs))
 	copy(listIDs, s.opt.ListIDs)
+	for _, id := range listIDs {
+		touched[id] = struct{}{}
+	}
 
 	for sub := range s.subQueue {
 		if cur == 0 {
@@ -292,6 +523,13 @@ func (s *Session) Start() {
 				continue
 			}
 
+			// Imports are allowed a longer statement timeout than regular queries.
+			if s.im.opt.Timeout > 0 {
+				if _, err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", s.im.opt.Timeout.Milliseconds())); err != nil {
+					s.log.Printf("error setting import timeout: %v", err)
+				}
+			}
+
 			if s.opt.Mode == ModeSubscribe {
 				stmt = tx.Stmt(s.im.opt.UpsertStmt)
 			} else {
@@ -303,36 +541,109 @@ func (s *Session) Start() {
 		if err != nil {
 			s.log.Printf("error generating UUID: %v", err)
 			tx.Rollback()
+			failed = true
+			break
+		}
+
+		// The token in the links in messages is independent of the UUID.
+		tok, err := uuid.NewV4()
+		if err != nil {
+			s.log.Printf("error generating UUID: %v", err)
+			tx.Rollback()
+			failed = true
 			break
 		}
 
+		// Rows with lists of their own in the lists column are subscribed to them.
+		lists := listIDs
+		if len(sub.Lists) > 0 {
+			lists = sub.Lists
+		}
+
+		// Encrypt the attributes of subscribers in sensitive lists.
+		if s.im.opt.EncryptAttribsCB != nil {
+			sub.Attribs, err = s.im.opt.EncryptAttribsCB(sub.Email, lists, sub.Attribs)
+			if err != nil {
+				s.log.Printf("error encrypting attributes: %v", err)
+				s.recordError(sub.row, err)
+				tx.Rollback()
+				failed = true
+				break
+			}
+		}
+
 		if s.opt.Mode == ModeSubscribe {
-			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs, pq.Array(listIDs), s.opt.SubStatus, s.opt.OverwriteUserInfo, s.opt.OverwriteSubStatus)
+			if len(sub.Lists) > 0 {
+				for _, id := range lists {
+					touched[id] = struct{}{}
+				}
+			}
+
+			// Subscription statuses follow the lists' opt-in types with the lists column.
+			var (
+				id      int
+				created pq.Int64Array
+				isNew   bool
+			)
+			err = stmt.QueryRow(uu, sub.Email, sub.Name, sub.Attribs, pq.Array(lists), s.opt.SubStatus,
+				s.opt.OverwriteUserInfo, s.opt.OverwriteSubStatus, s.opt.ListsColumn != "", tok).Scan(new(string), &id, &created, &isNew)
+			for _, id := range created {
+				counts[int(id)]++
+			}
+			if err == nil && isNew {
+				evs[models.SubscriberEventCreated] = append(evs[models.SubscriberEventCreated], models.Subscriber{Base: models.Base{ID: id}, Email: sub.Email})
+			}
 		} else if s.opt.Mode == ModeBlocklist {
-			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs)
+			var id int
+			err = stmt.QueryRow(uu, sub.Email, sub.Name, sub.Attribs, tok).Scan(&id)
+			if err == nil {
+				evs[models.SubscriberEventBlocklisted] = append(evs[models.SubscriberEventBlocklisted], models.Subscriber{Base: models.Base{ID: id}, Email: sub.Email})
+			}
 		}
 		if err != nil {
 			s.log.Printf("error executing insert: %v", err)
+			s.recordError(sub.row, err)
 			tx.Rollback()
+			failed = true
 			break
 		}
 		cur++
 		total++
 
 		// Batch size is met. Commit.
-		if cur%commitBatchSize == 0 {
+		if cur%s.im.opt.BatchSize == 0 {
 			if err := tx.Commit(); err != nil {
 				tx.Rollback()
 				s.log.Printf("error committing to DB: %v", err)
 			} else {
-				s.im.incrementImportCount(cur)
-				s.log.Printf("imported %d", total)
+				s.im.incrementImportCount(cur, counts)
+				s.log.Printf("imported %d (batch %d)", total, s.im.GetStats().Batch)
+				s.publishEvents(evs)
 			}
 
 			cur = 0
+			clear(counts)
+			clear(evs)
 		}
 	}
 
+	listIDs = slices.Sorted(maps.Keys(touched))
+
+	// The uncommitted batch has been rolled back. Batches committed before
+	// the failure are retained and can be inspected.
+	if failed {
+		// Drain the queue so that the CSV loader doesn't block.
+		go func() {
+			for range s.subQueue {
+			}
+		}()
+
+		s.im.setStatus(StatusFailed)
+		s.log.Printf("import failed. %d batches were committed", s.im.GetStats().Batch)
+		s.im.sendNotif(StatusFailed)
+		return
+	}
+
 	// Queue's closed and there's nothing left to commit.
 	if cur == 0 {
 		s.im.setStatus(StatusFinished)
@@ -340,6 +651,7 @@ func (s *Session) Start() {
 		if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
 			s.log.Printf("error updating lists date: %v", err)
 		}
+		s.archive()
 		s.im.sendNotif(StatusFinished)
 		return
 	}
@@ -353,16 +665,50 @@ func (s *Session) Start() {
 		return
 	}
 
-	s.im.incrementImportCount(cur)
+	s.im.incrementImportCount(cur, counts)
+	s.publishEvents(evs)
 	s.im.setStatus(StatusFinished)
 	s.log.Printf("imported finished")
 	if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
 		s.log.Printf("error updating lists date: %v", err)
 	}
 
+	s.archive()
 	s.im.sendNotif(StatusFinished)
 }
 
+// publishEvents publishes the subscriber events of a committed batch.
+func (s *Session) publishEvents(evs map[string][]models.Subscriber) {
+	if s.im.opt.SubscriberEventCB == nil {
+		return
+	}
+
+	for typ, subs := range evs {
+		if len(subs) > 0 {
+			s.im.opt.SubscriberEventCB(typ, subs)
+		}
+	}
+}
+
+// archive archives the original CSV file of a finished import
+// if archiving is enabled.
+func (s *Session) archive() {
+	if s.im.opt.ArchiveCB == nil || s.srcPath == "" || s.stopped {
+		return
+	}
+
+	id, err := s.im.opt.ArchiveCB(s.opt.Filename, s.srcPath)
+	if err != nil {
+		s.log.Printf("error archiving import file: %v", err)
+		return
+	}
+
+	s.im.Lock()
+	s.im.status.ArchiveID = id
+	s.im.Unlock()
+	s.log.Printf("archived import file (archive %d)", id)
+}
+
 // Stop stops an active import session.
 func (s *Session) Stop() {
 	close(s.subQueue)
@@ -372,13 +718,13 @@ func (s *Session) Stop() {
 // a temporary directory, and returns the name of the temp directory and the
 // list of extracted .csv files.
 func (s *Session) ExtractZIP(srcPath string, maxCSVs int) (string, []string, error) {
-	if s.im.isDone() {
+	if !s.dryRun && s.im.isDone() {
 		return "", nil, ErrIsImporting
 	}
 
 	failed := true
 	defer func() {
-		if failed {
+		if failed && !s.dryRun {
 			s.im.setStatus(StatusFailed)
 		}
 	}()
@@ -471,6 +817,7 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 	if err != nil {
 		return err
 	}
+	s.srcPath = srcPath
 
 	// Count the total number of lines in the file. This doesn't distinguish
 	// between "blank" and non "blank" lines, and is only used to derive
@@ -502,11 +849,27 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 		return err
 	}
 
-	hdrKeys := s.mapCSVHeaders(csvHdr, csvHeaders)
-	// email is a required header.
-	if _, ok := hdrKeys["email"]; !ok {
-		s.log.Printf("'email' column not found in '%s'", srcPath)
-		return errors.New("'email' column not found")
+	s.errFile.setHeader(csvHdr)
+
+	hdrKeys, err := s.mapHeader(csvHdr)
+	if err != nil {
+		s.log.Printf("error in the header of '%s': %v", srcPath, err)
+		return err
+	}
+
+	// Validate all the lists in the lists column before importing anything.
+	if s.opt.ListsColumn != "" {
+		if err := s.validateListsColumn(rd, hdrKeys[s.opt.ListsColumn]); err != nil {
+			return err
+		}
+
+		// Rewind and skip the header again.
+		_, _ = f.Seek(0, 0)
+		rd = csv.NewReader(f)
+		rd.Comma = delim
+		if _, err := rd.Read(); err != nil {
+			return err
+		}
 	}
 
 	var (
@@ -520,6 +883,7 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 		select {
 		case <-s.im.stop:
 			failed = false
+			s.stopped = true
 			close(s.subQueue)
 			s.log.Println("stop request received")
 			return nil
@@ -532,6 +896,7 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 		} else if err != nil {
 			if err, ok := err.(*csv.ParseError); ok && err.Err == csv.ErrFieldCount {
 				s.log.Printf("skipping line %d. %v", i, err)
+				s.recordError(cols, err)
 				continue
 			} else {
 				s.log.Printf("error reading CSV '%s'", err)
@@ -542,50 +907,97 @@ func (s *Session) LoadCSV(srcPath string, delim rune) error {
 		lnCols := len(cols)
 		if lnCols < lnHdr {
 			s.log.Printf("skipping line %d. column count (%d) does not match minimum header count (%d)", i, lnCols, lnHdr)
+			s.recordError(cols, fmt.Errorf("column count (%d) does not match minimum header count (%d)", lnCols, lnHdr))
 			continue
 		}
 
-		// Iterate the key map and based on the indices mapped earlier,
-		// form a map of key: csv_value, eg: email: user@user.com.
-		row := make(map[string]string, lnCols)
-		for key := range hdrKeys {
-			row[key] = cols[hdrKeys[key]]
+		// Rows with invalid attributes are imported without them.
+		sub, err := s.parseRow(cols, hdrKeys)
+		if errors.Is(err, errInvalidAttribs) {
+			s.log.Printf("skipping invalid attributes JSON on line %d for '%s': %v", i, sub.Email, err)
+		} else if err != nil {
+			s.log.Printf("skipping line %d: %v: %v", i, err, cols)
+			s.recordError(cols, err)
+			continue
 		}
 
-		sub := SubReq{}
-		sub.Email = row["email"]
+		// Send the subscriber to the queue.
+		s.subQueue <- sub
+	}
+
+	close(s.subQueue)
+	failed = false
+
+	return nil
+}
+
+// DryRun reads a CSV file and validates its rows like LoadCSV without importing
+// them. Unlike an import, rows with invalid attributes JSON are reported as
+// invalid. Errors in the file itself, eg: a missing header, are returned.
+func (s *Session) DryRun(srcPath string, delim rune) (DryRunReport, error) {
+	out := DryRunReport{InvalidRows: []InvalidRow{}}
+
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return out, err
+	}
+	defer f.Close()
+
+	rd := csv.NewReader(f)
+	rd.Comma = delim
 
-		if v, ok := row["name"]; ok {
-			sub.Name = v
+	csvHdr, err := rd.Read()
+	if err != nil {
+		if err == io.EOF {
+			return out, errors.New("empty file")
 		}
+		return out, err
+	}
 
-		sub, err = s.im.ValidateFields(sub)
-		if err != nil {
-			s.log.Printf("skipping line %d: %v: %v", i, err, cols)
+	hdrKeys, err := s.mapHeader(csvHdr)
+	if err != nil {
+		return out, err
+	}
+
+	lnHdr := len(hdrKeys)
+	for i := 1; ; i++ {
+		cols, err := rd.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			if err, ok := err.(*csv.ParseError); !ok || err.Err != csv.ErrFieldCount {
+				return out, err
+			}
+			out.Total++
+			out.addInvalid(i, err)
 			continue
 		}
 
-		// JSON attributes.
-		if len(row["attributes"]) > 0 {
-			var (
-				attribs models.JSON
-				b       = []byte(row["attributes"])
-			)
-			if err := json.Unmarshal(b, &attribs); err != nil {
-				s.log.Printf("skipping invalid attributes JSON on line %d for '%s': %v", i, sub.Email, err)
-			} else {
-				sub.Attribs = attribs
-			}
+		out.Total++
+		if len(cols) < lnHdr {
+			out.addInvalid(i, fmt.Errorf("column count (%d) does not match minimum header count (%d)", len(cols), lnHdr))
+			continue
 		}
 
-		// Send the subscriber to the queue.
-		s.subQueue <- sub
+		if _, err := s.parseRow(cols, hdrKeys); err != nil {
+			out.addInvalid(i, err)
+		}
 	}
+	out.Valid = out.Total - out.Invalid
 
-	close(s.subQueue)
-	failed = false
+	return out, nil
+}
 
-	return nil
+// addInvalid records an invalid row in the report. Only the first maxDryRunErrors
+// rows are listed.
+func (r *DryRunReport) addInvalid(row int, err error) {
+	r.Invalid++
+	if len(r.InvalidRows) >= maxDryRunErrors {
+		r.InvalidRowsTruncated = true
+		return
+	}
+
+	r.InvalidRows = append(r.InvalidRows, InvalidRow{Row: row, Error: err.Error()})
 }
 
 // Stop sends a signal to stop the existing import.
@@ -593,6 +1005,8 @@ func (im *Importer) Stop() {
 	if im.getStatus() != StatusImporting {
 		im.Lock()
 		im.status = Status{Status: StatusNone}
+		im.errFile.remove()
+		im.errFile = nil
 		im.Unlock()
 
 		return
@@ -605,15 +1019,44 @@ func (im *Importer) Stop() {
 	}
 }
 
+// ErrEmailNotAllowed is matched (errors.Is) by the errors returned by SanitizeEmail
+// for addresses that are rejected by the e-mail allowlist/denylist patterns.
+var ErrEmailNotAllowed = errors.New("email_not_allowed")
+
+// emailNotAllowedErr is the error for addresses rejected by the e-mail patterns.
+type emailNotAllowedErr struct {
+	msg string
+}
+
+func (e emailNotAllowedErr) Error() string {
+	return e.msg
+}
+
+func (e emailNotAllowedErr) Is(err error) bool {
+	return err == ErrEmailNotAllowed
+}
+
 // SanitizeEmail validates and sanitizes an e-mail string and returns the
 // canonical (lowercased, trimmed) address. Domain allowlist/blocklist rules
-// are enforced on top of the bare-address validation in utils.SanitizeEmail.
+// and the e-mail allowlist/denylist patterns are enforced on top of the
+// bare-address validation in utils.SanitizeEmail.
 func (im *Importer) SanitizeEmail(email string) (string, error) {
+	if im.opt.StrictASCIIEmail && !utils.IsASCII(email) {
+		return "", errors.New(im.i18n.T("subscribers.invalidEmailASCII"))
+	}
+
 	addr, err := utils.SanitizeEmail(email)
 	if err != nil {
 		return "", errors.New(im.i18n.T("subscribers.invalidEmail"))
 	}
 
+	// Strict ASCII installs store IDN domains in their punycode form.
+	if im.opt.StrictASCIIEmail {
+		if addr, err = utils.EmailToASCII(addr); err != nil {
+			return "", errors.New(im.i18n.T("subscribers.invalidEmailASCII"))
+		}
+	}
+
 	// Check if the e-mail's domain is blocklisted. The e-mail domain and blocklist config
 	// are always lowercase.
 	if im.hasAllowlist || im.hasBlocklist {
@@ -636,9 +1079,34 @@ func (im *Importer) SanitizeEmail(email string) (string, error) {
 		}
 	}
 
+	if !im.isEmailAllowed(addr) {
+		return "", emailNotAllowedErr{msg: im.i18n.T("subscribers.emailNotAllowed")}
+	}
+
 	return addr, nil
 }
 
+// isEmailAllowed checks an e-mail address against the e-mail denylist and
+// allowlist patterns. Denylist patterns take priority over allowlist patterns.
+func (im *Importer) isEmailAllowed(email string) bool {
+	for _, re := range im.opt.EmailDenylistPatterns {
+		if re.MatchString(email) {
+			return false
+		}
+	}
+
+	if len(im.opt.EmailAllowlistPatterns) == 0 {
+		return true
+	}
+	for _, re := range im.opt.EmailAllowlistPatterns {
+		if re.MatchString(email) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // ValidateFields validates incoming subscriber field values and returns sanitized fields.
 func (im *Importer) ValidateFields(s SubReq) (SubReq, error) {
 	if len(s.Email) > 1000 {
@@ -691,6 +1159,159 @@ func (im *Importer) checkInList(domain string, hasWildcards bool, mp map[string]
 	return false
 }
 
+// mapHeader maps the known columns in the header of a CSV to their positions
+// and checks that the required columns are in it.
+func (s *Session) mapHeader(csvHdr []string) (map[string]int, error) {
+	var hdrKeys map[string]int
+	if len(s.opt.Mapping) > 0 {
+		h, err := s.mapFields(csvHdr)
+		if err != nil {
+			return nil, err
+		}
+		hdrKeys = h
+	} else {
+		knownHdrs := csvHeaders
+		if s.opt.ListsColumn != "" {
+			knownHdrs = maps.Clone(csvHeaders)
+			knownHdrs[s.opt.ListsColumn] = true
+		}
+
+		hdrKeys = s.mapCSVHeaders(csvHdr, knownHdrs)
+	}
+
+	// email is a required header.
+	if _, ok := hdrKeys["email"]; !ok {
+		return nil, errors.New("'email' column not found")
+	}
+
+	if s.opt.ListsColumn != "" {
+		if _, ok := hdrKeys[s.opt.ListsColumn]; !ok {
+			return nil, fmt.Errorf("'%s' column not found", s.opt.ListsColumn)
+		}
+	}
+
+	return hdrKeys, nil
+}
+
+// parseRow maps the columns of a CSV row to a subscriber and validates it. If the
+// attributes JSON is invalid, the subscriber is returned without the attributes
+// along with errInvalidAttribs.
+func (s *Session) parseRow(cols []string, hdrKeys map[string]int) (SubReq, error) {
+	// Iterate the key map and based on the indices mapped earlier,
+	// form a map of key: csv_value, eg: email: user@user.com.
+	row := make(map[string]string, len(cols))
+	for key := range hdrKeys {
+		row[key] = cols[hdrKeys[key]]
+	}
+
+	sub := SubReq{row: cols}
+	sub.Email = row["email"]
+
+	if v, ok := row["name"]; ok {
+		sub.Name = v
+	}
+	s.applyFields(&sub, cols)
+
+	sub, err := s.im.ValidateFields(sub)
+	if err != nil {
+		return sub, err
+	}
+
+	// Lists to subscribe the row to. In imports, they have already been validated.
+	if s.opt.ListsColumn != "" {
+		ids, unknown := s.resolveLists(row[s.opt.ListsColumn])
+		if len(unknown) > 0 {
+			return sub, fmt.Errorf("unknown lists: %s", strings.Join(unknown, ", "))
+		}
+		sub.Lists = ids
+	}
+
+	// JSON attributes.
+	if len(row["attributes"]) > 0 {
+		var attribs models.JSON
+		if err := json.Unmarshal([]byte(row["attributes"]), &attribs); err != nil {
+			return sub, fmt.Errorf("%w: %v", errInvalidAttribs, err)
+		}
+
+		// Attributes from mapped columns take precedence.
+		if attribs == nil {
+			attribs = models.JSON{}
+		}
+		maps.Copy(attribs, sub.Attribs)
+		sub.Attribs = attribs
+	}
+
+	return sub, nil
+}
+
+// validateListsColumn reads all the rows in a CSV and checks that every list in
+// the lists column can be resolved to a list. Unknown (or ambiguous) list names are
+// logged and an error is returned.
+func (s *Session) validateListsColumn(rd *csv.Reader, col int) error {
+	unknown := make(map[string]struct{})
+	for {
+		cols, err := rd.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			if err, ok := err.(*csv.ParseError); ok && err.Err == csv.ErrFieldCount {
+				continue
+			}
+			s.log.Printf("error reading CSV '%s'", err)
+			return err
+		}
+
+		if col >= len(cols) {
+			continue
+		}
+		_, bad := s.resolveLists(cols[col])
+		for _, name := range bad {
+			unknown[name] = struct{}{}
+		}
+	}
+
+	if len(unknown) > 0 {
+		names := strings.Join(slices.Sorted(maps.Keys(unknown)), ", ")
+		s.log.Printf("unknown or ambiguous lists in column '%s': %s", s.opt.ListsColumn, names)
+		return fmt.Errorf("unknown lists: %s", names)
+	}
+
+	return nil
+}
+
+// resolveLists takes a comma separated string of list names or IDs and returns
+// the list IDs. Names are matched case insensitively. Names that don't match
+// a list or match more than one list are returned separately.
+func (s *Session) resolveLists(val string) ([]int, []string) {
+	var (
+		ids     []int
+		unknown []string
+	)
+	for _, v := range strings.Split(val, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if id, err := strconv.Atoi(v); err == nil {
+			if _, ok := s.listIDs[id]; ok {
+				ids = append(ids, id)
+				continue
+			}
+		}
+
+		if m := s.listNames[strings.ToLower(v)]; len(m) == 1 {
+			ids = append(ids, m[0])
+			continue
+		}
+
+		unknown = append(unknown, v)
+	}
+
+	slices.Sort(ids)
+	return slices.Compact(ids), unknown
+}
+
 // mapCSVHeaders takes a list of headers obtained from a CSV file, a map of known headers,
 // and returns a new map with each of the headers in the known map mapped by the position (0-n)
 // in the given CSV list.
@@ -700,7 +1321,7 @@ func (s *Session) mapCSVHeaders(csvHdrs []string, knownHdrs map[string]bool) map
 	hdrKeys := make(map[string]int)
 	for i, h := range csvHdrs {
 		// Clean the string of non-ASCII characters (BOM etc.).
-		h := regexCleanStr.ReplaceAllString(strings.TrimSpace(h), "")
+		h := cleanHeader(h)
 		if _, ok := knownHdrs[h]; !ok {
 			s.log.Printf("ignoring unknown header '%s'", h)
 			continue
@@ -749,17 +1370,159 @@ func makeDomainMap(domains []string) (map[string]struct{}, bool) {
 		out          = make(map[string]struct{}, len(domains))
 		hasWildCards = false
 	)
-	for _, d := range domains {
-		out[d] = struct{}{}
-
-		// Domains with *. as the subdomain prefix, strip that
-		// and add the full domain to the blocklist as well.
-		// eg: *.example.com => example.com
-		if strings.Contains(d, "*.") {
-			hasWildCards = true
-			out[strings.TrimPrefix(d, "*.")] = struct{}{}
+	for _, dom := range domains {
+		// Add both the Unicode and ASCII (punycode) forms of IDN domains
+		// so that addresses in either form match.
+		for _, d := range idnForms(dom) {
+			out[d] = struct{}{}
+
+			// Domains with *. as the subdomain prefix, strip that
+			// and add the full domain to the blocklist as well.
+			// eg: *.example.com => example.com
+			if strings.Contains(d, "*.") {
+				hasWildCards = true
+				out[strings.TrimPrefix(d, "*.")] = struct{}{}
+			}
 		}
 	}
 
 	return out, hasWildCards
 }
+
+// idnForms returns the given domain along with its Unicode or ASCII (punycode)
+// counterpart if it's an IDN domain.
+func idnForms(d string) []string {
+	name, wildcard := strings.CutPrefix(d, "*.")
+
+	var (
+		alt string
+		err error
+	)
+	if utils.IsASCII(name) {
+		if !strings.Contains(name, "xn--") {
+			return []string{d}
+		}
+		alt, err = idna.Lookup.ToUnicode(name)
+	} else {
+		alt, err = idna.Lookup.ToASCII(name)
+	}
+	if err != nil || alt == name {
+		return []string{d}
+	}
+
+	if wildcard {
+		alt = "*." + alt
+	}
+	return []string{d, alt}
+}
+
+// errorFile streams rows that failed to import, along with the reasons,
+// to a temporary CSV file capped at a maximum size.
+type errorFile struct {
+	path    string
+	hdr     []string
+	maxSize int64
+
+	f         *os.File
+	wr        *csv.Writer
+	size      int64
+	count     int
+	truncated bool
+	sync.Mutex
+}
+
+// recordError records a row that failed to import in the session's error file.
+func (s *Session) recordError(row []string, rowErr error) {
+	if err := s.errFile.write(row, rowErr.Error()); err != nil {
+		s.log.Printf("error writing to the error file: %v", err)
+	}
+}
+
+// setHeader sets the CSV header of the file. An "error" column is appended to it.
+func (e *errorFile) setHeader(hdr []string) {
+	if e == nil {
+		return
+	}
+
+	e.Lock()
+	e.hdr = append(slices.Clone(hdr), "error")
+	e.Unlock()
+}
+
+// write writes a failed row and its error to the file. The file is created on the first write.
+func (e *errorFile) write(row []string, reason string) error {
+	if e == nil {
+		return nil
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.count++
+	if e.maxSize <= 0 || e.truncated {
+		return nil
+	}
+	if e.size >= e.maxSize {
+		e.truncated = true
+		return nil
+	}
+
+	if e.f == nil {
+		f, err := os.CreateTemp("", "listmonk-import-errors-*.csv")
+		if err != nil {
+			e.truncated = true
+			return err
+		}
+		e.f = f
+		e.path = f.Name()
+		e.wr = csv.NewWriter(&countWriter{w: f, n: &e.size})
+
+		if err := e.wr.Write(e.hdr); err != nil {
+			return err
+		}
+	}
+
+	// Pad short rows so that the error column always lines up with the header.
+	rec := slices.Clone(row)
+	if n := len(e.hdr) - 1; len(rec) < n {
+		rec = append(rec, make([]string, n-len(rec))...)
+	}
+
+	// Flush on every row so that memory stays bounded and the file is
+	// complete whenever the import stops.
+	if err := e.wr.Write(append(rec, reason)); err != nil {
+		return err
+	}
+	e.wr.Flush()
+
+	return e.wr.Error()
+}
+
+// remove closes and deletes the file.
+func (e *errorFile) remove() {
+	if e == nil {
+		return
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	if e.f != nil {
+		e.f.Close()
+		os.Remove(e.path)
+	}
+	e.f = nil
+	e.path = ""
+}
+
+// countWriter counts the number of bytes written to the underlying writer.
+type countWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	*c.n += int64(n)
+	return n, err
+}
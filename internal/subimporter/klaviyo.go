@@ -0,0 +1,260 @@
+package subimporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	klaviyoBaseURL  = "https://a.klaviyo.com/api"
+	klaviyoRevision = "2024-10-15"
+	klaviyoTimeout  = time.Second * 30
+
+	// Max size of a Klaviyo API response.
+	klaviyoMaxBytes = 20 << 20
+
+	// Number of times a rate limited request is retried.
+	klaviyoMaxRetries = 5
+
+	// Profile e-mail marketing consent of unsubscribed profiles.
+	klaviyoConsentUnsubscribed = "UNSUBSCRIBED"
+)
+
+// Klaviyo fetches lists, segments, and profiles from the Klaviyo API
+// with a private API key.
+type Klaviyo struct {
+	apiKey string
+	client *http.Client
+}
+
+// KlaviyoGroup is a Klaviyo list or segment.
+type KlaviyoGroup struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// KlaviyoProfile is a Klaviyo profile.
+type KlaviyoProfile struct {
+	ID      string
+	Email   string
+	Name    string
+	Attribs map[string]any
+
+	// Unsubscribed is true if the profile has unsubscribed from e-mail marketing.
+	Unsubscribed bool
+}
+
+// klaviyoResp is a page of resources in a Klaviyo API (JSON:API) response.
+type klaviyoResp struct {
+	Data []struct {
+		ID         string          `json:"id"`
+		Attributes json.RawMessage `json:"attributes"`
+	} `json:"data"`
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+	Errors []struct {
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+// klaviyoProfileAttribs are the attributes of a profile resource.
+type klaviyoProfileAttribs struct {
+	Email         string         `json:"email"`
+	FirstName     string         `json:"first_name"`
+	LastName      string         `json:"last_name"`
+	PhoneNumber   string         `json:"phone_number"`
+	Organization  string         `json:"organization"`
+	Title         string         `json:"title"`
+	Location      map[string]any `json:"location"`
+	Properties    map[string]any `json:"properties"`
+	Subscriptions struct {
+		Email struct {
+			Marketing struct {
+				Consent string `json:"consent"`
+			} `json:"marketing"`
+		} `json:"email"`
+	} `json:"subscriptions"`
+}
+
+// NewKlaviyo returns a Klaviyo client that authenticates with the given private API key.
+func NewKlaviyo(apiKey string) (*Klaviyo, error) {
+	apiKey = strings.TrimSpace(apiKey)
+	if apiKey == "" || strings.ContainsAny(apiKey, " \r\n") {
+		return nil, errors.New("invalid API key")
+	}
+
+	return &Klaviyo{apiKey: apiKey, client: &http.Client{Timeout: klaviyoTimeout}}, nil
+}
+
+// Lists returns all the Klaviyo lists.
+func (k *Klaviyo) Lists() ([]KlaviyoGroup, error) {
+	return k.groups(klaviyoBaseURL + "/lists/?fields[list]=name")
+}
+
+// Segments returns all the Klaviyo segments.
+func (k *Klaviyo) Segments() ([]KlaviyoGroup, error) {
+	return k.groups(klaviyoBaseURL + "/segments/?fields[segment]=name")
+}
+
+// ListProfiles returns all the profiles in a Klaviyo list.
+func (k *Klaviyo) ListProfiles(listID string) ([]KlaviyoProfile, error) {
+	u := fmt.Sprintf("%s/lists/%s/profiles/?page[size]=100&additional-fields[profile]=subscriptions",
+		klaviyoBaseURL, url.PathEscape(listID))
+
+	out := []KlaviyoProfile{}
+	err := k.paginate(u, func(r klaviyoResp) error {
+		for _, d := range r.Data {
+			var a klaviyoProfileAttribs
+			if err := json.Unmarshal(d.Attributes, &a); err != nil {
+				return fmt.Errorf("klaviyo: invalid profile: %v", err)
+			}
+			if a.Email == "" {
+				continue
+			}
+
+			out = append(out, KlaviyoProfile{
+				ID:           d.ID,
+				Email:        a.Email,
+				Name:         strings.TrimSpace(a.FirstName + " " + a.LastName),
+				Attribs:      a.attribs(),
+				Unsubscribed: a.Subscriptions.Email.Marketing.Consent == klaviyoConsentUnsubscribed,
+			})
+		}
+		return nil
+	})
+
+	return out, err
+}
+
+// SegmentProfileIDs returns the IDs of all the profiles in a Klaviyo segment.
+func (k *Klaviyo) SegmentProfileIDs(segmentID string) (map[string]struct{}, error) {
+	u := fmt.Sprintf("%s/segments/%s/profiles/?page[size]=100&fields[profile]=email",
+		klaviyoBaseURL, url.PathEscape(segmentID))
+
+	out := make(map[string]struct{})
+	err := k.paginate(u, func(r klaviyoResp) error {
+		for _, d := range r.Data {
+			out[d.ID] = struct{}{}
+		}
+		return nil
+	})
+
+	return out, err
+}
+
+// groups returns all the lists or segments from the given endpoint.
+func (k *Klaviyo) groups(u string) ([]KlaviyoGroup, error) {
+	out := []KlaviyoGroup{}
+	err := k.paginate(u, func(r klaviyoResp) error {
+		for _, d := range r.Data {
+			var a struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(d.Attributes, &a); err != nil {
+				return fmt.Errorf("klaviyo: invalid response: %v", err)
+			}
+			out = append(out, KlaviyoGroup{ID: d.ID, Name: a.Name})
+		}
+		return nil
+	})
+
+	return out, err
+}
+
+// paginate fetches the pages of resources starting at the given URL by following
+// the cursors in the next links, and calls cb with each page.
+func (k *Klaviyo) paginate(u string, cb func(klaviyoResp) error) error {
+	for u != "" {
+		r, err := k.get(u)
+		if err != nil {
+			return err
+		}
+		if err := cb(r); err != nil {
+			return err
+		}
+
+		u = r.Links.Next
+	}
+
+	return nil
+}
+
+// get fetches a page of resources. Rate limited requests are retried
+// after the interval the API asks for.
+func (k *Klaviyo) get(u string) (klaviyoResp, error) {
+	var out klaviyoResp
+
+	for n := 0; ; n++ {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return out, err
+		}
+		req.Header.Set("Authorization", "Klaviyo-API-Key "+k.apiKey)
+		req.Header.Set("Accept", "application/vnd.api+json")
+		req.Header.Set("revision", klaviyoRevision)
+
+		resp, err := k.client.Do(req)
+		if err != nil {
+			return out, err
+		}
+
+		b, err := io.ReadAll(io.LimitReader(resp.Body, klaviyoMaxBytes))
+		resp.Body.Close()
+		if err != nil {
+			return out, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && n < klaviyoMaxRetries {
+			wait := time.Second
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+				wait = time.Duration(s) * time.Second
+			}
+			time.Sleep(wait)
+			continue
+		}
+
+		if err := json.Unmarshal(b, &out); err != nil && resp.StatusCode == http.StatusOK {
+			return out, fmt.Errorf("klaviyo: invalid response: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			if len(out.Errors) > 0 && out.Errors[0].Detail != "" {
+				return out, fmt.Errorf("klaviyo: %s", out.Errors[0].Detail)
+			}
+			return out, fmt.Errorf("klaviyo: %s", resp.Status)
+		}
+
+		return out, nil
+	}
+}
+
+// attribs returns the custom properties of a profile with its
+// non-empty standard fields as subscriber attributes.
+func (a klaviyoProfileAttribs) attribs() map[string]any {
+	out := make(map[string]any, len(a.Properties)+4)
+	maps.Copy(out, a.Properties)
+
+	if a.PhoneNumber != "" {
+		out["phone_number"] = a.PhoneNumber
+	}
+	if a.Organization != "" {
+		out["organization"] = a.Organization
+	}
+	if a.Title != "" {
+		out["title"] = a.Title
+	}
+	if len(a.Location) > 0 {
+		out["location"] = a.Location
+	}
+
+	return out
+}
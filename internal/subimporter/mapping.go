@@ -0,0 +1,227 @@
+package subimporter
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Subscriber fields that CSV columns can be mapped to. Columns can also be
+// mapped to attributes with FieldAttribPrefix + the attribute's key.
+const (
+	FieldEmail        = "email"
+	FieldName         = "name"
+	FieldAttributes   = "attributes"
+	FieldAttribPrefix = "attribs."
+)
+
+// ColumnMapping maps a CSV column to a subscriber field. An empty Field
+// ignores the column.
+type ColumnMapping struct {
+	Column string `json:"column"`
+	Field  string `json:"field"`
+}
+
+// Preview is the header of a CSV, the mapping of its columns auto-detected
+// from their names, and the first few rows.
+type Preview struct {
+	Mapping []ColumnMapping `json:"mapping"`
+	Rows    [][]string      `json:"rows"`
+}
+
+// field is a column in a CSV row that's mapped to the name or an attribute.
+type field struct {
+	col  int
+	name string
+}
+
+var (
+	// Normalized column names (lowercase, without spaces and punctuation) that
+	// are auto-detected as e-mail addresses, full names, and first and last names.
+	emailCols     = map[string]bool{"email": true, "emailaddress": true, "mail": true, "emailid": true}
+	nameCols      = map[string]bool{"name": true, "fullname": true}
+	firstNameCols = map[string]bool{"firstname": true, "fname": true, "givenname": true, "forename": true}
+	lastNameCols  = map[string]bool{"lastname": true, "lname": true, "surname": true, "familyname": true}
+	attribCols    = map[string]bool{"attributes": true, "attribs": true}
+)
+
+// DetectMapping maps the columns in a CSV header to subscriber fields based on
+// their names, eg: "Email Address" to the e-mail, and "First name" and
+// "Last name" to the name. Unknown columns are mapped to attributes by their
+// names. listsCol, the optional lists column, is ignored.
+func DetectMapping(hdr []string, listsCol string) []ColumnMapping {
+	var (
+		out      = make([]ColumnMapping, len(hdr))
+		hasEmail = false
+		hasName  = false
+	)
+
+	// A full name column takes precedence over first and last names.
+	for _, h := range hdr {
+		if nameCols[normalizeHeader(h)] {
+			hasName = true
+			break
+		}
+	}
+
+	for i, h := range hdr {
+		h = cleanHeader(h)
+		out[i] = ColumnMapping{Column: h}
+		if h == "" || (listsCol != "" && h == listsCol) {
+			continue
+		}
+
+		n := normalizeHeader(h)
+		switch {
+		case emailCols[n] && !hasEmail:
+			out[i].Field = FieldEmail
+			hasEmail = true
+		case nameCols[n]:
+			out[i].Field = FieldName
+		case (firstNameCols[n] || lastNameCols[n]) && !hasName:
+			out[i].Field = FieldName
+		case attribCols[n]:
+			out[i].Field = FieldAttributes
+		default:
+			out[i].Field = FieldAttribPrefix + h
+		}
+	}
+
+	return out
+}
+
+// PreviewCSV reads the header and the first numRows rows of a CSV file and
+// auto-detects the mapping of its columns.
+func PreviewCSV(srcPath string, delim rune, listsCol string, numRows int) (Preview, error) {
+	out := Preview{Rows: [][]string{}}
+
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return out, err
+	}
+	defer f.Close()
+
+	rd := csv.NewReader(f)
+	rd.Comma = delim
+	rd.FieldsPerRecord = -1
+
+	hdr, err := rd.Read()
+	if err != nil {
+		if err == io.EOF {
+			return out, errors.New("empty file")
+		}
+		return out, err
+	}
+	out.Mapping = DetectMapping(hdr, listsCol)
+
+	for len(out.Rows) < numRows {
+		row, err := rd.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return out, err
+		}
+		out.Rows = append(out.Rows, row)
+	}
+
+	return out, nil
+}
+
+// mapFields maps the columns in the header of a CSV to their positions using
+// the session's column mapping. The e-mail, JSON attributes, and lists columns
+// are returned by their keys like in mapCSVHeaders, and the name and attribute
+// columns are recorded on the session.
+func (s *Session) mapFields(csvHdr []string) (map[string]int, error) {
+	mapping := make(map[string]string, len(s.opt.Mapping))
+	for _, m := range s.opt.Mapping {
+		mapping[m.Column] = strings.TrimSpace(m.Field)
+	}
+
+	hdrKeys := make(map[string]int)
+	s.fields = nil
+	for i, h := range csvHdr {
+		h = cleanHeader(h)
+		if s.opt.ListsColumn != "" && h == s.opt.ListsColumn {
+			hdrKeys[h] = i
+			continue
+		}
+
+		f := mapping[h]
+		switch {
+		case f == "":
+			s.log.Printf("ignoring unmapped header '%s'", h)
+		case f == FieldEmail || f == FieldAttributes:
+			if _, ok := hdrKeys[f]; ok {
+				return nil, fmt.Errorf("more than one column mapped to '%s'", f)
+			}
+			hdrKeys[f] = i
+		case IsValidField(f):
+			s.fields = append(s.fields, field{col: i, name: f})
+		default:
+			return nil, fmt.Errorf("invalid field '%s' for column '%s'", f, h)
+		}
+	}
+
+	return hdrKeys, nil
+}
+
+// applyFields sets the name and attributes of a subscriber from the columns
+// mapped to them. Multiple name columns, eg: first and last names, are joined.
+func (s *Session) applyFields(sub *SubReq, cols []string) {
+	var names []string
+	for _, f := range s.fields {
+		if f.col >= len(cols) {
+			continue
+		}
+
+		v := strings.TrimSpace(cols[f.col])
+		if v == "" {
+			continue
+		}
+
+		if f.name == FieldName {
+			names = append(names, v)
+			continue
+		}
+
+		if sub.Attribs == nil {
+			sub.Attribs = make(map[string]any)
+		}
+		sub.Attribs[strings.TrimPrefix(f.name, FieldAttribPrefix)] = v
+	}
+
+	if len(names) > 0 {
+		sub.Name = strings.Join(names, " ")
+	}
+}
+
+// IsValidField checks whether a CSV column can be mapped to a field. An empty
+// field, which ignores the column, is valid.
+func IsValidField(f string) bool {
+	switch f {
+	case "", FieldEmail, FieldName, FieldAttributes:
+		return true
+	}
+
+	return strings.HasPrefix(f, FieldAttribPrefix) && len(f) > len(FieldAttribPrefix)
+}
+
+// cleanHeader trims a CSV header and cleans it of non-ASCII characters (BOM etc.).
+func cleanHeader(h string) string {
+	return regexCleanStr.ReplaceAllString(strings.TrimSpace(h), "")
+}
+
+// normalizeHeader lowercases a CSV header and strips everything but letters
+// and digits from it, eg: "E-mail Address" becomes "emailaddress".
+func normalizeHeader(h string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, cleanHeader(h))
+}
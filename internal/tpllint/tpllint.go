@@ -0,0 +1,272 @@
+// Package tpllint checks the HTML of e-mail templates for common coding
+// mistakes that break rendering in e-mail clients: layouts without table
+// fallbacks for Outlook, CSS that e-mail clients don't support, styles that
+// are only in the <head>, and a missing viewport meta tag. Like a CSS linter,
+// every rule has a severity that can be configured or turned off.
+package tpllint
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Rules that are checked.
+const (
+	RuleDivLayout      = "div_layout"
+	RuleUnsupportedCSS = "unsupported_css"
+	RuleHeadStyles     = "head_styles"
+	RuleViewportMeta   = "viewport_meta"
+)
+
+// Severities of rules, in the order of their seriousness. Rules with
+// SeverityOff aren't checked.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNotice  = "notice"
+	SeverityOff     = "off"
+)
+
+// DefaultSeverities are the severities of the rules unless configured otherwise.
+var DefaultSeverities = map[string]string{
+	RuleDivLayout:      SeverityWarning,
+	RuleUnsupportedCSS: SeverityError,
+	RuleHeadStyles:     SeverityWarning,
+	RuleViewportMeta:   SeverityNotice,
+}
+
+// unsupportedCSS are CSS properties and values that aren't supported by major
+// e-mail clients (Outlook on Windows, Gmail etc.), mapped to their descriptions.
+var unsupportedCSS = []struct {
+	re   *regexp.Regexp
+	desc string
+}{
+	{regexp.MustCompile(`(?i)\bdisplay\s*:\s*(inline-)?flex\b`), "display: flex"},
+	{regexp.MustCompile(`(?i)\bdisplay\s*:\s*(inline-)?grid\b`), "display: grid"},
+	{regexp.MustCompile(`(?i)(^|[;{\s])(flex|flex-[a-z]+|justify-content|align-items|align-self|align-content|order|gap)\s*:`), "flexbox"},
+	{regexp.MustCompile(`(?i)(^|[;{\s])grid-[a-z-]+\s*:`), "CSS grid"},
+	{regexp.MustCompile(`(?i)\bposition\s*:\s*(absolute|fixed|sticky)\b`), "position"},
+	{regexp.MustCompile(`(?i)\bvar\(\s*--`), "CSS variables"},
+	{regexp.MustCompile(`(?i)(^|[;{\s])(transform|transition|animation)\s*:`), "transforms and animations"},
+	{regexp.MustCompile(`(?i)(^|[;{\s])box-shadow\s*:`), "box-shadow"},
+}
+
+var reViewport = regexp.MustCompile(`(?i)^\s*viewport\s*$`)
+
+// Issue is a rule that matched a template.
+type Issue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+
+	// Line (from 1) and the HTML element the issue was found at, if any.
+	Line    int    `json:"line,omitempty"`
+	Element string `json:"element,omitempty"`
+}
+
+// Summary is the number of issues of each severity.
+type Summary struct {
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+	Notices  int `json:"notices"`
+}
+
+// Result is the result of linting a template. A template passes if it has
+// no issues of the error severity.
+type Result struct {
+	Passed  bool    `json:"passed"`
+	Summary Summary `json:"summary"`
+	Issues  []Issue `json:"issues"`
+}
+
+// IsValidSeverity checks whether a severity is one of the known severities.
+func IsValidSeverity(s string) bool {
+	switch s {
+	case SeverityError, SeverityWarning, SeverityNotice, SeverityOff:
+		return true
+	}
+
+	return false
+}
+
+// element is an HTML element in a template of interest to the rules.
+type element struct {
+	tag  string
+	line int
+}
+
+// doc is the elements and styles of interest in an HTML template.
+type doc struct {
+	divs        []element
+	tables      int
+	msoComments int
+	hasViewport bool
+
+	// Inline styles and <style> blocks with their lines.
+	inline      []styleDecl
+	blocks      []styleDecl
+	blockInHead bool
+}
+
+type styleDecl struct {
+	css  string
+	line int
+	tag  string
+}
+
+// Lint checks an HTML template against the rules. severities overrides the
+// DefaultSeverities of rules.
+func Lint(body string, severities map[string]string) Result {
+	sev := make(map[string]string, len(DefaultSeverities))
+	for r, s := range DefaultSeverities {
+		sev[r] = s
+	}
+	for r, s := range severities {
+		if _, ok := sev[r]; ok && IsValidSeverity(s) {
+			sev[r] = s
+		}
+	}
+
+	var (
+		d   = parse(body)
+		out = Result{Issues: []Issue{}}
+	)
+	add := func(rule, msg string, line int, el string) {
+		s := sev[rule]
+		if s == SeverityOff {
+			return
+		}
+		out.Issues = append(out.Issues, Issue{Rule: rule, Severity: s, Message: msg, Line: line, Element: el})
+	}
+
+	// <div> layouts without tables or Outlook (mso) conditional comments.
+	if len(d.divs) > 0 && d.tables == 0 && d.msoComments == 0 {
+		add(RuleDivLayout, fmt.Sprintf("%d <div> elements are used for layout without a <table> fallback "+
+			"or an Outlook conditional comment (<!--[if mso]>). Outlook on Windows ignores the widths, "+
+			"padding, and backgrounds of <div> elements.", len(d.divs)), d.divs[0].line, "div")
+	}
+
+	// CSS that e-mail clients don't support.
+	for _, s := range append(d.inline, d.blocks...) {
+		for _, u := range unsupportedCSS {
+			if loc := u.re.FindStringIndex(s.css); loc != nil {
+				line := s.line + strings.Count(s.css[:loc[0]], "\n")
+				add(RuleUnsupportedCSS, fmt.Sprintf("%s is not supported by many e-mail clients.", u.desc), line, s.tag)
+			}
+		}
+	}
+
+	// Styles in the <head> without inline styles, which clients that strip
+	// <style> blocks (eg: Gmail for non-Google accounts) can fall back to.
+	if d.blockInHead && len(d.inline) == 0 {
+		add(RuleHeadStyles, "Styles are only in <style> blocks in the <head> without inline style attributes. "+
+			"Some e-mail clients strip <style> blocks, so inline the important styles.", d.blocks[0].line, "style")
+	}
+
+	if !d.hasViewport {
+		add(RuleViewportMeta, `There's no <meta name="viewport" content="width=device-width, initial-scale=1"> tag. `+
+			"Mobile e-mail clients may render the message zoomed out.", 0, "")
+	}
+
+	sort.SliceStable(out.Issues, func(i, j int) bool {
+		return out.Issues[i].Line < out.Issues[j].Line
+	})
+	for _, i := range out.Issues {
+		switch i.Severity {
+		case SeverityError:
+			out.Summary.Errors++
+		case SeverityWarning:
+			out.Summary.Warnings++
+		case SeverityNotice:
+			out.Summary.Notices++
+		}
+	}
+	out.Passed = out.Summary.Errors == 0
+
+	return out
+}
+
+// parse tokenizes an HTML template and records the elements of interest along
+// with the lines they're on.
+func parse(body string) doc {
+	var (
+		out     doc
+		line    = 1
+		inHead  = false
+		inStyle = 0
+		token   = html.NewTokenizer(strings.NewReader(body))
+	)
+
+	for {
+		tt := token.Next()
+		if tt == html.ErrorToken {
+			return out
+		}
+
+		// The line the token starts on.
+		raw := token.Raw()
+		cur := line
+		line += strings.Count(string(raw), "\n")
+
+		switch tt {
+		case html.CommentToken:
+			if strings.HasPrefix(strings.TrimSpace(string(token.Text())), "[if") &&
+				strings.Contains(strings.ToLower(string(raw)), "mso") {
+				out.msoComments++
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := token.Token()
+			switch t.DataAtom {
+			case atom.Head:
+				inHead = tt == html.StartTagToken
+			case atom.Body:
+				inHead = false
+			case atom.Div:
+				out.divs = append(out.divs, element{tag: "div", line: cur})
+			case atom.Table:
+				out.tables++
+			case atom.Style:
+				if tt == html.StartTagToken {
+					inStyle++
+					if inHead {
+						out.blockInHead = true
+					}
+				}
+			case atom.Meta:
+				for _, a := range t.Attr {
+					if a.Key == "name" && reViewport.MatchString(a.Val) {
+						out.hasViewport = true
+					}
+				}
+			}
+
+			for _, a := range t.Attr {
+				if a.Key == "style" && strings.TrimSpace(a.Val) != "" {
+					out.inline = append(out.inline, styleDecl{css: a.Val, line: cur, tag: t.Data})
+				}
+			}
+
+		case html.EndTagToken:
+			t := token.Token()
+			switch t.DataAtom {
+			case atom.Head:
+				inHead = false
+			case atom.Style:
+				if inStyle > 0 {
+					inStyle--
+				}
+			}
+
+		case html.TextToken:
+			if inStyle > 0 {
+				out.blocks = append(out.blocks, styleDecl{css: string(token.Text()), line: cur, tag: "style"})
+			}
+		}
+	}
+}
@@ -1,16 +1,34 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"net/mail"
 	"net/url"
 	"path"
+	"regexp"
 	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
 )
 
-// ErrInvalidEmail is returned by SanitizeEmail for malformed input.
-var ErrInvalidEmail = errors.New("invalid e-mail address")
+// Number of bytes of the HMAC used as the signature in unsubscribe mailto: addresses.
+const unsubSigLen = 10
+
+var (
+	// ErrInvalidEmail is returned by SanitizeEmail for malformed input.
+	ErrInvalidEmail = errors.New("invalid e-mail address")
+
+	// reUnsubMailto matches the token in a plus-addressed unsubscribe address:
+	// local+<campaignUUID>.<subscriberUUID>.<signature>@domain, where the signature
+	// is unsubSigLen bytes in hex.
+	reUnsubMailto = regexp.MustCompile(`(?i)\+([a-f0-9\-]{36})\.([a-f0-9\-]{36})\.([a-f0-9]{20})@`)
+)
 
 // ValidateEmail reports whether s is a correctly formed bare e-mail address
 // (no display name component).
@@ -22,13 +40,72 @@ func ValidateEmail(s string) bool {
 // SanitizeEmail trims, lowercases, and validates s as a bare e-mail address
 // (no display name) and returns the canonical form. Returns ErrInvalidEmail
 // for anything `mail.ParseAddress` rejects or for input with a display name.
+//
+// Internationalized (EAI) addresses are accepted. The canonical form is NFC
+// normalized and has its domain in Unicode, so that the Unicode and punycode
+// forms of an IDN domain (例え.jp, xn--r8jz45g.jp) yield the same address.
 func SanitizeEmail(s string) (string, error) {
-	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ToLower(norm.NFC.String(strings.TrimSpace(s)))
 	em, err := mail.ParseAddress(s)
 	if err != nil || em.Address != s {
 		return "", ErrInvalidEmail
 	}
-	return em.Address, nil
+
+	local, domain, ok := cutEmail(em.Address)
+	if !ok {
+		return "", ErrInvalidEmail
+	}
+
+	// Validate the IDN domain and convert it to its Unicode form.
+	if !IsASCII(domain) || strings.Contains(domain, "xn--") {
+		d, err := idna.Lookup.ToUnicode(domain)
+		if err != nil {
+			return "", ErrInvalidEmail
+		}
+		domain = norm.NFC.String(d)
+	}
+
+	return local + "@" + domain, nil
+}
+
+// EmailToASCII converts the IDN domain of an e-mail address to punycode for
+// delivery through mail servers that don't support SMTPUTF8. Addresses with
+// non-ASCII local parts can't be represented in ASCII and return ErrInvalidEmail.
+func EmailToASCII(addr string) (string, error) {
+	if IsASCII(addr) {
+		return addr, nil
+	}
+
+	local, domain, ok := cutEmail(addr)
+	if !ok || !IsASCII(local) {
+		return "", ErrInvalidEmail
+	}
+
+	d, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", ErrInvalidEmail
+	}
+
+	return local + "@" + d, nil
+}
+
+// IsASCII checks whether s consists only of ASCII characters.
+func IsASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// cutEmail splits an e-mail address into its local part and domain.
+func cutEmail(addr string) (string, string, bool) {
+	i := strings.LastIndex(addr, "@")
+	if i < 1 || i == len(addr)-1 {
+		return "", "", false
+	}
+	return addr[:i], addr[i+1:], true
 }
 
 // ParseEmailAddress extracts the lowercased bare address from an RFC 5322
@@ -76,3 +153,44 @@ func SanitizeURI(u string) string {
 
 	return path.Clean(p.Path)
 }
+
+// MakeUnsubMailto returns a plus-addressed unsubscribe address on the given address
+// for a campaign and a subscriber, signed with the given key.
+// eg: unsub@site.com -> unsub+<campaignUUID>.<subscriberUUID>.<signature>@site.com
+func MakeUnsubMailto(addr, campUUID, subUUID string, key []byte) string {
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return addr
+	}
+
+	return addr[:i] + "+" + campUUID + "." + subUUID + "." + signUnsubMailto(campUUID, subUUID, key) + addr[i:]
+}
+
+// FindUnsubMailto finds a plus-addressed unsubscribe address in s and returns the
+// campaign UUID, subscriber UUID, and the signature in it.
+func FindUnsubMailto(s string) (string, string, string, bool) {
+	m := reUnsubMailto.FindStringSubmatch(s)
+	if m == nil {
+		return "", "", "", false
+	}
+
+	return strings.ToLower(m[1]), strings.ToLower(m[2]), strings.ToLower(m[3]), true
+}
+
+// VerifyUnsubMailto reports whether sig is the valid signature of the campaign and
+// subscriber UUIDs in an unsubscribe address for the given key.
+func VerifyUnsubMailto(campUUID, subUUID, sig string, key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(signUnsubMailto(campUUID, subUUID, key)))
+}
+
+// signUnsubMailto returns the hex signature of the campaign and subscriber UUIDs.
+func signUnsubMailto(campUUID, subUUID string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(campUUID + "." + subUUID))
+
+	return hex.EncodeToString(mac.Sum(nil)[:unsubSigLen])
+}
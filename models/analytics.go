@@ -0,0 +1,193 @@
+package models
+
+import (
+	"time"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+type CampaignAnalyticsCount struct {
+	CampaignID int       `db:"campaign_id" json:"campaign_id"`
+	Count      int       `db:"count" json:"count"`
+	Timestamp  time.Time `db:"timestamp" json:"timestamp"`
+}
+
+// CampaignAnalyticsBounceType is the number of bounces of a type on a campaign.
+type CampaignAnalyticsBounceType struct {
+	CampaignID int    `db:"campaign_id" json:"campaign_id"`
+	Type       string `db:"type" json:"type"`
+	Count      int    `db:"count" json:"count"`
+}
+
+// EngagementFunnel is the funnel of the messages sent in a campaign through to
+// the subscribers who engaged with them. When no deliveries have been confirmed
+// for the campaign, the delivered count is estimated as the sent count less the
+// bounces and DeliveredConfirmed is false.
+type EngagementFunnel struct {
+	CampaignID         int           `json:"campaign_id"`
+	DeliveredConfirmed bool          `json:"delivered_confirmed"`
+	Stages             []FunnelStage `json:"stages"`
+}
+
+// FunnelStage is a stage in an engagement funnel. Percent is the count's share of
+// the messages sent and Rate is its share of the previous stage's count.
+type FunnelStage struct {
+	Stage   string  `json:"stage"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+	Rate    float64 `json:"rate"`
+}
+
+// CampaignFunnelCounts is the raw counts of the stages in a campaign's engagement funnel.
+type CampaignFunnelCounts struct {
+	Sent      int `db:"sent"`
+	Delivered int `db:"delivered"`
+	Bounced   int `db:"bounced"`
+	Opened    int `db:"opened"`
+	Clicked   int `db:"clicked"`
+
+	UnsubscribeClicked int `db:"unsubscribe_clicked"`
+	Unsubscribed       int `db:"unsubscribed"`
+}
+
+type CampaignAnalyticsLink struct {
+	URL         string `db:"url" json:"url"`
+	Count       int    `db:"count" json:"count"`
+	Conversions int    `db:"conversions" json:"conversions"`
+}
+
+type CampaignViewExport struct {
+	CampaignID     int       `db:"campaign_id"`
+	CampaignUUID   string    `db:"campaign_uuid"`
+	CampaignName   string    `db:"campaign_name"`
+	SubscriberID   int       `db:"subscriber_id"`
+	SubscriberUUID string    `db:"subscriber_uuid"`
+	Email          string    `db:"email"`
+	SubscriberName string    `db:"subscriber_name"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+type CampaignClickExport struct {
+	CampaignID     int       `db:"campaign_id"`
+	CampaignUUID   string    `db:"campaign_uuid"`
+	CampaignName   string    `db:"campaign_name"`
+	SubscriberID   int       `db:"subscriber_id"`
+	SubscriberUUID string    `db:"subscriber_uuid"`
+	Email          string    `db:"email"`
+	SubscriberName string    `db:"subscriber_name"`
+	URL            string    `db:"url"`
+	UTM            string    `db:"utm"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// CampaignSubscriberAnalytics is a subscriber's engagement with a campaign
+// in the campaign's analytics export.
+type CampaignSubscriberAnalytics struct {
+	Email          string    `db:"email"`
+	SentAt         null.Time `db:"sent_at"`
+	OpenedAt       null.Time `db:"opened_at"`
+	FirstClickedAt null.Time `db:"first_clicked_at"`
+	Bounced        bool      `db:"bounced"`
+	Unsubscribed   bool      `db:"unsubscribed"`
+}
+
+// CohortCount is the number of campaigns sent to a cohort of subscribers in a week
+// since the cohort's week along with the number of them that were viewed and clicked.
+type CohortCount struct {
+	Cohort time.Time `db:"cohort"`
+	Size   int       `db:"size"`
+	Week   int       `db:"week"`
+	Sent   int       `db:"sent"`
+	Views  int       `db:"views"`
+	Clicks int       `db:"clicks"`
+}
+
+// SubscriberCohorts represents the cumulative open or click rates (0 - 1) of cohorts of
+// subscribers grouped by the week they subscribed in. Matrix[i][w] is the rate of the
+// Cohorts[i] over the campaigns sent to it until w weeks since its week, or null if no
+// campaigns were sent to it by then.
+type SubscriberCohorts struct {
+	By        string           `json:"by"`
+	Metric    string           `json:"metric"`
+	Weeks     int              `json:"weeks"`
+	ListID    int              `json:"list_id"`
+	Cohorts   []Cohort         `json:"cohorts"`
+	Matrix    [][]null.Float64 `json:"matrix"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// Cohort is a group of subscribers who subscribed in a week.
+type Cohort struct {
+	Week time.Time `json:"week"`
+	Size int       `json:"size"`
+}
+
+// SendFrequency represents the number of campaigns sent each week over a period and
+// the unsubscribe rates in those weeks. Correlation is the (Pearson) correlation between
+// the two over the weeks, or null if it can't be computed.
+type SendFrequency struct {
+	Days        int                 `json:"days"`
+	ListID      int                 `json:"list_id"`
+	Correlation null.Float64        `json:"correlation"`
+	Weeks       []SendFrequencyWeek `json:"weeks"`
+}
+
+// SendFrequencyWeek is the number of campaigns sent in an (ISO) week, eg: 2024-W01,
+// and the percentage of the active subscriptions that were unsubscribed in it.
+type SendFrequencyWeek struct {
+	Week            string    `db:"week" json:"week"`
+	StartsAt        time.Time `db:"starts_at" json:"starts_at"`
+	CampaignsSent   int       `db:"campaigns_sent" json:"campaigns_sent"`
+	Unsubscribes    int       `db:"unsubscribes" json:"unsubscribes"`
+	Subscriptions   int       `db:"subscriptions" json:"subscriptions"`
+	UnsubscribeRate float64   `db:"-" json:"unsubscribe_rate"`
+}
+
+// ComparableCampaign is a past campaign sent to the same lists as a campaign
+// whose performance is predicted.
+type ComparableCampaign struct {
+	ID           int       `db:"id"`
+	Sent         int       `db:"sent"`
+	StartedAt    time.Time `db:"started_at"`
+	Views        int       `db:"views"`
+	Clicks       int       `db:"clicks"`
+	Unsubscribes int       `db:"unsubscribes"`
+}
+
+// PredictedRate is a predicted rate (0 - 1) with its 95% confidence interval.
+type PredictedRate struct {
+	Rate float64 `json:"rate"`
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+// CampaignPrediction is the predicted performance of a campaign based on
+// comparable campaigns sent earlier. Confidence is a percentage.
+type CampaignPrediction struct {
+	CampaignID      int           `json:"campaign_id"`
+	CampaignIDs     []int         `json:"comparable_campaign_ids"`
+	OpenRate        PredictedRate `json:"open_rate"`
+	ClickRate       PredictedRate `json:"click_rate"`
+	UnsubscribeRate PredictedRate `json:"unsubscribe_rate"`
+	Confidence      float64       `json:"confidence"`
+}
+
+// HeatmapCount is the unique subscriber count of an engagement type (views, clicks)
+// in a day-of-week and hour-of-day slot.
+type HeatmapCount struct {
+	Type  string `db:"type"`
+	DOW   int    `db:"dow"`
+	Hour  int    `db:"hour"`
+	Count int    `db:"count"`
+}
+
+// EngagementHeatmap represents 7x24 (day-of-week x hour-of-day) matrices of
+// unique subscribers who viewed or clicked campaigns. Rows start on Sunday.
+type EngagementHeatmap struct {
+	Days      int        `json:"days"`
+	Timezone  string     `json:"timezone"`
+	ListID    int        `json:"list_id"`
+	Views     [7][24]int `json:"views"`
+	Clicks    [7][24]int `json:"clicks"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
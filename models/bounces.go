@@ -11,11 +11,41 @@ const (
 	BounceTypeComplaint = "complaint"
 )
 
+// Normalized reasons of bounces.
+const (
+	BounceReasonInvalidRecipient = "invalid_recipient"
+	BounceReasonMailboxFull      = "mailbox_full"
+	BounceReasonContentRejected  = "content_rejected"
+	BounceReasonReputationBlock  = "reputation_block"
+	BounceReasonDMARCPolicy      = "dmarc_policy"
+	BounceReasonOther            = "other"
+)
+
+// BounceReasons is the list of all bounce reasons.
+var BounceReasons = []string{
+	BounceReasonInvalidRecipient,
+	BounceReasonMailboxFull,
+	BounceReasonContentRejected,
+	BounceReasonReputationBlock,
+	BounceReasonDMARCPolicy,
+	BounceReasonOther,
+}
+
+// MailtoUnsub represents an unsubscribe request e-mailed to a signed
+// List-Unsubscribe mailto: address and received in the bounce mailbox.
+type MailtoUnsub struct {
+	CampaignUUID   string
+	SubscriberUUID string
+	Signature      string
+	From           string
+}
+
 // Bounce represents a single bounce event.
 type Bounce struct {
 	ID        int             `db:"id" json:"id"`
 	Type      string          `db:"type" json:"type"`
 	Source    string          `db:"source" json:"source"`
+	Reason    string          `db:"reason" json:"reason"`
 	Meta      json.RawMessage `db:"meta" json:"meta"`
 	CreatedAt time.Time       `db:"created_at" json:"created_at"`
 
@@ -32,3 +62,21 @@ type Bounce struct {
 	// in searches and queries.
 	Total int `db:"total" json:"-"`
 }
+
+// BounceReasonCount represents the number of bounces of a reason and their
+// share of all the bounces.
+type BounceReasonCount struct {
+	Reason  string  `db:"reason" json:"reason"`
+	Count   int     `db:"count" json:"count"`
+	Percent float64 `db:"percent" json:"percent"`
+}
+
+// BounceDomainCount represents the number of bounces, by type, of the subscribers
+// with e-mail addresses on a domain.
+type BounceDomainCount struct {
+	Domain    string `db:"domain" json:"domain"`
+	Hard      int    `db:"hard" json:"hard"`
+	Soft      int    `db:"soft" json:"soft"`
+	Complaint int    `db:"complaint" json:"complaint"`
+	Total     int    `db:"total" json:"total"`
+}
@@ -8,6 +8,7 @@ import (
 	"html/template"
 	"strings"
 	txttpl "text/template"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/types"
@@ -29,6 +30,31 @@ const (
 	CampaignContentTypeMarkdown = "markdown"
 	CampaignContentTypePlain    = "plain"
 	CampaignContentTypeVisual   = "visual"
+
+	// Statuses of a campaign's external approval gate.
+	CampaignGatePending    = "pending"
+	CampaignGateApproved   = "approved"
+	CampaignGateOverridden = "overridden"
+
+	// Curves along which a campaign's messages are spread over its send window.
+	CampaignSpreadUniform  = "uniform"
+	CampaignSpreadRampUp   = "ramp_up"
+	CampaignSpreadRampDown = "ramp_down"
+
+	// Types of the events in a campaign's audit trail (campaign_events).
+	CampaignEventContentRevision   = "content_revision"
+	CampaignEventSubscriberPreview = "subscriber_preview"
+	CampaignEventUnsubscribe       = "unsubscribe"
+	CampaignEventUnsubscribeClick  = "unsubscribe_click"
+
+	// Checks in a campaign's pre-send checklist.
+	CampaignCheckFromEmail   = "from_email"
+	CampaignCheckSubject     = "subject"
+	CampaignCheckUnsubscribe = "unsubscribe_link"
+	CampaignCheckLinks       = "links"
+	CampaignCheckSubscribers = "subscribers"
+	CampaignCheckTemplate    = "template"
+	CampaignCheckSpamScore   = "spam_score"
 )
 
 // Campaigns represents a slice of Campaigns.
@@ -51,6 +77,27 @@ type Campaign struct {
 	Status            string          `db:"status" json:"status"`
 	ContentType       string          `db:"content_type" json:"content_type"`
 	Tags              pq.StringArray  `db:"tags" json:"tags"`
+	ExcludeListIDs    pq.Int64Array   `db:"exclude_list_ids" json:"exclude_list_ids"`
+	JournalAddress    string          `db:"journal_address" json:"journal_address"`
+	TopicIDs          pq.Int64Array   `db:"topic_ids" json:"topic_ids"`
+	GateURL           string          `db:"gate_url" json:"gate_url"`
+	GateStatus        string          `db:"gate_status" json:"gate_status"`
+	GateReason        string          `db:"gate_reason" json:"gate_reason"`
+	GateCheckedAt     null.Time       `db:"gate_checked_at" json:"gate_checked_at"`
+	RenderStats       JSON            `db:"render_stats" json:"render_stats"`
+	SendSpread        int             `db:"send_spread" json:"send_spread"`
+	SendSpreadCurve   string          `db:"send_spread_curve" json:"send_spread_curve"`
+	SendAtLocalTime   string          `db:"send_at_local_time" json:"send_at_local_time"`
+	LocalWaveAt       null.Time       `db:"local_wave_at" json:"-"`
+	TrackingDomain    null.String     `db:"tracking_domain" json:"tracking_domain"`
+	BodyURL           string          `db:"body_url" json:"body_url"`
+	Survey            SurveyQuestions `db:"survey" json:"survey"`
+	UTMTemplate       UTMTemplate     `db:"utm_template" json:"utm_template"`
+	SegmentID         null.Int        `db:"segment_id" json:"segment_id"`
+	SegmentParams     SegmentValues   `db:"segment_params" json:"segment_params"`
+	FreezeAudience    bool            `db:"freeze_audience" json:"freeze_audience"`
+	AudienceFrozenAt  null.Time       `db:"audience_frozen_at" json:"audience_frozen_at"`
+	ContentRevision   int             `db:"content_revision" json:"content_revision"`
 	Headers           Headers         `db:"headers" json:"headers"`
 	Attribs           JSON            `db:"attribs" json:"attribs"`
 	TemplateID        null.Int        `db:"template_id" json:"template_id"`
@@ -60,6 +107,18 @@ type Campaign struct {
 	ArchiveTemplateID null.Int        `db:"archive_template_id" json:"archive_template_id"`
 	ArchiveMeta       json.RawMessage `db:"archive_meta" json:"archive_meta"`
 
+	ArchiveCoverMediaID null.Int    `db:"archive_cover_media_id" json:"archive_cover_media_id"`
+	ArchiveAccentColor  null.String `db:"archive_accent_color" json:"archive_accent_color"`
+	ArchiveExcerpt      null.String `db:"archive_excerpt" json:"archive_excerpt"`
+
+	// ArchivePassword is the bcrypt hash of the password that protects the archive page.
+	ArchivePassword    null.String `db:"archive_password" json:"-"`
+	ArchiveHasPassword bool        `db:"archive_has_password" json:"archive_has_password"`
+
+	// ArchiveCoverURL is the public URL of the archive cover media
+	// that's resolved when rendering the archive.
+	ArchiveCoverURL string `db:"-" json:"-"`
+
 	// TemplateBody is joined in from templates by the next-campaigns query.
 	TemplateBody        string             `db:"template_body" json:"-"`
 	ArchiveTemplateBody string             `db:"archive_template_body" json:"-"`
@@ -67,6 +126,11 @@ type Campaign struct {
 	SubjectTpl          *txttpl.Template   `json:"-"`
 	AltBodyTpl          *template.Template `json:"-"`
 
+	// TemplateVariants are the bodies of the language variants of the template,
+	// and LangTpls, the campaign compiled into each of them.
+	TemplateVariants TemplateVariants              `db:"template_variants" json:"-"`
+	LangTpls         map[string]*template.Template `json:"-"`
+
 	// HeaderTpls is holds optionally {{ templated }} campaign headers.
 	HeaderTpls []map[string]*txttpl.Template `json:"-"`
 
@@ -80,6 +144,120 @@ type Campaign struct {
 	// Pseudofield for getting the total number of subscribers
 	// in searches and queries.
 	Total int `db:"total" json:"-"`
+
+	// Pseudofield indicating where a search query matched (name, subject, body).
+	MatchType string `db:"match_type" json:"match_type,omitempty"`
+
+	// Other users who are currently editing the campaign (advisory).
+	Editors []Editor `db:"-" json:"editors,omitempty"`
+}
+
+// CampaignAudience is the number of subscribers a campaign would be sent to now,
+// along with the approximate daily counts of the past days.
+type CampaignAudience struct {
+	Count    int                   `json:"count"`
+	FrozenAt null.Time             `json:"frozen_at"`
+	Trend    []CampaignAudienceDay `json:"trend"`
+}
+
+// CampaignOverlap is the number of subscribers in the audience of a campaign
+// who are also in the audience of another campaign.
+type CampaignOverlap struct {
+	CampaignID   int `db:"campaign_id" json:"campaign_id"`
+	OverlapCount int `db:"overlap_count" json:"overlap_count"`
+}
+
+// CampaignClient is the number of views of a campaign in an e-mail client
+// and their percentage of all the views.
+type CampaignClient struct {
+	Client string  `db:"client" json:"client"`
+	Opens  int     `db:"opens" json:"opens"`
+	Pct    float64 `db:"pct" json:"pct"`
+}
+
+// CampaignISP is the number of subscribers of an ISP (eg: gmail) that a campaign
+// was sent to, bounced for, and was opened by on the ISP's tracking domain.
+type CampaignISP struct {
+	ISP          string  `db:"isp" json:"isp"`
+	Sent         int     `db:"sent" json:"sent"`
+	Bounced      int     `db:"bounced" json:"bounced"`
+	Opens        int     `db:"opens" json:"opens"`
+	DeliveryRate float64 `db:"delivery_rate" json:"delivery_rate"`
+	OpenRate     float64 `db:"open_rate" json:"open_rate"`
+}
+
+// CampaignSendReport breaks down the audience of a campaign into the subscribers who
+// were sent the campaign, the ones who were skipped, and the remaining ones who
+// haven't been sent it yet.
+type CampaignSendReport struct {
+	Sent              int `db:"sent" json:"sent"`
+	SkippedSuppressed int `db:"skipped_suppressed" json:"skipped_suppressed"`
+	SkippedBounced    int `db:"skipped_bounced" json:"skipped_bounced"`
+	RemainingUnsent   int `db:"remaining_unsent" json:"remaining_unsent"`
+}
+
+// CampaignAudienceDay is the approximate audience count of a campaign at the end of a day.
+type CampaignAudienceDay struct {
+	Date  string `db:"date" json:"date"`
+	Count int    `db:"count" json:"count"`
+}
+
+// CampaignEvent is an event in the lifecycle of a campaign, eg: a change to the
+// content of a running campaign.
+type CampaignEvent struct {
+	ID         int64     `db:"id" json:"id"`
+	CampaignID int       `db:"campaign_id" json:"campaign_id"`
+	Type       string    `db:"type" json:"type"`
+	Data       JSON      `db:"data" json:"data"`
+	CreatedBy  null.Int  `db:"created_by" json:"created_by"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
+// CampaignRevision is a revision of the content of a campaign along with the views
+// and clicks of the messages that were sent with it. Revision 0 is the original content.
+// AfterSubscriberID is the subscriber checkpoint after which (by ID) the messages were
+// sent with the revision once the running campaign picked it up.
+type CampaignRevision struct {
+	Revision          int         `db:"revision" json:"revision"`
+	AfterSubscriberID null.Int    `db:"after_subscriber_id" json:"after_subscriber_id"`
+	AppliedAt         null.Time   `db:"applied_at" json:"applied_at"`
+	CreatedBy         null.Int    `db:"created_by" json:"created_by"`
+	CreatedByName     null.String `db:"created_by_name" json:"created_by_name"`
+	CreatedAt         null.Time   `db:"created_at" json:"created_at"`
+	Views             int         `db:"views" json:"views"`
+	Clicks            int         `db:"clicks" json:"clicks"`
+}
+
+// CampaignStatusResult is the result of a campaign's status
+// update in a batch status update.
+type CampaignStatusResult struct {
+	ID      int    `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// CampaignStartConfirmation is returned when a campaign is being started
+// with confirmation enabled. The campaign is only started when the token
+// is presented again before it expires.
+type CampaignStartConfirmation struct {
+	Token     string                `json:"confirmation_token"`
+	ExpiresAt time.Time             `json:"expires_at"`
+	Snapshot  CampaignStartSnapshot `json:"snapshot"`
+}
+
+// CampaignStartSnapshot is a summary of what's about to be sent when
+// a campaign is started.
+type CampaignStartSnapshot struct {
+	CampaignID    int            `json:"campaign_id"`
+	Name          string         `json:"name"`
+	Subject       string         `json:"subject"`
+	FromEmail     string         `json:"from_email"`
+	Messenger     string         `json:"messenger"`
+	SendAt        null.Time      `json:"send_at"`
+	Lists         types.JSONText `json:"lists"`
+	AudienceCount int            `json:"audience_count"`
+	Warnings      []string       `json:"warnings"`
 }
 
 // CampaignMeta contains fields tracking a campaign's progress.
@@ -89,6 +267,10 @@ type CampaignMeta struct {
 	Clicks     int `db:"clicks" json:"clicks"`
 	Bounces    int `db:"bounces" json:"bounces"`
 
+	// Number of subscribers who clicked the unsubscribe link in the campaign's messages,
+	// whether or not they confirmed the unsubscription.
+	UnsubscribeClickCount int `db:"unsubscribe_click_count" json:"unsubscribe_click_count"`
+
 	// This is a list of {list_id, name} pairs unlike Subscriber.Lists[]
 	// because lists can be deleted after a campaign is finished, resulting
 	// in null lists data to be returned. For that reason, campaign_lists maintains
@@ -100,6 +282,61 @@ type CampaignMeta struct {
 	StartedAt null.Time `db:"started_at" json:"started_at"`
 	ToSend    int       `db:"to_send" json:"to_send"`
 	Sent      int       `db:"sent" json:"sent"`
+
+	// Delivered is the number of messages confirmed to be delivered with
+	// delivery notifications, which is independent of the sent count.
+	Delivered int `db:"delivered" json:"delivered"`
+}
+
+// CampaignActivity is a view, click, bounce, or unsubscription recorded on a campaign.
+// Data has the type specific details, eg: the URL of a click. The subscriber fields
+// are null for anonymous views and clicks, or when the subscriber has been deleted.
+type CampaignActivity struct {
+	ID             int64          `db:"id" json:"id"`
+	SubscriberID   null.Int       `db:"subscriber_id" json:"subscriber_id"`
+	SubscriberUUID null.String    `db:"subscriber_uuid" json:"subscriber_uuid"`
+	Email          null.String    `db:"email" json:"email"`
+	Data           types.JSONText `db:"data" json:"data"`
+	CreatedAt      time.Time      `db:"created_at" json:"created_at"`
+}
+
+// CampaignActivityPage is a page of a campaign's activity. NextCursor is
+// the cursor to fetch the next page with, or null on the last page.
+type CampaignActivityPage struct {
+	Results    []CampaignActivity `json:"results"`
+	PerPage    int                `json:"per_page"`
+	NextCursor null.Int           `json:"next_cursor"`
+}
+
+// CalendarEvent is the send of a campaign to one of its lists on the dashboard
+// calendar. Conflict is set when another campaign is sent to the list on the same day.
+type CalendarEvent struct {
+	CampaignID      int       `db:"campaign_id" json:"campaign_id"`
+	CampaignName    string    `db:"campaign_name" json:"campaign_name"`
+	Status          string    `db:"status" json:"status"`
+	ListID          int       `db:"list_id" json:"list_id"`
+	ListName        string    `db:"list_name" json:"list_name"`
+	SubscriberCount int       `db:"subscriber_count" json:"subscriber_count"`
+	SendAt          time.Time `db:"send_at" json:"send_at"`
+	Conflict        bool      `db:"-" json:"conflict"`
+}
+
+// CampaignCheck is the result of a check in a campaign's pre-send checklist.
+type CampaignCheck struct {
+	Check   string `json:"check"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// RenderStats contains the render timing (in milliseconds) and message size
+// (in bytes) statistics of a set of sampled template renders.
+type RenderStats struct {
+	Samples int     `json:"samples"`
+	P50     float64 `json:"p50_ms"`
+	P95     float64 `json:"p95_ms"`
+	Max     float64 `json:"max_ms"`
+	SizeAvg int     `json:"size_avg"`
+	SizeMax int     `json:"size_max"`
 }
 
 // GetIDs returns the list of campaign IDs.
@@ -129,6 +366,7 @@ func (camps Campaigns) LoadStats(stmt *sqlx.Stmt) error {
 			camps[i].Views = c.Views
 			camps[i].Clicks = c.Clicks
 			camps[i].Bounces = c.Bounces
+			camps[i].UnsubscribeClickCount = c.UnsubscribeClickCount
 			camps[i].Media = c.Media
 		}
 	}
@@ -155,22 +393,13 @@ func (c *Campaign) CompileTemplate(f template.FuncMap) error {
 	}
 
 	// Compile the base template.
-	body := c.TemplateBody
-
-	if body == "" || c.ContentType == CampaignContentTypeVisual {
-		body = `{{ template "content" . }}`
-	}
-
-	for _, r := range regTplFuncs {
-		body = r.regExp.ReplaceAllString(body, r.replace)
-	}
-
-	baseTPL, err := template.New(BaseTpl).Funcs(f).Parse(body)
+	baseTPL, err := c.compileBaseTemplate(c.TemplateBody, f)
 	if err != nil {
-		return fmt.Errorf("error compiling base template: %v", err)
+		return err
 	}
 
 	// If the format is markdown, convert Markdown to HTML.
+	var body string
 	if c.ContentType == CampaignContentTypeMarkdown {
 		var b bytes.Buffer
 		if err := markdown.Convert([]byte(c.Body), &b); err != nil {
@@ -181,12 +410,12 @@ func (c *Campaign) CompileTemplate(f template.FuncMap) error {
 		body = c.Body
 	}
 
-	// Compile the campaign message.
-	for _, r := range regTplFuncs {
+	// Compile the campaign message with the configured delimiters.
+	for _, r := range bodyTplFuncs {
 		body = r.regExp.ReplaceAllString(body, r.replace)
 	}
 
-	msgTpl, err := template.New(ContentTpl).Funcs(f).Parse(body)
+	msgTpl, err := template.New(ContentTpl).Delims(tplDelims[0], tplDelims[1]).Funcs(f).Parse(body)
 	if err != nil {
 		return fmt.Errorf("error compiling message: %v", err)
 	}
@@ -197,12 +426,30 @@ func (c *Campaign) CompileTemplate(f template.FuncMap) error {
 	}
 	c.Tpl = out
 
-	if hasTplExpr(c.AltBody.String) {
-		b := c.AltBody.String
-		for _, r := range regTplFuncs {
+	// Compile the message into the language variants of the template. Visual
+	// campaigns don't use the template's body.
+	c.LangTpls = nil
+	if c.ContentType != CampaignContentTypeVisual && len(c.TemplateVariants) > 0 {
+		c.LangTpls = make(map[string]*template.Template, len(c.TemplateVariants))
+		for lang, b := range c.TemplateVariants {
+			base, err := c.compileBaseTemplate(b, f)
+			if err != nil {
+				return fmt.Errorf("error compiling '%s' template: %v", lang, err)
+			}
+
+			out, err := base.AddParseTree(ContentTpl, msgTpl.Tree)
+			if err != nil {
+				return fmt.Errorf("error inserting child template: %v", err)
+			}
+			c.LangTpls[lang] = out
+		}
+	}
+
+	if b, _ := c.AltBodyText(); hasDelimExpr(b, tplDelims[0], tplDelims[1]) {
+		for _, r := range bodyTplFuncs {
 			b = r.regExp.ReplaceAllString(b, r.replace)
 		}
-		bTpl, err := template.New(ContentTpl).Funcs(f).Parse(b)
+		bTpl, err := template.New(ContentTpl).Delims(tplDelims[0], tplDelims[1]).Funcs(f).Parse(b)
 		if err != nil {
 			return fmt.Errorf("error compiling alt plaintext message: %v", err)
 		}
@@ -241,32 +488,67 @@ func (c *Campaign) CompileTemplate(f template.FuncMap) error {
 	return nil
 }
 
+// compileBaseTemplate compiles a template body into which the campaign
+// message is inserted.
+func (c *Campaign) compileBaseTemplate(body string, f template.FuncMap) (*template.Template, error) {
+	if body == "" || c.ContentType == CampaignContentTypeVisual {
+		body = `{{ template "content" . }}`
+	}
+
+	for _, r := range regTplFuncs {
+		body = r.regExp.ReplaceAllString(body, r.replace)
+	}
+
+	tpl, err := template.New(BaseTpl).Funcs(f).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling base template: %v", err)
+	}
+
+	return tpl, nil
+}
+
+// LangTpl returns the compiled template of the campaign for a subscriber's
+// language preference, falling back to the default template.
+func (c *Campaign) LangTpl(lang string) *template.Template {
+	if l, ok := c.TemplateVariants.Match(lang); ok {
+		if tpl, ok := c.LangTpls[l]; ok {
+			return tpl
+		}
+	}
+
+	return c.Tpl
+}
+
+// AltBodyText returns the plain text alternative body of the campaign and
+// whether it has one. Markdown campaigns without an explicit alt body use
+// their raw Markdown body, which is readable as plain text.
+func (c *Campaign) AltBodyText() (string, bool) {
+	if c.ContentType == CampaignContentTypeMarkdown && !c.AltBody.Valid {
+		return c.Body, true
+	}
+
+	return c.AltBody.String, c.AltBody.Valid
+}
+
 // hasTplExpr checks whether a given string has a Go template expression with {{ and  }}.
 func hasTplExpr(s string) bool {
-	_, after, ok := strings.Cut(s, "{{")
-	return ok && strings.Contains(after, "}}")
+	return hasDelimExpr(s, "{{", "}}")
+}
+
+// hasDelimExpr checks whether a given string has a Go template expression
+// with the given delimiters.
+func hasDelimExpr(s, left, right string) bool {
+	_, after, ok := strings.Cut(s, left)
+	return ok && strings.Contains(after, right)
 }
 
 // ConvertContent converts a campaign's body from one format to another,
 // for example, Markdown to HTML.
 func (c *Campaign) ConvertContent(from, to string) (string, error) {
-	body := c.Body
-	for _, r := range regTplFuncs {
-		body = r.regExp.ReplaceAllString(body, r.replace)
-	}
-
-	// If the format is markdown, convert Markdown to HTML.
-	var out string
-	if from == CampaignContentTypeMarkdown &&
-		(to == CampaignContentTypeHTML || to == CampaignContentTypeRichtext) {
-		var b bytes.Buffer
-		if err := markdown.Convert([]byte(c.Body), &b); err != nil {
-			return out, err
-		}
-		out = b.String()
-	} else {
-		return out, errors.New("unknown formats to convert")
+	out, err := c.Convert(from, to)
+	if err != nil {
+		return "", err
 	}
 
-	return out, nil
+	return out.Body, nil
 }
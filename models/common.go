@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/extension"
@@ -15,7 +17,8 @@ import (
 
 // Enum values for various statuses.
 const (
-	// Headers attached to e-mails for bounce tracking.
+	// Headers attached to e-mails for bounce tracking. The subscriber header carries
+	// the subscriber's token (Subscriber.UnsubscribeToken) and not their UUID.
 	EmailHeaderSubscriberUUID = "X-Listmonk-Subscriber"
 	EmailHeaderCampaignUUID   = "X-Listmonk-Campaign"
 
@@ -40,29 +43,64 @@ type regTplFunc struct {
 	replace string
 }
 
-var regTplFuncs = []regTplFunc{
-	// Regular expression for matching {{ TrackLink "http://link.com" }} in the template
-	// and substituting it with {{ TrackLink "http://link.com" . }} (the dot context)
-	// before compilation. This is to make linking easier for users.
-	{
-		regExp:  regexp.MustCompile(`{{\s*TrackLink\s+"([^"]+)"\s*}}`),
-		replace: `{{ TrackLink "$1" . }}`,
-	},
-
-	// Convert the shorthand https://google.com@TrackLink to {{ TrackLink ... }}.
-	// This is for WYSIWYG editors that encode and break quotes {{ "" }} when inserted
-	// inside <a href="{{ TrackLink "https://these-quotes-break" }}>.
-	// The regex matches all characters that may occur in an URL
-	// (see "2. Characters" in RFC3986: https://www.ietf.org/rfc/rfc3986.txt)
-	{
-		regExp:  regexp.MustCompile(`(https?://[\p{L}\p{N}_\-\.~!#$&'()*+,/:;=?@\[\]%]*)@TrackLink`),
-		replace: `{{ TrackLink "$1" . }}`,
-	},
-
-	{
-		regExp:  regexp.MustCompile(`{{(\s+)?(TrackView|UnsubscribeURL|ManageURL|OptinURL|MessageURL)(\s+)?}}`),
-		replace: `{{ $2 . }}`,
-	},
+var (
+	// regTplFuncs are the shorthands of template functions with the default delimiters.
+	regTplFuncs = makeRegTplFuncs("{{", "}}")
+
+	// tplDelims are the delimiters of template expressions in campaign bodies
+	// and bodyTplFuncs, the shorthands of template functions with them.
+	tplDelims    = [2]string{"{{", "}}"}
+	bodyTplFuncs = regTplFuncs
+)
+
+// SetTemplateDelims sets the delimiters of template expressions in campaign bodies,
+// eg: [[ and ]], for bodies that have to contain {{ and }} as-is. Empty delimiters
+// reset them to the default {{ and }}. It's not safe to call while campaigns are
+// being compiled.
+func SetTemplateDelims(left, right string) {
+	if left == "" || right == "" {
+		left, right = "{{", "}}"
+	}
+
+	tplDelims = [2]string{left, right}
+	bodyTplFuncs = makeRegTplFuncs(left, right)
+}
+
+// makeRegTplFuncs returns the shorthands of template functions with the given delimiters.
+func makeRegTplFuncs(left, right string) []regTplFunc {
+	var (
+		l = regexp.QuoteMeta(left)
+		r = regexp.QuoteMeta(right)
+
+		// Delimiters escaped for the replacement strings.
+		lr = strings.ReplaceAll(left, "$", "$$")
+		rr = strings.ReplaceAll(right, "$", "$$")
+	)
+
+	return []regTplFunc{
+		// Regular expression for matching {{ TrackLink "http://link.com" }} in the template
+		// and substituting it with {{ TrackLink "http://link.com" . }} (the dot context)
+		// before compilation. This is to make linking easier for users.
+		{
+			regExp:  regexp.MustCompile(l + `\s*TrackLink\s+"([^"]+)"\s*` + r),
+			replace: lr + ` TrackLink "$1" . ` + rr,
+		},
+
+		// Convert the shorthand https://google.com@TrackLink to {{ TrackLink ... }}.
+		// This is for WYSIWYG editors that encode and break quotes {{ "" }} when inserted
+		// inside <a href="{{ TrackLink "https://these-quotes-break" }}>.
+		// The regex matches all characters that may occur in an URL
+		// (see "2. Characters" in RFC3986: https://www.ietf.org/rfc/rfc3986.txt)
+		{
+			regExp:  regexp.MustCompile(`(https?://[\p{L}\p{N}_\-\.~!#$&'()*+,/:;=?@\[\]%]*)@TrackLink`),
+			replace: lr + ` TrackLink "$1" . ` + rr,
+		},
+
+		{
+			regExp:  regexp.MustCompile(l + `(\s+)?(TrackView|UnsubscribeURL|ManageURL|OptinURL|MessageURL)(\s+)?` + r),
+			replace: lr + ` $2 . ` + rr,
+		},
+	}
 }
 
 // markdown is a global instance of Markdown parser and renderer.
@@ -102,6 +140,13 @@ type PageResults struct {
 	Page    int    `json:"page"`
 }
 
+// Editor represents a user who is currently editing a resource, eg: a campaign.
+type Editor struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	TouchedAt time.Time `json:"touched_at"`
+}
+
 // Base holds common fields shared across models.
 type Base struct {
 	ID        int       `db:"id" json:"id"`
@@ -0,0 +1,624 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Types of constructs that may not survive a content format conversion.
+const (
+	ConversionLossScript      = "script"
+	ConversionLossStyle       = "style"
+	ConversionLossAttribute   = "attribute"
+	ConversionLossNestedTable = "nested_table"
+	ConversionLossImage       = "image"
+)
+
+// ContentConversion represents a campaign body converted from one
+// content format to another along with a fidelity report.
+type ContentConversion struct {
+	From   string           `json:"from"`
+	To     string           `json:"to"`
+	Body   string           `json:"body"`
+	Report []ConversionLoss `json:"report"`
+}
+
+// ConversionLoss represents a construct in the source body that
+// will be lost or altered in the converted body.
+type ConversionLoss struct {
+	Type  string   `json:"type"`
+	Count int      `json:"count"`
+	Items []string `json:"items,omitempty"`
+}
+
+var (
+	reTplExp     = regexp.MustCompile(`(?s){{.*?}}`)
+	reTplHolder  = regexp.MustCompile(`lmtplx(\d+)x`)
+	reSpaces     = regexp.MustCompile(`[ \t\r\n\f]+`)
+	reLineSpaces = regexp.MustCompile(`[ \t]+`)
+	reNewlines   = regexp.MustCompile(`\n{3,}`)
+
+	errUnknownConversion = errors.New("unknown formats to convert")
+)
+
+// Attributes that are represented in Markdown.
+var mdAttribs = map[string]bool{
+	"href":  true,
+	"src":   true,
+	"alt":   true,
+	"title": true,
+}
+
+// Convert converts the campaign's body from one content format to another
+// and reports the constructs in the body that won't survive the conversion.
+func (c *Campaign) Convert(from, to string) (ContentConversion, error) {
+	out := ContentConversion{From: from, To: to, Body: c.Body, Report: []ConversionLoss{}}
+
+	if from == CampaignContentTypeVisual || to == CampaignContentTypeVisual {
+		return out, errUnknownConversion
+	}
+	if from == to {
+		return out, nil
+	}
+
+	// Template expressions are swapped with placeholders before the HTML is
+	// parsed so that the parser doesn't escape or mangle them.
+	body, exps := protectTplExps(c.Body)
+
+	var err error
+	switch {
+	case from == CampaignContentTypeMarkdown && isHTMLContent(to):
+		var b bytes.Buffer
+		if err := markdown.Convert([]byte(c.Body), &b); err != nil {
+			return out, err
+		}
+		out.Body = b.String()
+		return out, nil
+
+	case from == CampaignContentTypeMarkdown && to == CampaignContentTypePlain:
+		var b bytes.Buffer
+		if err := markdown.Convert([]byte(body), &b); err != nil {
+			return out, err
+		}
+		out.Report = conversionReport(b.String(), to)
+		body, err = htmlToPlain(b.String())
+
+	case from == CampaignContentTypePlain && isHTMLContent(to):
+		body = strings.ReplaceAll(html.EscapeString(body), "\n", "<br>\n")
+
+	case from == CampaignContentTypePlain && to == CampaignContentTypeMarkdown:
+
+	case isHTMLContent(from) && to == CampaignContentTypeMarkdown:
+		out.Report = conversionReport(body, to)
+		body, err = htmlToMarkdown(body)
+
+	case isHTMLContent(from) && to == CampaignContentTypePlain:
+		out.Report = conversionReport(body, to)
+		body, err = htmlToPlain(body)
+
+	case isHTMLContent(from) && to == CampaignContentTypeRichtext:
+		out.Report = conversionReport(body, to)
+		body, err = normalizeRichtext(body)
+
+	case isHTMLContent(from) && to == CampaignContentTypeHTML:
+
+	default:
+		return out, errUnknownConversion
+	}
+	if err != nil {
+		return out, err
+	}
+
+	out.Body = restoreTplExps(body, exps)
+	return out, nil
+}
+
+// isHTMLContent checks if a content type is an HTML format.
+func isHTMLContent(typ string) bool {
+	return typ == CampaignContentTypeHTML || typ == CampaignContentTypeRichtext
+}
+
+// protectTplExps replaces template expressions in the body with
+// plain text placeholders and returns the expressions.
+func protectTplExps(body string) (string, []string) {
+	var exps []string
+	body = reTplExp.ReplaceAllStringFunc(body, func(s string) string {
+		exps = append(exps, s)
+		return fmt.Sprintf("lmtplx%dx", len(exps)-1)
+	})
+
+	return body, exps
+}
+
+// restoreTplExps puts back the template expressions replaced by protectTplExps.
+func restoreTplExps(body string, exps []string) string {
+	return reTplHolder.ReplaceAllStringFunc(body, func(s string) string {
+		var n int
+		if _, err := fmt.Sscanf(s, "lmtplx%dx", &n); err != nil || n >= len(exps) {
+			return s
+		}
+		return exps[n]
+	})
+}
+
+// parseHTML parses an HTML body (full document or fragment) into a list of nodes.
+func parseHTML(body string) ([]*html.Node, error) {
+	return html.ParseFragment(strings.NewReader(body), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+}
+
+// conversionReport walks the HTML body and returns the list of constructs
+// that will not survive conversion to the given content type.
+func conversionReport(body, to string) []ConversionLoss {
+	out := []ConversionLoss{}
+
+	nodes, err := parseHTML(body)
+	if err != nil {
+		return out
+	}
+
+	var (
+		counts = map[string]int{}
+		attrs  = map[string]bool{}
+		walk   func(n *html.Node, tables int)
+	)
+	walk = func(n *html.Node, tables int) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Script:
+				counts[ConversionLossScript]++
+			case atom.Style:
+				counts[ConversionLossStyle]++
+			case atom.Img:
+				if to == CampaignContentTypePlain {
+					counts[ConversionLossImage]++
+				}
+			case atom.Table:
+				if tables > 0 && to == CampaignContentTypeMarkdown {
+					counts[ConversionLossNestedTable]++
+				}
+				tables++
+			}
+
+			for _, a := range n.Attr {
+				if lostAttrib(a.Key, to) {
+					counts[ConversionLossAttribute]++
+					attrs[a.Key] = true
+				}
+			}
+		}
+
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			walk(ch, tables)
+		}
+	}
+	for _, n := range nodes {
+		walk(n, 0)
+	}
+
+	for _, typ := range []string{ConversionLossScript, ConversionLossStyle, ConversionLossAttribute,
+		ConversionLossNestedTable, ConversionLossImage} {
+		if counts[typ] == 0 {
+			continue
+		}
+
+		l := ConversionLoss{Type: typ, Count: counts[typ]}
+		if typ == ConversionLossAttribute {
+			for a := range attrs {
+				l.Items = append(l.Items, a)
+			}
+			sort.Strings(l.Items)
+		}
+		out = append(out, l)
+	}
+
+	return out
+}
+
+// lostAttrib checks if an HTML attribute is dropped when converting to the given content type.
+func lostAttrib(key, to string) bool {
+	switch to {
+	case CampaignContentTypeMarkdown:
+		return !mdAttribs[key]
+	case CampaignContentTypeRichtext:
+		return strings.HasPrefix(key, "on")
+	}
+
+	return false
+}
+
+// normalizeRichtext strips scripts, the document <head> and wrapper tags, and
+// event handler attributes from an HTML body that the rich text editor does not retain.
+func normalizeRichtext(body string) (string, error) {
+	var (
+		b    strings.Builder
+		skip atom.Atom
+		z    = html.NewTokenizer(strings.NewReader(body))
+	)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		// Skip the contents of a dropped tag until it closes. An unclosed
+		// <head> ends where the <body> starts.
+		if skip != 0 {
+			t := z.Token()
+			if (tt == html.EndTagToken && t.DataAtom == skip) ||
+				(tt == html.StartTagToken && skip == atom.Head && t.DataAtom == atom.Body) {
+				skip = 0
+			}
+			continue
+		}
+
+		switch tt {
+		case html.DoctypeToken:
+			continue
+
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			raw := string(z.Raw())
+			t := z.Token()
+
+			switch t.DataAtom {
+			case atom.Html, atom.Body:
+				continue
+			case atom.Script, atom.Head:
+				if tt == html.StartTagToken {
+					skip = t.DataAtom
+				}
+				continue
+			}
+
+			attrs := t.Attr[:0]
+			for _, a := range t.Attr {
+				if !strings.HasPrefix(a.Key, "on") {
+					attrs = append(attrs, a)
+				}
+			}
+			if len(attrs) == len(t.Attr) {
+				b.WriteString(raw)
+				continue
+			}
+			t.Attr = attrs
+			b.WriteString(t.String())
+
+		default:
+			b.Write(z.Raw())
+		}
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+// htmlToPlain converts an HTML body to plain text.
+func htmlToPlain(body string) (string, error) {
+	nodes, err := parseHTML(body)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		b    strings.Builder
+		walk func(n *html.Node)
+	)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(reSpaces.ReplaceAllString(n.Data, " "))
+			return
+		case html.ElementNode:
+		default:
+			return
+		}
+
+		switch n.DataAtom {
+		case atom.Script, atom.Style, atom.Head, atom.Title:
+			return
+		case atom.Br:
+			b.WriteString("\n")
+			return
+		case atom.Li:
+			b.WriteString("\n- ")
+		}
+
+		block := isBlockElement(n.DataAtom)
+		if block {
+			b.WriteString("\n\n")
+		}
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			walk(ch)
+		}
+		if block {
+			b.WriteString("\n\n")
+		} else if n.DataAtom == atom.Td || n.DataAtom == atom.Th {
+			b.WriteString(" ")
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+
+	return cleanLines(b.String()), nil
+}
+
+// htmlToMarkdown converts an HTML body to Markdown.
+func htmlToMarkdown(body string) (string, error) {
+	nodes, err := parseHTML(body)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, n := range nodes {
+		writeMarkdown(&b, n, 0, 0)
+	}
+
+	return cleanLines(b.String()), nil
+}
+
+// writeMarkdown writes the Markdown representation of an HTML node. depth is
+// the list nesting depth and tables is the table nesting depth.
+func writeMarkdown(b *strings.Builder, n *html.Node, depth, tables int) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(reSpaces.ReplaceAllString(n.Data, " "))
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	children := func() string {
+		var s strings.Builder
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			writeMarkdown(&s, ch, depth, tables)
+		}
+		return s.String()
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Head, atom.Title, atom.Noscript, atom.Template:
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		lvl := int(n.Data[1] - '0')
+		b.WriteString("\n\n" + strings.Repeat("#", lvl) + " " + inlineText(children()) + "\n\n")
+
+	case atom.Br:
+		b.WriteString("  \n")
+
+	case atom.Hr:
+		b.WriteString("\n\n---\n\n")
+
+	case atom.Strong, atom.B:
+		b.WriteString(wrapInline(children(), "**"))
+
+	case atom.Em, atom.I:
+		b.WriteString(wrapInline(children(), "_"))
+
+	case atom.Del, atom.S, atom.Strike:
+		b.WriteString(wrapInline(children(), "~~"))
+
+	case atom.Code:
+		b.WriteString(wrapInline(textContent(n), "`"))
+
+	case atom.Pre:
+		b.WriteString("\n\n```\n" + strings.Trim(textContent(n), "\n") + "\n```\n\n")
+
+	case atom.A:
+		text := strings.TrimSpace(children())
+		href := getAttr(n, "href")
+		if href == "" {
+			b.WriteString(text)
+			break
+		}
+		if t := getAttr(n, "title"); t != "" {
+			href += ` "` + t + `"`
+		}
+		b.WriteString("[" + text + "](" + href + ")")
+
+	case atom.Img:
+		b.WriteString("![" + getAttr(n, "alt") + "](" + getAttr(n, "src") + ")")
+
+	case atom.Ul, atom.Ol:
+		b.WriteString("\n\n")
+		i := 1
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			if ch.DataAtom != atom.Li {
+				continue
+			}
+
+			prefix := "- "
+			if n.DataAtom == atom.Ol {
+				prefix = fmt.Sprintf("%d. ", i)
+			}
+			i++
+
+			var s strings.Builder
+			for c := ch.FirstChild; c != nil; c = c.NextSibling {
+				writeMarkdown(&s, c, depth+1, tables)
+			}
+
+			indent := strings.Repeat("  ", depth)
+			lines := strings.Split(strings.TrimSpace(cleanLines(s.String())), "\n")
+			b.WriteString(indent + prefix + lines[0] + "\n")
+			for _, l := range lines[1:] {
+				if l != "" {
+					l = indent + strings.Repeat(" ", len(prefix)) + strings.TrimLeft(l, " ")
+				}
+				b.WriteString(l + "\n")
+			}
+		}
+		b.WriteString("\n")
+
+	case atom.Blockquote:
+		b.WriteString("\n\n")
+		for _, l := range strings.Split(strings.TrimSpace(cleanLines(children())), "\n") {
+			b.WriteString(strings.TrimSpace("> "+l) + "\n")
+		}
+		b.WriteString("\n")
+
+	case atom.Table:
+		// Markdown tables can't be nested. The contents of nested tables are inlined.
+		if tables > 0 {
+			tables++
+			b.WriteString(inlineText(children()))
+			break
+		}
+		writeMarkdownTable(b, n, depth)
+
+	default:
+		s := children()
+		if isBlockElement(n.DataAtom) {
+			s = "\n\n" + s + "\n\n"
+		} else if n.DataAtom == atom.Td || n.DataAtom == atom.Th {
+			s += " "
+		}
+		b.WriteString(s)
+	}
+}
+
+// writeMarkdownTable writes an HTML table as a Markdown table with
+// the first row as the header.
+func writeMarkdownTable(b *strings.Builder, n *html.Node, depth int) {
+	var rows [][]string
+	for _, tr := range findRows(n) {
+		var row []string
+		for c := tr.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom != atom.Td && c.DataAtom != atom.Th {
+				continue
+			}
+
+			var s strings.Builder
+			for ch := c.FirstChild; ch != nil; ch = ch.NextSibling {
+				writeMarkdown(&s, ch, depth, 1)
+			}
+			row = append(row, strings.ReplaceAll(inlineText(s.String()), "|", `\|`))
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	cols := 0
+	for _, r := range rows {
+		if len(r) > cols {
+			cols = len(r)
+		}
+	}
+
+	b.WriteString("\n\n")
+	for i, r := range rows {
+		for len(r) < cols {
+			r = append(r, "")
+		}
+		b.WriteString("| " + strings.Join(r, " | ") + " |\n")
+
+		if i == 0 {
+			b.WriteString(strings.Repeat("| --- ", cols) + "|\n")
+		}
+	}
+	b.WriteString("\n")
+}
+
+// findRows returns the rows of a table excluding the rows of tables nested in it.
+func findRows(n *html.Node) []*html.Node {
+	var out []*html.Node
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		if ch.DataAtom == atom.Tr {
+			out = append(out, ch)
+			continue
+		}
+		if ch.DataAtom != atom.Table {
+			out = append(out, findRows(ch)...)
+		}
+	}
+	return out
+}
+
+// textContent returns the raw text content of a node and its children.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var s strings.Builder
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		s.WriteString(textContent(ch))
+	}
+	return s.String()
+}
+
+// getAttr returns the value of an attribute on an HTML node.
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// wrapInline wraps inline text with a Markdown marker (eg: **bold**)
+// retaining the surrounding whitespace outside the marker.
+func wrapInline(s, marker string) string {
+	t := strings.TrimSpace(s)
+	if t == "" {
+		return s
+	}
+
+	var (
+		pre  = s[:strings.Index(s, t)]
+		post = s[len(pre)+len(t):]
+	)
+	return pre + marker + t + marker + post
+}
+
+// inlineText collapses multi-line text into a single line.
+func inlineText(s string) string {
+	return strings.TrimSpace(reSpaces.ReplaceAllString(s, " "))
+}
+
+// cleanLines trims whitespace around lines and collapses consecutive blank lines.
+func cleanLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		// Retain Markdown hard line breaks (two trailing spaces) and indentation.
+		hard := strings.HasSuffix(l, "  ") && strings.TrimSpace(l) != ""
+		l = strings.TrimRight(l, " \t")
+
+		indent := len(l) - len(strings.TrimLeft(l, " "))
+		l = strings.Repeat(" ", indent) + reLineSpaces.ReplaceAllString(strings.TrimLeft(l, " "), " ")
+		if hard {
+			l += "  "
+		}
+		lines[i] = l
+	}
+
+	return strings.TrimSpace(reNewlines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// isBlockElement checks if an HTML element is rendered as a block.
+func isBlockElement(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Main,
+		atom.Aside, atom.Nav, atom.Center, atom.Table, atom.Tr, atom.Ul, atom.Ol, atom.Dl, atom.Dd, atom.Dt,
+		atom.Blockquote, atom.Pre, atom.Hr, atom.Figure, atom.Figcaption, atom.Address,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return true
+	}
+	return false
+}
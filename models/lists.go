@@ -1,6 +1,12 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/lib/pq"
 	null "gopkg.in/volatiletech/null.v6"
 )
@@ -12,6 +18,19 @@ const (
 	ListOptinDouble    = "double"
 	ListStatusActive   = "active"
 	ListStatusArchived = "archived"
+
+	ListEventSubscriberJoined = "list.subscriber_joined"
+	ListEventSubscriberLeft   = "list.subscriber_left"
+
+	// List auto-assignment rule operators.
+	ListRuleEq     = "eq"
+	ListRuleNeq    = "neq"
+	ListRuleGt     = "gt"
+	ListRuleGte    = "gte"
+	ListRuleLt     = "lt"
+	ListRuleLte    = "lte"
+	ListRuleIn     = "in"
+	ListRuleExists = "exists"
 )
 
 // List represents a mailing list.
@@ -25,10 +44,17 @@ type List struct {
 	Status           string         `db:"status" json:"status"`
 	Tags             pq.StringArray `db:"tags" json:"tags"`
 	Description      string         `db:"description" json:"description"`
+	Sensitive        bool           `db:"sensitive" json:"sensitive"`
+	MaxCampsPerWeek  int            `db:"max_campaigns_per_week" json:"max_campaigns_per_week"`
+	MaxCampsPerMonth int            `db:"max_campaigns_per_month" json:"max_campaigns_per_month"`
 	SubscriberCount  int            `db:"subscriber_count" json:"subscriber_count"`
 	SubscriberCounts StringIntMap   `db:"subscriber_statuses" json:"subscriber_statuses"`
 	SubscriberID     int            `db:"subscriber_id" json:"-"`
 
+	// Subscribers whose attributes match all the rules are automatically
+	// subscribed to the list, and unsubscribed when they no longer match.
+	AutoAssignmentRules ListRules `db:"auto_assignment_rules" json:"auto_assignment_rules"`
+
 	// This is only relevant when querying the lists of a subscriber.
 	SubscriptionStatus    string    `db:"subscription_status" json:"subscription_status,omitempty"`
 	SubscriptionCreatedAt null.Time `db:"subscription_created_at" json:"subscription_created_at,omitempty"`
@@ -38,3 +64,143 @@ type List struct {
 	// in searches and queries.
 	Total int `db:"total" json:"-"`
 }
+
+// ListRule is a condition on a subscriber attribute for automatically
+// assigning subscribers to a list, eg: {"attrib": "plan", "op": "eq", "value": "pro"}.
+type ListRule struct {
+	Attrib string          `json:"attrib"`
+	Op     string          `json:"op"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+// ListRules is a set of list auto-assignment rules that all have to match.
+type ListRules []ListRule
+
+// ListRuleResult is the number of subscriptions changed by evaluating list auto-assignment rules.
+type ListRuleResult struct {
+	Subscribed   int `db:"subscribed" json:"subscribed"`
+	Unsubscribed int `db:"unsubscribed" json:"unsubscribed"`
+}
+
+// ListMergeResult is the number of subscriptions and campaigns moved by merging a list
+// into another. Skipped subscribers were already subscribed to the target list.
+type ListMergeResult struct {
+	Subscribers int `db:"subscribers" json:"subscribers"`
+	Merged      int `db:"merged" json:"merged"`
+	Skipped     int `db:"skipped" json:"skipped"`
+	Campaigns   int `db:"campaigns" json:"campaigns"`
+}
+
+// ListEvent is a subscriber joining (list.subscriber_joined) or leaving (list.subscriber_left)
+// a list that's posted to the list events webhook.
+type ListEvent struct {
+	Event        string          `db:"event" json:"event"`
+	Subscriber   json.RawMessage `db:"subscriber" json:"subscriber"`
+	List         json.RawMessage `db:"list" json:"list"`
+	Subscription json.RawMessage `db:"subscription" json:"subscription"`
+	CreatedAt    time.Time       `db:"created_at" json:"created_at"`
+
+	SubscriberID int `db:"subscriber_id" json:"-"`
+	ListID       int `db:"list_id" json:"-"`
+}
+
+// Validate checks the attributes, operators, and values of the rules.
+func (r ListRules) Validate() error {
+	for _, l := range r {
+		if strings.TrimSpace(l.Attrib) == "" {
+			return fmt.Errorf("rule has no attribute")
+		}
+
+		switch l.Op {
+		case ListRuleExists:
+			continue
+		case ListRuleEq, ListRuleNeq, ListRuleGt, ListRuleGte, ListRuleLt, ListRuleLte, ListRuleIn:
+		default:
+			return fmt.Errorf("rule on '%s' has an invalid operator '%s'", l.Attrib, l.Op)
+		}
+
+		var v any
+		if len(l.Value) == 0 || json.Unmarshal(l.Value, &v) != nil || v == nil {
+			return fmt.Errorf("rule on '%s' has no value", l.Attrib)
+		}
+		if _, ok := v.([]any); ok != (l.Op == ListRuleIn) {
+			return fmt.Errorf("rule on '%s' has an invalid value", l.Attrib)
+		}
+	}
+
+	return nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (r *ListRules) Scan(src any) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		return nil
+	}
+
+	return json.Unmarshal(b, r)
+}
+
+// Value implements the driver.Valuer interface.
+func (r ListRules) Value() (driver.Value, error) {
+	if len(r) == 0 {
+		return "[]", nil
+	}
+
+	return json.Marshal(r)
+}
+
+// ListSendCount is the number of campaigns sent or scheduled to a list with
+// send frequency limits in the calendar week and month of a campaign's send time.
+type ListSendCount struct {
+	ID               int    `db:"id" json:"id"`
+	Name             string `db:"name" json:"name"`
+	MaxCampsPerWeek  int    `db:"max_campaigns_per_week" json:"max_campaigns_per_week"`
+	MaxCampsPerMonth int    `db:"max_campaigns_per_month" json:"max_campaigns_per_month"`
+	WeekCount        int    `db:"week_count" json:"week_count"`
+	MonthCount       int    `db:"month_count" json:"month_count"`
+}
+
+// SuppressionImport is the summary of a suppression list import. Suppressed is the
+// number of subscribers that were blocklisted, Existing, the number that already were,
+// and NotFound, the number of e-mails that didn't match a subscriber.
+type SuppressionImport struct {
+	Total      int `json:"total"`
+	Suppressed int `json:"suppressed"`
+	Existing   int `json:"existing"`
+	NotFound   int `json:"not_found"`
+	Invalid    int `json:"invalid"`
+}
+
+// ListOverlap represents the subscriber overlap between a set of lists.
+// Matrix[i][j] is the number of subscribers common to Lists[i] and Lists[j]
+// and Matrix[i][i] is the number of subscribers in Lists[i].
+type ListOverlap struct {
+	Lists  []ListOverlapItem `json:"lists"`
+	Matrix [][]int           `json:"matrix"`
+}
+
+// ListOverlapItem represents a list in a list overlap along with the
+// number of its subscribers who are in none of the other lists.
+type ListOverlapItem struct {
+	ID              int    `db:"id" json:"id"`
+	Name            string `db:"name" json:"name"`
+	SubscriberCount int    `db:"subscriber_count" json:"subscriber_count"`
+	UniqueCount     int    `db:"unique_count" json:"unique_count"`
+}
+
+// LandingPage is a standalone HTML subscription page generated for a public list.
+type LandingPage struct {
+	ID        int       `db:"id" json:"id"`
+	UUID      string    `db:"uuid" json:"uuid"`
+	ListID    int       `db:"list_id" json:"list_id"`
+	CustomCSS string    `db:"custom_css" json:"custom_css"`
+	Body      string    `db:"body" json:"-"`
+	CreatedAt null.Time `db:"created_at" json:"created_at"`
+	UpdatedAt null.Time `db:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// Types of issues in a Markdown body.
+const (
+	MarkdownIssueUnclosedFence = "unclosed_fence"
+	MarkdownIssueEmptyLink     = "empty_link"
+	MarkdownIssueUndefinedRef  = "undefined_reference"
+)
+
+var (
+	reMDFence     = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})(.*)$")
+	reMDCodeSpan  = regexp.MustCompile("`+[^`]*`+")
+	reMDEmptyLink = regexp.MustCompile(`!?\[[^\]]*\]\(\s*\)`)
+	reMDRefLink   = regexp.MustCompile(`\[([^\]]+)\]\[([^\]]*)\]`)
+	reMDRefDef    = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:\s*\S`)
+)
+
+// MarkdownIssue represents a syntax issue in a Markdown body. Markdown
+// never fails to parse, but these constructs render differently from
+// what was most likely intended.
+type MarkdownIssue struct {
+	Type string `json:"type"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// MarkdownToHTML converts a Markdown body to HTML with the same parser and
+// renderer that's used to render Markdown campaigns.
+func MarkdownToHTML(body string) (string, error) {
+	var b bytes.Buffer
+	if err := markdown.Convert([]byte(body), &b); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// ValidateMarkdown checks a Markdown body for code fences that are never
+// closed (swallowing the rest of the body), links and images without a URL,
+// and reference links whose reference isn't defined.
+func ValidateMarkdown(body string) []MarkdownIssue {
+	var (
+		lines = strings.Split(body, "\n")
+		out   = []MarkdownIssue{}
+
+		// Reference definitions, lowercased, as references are case-insensitive.
+		defs = map[string]bool{}
+	)
+
+	// Collect the reference definitions and the lines that are outside code blocks.
+	var (
+		fence     string
+		fenceLine int
+		text      = make([]bool, len(lines))
+	)
+	for i, l := range lines {
+		l = strings.TrimRight(l, "\r")
+		lines[i] = l
+
+		if m := reMDFence.FindStringSubmatch(l); m != nil {
+			switch {
+			case fence == "":
+				fence, fenceLine = m[1], i
+				continue
+			// A closing fence is of the same character, at least as long as the
+			// opening one, and has no info string.
+			case m[1][0] == fence[0] && len(m[1]) >= len(fence) && strings.TrimSpace(m[2]) == "":
+				fence = ""
+				continue
+			}
+		}
+		if fence != "" {
+			continue
+		}
+
+		text[i] = true
+		if m := reMDRefDef.FindStringSubmatch(l); m != nil {
+			defs[normalizeMDLabel(m[1])] = true
+		}
+	}
+
+	if fence != "" {
+		out = append(out, MarkdownIssue{
+			Type: MarkdownIssueUnclosedFence,
+			Line: fenceLine + 1,
+			Text: strings.TrimSpace(lines[fenceLine]),
+		})
+	}
+
+	for i, l := range lines {
+		if !text[i] || reMDRefDef.MatchString(l) {
+			continue
+		}
+
+		// Ignore anything inside inline code spans.
+		l = reMDCodeSpan.ReplaceAllString(l, "")
+
+		for _, m := range reMDEmptyLink.FindAllString(l, -1) {
+			out = append(out, MarkdownIssue{Type: MarkdownIssueEmptyLink, Line: i + 1, Text: m})
+		}
+
+		for _, m := range reMDRefLink.FindAllStringSubmatch(l, -1) {
+			// A collapsed reference, [text][], uses the text as the label.
+			label := m[2]
+			if strings.TrimSpace(label) == "" {
+				label = m[1]
+			}
+			if !defs[normalizeMDLabel(label)] {
+				out = append(out, MarkdownIssue{Type: MarkdownIssueUndefinedRef, Line: i + 1, Text: m[0]})
+			}
+		}
+	}
+
+	return out
+}
+
+// normalizeMDLabel normalizes a reference label for case-insensitive matching.
+func normalizeMDLabel(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
@@ -2,12 +2,25 @@ package models
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/textproto"
 	txttpl "text/template"
 )
 
+// ErrUnsupportedRecipient is returned (wrapped) by messengers when a message
+// can't be delivered to a recipient's address through the messenger at all, for
+// instance, an internationalized address through an SMTP server that doesn't
+// support SMTPUTF8. Such failures are specific to the recipient and are skipped
+// without counting towards a campaign's error threshold.
+var ErrUnsupportedRecipient = errors.New("unsupported recipient address")
+
+// ErrTemporaryFailure is returned (wrapped) by messengers when a message couldn't
+// be sent due to a temporary failure that may succeed on retrying, for instance,
+// a 4xx SMTP response (eg: 421 Too many connections).
+var ErrTemporaryFailure = errors.New("temporary failure")
+
 // Message is the message pushed to a Messenger.
 type Message struct {
 	From        string
@@ -19,6 +32,10 @@ type Message struct {
 	Headers     textproto.MIMEHeader
 	Attachments []Attachment
 
+	// Bcc is a list of envelope-only recipients that are not added
+	// to the message headers, eg: a journaling (archive) mailbox.
+	Bcc []string
+
 	Subscriber Subscriber
 
 	// Campaign is generally the same instance for a large number of subscribers.
@@ -28,6 +45,20 @@ type Message struct {
 	Messenger string
 }
 
+// WarmupStep is the maximum number of messages that a messenger sends on a day of
+// its warm-up schedule. Days are counted from the first day the messenger sent messages.
+type WarmupStep struct {
+	Day      int `json:"day"`
+	MaxSends int `json:"max_sends"`
+}
+
+// MessengerDailyStats represents the warm-up day of a messenger and the number of
+// messages it has sent on the day.
+type MessengerDailyStats struct {
+	Day  int `db:"day"`
+	Sent int `db:"sent"`
+}
+
 // Attachment represents a file or blob attachment that can be
 // sent along with a message by a Messenger.
 type Attachment struct {
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	NotificationCampaignFailure = "campaign_failure"
+	NotificationBounceSpike     = "bounce_spike"
+	NotificationIPBounceSpike   = "ip_bounce_spike"
+	NotificationNewLogin        = "new_login"
+	NotificationDBPool          = "db_pool"
+	NotificationWarmupLimit     = "warmup_limit"
+
+	NotificationChannelEmail   = "email"
+	NotificationChannelWebhook = "webhook"
+)
+
+// Notification represents an admin notification sent out for a critical event.
+type Notification struct {
+	ID        int64           `db:"id" json:"id"`
+	Type      string          `db:"type" json:"type"`
+	Subject   string          `db:"subject" json:"subject"`
+	Data      json.RawMessage `db:"data" json:"data"`
+	Channels  pq.StringArray  `db:"channels" json:"channels"`
+	Error     null.String     `db:"error" json:"error"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+
+	// Pseudofield for getting the total number of notifications
+	// in searches and queries.
+	Total int `db:"total" json:"-"`
+}
+
+// CampaignBounceRate represents the bounces recorded on a campaign within
+// a period as a percentage of the messages sent by the campaign.
+type CampaignBounceRate struct {
+	CampaignID int     `db:"campaign_id" json:"campaign_id"`
+	Name       string  `db:"name" json:"name"`
+	Sent       int     `db:"sent" json:"sent"`
+	Bounces    int     `db:"bounces" json:"bounces"`
+	Rate       float64 `db:"rate" json:"rate"`
+}
+
+// IPBounceCount represents the bounces recorded within a period that originated
+// from an IP address.
+type IPBounceCount struct {
+	IP      string `json:"ip"`
+	Bounces int    `json:"bounces"`
+}
@@ -11,101 +11,221 @@ import (
 
 // Queries contains all prepared SQL queries.
 type Queries struct {
-	GetDashboardCharts *sqlx.Stmt `query:"get-dashboard-charts"`
-	GetDashboardCounts *sqlx.Stmt `query:"get-dashboard-counts"`
+	GetDashboardCharts  *sqlx.Stmt `query:"get-dashboard-charts"`
+	GetDashboardCounts  *sqlx.Stmt `query:"get-dashboard-counts"`
+	GetCampaignCalendar *sqlx.Stmt `query:"get-campaign-calendar"`
 
 	InsertSubscriber                *sqlx.Stmt `query:"insert-subscriber"`
 	UpsertSubscriber                *sqlx.Stmt `query:"upsert-subscriber"`
 	UpsertBlocklistSubscriber       *sqlx.Stmt `query:"upsert-blocklist-subscriber"`
 	GetSubscriber                   *sqlx.Stmt `query:"get-subscriber"`
+	GetSubscriberByToken            *sqlx.Stmt `query:"get-subscriber-by-token"`
 	HasSubscriberLists              *sqlx.Stmt `query:"has-subscriber-list"`
 	GetSubscribersByEmails          *sqlx.Stmt `query:"get-subscribers-by-emails"`
+	GetSubscriberEmails             *sqlx.Stmt `query:"get-subscriber-emails"`
 	GetSubscriberLists              *sqlx.Stmt `query:"get-subscriber-lists"`
 	GetSubscriptions                *sqlx.Stmt `query:"get-subscriptions"`
 	GetSubscriberListsLazy          *sqlx.Stmt `query:"get-subscriber-lists-lazy"`
 	UpdateSubscriber                *sqlx.Stmt `query:"update-subscriber"`
 	UpdateSubscriberWithLists       *sqlx.Stmt `query:"update-subscriber-with-lists"`
 	BlocklistSubscribers            *sqlx.Stmt `query:"blocklist-subscribers"`
+	BlocklistSubscribersByEmails    *sqlx.Stmt `query:"blocklist-subscribers-by-emails"`
+	SuppressSubscribers             *sqlx.Stmt `query:"suppress-subscribers"`
+	UpdateSubscribersStatus         *sqlx.Stmt `query:"update-subscribers-status"`
+	HasSensitiveLists               *sqlx.Stmt `query:"has-sensitive-lists"`
+	HasAnySensitiveList             *sqlx.Stmt `query:"has-any-sensitive-list"`
+	GetSensitivePlainAttribs        *sqlx.Stmt `query:"get-sensitive-plain-attribs"`
+	GetPlainAttribs                 *sqlx.Stmt `query:"get-plain-attribs"`
+	UpdateSubscriberAttribs         *sqlx.Stmt `query:"update-subscriber-attribs"`
 	AddSubscribersToLists           *sqlx.Stmt `query:"add-subscribers-to-lists"`
 	DeleteSubscriptions             *sqlx.Stmt `query:"delete-subscriptions"`
 	DeleteUnconfirmedSubscriptions  *sqlx.Stmt `query:"delete-unconfirmed-subscriptions"`
 	ConfirmSubscriptionOptin        *sqlx.Stmt `query:"confirm-subscription-optin"`
+	DeclineSubscriptionOptin        *sqlx.Stmt `query:"decline-subscription-optin"`
 	UnsubscribeSubscribersFromLists *sqlx.Stmt `query:"unsubscribe-subscribers-from-lists"`
 	DeleteSubscribers               *sqlx.Stmt `query:"delete-subscribers"`
+	AnonymizeSubscribers            *sqlx.Stmt `query:"anonymize-subscribers"`
 	DeleteBlocklistedSubscribers    *sqlx.Stmt `query:"delete-blocklisted-subscribers"`
 	DeleteOrphanSubscribers         *sqlx.Stmt `query:"delete-orphan-subscribers"`
 	UnsubscribeByCampaign           *sqlx.Stmt `query:"unsubscribe-by-campaign"`
+	GetResubscribeProtected         *sqlx.Stmt `query:"get-resubscribe-protected"`
 	ExportSubscriberData            *sqlx.Stmt `query:"export-subscriber-data"`
 	GetSubscriberActivity           *sqlx.Stmt `query:"get-subscriber-activity"`
+	GetSubscriberSends              *sqlx.Stmt `query:"get-subscriber-sends"`
+	GetSubscriberCampaignHistory    *sqlx.Stmt `query:"get-subscriber-campaign-history"`
+	InsertAttribChange              *sqlx.Stmt `query:"insert-attrib-change"`
+	GetAttribChangelog              *sqlx.Stmt `query:"get-attrib-changelog"`
+	GetSubscriberAttribStats        *sqlx.Stmt `query:"get-subscriber-attrib-stats"`
+	GetPublicSubscriptionStatus     *sqlx.Stmt `query:"get-public-subscription-status"`
+	GetDisengagedSubscriberCounts   *sqlx.Stmt `query:"get-disengaged-subscriber-counts"`
+	GetDisengagedSubscribers        *sqlx.Stmt `query:"get-disengaged-subscribers"`
+	GetSubscriberGrowth             *sqlx.Stmt `query:"get-subscriber-growth"`
+	GetNewSubscriptions             *sqlx.Stmt `query:"get-new-subscriptions"`
+	InsertImportArchive             *sqlx.Stmt `query:"insert-import-archive"`
+	GetImportArchive                *sqlx.Stmt `query:"get-import-archive"`
+	GetExpiredImportArchives        *sqlx.Stmt `query:"get-expired-import-archives"`
+	DeleteImportArchive             *sqlx.Stmt `query:"delete-import-archive"`
+	CreateReconfirmations           *sqlx.Stmt `query:"create-reconfirmations"`
+	GetReconfirmation               *sqlx.Stmt `query:"get-reconfirmation"`
+	ConfirmReconfirmation           *sqlx.Stmt `query:"confirm-reconfirmation"`
+	ExpireReconfirmations           *sqlx.Stmt `query:"expire-reconfirmations"`
+	InsertConsents                  *sqlx.Stmt `query:"insert-consents"`
+	GetSubscriberConsents           *sqlx.Stmt `query:"get-subscriber-consents"`
 
 	// Non-prepared arbitrary subscriber queries.
 	QuerySubscribers                       string     `query:"query-subscribers"`
 	QuerySubscribersCount                  string     `query:"query-subscribers-count"`
 	QuerySubscribersCountAll               *sqlx.Stmt `query:"query-subscribers-count-all"`
-	QuerySubscribersForExport              string     `query:"query-subscribers-for-export"`
+	CopyListSubscribers                    string     `query:"copy-list-subscribers"`
 	QuerySubscribersTpl                    string     `query:"query-subscribers-template"`
 	DeleteSubscribersByQuery               string     `query:"delete-subscribers-by-query"`
+	QuerySubscriberIDsByQuery              string     `query:"query-subscriber-ids-by-query"`
 	AddSubscribersToListsByQuery           string     `query:"add-subscribers-to-lists-by-query"`
 	BlocklistSubscribersByQuery            string     `query:"blocklist-subscribers-by-query"`
 	DeleteSubscriptionsByQuery             string     `query:"delete-subscriptions-by-query"`
 	UnsubscribeSubscribersFromListsByQuery string     `query:"unsubscribe-subscribers-from-lists-by-query"`
 
-	CreateList      *sqlx.Stmt `query:"create-list"`
-	QueryLists      string     `query:"query-lists"`
-	GetLists        *sqlx.Stmt `query:"get-lists"`
-	GetListsByOptin *sqlx.Stmt `query:"get-lists-by-optin"`
-	GetListTypes    *sqlx.Stmt `query:"get-list-types"`
-	UpdateList      *sqlx.Stmt `query:"update-list"`
-	UpdateListsDate *sqlx.Stmt `query:"update-lists-date"`
-	DeleteLists     *sqlx.Stmt `query:"delete-lists"`
-
-	CreateCampaign        *sqlx.Stmt `query:"create-campaign"`
-	QueryCampaigns        string     `query:"query-campaigns"`
-	GetCampaign           *sqlx.Stmt `query:"get-campaign"`
-	GetCampaignForPreview *sqlx.Stmt `query:"get-campaign-for-preview"`
-	GetCampaignStats      *sqlx.Stmt `query:"get-campaign-stats"`
-	GetCampaignStatus     *sqlx.Stmt `query:"get-campaign-status"`
-	GetArchivedCampaigns  *sqlx.Stmt `query:"get-archived-campaigns"`
-	CampaignHasLists      *sqlx.Stmt `query:"campaign-has-lists"`
+	CreateList        *sqlx.Stmt `query:"create-list"`
+	QueryLists        string     `query:"query-lists"`
+	GetLists          *sqlx.Stmt `query:"get-lists"`
+	GetListsByOptin   *sqlx.Stmt `query:"get-lists-by-optin"`
+	GetListSubCount   *sqlx.Stmt `query:"get-list-subscriber-count"`
+	UpsertLandingPage *sqlx.Stmt `query:"upsert-landing-page"`
+	GetLandingPage    *sqlx.Stmt `query:"get-landing-page"`
+	GetListTypes      *sqlx.Stmt `query:"get-list-types"`
+	UpdateList        *sqlx.Stmt `query:"update-list"`
+	UpdateListsDate   *sqlx.Stmt `query:"update-lists-date"`
+	DeleteLists       *sqlx.Stmt `query:"delete-lists"`
+	ApplyListRules    *sqlx.Stmt `query:"apply-list-rules"`
+	MergeLists        *sqlx.Stmt `query:"merge-lists"`
+	GetListEvents     *sqlx.Stmt `query:"get-list-events"`
+
+	GetListsOverlap           *sqlx.Stmt `query:"get-lists-overlap"`
+	GetListsUniqueSubscribers *sqlx.Stmt `query:"get-lists-unique-subscribers"`
+
+	GetTopics              *sqlx.Stmt `query:"get-topics"`
+	CreateTopic            *sqlx.Stmt `query:"create-topic"`
+	UpdateTopic            *sqlx.Stmt `query:"update-topic"`
+	DeleteTopic            *sqlx.Stmt `query:"delete-topic"`
+	GetSubscriberTopics    *sqlx.Stmt `query:"get-subscriber-topics"`
+	UpdateSubscriberTopics *sqlx.Stmt `query:"update-subscriber-topics"`
+
+	GetSegments              *sqlx.Stmt `query:"get-segments"`
+	CreateSegment            *sqlx.Stmt `query:"create-segment"`
+	UpdateSegment            *sqlx.Stmt `query:"update-segment"`
+	ApproveSegment           *sqlx.Stmt `query:"approve-segment"`
+	DeleteSegment            *sqlx.Stmt `query:"delete-segment"`
+	FilterSegmentSubscribers string     `query:"filter-segment-subscribers"`
+
+	CreateCampaign            *sqlx.Stmt `query:"create-campaign"`
+	QueryCampaigns            string     `query:"query-campaigns"`
+	GetCampaign               *sqlx.Stmt `query:"get-campaign"`
+	GetCampaignForPreview     *sqlx.Stmt `query:"get-campaign-for-preview"`
+	GetCampaignAudienceCount  *sqlx.Stmt `query:"get-campaign-audience-count"`
+	GetCampaignSubOverlap     *sqlx.Stmt `query:"get-campaign-subscriber-overlap"`
+	GetCampaignSendReport     *sqlx.Stmt `query:"get-campaign-send-report"`
+	GetCampaignQueuePosition  *sqlx.Stmt `query:"get-campaign-queue-position"`
+	GetCampaignAudienceTrend  *sqlx.Stmt `query:"get-campaign-audience-trend"`
+	GetCampaignListSendCounts *sqlx.Stmt `query:"get-campaign-list-send-counts"`
+	GetCampaignStats          *sqlx.Stmt `query:"get-campaign-stats"`
+	GetCampaignStatus         *sqlx.Stmt `query:"get-campaign-status"`
+	GetArchivedCampaigns      *sqlx.Stmt `query:"get-archived-campaigns"`
+	CampaignHasLists          *sqlx.Stmt `query:"campaign-has-lists"`
+
+	FreezeCampaignAudience                    *sqlx.Stmt `query:"freeze-campaign-audience"`
+	UnfreezeCampaignAudience                  *sqlx.Stmt `query:"unfreeze-campaign-audience"`
+	GetCampaignAudienceSnapshot               *sqlx.Stmt `query:"get-campaign-audience-snapshot"`
+	DeleteCampaignAudienceSnapshotSubscribers *sqlx.Stmt `query:"delete-campaign-audience-snapshot-subscribers"`
 
 	// These two queries are read as strings and based on settings.individual_tracking=on/off,
 	// are interpolated and copied to view and click counts. Same query, different tables.
-	GetCampaignAnalyticsCounts string     `query:"get-campaign-analytics-counts"`
-	GetCampaignViewCounts      *sqlx.Stmt `query:"get-campaign-view-counts"`
-	GetCampaignClickCounts     *sqlx.Stmt `query:"get-campaign-click-counts"`
-	GetCampaignLinkCounts      *sqlx.Stmt `query:"get-campaign-link-counts"`
-	GetCampaignBounceCounts    *sqlx.Stmt `query:"get-campaign-bounce-counts"`
-	DeleteCampaignViews        *sqlx.Stmt `query:"delete-campaign-views"`
-	DeleteCampaignLinkClicks   *sqlx.Stmt `query:"delete-campaign-link-clicks"`
-	ExportCampaignViews        *sqlx.Stmt `query:"export-campaign-views"`
-	ExportCampaignLinkClicks   *sqlx.Stmt `query:"export-campaign-link-clicks"`
+	GetCampaignAnalyticsCounts  string     `query:"get-campaign-analytics-counts"`
+	GetCampaignViewCounts       *sqlx.Stmt `query:"get-campaign-view-counts"`
+	GetCampaignClickCounts      *sqlx.Stmt `query:"get-campaign-click-counts"`
+	GetCampaignLinkCounts       *sqlx.Stmt `query:"get-campaign-link-counts"`
+	GetCampaignBounceCounts     *sqlx.Stmt `query:"get-campaign-bounce-counts"`
+	GetCampaignBounceTypes      *sqlx.Stmt `query:"get-campaign-bounce-type-counts"`
+	GetCampaignFunnelCounts     *sqlx.Stmt `query:"get-campaign-funnel-counts"`
+	GetCampaignViewEvents       *sqlx.Stmt `query:"get-campaign-view-events"`
+	GetCampaignClickEvents      *sqlx.Stmt `query:"get-campaign-click-events"`
+	GetCampaignBounceEvents     *sqlx.Stmt `query:"get-campaign-bounce-events"`
+	GetCampaignUnsubEvents      *sqlx.Stmt `query:"get-campaign-unsubscribe-events"`
+	GetCampaignUnsubClickEvents *sqlx.Stmt `query:"get-campaign-unsubscribe-click-events"`
+	RegisterUnsubscribeClick    *sqlx.Stmt `query:"register-unsubscribe-click"`
+	RecordCampaignDeliveries    *sqlx.Stmt `query:"record-campaign-deliveries"`
+	DeleteCampaignViews         *sqlx.Stmt `query:"delete-campaign-views"`
+	DeleteCampaignLinkClicks    *sqlx.Stmt `query:"delete-campaign-link-clicks"`
+	ExportCampaignViews         *sqlx.Stmt `query:"export-campaign-views"`
+	ExportCampSubAnalytics      *sqlx.Stmt `query:"export-campaign-subscriber-analytics"`
+	ExportCampaignLinkClicks    *sqlx.Stmt `query:"export-campaign-link-clicks"`
+	GetEngagementHeatmap        *sqlx.Stmt `query:"get-engagement-heatmap"`
+	GetSubscriberCohorts        *sqlx.Stmt `query:"get-subscriber-cohorts"`
+	GetSendFrequency            *sqlx.Stmt `query:"get-send-frequency"`
+	RecordCampaignSendFailure   *sqlx.Stmt `query:"record-campaign-send-failure"`
+	RecordCampaignSends         *sqlx.Stmt `query:"record-campaign-sends"`
+	GetMessengerDailyStats      *sqlx.Stmt `query:"get-messenger-daily-stats"`
+	RecordMessengerSends        *sqlx.Stmt `query:"record-messenger-sends"`
+	DeleteCampaignSendFailure   *sqlx.Stmt `query:"delete-campaign-send-failure"`
+	RetryCampaignSendFailures   *sqlx.Stmt `query:"retry-campaign-send-failures"`
+	GetCampaignTestCohort       *sqlx.Stmt `query:"get-campaign-test-cohort"`
+	GetComparableCampaigns      *sqlx.Stmt `query:"get-comparable-campaigns"`
 
 	NextCampaigns            *sqlx.Stmt `query:"next-campaigns"`
 	GetRunningCampaign       *sqlx.Stmt `query:"get-running-campaign"`
+	GetCampaignLists         *sqlx.Stmt `query:"get-campaign-lists"`
 	NextCampaignSubscribers  *sqlx.Stmt `query:"next-campaign-subscribers"`
 	GetOneCampaignSubscriber *sqlx.Stmt `query:"get-one-campaign-subscriber"`
 	UpdateCampaign           *sqlx.Stmt `query:"update-campaign"`
 	UpdateCampaignStatus     *sqlx.Stmt `query:"update-campaign-status"`
+	UpdateCampaignWave       *sqlx.Stmt `query:"update-campaign-wave"`
+	UpdateCampaignRemoteBody *sqlx.Stmt `query:"update-campaign-remote-body"`
+	QueueCampaigns           *sqlx.Stmt `query:"queue-campaigns"`
+	QueueCampaign            *sqlx.Stmt `query:"queue-campaign"`
+	GetCampaignTimezones     *sqlx.Stmt `query:"get-campaign-timezones"`
+	GetCampaignStatuses      *sqlx.Stmt `query:"get-campaign-statuses"`
+	UpdateCampaignGate       *sqlx.Stmt `query:"update-campaign-gate"`
+	GetPendingGateCampaigns  *sqlx.Stmt `query:"get-pending-gate-campaigns"`
+	UpdateCampaignsStatus    *sqlx.Stmt `query:"update-campaigns-status"`
 	UpdateCampaignCounts     *sqlx.Stmt `query:"update-campaign-counts"`
 	UpdateCampaignArchive    *sqlx.Stmt `query:"update-campaign-archive"`
 	RegisterCampaignView     *sqlx.Stmt `query:"register-campaign-view"`
+	GetCampaignClientReport  *sqlx.Stmt `query:"get-campaign-client-report"`
+	GetCampaignISPReport     *sqlx.Stmt `query:"get-campaign-isp-report"`
 	DeleteCampaign           *sqlx.Stmt `query:"delete-campaign"`
 	DeleteCampaigns          *sqlx.Stmt `query:"delete-campaigns"`
 
-	InsertMedia *sqlx.Stmt `query:"insert-media"`
-	GetMedia    *sqlx.Stmt `query:"get-media"`
-	QueryMedia  *sqlx.Stmt `query:"query-media"`
-	DeleteMedia *sqlx.Stmt `query:"delete-media"`
+	UpdateCampaignRenderStats    *sqlx.Stmt `query:"update-campaign-render-stats"`
+	CheckCampaignArchivePassword *sqlx.Stmt `query:"check-campaign-archive-password"`
+
+	SwapCampaignContent   *sqlx.Stmt `query:"swap-campaign-content"`
+	GetCampaignRevision   *sqlx.Stmt `query:"get-campaign-revision"`
+	ApplyCampaignRevision *sqlx.Stmt `query:"apply-campaign-revision"`
+	GetCampaignRevisions  *sqlx.Stmt `query:"get-campaign-revisions"`
+	InsertCampaignEvent   *sqlx.Stmt `query:"insert-campaign-event"`
+
+	UpdateCampaignSurvey *sqlx.Stmt `query:"update-campaign-survey"`
+	RecordSurveyResponse *sqlx.Stmt `query:"record-survey-response"`
+	GetSurveyResults     *sqlx.Stmt `query:"get-survey-results"`
+
+	InsertMedia        *sqlx.Stmt `query:"insert-media"`
+	GetMedia           *sqlx.Stmt `query:"get-media"`
+	GetMediaByChecksum *sqlx.Stmt `query:"get-media-by-checksum"`
+	QueryMedia         *sqlx.Stmt `query:"query-media"`
+	DeleteMedia        *sqlx.Stmt `query:"delete-media"`
 
 	CreateTemplate     *sqlx.Stmt `query:"create-template"`
+	CreateLibTemplate  *sqlx.Stmt `query:"create-library-template"`
 	GetTemplates       *sqlx.Stmt `query:"get-templates"`
 	UpdateTemplate     *sqlx.Stmt `query:"update-template"`
 	SetDefaultTemplate *sqlx.Stmt `query:"set-default-template"`
 	DeleteTemplate     *sqlx.Stmt `query:"delete-template"`
 
-	CreateLink        *sqlx.Stmt `query:"create-link"`
-	GetLinkURL        *sqlx.Stmt `query:"get-link-url"`
-	RegisterLinkClick *sqlx.Stmt `query:"register-link-click"`
+	GetLatestTemplateVersion *sqlx.Stmt `query:"get-latest-template-version"`
+
+	CreateLink             *sqlx.Stmt `query:"create-link"`
+	GetLinkURL             *sqlx.Stmt `query:"get-link-url"`
+	RegisterLinkClick      *sqlx.Stmt `query:"register-link-click"`
+	RegisterLinkConversion *sqlx.Stmt `query:"register-link-conversion"`
 
 	GetSettings         *sqlx.Stmt `query:"get-settings"`
 	UpdateSettings      *sqlx.Stmt `query:"update-settings"`
@@ -117,12 +237,23 @@ type Queries struct {
 	BlocklistBouncedSubscribers *sqlx.Stmt `query:"blocklist-bounced-subscribers"`
 	DeleteBounces               *sqlx.Stmt `query:"delete-bounces"`
 	DeleteBouncesBySubscriber   *sqlx.Stmt `query:"delete-bounces-by-subscriber"`
+	GetCampaignBounceRates      *sqlx.Stmt `query:"get-campaign-bounce-rates"`
+	GetBounceReceivedHeaders    *sqlx.Stmt `query:"get-bounce-received-headers"`
+	InsertIPReputationStats     *sqlx.Stmt `query:"insert-ip-reputation-stats"`
+	GetBounceReasonCounts       *sqlx.Stmt `query:"get-bounce-reason-counts"`
+	GetBounceDomainCounts       *sqlx.Stmt `query:"get-bounce-domain-counts"`
 	GetDBInfo                   string     `query:"get-db-info"`
+	TakeRateLimitToken          *sqlx.Stmt `query:"take-rate-limit-token"`
+	DeleteStaleRateLimits       *sqlx.Stmt `query:"delete-stale-rate-limits"`
+
+	InsertNotification *sqlx.Stmt `query:"insert-notification"`
+	QueryNotifications *sqlx.Stmt `query:"query-notifications"`
 
 	CreateUser         *sqlx.Stmt `query:"create-user"`
 	UpdateUser         *sqlx.Stmt `query:"update-user"`
 	UpdateUserProfile  *sqlx.Stmt `query:"update-user-profile"`
 	UpdateUserLogin    *sqlx.Stmt `query:"update-user-login"`
+	RecordUserLoginIP  *sqlx.Stmt `query:"record-user-login-ip"`
 	SetUserTwoFA       *sqlx.Stmt `query:"set-user-twofa"`
 	DeleteUsers        *sqlx.Stmt `query:"delete-users"`
 	GetUsers           *sqlx.Stmt `query:"get-users"`
@@ -193,3 +324,21 @@ func (q *Queries) ExecSubQueryTpl(searchStr, queryExp, baseQueryTpl string, list
 	}
 	return nil
 }
+
+// SelectSubQueryTpl is like ExecSubQueryTpl but scans the rows returned by the
+// combined query into dest.
+func (q *Queries) SelectSubQueryTpl(dest any, searchStr, queryExp, baseQueryTpl string, listIDs []int, db *sqlx.DB, subStatus string, args ...any) error {
+	filterExp, err := q.compileSubscriberQueryTpl(searchStr, queryExp, db, subStatus)
+	if err != nil {
+		return err
+	}
+
+	if len(listIDs) == 0 {
+		listIDs = []int{}
+	}
+
+	stmt := strings.ReplaceAll(baseQueryTpl, "%query%", filterExp)
+	a := append([]any{false, pq.Array(listIDs), subStatus, searchStr}, args...)
+
+	return db.Select(dest, stmt, a...)
+}
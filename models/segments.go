@@ -0,0 +1,372 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Segment param types.
+const (
+	SegmentParamInt    = "int"
+	SegmentParamString = "string"
+	SegmentParamDate   = "date"
+	SegmentParamEnum   = "enum"
+)
+
+// SegmentDateFormat is the format of date params.
+const SegmentDateFormat = "2006-01-02"
+
+var reSegmentParam = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// segmentParamCasts are the Postgres types that the positional arguments of
+// each param type are cast to.
+var segmentParamCasts = map[string]string{
+	SegmentParamInt:    "BIGINT",
+	SegmentParamString: "TEXT",
+	SegmentParamDate:   "DATE",
+	SegmentParamEnum:   "TEXT",
+}
+
+// Segment represents a saved, reusable subscriber query. The query is an SQL
+// expression (like the one in the advanced subscriber query) that can reference
+// typed params as :name placeholders, which are bound to positional arguments
+// when the segment is executed, and never interpolated into the query.
+//
+// A segment can only be used by users other than its creator once it has been
+// approved. Changing the query or params of a segment revokes its approval.
+type Segment struct {
+	Base
+
+	Name        string        `db:"name" json:"name"`
+	Description string        `db:"description" json:"description"`
+	Query       string        `db:"query" json:"query"`
+	Params      SegmentParams `db:"params" json:"params"`
+	CreatedBy   null.Int      `db:"created_by" json:"created_by"`
+	Approved    bool          `db:"approved" json:"approved"`
+	ApprovedBy  null.Int      `db:"approved_by" json:"approved_by"`
+	ApprovedAt  null.Time     `db:"approved_at" json:"approved_at"`
+
+	// Names of the users who created and approved the segment.
+	CreatedByName  null.String `db:"created_by_name" json:"created_by_name"`
+	ApprovedByName null.String `db:"approved_by_name" json:"approved_by_name"`
+}
+
+// SegmentParam represents a typed param of a segment's query. Options are
+// the allowed values of enum params.
+type SegmentParam struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Label   string   `json:"label"`
+	Options []string `json:"options"`
+}
+
+// SegmentParams represents the params of a segment.
+type SegmentParams []SegmentParam
+
+// SegmentValues represents the values bound to the params of a segment.
+type SegmentValues map[string]any
+
+// CanUse checks whether the given user can use the segment. Unapproved
+// segments can only be used by the users who created them.
+func (s Segment) CanUse(userID int) bool {
+	return s.Approved || (s.CreatedBy.Valid && s.CreatedBy.Int == userID)
+}
+
+// Validate checks the segment's param definitions and ensures that every
+// placeholder in the query is a defined param and that every param is used.
+func (s Segment) Validate() error {
+	defined := make(map[string]bool, len(s.Params))
+	for _, p := range s.Params {
+		if !reSegmentParam.MatchString(p.Name) {
+			return fmt.Errorf("invalid param name '%s'", p.Name)
+		}
+		if _, ok := defined[p.Name]; ok {
+			return fmt.Errorf("duplicate param '%s'", p.Name)
+		}
+		if _, ok := segmentParamCasts[p.Type]; !ok {
+			return fmt.Errorf("param '%s' has an invalid type '%s'", p.Name, p.Type)
+		}
+		if p.Type == SegmentParamEnum && len(p.Options) == 0 {
+			return fmt.Errorf("enum param '%s' has no options", p.Name)
+		}
+		defined[p.Name] = false
+	}
+
+	var undefined []string
+	if _, err := scanSegmentQuery(s.Query, func(name string) string {
+		if _, ok := defined[name]; !ok {
+			undefined = append(undefined, name)
+		}
+		defined[name] = true
+		return ""
+	}); err != nil {
+		return err
+	}
+
+	if len(undefined) > 0 {
+		return fmt.Errorf("undefined param '%s'", undefined[0])
+	}
+	for _, p := range s.Params {
+		if !defined[p.Name] {
+			return fmt.Errorf("param '%s' isn't used in the query", p.Name)
+		}
+	}
+
+	return nil
+}
+
+// BindValues type-checks the given param values and returns them converted
+// to their params' types. Every param requires a value.
+func (s Segment) BindValues(values map[string]any) (SegmentValues, error) {
+	out := make(SegmentValues, len(s.Params))
+	for _, p := range s.Params {
+		v, ok := values[p.Name]
+		if !ok || v == nil {
+			return nil, fmt.Errorf("missing value for param '%s'", p.Name)
+		}
+
+		val, err := p.cast(v)
+		if err != nil {
+			return nil, err
+		}
+		out[p.Name] = val
+	}
+
+	return out, nil
+}
+
+// Bind type-checks the given param values and returns the segment's query with
+// its placeholders replaced by positional arguments starting at $(offset+1),
+// along with the values of the arguments, in order. offset should be the number
+// of positional arguments in the query that the segment's query is embedded in.
+// The returned query is parenthesized.
+func (s Segment) Bind(values map[string]any, offset int) (string, []any, error) {
+	vals, err := s.BindValues(values)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Every param is bound to a single positional argument, no matter how many
+	// times it appears in the query.
+	pos := make(map[string]int, len(s.Params))
+	args := make([]any, 0, len(s.Params))
+	for _, p := range s.Params {
+		args = append(args, vals[p.Name])
+		pos[p.Name] = offset + len(args)
+	}
+
+	types := make(map[string]string, len(s.Params))
+	for _, p := range s.Params {
+		types[p.Name] = p.Type
+	}
+
+	var undefined string
+	q, err := scanSegmentQuery(s.Query, func(name string) string {
+		n, ok := pos[name]
+		if !ok {
+			undefined = name
+			return ""
+		}
+		return fmt.Sprintf("$%d::%s", n, segmentParamCasts[types[name]])
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if undefined != "" {
+		return "", nil, fmt.Errorf("undefined param '%s'", undefined)
+	}
+
+	// Parenthesize the query so that it can't change the meaning of the conditions
+	// of the query it's embedded in, with the newline terminating any trailing comment.
+	return "(" + q + "\n)", args, nil
+}
+
+// cast converts a param value to the param's type.
+func (p SegmentParam) cast(v any) (any, error) {
+	invalid := fmt.Errorf("invalid value for %s param '%s'", p.Type, p.Name)
+
+	switch p.Type {
+	case SegmentParamInt:
+		switch v := v.(type) {
+		case float64:
+			if v != math.Trunc(v) || math.Abs(v) > math.MaxInt64 {
+				return nil, invalid
+			}
+			return int64(v), nil
+		case int:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil {
+				return nil, invalid
+			}
+			return n, nil
+		case string:
+			n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return nil, invalid
+			}
+			return n, nil
+		}
+
+	case SegmentParamString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+
+	case SegmentParamDate:
+		if s, ok := v.(string); ok {
+			d, err := time.Parse(SegmentDateFormat, strings.TrimSpace(s))
+			if err != nil {
+				return nil, invalid
+			}
+			return d.Format(SegmentDateFormat), nil
+		}
+
+	case SegmentParamEnum:
+		if s, ok := v.(string); ok && slices.Contains(p.Options, s) {
+			return s, nil
+		}
+	}
+
+	return nil, invalid
+}
+
+// scanSegmentQuery walks a segment's query and replaces every :name placeholder
+// outside string literals, quoted identifiers, and comments with the value
+// returned by fn for the name. Type casts (::) are left as-is. Positional ($n)
+// arguments and dollar-quoted strings aren't allowed as the positional
+// arguments are reserved for binding params.
+func scanSegmentQuery(q string, fn func(name string) string) (string, error) {
+	var b strings.Builder
+	b.Grow(len(q))
+
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		// Quoted string literal or identifier, where quotes are escaped by doubling them.
+		case c == '\'' || c == '"':
+			j := i + 1
+			for ; j < len(q); j++ {
+				if q[j] != c {
+					continue
+				}
+				if j+1 < len(q) && q[j+1] == c {
+					j++
+					continue
+				}
+				break
+			}
+			if j >= len(q) {
+				return "", errors.New("unterminated quoted string")
+			}
+			b.WriteString(q[i : j+1])
+			i = j + 1
+
+		// Line comment.
+		case c == '-' && strings.HasPrefix(q[i:], "--"):
+			j := strings.IndexByte(q[i:], '\n')
+			if j < 0 {
+				j = len(q) - i
+			}
+			b.WriteString(q[i : i+j])
+			i += j
+
+		// Block comment.
+		case c == '/' && strings.HasPrefix(q[i:], "/*"):
+			j := strings.Index(q[i+2:], "*/")
+			if j < 0 {
+				return "", errors.New("unterminated comment")
+			}
+			b.WriteString(q[i : i+j+4])
+			i += j + 4
+
+		case c == '$':
+			return "", errors.New("'$' isn't allowed outside quoted strings. Use :name params")
+
+		// Type cast.
+		case c == ':' && strings.HasPrefix(q[i:], "::"):
+			b.WriteString("::")
+			i += 2
+
+		// Param placeholder.
+		case c == ':' && i+1 < len(q) && isSegmentIdentStart(q[i+1]):
+			j := i + 1
+			for j < len(q) && (isSegmentIdentStart(q[j]) || (q[j] >= '0' && q[j] <= '9')) {
+				j++
+			}
+			b.WriteString(fn(q[i+1 : j]))
+			i = j
+
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String(), nil
+}
+
+func isSegmentIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Scan implements the sql.Scanner interface.
+func (p *SegmentParams) Scan(src any) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		return nil
+	}
+
+	return json.Unmarshal(b, p)
+}
+
+// Value implements the driver.Valuer interface.
+func (p SegmentParams) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return "[]", nil
+	}
+
+	return json.Marshal(p)
+}
+
+// Scan implements the sql.Scanner interface.
+func (v *SegmentValues) Scan(src any) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		return nil
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// Value implements the driver.Valuer interface.
+func (v SegmentValues) Value() (driver.Value, error) {
+	if v == nil {
+		return "{}", nil
+	}
+
+	return json.Marshal(v)
+}
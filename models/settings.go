@@ -2,6 +2,12 @@ package models
 
 import "gopkg.in/volatiletech/null.v6"
 
+const (
+	AttribFilterAll   = "all"
+	AttribFilterAllow = "allow"
+	AttribFilterDeny  = "deny"
+)
+
 // Settings represents the app settings stored in the DB.
 type Settings struct {
 	AppSiteName                   string   `json:"app.site_name"`
@@ -16,30 +22,64 @@ type Settings struct {
 	ShowOptinPage                 bool     `json:"app.show_optin_page"`
 	SendOptinConfirmation         bool     `json:"app.send_optin_confirmation"`
 	CheckUpdates                  bool     `json:"app.check_updates"`
+	ConfirmCampaignStart          bool     `json:"app.confirm_campaign_start"`
+	EnforceListSendLimits         bool     `json:"app.enforce_list_send_limits"`
 	AppLang                       string   `json:"app.lang"`
 
-	AppBatchSize             int    `json:"app.batch_size"`
-	AppConcurrency           int    `json:"app.concurrency"`
-	AppMaxSendErrors         int    `json:"app.max_send_errors"`
-	AppMessageRate           int    `json:"app.message_rate"`
-	CacheSlowQueries         bool   `json:"app.cache_slow_queries"`
-	CacheSlowQueriesInterval string `json:"app.cache_slow_queries_interval"`
+	// AppUTMTemplate is the default UTM template of campaigns.
+	AppUTMTemplate UTMTemplate `json:"app.utm_template"`
+
+	// AppTemplateLintRules are the severities of the template lint rules.
+	AppTemplateLintRules map[string]string `json:"app.template_lint_rules"`
+
+	AppBatchSize              int    `json:"app.batch_size"`
+	AppImportBatchSize        int    `json:"app.import_batch_size"`
+	AppImportErrorFileSize    int    `json:"app.import_error_file_size"`
+	AppArchiveImports         bool   `json:"app.archive_imports"`
+	AppImportArchiveRetention int    `json:"app.import_archive_retention_days"`
+	AppConcurrency            int    `json:"app.concurrency"`
+	AppMaxSendErrors          int    `json:"app.max_send_errors"`
+	AppTemplateMaxBodyBytes   int    `json:"app.template_max_body_bytes"`
+	AppTemplateLeftDelim      string `json:"app.template_left_delim"`
+	AppTemplateRightDelim     string `json:"app.template_right_delim"`
+	AppCampaignMinifyHTML     bool   `json:"app.campaign_minify_html"`
+	AppMessageRate            int    `json:"app.message_rate"`
+	AppMaxConcurrentCampaigns int    `json:"app.max_concurrent_campaigns"`
+	AppCampaignQueueDelay     string `json:"app.campaign_queue_delay"`
+	CacheSlowQueries          bool   `json:"app.cache_slow_queries"`
+	CacheSlowQueriesInterval  string `json:"app.cache_slow_queries_interval"`
 
 	AppMessageSlidingWindow         bool   `json:"app.message_sliding_window"`
 	AppMessageSlidingWindowDuration string `json:"app.message_sliding_window_duration"`
 	AppMessageSlidingWindowRate     int    `json:"app.message_sliding_window_rate"`
 
-	PrivacyIndividualTracking bool     `json:"privacy.individual_tracking"`
-	PrivacyDisableTracking    bool     `json:"privacy.disable_tracking"`
-	PrivacyUnsubHeader        bool     `json:"privacy.unsubscribe_header"`
-	PrivacyAllowBlocklist     bool     `json:"privacy.allow_blocklist"`
-	PrivacyAllowPreferences   bool     `json:"privacy.allow_preferences"`
-	PrivacyAllowExport        bool     `json:"privacy.allow_export"`
-	PrivacyAllowWipe          bool     `json:"privacy.allow_wipe"`
-	PrivacyExportable         []string `json:"privacy.exportable"`
-	PrivacyRecordOptinIP      bool     `json:"privacy.record_optin_ip"`
-	DomainBlocklist           []string `json:"privacy.domain_blocklist"`
-	DomainAllowlist           []string `json:"privacy.domain_allowlist"`
+	PrivacyIndividualTracking        bool     `json:"privacy.individual_tracking"`
+	PrivacyDisableTracking           bool     `json:"privacy.disable_tracking"`
+	PrivacyISPTrackingDomain         string   `json:"privacy.isp_tracking_domain"`
+	PrivacyUnsubHeader               bool     `json:"privacy.unsubscribe_header"`
+	PrivacyAllowBlocklist            bool     `json:"privacy.allow_blocklist"`
+	PrivacyAllowPreferences          bool     `json:"privacy.allow_preferences"`
+	PrivacyAllowExport               bool     `json:"privacy.allow_export"`
+	PrivacyAllowWipe                 bool     `json:"privacy.allow_wipe"`
+	PrivacyMode                      string   `json:"privacy.mode"`
+	PrivacyLegacyUUIDLinksUntil      string   `json:"privacy.legacy_uuid_links_until"`
+	PrivacyExportable                []string `json:"privacy.exportable"`
+	PrivacyRecordOptinIP             bool     `json:"privacy.record_optin_ip"`
+	PrivacyResubscribeProtectionDays int      `json:"privacy.resubscribe_protection_days"`
+	DomainBlocklist                  []string `json:"privacy.domain_blocklist"`
+	DomainAllowlist                  []string `json:"privacy.domain_allowlist"`
+	EmailAllowlistPatterns           []string `json:"privacy.email_allowlist_patterns"`
+	EmailDenylistPatterns            []string `json:"privacy.email_denylist_patterns"`
+	PrivacyUnsubMailto               struct {
+		Enabled bool   `json:"enabled"`
+		Address string `json:"address"`
+	} `json:"privacy.unsubscribe_mailto"`
+	PrivacyJournal struct {
+		Enabled bool   `json:"enabled"`
+		Address string `json:"address"`
+		Mode    string `json:"mode"`
+		Tx      bool   `json:"tx"`
+	} `json:"privacy.journal"`
 
 	SecurityCaptcha struct {
 		Altcha struct {
@@ -68,6 +108,7 @@ type Settings struct {
 
 	UploadProvider             string   `json:"upload.provider"`
 	UploadExtensions           []string `json:"upload.extensions"`
+	UploadMaxArchiveSizeMB     int      `json:"upload.max_archive_size_mb"`
 	UploadFilesystemUploadPath string   `json:"upload.filesystem.upload_path"`
 	UploadFilesystemUploadURI  string   `json:"upload.filesystem.upload_uri"`
 	UploadS3URL                string   `json:"upload.s3.url"`
@@ -100,6 +141,11 @@ type Settings struct {
 		TLSType       string              `json:"tls_type"`
 		TLSSkipVerify bool                `json:"tls_skip_verify"`
 		FromAddresses []string            `json:"from_addresses"`
+
+		ShadowMode       bool   `json:"shadow_mode"`
+		ShadowBccAddress string `json:"shadow_bcc_address"`
+
+		WarmupSchedule []WarmupStep `json:"warmup_schedule"`
 	} `json:"smtp"`
 
 	Messengers []struct {
@@ -112,8 +158,16 @@ type Settings struct {
 		MaxConns      int    `json:"max_conns"`
 		Timeout       string `json:"timeout"`
 		MaxMsgRetries int    `json:"max_msg_retries"`
+
+		WarmupSchedule []WarmupStep `json:"warmup_schedule"`
 	} `json:"messengers"`
 
+	// The capture messenger records messages in memory instead of delivering them.
+	CaptureMessenger struct {
+		Enabled bool `json:"enabled"`
+		Size    int  `json:"size"`
+	} `json:"capture_messenger"`
+
 	BounceEnabled        bool `json:"bounce.enabled"`
 	BounceEnableWebhooks bool `json:"bounce.webhooks_enabled"`
 	BounceActions        map[string]struct {
@@ -154,15 +208,137 @@ type Settings struct {
 		TLSEnabled    bool   `json:"tls_enabled"`
 		TLSSkipVerify bool   `json:"tls_skip_verify"`
 		ScanInterval  string `json:"scan_interval"`
+		Folder        string `json:"folder"`
+
+		MaxMessageSize int `json:"max_message_size"`
 	} `json:"bounce.mailboxes"`
+	BounceReasons []struct {
+		Reason  string `json:"reason"`
+		Pattern string `json:"pattern"`
+	} `json:"bounce.reasons"`
+	BounceClassificationRules []struct {
+		Pattern string `json:"pattern"`
+		Type    string `json:"type"`
+	} `json:"bounce.classification_rules"`
 
 	MaintenanceDB struct {
 		Vacuum         bool   `json:"vacuum"`
 		VacuumInterval string `json:"vacuum_cron_interval"`
 	} `json:"maintenance.db"`
 
+	MaintenanceBackup struct {
+		Enabled      bool   `json:"enabled"`
+		CronInterval string `json:"cron_interval"`
+		Method       string `json:"method"`
+		Keep         int    `json:"keep"`
+	} `json:"maintenance.backup"`
+
+	SpellcheckDictionaryIDs []int `json:"spellcheck.dictionary_ids"`
+
+	NotificationsEmail struct {
+		Enabled bool     `json:"enabled"`
+		Emails  []string `json:"emails"`
+	} `json:"notifications.email"`
+	NotificationsWebhook struct {
+		Enabled bool   `json:"enabled"`
+		URL     string `json:"url"`
+	} `json:"notifications.webhook"`
+	NotificationsListWebhook struct {
+		Enabled bool   `json:"enabled"`
+		URL     string `json:"url"`
+		Secret  string `json:"secret"`
+	} `json:"notifications.list_webhook"`
+	NotificationsEvents struct {
+		CampaignFailure struct {
+			Enabled   bool `json:"enabled"`
+			Threshold int  `json:"threshold"`
+		} `json:"campaign_failure"`
+		BounceSpike struct {
+			Enabled   bool    `json:"enabled"`
+			Threshold float64 `json:"threshold"`
+			Window    string  `json:"window"`
+		} `json:"bounce_spike"`
+		IPBounceSpike struct {
+			Enabled   bool   `json:"enabled"`
+			Threshold int    `json:"threshold"`
+			Window    string `json:"window"`
+		} `json:"ip_bounce_spike"`
+		NewLogin struct {
+			Enabled bool `json:"enabled"`
+		} `json:"new_login"`
+		DBPool struct {
+			Enabled bool `json:"enabled"`
+		} `json:"db_pool"`
+		WarmupLimit struct {
+			Enabled bool `json:"enabled"`
+		} `json:"warmup_limit"`
+	} `json:"notifications.events"`
+
 	AdminCustomCSS  string `json:"appearance.admin.custom_css"`
 	AdminCustomJS   string `json:"appearance.admin.custom_js"`
 	PublicCustomCSS string `json:"appearance.public.custom_css"`
 	PublicCustomJS  string `json:"appearance.public.custom_js"`
+
+	SecurityCampaignGate struct {
+		Enabled       bool     `json:"enabled"`
+		URLs          []string `json:"urls"`
+		Secret        string   `json:"secret"`
+		Timeout       string   `json:"timeout"`
+		RetryInterval string   `json:"retry_interval"`
+	} `json:"security.campaign_gate"`
+
+	SecuritySubscriberFeed struct {
+		Enabled bool   `json:"enabled"`
+		Token   string `json:"token"`
+	} `json:"security.subscriber_feed"`
+
+	SecurityGoogleSheets struct {
+		Enabled     bool   `json:"enabled"`
+		Credentials string `json:"credentials"`
+	} `json:"security.google_sheets"`
+
+	PrivacyStrictASCIIEmail bool `json:"privacy.strict_ascii_email"`
+
+	// Subscriber attributes available to templates, and to the public
+	// preference page and APIs.
+	PrivacyTemplateAttribs AttribFilter `json:"privacy.template_attribs"`
+	PrivacyPublicAttribs   AttribFilter `json:"privacy.public_attribs"`
+}
+
+// AttribFilter is an allowlist or a denylist of top-level subscriber attribute keys.
+type AttribFilter struct {
+	Mode string   `json:"mode"`
+	Keys []string `json:"keys"`
+}
+
+// Allows returns true if the attribute key passes the filter.
+func (f AttribFilter) Allows(key string) bool {
+	switch f.Mode {
+	case AttribFilterAllow, AttribFilterDeny:
+	default:
+		return true
+	}
+
+	for _, k := range f.Keys {
+		if k == key {
+			return f.Mode == AttribFilterAllow
+		}
+	}
+	return f.Mode == AttribFilterDeny
+}
+
+// Apply returns a copy of the attribute map with only the keys that pass the filter.
+// The given map is not modified.
+func (f AttribFilter) Apply(attribs JSON) JSON {
+	if f.Mode != AttribFilterAllow && f.Mode != AttribFilterDeny {
+		return attribs
+	}
+
+	out := make(JSON, len(attribs))
+	for k, v := range attribs {
+		if f.Allows(k) {
+			out[k] = v
+		}
+	}
+	return out
 }
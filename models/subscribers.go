@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/types"
@@ -19,8 +20,56 @@ const (
 	SubscriptionStatusUnconfirmed  = "unconfirmed"
 	SubscriptionStatusConfirmed    = "confirmed"
 	SubscriptionStatusUnsubscribed = "unsubscribed"
+
+	// Sources of subscriptions recorded in subscriber_lists.subscribe_source.
+	SubscribeSourceAPI         = "api"
+	SubscribeSourceForm        = "form"
+	SubscribeSourceImport      = "import"
+	SubscribeSourceAutomation  = "automation"
+	SubscribeSourceSuppression = "suppression"
+
+	ReconfirmationStatusPending   = "pending"
+	ReconfirmationStatusConfirmed = "confirmed"
+	ReconfirmationStatusExpired   = "expired"
+
+	// Types of consent events recorded in the consents audit trail.
+	ConsentTypeSubscribe   = "subscribe"
+	ConsentTypeUnsubscribe = "unsubscribe"
+	ConsentTypeMarketing   = "marketing"
+
+	// SubscriberAttribColorScheme is the subscriber attribute in which the color
+	// scheme preferred by the subscriber's browser is recorded.
+	SubscriberAttribColorScheme = "prefers_color_scheme"
+	ColorSchemeDark             = "dark"
+	ColorSchemeLight            = "light"
+
+	// Privacy modes (privacy.mode) that decide what deleting a subscriber does.
+	// With PrivacyModeAnonymizeOnDelete, the subscriber's analytics records are
+	// re-pointed to the anonymized placeholder subscriber before the subscriber
+	// is deleted, so that campaign analytics remain unchanged.
+	PrivacyModeDelete            = "delete"
+	PrivacyModeAnonymizeOnDelete = "anonymize_on_delete"
+
+	// Subscriber lifecycle events published to the subscriber event stream.
+	SubscriberEventCreated      = "created"
+	SubscriberEventConfirmed    = "confirmed"
+	SubscriberEventBlocklisted  = "blocklisted"
+	SubscriberEventUnsubscribed = "unsubscribed"
+
+	// SubscriberAnonymizedEmail is the e-mail of the placeholder subscriber to
+	// which the analytics records of anonymized subscribers belong.
+	SubscriberAnonymizedEmail = "anonymized_subscriber"
 )
 
+// SubscriberEvent is a subscriber lifecycle event (SubscriberEvent*). The e-mail
+// is only identified by its (SHA-256) hash for privacy.
+type SubscriberEvent struct {
+	SubscriberID int       `json:"subscriber_id"`
+	EmailHash    string    `json:"email_hash"`
+	Type         string    `json:"type"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
 // Subscribers represents a slice of Subscriber.
 type Subscribers []Subscriber
 
@@ -34,6 +83,14 @@ type Subscriber struct {
 	Attribs JSON           `db:"attribs" json:"attribs"`
 	Status  string         `db:"status" json:"status"`
 	Lists   types.JSONText `db:"lists" json:"lists"`
+
+	// UnsubscribeToken identifies the subscriber in the links in the messages
+	// sent to them instead of the UUID. It's never exposed in the API.
+	UnsubscribeToken string `db:"unsubscribe_token" json:"-"`
+
+	// CampaignListID is the campaign list through which the subscriber is being
+	// messaged. It is only set by the next-campaign-subscribers query.
+	CampaignListID int `db:"campaign_list_id" json:"-"`
 }
 
 type subLists struct {
@@ -101,6 +158,16 @@ func (s Subscriber) LastName() string {
 	return s.Name
 }
 
+// Lang returns the subscriber's language preference, a BCP 47 language
+// in the "lang" attribute, if any.
+func (s Subscriber) Lang() string {
+	if v, ok := s.Attribs["lang"].(string); ok {
+		return v
+	}
+
+	return ""
+}
+
 // Subscription represents a list attached to a subscriber.
 type Subscription struct {
 	List
@@ -109,17 +176,6 @@ type Subscription struct {
 	Meta                  json.RawMessage `db:"meta" json:"meta"`
 }
 
-// SubscriberExport represents a subscriber record that is exported to raw data.
-type SubscriberExport struct {
-	Base
-
-	UUID    string `db:"uuid" json:"uuid"`
-	Email   string `db:"email" json:"email"`
-	Name    string `db:"name" json:"name"`
-	Attribs string `db:"attribs" json:"attribs"`
-	Status  string `db:"status" json:"status"`
-}
-
 // SubscriberExportProfile represents a subscriber's collated data in JSON for export.
 type SubscriberExportProfile struct {
 	Email         string          `db:"email" json:"-"`
@@ -127,6 +183,7 @@ type SubscriberExportProfile struct {
 	Subscriptions json.RawMessage `db:"subscriptions" json:"subscriptions,omitempty"`
 	CampaignViews json.RawMessage `db:"campaign_views" json:"campaign_views,omitempty"`
 	LinkClicks    json.RawMessage `db:"link_clicks" json:"link_clicks,omitempty"`
+	Consents      json.RawMessage `db:"consents" json:"consents,omitempty"`
 }
 
 // SubscriberActivity represents a subscriber's campaign views and link clicks for the Activity tab.
@@ -134,3 +191,200 @@ type SubscriberActivity struct {
 	CampaignViews json.RawMessage `db:"campaign_views" json:"campaign_views"`
 	LinkClicks    json.RawMessage `db:"link_clicks" json:"link_clicks"`
 }
+
+// AttribStats represents the aggregate statistics of the values of a subscriber
+// attribute. Values has the counts of the most common distinct values and the
+// numeric stats are only set for numeric attributes.
+type AttribStats struct {
+	Key    string         `db:"-" json:"key"`
+	Type   string         `db:"type" json:"type"`
+	Total  int            `db:"total" json:"total"`
+	Values types.JSONText `db:"values" json:"values"`
+	Min    null.Float64   `db:"min" json:"min"`
+	Max    null.Float64   `db:"max" json:"max"`
+	Mean   null.Float64   `db:"mean" json:"mean"`
+	Median null.Float64   `db:"median" json:"median"`
+}
+
+// AttribChange represents a change to a subscriber's attributes. OldValue and
+// NewValue only have the (top level) attributes that were changed, added, or removed.
+type AttribChange struct {
+	ID            int64       `db:"id" json:"id"`
+	SubscriberID  int         `db:"subscriber_id" json:"subscriber_id"`
+	ChangedAt     null.Time   `db:"changed_at" json:"changed_at"`
+	ChangedBy     null.Int    `db:"changed_by" json:"changed_by"`
+	ChangedByName null.String `db:"changed_by_name" json:"changed_by_name"`
+	OldValue      JSON        `db:"old_value" json:"old_value"`
+	NewValue      JSON        `db:"new_value" json:"new_value"`
+
+	// Pseudofield for getting the total number of records.
+	Total int `db:"total" json:"-"`
+}
+
+// SubscriberSend represents a campaign sent to a subscriber and the
+// subscriber's engagement with it.
+type SubscriberSend struct {
+	CampaignID   int       `db:"campaign_id" json:"campaign_id"`
+	CampaignUUID string    `db:"campaign_uuid" json:"campaign_uuid"`
+	Name         string    `db:"name" json:"name"`
+	Subject      string    `db:"subject" json:"subject"`
+	Status       string    `db:"status" json:"status"`
+	SentAt       null.Time `db:"sent_at" json:"sent_at"`
+	OpenedAt     null.Time `db:"opened_at" json:"opened_at"`
+	Clicked      bool      `db:"clicked" json:"clicked"`
+	Bounced      bool      `db:"bounced" json:"bounced"`
+	Unsubscribed bool      `db:"unsubscribed" json:"unsubscribed"`
+
+	// Pseudofield for getting the total number of records.
+	Total int `db:"total" json:"-"`
+}
+
+// CampaignSend is a campaign message sent to a subscriber that's recorded
+// in the send log.
+type CampaignSend struct {
+	CampaignID   int
+	SubscriberID int
+	Subject      string
+	SentAt       time.Time
+
+	// Test is true for the messages sent to a test cohort of the campaign.
+	Test bool
+}
+
+// CampaignHistory is a campaign message in the send log of a subscriber along
+// with the subscriber's views (timestamps) and clicks of the campaign.
+type CampaignHistory struct {
+	ID           int64          `db:"id" json:"id"`
+	CampaignID   int            `db:"campaign_id" json:"campaign_id"`
+	CampaignUUID string         `db:"campaign_uuid" json:"campaign_uuid"`
+	CampaignName string         `db:"campaign_name" json:"campaign_name"`
+	Subject      string         `db:"subject" json:"subject"`
+	SentAt       time.Time      `db:"sent_at" json:"sent_at"`
+	Test         bool           `db:"test" json:"test"`
+	Opens        types.JSONText `db:"opens" json:"opens"`
+	Clicks       types.JSONText `db:"clicks" json:"clicks"`
+}
+
+// CampaignHistoryPage is a page of a subscriber's campaign history. NextCursor
+// is the cursor (sent_at) to fetch the next page with, or null on the last page.
+type CampaignHistoryPage struct {
+	Results    []CampaignHistory `json:"results"`
+	PerPage    int               `json:"per_page"`
+	NextCursor null.Time         `json:"next_cursor"`
+}
+
+// SubscriberStatusConfirmation is returned when a destructive bulk subscriber
+// status change is requested. The change is only made when the token is presented
+// again with the same request before it expires.
+type SubscriberStatusConfirmation struct {
+	Token     string    `json:"confirmation_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Count     int       `json:"count"`
+}
+
+// DisengagedReport is the report of the subscribers who have been on a list (or on
+// listmonk) for at least InactiveDays days and have never viewed or clicked a campaign.
+type DisengagedReport struct {
+	InactiveDays int                    `json:"inactive_days"`
+	ListID       int                    `json:"list_id"`
+	Total        int                    `json:"total"`
+	Buckets      []DisengagedBucket     `json:"buckets"`
+	Sample       []DisengagedSubscriber `json:"sample"`
+}
+
+// DisengagedBucket is the number of disengaged subscribers who have been on a list
+// for FromDays to ToDays (exclusive, null for no upper bound) days.
+type DisengagedBucket struct {
+	FromDays int      `json:"from_days"`
+	ToDays   null.Int `json:"to_days"`
+	Count    int      `json:"count"`
+}
+
+// DisengagedSubscriber is a subscriber in the disengaged subscribers report.
+type DisengagedSubscriber struct {
+	ID           int       `db:"id" json:"id"`
+	UUID         string    `db:"uuid" json:"uuid"`
+	Email        string    `db:"email" json:"email"`
+	Name         string    `db:"name" json:"name"`
+	Status       string    `db:"status" json:"status"`
+	SubscribedAt null.Time `db:"subscribed_at" json:"subscribed_at"`
+	Days         int       `db:"days" json:"days"`
+}
+
+// SubscriberGrowth is the number of subscribers who subscribed to lists on a date
+// (the start of a day, week, or month), broken down by the source of the subscriptions.
+type SubscriberGrowth struct {
+	Date     string         `json:"date"`
+	Total    int            `json:"total"`
+	BySource map[string]int `json:"by_source"`
+}
+
+// NewSubscription is a subscription of a subscriber to a list in the new
+// subscribers feed.
+type NewSubscription struct {
+	SubscriberID   int       `db:"subscriber_id" json:"subscriber_id"`
+	SubscriberUUID string    `db:"subscriber_uuid" json:"subscriber_uuid"`
+	Email          string    `db:"email" json:"email"`
+	ListID         int       `db:"list_id" json:"list_id"`
+	ListName       string    `db:"list_name" json:"list_name"`
+	Optin          string    `db:"optin" json:"optin"`
+	Status         string    `db:"status" json:"status"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// ImportArchive is the archived original file of a subscriber import
+// in the media store.
+type ImportArchive struct {
+	ID        int       `db:"id" json:"id"`
+	Filename  string    `db:"filename" json:"filename"`
+	Path      string    `db:"path" json:"path"`
+	Size      int64     `db:"size" json:"size"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Reconfirmation is a request sent to an inactive subscriber to re-confirm
+// their subscriptions to a set of lists, failing which, they're unsubscribed
+// from the lists when it expires.
+type Reconfirmation struct {
+	ID           int           `db:"id" json:"id"`
+	UUID         string        `db:"uuid" json:"uuid"`
+	SubscriberID int           `db:"subscriber_id" json:"subscriber_id"`
+	ListIDs      pq.Int64Array `db:"list_ids" json:"list_ids"`
+	Status       string        `db:"status" json:"status"`
+	ExpiresAt    time.Time     `db:"expires_at" json:"expires_at"`
+	ConfirmedAt  null.Time     `db:"confirmed_at" json:"confirmed_at"`
+	CreatedAt    time.Time     `db:"created_at" json:"created_at"`
+}
+
+// Consent is an entry in the audit trail of a subscriber's consents to a list.
+type Consent struct {
+	ID           int64       `db:"id" json:"id"`
+	SubscriberID int         `db:"subscriber_id" json:"subscriber_id"`
+	ListID       null.Int    `db:"list_id" json:"list_id"`
+	ListName     null.String `db:"list_name" json:"list_name"`
+	CampaignID   null.Int    `db:"campaign_id" json:"campaign_id"`
+	CampaignName null.String `db:"campaign_name" json:"campaign_name"`
+	Type         string      `db:"consent_type" json:"consent_type"`
+	IP           null.String `db:"ip" json:"ip"`
+	UserAgent    null.String `db:"user_agent" json:"user_agent"`
+	Text         string      `db:"consent_text_snapshot" json:"consent_text_snapshot"`
+	CreatedAt    time.Time   `db:"created_at" json:"created_at"`
+
+	Total int `db:"total" json:"-"`
+}
+
+// ConsentEvent is a consent action of subscribers to be recorded on lists
+// by their IDs or UUIDs. Without lists, it's recorded on the lists of the
+// campaign, or on all the subscribers' lists with AllLists.
+type ConsentEvent struct {
+	SubscriberIDs []int
+	Type          string
+	ListIDs       []int
+	ListUUIDs     []string
+	CampaignUUID  string
+	AllLists      bool
+
+	IP        string
+	UserAgent string
+	Text      string
+}
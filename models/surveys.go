@@ -0,0 +1,140 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	SurveyQuestionSingleChoice = "single_choice"
+	SurveyQuestionRating       = "rating"
+
+	// Range of the answers to rating questions.
+	SurveyRatingMin = 1
+	SurveyRatingMax = 10
+
+	// Max. number of questions in a survey and the max. length of an answer.
+	SurveyMaxQuestions = 50
+	SurveyMaxAnswerLen = 200
+)
+
+var reSurveyQuestionID = regexp.MustCompile(`^[a-zA-Z0-9_\-]{1,50}$`)
+
+// SurveyQuestion is a question in a campaign's survey that's answered by clicking
+// on the links generated with {{ surveyURL }} in the campaign. Options optionally
+// restrict the answers to a single_choice question.
+type SurveyQuestion struct {
+	ID      string   `json:"id"`
+	Text    string   `json:"text"`
+	Type    string   `json:"type"`
+	Options []string `json:"options,omitempty"`
+}
+
+// SurveyQuestions is the list of questions in a campaign's survey.
+type SurveyQuestions []SurveyQuestion
+
+// SurveyResult is the aggregate of the responses to a survey question.
+// Average is only set for rating questions.
+type SurveyResult struct {
+	SurveyQuestion
+	Total   int                 `json:"total"`
+	Average null.Float64        `json:"average"`
+	Answers []SurveyAnswerCount `json:"answers"`
+}
+
+// SurveyAnswerCount is the number of responses with an answer to a survey question.
+type SurveyAnswerCount struct {
+	QuestionID string `db:"question_id" json:"-"`
+	Answer     string `db:"answer" json:"answer"`
+	Count      int    `db:"count" json:"count"`
+}
+
+// Validate validates the questions in a survey.
+func (s SurveyQuestions) Validate() error {
+	if len(s) > SurveyMaxQuestions {
+		return fmt.Errorf("a survey can have up to %d questions", SurveyMaxQuestions)
+	}
+
+	ids := make(map[string]struct{}, len(s))
+	for _, q := range s {
+		if !reSurveyQuestionID.MatchString(q.ID) {
+			return fmt.Errorf("invalid question ID '%s'", q.ID)
+		}
+		if _, ok := ids[q.ID]; ok {
+			return fmt.Errorf("duplicate question ID '%s'", q.ID)
+		}
+		ids[q.ID] = struct{}{}
+
+		if strings.TrimSpace(q.Text) == "" {
+			return fmt.Errorf("question '%s' has no text", q.ID)
+		}
+
+		switch q.Type {
+		case SurveyQuestionSingleChoice:
+		case SurveyQuestionRating:
+			if len(q.Options) > 0 {
+				return fmt.Errorf("rating question '%s' can't have options", q.ID)
+			}
+		default:
+			return fmt.Errorf("question '%s' has an invalid type '%s'", q.ID, q.Type)
+		}
+	}
+
+	return nil
+}
+
+// Get returns the question with the given ID.
+func (s SurveyQuestions) Get(id string) (SurveyQuestion, bool) {
+	for _, q := range s {
+		if q.ID == id {
+			return q, true
+		}
+	}
+
+	return SurveyQuestion{}, false
+}
+
+// IsValidAnswer checks whether an answer is valid for the question.
+func (q SurveyQuestion) IsValidAnswer(a string) bool {
+	if a == "" || len(a) > SurveyMaxAnswerLen {
+		return false
+	}
+
+	if q.Type == SurveyQuestionRating {
+		n, err := strconv.Atoi(a)
+		return err == nil && n >= SurveyRatingMin && n <= SurveyRatingMax
+	}
+
+	return len(q.Options) == 0 || slices.Contains(q.Options, a)
+}
+
+// Scan implements the sql.Scanner interface.
+func (s *SurveyQuestions) Scan(src any) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		return nil
+	}
+
+	return json.Unmarshal(b, s)
+}
+
+// Value implements the driver.Valuer interface.
+func (s SurveyQuestions) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return "[]", nil
+	}
+
+	return json.Marshal(s)
+}
@@ -1,10 +1,11 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"strings"
 	txttpl "text/template"
-	"time"
 
 	null "gopkg.in/volatiletech/null.v6"
 )
@@ -29,12 +30,36 @@ type Template struct {
 	BodySource null.String `db:"body_source" json:"body_source,omitempty"`
 	IsDefault  bool        `db:"is_default" json:"is_default"`
 
+	// Templates in the template library are read-only and are cloned to be used.
+	IsLibrary   bool   `db:"is_library" json:"is_library"`
+	Category    string `db:"category" json:"category"`
+	Description string `db:"description" json:"description"`
+
+	// Lang is the BCP 47 language of a variant of the ParentTemplateID campaign
+	// template that's used for subscribers with a matching language.
+	Lang             null.String `db:"lang" json:"lang"`
+	ParentTemplateID null.Int    `db:"parent_template_id" json:"parent_template_id"`
+
+	// Other users who are currently editing the template (advisory).
+	Editors []Editor `db:"-" json:"editors,omitempty"`
+
 	// Only relevant to tx (transactional) templates.
 	SubjectTpl  *txttpl.Template   `json:"-"`
 	Tpl         *template.Template `json:"-"`
 	Attachments []Attachment       `json:"-"`
 }
 
+// TemplateVersion is a previous version of a template that's saved when
+// the template's body is updated.
+type TemplateVersion struct {
+	ID         int64       `db:"id" json:"id"`
+	TemplateID int         `db:"template_id" json:"template_id"`
+	Subject    string      `db:"subject" json:"subject"`
+	Body       string      `db:"body" json:"body"`
+	BodySource null.String `db:"body_source" json:"body_source"`
+	CreatedAt  null.Time   `db:"created_at" json:"created_at"`
+}
+
 // Compile compiles a template body and subject (only for tx templates) and
 // caches the templat references to be executed later.
 func (t *Template) Compile(f template.FuncMap) error {
@@ -58,6 +83,47 @@ func (t *Template) Compile(f template.FuncMap) error {
 	return nil
 }
 
+// TemplateVariants maps the lowercased BCP 47 languages of the language
+// variants of a campaign template to their bodies.
+type TemplateVariants map[string]string
+
+// Match returns the language of the variant that matches a language preference,
+// eg: "de-AT" matches a "de-at" variant, or failing that, a "de" variant.
+func (v TemplateVariants) Match(lang string) (string, bool) {
+	if len(v) == 0 || lang == "" {
+		return "", false
+	}
+
+	lang = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+	if _, ok := v[lang]; ok {
+		return lang, true
+	}
+
+	// Fall back to the primary language subtag.
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		if _, ok := v[base]; ok {
+			return base, true
+		}
+	}
+
+	return "", false
+}
+
+// Scan implements the sql.Scanner interface.
+func (v *TemplateVariants) Scan(src any) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		return nil
+	}
+
+	return json.Unmarshal(b, v)
+}
+
 type CampaignStats struct {
 	ID        int       `db:"id" json:"id"`
 	Status    string    `db:"status" json:"status"`
@@ -67,38 +133,12 @@ type CampaignStats struct {
 	UpdatedAt null.Time `db:"updated_at" json:"updated_at"`
 	Rate      int       `json:"rate"`
 	NetRate   int       `json:"net_rate"`
-}
-
-type CampaignAnalyticsCount struct {
-	CampaignID int       `db:"campaign_id" json:"campaign_id"`
-	Count      int       `db:"count" json:"count"`
-	Timestamp  time.Time `db:"timestamp" json:"timestamp"`
-}
 
-type CampaignAnalyticsLink struct {
-	URL   string `db:"url" json:"url"`
-	Count int    `db:"count" json:"count"`
-}
-
-type CampaignViewExport struct {
-	CampaignID     int       `db:"campaign_id"`
-	CampaignUUID   string    `db:"campaign_uuid"`
-	CampaignName   string    `db:"campaign_name"`
-	SubscriberID   int       `db:"subscriber_id"`
-	SubscriberUUID string    `db:"subscriber_uuid"`
-	Email          string    `db:"email"`
-	SubscriberName string    `db:"subscriber_name"`
-	CreatedAt      time.Time `db:"created_at"`
-}
+	// Live render stats of the campaign's messages processed so far.
+	RenderStats RenderStats `json:"render_stats"`
 
-type CampaignClickExport struct {
-	CampaignID     int       `db:"campaign_id"`
-	CampaignUUID   string    `db:"campaign_uuid"`
-	CampaignName   string    `db:"campaign_name"`
-	SubscriberID   int       `db:"subscriber_id"`
-	SubscriberUUID string    `db:"subscriber_uuid"`
-	Email          string    `db:"email"`
-	SubscriberName string    `db:"subscriber_name"`
-	URL            string    `db:"url"`
-	CreatedAt      time.Time `db:"created_at"`
+	// Projected completion time of the campaign and the end of the window
+	// over which its messages are spread out, if any.
+	ETA       null.Time `json:"eta"`
+	SpreadEnd null.Time `json:"spread_end"`
 }
@@ -0,0 +1,17 @@
+package models
+
+// Topic represents a topic that campaigns can be tagged with and that
+// subscribers can opt in or out of from the preferences page.
+type Topic struct {
+	Base
+
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+}
+
+// SubscriberTopic represents a topic along with a subscriber's preference for it.
+type SubscriberTopic struct {
+	Topic
+
+	Subscribed bool `db:"subscribed" json:"subscribed"`
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	txttpl "text/template"
+)
+
+// utmParams maps the keys in UTM templates to the UTM query params.
+var utmParams = map[string]string{
+	"source":   "utm_source",
+	"medium":   "utm_medium",
+	"campaign": "utm_campaign",
+	"term":     "utm_term",
+	"content":  "utm_content",
+}
+
+// UTMTemplate maps UTM params (source, medium, campaign, term, content) to
+// optionally {{ templated }} values, eg: {"source": "{{ .List.Name }}"}, that
+// are rendered for a campaign and added to the URLs of its tracked links.
+// A nil template on a campaign uses the global default template, and an
+// empty template disables UTM params for the campaign.
+type UTMTemplate map[string]string
+
+// UTMParams are the rendered UTM query params, eg: {"utm_medium": "email"}.
+type UTMParams map[string]string
+
+// UTMTemplateData is the data UTM templates are rendered with. List is the
+// first list the campaign targets.
+type UTMTemplateData struct {
+	Campaign *Campaign
+	List     struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+}
+
+// Compile validates the keys of a UTM template and compiles its values with
+// the given template functions.
+func (u UTMTemplate) Compile(f map[string]any) (map[string]*txttpl.Template, error) {
+	out := make(map[string]*txttpl.Template, len(u))
+	for k, v := range u {
+		if _, ok := utmParams[k]; !ok {
+			return nil, fmt.Errorf("unknown UTM param '%s'", k)
+		}
+
+		tpl, err := txttpl.New(ContentTpl).Funcs(f).Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling UTM param '%s': %v", k, err)
+		}
+		out[k] = tpl
+	}
+
+	return out, nil
+}
+
+// Render compiles and renders a UTM template into UTM params. Params that
+// render to an empty value are skipped.
+func (u UTMTemplate) Render(f map[string]any, data UTMTemplateData) (UTMParams, error) {
+	tpls, err := u.Compile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(UTMParams, len(tpls))
+	for k, tpl := range tpls {
+		var b bytes.Buffer
+		if err := tpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("error rendering UTM param '%s': %v", k, err)
+		}
+
+		if v := strings.TrimSpace(b.String()); v != "" {
+			out[utmParams[k]] = v
+		}
+	}
+
+	return out, nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (u *UTMTemplate) Scan(src any) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		*u = nil
+		return nil
+	}
+
+	return json.Unmarshal(b, u)
+}
+
+// Value implements the driver.Valuer interface. A nil template is stored as NULL.
+func (u UTMTemplate) Value() (driver.Value, error) {
+	if u == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(u)
+}
+
+// Scan implements the sql.Scanner interface.
+func (p *UTMParams) Scan(src any) error {
+	var b []byte
+	switch src := src.(type) {
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	case nil:
+		return nil
+	}
+
+	return json.Unmarshal(b, p)
+}
+
+// Value implements the driver.Valuer interface. Empty params are stored as NULL.
+func (p UTMParams) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+
+	return json.Marshal(p)
+}
@@ -1,7 +1,9 @@
 -- name: record-bounce
 -- Insert a bounce and count the bounces for the subscriber and either unsubscribe them,
 WITH sub AS (
-    SELECT id, status FROM subscribers WHERE CASE WHEN $1 != '' THEN uuid = $1::UUID ELSE email = $2 END
+    -- $1 is the subscriber's UUID, or the token in the X-Listmonk-Subscriber header of messages.
+    SELECT id, status FROM subscribers WHERE CASE WHEN $1 != '' THEN (uuid = $1::UUID OR unsubscribe_token = $1::UUID) ELSE email = $2 END
+    LIMIT 1
 ),
 camp AS (
     SELECT id FROM campaigns WHERE $3 != '' AND uuid = $3::UUID
@@ -14,25 +16,33 @@ num AS (
 block1 AS (
     UPDATE subscribers SET status='blocklisted'
     WHERE $9 = 'blocklist' AND (SELECT num FROM num) >= $8 AND id = (SELECT id FROM sub) AND (SELECT status FROM sub) != 'blocklisted'
+    RETURNING id
 ),
 block2 AS (
-    UPDATE subscriber_lists SET status='unsubscribed'
+    UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW()
     WHERE $9 = 'unsubscribe' AND (SELECT num FROM num) >= $8 AND subscriber_id = (SELECT id FROM sub) AND (SELECT status FROM sub) != 'blocklisted'
+    RETURNING subscriber_id
 ),
 bounce AS (
     -- Record the bounce if the subscriber is not already blocklisted;
-    INSERT INTO bounces (subscriber_id, campaign_id, type, source, meta, created_at)
-    SELECT (SELECT id FROM sub), (SELECT id FROM camp), $4, $5, $6, $7
+    INSERT INTO bounces (subscriber_id, campaign_id, type, reason, source, meta, created_at)
+    SELECT (SELECT id FROM sub), (SELECT id FROM camp), $4, COALESCE(NULLIF($10, ''), 'other')::bounce_reason, $5, $6, $7
     WHERE NOT EXISTS (SELECT 1 WHERE (SELECT status FROM sub) = 'blocklisted' OR (SELECT num FROM num) > $8)
 )
 -- This delete  will only run when $9 = 'delete' and the number of bounces exceed $8.
-DELETE FROM subscribers
-    WHERE $9 = 'delete' AND (SELECT num FROM num) >= $8 AND id = (SELECT id FROM sub);
+del AS (
+    DELETE FROM subscribers
+    WHERE $9 = 'delete' AND (SELECT num FROM num) >= $8 AND id = (SELECT id FROM sub)
+)
+-- The IDs of the subscriber if it was blocklisted or unsubscribed by the bounce, or 0.
+SELECT COALESCE((SELECT id FROM block1), 0) AS blocklisted_id,
+    COALESCE((SELECT subscriber_id FROM block2 LIMIT 1), 0) AS unsubscribed_id;
 
 -- name: query-bounces
 SELECT COUNT(*) OVER () AS total,
     bounces.id,
     bounces.type,
+    bounces.reason,
     bounces.source,
     bounces.meta,
     bounces.created_at,
@@ -52,7 +62,9 @@ WHERE ($1 = 0 OR bounces.id = $1)
     AND ($2 = 0 OR bounces.campaign_id = $2)
     AND ($3 = 0 OR bounces.subscriber_id = $3)
     AND ($4 = '' OR bounces.source = $4)
-ORDER BY %order% OFFSET $5 LIMIT (CASE WHEN $6 < 1 THEN NULL ELSE $6 END);
+    AND ($5 = '' OR bounces.reason = $5::bounce_reason)
+    AND ($8 = '' OR bounces.type = $8::bounce_type)
+ORDER BY %order% OFFSET $6 LIMIT (CASE WHEN $7 < 1 THEN NULL ELSE $7 END);
 
 -- name: delete-bounces
 DELETE FROM bounces WHERE $2 = TRUE OR id = ANY($1);
@@ -71,6 +83,53 @@ b AS (
     UPDATE subscribers SET status='blocklisted', updated_at=NOW()
     WHERE id = ANY(SELECT subscriber_id FROM subs)
 )
-UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
+UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW(), updated_at=NOW()
     WHERE subscriber_id = ANY(SELECT subscriber_id FROM subs);
 
+-- name: get-campaign-bounce-rates
+-- Returns campaigns that have sent at least $3 messages, and the bounces of which
+-- recorded in the last $1 seconds are at least $2 percent of the messages sent.
+SELECT campaigns.id AS campaign_id, campaigns.name, campaigns.sent, COUNT(bounces.id) AS bounces,
+    ROUND(COUNT(bounces.id) * 100.0 / campaigns.sent, 2)::FLOAT AS rate
+FROM bounces
+JOIN campaigns ON (campaigns.id = bounces.campaign_id)
+WHERE bounces.created_at > NOW() - ($1 * INTERVAL '1 second') AND campaigns.sent >= $3
+GROUP BY campaigns.id
+HAVING COUNT(bounces.id) * 100.0 / campaigns.sent >= $2
+ORDER BY rate DESC;
+
+-- name: get-bounce-received-headers
+-- Returns the Received headers (if any) of the bounce messages recorded in the last $1 seconds.
+SELECT (CASE WHEN JSONB_TYPEOF(meta->'received') = 'array'
+    THEN ARRAY(SELECT JSONB_ARRAY_ELEMENTS_TEXT(meta->'received')) ELSE '{}' END) AS received
+FROM bounces WHERE created_at > NOW() - ($1 * INTERVAL '1 second');
+
+-- name: insert-ip-reputation-stats
+INSERT INTO ip_reputation_stats (ip, bounces, total_bounces, window_seconds)
+    SELECT ip, bounces, $3, $4 FROM UNNEST($1::TEXT[], $2::INT[]) AS t(ip, bounces);
+
+-- name: get-bounce-reason-counts
+-- Counts bounces by reason along with their share of all the bounces. The bounces are
+-- optionally filtered by campaigns ($1), source ($2), and a created_at range ($3, $4).
+SELECT reason, COUNT(*) AS count,
+    ROUND(COUNT(*) * 100.0 / SUM(COUNT(*)) OVER (), 2)::FLOAT AS percent
+FROM bounces
+WHERE (COALESCE(CARDINALITY($1::INT[]), 0) = 0 OR campaign_id = ANY($1::INT[]))
+    AND ($2 = '' OR source = $2)
+    AND created_at >= COALESCE(NULLIF($3, '')::TIMESTAMP, '-infinity')
+    AND created_at <= COALESCE(NULLIF($4, '')::TIMESTAMP, 'infinity')
+GROUP BY reason ORDER BY count DESC;
+
+-- name: get-bounce-domain-counts
+-- Counts bounces by the domain of the subscribers' e-mail addresses and by bounce type,
+-- optionally filtered by a created_at range ($1, $2).
+SELECT LOWER(SUBSTRING(subscribers.email FROM '@([^@]*)$')) AS domain,
+    COUNT(*) FILTER (WHERE bounces.type = 'hard') AS hard,
+    COUNT(*) FILTER (WHERE bounces.type = 'soft') AS soft,
+    COUNT(*) FILTER (WHERE bounces.type = 'complaint') AS complaint,
+    COUNT(*) AS total
+FROM bounces
+JOIN subscribers ON (subscribers.id = bounces.subscriber_id)
+WHERE bounces.created_at >= COALESCE(NULLIF($1, '')::TIMESTAMP, '-infinity')
+    AND bounces.created_at <= COALESCE(NULLIF($2, '')::TIMESTAMP, 'infinity')
+GROUP BY domain ORDER BY total DESC, domain;
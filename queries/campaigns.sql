@@ -25,7 +25,10 @@ WITH tpl AS (
 camp AS (
     INSERT INTO campaigns (uuid, type, name, subject, from_email, body, altbody,
         content_type, send_at, headers, attribs, tags, messenger, template_id, to_send,
-        max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, body_source)
+        max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, body_source,
+        archive_cover_media_id, archive_accent_color, archive_excerpt, exclude_list_ids, journal_address, topic_ids, gate_url,
+        send_spread, send_spread_curve, segment_id, segment_params, freeze_audience, send_at_local_time, tracking_domain,
+        utm_template, body_url)
         SELECT $1, $2, $3, $4, $5,
             -- body
             COALESCE(NULLIF($6, ''), (SELECT body FROM tpl), ''),
@@ -40,7 +43,18 @@ camp AS (
             $18,
             $19,
             -- body_source
-            COALESCE($21, (SELECT body_source FROM tpl))
+            COALESCE($21, (SELECT body_source FROM tpl)),
+            $22, $23, $24,
+            COALESCE($25::INT[], '{}'),
+            $26,
+            COALESCE($27::INT[], '{}'),
+            $28,
+            $29, $30,
+            $31, $32,
+            $33, $34,
+            $35,
+            $36,
+            $37
         RETURNING id
 ),
 med AS (
@@ -61,6 +75,7 @@ SELECT id FROM camp;
 -- for pagination in the frontend, albeit being a field that'll repeat
 -- with every resultant row.
 SELECT  c.*,
+        (c.archive_password IS NOT NULL) AS archive_has_password,
         COUNT(*) OVER () AS total,
         (
             SELECT COALESCE(ARRAY_TO_JSON(ARRAY_AGG(l)), '[]') FROM (
@@ -68,12 +83,23 @@ SELECT  c.*,
                 campaign_lists.list_name AS name
                 FROM campaign_lists WHERE campaign_lists.campaign_id = c.id
         ) l
-    ) AS lists
+    ) AS lists,
+    -- Where the search query matched. name and subject are weighted A and B in search_tsv.
+    (CASE WHEN $4 = '' THEN ''
+        WHEN TS_FILTER(c.search_tsv, '{a}') @@ WEBSEARCH_TO_TSQUERY('simple', $9) OR c.name ILIKE $4 THEN 'name'
+        WHEN TS_FILTER(c.search_tsv, '{b}') @@ WEBSEARCH_TO_TSQUERY('simple', $9) OR c.subject ILIKE $4 OR NOT $10 THEN 'subject'
+        ELSE 'body'
+    END) AS match_type,
+    (CASE WHEN $4 = '' THEN 0 ELSE TS_RANK(c.search_tsv, WEBSEARCH_TO_TSQUERY('simple', $9)) END) AS relevance
 FROM campaigns c
 WHERE ($1 = 0 OR id = $1)
     AND (CARDINALITY($2::campaign_status[]) = 0 OR status = ANY($2))
     AND (CARDINALITY($3::VARCHAR(100)[]) = 0 OR $3 <@ tags)
-    AND ($4 = '' OR TO_TSVECTOR(CONCAT(name, ' ', subject)) @@ TO_TSQUERY($4) OR CONCAT(c.name, ' ', c.subject) ILIKE $4)
+    -- Search name + subject, and optionally, the body ($10).
+    AND ($4 = ''
+        OR (c.search_tsv @@ WEBSEARCH_TO_TSQUERY('simple', $9)
+            AND ($10 OR TS_FILTER(c.search_tsv, '{a,b}') @@ WEBSEARCH_TO_TSQUERY('simple', $9)))
+        OR CONCAT(c.name, ' ', c.subject) ILIKE $4)
     -- Get all campaigns or filter by list IDs.
     AND (
         $5 OR EXISTS (
@@ -83,8 +109,13 @@ WHERE ($1 = 0 OR id = $1)
 ORDER BY %order% OFFSET $7 LIMIT (CASE WHEN $8 < 1 THEN NULL ELSE $8 END);
 
 -- name: get-campaign
-SELECT campaigns.*,
-    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body
+SELECT campaigns.*, (campaigns.archive_password IS NOT NULL) AS archive_has_password,
+    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body,
+    -- Language variants of the campaign template. Archives always use the archive template.
+    (SELECT JSON_OBJECT_AGG(LOWER(v.lang), v.body) FROM templates v
+        WHERE $4 = 'default' AND v.lang IS NOT NULL
+        AND v.parent_template_id = COALESCE(templates.id, (SELECT id FROM templates WHERE is_default = true LIMIT 1))
+    ) AS template_variants
     FROM campaigns
     LEFT JOIN templates ON (
         CASE WHEN $4 = 'default' THEN templates.id = campaigns.template_id
@@ -105,6 +136,8 @@ SELECT COUNT(*) OVER () AS total, campaigns.*,
         ELSE templates.id = campaigns.archive_template_id END
     )
     WHERE campaigns.archive=true AND campaigns.type='regular' AND campaigns.status=ANY('{running, paused, finished}')
+    -- Optionally, only the campaigns sent to the list $4.
+    AND ($4 = 0 OR EXISTS (SELECT 1 FROM campaign_lists WHERE campaign_id = campaigns.id AND list_id = $4))
     ORDER by campaigns.created_at DESC OFFSET $1 LIMIT $2;
 
 -- name: get-campaign-stats
@@ -134,11 +167,17 @@ bounces AS (
     SELECT campaign_id, COUNT(campaign_id) as num FROM bounces
     WHERE campaign_id = ANY($1)
     GROUP BY campaign_id
+),
+unsub_clicks AS (
+    SELECT campaign_id, COUNT(DISTINCT data->>'subscriber_id') as num FROM campaign_events
+    WHERE campaign_id = ANY($1) AND type = 'unsubscribe_click'
+    GROUP BY campaign_id
 )
 SELECT id as campaign_id,
     COALESCE(v.num, 0) AS views,
     COALESCE(c.num, 0) AS clicks,
     COALESCE(b.num, 0) AS bounces,
+    COALESCE(u.num, 0) AS unsubscribe_click_count,
     COALESCE(l.lists, '[]') AS lists,
     COALESCE(m.media, '[]') AS media
 FROM (SELECT id FROM UNNEST($1) AS id) x
@@ -147,8 +186,212 @@ LEFT JOIN media AS m ON (m.campaign_id = id)
 LEFT JOIN views AS v ON (v.campaign_id = id)
 LEFT JOIN clicks AS c ON (c.campaign_id = id)
 LEFT JOIN bounces AS b ON (b.campaign_id = id)
+LEFT JOIN unsub_clicks AS u ON (u.campaign_id = id)
 ORDER BY ARRAY_POSITION($1, id);
 
+-- name: get-campaign-list-send-counts
+-- Returns the lists of a campaign ($1) that have send frequency limits along with the number
+-- of other campaigns sent or scheduled to them in the calendar week and month in which the
+-- campaign will be sent. That is its send_at if it's being scheduled ($2), or now.
+-- Paused campaigns that are resumed have already been counted, so all counts are 0 for them.
+WITH t AS (
+    SELECT (CASE WHEN $2 = 'scheduled' THEN COALESCE(send_at, NOW()) ELSE NOW() END) AS t
+    FROM campaigns WHERE id = $1 AND status != 'paused'
+),
+ls AS (
+    SELECT lists.* FROM lists
+    JOIN campaign_lists ON (campaign_lists.list_id = lists.id AND campaign_lists.campaign_id = $1)
+    WHERE lists.max_campaigns_per_week > 0 OR lists.max_campaigns_per_month > 0
+),
+camps AS (
+    SELECT campaign_lists.list_id, COALESCE(campaigns.started_at, campaigns.send_at, campaigns.updated_at) AS t
+    FROM campaign_lists
+    JOIN campaigns ON (campaigns.id = campaign_lists.campaign_id)
+    WHERE campaigns.id != $1
+        AND campaigns.status IN ('scheduled', 'running', 'paused', 'finished')
+        AND campaign_lists.list_id IN (SELECT id FROM ls)
+)
+SELECT ls.id, ls.name, ls.max_campaigns_per_week, ls.max_campaigns_per_month,
+    (SELECT COUNT(*) FROM camps, t WHERE camps.list_id = ls.id
+        AND DATE_TRUNC('week', camps.t) = DATE_TRUNC('week', t.t)) AS week_count,
+    (SELECT COUNT(*) FROM camps, t WHERE camps.list_id = ls.id
+        AND DATE_TRUNC('month', camps.t) = DATE_TRUNC('month', t.t)) AS month_count
+FROM ls ORDER BY ls.id;
+
+-- name: get-campaign-audience-count
+-- Counts the subscribers a campaign would be sent to if it were started now.
+-- The subscription status rules are the same as in next-campaigns.
+SELECT COUNT(DISTINCT sl.subscriber_id) FROM campaigns c
+    JOIN campaign_lists cl ON cl.campaign_id = c.id
+    JOIN lists l ON l.id = cl.list_id
+    JOIN subscriber_lists sl ON sl.list_id = l.id
+        AND (
+            CASE
+                WHEN c.type = 'optin' THEN sl.status = 'unconfirmed' AND l.optin = 'double'
+                WHEN l.optin = 'double' THEN sl.status = 'confirmed'
+                ELSE sl.status != 'unsubscribed'
+            END
+        )
+    JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted')
+    WHERE c.id = $1
+        -- Subscribers on any of the campaign's excluded lists are skipped.
+        AND sl.subscriber_id NOT IN (SELECT subscriber_id FROM subscriber_lists WHERE list_id = ANY(c.exclude_list_ids))
+        -- Subscribers who have opted out of any of the campaign's topics are skipped.
+        AND NOT EXISTS (SELECT 1 FROM subscriber_topics st WHERE st.subscriber_id = sl.subscriber_id
+            AND st.topic_id = ANY(c.topic_ids) AND NOT st.subscribed)
+        -- If the audience is frozen, only the subscribers in the snapshot are counted.
+        AND (c.audience_frozen_at IS NULL OR EXISTS (SELECT 1 FROM campaign_audience_snapshots a
+            WHERE a.campaign_id = c.id AND a.subscriber_id = sl.subscriber_id));
+
+-- name: get-campaign-subscriber-overlap
+-- Counts the subscribers in the audience of campaign $1 who are also in the audience of
+-- each of the campaigns $2. Audiences follow the rules of get-campaign-audience-count.
+WITH aud AS (
+    SELECT DISTINCT c.id AS campaign_id, sl.subscriber_id FROM campaigns c
+    JOIN campaign_lists cl ON cl.campaign_id = c.id
+    JOIN lists l ON l.id = cl.list_id
+    JOIN subscriber_lists sl ON sl.list_id = l.id
+        AND (
+            CASE
+                WHEN c.type = 'optin' THEN sl.status = 'unconfirmed' AND l.optin = 'double'
+                WHEN l.optin = 'double' THEN sl.status = 'confirmed'
+                ELSE sl.status != 'unsubscribed'
+            END
+        )
+    JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted')
+    WHERE c.id = ANY($1::INT || $2::INT[])
+        AND sl.subscriber_id NOT IN (SELECT subscriber_id FROM subscriber_lists WHERE list_id = ANY(c.exclude_list_ids))
+        AND NOT EXISTS (SELECT 1 FROM subscriber_topics st WHERE st.subscriber_id = sl.subscriber_id
+            AND st.topic_id = ANY(c.topic_ids) AND NOT st.subscribed)
+        AND (c.audience_frozen_at IS NULL OR EXISTS (SELECT 1 FROM campaign_audience_snapshots a
+            WHERE a.campaign_id = c.id AND a.subscriber_id = sl.subscriber_id))
+)
+SELECT c.id AS campaign_id, COUNT(b.subscriber_id) AS overlap_count FROM campaigns c
+    LEFT JOIN aud b ON (b.campaign_id = c.id
+        AND b.subscriber_id IN (SELECT subscriber_id FROM aud WHERE campaign_id = $1))
+    WHERE c.id = ANY($2::INT[])
+    GROUP BY c.id
+    ORDER BY c.id;
+
+-- name: get-campaign-send-report
+-- Breaks down the audience of a campaign (the subscribers in its lists, up to the
+-- max_subscriber_id it was started with) into the ones that were sent the campaign, the
+-- ones that were skipped for being blocklisted after bouncing, the ones that were skipped
+-- by the other send rules of next-campaign-subscribers (blocklisted, unsubscribed, excluded
+-- lists, topic opt-outs), and the remaining ones that would still be sent to.
+WITH subs AS (
+    SELECT sl.subscriber_id, s.status,
+        BOOL_OR(
+            CASE
+                WHEN c.type = 'optin' THEN sl.status = 'unconfirmed' AND l.optin = 'double'
+                WHEN l.optin = 'double' THEN sl.status = 'confirmed'
+                ELSE sl.status != 'unsubscribed'
+            END
+        ) AS subscribed,
+        BOOL_OR(sl.subscriber_id IN (SELECT subscriber_id FROM subscriber_lists WHERE list_id = ANY(c.exclude_list_ids))
+            OR EXISTS (SELECT 1 FROM subscriber_topics st WHERE st.subscriber_id = sl.subscriber_id
+                AND st.topic_id = ANY(c.topic_ids) AND NOT st.subscribed)) AS excluded
+    FROM campaigns c
+    JOIN campaign_lists cl ON cl.campaign_id = c.id
+    JOIN lists l ON l.id = cl.list_id
+    JOIN subscriber_lists sl ON sl.list_id = l.id
+    JOIN subscribers s ON s.id = sl.subscriber_id
+    WHERE c.id = $1
+        AND (c.max_subscriber_id = 0 OR sl.subscriber_id <= c.max_subscriber_id)
+        -- If the audience is frozen, only the subscribers in the snapshot are the audience.
+        AND (c.audience_frozen_at IS NULL OR EXISTS (SELECT 1 FROM campaign_audience_snapshots a
+            WHERE a.campaign_id = c.id AND a.subscriber_id = sl.subscriber_id))
+    GROUP BY sl.subscriber_id, s.status
+),
+sent AS (
+    SELECT DISTINCT subscriber_id FROM campaign_sends WHERE campaign_id = $1
+),
+unsent AS (
+    SELECT subs.*, (subs.status = 'blocklisted' AND EXISTS (SELECT 1 FROM bounces b WHERE b.subscriber_id = subs.subscriber_id)) AS bounced
+    FROM subs WHERE subs.subscriber_id NOT IN (SELECT subscriber_id FROM sent)
+)
+SELECT (SELECT COUNT(*) FROM sent) AS sent,
+    COUNT(*) FILTER (WHERE NOT bounced AND (status = 'blocklisted' OR NOT subscribed OR excluded)) AS skipped_suppressed,
+    COUNT(*) FILTER (WHERE bounced) AS skipped_bounced,
+    COUNT(*) FILTER (WHERE status != 'blocklisted' AND subscribed AND NOT excluded) AS remaining_unsent
+FROM unsent;
+
+-- name: get-campaign-audience-trend
+-- Approximates the number of subscribers a campaign would have been sent to at the end of
+-- each of the last $2 days with the rules of get-campaign-audience-count, ignoring any
+-- frozen snapshot. Subscription history isn't recorded, so a subscription counts from
+-- its creation, and one that is now unsubscribed, until its last update.
+WITH days AS (
+    SELECT GENERATE_SERIES(CURRENT_DATE - ($2::INT - 1), CURRENT_DATE, '1 day')::DATE AS day
+),
+subs AS (
+    SELECT sl.subscriber_id, sl.created_at,
+        (CASE WHEN sl.status = 'unsubscribed' THEN sl.updated_at ELSE NULL END) AS ended_at
+    FROM campaigns c
+    JOIN campaign_lists cl ON cl.campaign_id = c.id
+    JOIN lists l ON l.id = cl.list_id
+    JOIN subscriber_lists sl ON sl.list_id = l.id
+        AND (
+            CASE
+                WHEN c.type = 'optin' THEN sl.status = 'unconfirmed' AND l.optin = 'double'
+                WHEN l.optin = 'double' THEN sl.status IN ('confirmed', 'unsubscribed')
+                ELSE TRUE
+            END
+        )
+    JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted')
+    WHERE c.id = $1
+        AND sl.subscriber_id NOT IN (SELECT subscriber_id FROM subscriber_lists WHERE list_id = ANY(c.exclude_list_ids))
+        AND NOT EXISTS (SELECT 1 FROM subscriber_topics st WHERE st.subscriber_id = sl.subscriber_id
+            AND st.topic_id = ANY(c.topic_ids) AND NOT st.subscribed)
+)
+SELECT TO_CHAR(days.day, 'YYYY-MM-DD') AS date, (
+    SELECT COUNT(DISTINCT subs.subscriber_id) FROM subs
+        WHERE subs.created_at < days.day + 1 AND (subs.ended_at IS NULL OR subs.ended_at >= days.day + 1)
+) AS count
+FROM days ORDER BY days.day;
+
+-- name: freeze-campaign-audience
+-- Snapshots the subscribers a campaign would be sent to now (get-campaign-audience-count)
+-- into campaign_audience_snapshots and returns the number of subscribers in it. Any previous
+-- snapshot should be dropped first with unfreeze-campaign-audience.
+WITH subs AS (
+    INSERT INTO campaign_audience_snapshots (campaign_id, subscriber_id)
+    SELECT DISTINCT c.id, sl.subscriber_id FROM campaigns c
+        JOIN campaign_lists cl ON cl.campaign_id = c.id
+        JOIN lists l ON l.id = cl.list_id
+        JOIN subscriber_lists sl ON sl.list_id = l.id
+            AND (
+                CASE
+                    WHEN c.type = 'optin' THEN sl.status = 'unconfirmed' AND l.optin = 'double'
+                    WHEN l.optin = 'double' THEN sl.status = 'confirmed'
+                    ELSE sl.status != 'unsubscribed'
+                END
+            )
+        JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted')
+        WHERE c.id = $1
+            AND sl.subscriber_id NOT IN (SELECT subscriber_id FROM subscriber_lists WHERE list_id = ANY(c.exclude_list_ids))
+            AND NOT EXISTS (SELECT 1 FROM subscriber_topics st WHERE st.subscriber_id = sl.subscriber_id
+                AND st.topic_id = ANY(c.topic_ids) AND NOT st.subscribed)
+    ON CONFLICT DO NOTHING
+    RETURNING subscriber_id
+)
+UPDATE campaigns SET audience_frozen_at=NOW() WHERE id = $1 RETURNING (SELECT COUNT(*) FROM subs);
+
+-- name: unfreeze-campaign-audience
+-- Drops the frozen audience snapshot of a campaign.
+WITH d AS (
+    DELETE FROM campaign_audience_snapshots WHERE campaign_id = $1
+)
+UPDATE campaigns SET audience_frozen_at=NULL WHERE id = $1;
+
+-- name: get-campaign-audience-snapshot
+-- Returns a batch of subscriber IDs in the frozen audience of a campaign after the ID $2.
+SELECT subscriber_id FROM campaign_audience_snapshots WHERE campaign_id = $1 AND subscriber_id > $2
+    ORDER BY subscriber_id LIMIT $3;
+
+-- name: delete-campaign-audience-snapshot-subscribers
+DELETE FROM campaign_audience_snapshots WHERE campaign_id = $1 AND subscriber_id = ANY($2::INT[]);
+
 -- name: get-campaign-for-preview
 SELECT campaigns.*, COALESCE(templates.body, '') AS template_body,
 (
@@ -180,11 +423,22 @@ SELECT EXISTS (
 -- a campaign. This is used to fetch and slice subscribers for the campaign in next-campaign-subscribers.
 WITH camps AS (
     -- Get all running campaigns and their template bodies (if the template's deleted, the default template body instead)
-    SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body
+    SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body,
+    -- Language variants of the template for subscribers with a language preference.
+    (SELECT JSON_OBJECT_AGG(LOWER(v.lang), v.body) FROM templates v
+        WHERE v.lang IS NOT NULL
+        AND v.parent_template_id = COALESCE(templates.id, (SELECT id FROM templates WHERE is_default = true LIMIT 1))
+    ) AS template_variants
     FROM campaigns
     LEFT JOIN templates ON (templates.id = campaigns.template_id)
     WHERE (status='running' OR (status='scheduled' AND NOW() >= campaigns.send_at))
     AND NOT(campaigns.id = ANY($1::INT[]))
+    -- Campaigns with an approval gate are only picked up once they're approved.
+    AND (campaigns.gate_url = '' OR campaigns.gate_status IN ('approved', 'overridden'))
+    -- Campaigns that were started earlier, and then the ones queued the longest, are picked up
+    -- first when the number of campaigns that can run concurrently is limited ($3, -1 for no limit).
+    ORDER BY campaigns.started_at NULLS LAST, campaigns.send_at NULLS LAST, campaigns.id
+    LIMIT NULLIF($3::INT, -1)
 ),
 campLists AS (
     -- Get the list_ids and their optin statuses for the campaigns found in the previous step.
@@ -211,6 +465,12 @@ counts AS (
             END
         )
     JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted')
+    WHERE sl.subscriber_id NOT IN (SELECT subscriber_id FROM subscriber_lists WHERE list_id = ANY(camps.exclude_list_ids))
+        AND NOT EXISTS (SELECT 1 FROM subscriber_topics st WHERE st.subscriber_id = sl.subscriber_id
+            AND st.topic_id = ANY(camps.topic_ids) AND NOT st.subscribed)
+        -- Campaigns with a frozen audience are only sent to the subscribers in the snapshot.
+        AND (camps.audience_frozen_at IS NULL OR EXISTS (SELECT 1 FROM campaign_audience_snapshots a
+            WHERE a.campaign_id = camps.id AND a.subscriber_id = sl.subscriber_id))
     GROUP BY camps.id
 ),
 updateCounts AS (
@@ -266,15 +526,213 @@ SELECT campaign_id, COUNT(*) AS "count", DATE_TRUNC((SELECT * FROM intval), crea
     WHERE campaign_id=ANY($1) AND created_at >= $2 AND created_at <= $3
     GROUP BY campaign_id, "timestamp" ORDER BY "timestamp" ASC;
 
+-- name: get-campaign-bounce-type-counts
+SELECT campaign_id, type, COUNT(*) AS "count"
+    FROM bounces
+    WHERE campaign_id=ANY($1) AND created_at >= $2 AND created_at <= $3
+    GROUP BY campaign_id, type ORDER BY campaign_id, type;
+
+-- name: get-campaign-funnel-counts
+-- Views and clicks are counted once per subscriber, and anonymous ones (when individual
+-- tracking is off) individually. Unsubscribe link clicks and confirmed unsubscriptions
+-- are counted once per subscriber.
+SELECT c.sent, c.delivered,
+    (SELECT COUNT(DISTINCT subscriber_id) FROM bounces WHERE campaign_id = c.id) AS bounced,
+    (SELECT COUNT(DISTINCT subscriber_id) + COUNT(*) FILTER (WHERE subscriber_id IS NULL)
+        FROM campaign_views WHERE campaign_id = c.id) AS opened,
+    (SELECT COUNT(DISTINCT subscriber_id) + COUNT(*) FILTER (WHERE subscriber_id IS NULL)
+        FROM link_clicks WHERE campaign_id = c.id) AS clicked,
+    (SELECT COUNT(DISTINCT data->>'subscriber_id') FROM campaign_events
+        WHERE campaign_id = c.id AND type = 'unsubscribe_click') AS unsubscribe_clicked,
+    (SELECT COUNT(DISTINCT data->>'subscriber_id') FROM campaign_events
+        WHERE campaign_id = c.id AND type = 'unsubscribe') AS unsubscribed
+    FROM campaigns c WHERE c.id = $1;
+
+-- name: record-campaign-deliveries
+-- Adds $2 confirmed deliveries to the campaign with the UUID $1.
+UPDATE campaigns SET delivered = delivered + $2 WHERE uuid = $1 RETURNING id;
+
+-- name: get-campaign-view-events
+-- Returns up to $3 views of campaign $1 after the view ID $2 (cursor).
+SELECT v.id, v.subscriber_id, s.uuid AS subscriber_uuid, s.email,
+    JSONB_BUILD_OBJECT('revision', v.revision) AS data, v.created_at
+    FROM campaign_views v
+    LEFT JOIN subscribers s ON (s.id = v.subscriber_id)
+    WHERE v.campaign_id = $1 AND v.id > $2
+    ORDER BY v.id LIMIT $3;
+
+-- name: get-campaign-click-events
+-- Returns up to $3 link clicks of campaign $1 after the click ID $2 (cursor).
+SELECT c.id, c.subscriber_id, s.uuid AS subscriber_uuid, s.email,
+    JSONB_BUILD_OBJECT('url', l.url, 'revision', c.revision) AS data, c.created_at
+    FROM link_clicks c
+    LEFT JOIN links l ON (l.id = c.link_id)
+    LEFT JOIN subscribers s ON (s.id = c.subscriber_id)
+    WHERE c.campaign_id = $1 AND c.id > $2
+    ORDER BY c.id LIMIT $3;
+
+-- name: get-campaign-bounce-events
+-- Returns up to $3 bounces of campaign $1 after the bounce ID $2 (cursor).
+SELECT b.id, b.subscriber_id, s.uuid AS subscriber_uuid, s.email,
+    JSONB_BUILD_OBJECT('type', b.type, 'source', b.source, 'reason', b.reason) AS data, b.created_at
+    FROM bounces b
+    LEFT JOIN subscribers s ON (s.id = b.subscriber_id)
+    WHERE b.campaign_id = $1 AND b.id > $2
+    ORDER BY b.id LIMIT $3;
+
+-- name: get-campaign-unsubscribe-events
+-- Returns up to $3 unsubscriptions from campaign $1 after the event ID $2 (cursor).
+SELECT e.id, s.id AS subscriber_id, s.uuid AS subscriber_uuid, s.email,
+    e.data - 'subscriber_id' AS data, e.created_at
+    FROM campaign_events e
+    LEFT JOIN subscribers s ON (s.id = (e.data->>'subscriber_id')::INT)
+    WHERE e.campaign_id = $1 AND e.type = 'unsubscribe' AND e.id > $2
+    ORDER BY e.id LIMIT $3;
+
+-- name: get-campaign-unsubscribe-click-events
+-- Returns up to $3 unsubscribe link clicks from campaign $1 after the event ID $2 (cursor).
+SELECT e.id, s.id AS subscriber_id, s.uuid AS subscriber_uuid, s.email,
+    e.data - 'subscriber_id' AS data, e.created_at
+    FROM campaign_events e
+    LEFT JOIN subscribers s ON (s.id = (e.data->>'subscriber_id')::INT)
+    WHERE e.campaign_id = $1 AND e.type = 'unsubscribe_click' AND e.id > $2
+    ORDER BY e.id LIMIT $3;
+
+-- name: register-unsubscribe-click
+-- Records an 'unsubscribe_click' event on the campaign with the UUID $1 for the subscriber $2
+-- who clicked the unsubscribe link in its message. Nothing is recorded for unknown campaigns.
+INSERT INTO campaign_events (campaign_id, type, data)
+    SELECT id, 'unsubscribe_click', JSONB_BUILD_OBJECT('subscriber_id', $2::INT)
+    FROM campaigns WHERE uuid = $1;
+
 -- name: get-campaign-link-counts
 -- raw: true
 -- %s = * or DISTINCT subscriber_id (prepared based on based on individual tracking=on/off). Prepared on boot.
-SELECT COUNT(%s) AS "count", url
+SELECT COUNT(%s) AS "count", COUNT(link_clicks.converted_at) AS conversions, url
     FROM link_clicks
     LEFT JOIN links ON (link_clicks.link_id = links.id)
     WHERE campaign_id=ANY($1) AND link_clicks.created_at >= $2 AND link_clicks.created_at <= $3
     GROUP BY links.url ORDER BY "count" DESC LIMIT 50;
 
+-- name: get-engagement-heatmap
+-- Aggregates unique subscribers who viewed or clicked campaigns in the last $1 days
+-- by day-of-week (0 = Sunday) and hour-of-day in the timezone $2. If $3 > 0, only
+-- campaigns sent to that list are considered. The created_at range is applied on each
+-- table directly so that the date indexes are used.
+WITH camps AS (
+    SELECT DISTINCT campaign_id AS id FROM campaign_lists WHERE $3 > 0 AND list_id = $3
+),
+events AS (
+    SELECT 'views' AS type, subscriber_id, created_at FROM campaign_views
+        WHERE created_at >= NOW() - MAKE_INTERVAL(days => $1::INT)
+        AND ($3 = 0 OR campaign_id = ANY(SELECT id FROM camps))
+    UNION ALL
+    SELECT 'clicks' AS type, subscriber_id, created_at FROM link_clicks
+        WHERE created_at >= NOW() - MAKE_INTERVAL(days => $1::INT)
+        AND ($3 = 0 OR campaign_id = ANY(SELECT id FROM camps))
+)
+SELECT type,
+    EXTRACT(DOW FROM created_at AT TIME ZONE $2)::INT AS dow,
+    EXTRACT(HOUR FROM created_at AT TIME ZONE $2)::INT AS hour,
+    COUNT(DISTINCT subscriber_id) AS "count"
+    FROM events WHERE subscriber_id IS NOT NULL
+    GROUP BY type, dow, hour;
+
+-- name: get-subscriber-cohorts
+-- Groups the subscribers who subscribed (to list $2, or to listmonk if 0) in the last $1 weeks
+-- by the week they subscribed in and counts the campaigns sent to each cohort by the number
+-- of weeks since the cohort's week, along with the ones that were viewed and clicked. There's
+-- no log of the messages sent, so the campaigns sent to a subscriber are the running or finished
+-- campaigns that started after they subscribed to any of the campaign's lists. Cohorts with no
+-- campaigns have a single row with week -1.
+WITH subs AS (
+    SELECT s.id, DATE_TRUNC('week', (CASE WHEN $2 > 0 THEN sl.created_at ELSE s.created_at END)) AS cohort
+    FROM subscribers s
+    LEFT JOIN subscriber_lists sl ON (sl.subscriber_id = s.id AND sl.list_id = $2)
+    WHERE ($2 = 0 OR sl.list_id IS NOT NULL)
+        AND (CASE WHEN $2 > 0 THEN sl.created_at ELSE s.created_at END) >= DATE_TRUNC('week', NOW()) - MAKE_INTERVAL(weeks => $1::INT - 1)
+),
+sizes AS (
+    SELECT cohort, COUNT(*) AS size FROM subs GROUP BY cohort
+),
+sends AS (
+    SELECT DISTINCT subs.id AS subscriber_id, subs.cohort, c.id AS campaign_id,
+        FLOOR(EXTRACT(EPOCH FROM c.started_at - subs.cohort) / 604800)::INT AS week
+    FROM subs
+    JOIN subscriber_lists sl ON (sl.subscriber_id = subs.id AND ($2 = 0 OR sl.list_id = $2))
+    JOIN campaign_lists cl ON (cl.list_id = sl.list_id)
+    JOIN campaigns c ON (c.id = cl.campaign_id)
+    WHERE c.type = 'regular' AND c.status IN ('running', 'finished') AND c.started_at >= sl.created_at
+),
+counts AS (
+    SELECT cohort, week, COUNT(*) AS sent,
+        COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM campaign_views v
+            WHERE v.campaign_id = sends.campaign_id AND v.subscriber_id = sends.subscriber_id)) AS views,
+        COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM link_clicks k
+            WHERE k.campaign_id = sends.campaign_id AND k.subscriber_id = sends.subscriber_id)) AS clicks
+    FROM sends GROUP BY cohort, week
+)
+SELECT sizes.cohort, sizes.size, COALESCE(counts.week, -1) AS week, COALESCE(counts.sent, 0) AS sent,
+    COALESCE(counts.views, 0) AS views, COALESCE(counts.clicks, 0) AS clicks
+    FROM sizes LEFT JOIN counts ON (counts.cohort = sizes.cohort)
+    ORDER BY sizes.cohort, week;
+
+-- name: get-send-frequency
+-- Counts the campaigns started in each (ISO) week in the last $1 days, and the subscriptions
+-- unsubscribed in the week out of the subscriptions that were active in it, optionally only
+-- of list $2. Unsubscriptions aren't logged, so a subscription's updated_at is taken as the
+-- time it was unsubscribed.
+WITH weeks AS (
+    SELECT GENERATE_SERIES(DATE_TRUNC('week', NOW() - MAKE_INTERVAL(days => $1::INT)), DATE_TRUNC('week', NOW()), '1 week') AS week
+),
+camps AS (
+    SELECT DATE_TRUNC('week', c.started_at) AS week, COUNT(*) AS n FROM campaigns c
+    WHERE c.type = 'regular' AND c.started_at >= (SELECT MIN(week) FROM weeks) AND c.sent > 0
+        AND ($2 = 0 OR EXISTS (SELECT 1 FROM campaign_lists cl WHERE cl.campaign_id = c.id AND cl.list_id = $2))
+    GROUP BY 1
+),
+unsubs AS (
+    SELECT DATE_TRUNC('week', sl.updated_at) AS week, COUNT(*) AS n FROM subscriber_lists sl
+    WHERE sl.status = 'unsubscribed' AND sl.updated_at >= (SELECT MIN(week) FROM weeks)
+        AND ($2 = 0 OR sl.list_id = $2)
+    GROUP BY 1
+)
+SELECT TO_CHAR(weeks.week, 'IYYY-"W"IW') AS week, weeks.week AS starts_at,
+    COALESCE(camps.n, 0) AS campaigns_sent, COALESCE(unsubs.n, 0) AS unsubscribes,
+    (SELECT COUNT(*) FROM subscriber_lists sl WHERE ($2 = 0 OR sl.list_id = $2)
+        AND sl.created_at < weeks.week + INTERVAL '1 week'
+        AND (sl.status != 'unsubscribed' OR sl.updated_at >= weeks.week)) AS subscriptions
+    FROM weeks
+    LEFT JOIN camps ON (camps.week = weeks.week)
+    LEFT JOIN unsubs ON (unsubs.week = weeks.week)
+    ORDER BY weeks.week;
+
+-- name: get-comparable-campaigns
+-- Returns the $2 most recent finished campaigns that were sent to any of the lists of
+-- campaign $1 with their unique view, click, and unsubscribe counts. Views and clicks
+-- without a subscriber (individual tracking off) are counted as-is. Unsubscriptions aren't
+-- linked to campaigns, so the subscriptions to a campaign's lists that were unsubscribed
+-- within $3 days of it starting are attributed to it.
+WITH camps AS (
+    SELECT c.id, c.sent, COALESCE(c.started_at, c.created_at) AS started_at FROM campaigns c
+    WHERE c.id != $1 AND c.status = 'finished' AND c.type = 'regular' AND c.sent > 0
+        AND EXISTS (
+            SELECT 1 FROM campaign_lists cl WHERE cl.campaign_id = c.id
+                AND cl.list_id = ANY(SELECT list_id FROM campaign_lists WHERE campaign_id = $1)
+        )
+    ORDER BY started_at DESC LIMIT $2
+)
+SELECT camps.*,
+    (SELECT COUNT(DISTINCT subscriber_id) + COUNT(*) FILTER (WHERE subscriber_id IS NULL)
+        FROM campaign_views WHERE campaign_id = camps.id) AS views,
+    (SELECT COUNT(DISTINCT subscriber_id) + COUNT(*) FILTER (WHERE subscriber_id IS NULL)
+        FROM link_clicks WHERE campaign_id = camps.id) AS clicks,
+    (SELECT COUNT(DISTINCT subscriber_id) FROM subscriber_lists
+        WHERE list_id = ANY(SELECT list_id FROM campaign_lists WHERE campaign_id = camps.id)
+        AND status = 'unsubscribed' AND updated_at >= camps.started_at
+        AND updated_at < camps.started_at + MAKE_INTERVAL(days => $3::INT)) AS unsubscribes
+FROM camps ORDER BY started_at DESC;
+
 -- name: export-campaign-views
 SELECT campaign_views.campaign_id,
        COALESCE(campaigns.uuid::TEXT, '') AS campaign_uuid,
@@ -290,6 +748,50 @@ SELECT campaign_views.campaign_id,
     WHERE campaign_views.created_at >= $1
     ORDER BY campaign_views.id ASC LIMIT $2 OFFSET $3;
 
+-- name: export-campaign-subscriber-analytics
+-- Streams the engagement of each subscriber a campaign ($1) was sent to. Individual send
+-- times aren't recorded, and sent_at is when the campaign started. The subscribers are the
+-- ones in the campaign's lists up to the last subscriber it was sent to, except the
+-- unconfirmed subscribers of double opt-in lists and the ones whose messages failed.
+WITH camp AS (
+    SELECT id, started_at, last_subscriber_id FROM campaigns WHERE id = $1
+),
+views AS (
+    SELECT subscriber_id, MIN(created_at) AS created_at FROM campaign_views
+        WHERE campaign_id = $1 AND subscriber_id IS NOT NULL GROUP BY subscriber_id
+),
+clicks AS (
+    SELECT subscriber_id, MIN(created_at) AS created_at FROM link_clicks
+        WHERE campaign_id = $1 AND subscriber_id IS NOT NULL GROUP BY subscriber_id
+),
+bounced AS (
+    SELECT DISTINCT subscriber_id FROM bounces WHERE campaign_id = $1
+),
+unsubs AS (
+    SELECT DISTINCT (data->>'subscriber_id')::INT AS subscriber_id FROM campaign_events
+        WHERE campaign_id = $1 AND type = 'unsubscribe'
+),
+subs AS (
+    SELECT DISTINCT sl.subscriber_id AS id FROM subscriber_lists sl
+        JOIN campaign_lists cl ON (cl.list_id = sl.list_id AND cl.campaign_id = $1)
+        JOIN lists ON (lists.id = sl.list_id)
+        WHERE sl.subscriber_id <= (SELECT last_subscriber_id FROM camp)
+            AND NOT (lists.optin = 'double' AND sl.status = 'unconfirmed')
+            AND sl.subscriber_id NOT IN (SELECT subscriber_id FROM campaign_send_failures WHERE campaign_id = $1)
+)
+SELECT s.email, (SELECT started_at FROM camp) AS sent_at,
+       views.created_at AS opened_at,
+       clicks.created_at AS first_clicked_at,
+       bounced.subscriber_id IS NOT NULL AS bounced,
+       unsubs.subscriber_id IS NOT NULL AS unsubscribed
+    FROM subs
+    JOIN subscribers s ON (s.id = subs.id)
+    LEFT JOIN views ON (views.subscriber_id = s.id)
+    LEFT JOIN clicks ON (clicks.subscriber_id = s.id)
+    LEFT JOIN bounced ON (bounced.subscriber_id = s.id)
+    LEFT JOIN unsubs ON (unsubs.subscriber_id = s.id)
+    ORDER BY s.id;
+
 -- name: export-campaign-link-clicks
 SELECT link_clicks.campaign_id,
        COALESCE(campaigns.uuid::TEXT, '') AS campaign_uuid,
@@ -298,7 +800,7 @@ SELECT link_clicks.campaign_id,
        COALESCE(subscribers.uuid::TEXT, '') AS subscriber_uuid,
        COALESCE(subscribers.email, '') AS email,
        COALESCE(subscribers.name, '') AS subscriber_name,
-       links.url, link_clicks.created_at
+       links.url, COALESCE(link_clicks.utm::TEXT, '') AS utm, link_clicks.created_at
     FROM link_clicks
     LEFT JOIN campaigns ON (campaigns.id = link_clicks.campaign_id)
     LEFT JOIN links ON (links.id = link_clicks.link_id)
@@ -309,12 +811,60 @@ SELECT link_clicks.campaign_id,
 -- name: get-running-campaign
 -- Returns the metadata for a running campaign that is required by next-campaign-subscribers to retrieve
 -- a batch of campaign subscribers for processing.
-SELECT campaigns.id AS campaign_id, campaigns.type as campaign_type, last_subscriber_id, max_subscriber_id, lists.id AS list_id
+SELECT campaigns.id AS campaign_id, campaigns.type as campaign_type, last_subscriber_id, max_subscriber_id,
+    exclude_list_ids, topic_ids, segment_id, segment_params, audience_frozen_at IS NOT NULL AS audience_frozen,
+    lists.id AS list_id
     FROM campaigns
     JOIN campaign_lists ON (campaign_lists.campaign_id = campaigns.id)
     JOIN lists ON (lists.id = campaign_lists.list_id)
     WHERE campaigns.id = $1 AND campaigns.status='running';
 
+-- name: get-campaign-timezones
+-- Returns the distinct timezones in the `timezone` attribute of the subscribers in a campaign's lists.
+SELECT DISTINCT s.attribs->>'timezone' FROM subscribers s
+    JOIN subscriber_lists sl ON sl.subscriber_id = s.id
+    JOIN campaign_lists cl ON cl.list_id = sl.list_id
+    WHERE cl.campaign_id = $1 AND COALESCE(s.attribs->>'timezone', '') != '';
+
+-- name: update-campaign-remote-body
+-- Saves the body of a campaign fetched from its body URL as it starts running so that
+-- archive and public pages render the body that was sent.
+UPDATE campaigns SET body=$2 WHERE id=$1;
+
+-- name: update-campaign-wave
+-- Moves a running campaign on to the timezone wave that's due at $2 and resets the
+-- subscriber checkpoint so that the lists are processed again for the wave.
+UPDATE campaigns SET local_wave_at=$2, last_subscriber_id=0, updated_at=NOW()
+    WHERE id=$1 AND status='running';
+
+-- name: queue-campaigns
+-- Queues the campaigns that are due to run, except the ones in $1 that are already running,
+-- as scheduled to be retried at $2 when the limit of concurrent campaigns is reached.
+UPDATE campaigns SET status='scheduled', send_at=$2, updated_at=NOW()
+    WHERE (status='running' OR (status='scheduled' AND NOW() >= send_at))
+    AND NOT(id = ANY($1::INT[]))
+    AND (gate_url = '' OR gate_status IN ('approved', 'overridden'));
+
+-- name: queue-campaign
+-- Queues a running campaign as scheduled to be retried at $3 if there are $2 or more
+-- other campaigns running.
+UPDATE campaigns SET status='scheduled', send_at=$3, updated_at=NOW()
+    WHERE id=$1 AND status='running'
+    AND (SELECT COUNT(*) FROM campaigns WHERE status='running' AND id != $1) >= $2;
+
+-- name: get-campaign-queue-position
+-- Returns the position of a scheduled campaign in the queue of scheduled campaigns ordered
+-- by their send time, or 0 if the campaign isn't scheduled.
+SELECT COUNT(*) FROM campaigns
+    WHERE status='scheduled'
+    AND (send_at, id) <= (SELECT send_at, id FROM campaigns WHERE id=$1 AND status='scheduled');
+
+-- name: get-campaign-lists
+-- Returns the lists of a campaign that still exist.
+SELECT lists.* FROM lists
+    JOIN campaign_lists ON (campaign_lists.list_id = lists.id)
+    WHERE campaign_lists.campaign_id = $1;
+
 -- name: next-campaign-subscribers
 -- Returns a batch of subscribers in a given campaign starting from the last checkpoint
 -- (last_subscriber_id). Every fetch updates the checkpoint and the sent count, which means
@@ -333,9 +883,10 @@ WITH campLists AS (
     WHERE campaign_lists.campaign_id = $1
 ),
 subs AS (
-    SELECT s.*
+    -- campaign_list_id is the (lowest) campaign list through which the subscriber is being messaged.
+    SELECT s.*, subIDs.campaign_list_id
     FROM (
-        SELECT DISTINCT s.id
+        SELECT s.id, MIN(sl.list_id) AS campaign_list_id
         FROM subscriber_lists sl
         JOIN campLists ON sl.list_id = campLists.list_id
         JOIN subscribers s ON s.id = sl.subscriber_id
@@ -347,6 +898,22 @@ subs AS (
             AND s.id <= $4
              -- Subscriber should not be blacklisted.
             AND s.status != 'blocklisted'
+            -- Subscriber should not be on any of the campaign's excluded lists ($7).
+            AND s.id NOT IN (SELECT subscriber_id FROM subscriber_lists WHERE list_id = ANY($7::INT[]))
+            -- Subscriber should not have opted out of any of the campaign's topics ($8).
+            AND NOT EXISTS (SELECT 1 FROM subscriber_topics st WHERE st.subscriber_id = s.id
+                AND st.topic_id = ANY($8::INT[]) AND NOT st.subscribed)
+            -- If the campaign is being sent to a timezone wave, the subscriber should be in one of
+            -- the wave's timezones ($10) or not in any of the timezones of the other waves ($11).
+            AND ($10::TEXT[] IS NULL OR s.attribs->>'timezone' = ANY($10::TEXT[]))
+            AND ($11::TEXT[] IS NULL OR COALESCE(s.attribs->>'timezone', '') != ALL($11::TEXT[]))
+            -- Subscriber should not have been sent a test of the campaign.
+            AND NOT EXISTS (SELECT 1 FROM campaign_sends cs WHERE cs.campaign_id = $1
+                AND cs.subscriber_id = s.id AND cs.test)
+            -- If the campaign's audience is frozen ($9), the subscriber should be in the snapshot.
+            -- Those who have since unsubscribed or been blocklisted are skipped by the rest.
+            AND (NOT $9 OR EXISTS (SELECT 1 FROM campaign_audience_snapshots a
+                WHERE a.campaign_id = $1 AND a.subscriber_id = s.id))
             AND (
                 -- If it's an optin campaign and the list is double-optin, only pick unconfirmed subscribers.
                 ($2 = 'optin' AND sl.status = 'unconfirmed' AND campLists.optin = 'double')
@@ -361,6 +928,7 @@ subs AS (
                     )
                 )
             )
+        GROUP BY s.id
         ORDER BY s.id LIMIT $6
     ) subIDs JOIN subscribers s ON (s.id = subIDs.id) ORDER BY s.id
 ),
@@ -371,6 +939,71 @@ u AS (
 )
 SELECT * FROM subs;
 
+-- name: record-campaign-send-failure
+-- Records the failure of a message after $4 retries on temporary errors, which
+-- are added to the retries of the existing failure.
+INSERT INTO campaign_send_failures (campaign_id, subscriber_id, error, retry_count) VALUES($1, $2, $3, $4)
+    ON CONFLICT (campaign_id, subscriber_id) DO UPDATE SET error = $3,
+        retry_count = campaign_send_failures.retry_count + $4, last_attempt_at = NOW();
+
+-- name: record-campaign-sends
+-- Records a batch of campaign messages sent to subscribers in the send log.
+-- Subscribers that have been deleted since are skipped.
+INSERT INTO campaign_sends (campaign_id, subscriber_id, subject, sent_at, test)
+    SELECT s.campaign_id, s.subscriber_id, s.subject, s.sent_at, s.test
+    FROM UNNEST($1::INT[], $2::INT[], $3::TEXT[], $4::TIMESTAMP WITH TIME ZONE[], $5::BOOLEAN[])
+        AS s(campaign_id, subscriber_id, subject, sent_at, test)
+    WHERE EXISTS (SELECT 1 FROM subscribers WHERE id = s.subscriber_id)
+        AND EXISTS (SELECT 1 FROM campaigns WHERE id = s.campaign_id);
+
+-- name: get-messenger-daily-stats
+-- Returns the warm-up day of a messenger, counting from the first day it sent
+-- messages, and the number of messages it has sent today.
+SELECT COALESCE(CURRENT_DATE - MIN(day) + 1, 1) AS day,
+    COALESCE(SUM(sent) FILTER (WHERE day = CURRENT_DATE), 0) AS sent
+    FROM messenger_daily_stats WHERE messenger = $1;
+
+-- name: record-messenger-sends
+INSERT INTO messenger_daily_stats (messenger, day, sent) VALUES($1, CURRENT_DATE, $2)
+    ON CONFLICT (messenger, day) DO UPDATE SET sent = messenger_daily_stats.sent + $2;
+
+-- name: delete-campaign-send-failure
+-- Deletes the failure of a message that was sent on retry and counts it as sent.
+WITH d AS (
+    DELETE FROM campaign_send_failures WHERE campaign_id = $1 AND subscriber_id = $2 RETURNING campaign_id
+)
+UPDATE campaigns SET sent = sent + 1, updated_at = NOW() WHERE id = (SELECT campaign_id FROM d);
+
+-- name: retry-campaign-send-failures
+-- Marks the send failures of campaign $1 that haven't been attempted in the last $2 seconds
+-- (and may still be queued) as retried, and returns their subscribers who are still on any
+-- of the campaign's lists and aren't blocklisted, with the (lowest) campaign list through
+-- which they're messaged.
+WITH f AS (
+    UPDATE campaign_send_failures SET retry_count = retry_count + 1, last_attempt_at = NOW()
+    WHERE campaign_id = $1 AND last_attempt_at < NOW() - MAKE_INTERVAL(secs => $2::INT)
+    RETURNING subscriber_id
+)
+SELECT s.*, MIN(sl.list_id) AS campaign_list_id FROM f
+    JOIN subscribers s ON (s.id = f.subscriber_id)
+    JOIN subscriber_lists sl ON (sl.subscriber_id = s.id AND sl.status != 'unsubscribed')
+    JOIN campaign_lists cl ON (cl.list_id = sl.list_id AND cl.campaign_id = $1)
+    WHERE s.status != 'blocklisted'
+    GROUP BY s.id ORDER BY s.id;
+
+-- name: get-campaign-test-cohort
+-- Returns the first $3 subscribers (by creation) of list $2, which should be one of campaign $1's
+-- lists, who can be sent the campaign and haven't been sent a test of it yet.
+SELECT s.*, sl.list_id AS campaign_list_id FROM subscriber_lists sl
+    JOIN campaign_lists cl ON (cl.list_id = sl.list_id AND cl.campaign_id = $1)
+    JOIN lists l ON (l.id = sl.list_id)
+    JOIN subscribers s ON (s.id = sl.subscriber_id)
+    WHERE sl.list_id = $2 AND s.status != 'blocklisted'
+        AND (CASE WHEN l.optin = 'double' THEN sl.status = 'confirmed' ELSE sl.status != 'unsubscribed' END)
+        AND NOT EXISTS (SELECT 1 FROM campaign_sends cs WHERE cs.campaign_id = $1
+            AND cs.subscriber_id = s.id AND cs.test)
+    ORDER BY s.created_at, s.id LIMIT $3;
+
 -- name: delete-campaign-views
 DELETE FROM campaign_views WHERE created_at < $1;
 
@@ -412,25 +1045,51 @@ WITH camp AS (
         archive_template_id=(CASE WHEN $7::content_type = 'visual' THEN NULL ELSE $17::INT END),
         archive_meta=$18,
         body_source=$20,
+        archive_cover_media_id=$21,
+        archive_accent_color=$22,
+        archive_excerpt=$23,
+        exclude_list_ids=COALESCE($24::INT[], '{}'),
+        journal_address=$25,
+        topic_ids=COALESCE($26::INT[], '{}'),
+        -- Changing the gate or the content of the campaign invalidates a prior approval.
+        gate_status=(CASE WHEN gate_url != $27 OR subject != $3 OR body != $5 THEN '' ELSE gate_status END),
+        gate_reason=(CASE WHEN gate_url != $27 OR subject != $3 OR body != $5 THEN '' ELSE gate_reason END),
+        gate_url=$27,
+        send_spread=$28,
+        send_spread_curve=$29,
+        segment_id=$30,
+        segment_params=$31,
+        freeze_audience=$32,
+        -- Unscheduling the campaign or unfreezing its audience drops the snapshot.
+        audience_frozen_at=(CASE WHEN $32 AND NOT (status = 'scheduled' AND $8 IS NULL) THEN audience_frozen_at ELSE NULL END),
+        send_at_local_time=$33,
+        tracking_domain=$35,
+        utm_template=$36,
+        body_url=$37,
         updated_at=NOW()
-    WHERE id = $1 RETURNING id
+    -- The update is skipped (returning 0) if the campaign has been modified since the
+    -- updated_at it was read at ($34).
+    WHERE id = $1 AND updated_at = $34 RETURNING id
 ),
 clists AS (
     -- Reset list relationships
-    DELETE FROM campaign_lists WHERE campaign_id = $1 AND NOT(list_id = ANY($14))
+    DELETE FROM campaign_lists WHERE campaign_id = $1 AND NOT(list_id = ANY($14)) AND EXISTS (SELECT 1 FROM camp)
 ),
 med AS (
     DELETE FROM campaign_media WHERE campaign_id = $1
-    AND ( media_id IS NULL or NOT(media_id = ANY($19))) RETURNING media_id
+    AND ( media_id IS NULL or NOT(media_id = ANY($19))) AND EXISTS (SELECT 1 FROM camp) RETURNING media_id
 ),
 medi AS (
     INSERT INTO campaign_media (campaign_id, media_id, filename)
-        (SELECT $1 AS campaign_id, id, filename FROM media WHERE id=ANY($19::INT[]))
+        (SELECT $1 AS campaign_id, id, filename FROM media WHERE id=ANY($19::INT[]) AND EXISTS (SELECT 1 FROM camp))
         ON CONFLICT (campaign_id, media_id) DO NOTHING
+),
+cl AS (
+    INSERT INTO campaign_lists (campaign_id, list_id, list_name)
+        (SELECT $1 as campaign_id, id, name FROM lists WHERE id=ANY($14::INT[]) AND EXISTS (SELECT 1 FROM camp))
+        ON CONFLICT (campaign_id, list_id) DO UPDATE SET list_name = EXCLUDED.list_name
 )
-INSERT INTO campaign_lists (campaign_id, list_id, list_name)
-    (SELECT $1 as campaign_id, id, name FROM lists WHERE id=ANY($14::INT[]))
-    ON CONFLICT (campaign_id, list_id) DO UPDATE SET list_name = EXCLUDED.list_name;
+SELECT COUNT(*) FROM camp;
 
 -- name: update-campaign-counts
 UPDATE campaigns SET
@@ -440,7 +1099,14 @@ UPDATE campaigns SET
     updated_at=NOW()
 WHERE id=$1;
 
+-- name: update-campaign-render-stats
+UPDATE campaigns SET render_stats=$2 WHERE id=$1;
+
 -- name: update-campaign-status
+-- The frozen audience snapshot is dropped when the campaign is unscheduled or done.
+WITH snap AS (
+    DELETE FROM campaign_audience_snapshots WHERE campaign_id = $1 AND $2 IN ('draft', 'cancelled', 'finished')
+)
 UPDATE campaigns SET
     status=(
         CASE
@@ -448,18 +1114,70 @@ UPDATE campaigns SET
             ELSE $2::campaign_status
         END
     ),
+    -- A pending approval is dropped when the campaign is taken back to draft or cancelled.
+    gate_status=(CASE WHEN gate_status = 'pending' AND $2 IN ('draft', 'cancelled') THEN '' ELSE gate_status END),
+    audience_frozen_at=(CASE WHEN $2 IN ('draft', 'cancelled', 'finished') THEN NULL ELSE audience_frozen_at END),
     updated_at=NOW()
 WHERE id = $1;
 
+-- name: update-campaign-gate
+UPDATE campaigns SET gate_status=$2, gate_reason=$3, gate_checked_at=NOW() WHERE id = $1;
+
+-- name: get-pending-gate-campaigns
+-- Returns the IDs of campaigns that are awaiting approval from their gates.
+SELECT id FROM campaigns WHERE gate_status = 'pending' AND gate_url != ''
+    AND status IN ('draft', 'scheduled', 'paused') ORDER BY id;
+
+-- name: get-campaign-statuses
+SELECT id, status, send_at, freeze_audience FROM campaigns WHERE id = ANY($1::INT[]);
+
+-- name: update-campaigns-status
+-- Updates the status of multiple campaigns ($1) to $2 if they're still in one of the
+-- valid prior statuses ($3) for the transition, and returns the IDs of the updated campaigns.
+WITH u AS (
+    UPDATE campaigns SET
+        status=(
+            CASE
+                WHEN send_at IS NOT NULL AND $2 = 'running' THEN 'scheduled'
+                ELSE $2::campaign_status
+            END
+        ),
+        -- A pending approval is dropped when the campaign is taken back to draft or cancelled.
+        gate_status=(CASE WHEN gate_status = 'pending' AND $2 IN ('draft', 'cancelled') THEN '' ELSE gate_status END),
+        audience_frozen_at=(CASE WHEN $2 IN ('draft', 'cancelled', 'finished') THEN NULL ELSE audience_frozen_at END),
+        updated_at=NOW()
+    WHERE id = ANY($1::INT[]) AND status = ANY($3::campaign_status[])
+    RETURNING id
+),
+snap AS (
+    -- The frozen audience snapshot is dropped when the campaign is unscheduled or done.
+    DELETE FROM campaign_audience_snapshots WHERE $2 IN ('draft', 'cancelled', 'finished')
+        AND campaign_id IN (SELECT id FROM u)
+)
+SELECT id FROM u;
+
 -- name: update-campaign-archive
 UPDATE campaigns SET
     archive=$2,
     archive_slug=(CASE WHEN $3::TEXT = '' THEN NULL ELSE $3 END),
     archive_template_id=(CASE WHEN $4 > 0 THEN $4 ELSE archive_template_id END),
     archive_meta=(CASE WHEN $5::TEXT != '' THEN $5::JSONB ELSE archive_meta END),
+    archive_cover_media_id=$6,
+    archive_accent_color=$7,
+    archive_excerpt=$8,
+    -- NULL leaves the password as-is, an empty string removes it.
+    archive_password=(
+        CASE WHEN $9::TEXT IS NULL THEN archive_password
+            WHEN $9 = '' THEN NULL
+            ELSE CRYPT($9, GEN_SALT('bf'))
+        END
+    ),
     updated_at=NOW()
     WHERE id=$1;
 
+-- name: check-campaign-archive-password
+SELECT COALESCE(CRYPT($2, archive_password) = archive_password, FALSE) FROM campaigns WHERE id=$1;
+
 -- name: delete-campaign
 DELETE FROM campaigns WHERE id=$1;
 
@@ -479,11 +1197,157 @@ AND (
 );
 
 -- name: register-campaign-view
+-- $3 is the content revision of the message, which is capped to the campaign's current revision.
+-- $2 is the subscriber's token (or the UUID in the messages sent before the tokens were introduced,
+-- if $6 is true). $4 is the e-mail client the message was opened in and $5, the ISP whose tracking
+-- domain it was loaded from.
 WITH view AS (
-    SELECT campaigns.id as campaign_id, subscribers.id AS subscriber_id FROM campaigns
-    LEFT JOIN subscribers ON (CASE WHEN $2::TEXT != '' THEN subscribers.uuid = $2::UUID ELSE FALSE END)
+    SELECT campaigns.id as campaign_id,
+        (CASE WHEN $2::TEXT != '' THEN (SELECT id FROM subscribers
+            WHERE unsubscribe_token = $2::UUID OR ($6 AND uuid = $2::UUID) LIMIT 1) END) AS subscriber_id,
+        LEAST(GREATEST($3::INT, 0), campaigns.content_revision) AS revision FROM campaigns
     WHERE campaigns.uuid = $1
 )
-INSERT INTO campaign_views (campaign_id, subscriber_id)
-    VALUES((SELECT campaign_id FROM view), (SELECT subscriber_id FROM view));
+INSERT INTO campaign_views (campaign_id, subscriber_id, revision, client, isp)
+    VALUES((SELECT campaign_id FROM view), (SELECT subscriber_id FROM view), COALESCE((SELECT revision FROM view), 0), NULLIF($4, ''), NULLIF($5, ''));
+
+-- name: get-campaign-client-report
+-- Returns the number of views of a campaign by the e-mail client they were opened in
+-- and their share of all the views. Views recorded without a client are counted as Unknown.
+SELECT COALESCE(client, 'Unknown') AS client, COUNT(*) AS opens,
+    ROUND(COUNT(*) * 100.0 / SUM(COUNT(*)) OVER (), 1) AS pct
+    FROM campaign_views WHERE campaign_id = $1
+    GROUP BY COALESCE(client, 'Unknown')
+    ORDER BY opens DESC, client;
+
+-- name: get-campaign-isp-report
+-- Returns the number of subscribers a campaign was sent to, the ones it bounced for, and the
+-- ones who opened it (on their ISP's tracking domain) per ISP. $2 and $3 are the e-mail domains
+-- of the known ISPs and their names. Subscribers of the other domains are counted as 'other'.
+-- Views recorded without individual tracking are counted individually.
+WITH isps AS (
+    SELECT * FROM UNNEST($2::TEXT[], $3::TEXT[]) AS t(domain, isp)
+),
+sent AS (
+    SELECT COALESCE(isps.isp, 'other') AS isp, COUNT(DISTINCT cs.subscriber_id) AS sent
+    FROM campaign_sends cs
+    JOIN subscribers s ON s.id = cs.subscriber_id
+    LEFT JOIN isps ON isps.domain = LOWER(SPLIT_PART(s.email, '@', 2))
+    WHERE cs.campaign_id = $1
+    GROUP BY 1
+),
+bounced AS (
+    SELECT COALESCE(isps.isp, 'other') AS isp, COUNT(DISTINCT b.subscriber_id) AS bounced
+    FROM bounces b
+    JOIN subscribers s ON s.id = b.subscriber_id
+    LEFT JOIN isps ON isps.domain = LOWER(SPLIT_PART(s.email, '@', 2))
+    WHERE b.campaign_id = $1
+    GROUP BY 1
+),
+opens AS (
+    SELECT isp, COUNT(DISTINCT subscriber_id) + COUNT(*) FILTER (WHERE subscriber_id IS NULL) AS opens
+    FROM campaign_views WHERE campaign_id = $1 AND isp IS NOT NULL
+    GROUP BY isp
+)
+SELECT sent.isp, sent.sent, COALESCE(bounced.bounced, 0) AS bounced, COALESCE(opens.opens, 0) AS opens,
+    ROUND((sent.sent - COALESCE(bounced.bounced, 0)) * 100.0 / sent.sent, 1) AS delivery_rate,
+    ROUND(COALESCE(opens.opens, 0) * 100.0 / sent.sent, 1) AS open_rate
+    FROM sent
+    LEFT JOIN bounced ON bounced.isp = sent.isp
+    LEFT JOIN opens ON opens.isp = sent.isp
+    ORDER BY sent.sent DESC, sent.isp;
+
+-- name: swap-campaign-content
+-- Replaces the content of a running campaign with a new revision and records the change as
+-- a campaign event along with the campaign's progress at the time. If the revision the change
+-- is based on ($5) is given, the update is skipped (returning no rows) if it's no longer current.
+WITH u AS (
+    UPDATE campaigns SET body=$2, altbody=$3, body_source=$4,
+        content_revision=content_revision + 1, updated_at=NOW()
+    WHERE id = $1 AND status = 'running' AND ($5::INT IS NULL OR content_revision = $5)
+    RETURNING id, content_revision, sent, last_subscriber_id
+)
+INSERT INTO campaign_events (campaign_id, type, data, created_by)
+    SELECT id, 'content_revision', JSONB_BUILD_OBJECT('revision', content_revision, 'sent', sent,
+        'last_subscriber_id', last_subscriber_id), $6 FROM u
+    RETURNING *;
+
+-- name: insert-campaign-event
+INSERT INTO campaign_events (campaign_id, type, data, created_by) VALUES($1, $2, $3, $4);
+
+-- name: get-campaign-revision
+SELECT content_revision FROM campaigns WHERE id = $1;
+
+-- name: apply-campaign-revision
+-- Records the subscriber checkpoint at which a running campaign was switched over to the
+-- content revision $2. Subscribers after it (by ID) are sent the revision.
+UPDATE campaign_events SET data = data || JSONB_BUILD_OBJECT('after_subscriber_id', c.last_subscriber_id, 'applied_at', NOW())
+    FROM campaigns c
+    WHERE c.id = $1 AND campaign_events.campaign_id = $1 AND campaign_events.type = 'content_revision'
+    AND (campaign_events.data->>'revision')::INT = $2;
+
+-- name: get-campaign-revisions
+-- Returns the content revisions of a campaign, 0 being the original content, along with
+-- the events that created them and the views and clicks of the messages in each revision.
+WITH revs AS (
+    SELECT GENERATE_SERIES(0, content_revision) AS revision FROM campaigns WHERE id = $1
+),
+views AS (
+    SELECT revision, COUNT(*) AS num FROM campaign_views WHERE campaign_id = $1 GROUP BY revision
+),
+clicks AS (
+    SELECT revision, COUNT(*) AS num FROM link_clicks WHERE campaign_id = $1 GROUP BY revision
+)
+SELECT revs.revision, (e.data->>'after_subscriber_id')::INT AS after_subscriber_id,
+    (e.data->>'applied_at')::TIMESTAMP WITH TIME ZONE AS applied_at,
+    e.created_by, u.username AS created_by_name, e.created_at,
+    COALESCE(views.num, 0) AS views, COALESCE(clicks.num, 0) AS clicks
+    FROM revs
+    LEFT JOIN campaign_events e ON (e.campaign_id = $1 AND e.type = 'content_revision'
+        AND (e.data->>'revision')::INT = revs.revision)
+    LEFT JOIN users u ON (u.id = e.created_by)
+    LEFT JOIN views ON (views.revision = revs.revision)
+    LEFT JOIN clicks ON (clicks.revision = revs.revision)
+    ORDER BY revs.revision;
+
+
+-- name: update-campaign-survey
+UPDATE campaigns SET survey=$2 WHERE id=$1;
 
+-- name: record-survey-response
+-- $2 is the subscriber's token (or UUID, if $5 is true). Without one, the response is recorded anonymously.
+INSERT INTO survey_responses (campaign_id, subscriber_id, question_id, answer) VALUES(
+    $1,
+    (SELECT id FROM subscribers WHERE
+        (CASE WHEN $2::TEXT != '' THEN subscribers.unsubscribe_token = $2::UUID OR ($5 AND subscribers.uuid = $2::UUID) ELSE FALSE END)
+        LIMIT 1
+    ),
+    $3, $4
+)
+ON CONFLICT (campaign_id, subscriber_id, question_id) DO UPDATE SET answer=$4, updated_at=NOW();
+
+-- name: get-survey-results
+SELECT question_id, answer, COUNT(*) AS "count" FROM survey_responses
+    WHERE campaign_id = $1
+    GROUP BY question_id, answer ORDER BY question_id, "count" DESC, answer;
+
+-- name: get-campaign-calendar
+-- Returns the sends of campaigns to each of their lists between $1 and $2: the
+-- scheduled time of scheduled campaigns and the start time of the ones that were
+-- started. If $3 is false, only the campaigns on the lists $4 are returned.
+SELECT c.id AS campaign_id, c.name AS campaign_name, c.status,
+    COALESCE(cl.list_id, 0) AS list_id, cl.list_name,
+    COALESCE((SELECT SUM(s.subscriber_count) FROM mat_list_subscriber_stats s
+        WHERE s.list_id = cl.list_id AND s.status != 'unsubscribed'), 0) AS subscriber_count,
+    e.send_at
+FROM campaigns c
+CROSS JOIN LATERAL (
+    SELECT (CASE WHEN c.status = 'scheduled' THEN c.send_at ELSE COALESCE(c.started_at, c.send_at) END) AS send_at
+) e
+JOIN campaign_lists cl ON (cl.campaign_id = c.id)
+WHERE c.status IN ('scheduled', 'running', 'paused', 'finished')
+    AND e.send_at >= $1 AND e.send_at < $2
+    AND ($3 OR EXISTS (
+        SELECT 1 FROM campaign_lists WHERE campaign_id = c.id AND list_id = ANY($4::INT[])
+    ))
+ORDER BY e.send_at, c.id, cl.list_id;
@@ -6,13 +6,32 @@ INSERT INTO links (uuid, url) VALUES($1, $2) ON CONFLICT (url) DO UPDATE SET url
 SELECT url FROM links WHERE uuid = $1;
 
 -- name: register-link-click
+-- $3 is the subscriber's token (or the UUID in the messages sent before the tokens were introduced,
+-- if $6 is true).
+-- $4 is the content revision of the message, which is capped to the campaign's current revision.
+-- $5 is the UTM params added to the link's URL, if any.
 WITH link AS(
     SELECT id, url FROM links WHERE uuid = $1
 )
-INSERT INTO link_clicks (campaign_id, subscriber_id, link_id) VALUES(
+INSERT INTO link_clicks (campaign_id, subscriber_id, link_id, revision, utm) VALUES(
     (SELECT id FROM campaigns WHERE uuid = $2),
     (SELECT id FROM subscribers WHERE
-        (CASE WHEN $3::TEXT != '' THEN subscribers.uuid = $3::UUID ELSE FALSE END)
+        (CASE WHEN $3::TEXT != '' THEN subscribers.unsubscribe_token = $3::UUID OR ($6 AND subscribers.uuid = $3::UUID) ELSE FALSE END)
+        LIMIT 1
     ),
-    (SELECT id FROM link)
+    (SELECT id FROM link),
+    COALESCE((SELECT LEAST(GREATEST($4::INT, 0), content_revision) FROM campaigns WHERE uuid = $2), 0),
+    $5::JSONB
 ) RETURNING (SELECT url FROM link);
+
+-- name: register-link-conversion
+-- Marks the latest click of the link $1 in the campaign $2 by the subscriber with the token $3
+-- as converted, unless it already is, so that reloads of the destination page aren't counted.
+-- The subscriber's UUID is accepted in place of the token if $4 is true.
+UPDATE link_clicks SET converted_at = NOW() WHERE id = (
+    SELECT id FROM link_clicks
+    WHERE link_id = (SELECT id FROM links WHERE uuid = $1)
+        AND campaign_id = (SELECT id FROM campaigns WHERE uuid = $2)
+        AND subscriber_id = (SELECT id FROM subscribers WHERE unsubscribe_token = $3::UUID OR ($4 AND uuid = $3::UUID) LIMIT 1)
+    ORDER BY created_at DESC LIMIT 1
+) AND converted_at IS NULL;
@@ -33,11 +33,16 @@ statuses AS (
         COALESCE(JSONB_OBJECT_AGG(status, subscriber_count) FILTER (WHERE status IS NOT NULL), '{}') AS subscriber_statuses,
         SUM(subscriber_count) AS subscriber_count
     FROM mat_list_subscriber_stats
+    -- Optionally skip the subscriber counts.
+    WHERE $12 = TRUE
     GROUP BY list_id
 )
 SELECT ls.*, COALESCE(ss.subscriber_statuses, '{}') AS subscriber_statuses, COALESCE(ss.subscriber_count, 0) AS subscriber_count
     FROM ls LEFT JOIN statuses ss ON (ls.id = ss.list_id) ORDER BY %order%;
 
+-- name: get-list-subscriber-count
+SELECT COUNT(*) FROM subscriber_lists WHERE list_id = $1;
+
 -- name: get-lists-by-optin
 -- Can have a list of IDs or a list of UUIDs.
 SELECT * FROM lists WHERE (CASE WHEN $1 != '' THEN optin=$1::list_optin ELSE TRUE END) AND
@@ -53,7 +58,8 @@ SELECT id, uuid, type FROM lists WHERE
     END);
 
 -- name: create-list
-INSERT INTO lists (uuid, name, type, optin, status, tags, description) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id;
+INSERT INTO lists (uuid, name, type, optin, status, tags, description, sensitive, max_campaigns_per_week, max_campaigns_per_month, auto_assignment_rules)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id;
 
 -- name: update-list
 WITH l AS (
@@ -64,6 +70,10 @@ WITH l AS (
         status=(CASE WHEN $5 != '' THEN $5::list_status ELSE status END),
         tags=$6::VARCHAR(100)[],
         description=(CASE WHEN $7 != '' THEN $7 ELSE description END),
+        sensitive=$8,
+        max_campaigns_per_week=$9,
+        max_campaigns_per_month=$10,
+        auto_assignment_rules=$11,
         updated_at=NOW()
     WHERE id = $1
     RETURNING id, name
@@ -73,9 +83,135 @@ c AS (
 )
 SELECT COUNT(*) FROM l, c;
 
+-- name: apply-list-rules
+-- Evaluates the auto-assignment rules of the lists ($1, or all lists with rules if NULL)
+-- against subscribers ($2, or all subscribers if NULL). If $3 is set, only the subscribers
+-- and lists modified since then are evaluated. Matching subscribers are subscribed and the
+-- ones that were auto-assigned and no longer match are unsubscribed. Subscriptions that
+-- subscribers have unsubscribed from themselves are left alone.
+WITH ls AS (
+    SELECT id, optin, auto_assignment_rules AS rules, updated_at FROM lists
+    WHERE JSONB_ARRAY_LENGTH(auto_assignment_rules) > 0
+        AND ($1::INT[] IS NULL OR id = ANY($1::INT[]))
+),
+matches AS (
+    SELECT ls.id AS list_id, ls.optin, s.id AS subscriber_id,
+        NOT EXISTS (
+            SELECT 1 FROM JSONB_ARRAY_ELEMENTS(ls.rules) r WHERE NOT COALESCE(
+                CASE r->>'op'
+                    WHEN 'exists' THEN s.attribs ? (r->>'attrib')
+                    WHEN 'eq' THEN s.attribs->(r->>'attrib') = r->'value'
+                    WHEN 'neq' THEN s.attribs->(r->>'attrib') IS DISTINCT FROM r->'value'
+                    WHEN 'in' THEN r->'value' @> JSONB_BUILD_ARRAY(s.attribs->(r->>'attrib'))
+                    -- Comparisons are only between values of the same JSON type, eg: numbers.
+                    WHEN 'gt' THEN JSONB_TYPEOF(s.attribs->(r->>'attrib')) = JSONB_TYPEOF(r->'value') AND s.attribs->(r->>'attrib') > r->'value'
+                    WHEN 'gte' THEN JSONB_TYPEOF(s.attribs->(r->>'attrib')) = JSONB_TYPEOF(r->'value') AND s.attribs->(r->>'attrib') >= r->'value'
+                    WHEN 'lt' THEN JSONB_TYPEOF(s.attribs->(r->>'attrib')) = JSONB_TYPEOF(r->'value') AND s.attribs->(r->>'attrib') < r->'value'
+                    WHEN 'lte' THEN JSONB_TYPEOF(s.attribs->(r->>'attrib')) = JSONB_TYPEOF(r->'value') AND s.attribs->(r->>'attrib') <= r->'value'
+                END, FALSE)
+        ) AS matched
+    FROM ls CROSS JOIN subscribers s
+    WHERE s.status != 'blocklisted'
+        AND ($2::INT[] IS NULL OR s.id = ANY($2::INT[]))
+        AND ($3::TIMESTAMP WITH TIME ZONE IS NULL OR s.updated_at >= $3 OR ls.updated_at >= $3)
+),
+sub AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status, meta, subscribe_source)
+        SELECT subscriber_id, list_id,
+            (CASE WHEN optin = 'double' THEN 'unconfirmed' ELSE 'confirmed' END)::subscription_status,
+            '{"auto_assigned": true}', 'automation'
+        FROM matches WHERE matched
+    -- Re-subscribe subscribers who were unsubscribed by the rules earlier.
+    ON CONFLICT (subscriber_id, list_id) DO UPDATE SET status = EXCLUDED.status,
+        meta = subscriber_lists.meta - 'auto_unsubscribed'::TEXT, updated_at = NOW()
+        WHERE subscriber_lists.meta->>'auto_unsubscribed' = 'true'
+    RETURNING 1
+),
+unsub AS (
+    UPDATE subscriber_lists sl SET status = 'unsubscribed', unsubscribed_at = NOW(),
+        meta = sl.meta || '{"auto_unsubscribed": true}', updated_at = NOW()
+    FROM matches m
+    WHERE NOT m.matched AND sl.subscriber_id = m.subscriber_id AND sl.list_id = m.list_id
+        AND sl.meta->>'auto_assigned' = 'true' AND sl.status != 'unsubscribed'
+    RETURNING 1
+)
+SELECT (SELECT COUNT(*) FROM sub) AS subscribed, (SELECT COUNT(*) FROM unsub) AS unsubscribed;
+
+-- name: merge-lists
+-- Moves the subscriptions of list $1 to list $2, keeping the existing subscriptions to $2
+-- as they are, reassigns the campaigns of $1 to $2, and archives $1.
+WITH src AS (
+    SELECT subscriber_id, meta, status, subscribe_source, unsubscribed_at, created_at
+    FROM subscriber_lists WHERE list_id = $1
+),
+target AS (
+    SELECT id, name FROM lists WHERE id = $2
+),
+ins AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, meta, status, subscribe_source, unsubscribed_at, created_at)
+        SELECT src.subscriber_id, target.id, src.meta, src.status, src.subscribe_source, src.unsubscribed_at, src.created_at
+        FROM src, target
+    ON CONFLICT (subscriber_id, list_id) DO NOTHING
+    RETURNING 1
+),
+del AS (
+    DELETE FROM subscriber_lists WHERE list_id = $1 AND EXISTS (SELECT 1 FROM target) RETURNING 1
+),
+-- Campaigns that already target both lists only keep the target list.
+camps AS (
+    UPDATE campaign_lists cl SET list_id = target.id, list_name = target.name FROM target
+    WHERE cl.list_id = $1 AND NOT EXISTS (
+        SELECT 1 FROM campaign_lists c WHERE c.campaign_id = cl.campaign_id AND c.list_id = target.id
+    )
+    RETURNING cl.campaign_id
+),
+dupes AS (
+    DELETE FROM campaign_lists cl USING target
+    WHERE cl.list_id = $1 AND EXISTS (
+        SELECT 1 FROM campaign_lists c WHERE c.campaign_id = cl.campaign_id AND c.list_id = target.id
+    )
+    RETURNING cl.campaign_id
+),
+arch AS (
+    UPDATE lists SET status = 'archived', updated_at = NOW()
+    WHERE id = $1 AND EXISTS (SELECT 1 FROM target) RETURNING 1
+),
+touch AS (
+    UPDATE lists SET updated_at = NOW() WHERE id = $2 RETURNING 1
+)
+SELECT (SELECT COUNT(*) FROM src) AS subscribers,
+    (SELECT COUNT(*) FROM ins) AS merged,
+    (SELECT COUNT(*) FROM src) - (SELECT COUNT(*) FROM ins) AS skipped,
+    (SELECT COUNT(*) FROM camps) + (SELECT COUNT(*) FROM dupes) AS campaigns;
+
 -- name: update-lists-date
 UPDATE lists SET updated_at=NOW() WHERE id = ANY($1);
 
+-- name: get-lists-overlap
+-- Returns the number of (non-unsubscribed) subscribers common to every pair of the given lists.
+WITH subs AS (
+    SELECT list_id, subscriber_id FROM subscriber_lists
+    WHERE list_id = ANY($1::INT[]) AND status != 'unsubscribed'
+)
+SELECT a.list_id AS list_a, b.list_id AS list_b, COUNT(*) AS count
+    FROM subs a JOIN subs b ON (b.subscriber_id = a.subscriber_id AND b.list_id > a.list_id)
+    GROUP BY a.list_id, b.list_id;
+
+-- name: get-lists-unique-subscribers
+-- Returns the total number of subscribers in each of the given lists and the number of
+-- subscribers who are in none of the other given lists.
+WITH subs AS (
+    SELECT list_id, subscriber_id, COUNT(*) OVER (PARTITION BY subscriber_id) AS num FROM subscriber_lists
+    WHERE list_id = ANY($1::INT[]) AND status != 'unsubscribed'
+)
+SELECT lists.id, lists.name,
+    COUNT(subs.subscriber_id) AS subscriber_count,
+    COUNT(subs.subscriber_id) FILTER (WHERE subs.num = 1) AS unique_count
+    FROM lists LEFT JOIN subs ON (subs.list_id = lists.id)
+    WHERE lists.id = ANY($1::INT[])
+    GROUP BY lists.id, lists.name
+    ORDER BY lists.id;
+
 -- name: delete-lists
 DELETE FROM lists
 WHERE CASE
@@ -87,3 +223,41 @@ AND CASE
     WHEN $3 = TRUE THEN TRUE ELSE id = ANY($4::INT[])
 END;
 
+-- name: get-list-events
+-- Returns up to $5 subscribers joining (new subscriptions) or leaving (unsubscribed
+-- subscriptions) lists after the cursor ($1 time, $2 subscriber ID, $3 list ID, $4 event)
+-- in the order they happened.
+WITH ev AS (
+    SELECT 'list.subscriber_joined' AS event, created_at AS event_at, subscriber_id, list_id
+        FROM subscriber_lists
+        WHERE created_at >= $1 AND status != 'unsubscribed'
+    UNION ALL
+    SELECT 'list.subscriber_left', updated_at, subscriber_id, list_id
+        FROM subscriber_lists
+        WHERE updated_at >= $1 AND status = 'unsubscribed' AND updated_at > created_at
+)
+SELECT ev.event, ev.event_at AS created_at, ev.subscriber_id, ev.list_id,
+    JSON_BUILD_OBJECT('id', s.id, 'uuid', s.uuid, 'email', s.email, 'name', s.name,
+        'attribs', s.attribs, 'status', s.status, 'created_at', s.created_at) AS subscriber,
+    JSON_BUILD_OBJECT('id', l.id, 'uuid', l.uuid, 'name', l.name, 'type', l.type,
+        'optin', l.optin, 'tags', l.tags, 'description', l.description) AS list,
+    JSON_BUILD_OBJECT('status', sl.status, 'meta', sl.meta, 'created_at', sl.created_at) AS subscription
+FROM ev
+JOIN subscriber_lists sl ON (sl.subscriber_id = ev.subscriber_id AND sl.list_id = ev.list_id)
+JOIN subscribers s ON (s.id = ev.subscriber_id)
+JOIN lists l ON (l.id = ev.list_id)
+WHERE (ev.event_at, ev.subscriber_id, ev.list_id, ev.event) > ($1, $2, $3, $4)
+ORDER BY ev.event_at, ev.subscriber_id, ev.list_id, ev.event
+LIMIT $5;
+
+-- name: upsert-landing-page
+-- Creates the landing page of a list or replaces its contents, retaining its UUID (and URL).
+INSERT INTO landing_pages (uuid, list_id, custom_css, body) VALUES ($1, $2, $3, $4)
+    ON CONFLICT (list_id) DO UPDATE SET custom_css = $3, body = $4, updated_at = NOW()
+    RETURNING *;
+
+-- name: get-landing-page
+-- Landing pages are only served while their lists are public and active.
+SELECT lp.* FROM landing_pages lp
+    JOIN lists l ON (l.id = lp.list_id)
+    WHERE lp.uuid = $1 AND l.type = 'public' AND l.status = 'active';
@@ -1,6 +1,6 @@
 -- media
 -- name: insert-media
-INSERT INTO media (uuid, filename, thumb, content_type, provider, meta, created_at) VALUES($1, $2, $3, $4, $5, $6, NOW()) RETURNING id;
+INSERT INTO media (uuid, filename, thumb, content_type, provider, meta, checksum, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, NOW()) RETURNING id;
 
 -- name: query-media
 SELECT COUNT(*) OVER () AS total, * FROM media
@@ -15,6 +15,9 @@ SELECT * FROM media WHERE
         ELSE false
     END;
 
+-- name: get-media-by-checksum
+SELECT * FROM media WHERE provider=$1 AND checksum=$2 AND checksum != '' ORDER BY id LIMIT 1;
+
 -- name: delete-media
 DELETE FROM media WHERE id=$1 RETURNING filename;
 
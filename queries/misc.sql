@@ -1,8 +1,30 @@
 -- name: get-dashboard-charts
-SELECT data FROM mat_dashboard_charts;
+-- Daily campaign views and link clicks between $1 and $2.
+WITH clicks AS (
+    SELECT COUNT(*) AS count, created_at::DATE AS date FROM link_clicks
+        WHERE created_at >= $1 AND created_at < $2
+        GROUP BY date ORDER BY date
+),
+views AS (
+    SELECT COUNT(*) AS count, created_at::DATE AS date FROM campaign_views
+        WHERE created_at >= $1 AND created_at < $2
+        GROUP BY date ORDER BY date
+)
+SELECT JSON_BUILD_OBJECT(
+    'link_clicks', COALESCE((SELECT JSON_AGG(ROW_TO_JSON(clicks)) FROM clicks), '[]'),
+    'campaign_views', COALESCE((SELECT JSON_AGG(ROW_TO_JSON(views)) FROM views), '[]')
+) AS data;
 
 -- name: get-dashboard-counts
-SELECT data FROM mat_dashboard_counts;
+-- Overall counts along with the counts of new subscribers, campaigns, and
+-- messages sent (by campaigns started) between $1 and $2.
+SELECT data::JSONB || JSONB_BUILD_OBJECT('range', JSONB_BUILD_OBJECT(
+    'from', $1::TIMESTAMP WITH TIME ZONE,
+    'to', $2::TIMESTAMP WITH TIME ZONE,
+    'subscribers', (SELECT COUNT(*) FROM subscribers WHERE created_at >= $1 AND created_at < $2),
+    'campaigns', (SELECT COUNT(*) FROM campaigns WHERE created_at >= $1 AND created_at < $2),
+    'messages', (SELECT COALESCE(SUM(sent), 0) FROM campaigns WHERE started_at >= $1 AND started_at < $2)
+)) FROM mat_dashboard_counts;
 
 -- name: get-settings
 SELECT JSON_OBJECT_AGG(key, value) AS settings FROM (SELECT * FROM settings ORDER BY key) t;
@@ -18,3 +40,21 @@ UPDATE settings SET value = $2, updated_at = NOW() WHERE key = $1;
 -- name: get-db-info
 SELECT JSON_BUILD_OBJECT('version', (SELECT VERSION()),
                         'size_mb', (SELECT ROUND(pg_database_size((SELECT CURRENT_DATABASE()))/(1024^2)))) AS info;
+
+-- name: take-rate-limit-token
+-- Takes a token from the bucket $1 that holds up to $2 tokens and is refilled fully
+-- over $3 seconds. Returns whether a token was taken and the tokens left in the bucket.
+-- The SET expressions are evaluated against the row before the update.
+INSERT INTO rate_limits AS r (key, tokens, allowed, updated_at) VALUES($1, $2::DOUBLE PRECISION - 1, TRUE, NOW())
+    ON CONFLICT (key) DO UPDATE SET
+        tokens = (CASE WHEN LEAST($2, r.tokens + EXTRACT(EPOCH FROM NOW() - r.updated_at) * $2 / $3) >= 1
+            THEN LEAST($2, r.tokens + EXTRACT(EPOCH FROM NOW() - r.updated_at) * $2 / $3) - 1
+            ELSE LEAST($2, r.tokens + EXTRACT(EPOCH FROM NOW() - r.updated_at) * $2 / $3) END),
+        allowed = LEAST($2, r.tokens + EXTRACT(EPOCH FROM NOW() - r.updated_at) * $2 / $3) >= 1,
+        updated_at = NOW()
+    RETURNING allowed, tokens;
+
+-- name: delete-stale-rate-limits
+-- Buckets that haven't been touched for longer than the refill window are full,
+-- which is the same as not having them.
+DELETE FROM rate_limits WHERE updated_at < NOW() - MAKE_INTERVAL(secs => $1::INT);
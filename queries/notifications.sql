@@ -0,0 +1,7 @@
+-- name: insert-notification
+INSERT INTO notifications_log (type, subject, data, channels, error) VALUES($1, $2, $3, $4, NULLIF($5, ''));
+
+-- name: query-notifications
+SELECT COUNT(*) OVER () AS total, notifications_log.* FROM notifications_log
+WHERE ($1 = '' OR type = $1)
+ORDER BY created_at DESC OFFSET $2 LIMIT (CASE WHEN $3 < 1 THEN NULL ELSE $3 END);
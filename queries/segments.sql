@@ -0,0 +1,35 @@
+-- segments
+-- name: get-segments
+SELECT segments.*, (segments.approved_at IS NOT NULL) AS approved,
+    cu.username AS created_by_name, au.username AS approved_by_name
+    FROM segments
+    LEFT JOIN users cu ON (cu.id = segments.created_by)
+    LEFT JOIN users au ON (au.id = segments.approved_by)
+    WHERE ($1 = 0 OR segments.id = $1) ORDER BY segments.name;
+
+-- name: create-segment
+INSERT INTO segments (name, description, query, params, created_by) VALUES($1, $2, $3, $4, $5) RETURNING id;
+
+-- name: update-segment
+-- Changing the query or the params of a segment revokes its approval.
+UPDATE segments SET name=$2, description=$3, query=$4, params=$5,
+    approved_by=(CASE WHEN query != $4 OR params != $5::JSONB THEN NULL ELSE approved_by END),
+    approved_at=(CASE WHEN query != $4 OR params != $5::JSONB THEN NULL ELSE approved_at END),
+    updated_at=NOW()
+    WHERE id = $1;
+
+-- name: approve-segment
+-- Approves ($2 = user ID) or revokes the approval ($2 = NULL) of a segment.
+UPDATE segments SET approved_by=$2::INT, approved_at=(CASE WHEN $2::INT IS NULL THEN NULL ELSE NOW() END)
+    WHERE id = $1;
+
+-- name: delete-segment
+-- Segments that are referenced by campaigns can't be deleted as campaigns retain
+-- their segment for reproducibility.
+DELETE FROM segments WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM campaigns WHERE segment_id = $1);
+
+-- name: filter-segment-subscribers
+-- raw: true
+-- Unprepared statement that returns the IDs among the given subscriber IDs ($1)
+-- that match a segment's bound query. The segment's arguments start from $2.
+SELECT subscribers.id FROM subscribers WHERE subscribers.id = ANY($1::INT[]) AND %query%;
@@ -8,6 +8,13 @@ SELECT * FROM subscribers WHERE
         WHEN $3 != '' THEN email = $3
     END;
 
+-- name: get-subscriber-by-token
+-- Get a single subscriber by the token in the links in the messages sent to them. The links
+-- in the messages that were sent before the tokens were introduced have the UUID instead,
+-- which is only accepted if $2 is true (privacy.legacy_uuid_links_until).
+SELECT id FROM subscribers WHERE unsubscribe_token = $1::UUID OR ($2 AND uuid = $1::UUID)
+    ORDER BY (unsubscribe_token = $1::UUID) DESC LIMIT 1;
+
 -- name: has-subscriber-list
 -- Used for checking access permission by list.
 SELECT s.id AS subscriber_id,
@@ -22,6 +29,11 @@ FROM subscribers s WHERE s.id = ANY($1);
 -- Get subscribers by emails.
 SELECT * FROM subscribers WHERE email=ANY($1);
 
+-- name: get-subscriber-emails
+-- Get the IDs and e-mails of subscribers by IDs or UUIDs.
+SELECT id, email FROM subscribers
+    WHERE CASE WHEN ARRAY_LENGTH($1::INT[], 1) > 0 THEN id = ANY($1) ELSE uuid = ANY($2::UUID[]) END;
+
 -- name: get-subscriber-lists
 WITH sub AS (
     SELECT id FROM subscribers WHERE CASE WHEN $1 > 0 THEN id = $1 ELSE uuid = $2 END
@@ -52,6 +64,7 @@ WITH subs AS (
                     subscriber_lists.status AS subscription_status,
                     subscriber_lists.created_at AS subscription_created_at,
                     subscriber_lists.updated_at AS subscription_updated_at,
+                    subscriber_lists.unsubscribed_at AS subscription_unsubscribed_at,
                     subscriber_lists.meta AS subscription_meta,
                     lists.*
             ) l)
@@ -85,8 +98,8 @@ SELECT lists.*,
 
 -- name: insert-subscriber
 WITH sub AS (
-    INSERT INTO subscribers (uuid, email, name, status, attribs)
-    VALUES($1, $2, $3, $4, $5)
+    INSERT INTO subscribers (uuid, email, name, status, attribs, unsubscribe_token)
+    VALUES($1, $2, $3, $4, $5, $9)
     RETURNING id, status
 ),
 listIDs AS (
@@ -95,11 +108,12 @@ listIDs AS (
               ELSE uuid=ANY($7::UUID[]) END)
 ),
 subs AS (
-    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status, subscribe_source)
     VALUES(
         (SELECT id FROM sub),
         UNNEST(ARRAY(SELECT id FROM listIDs)),
-        (CASE WHEN $4='blocklisted' THEN 'unsubscribed'::subscription_status ELSE $8::subscription_status END)
+        (CASE WHEN $4='blocklisted' THEN 'unsubscribed'::subscription_status ELSE $8::subscription_status END),
+        $10
     )
     ON CONFLICT (subscriber_id, list_id) DO UPDATE
         SET updated_at=NOW(),
@@ -114,39 +128,54 @@ SELECT id from sub;
 -- name: upsert-subscriber
 -- Upserts a subscriber where existing subscribers get their names and attributes overwritten.
 -- If $7 = true, update name/attribs. If $8 = true, update subscription status.
+-- If $9 = true, subscriptions to single opt-in lists are confirmed unless $6 is unsubscribed.
+-- The IDs of the lists on which new subscriptions were created are returned along with
+-- whether the subscriber was created.
 WITH sub AS (
-    INSERT INTO subscribers as s (uuid, email, name, attribs, status)
-    VALUES($1, $2, $3, $4, 'enabled')
+    INSERT INTO subscribers as s (uuid, email, name, attribs, status, unsubscribe_token)
+    VALUES($1, $2, $3, $4, 'enabled', $10)
     ON CONFLICT (email)
     DO UPDATE SET
         name=(CASE WHEN $7 THEN $3 ELSE s.name END),
         attribs=(CASE WHEN $7 THEN $4 ELSE s.attribs END),
         updated_at=NOW()
-    RETURNING uuid, id, status
+    -- xmax is 0 for inserted rows and non-zero for updated ones.
+    RETURNING uuid, id, status, (xmax = 0) AS created
 ),
 subs AS (
-    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
-    SELECT sub.id, listID, CASE WHEN sub.status = 'blocklisted' THEN 'unsubscribed' ELSE $6::subscription_status END
-    FROM sub, UNNEST($5::INT[]) AS listID
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status, subscribe_source)
+    SELECT sub.id, lists.id,
+        (CASE
+            WHEN sub.status = 'blocklisted' THEN 'unsubscribed'
+            WHEN $9 AND lists.optin = 'single' AND $6::subscription_status != 'unsubscribed' THEN 'confirmed'
+            ELSE $6::subscription_status
+        END),
+        'import'
+    FROM sub, lists WHERE lists.id = ANY($5::INT[])
     ON CONFLICT (subscriber_id, list_id) DO UPDATE
     SET updated_at = NOW(),
         status = CASE WHEN $8 THEN EXCLUDED.status ELSE subscriber_lists.status END
+    -- xmax is 0 for inserted rows and non-zero for updated ones.
+    RETURNING list_id, (xmax = 0) AS created
 )
-SELECT uuid, id from sub;
+SELECT uuid, id, ARRAY(SELECT list_id FROM subs WHERE created) AS created_list_ids, created FROM sub;
 
 -- name: upsert-blocklist-subscriber
 -- Upserts a subscriber where the update will only set the status to blocklisted
 -- unlike upsert-subscribers where name and attributes are updated. In addition, all
--- existing subscriptions are marked as 'unsubscribed'.
+-- existing subscriptions are marked as 'unsubscribed'. Returns the subscriber's ID.
 -- This is used in the bulk importer.
 WITH sub AS (
-    INSERT INTO subscribers (uuid, email, name, attribs, status)
-    VALUES($1, $2, $3, $4, 'blocklisted')
+    INSERT INTO subscribers (uuid, email, name, attribs, status, unsubscribe_token)
+    VALUES($1, $2, $3, $4, 'blocklisted', $5)
     ON CONFLICT (email) DO UPDATE SET status='blocklisted', updated_at=NOW()
     RETURNING id
+),
+u AS (
+    UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW(), updated_at=NOW()
+    WHERE subscriber_id = (SELECT id FROM sub)
 )
-UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
-    WHERE subscriber_id = (SELECT id FROM sub);
+SELECT id FROM sub;
 
 -- name: update-subscriber
 UPDATE subscribers SET
@@ -179,11 +208,12 @@ d AS (
         AND list_id != ALL(SELECT id FROM listIDs)
         AND (CARDINALITY($10::INT[]) = 0 OR list_id = ANY($10::INT[]))
 )
-INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+INSERT INTO subscriber_lists (subscriber_id, list_id, status, subscribe_source)
     VALUES(
         (SELECT id FROM s),
         UNNEST(ARRAY(SELECT id FROM listIDs)),
-        (CASE WHEN $4='blocklisted' THEN 'unsubscribed'::subscription_status ELSE $8::subscription_status END)
+        (CASE WHEN $4='blocklisted' THEN 'unsubscribed'::subscription_status ELSE $8::subscription_status END),
+        $12
     )
     ON CONFLICT (subscriber_id, list_id) DO UPDATE
     SET status = (
@@ -201,24 +231,161 @@ INSERT INTO subscriber_lists (subscriber_id, list_id, status)
     );
 
 -- name: delete-subscribers
--- Delete one or more subscribers by ID or UUID.
-DELETE FROM subscribers WHERE CASE WHEN ARRAY_LENGTH($1::INT[], 1) > 0 THEN id = ANY($1) ELSE uuid = ANY($2::UUID[]) END;
+-- Delete one or more subscribers by ID or UUID. The anonymized placeholder subscriber ($3)
+-- is never deleted as that would delete the analytics records of anonymized subscribers.
+DELETE FROM subscribers WHERE CASE WHEN ARRAY_LENGTH($1::INT[], 1) > 0 THEN id = ANY($1) ELSE uuid = ANY($2::UUID[]) END
+    AND email != $3;
+
+-- name: anonymize-subscribers
+-- Re-points the analytics records (views, clicks, sends, bounces) of one or more subscribers
+-- (by ID or UUID) to the anonymized placeholder subscriber ($3), which is created if it
+-- doesn't exist, and returns the IDs of the subscribers, which are to be deleted. Survey
+-- answers are unique per subscriber and are left unattributed instead.
+WITH anon AS (
+    INSERT INTO subscribers (uuid, unsubscribe_token, email, name, status)
+        VALUES ($4, $5, $3, $3, 'blocklisted')
+        ON CONFLICT (email) DO UPDATE SET updated_at=NOW()
+        RETURNING id
+),
+subs AS (
+    SELECT id FROM subscribers
+    WHERE (CASE WHEN ARRAY_LENGTH($1::INT[], 1) > 0 THEN id = ANY($1) ELSE uuid = ANY($2::UUID[]) END)
+    AND email != $3
+),
+views AS (
+    UPDATE campaign_views SET subscriber_id = (SELECT id FROM anon) WHERE subscriber_id = ANY(SELECT id FROM subs)
+),
+clicks AS (
+    UPDATE link_clicks SET subscriber_id = (SELECT id FROM anon) WHERE subscriber_id = ANY(SELECT id FROM subs)
+),
+sends AS (
+    UPDATE campaign_sends SET subscriber_id = (SELECT id FROM anon) WHERE subscriber_id = ANY(SELECT id FROM subs)
+),
+bnc AS (
+    UPDATE bounces SET subscriber_id = (SELECT id FROM anon) WHERE subscriber_id = ANY(SELECT id FROM subs)
+),
+answers AS (
+    UPDATE survey_responses SET subscriber_id = NULL WHERE subscriber_id = ANY(SELECT id FROM subs)
+)
+SELECT id FROM subs;
 
 -- name: delete-blocklisted-subscribers
-DELETE FROM subscribers WHERE status = 'blocklisted';
+-- The anonymized placeholder subscriber ($1) is never deleted as that would delete the
+-- analytics records of anonymized subscribers.
+DELETE FROM subscribers WHERE status = 'blocklisted' AND email != $1;
 
 -- name: delete-orphan-subscribers
 DELETE FROM subscribers a WHERE NOT EXISTS
-    (SELECT 1 FROM subscriber_lists b WHERE b.subscriber_id = a.id);
+    (SELECT 1 FROM subscriber_lists b WHERE b.subscriber_id = a.id)
+    AND a.email != $1;
 
 -- name: blocklist-subscribers
 WITH b AS (
     UPDATE subscribers SET status='blocklisted', updated_at=NOW()
     WHERE id = ANY($1::INT[])
 )
-UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
+UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW(), updated_at=NOW()
     WHERE subscriber_id = ANY($1::INT[]);
 
+-- name: blocklist-subscribers-by-emails
+-- Blocklists the existing subscribers with the given (lowercase) e-mails,
+-- unsubscribes them from all their lists, and returns their IDs.
+WITH b AS (
+    UPDATE subscribers SET status='blocklisted', updated_at=NOW()
+    WHERE LOWER(email) = ANY($1::TEXT[]) RETURNING id
+),
+u AS (
+    UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW(), updated_at=NOW()
+    WHERE subscriber_id = ANY(SELECT id FROM b)
+)
+SELECT id FROM b;
+
+-- name: suppress-subscribers
+-- Blocklists the existing subscribers with the given e-mails ($1) and unsubscribes them from
+-- all their lists. The suppression is recorded on the subscription to the given list ($2).
+-- Returns the number of subscribers that were blocklisted and that were already blocklisted.
+WITH subs AS (
+    SELECT id, status FROM subscribers WHERE LOWER(email) = ANY($1::TEXT[])
+),
+b AS (
+    UPDATE subscribers SET status='blocklisted', updated_at=NOW()
+    WHERE id = ANY(SELECT id FROM subs) AND status != 'blocklisted'
+),
+u AS (
+    UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW(), updated_at=NOW()
+    WHERE subscriber_id = ANY(SELECT id FROM subs) AND list_id != $2
+),
+l AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status, unsubscribed_at, meta, subscribe_source)
+        SELECT id, $2, 'unsubscribed', NOW(), '{"source": "suppression"}', 'suppression' FROM subs
+    ON CONFLICT (subscriber_id, list_id) DO UPDATE
+        SET status='unsubscribed', unsubscribed_at=NOW(), meta=subscriber_lists.meta || EXCLUDED.meta, updated_at=NOW()
+)
+SELECT COUNT(*) FILTER (WHERE status != 'blocklisted') AS suppressed,
+    COUNT(*) FILTER (WHERE status = 'blocklisted') AS existing
+FROM subs;
+
+-- name: has-sensitive-lists
+-- Checks whether any of the given lists ($3 IDs, $4 UUIDs) or the existing lists
+-- of the given subscriber ($1 ID or $2 e-mail) is flagged as sensitive.
+SELECT EXISTS (
+    SELECT 1 FROM lists WHERE sensitive AND (
+        id = ANY($3::INT[]) OR uuid = ANY($4::UUID[]) OR
+        id IN (SELECT list_id FROM subscriber_lists WHERE subscriber_id = (
+            SELECT id FROM subscribers WHERE CASE WHEN $1 > 0 THEN id = $1 ELSE LOWER(email) = LOWER($2) END
+        ))
+    )
+);
+
+-- name: has-any-sensitive-list
+SELECT EXISTS (SELECT 1 FROM lists WHERE sensitive);
+
+-- name: get-sensitive-plain-attribs
+-- Returns a batch of unencrypted attributes of subscribers in sensitive lists after the given ID.
+SELECT id, attribs FROM subscribers
+    WHERE id > $1 AND attribs->'_encrypted' IS NULL AND id IN (
+        SELECT subscriber_id FROM subscriber_lists
+        JOIN lists ON (lists.id = subscriber_lists.list_id)
+        WHERE lists.sensitive
+    )
+    ORDER BY id LIMIT $2;
+
+-- name: get-plain-attribs
+-- Returns the unencrypted attributes of the given subscribers, locking them for encryption.
+SELECT id, attribs FROM subscribers
+    WHERE id = ANY($1::INT[]) AND attribs->'_encrypted' IS NULL
+    ORDER BY id FOR UPDATE;
+
+-- name: update-subscriber-attribs
+UPDATE subscribers SET attribs=$2 WHERE id = $1;
+
+-- name: update-subscribers-status
+-- Updates the status of subscribers by IDs ($1) or, if there are no IDs, by membership
+-- in any of the lists ($2, empty for all) and attribute containment ($3).
+-- Blocklisting unsubscribes all subscriptions. If $5 is false, the subscribers
+-- are only counted and not updated. Returns the number of affected subscribers.
+WITH subs AS (
+    SELECT id FROM subscribers
+    WHERE status != $4::subscriber_status AND (CASE
+        WHEN CARDINALITY($1::INT[]) > 0 THEN id = ANY($1::INT[])
+        ELSE (
+            (CARDINALITY($2::INT[]) = 0 OR EXISTS (
+                SELECT 1 FROM subscriber_lists WHERE subscriber_id = subscribers.id AND list_id = ANY($2::INT[])
+            ))
+            AND attribs @> $3
+        )
+    END)
+),
+u AS (
+    UPDATE subscribers SET status=$4::subscriber_status, updated_at=NOW()
+    WHERE $5 AND id = ANY(SELECT id FROM subs)
+),
+b AS (
+    UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW(), updated_at=NOW()
+    WHERE $5 AND $4::subscriber_status = 'blocklisted' AND subscriber_id = ANY(SELECT id FROM subs)
+)
+SELECT COUNT(*) FROM subs;
+
 -- name: add-subscribers-to-lists
 INSERT INTO subscriber_lists (subscriber_id, list_id, status)
     (SELECT a, b, (CASE WHEN $3 != '' THEN $3::subscription_status ELSE 'unconfirmed' END) FROM UNNEST($1::INT[]) a, UNNEST($2::INT[]) b)
@@ -238,6 +405,18 @@ listIDs AS (
 UPDATE subscriber_lists SET status='confirmed', meta=meta || $3, updated_at=NOW()
     WHERE subscriber_id = (SELECT id FROM subID) AND list_id = ANY(SELECT id FROM listIDs);
 
+-- name: decline-subscription-optin
+-- Unsubscribes a subscriber from unconfirmed lists that were declined on the opt-in page.
+WITH subID AS (
+    SELECT id FROM subscribers WHERE uuid = $1::UUID
+),
+listIDs AS (
+    SELECT id FROM lists WHERE uuid = ANY($2::UUID[])
+)
+UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW(), meta=meta || $3, updated_at=NOW()
+    WHERE subscriber_id = (SELECT id FROM subID) AND list_id = ANY(SELECT id FROM listIDs)
+    AND status = 'unconfirmed';
+
 -- name: unsubscribe-subscribers-from-lists
 WITH listIDs AS (
     SELECT ARRAY(
@@ -245,13 +424,14 @@ WITH listIDs AS (
         (CASE WHEN CARDINALITY($2::INT[]) > 0 THEN id=ANY($2) ELSE uuid=ANY($3::UUID[]) END)
     ) id
 )
-UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
+UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW(), updated_at=NOW()
     WHERE (subscriber_id, list_id) = ANY(SELECT a, b FROM UNNEST($1::INT[]) a, UNNEST((SELECT id FROM listIDs)) b);
 
 -- name: unsubscribe-by-campaign
 -- Unsubscribes a subscriber given a campaign UUID (from all the lists in the campaign) and the subscriber UUID.
 -- If $3 is TRUE, then all subscriptions of the subscriber is blocklisted
 -- and all existing subscriptions, irrespective of lists, unsubscribed.
+-- An 'unsubscribe' event is recorded on the campaign if any subscriptions were unsubscribed.
 WITH lists AS (
     SELECT list_id FROM campaign_lists
     LEFT JOIN campaigns ON (campaign_lists.campaign_id = campaigns.id)
@@ -260,11 +440,26 @@ WITH lists AS (
 sub AS (
     UPDATE subscribers SET status = (CASE WHEN $3 IS TRUE THEN 'blocklisted' ELSE status END)
     WHERE uuid = $2 RETURNING id
+),
+unsubs AS (
+    UPDATE subscriber_lists SET status = 'unsubscribed', unsubscribed_at=NOW(), updated_at=NOW() WHERE
+        subscriber_id = (SELECT id FROM sub) AND status != 'unsubscribed' AND
+        -- If $3 is false, unsubscribe from the campaign's lists, otherwise all lists.
+        CASE WHEN $3 IS FALSE THEN list_id = ANY(SELECT list_id FROM lists) ELSE list_id != 0 END
+    RETURNING list_id
 )
-UPDATE subscriber_lists SET status = 'unsubscribed', updated_at=NOW() WHERE
-    subscriber_id = (SELECT id FROM sub) AND status != 'unsubscribed' AND
-    -- If $3 is false, unsubscribe from the campaign's lists, otherwise all lists.
-    CASE WHEN $3 IS FALSE THEN list_id = ANY(SELECT list_id FROM lists) ELSE list_id != 0 END;
+INSERT INTO campaign_events (campaign_id, type, data)
+    SELECT campaigns.id, 'unsubscribe',
+        JSONB_BUILD_OBJECT('subscriber_id', (SELECT id FROM sub), 'blocklist', $3::BOOLEAN,
+            'lists', (SELECT JSONB_AGG(list_id) FROM unsubs))
+    FROM campaigns WHERE campaigns.uuid = $1 AND EXISTS (SELECT 1 FROM unsubs);
+
+-- name: get-resubscribe-protected
+-- Returns the IDs of the given subscribers ($1) that are unsubscribed from all their lists,
+-- the last of them within the past $2 days.
+SELECT subscriber_id FROM subscriber_lists WHERE subscriber_id = ANY($1::INT[])
+    GROUP BY subscriber_id
+    HAVING BOOL_AND(status = 'unsubscribed') AND MAX(unsubscribed_at) > NOW() - MAKE_INTERVAL(days => $2::INT);
 
 -- name: delete-unconfirmed-subscriptions
 WITH optins AS (
@@ -294,6 +489,8 @@ SELECT subscribers.* FROM subscribers
     )
     WHERE (CARDINALITY($1) = 0 OR subscriber_lists.list_id = ANY($1::INT[]))
     AND (CASE WHEN $3 != '' THEN name ~* $3 OR email ~* $3 ELSE TRUE END)
+    -- The anonymized placeholder subscriber ($6 = models.SubscriberAnonymizedEmail) is hidden.
+    AND subscribers.email != $6
     AND %query%
     ORDER BY %order% OFFSET $4 LIMIT (CASE WHEN $5 < 1 THEN NULL ELSE $5 END);
 
@@ -309,6 +506,8 @@ SELECT COUNT(*) AS total FROM subscribers
     )
     WHERE (CARDINALITY($1) = 0 OR subscriber_lists.list_id = ANY($1::INT[]))
     AND (CASE WHEN $3 != '' THEN name ~* $3 OR email ~* $3 ELSE TRUE END)
+    -- The anonymized placeholder subscriber ($4 = models.SubscriberAnonymizedEmail) is hidden.
+    AND subscribers.email != $4
     AND %query%;
 
 -- name: query-subscribers-count-all
@@ -317,31 +516,18 @@ SELECT COALESCE(SUM(subscriber_count), 0) AS total FROM mat_list_subscriber_stat
     WHERE list_id = ANY(CASE WHEN CARDINALITY($1::INT[]) > 0 THEN $1 ELSE '{0}' END)
     AND ($2 = '' OR status = $2::subscription_status);
 
--- name: query-subscribers-for-export
+-- name: copy-list-subscribers
 -- raw: true
--- Unprepared statement for issuring arbitrary WHERE conditions for
--- searching subscribers to do bulk CSV export.
-SELECT subscribers.id,
-       subscribers.uuid,
-       subscribers.email,
-       subscribers.name,
-       subscribers.status,
-       subscribers.attribs,
-       subscribers.created_at,
-       subscribers.updated_at
-       FROM subscribers
-    LEFT JOIN subscriber_lists
-    ON (
-        -- Optional list filtering.
-        (CASE WHEN CARDINALITY($1::INT[]) > 0 THEN true ELSE false END)
-        AND subscriber_lists.subscriber_id = subscribers.id
-        AND ($4 = '' OR subscriber_lists.status = $4::subscription_status)
-    )
-    WHERE subscriber_lists.list_id = ALL($1::INT[]) AND id > $2
-    AND (CASE WHEN CARDINALITY($3::INT[]) > 0 THEN id=ANY($3) ELSE true END)
-    AND (CASE WHEN $5 != '' THEN name ~* $5 OR email ~* $5 ELSE TRUE END)
-    AND %query%
-    ORDER BY subscribers.id ASC LIMIT (CASE WHEN $6 < 1 THEN NULL ELSE $6 END);
+-- Streams a CSV export of a list's subscribers with COPY TO STDOUT. COPY doesn't accept
+-- bind params, so %list_id% and %status% are replaced with escaped values.
+COPY (
+    SELECT subscribers.email, subscribers.name, subscribers.status, subscribers.attribs
+    FROM subscriber_lists
+    JOIN subscribers ON (subscribers.id = subscriber_lists.subscriber_id)
+    WHERE subscriber_lists.list_id = %list_id%
+    AND (%status% = '' OR subscriber_lists.status = %status%::subscription_status)
+    ORDER BY subscribers.id
+) TO STDOUT WITH (FORMAT CSV, HEADER);
 
 -- name: query-subscribers-template
 -- raw: true
@@ -367,18 +553,28 @@ LIMIT (CASE WHEN $1 THEN 1 END)
 
 -- name: delete-subscribers-by-query
 -- raw: true
+-- The anonymized placeholder subscriber ($5) is never deleted.
+WITH subs AS (%query%)
+DELETE FROM subscribers WHERE id=ANY(SELECT id FROM subs) AND email != $5;
+
+-- name: query-subscriber-ids-by-query
+-- raw: true
 WITH subs AS (%query%)
-DELETE FROM subscribers WHERE id=ANY(SELECT id FROM subs);
+SELECT id FROM subs;
 
 -- name: blocklist-subscribers-by-query
 -- raw: true
+-- Returns the IDs of the subscribers that were blocklisted.
 WITH subs AS (%query%),
 b AS (
     UPDATE subscribers SET status='blocklisted', updated_at=NOW()
-    WHERE id = ANY(SELECT id FROM subs)
+    WHERE id = ANY(SELECT id FROM subs) RETURNING id
+),
+u AS (
+    UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW(), updated_at=NOW()
+    WHERE subscriber_id = ANY(SELECT id FROM subs)
 )
-UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
-    WHERE subscriber_id = ANY(SELECT id FROM subs);
+SELECT id FROM b;
 
 -- name: add-subscribers-to-lists-by-query
 -- raw: true
@@ -395,9 +591,14 @@ DELETE FROM subscriber_lists
 
 -- name: unsubscribe-subscribers-from-lists-by-query
 -- raw: true
-WITH subs AS (%query%)
-UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
-    WHERE (subscriber_id, list_id) = ANY(SELECT a, b FROM UNNEST(ARRAY(SELECT id FROM subs)) a, UNNEST($5::INT[]) b);
+-- Returns the IDs of the subscribers that were unsubscribed.
+WITH subs AS (%query%),
+u AS (
+    UPDATE subscriber_lists SET status='unsubscribed', unsubscribed_at=NOW(), updated_at=NOW()
+    WHERE (subscriber_id, list_id) = ANY(SELECT a, b FROM UNNEST(ARRAY(SELECT id FROM subs)) a, UNNEST($5::INT[]) b)
+    RETURNING subscriber_id
+)
+SELECT DISTINCT subscriber_id FROM u;
 
 
 -- privacy
@@ -425,12 +626,23 @@ clicks AS (
         LEFT JOIN links ON (links.id = link_clicks.link_id)
         WHERE subscriber_id = (SELECT id FROM prof)
         GROUP BY links.id ORDER BY links.id
+),
+cons AS (
+    SELECT (CASE WHEN lists.type = 'private' THEN 'Private list' ELSE lists.name END) AS list,
+            campaigns.subject AS campaign, consents.consent_type AS type, consents.ip, consents.user_agent,
+            consents.consent_text_snapshot AS consent_text, consents.created_at
+        FROM consents
+        LEFT JOIN lists ON (lists.id = consents.list_id)
+        LEFT JOIN campaigns ON (campaigns.id = consents.campaign_id)
+        WHERE consents.subscriber_id = (SELECT id FROM prof)
+        ORDER BY consents.created_at, consents.id
 )
 SELECT (SELECT email FROM prof) as email,
         COALESCE((SELECT JSON_AGG(t) FROM prof t), '{}') AS profile,
         COALESCE((SELECT JSON_AGG(t) FROM subs t), '[]') AS subscriptions,
         COALESCE((SELECT JSON_AGG(t) FROM views t), '[]') AS campaign_views,
-        COALESCE((SELECT JSON_AGG(t) FROM clicks t), '[]') AS link_clicks;
+        COALESCE((SELECT JSON_AGG(t) FROM clicks t), '[]') AS link_clicks,
+        COALESCE((SELECT JSON_AGG(t) FROM cons t), '[]') AS consents;
 
 -- name: get-subscriber-activity
 -- Gets the subscriber's campaign views and link clicks with detailed information
@@ -469,3 +681,274 @@ clicks AS (
 SELECT
     COALESCE((SELECT JSON_AGG(v) FROM views v), '[]') as campaign_views,
     COALESCE((SELECT JSON_AGG(c) FROM clicks c), '[]') as link_clicks;
+
+-- name: get-subscriber-sends
+-- Campaign messages are not logged individually. A campaign is considered sent to a subscriber
+-- if they were subscribed to one of its lists (in a status the campaign sends to) before the
+-- campaign was last updated, and the campaign's progress has gone past the subscriber. Subscribers
+-- are processed in the order of their IDs, so last_subscriber_id is the progress marker.
+-- sent_at is the campaign's start time.
+WITH camps AS (
+    SELECT c.id, c.uuid, c.name, c.subject, c.status, c.started_at,
+        -- Subscriptions to the campaign's lists that were unsubscribed after it started.
+        BOOL_OR(sl.status = 'unsubscribed') AS unsubscribed
+    FROM campaigns c
+    JOIN campaign_lists cl ON cl.campaign_id = c.id
+    JOIN lists l ON l.id = cl.list_id
+    JOIN subscriber_lists sl ON (sl.list_id = cl.list_id AND sl.subscriber_id = $1)
+    WHERE c.started_at IS NOT NULL AND c.last_subscriber_id >= $1 AND sl.created_at <= c.updated_at
+        AND (
+            CASE
+                WHEN c.type = 'optin' THEN l.optin = 'double'
+                WHEN l.optin = 'double' THEN sl.status != 'unconfirmed'
+                ELSE TRUE
+            END
+        )
+        AND (sl.status != 'unsubscribed' OR sl.updated_at >= c.started_at)
+    GROUP BY c.id
+)
+SELECT COUNT(*) OVER () AS total, camps.id AS campaign_id, camps.uuid AS campaign_uuid,
+    camps.name, camps.subject, camps.status, camps.started_at AS sent_at,
+    (SELECT MIN(created_at) FROM campaign_views WHERE campaign_id = camps.id AND subscriber_id = $1) AS opened_at,
+    EXISTS (SELECT 1 FROM link_clicks WHERE campaign_id = camps.id AND subscriber_id = $1) AS clicked,
+    EXISTS (SELECT 1 FROM bounces WHERE campaign_id = camps.id AND subscriber_id = $1) AS bounced,
+    camps.unsubscribed
+FROM camps
+ORDER BY camps.started_at DESC, camps.id DESC OFFSET $2 LIMIT (CASE WHEN $3 < 1 THEN NULL ELSE $3 END);
+
+-- name: get-subscriber-campaign-history
+-- Returns the campaign messages sent to a subscriber from the send log that were
+-- sent before the cursor ($2, or all if NULL), newest first, with the subscriber's
+-- views and clicks of each campaign.
+SELECT s.id, s.campaign_id, c.uuid AS campaign_uuid, c.name AS campaign_name, s.subject, s.sent_at, s.test,
+    COALESCE((SELECT JSON_AGG(v.created_at ORDER BY v.created_at) FROM campaign_views v
+        WHERE v.campaign_id = s.campaign_id AND v.subscriber_id = s.subscriber_id), '[]') AS opens,
+    COALESCE((SELECT JSON_AGG(JSON_BUILD_OBJECT('url', l.url, 'created_at', lc.created_at) ORDER BY lc.created_at)
+        FROM link_clicks lc JOIN links l ON (l.id = lc.link_id)
+        WHERE lc.campaign_id = s.campaign_id AND lc.subscriber_id = s.subscriber_id), '[]') AS clicks
+    FROM campaign_sends s
+    JOIN campaigns c ON (c.id = s.campaign_id)
+    WHERE s.subscriber_id = $1 AND ($2::TIMESTAMP WITH TIME ZONE IS NULL OR s.sent_at < $2)
+    ORDER BY s.sent_at DESC LIMIT $3;
+
+-- name: insert-attrib-change
+INSERT INTO attrib_changelog (subscriber_id, changed_by, old_value, new_value) VALUES($1, $2, $3, $4);
+
+-- name: get-attrib-changelog
+SELECT COUNT(*) OVER () AS total, a.id, a.subscriber_id, a.changed_at, a.changed_by,
+    u.username AS changed_by_name, a.old_value, a.new_value
+    FROM attrib_changelog a
+    LEFT JOIN users u ON (u.id = a.changed_by)
+    WHERE a.subscriber_id = $1
+    ORDER BY a.changed_at DESC, a.id DESC OFFSET $2 LIMIT (CASE WHEN $3 < 1 THEN NULL ELSE $3 END);
+
+-- name: get-public-subscription-status
+-- Returns the status of the subscription made in the last day to the public list $1 by
+-- the subscriber whose lowercased e-mail's HMAC-SHA256 (hex) with the key $3 is $2.
+SELECT sl.status FROM subscriber_lists sl
+    JOIN lists l ON (l.id = sl.list_id)
+    JOIN subscribers s ON (s.id = sl.subscriber_id)
+    WHERE l.uuid = $1 AND l.type = 'public' AND sl.created_at >= NOW() - INTERVAL '1 day'
+        AND ENCODE(HMAC(LOWER(s.email), $3, 'sha256'), 'hex') = $2
+    LIMIT 1;
+
+-- name: get-subscriber-attrib-stats
+-- Aggregates the values of the top level attribute $1 of the subscribers on the lists $2
+-- (or all subscribers if empty): the most common JSON type of the values, the counts of the
+-- top $3 distinct scalar values, and the min, max, mean, and median of the numeric values.
+WITH subs AS (
+    SELECT attribs->($1::TEXT) AS v FROM subscribers
+    -- The encrypted attributes of subscribers in sensitive lists are skipped.
+    WHERE attribs->($1::TEXT) IS NOT NULL AND attribs->'_encrypted' IS NULL
+        AND (CARDINALITY($2::INT[]) = 0 OR id IN (
+            SELECT subscriber_id FROM subscriber_lists WHERE list_id = ANY($2::INT[])
+        ))
+),
+nums AS (
+    SELECT (v#>>'{}')::NUMERIC AS n FROM subs WHERE JSONB_TYPEOF(v) = 'number'
+)
+SELECT
+    COALESCE((SELECT JSONB_TYPEOF(v) FROM subs GROUP BY JSONB_TYPEOF(v) ORDER BY COUNT(*) DESC LIMIT 1), '') AS type,
+    (SELECT COUNT(*) FROM subs) AS total,
+    (SELECT COALESCE(JSON_AGG(t), '[]') FROM (
+        SELECT v AS value, COUNT(*) AS count FROM subs
+        WHERE JSONB_TYPEOF(v) IN ('string', 'number', 'boolean')
+        GROUP BY v ORDER BY count DESC, v LIMIT $3
+    ) t) AS values,
+    (SELECT MIN(n) FROM nums) AS min,
+    (SELECT MAX(n) FROM nums) AS max,
+    (SELECT AVG(n) FROM nums) AS mean,
+    (SELECT PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY n) FROM nums) AS median;
+
+-- name: get-disengaged-subscriber-counts
+-- Counts the subscribers who have been on a list ($2, or on listmonk if 0) for at least
+-- $1 days and have never viewed or clicked a campaign, bucketed by the number of days
+-- they've been on it by the ascending bucket boundaries in $3. The first boundary is $1.
+WITH subs AS (
+    SELECT s.id, s.uuid, s.email, s.name, s.status,
+        (CASE WHEN $2 > 0 THEN sl.created_at ELSE s.created_at END) AS subscribed_at
+    FROM subscribers s
+    LEFT JOIN subscriber_lists sl ON (sl.subscriber_id = s.id AND sl.list_id = $2)
+    WHERE s.status != 'blocklisted'
+        AND ($2 = 0 OR (sl.list_id IS NOT NULL AND sl.status != 'unsubscribed'))
+        AND (CASE WHEN $2 > 0 THEN sl.created_at ELSE s.created_at END) <= NOW() - MAKE_INTERVAL(days => $1::INT)
+        AND NOT EXISTS (SELECT 1 FROM campaign_views WHERE subscriber_id = s.id)
+        AND NOT EXISTS (SELECT 1 FROM link_clicks WHERE subscriber_id = s.id)
+)
+SELECT WIDTH_BUCKET(EXTRACT(EPOCH FROM NOW() - subscribed_at) / 86400, $3::FLOAT8[]) AS bucket, COUNT(*) AS "count"
+    FROM subs GROUP BY bucket ORDER BY bucket;
+
+-- name: get-disengaged-subscribers
+-- Returns up to $3 of the subscribers counted by get-disengaged-subscriber-counts,
+-- the ones who have been on the list the longest first.
+WITH subs AS (
+    SELECT s.id, s.uuid, s.email, s.name, s.status,
+        (CASE WHEN $2 > 0 THEN sl.created_at ELSE s.created_at END) AS subscribed_at
+    FROM subscribers s
+    LEFT JOIN subscriber_lists sl ON (sl.subscriber_id = s.id AND sl.list_id = $2)
+    WHERE s.status != 'blocklisted'
+        AND ($2 = 0 OR (sl.list_id IS NOT NULL AND sl.status != 'unsubscribed'))
+        AND (CASE WHEN $2 > 0 THEN sl.created_at ELSE s.created_at END) <= NOW() - MAKE_INTERVAL(days => $1::INT)
+        AND NOT EXISTS (SELECT 1 FROM campaign_views WHERE subscriber_id = s.id)
+        AND NOT EXISTS (SELECT 1 FROM link_clicks WHERE subscriber_id = s.id)
+)
+SELECT id, uuid, email, name, status, subscribed_at,
+    FLOOR(EXTRACT(EPOCH FROM NOW() - subscribed_at) / 86400)::INT AS days
+    FROM subs ORDER BY subscribed_at ASC, id ASC LIMIT $3;
+
+-- name: get-subscriber-growth
+-- Counts the subscribers who subscribed to lists (or list $4 if > 0) between $1 and $2
+-- (the last 30 days by default) by $3 (day, week, month) and the source of the subscriptions.
+-- Rows with a NULL source are the totals, and every period in the range has at least one row.
+-- Subscriptions from before sources were recorded are counted under 'unknown'.
+WITH r AS (
+    SELECT COALESCE(NULLIF($1, '')::TIMESTAMP WITH TIME ZONE, NOW() - INTERVAL '30 days') AS from_date,
+        COALESCE(NULLIF($2, '')::TIMESTAMP WITH TIME ZONE, NOW()) AS to_date
+),
+subs AS (
+    SELECT DATE_TRUNC($3, sl.created_at) AS date, COALESCE(sl.subscribe_source, 'unknown') AS source, sl.subscriber_id
+    FROM subscriber_lists sl, r
+    WHERE sl.created_at >= r.from_date AND sl.created_at <= r.to_date
+        AND ($4 = 0 OR sl.list_id = $4)
+        AND sl.subscribe_source IS DISTINCT FROM 'suppression'
+),
+counts AS (
+    SELECT date, (CASE WHEN GROUPING(source) = 0 THEN source END) AS source,
+        COUNT(DISTINCT subscriber_id) AS "count"
+    FROM subs GROUP BY GROUPING SETS ((date, source), (date))
+),
+dates AS (
+    SELECT GENERATE_SERIES(DATE_TRUNC($3, r.from_date), DATE_TRUNC($3, r.to_date), ('1 ' || $3)::INTERVAL) AS date FROM r
+)
+SELECT dates.date, counts.source, COALESCE(counts.count, 0) AS "count"
+    FROM dates LEFT JOIN counts ON (counts.date = dates.date)
+    ORDER BY dates.date, counts.source NULLS FIRST;
+
+-- name: get-new-subscriptions
+-- Returns the $1 latest subscriptions to lists for the new subscribers feed.
+SELECT sl.subscriber_id, s.uuid AS subscriber_uuid, s.email, sl.list_id, l.name AS list_name,
+    l.optin, sl.status, sl.created_at
+    FROM subscriber_lists sl
+    JOIN subscribers s ON s.id = sl.subscriber_id
+    JOIN lists l ON l.id = sl.list_id
+    ORDER BY sl.created_at DESC, sl.subscriber_id DESC LIMIT $1;
+
+-- name: insert-import-archive
+-- Records the archive of an import file. The file is stored at the
+-- path named after the archive's ID.
+WITH seq AS (SELECT NEXTVAL('import_archives_id_seq')::INT AS id)
+INSERT INTO import_archives (id, filename, path, size)
+    SELECT seq.id, $1, $2 || '/' || seq.id || '.csv.gz', $3 FROM seq
+    RETURNING *;
+
+-- name: get-import-archive
+SELECT * FROM import_archives WHERE id = $1;
+
+-- name: get-expired-import-archives
+SELECT * FROM import_archives WHERE created_at < NOW() - MAKE_INTERVAL(days => $1) ORDER BY id;
+
+-- name: delete-import-archive
+DELETE FROM import_archives WHERE id = $1;
+
+-- name: create-reconfirmations
+-- Creates re-confirmations that expire in $3 days for the subscribers on any of the lists in $2
+-- for at least $1 days who haven't viewed or clicked a campaign in the last $1 days,
+-- skipping the ones who already have a pending re-confirmation.
+WITH subs AS (
+    SELECT sl.subscriber_id, ARRAY_AGG(sl.list_id ORDER BY sl.list_id) AS list_ids
+    FROM subscriber_lists sl
+    JOIN subscribers s ON (s.id = sl.subscriber_id)
+    WHERE sl.list_id = ANY($2::INT[])
+        AND sl.status != 'unsubscribed'
+        AND s.status != 'blocklisted'
+        AND sl.created_at <= NOW() - MAKE_INTERVAL(days => $1::INT)
+        AND NOT EXISTS (SELECT 1 FROM campaign_views WHERE subscriber_id = s.id AND created_at > NOW() - MAKE_INTERVAL(days => $1::INT))
+        AND NOT EXISTS (SELECT 1 FROM link_clicks WHERE subscriber_id = s.id AND created_at > NOW() - MAKE_INTERVAL(days => $1::INT))
+        AND NOT EXISTS (SELECT 1 FROM reconfirmations WHERE subscriber_id = s.id AND status = 'pending')
+    GROUP BY sl.subscriber_id
+)
+INSERT INTO reconfirmations (uuid, subscriber_id, list_ids, expires_at)
+    SELECT gen_random_uuid(), subscriber_id, list_ids, NOW() + MAKE_INTERVAL(days => $3::INT) FROM subs
+    RETURNING *;
+
+-- name: get-reconfirmation
+SELECT * FROM reconfirmations WHERE uuid = $1;
+
+-- name: confirm-reconfirmation
+-- Confirms a pending re-confirmation that hasn't expired.
+UPDATE reconfirmations SET status = 'confirmed', confirmed_at = NOW()
+    WHERE uuid = $1 AND status = 'pending' AND expires_at > NOW()
+    RETURNING *;
+
+-- name: expire-reconfirmations
+-- Expires the pending re-confirmations past their expiry and unsubscribes
+-- their subscribers from the lists they were re-confirming, recording the
+-- unsubscriptions as consent events with the text in $1.
+WITH exp AS (
+    UPDATE reconfirmations SET status = 'expired'
+    WHERE status = 'pending' AND expires_at <= NOW()
+    RETURNING subscriber_id, list_ids
+),
+unsubs AS (
+    UPDATE subscriber_lists sl SET status = 'unsubscribed', unsubscribed_at = NOW(), updated_at = NOW()
+    FROM exp WHERE sl.subscriber_id = exp.subscriber_id AND sl.list_id = ANY(exp.list_ids) AND sl.status != 'unsubscribed'
+    RETURNING sl.subscriber_id, sl.list_id
+),
+cons AS (
+    INSERT INTO consents (subscriber_id, list_id, consent_type, consent_text_snapshot)
+        SELECT subscriber_id, list_id, 'unsubscribe', $1 FROM unsubs
+)
+SELECT COUNT(*) FROM exp;
+
+-- name: insert-consents
+-- Records a consent event ($4) of the subscribers in $1 on the lists by IDs ($2) or UUIDs ($3).
+-- Without lists, it's recorded on the lists of the campaign ($5, UUID) that the subscribers
+-- are on, or on all the subscribers' lists if $9 is true.
+WITH camp AS (
+    SELECT id FROM campaigns WHERE uuid = NULLIF($5, '')::UUID
+),
+l AS (
+    SELECT id FROM lists WHERE
+        (CASE WHEN CARDINALITY($2::INT[]) > 0 THEN id = ANY($2::INT[])
+              WHEN CARDINALITY($3::UUID[]) > 0 THEN uuid = ANY($3::UUID[])
+              ELSE id IN (SELECT list_id FROM campaign_lists WHERE campaign_id = (SELECT id FROM camp))
+                AND id IN (SELECT list_id FROM subscriber_lists WHERE subscriber_id = ANY($1::INT[]))
+        END)
+),
+subs AS (
+    SELECT s.id AS subscriber_id, l.id AS list_id FROM UNNEST($1::INT[]) s(id), l WHERE $9 IS NOT TRUE
+    UNION ALL
+    SELECT subscriber_id, list_id FROM subscriber_lists WHERE $9 IS TRUE AND subscriber_id = ANY($1::INT[])
+)
+INSERT INTO consents (subscriber_id, list_id, campaign_id, consent_type, ip, user_agent, consent_text_snapshot)
+    SELECT subscriber_id, list_id, (SELECT id FROM camp), $4::consent_type, NULLIF($6, ''), NULLIF($7, ''), $8 FROM subs;
+
+-- name: get-subscriber-consents
+SELECT COUNT(*) OVER () AS total, c.id, c.subscriber_id, c.list_id, l.name AS list_name,
+    c.campaign_id, cp.name AS campaign_name, c.consent_type, c.ip, c.user_agent,
+    c.consent_text_snapshot, c.created_at
+    FROM consents c
+    LEFT JOIN lists l ON (l.id = c.list_id)
+    LEFT JOIN campaigns cp ON (cp.id = c.campaign_id)
+    WHERE c.subscriber_id = $1
+    ORDER BY c.created_at DESC, c.id DESC OFFSET $2 LIMIT (CASE WHEN $3 < 1 THEN NULL ELSE $3 END);
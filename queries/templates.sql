@@ -1,28 +1,58 @@
 -- templates
 -- name: get-templates
 -- Only if the second param ($2 - noBody) is true, body and body_source is returned.
-SELECT id, name, type, subject,
+-- A template is fetched by its ID ($1) regardless of whether it's in the template library.
+-- Otherwise, either the library templates or the other templates are returned ($4).
+SELECT templates.id, templates.name, type, subject,
     (CASE WHEN $2 = false THEN body ELSE '' END) as body,
     (CASE WHEN $2 = false THEN body_source ELSE NULL END) as body_source,
-    is_default, created_at, updated_at
-    FROM templates WHERE ($1 = 0 OR id = $1) AND ($3 = '' OR type = $3::template_type)
-    ORDER BY created_at;
+    is_default, is_library, description, COALESCE(template_categories.name, '') AS category,
+    lang, parent_template_id, templates.created_at, templates.updated_at
+    FROM templates
+    LEFT JOIN template_categories ON (template_categories.id = templates.category_id)
+    WHERE (CASE WHEN $1 > 0 THEN templates.id = $1 ELSE is_library = $4 END)
+        AND ($3 = '' OR type = $3::template_type)
+    ORDER BY (CASE WHEN $4 THEN template_categories.name END), templates.created_at;
 
 -- name: create-template
-INSERT INTO templates (name, type, subject, body, body_source) VALUES($1, $2, $3, $4, $5) RETURNING id;
+INSERT INTO templates (name, type, subject, body, body_source, lang, parent_template_id)
+    VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id;
+
+-- name: create-library-template
+-- Creates a read-only campaign template in the template library, creating its category if it doesn't exist.
+WITH cat AS (
+    INSERT INTO template_categories (name) VALUES($4)
+        ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id
+)
+INSERT INTO templates (name, type, subject, body, is_library, category_id, description)
+    VALUES($1, 'campaign', '', $2, true, (SELECT id FROM cat), $3) RETURNING id;
 
 -- name: update-template
+WITH ver AS (
+    -- Save the current version of the template if its body is being changed.
+    INSERT INTO template_versions (template_id, subject, body, body_source)
+        SELECT id, subject, body, body_source FROM templates
+        WHERE id = $1 AND $4 != '' AND body != $4
+            AND updated_at = $6
+)
 UPDATE templates SET
     name=(CASE WHEN $2 != '' THEN $2 ELSE name END),
     subject=(CASE WHEN $3 != '' THEN $3 ELSE name END),
     body=(CASE WHEN $4 != '' THEN $4 ELSE body END),
     body_source=(CASE WHEN $5 != '' THEN $5 ELSE body_source END),
+    lang=$7,
+    parent_template_id=$8,
     updated_at=NOW()
-WHERE id = $1;
+-- The update is skipped if the template has been modified since the updated_at
+-- it was read at ($6).
+WHERE id = $1 AND updated_at = $6;
+
+-- name: get-latest-template-version
+SELECT * FROM template_versions WHERE template_id = $1 ORDER BY id DESC LIMIT 1;
 
 -- name: set-default-template
 WITH u AS (
-    UPDATE templates SET is_default=true WHERE id=$1 AND type='campaign' RETURNING id
+    UPDATE templates SET is_default=true WHERE id=$1 AND type='campaign' AND is_library = false RETURNING id
 )
 UPDATE templates SET is_default=false WHERE id != $1;
 
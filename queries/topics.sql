@@ -0,0 +1,31 @@
+-- topics
+-- name: get-topics
+SELECT * FROM topics WHERE ($1 = 0 OR id = $1) ORDER BY name;
+
+-- name: create-topic
+INSERT INTO topics (name, description) VALUES($1, $2) RETURNING id;
+
+-- name: update-topic
+UPDATE topics SET name=$2, description=$3, updated_at=NOW() WHERE id = $1;
+
+-- name: delete-topic
+WITH camps AS (
+    -- Remove the topic from campaigns.
+    UPDATE campaigns SET topic_ids = ARRAY_REMOVE(topic_ids, $1) WHERE $1 = ANY(topic_ids)
+)
+DELETE FROM topics WHERE id = $1;
+
+-- name: get-subscriber-topics
+-- Returns all topics along with whether the subscriber ($1) is subscribed to them.
+-- Topics that the subscriber hasn't set a preference for are subscribed by default.
+SELECT topics.*, COALESCE(st.subscribed, true) AS subscribed FROM topics
+    LEFT JOIN subscriber_topics st ON (st.topic_id = topics.id AND st.subscriber_id = $1)
+    ORDER BY topics.name;
+
+-- name: update-subscriber-topics
+-- Records the subscriber's ($1) preference for all topics. Topics in $2 are subscribed
+-- and the rest are opted out of.
+INSERT INTO subscriber_topics (subscriber_id, topic_id, subscribed)
+    SELECT $1, id, id = ANY($2::INT[]) FROM topics
+    ON CONFLICT (subscriber_id, topic_id) DO UPDATE
+        SET subscribed = EXCLUDED.subscribed, updated_at = NOW();
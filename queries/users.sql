@@ -154,6 +154,18 @@ UPDATE users SET name=$2, email=(CASE WHEN password_login THEN $3 ELSE email END
 -- name: update-user-login
 UPDATE users SET loggedin_at=NOW(), avatar=(CASE WHEN $2 != '' THEN $2 ELSE avatar END) WHERE id=$1;
 
+-- name: record-user-login-ip
+-- Records the IP a user logged in from and returns true if the user has
+-- logged in before, but never from this IP.
+WITH prev AS (
+    SELECT COUNT(*) AS num, COALESCE(BOOL_OR(ip = $2), FALSE) AS seen FROM user_login_ips WHERE user_id = $1
+),
+ins AS (
+    INSERT INTO user_login_ips (user_id, ip) VALUES($1, $2)
+    ON CONFLICT (user_id, ip) DO UPDATE SET updated_at = NOW()
+)
+SELECT num > 0 AND NOT seen FROM prev;
+
 -- name: set-user-twofa
 UPDATE users SET twofa_type=$2::twofa_type, twofa_key=$3, updated_at=NOW() WHERE id=$1;
 
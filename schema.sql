@@ -7,11 +7,14 @@ DROP TYPE IF EXISTS campaign_status CASCADE; CREATE TYPE campaign_status AS ENUM
 DROP TYPE IF EXISTS campaign_type CASCADE; CREATE TYPE campaign_type AS ENUM ('regular', 'optin');
 DROP TYPE IF EXISTS content_type CASCADE; CREATE TYPE content_type AS ENUM ('richtext', 'html', 'plain', 'markdown', 'visual');
 DROP TYPE IF EXISTS bounce_type CASCADE; CREATE TYPE bounce_type AS ENUM ('soft', 'hard', 'complaint');
+DROP TYPE IF EXISTS bounce_reason CASCADE; CREATE TYPE bounce_reason AS ENUM ('invalid_recipient', 'mailbox_full', 'content_rejected', 'reputation_block', 'dmarc_policy', 'other');
 DROP TYPE IF EXISTS template_type CASCADE; CREATE TYPE template_type AS ENUM ('campaign', 'campaign_visual', 'tx');
 DROP TYPE IF EXISTS user_type CASCADE; CREATE TYPE user_type AS ENUM ('user', 'api');
 DROP TYPE IF EXISTS user_status CASCADE; CREATE TYPE user_status AS ENUM ('enabled', 'disabled');
 DROP TYPE IF EXISTS role_type CASCADE; CREATE TYPE role_type AS ENUM ('user', 'list');
 DROP TYPE IF EXISTS twofa_type CASCADE; CREATE TYPE twofa_type AS ENUM ('none', 'totp');
+DROP TYPE IF EXISTS reconfirmation_status CASCADE; CREATE TYPE reconfirmation_status AS ENUM ('pending', 'confirmed', 'expired');
+DROP TYPE IF EXISTS consent_type CASCADE; CREATE TYPE consent_type AS ENUM ('subscribe', 'unsubscribe', 'marketing');
 
 CREATE EXTENSION IF NOT EXISTS pgcrypto;
 
@@ -20,6 +23,10 @@ DROP TABLE IF EXISTS subscribers CASCADE;
 CREATE TABLE subscribers (
     id              SERIAL PRIMARY KEY,
     uuid uuid       NOT NULL UNIQUE,
+
+    -- Random token that identifies the subscriber in the links in the messages sent
+    -- to them (unsubscribe, preferences, tracking etc.) instead of the UUID.
+    unsubscribe_token uuid NOT NULL UNIQUE,
     email           TEXT NOT NULL UNIQUE,
     name            TEXT NOT NULL,
     attribs         JSONB NOT NULL DEFAULT '{}',
@@ -46,6 +53,17 @@ CREATE TABLE lists (
     tags            VARCHAR(100)[],
     description     TEXT NOT NULL DEFAULT '',
 
+    -- Attributes of subscribers in sensitive lists are encrypted at rest.
+    sensitive       BOOLEAN NOT NULL DEFAULT false,
+
+    -- Max number of campaigns per calendar week and month. 0 = unlimited.
+    max_campaigns_per_week  INTEGER NOT NULL DEFAULT 0,
+    max_campaigns_per_month INTEGER NOT NULL DEFAULT 0,
+
+    -- Rules on subscriber attributes for automatically assigning subscribers to the list.
+    -- [{"attrib": "plan", "op": "eq", "value": "pro"}]
+    auto_assignment_rules JSONB NOT NULL DEFAULT '[]',
+
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
@@ -64,6 +82,13 @@ CREATE TABLE subscriber_lists (
     meta               JSONB NOT NULL DEFAULT '{}',
     status             subscription_status NOT NULL DEFAULT 'unconfirmed',
 
+    -- api, form, import, automation, or suppression. NULL for subscriptions
+    -- from before the sources were recorded.
+    subscribe_source   TEXT NULL DEFAULT 'api',
+
+    -- When the subscription was last unsubscribed.
+    unsubscribed_at    TIMESTAMP WITH TIME ZONE NULL,
+
     created_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at         TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 
@@ -71,7 +96,124 @@ CREATE TABLE subscriber_lists (
 );
 DROP INDEX IF EXISTS idx_sub_lists_sub_id; CREATE INDEX idx_sub_lists_sub_id ON subscriber_lists(subscriber_id);
 DROP INDEX IF EXISTS idx_sub_lists_list_id; CREATE INDEX idx_sub_lists_list_id ON subscriber_lists(list_id);
+DROP INDEX IF EXISTS idx_sub_lists_created_at; CREATE INDEX idx_sub_lists_created_at ON subscriber_lists(created_at);
 DROP INDEX IF EXISTS idx_sub_lists_status; CREATE INDEX idx_sub_lists_status ON subscriber_lists(status);
+DROP INDEX IF EXISTS idx_sub_lists_unsub_updated_at; CREATE INDEX idx_sub_lists_unsub_updated_at ON subscriber_lists(updated_at) WHERE status = 'unsubscribed';
+
+-- Standalone subscription landing pages generated for public lists.
+DROP TABLE IF EXISTS landing_pages CASCADE;
+CREATE TABLE landing_pages (
+    id               SERIAL PRIMARY KEY,
+    uuid             UUID NOT NULL UNIQUE,
+    list_id          INTEGER NOT NULL UNIQUE REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    custom_css       TEXT NOT NULL DEFAULT '',
+    body             TEXT NOT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- import archives
+DROP TABLE IF EXISTS import_archives CASCADE;
+CREATE TABLE import_archives (
+    id               SERIAL PRIMARY KEY,
+    filename         TEXT NOT NULL,
+    path             TEXT NOT NULL,
+    size             BIGINT NOT NULL DEFAULT 0,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_import_archives_created_at ON import_archives(created_at);
+
+-- re-confirmations of the subscriptions of inactive subscribers
+DROP TABLE IF EXISTS reconfirmations CASCADE;
+CREATE TABLE reconfirmations (
+    id               SERIAL PRIMARY KEY,
+    uuid             UUID NOT NULL UNIQUE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    list_ids         INTEGER[] NOT NULL,
+    status           reconfirmation_status NOT NULL DEFAULT 'pending',
+    expires_at       TIMESTAMP WITH TIME ZONE NOT NULL,
+    confirmed_at     TIMESTAMP WITH TIME ZONE NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_reconfirmations_sub_id ON reconfirmations(subscriber_id);
+CREATE INDEX idx_reconfirmations_status ON reconfirmations(status, expires_at);
+
+-- audit trail of the consents of subscribers to lists
+DROP TABLE IF EXISTS consents CASCADE;
+CREATE TABLE consents (
+    id                     BIGSERIAL PRIMARY KEY,
+    subscriber_id          INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    list_id                INTEGER NULL REFERENCES lists(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    campaign_id            INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    consent_type           consent_type NOT NULL,
+    ip                     TEXT NULL,
+    user_agent             TEXT NULL,
+    consent_text_snapshot  TEXT NOT NULL DEFAULT '',
+    created_at             TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_consents_sub_id ON consents(subscriber_id, created_at);
+
+-- topics
+DROP TABLE IF EXISTS topics CASCADE;
+CREATE TABLE topics (
+    id              SERIAL PRIMARY KEY,
+    name            TEXT NOT NULL UNIQUE,
+    description     TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Subscribers are opted into all topics by default. Only the topics that a
+-- subscriber has explicitly set a preference for are recorded here.
+DROP TABLE IF EXISTS subscriber_topics CASCADE;
+CREATE TABLE subscriber_topics (
+    subscriber_id   INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    topic_id        INTEGER NOT NULL REFERENCES topics(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscribed      BOOLEAN NOT NULL DEFAULT true,
+    updated_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY(subscriber_id, topic_id)
+);
+DROP INDEX IF EXISTS idx_sub_topics_topic_id; CREATE INDEX idx_sub_topics_topic_id ON subscriber_topics(topic_id);
+
+-- attrib_changelog
+-- Changes to subscriber attributes made via the API. old_value and new_value only
+-- have the (top level) attributes that were changed, added, or removed.
+DROP TABLE IF EXISTS attrib_changelog CASCADE;
+CREATE TABLE attrib_changelog (
+    id              BIGSERIAL PRIMARY KEY,
+    subscriber_id   INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    changed_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    changed_by      INTEGER NULL,
+    old_value       JSONB NOT NULL DEFAULT '{}',
+    new_value       JSONB NOT NULL DEFAULT '{}'
+);
+DROP INDEX IF EXISTS idx_attrib_changelog_sub; CREATE INDEX idx_attrib_changelog_sub ON attrib_changelog(subscriber_id, changed_at);
+
+-- segments
+-- Saved subscriber queries with typed :name params. Segments created by a user
+-- can only be used by others once approved (approved_at is set).
+DROP TABLE IF EXISTS segments CASCADE;
+CREATE TABLE segments (
+    id              SERIAL PRIMARY KEY,
+    name            TEXT NOT NULL UNIQUE,
+    description     TEXT NOT NULL DEFAULT '',
+    query           TEXT NOT NULL,
+    params          JSONB NOT NULL DEFAULT '[]',
+    created_by      INTEGER NULL,
+    approved_by     INTEGER NULL,
+    approved_at     TIMESTAMP WITH TIME ZONE NULL,
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Categories of the templates in the template library.
+DROP TABLE IF EXISTS template_categories CASCADE;
+CREATE TABLE template_categories (
+    id              SERIAL PRIMARY KEY,
+    name            TEXT NOT NULL UNIQUE,
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
 
 -- templates
 DROP TABLE IF EXISTS templates CASCADE;
@@ -84,10 +226,33 @@ CREATE TABLE templates (
     body_source     TEXT NULL,
     is_default      BOOLEAN NOT NULL DEFAULT false,
 
+    -- Templates in the template library are read-only, and are cloned to be used.
+    is_library      BOOLEAN NOT NULL DEFAULT false,
+    category_id     INTEGER NULL REFERENCES template_categories(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    description     TEXT NOT NULL DEFAULT '',
+
+    -- Language variants (BCP 47 lang) of a campaign template that are used for
+    -- subscribers with a matching language preference.
+    lang                TEXT NULL,
+    parent_template_id  INTEGER NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 CREATE UNIQUE INDEX ON templates (is_default) WHERE is_default = true;
+CREATE UNIQUE INDEX idx_templates_lang ON templates (parent_template_id, LOWER(lang)) WHERE parent_template_id IS NOT NULL;
+
+-- Previous versions of templates saved when their bodies are updated.
+DROP TABLE IF EXISTS template_versions CASCADE;
+CREATE TABLE template_versions (
+    id              BIGSERIAL PRIMARY KEY,
+    template_id     INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subject         TEXT NOT NULL,
+    body            TEXT NOT NULL,
+    body_source     TEXT NULL,
+    created_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_tpl_versions_tpl_id; CREATE INDEX idx_tpl_versions_tpl_id ON template_versions(template_id);
 
 
 -- campaigns
@@ -108,6 +273,70 @@ CREATE TABLE campaigns (
     status           campaign_status NOT NULL DEFAULT 'draft',
     tags             VARCHAR(100)[],
 
+    -- Subscribers on any of these lists are excluded from the campaign.
+    exclude_list_ids INTEGER[] NOT NULL DEFAULT '{}',
+
+    -- Address to which copies of the campaign's messages are journaled (archived).
+    -- Overrides the global journal address.
+    journal_address  TEXT NOT NULL DEFAULT '',
+
+    -- Topics of the campaign. Subscribers who have opted out of any of them are skipped.
+    topic_ids        INTEGER[] NOT NULL DEFAULT '{}',
+
+    -- External approval gate that has to approve the campaign before it's sent.
+    -- gate_status is one of '', 'pending', 'approved', 'overridden'.
+    gate_url         TEXT NOT NULL DEFAULT '',
+    gate_status      TEXT NOT NULL DEFAULT '',
+    gate_reason      TEXT NOT NULL DEFAULT '',
+    gate_checked_at  TIMESTAMP WITH TIME ZONE NULL,
+
+    -- Sampled render timing and message size stats of the campaign's last run.
+    render_stats     JSONB NOT NULL DEFAULT '{}',
+
+    -- Minutes over which the campaign's messages are spread out after it starts
+    -- instead of being sent as fast as possible. 0 disables it.
+    -- send_spread_curve is one of 'uniform', 'ramp_up', 'ramp_down'.
+    send_spread       INTEGER NOT NULL DEFAULT 0,
+    send_spread_curve TEXT NOT NULL DEFAULT 'uniform',
+
+    -- Optional local time (HH:MM) at which the messages are delivered to subscribers in the
+    -- timezone in their `timezone` attribute, after send_at. Subscribers without one get them
+    -- at send_at. Subscribers are sent to in waves by timezone and local_wave_at is the due
+    -- time of the current wave.
+    send_at_local_time TEXT NOT NULL DEFAULT '',
+    local_wave_at      TIMESTAMP WITH TIME ZONE NULL,
+
+    -- Domain (eg: links.brand.com) on which the campaign's click and open tracking URLs are
+    -- generated instead of the root URL's. NULL uses the root URL.
+    tracking_domain    TEXT NULL,
+
+    -- HTTPS URL from which the campaign's body is fetched when it's sent instead of
+    -- using body. The fetched body is cached for the duration of a campaign run.
+    body_url           TEXT NOT NULL DEFAULT '',
+
+    -- Survey questions answered with {{ surveyURL }} links in the campaign.
+    survey             JSONB NOT NULL DEFAULT '[]',
+
+    -- Templates of the UTM params added to the campaign's tracked links,
+    -- eg: {"source": "{{ .List.Name }}"}. NULL uses the app.utm_template setting.
+    utm_template       JSONB NULL,
+
+    -- Optional segment that further narrows down the subscribers on the campaign's
+    -- lists, along with the values its params are bound to.
+    segment_id       INTEGER NULL REFERENCES segments(id) ON UPDATE CASCADE,
+    segment_params   JSONB NOT NULL DEFAULT '{}',
+
+    -- If enabled, the audience of the campaign is snapshotted into campaign_audience_snapshots
+    -- when it's scheduled and it's only sent to the subscribers in the snapshot.
+    -- audience_frozen_at is the time of the current snapshot, if any.
+    freeze_audience    BOOLEAN NOT NULL DEFAULT false,
+    audience_frozen_at TIMESTAMP WITH TIME ZONE NULL,
+
+    -- Revision of the content that's incremented every time the body of the campaign
+    -- is changed while it's running. Messages carry it in their tracking URLs so that
+    -- views and clicks can be split by revision.
+    content_revision   INT NOT NULL DEFAULT 0,
+
     -- The subscription statuses of subscribers to which a campaign will be sent.
     -- For opt-in campaigns, this will be 'unsubscribed'.
     type campaign_type DEFAULT 'regular',
@@ -119,6 +348,8 @@ CREATE TABLE campaigns (
     -- Progress and stats.
     to_send            INT NOT NULL DEFAULT 0,
     sent               INT NOT NULL DEFAULT 0,
+    -- Messages confirmed to be delivered with delivery webhooks.
+    delivered          INT NOT NULL DEFAULT 0,
     max_subscriber_id  INT NOT NULL DEFAULT 0,
     last_subscriber_id INT NOT NULL DEFAULT 0,
 
@@ -128,11 +359,28 @@ CREATE TABLE campaigns (
     archive_template_id INTEGER REFERENCES templates(id) ON DELETE SET NULL,
     archive_meta        JSONB NOT NULL DEFAULT '{}',
 
+    -- Optional archive cover image (media ID), accent color (hex), and excerpt
+    -- shown on the public archive and in social meta tags.
+    archive_cover_media_id INTEGER NULL,
+    archive_accent_color   TEXT NULL,
+    archive_excerpt        TEXT NULL,
+
+    -- Optional bcrypt hash of the password that protects the archive page.
+    archive_password       TEXT NULL,
+
     started_at       TIMESTAMP WITH TIME ZONE,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    -- Full-text search vector of the name (A), subject (B), and body (C).
+    search_tsv TSVECTOR GENERATED ALWAYS AS (
+        SETWEIGHT(TO_TSVECTOR('simple', name), 'A') ||
+        SETWEIGHT(TO_TSVECTOR('simple', subject), 'B') ||
+        SETWEIGHT(TO_TSVECTOR('simple', body), 'C')
+    ) STORED
 );
 DROP INDEX IF EXISTS idx_camps_status; CREATE INDEX idx_camps_status ON campaigns(status);
+DROP INDEX IF EXISTS idx_camps_search; CREATE INDEX idx_camps_search ON campaigns USING GIN(search_tsv);
 DROP INDEX IF EXISTS idx_camps_name; CREATE INDEX idx_camps_name ON campaigns(name);
 DROP INDEX IF EXISTS idx_camps_created_at; CREATE INDEX idx_camps_created_at ON campaigns(created_at);
 DROP INDEX IF EXISTS idx_camps_updated_at; CREATE INDEX idx_camps_updated_at ON campaigns(updated_at);
@@ -152,6 +400,86 @@ CREATE UNIQUE INDEX ON campaign_lists (campaign_id, list_id);
 DROP INDEX IF EXISTS idx_camp_lists_camp_id; CREATE INDEX idx_camp_lists_camp_id ON campaign_lists(campaign_id);
 DROP INDEX IF EXISTS idx_camp_lists_list_id; CREATE INDEX idx_camp_lists_list_id ON campaign_lists(list_id);
 
+-- Subscribers in the frozen audience of scheduled campaigns (campaigns.freeze_audience).
+DROP TABLE IF EXISTS campaign_audience_snapshots CASCADE;
+CREATE TABLE campaign_audience_snapshots (
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    PRIMARY KEY (campaign_id, subscriber_id)
+);
+DROP INDEX IF EXISTS idx_camp_audience_sub_id; CREATE INDEX idx_camp_audience_sub_id ON campaign_audience_snapshots(subscriber_id);
+
+-- Events in the lifecycle of campaigns, eg: changes to the content of a running campaign
+-- (content_revision), previews of a campaign rendered with a subscriber's data
+-- (subscriber_preview), or unsubscriptions from a campaign (unsubscribe). data has the
+-- type specific details.
+DROP TABLE IF EXISTS campaign_events CASCADE;
+CREATE TABLE campaign_events (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    type             TEXT NOT NULL,
+    data             JSONB NOT NULL DEFAULT '{}',
+    created_by       INTEGER NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_camp_events_camp_id; CREATE INDEX idx_camp_events_camp_id ON campaign_events(campaign_id, type);
+
+-- Campaign messages that failed to be sent to subscribers (eg: temporary SMTP errors)
+-- and can be retried. Rows are deleted when the retries succeed.
+DROP TABLE IF EXISTS campaign_send_failures CASCADE;
+CREATE TABLE campaign_send_failures (
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    error            TEXT NOT NULL DEFAULT '',
+    retry_count      INTEGER NOT NULL DEFAULT 0,
+    last_attempt_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (campaign_id, subscriber_id)
+);
+DROP INDEX IF EXISTS idx_camp_send_failures_sub_id; CREATE INDEX idx_camp_send_failures_sub_id ON campaign_send_failures(subscriber_id);
+
+-- Log of the campaign messages sent to subscribers with their rendered subjects.
+DROP TABLE IF EXISTS campaign_sends CASCADE;
+CREATE TABLE campaign_sends (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subject          TEXT NOT NULL DEFAULT '',
+    sent_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    -- Whether the message was sent to a test cohort before the campaign was sent in full.
+    test             BOOLEAN NOT NULL DEFAULT false
+);
+DROP INDEX IF EXISTS idx_camp_sends_sub_id; CREATE INDEX idx_camp_sends_sub_id ON campaign_sends(subscriber_id, sent_at);
+DROP INDEX IF EXISTS idx_camp_sends_camp_id; CREATE INDEX idx_camp_sends_camp_id ON campaign_sends(campaign_id);
+DROP INDEX IF EXISTS idx_camp_sends_test; CREATE INDEX idx_camp_sends_test ON campaign_sends(campaign_id, subscriber_id) WHERE test;
+
+-- Number of messages sent by messengers per day for enforcing their warm-up schedules.
+DROP TABLE IF EXISTS messenger_daily_stats CASCADE;
+CREATE TABLE messenger_daily_stats (
+    messenger        TEXT NOT NULL,
+    day              DATE NOT NULL,
+    sent             INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (messenger, day)
+);
+
+-- Answers to campaign survey questions recorded from {{ surveyURL }} links. A subscriber's
+-- later answer to a question replaces the earlier one. subscriber_id is NULL when individual
+-- tracking is disabled.
+DROP TABLE IF EXISTS survey_responses CASCADE;
+CREATE TABLE survey_responses (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+    question_id      TEXT NOT NULL,
+    answer           TEXT NOT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_survey_responses; CREATE UNIQUE INDEX idx_survey_responses ON survey_responses(campaign_id, subscriber_id, question_id);
+
 DROP TABLE IF EXISTS campaign_views CASCADE;
 CREATE TABLE campaign_views (
     id               BIGSERIAL PRIMARY KEY,
@@ -159,11 +487,21 @@ CREATE TABLE campaign_views (
 
     -- Subscribers may be deleted, but the view counts should remain.
     subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- Content revision (campaigns.content_revision) of the viewed message.
+    revision         INTEGER NOT NULL DEFAULT 0,
+
+    -- E-mail client (eg: Gmail) the message was opened in, classified from the user agent.
+    client           TEXT NULL,
+
+    -- ISP (eg: gmail) whose tracking domain the tracking pixel was loaded from.
+    isp              TEXT NULL,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_views_camp_id; CREATE INDEX idx_views_camp_id ON campaign_views(campaign_id);
 DROP INDEX IF EXISTS idx_views_subscriber_id; CREATE INDEX idx_views_subscriber_id ON campaign_views(subscriber_id);
 DROP INDEX IF EXISTS idx_views_date; CREATE INDEX idx_views_date ON campaign_views(created_at);
+DROP INDEX IF EXISTS idx_views_camp_id_id; CREATE INDEX idx_views_camp_id_id ON campaign_views(campaign_id, id);
 
 -- media
 DROP TABLE IF EXISTS media CASCADE;
@@ -175,9 +513,15 @@ CREATE TABLE media (
     content_type     TEXT NOT NULL DEFAULT 'application/octet-stream',
     thumb            TEXT NOT NULL,
     meta             JSONB NOT NULL DEFAULT '{}',
+    checksum         TEXT NOT NULL DEFAULT '',
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_media_filename; CREATE INDEX idx_media_filename ON media(provider, filename);
+DROP INDEX IF EXISTS idx_media_checksum; CREATE INDEX idx_media_checksum ON media(provider, checksum);
+
+-- The media table is created after campaigns.
+ALTER TABLE campaigns ADD CONSTRAINT campaigns_archive_cover_media_id_fkey
+    FOREIGN KEY (archive_cover_media_id) REFERENCES media(id) ON DELETE SET NULL;
 
 -- campaign_media
 DROP TABLE IF EXISTS campaign_media CASCADE;
@@ -211,12 +555,22 @@ CREATE TABLE link_clicks (
 
     -- Subscribers may be deleted, but the link counts should remain.
     subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- Content revision (campaigns.content_revision) of the clicked message.
+    revision         INTEGER NOT NULL DEFAULT 0,
+
+    -- UTM params added to the link's URL on the click.
+    utm              JSONB NULL,
+
+    -- Time at which the destination page of the click reported a conversion, if any.
+    converted_at     TIMESTAMP WITH TIME ZONE NULL,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_clicks_camp_id; CREATE INDEX idx_clicks_camp_id ON link_clicks(campaign_id);
 DROP INDEX IF EXISTS idx_clicks_link_id; CREATE INDEX idx_clicks_link_id ON link_clicks(link_id);
 DROP INDEX IF EXISTS idx_clicks_sub_id; CREATE INDEX idx_clicks_sub_id ON link_clicks(subscriber_id);
 DROP INDEX IF EXISTS idx_clicks_date; CREATE INDEX idx_clicks_date ON link_clicks(created_at);
+DROP INDEX IF EXISTS idx_clicks_camp_id_id; CREATE INDEX idx_clicks_camp_id_id ON link_clicks(campaign_id, id);
 
 -- settings
 DROP TABLE IF EXISTS settings CASCADE;
@@ -234,8 +588,19 @@ INSERT INTO settings (key, value) VALUES
     ('app.logo_url', '""'),
     ('app.concurrency', '10'),
     ('app.message_rate', '10'),
+    ('app.max_concurrent_campaigns', '5'),
+    ('app.campaign_queue_delay', '"1m"'),
     ('app.batch_size', '1000'),
+    ('app.import_batch_size', '1000'),
+    ('app.import_error_file_size', '10'),
+    ('app.archive_imports', 'false'),
+    ('app.import_archive_retention_days', '90'),
     ('app.max_send_errors', '1000'),
+    ('app.template_max_body_bytes', '512000'),
+    ('app.campaign_minify_html', 'false'),
+    ('app.template_left_delim', '""'),
+    ('app.template_right_delim', '""'),
+    ('app.template_lint_rules', '{"div_layout": "warning", "unsupported_css": "error", "head_styles": "warning", "viewport_meta": "notice"}'),
     ('app.message_sliding_window', 'false'),
     ('app.message_sliding_window_duration', '"1h"'),
     ('app.message_sliding_window_rate', '10000'),
@@ -247,25 +612,43 @@ INSERT INTO settings (key, value) VALUES
     ('app.enable_public_archive_rss_content', 'true'),
     ('app.send_optin_confirmation', 'true'),
     ('app.check_updates', 'true'),
+    ('app.confirm_campaign_start', 'false'),
+    ('app.enforce_list_send_limits', 'false'),
     ('app.notify_emails', '[]'),
     ('app.lang', '"en"'),
+    ('app.utm_template', '{}'),
     ('privacy.individual_tracking', 'false'),
     ('privacy.disable_tracking', 'false'),
+    ('privacy.isp_tracking_domain', '""'),
     ('privacy.unsubscribe_header', 'true'),
     ('privacy.allow_blocklist', 'true'),
     ('privacy.allow_export', 'true'),
     ('privacy.allow_wipe', 'true'),
+    ('privacy.mode', '"delete"'),
+    ('privacy.legacy_uuid_links_until', '""'),
     ('privacy.allow_preferences', 'true'),
-    ('privacy.exportable', '["profile", "subscriptions", "campaign_views", "link_clicks"]'),
+    ('privacy.exportable', '["profile", "subscriptions", "campaign_views", "link_clicks", "consents"]'),
     ('privacy.domain_blocklist', '[]'),
     ('privacy.domain_allowlist', '[]'),
+    ('privacy.email_allowlist_patterns', '[]'),
+    ('privacy.email_denylist_patterns', '[]'),
     ('privacy.record_optin_ip', 'false'),
+    ('privacy.resubscribe_protection_days', '30'),
+    ('privacy.journal', '{"enabled": false, "address": "", "mode": "bcc", "tx": false}'),
+    ('privacy.strict_ascii_email', 'false'),
+    ('privacy.template_attribs', '{"mode": "all", "keys": []}'),
+    ('privacy.public_attribs', '{"mode": "allow", "keys": []}'),
+    ('security.campaign_gate', '{"enabled": false, "urls": [], "secret": "", "timeout": "10s", "retry_interval": "5m"}'),
+    ('security.subscriber_feed', '{"enabled": false, "token": ""}'),
+    ('security.google_sheets', '{"enabled": false, "credentials": ""}'),
+    ('privacy.unsubscribe_mailto', '{"enabled": false, "address": ""}'),
     ('security.captcha', '{"altcha": {"enabled": false, "complexity": 300000}, "hcaptcha": {"enabled": false, "key": "", "secret": ""}}'),
     ('security.oidc', '{"enabled": false, "provider_url": "", "provider_name": "", "client_id": "", "client_secret": "", "auto_create_users": false, "default_user_role_id": null, "default_list_role_id": null}'),
     ('security.trusted_urls', '[]'),
     ('upload.provider', '"filesystem"'),
     ('upload.max_file_size', '5000'),
     ('upload.extensions', '["jpg","jpeg","png","gif","svg","*"]'),
+    ('upload.max_archive_size_mb', '100'),
     ('upload.filesystem.upload_path', '"uploads"'),
     ('upload.filesystem.upload_uri', '"/uploads"'),
     ('upload.s3.url', '"https://ap-south-1.s3.amazonaws.com"'),
@@ -282,6 +665,7 @@ INSERT INTO settings (key, value) VALUES
         '[{"enabled":true, "host":"smtp.yoursite.com","port":25,"auth_protocol":"cram","username":"username","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"msg_retry_delay":"10ms","tls_type":"STARTTLS","tls_skip_verify":false,"email_headers":[], "from_addresses":[]},
           {"enabled":false, "host":"smtp.gmail.com","port":465,"auth_protocol":"login","username":"username@gmail.com","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"msg_retry_delay":"10ms","tls_type":"TLS","tls_skip_verify":false,"email_headers":[], "from_addresses":[]}]'),
     ('messengers', '[]'),
+    ('capture_messenger', '{"enabled": false, "size": 1000}'),
     ('bounce.enabled', 'false'),
     ('bounce.webhooks_enabled', 'false'),
     ('bounce.actions', '{"soft": {"count": 2, "action": "none"}, "hard": {"count": 1, "action": "blocklist"}, "complaint" : {"count": 1, "action": "blocklist"}}'),
@@ -292,13 +676,28 @@ INSERT INTO settings (key, value) VALUES
     ('bounce.postmark', '{"enabled": false, "username": "", "password": ""}'),
     ('bounce.forwardemail', '{"enabled": false, "key": ""}'),
     ('bounce.lettermint', '{"enabled": false, "key": ""}'),
+    ('bounce.reasons', '[]'),
+    ('bounce.classification_rules', '[]'),
     ('bounce.mailboxes',
-        '[{"enabled":false, "type": "pop", "host":"pop.yoursite.com","port":995,"auth_protocol":"userpass","username":"username","password":"password","return_path": "bounce@listmonk.yoursite.com","scan_interval":"15m","tls_enabled":true,"tls_skip_verify":false}]'),
+        '[{"enabled":false, "type": "pop", "host":"pop.yoursite.com","port":995,"auth_protocol":"userpass","username":"username","password":"password","return_path": "bounce@listmonk.yoursite.com","scan_interval":"15m","max_message_size":10240,"tls_enabled":true,"tls_skip_verify":false}]'),
+    ('spellcheck.dictionary_ids', '[]'),
     ('appearance.admin.custom_css', '""'),
     ('appearance.admin.custom_js', '""'),
     ('appearance.public.custom_css', '""'),
     ('appearance.public.custom_js', '""'),
-    ('maintenance.db', '{"vacuum": false, "vacuum_cron_interval": "0 2 * * *"}');
+    ('maintenance.db', '{"vacuum": false, "vacuum_cron_interval": "0 2 * * *"}'),
+    ('maintenance.backup', '{"enabled": false, "cron_interval": "0 3 * * *", "method": "sql", "keep": 7}'),
+    ('notifications.email', '{"enabled": false, "emails": []}'),
+    ('notifications.webhook', '{"enabled": false, "url": ""}'),
+    ('notifications.list_webhook', '{"enabled": false, "url": "", "secret": ""}'),
+    ('notifications.events', '{"campaign_failure": {"enabled": true, "threshold": 100}, "bounce_spike": {"enabled": true, "threshold": 5, "window": "1h"}, "ip_bounce_spike": {"enabled": true, "threshold": 50, "window": "1h"}, "new_login": {"enabled": true}, "db_pool": {"enabled": true}, "warmup_limit": {"enabled": true}}');
+
+-- Secret key for signing List-Unsubscribe mailto: addresses. Not exposed via the settings API.
+INSERT INTO settings (key, value) VALUES ('security.unsubscribe_mailto_key', TO_JSONB(ENCODE(GEN_RANDOM_BYTES(32), 'hex')));
+
+-- Secret key for signing the cookies that unlock password protected archive pages.
+INSERT INTO settings (key, value) VALUES ('security.archive_key', TO_JSONB(ENCODE(GEN_RANDOM_BYTES(32), 'hex')));
+INSERT INTO settings (key, value) VALUES ('security.sub_status_key', TO_JSONB(ENCODE(GEN_RANDOM_BYTES(32), 'hex')));
 
 -- bounces
 DROP TABLE IF EXISTS bounces CASCADE;
@@ -307,6 +706,7 @@ CREATE TABLE bounces (
     subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
     campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
     type             bounce_type NOT NULL DEFAULT 'hard',
+    reason           bounce_reason NOT NULL DEFAULT 'other',
     source           TEXT NOT NULL DEFAULT '',
     meta             JSONB NOT NULL DEFAULT '{}',
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
@@ -315,6 +715,20 @@ DROP INDEX IF EXISTS idx_bounces_sub_id; CREATE INDEX idx_bounces_sub_id ON boun
 DROP INDEX IF EXISTS idx_bounces_camp_id; CREATE INDEX idx_bounces_camp_id ON bounces(campaign_id);
 DROP INDEX IF EXISTS idx_bounces_source; CREATE INDEX idx_bounces_source ON bounces(source);
 DROP INDEX IF EXISTS idx_bounces_date; CREATE INDEX idx_bounces_date ON bounces(created_at);
+DROP INDEX IF EXISTS idx_bounces_reason; CREATE INDEX idx_bounces_reason ON bounces(reason);
+
+-- Periodic snapshots of the number of bounces within a window by the IP address the
+-- bounce messages originated from (parsed from their Received headers).
+DROP TABLE IF EXISTS ip_reputation_stats CASCADE;
+CREATE TABLE ip_reputation_stats (
+    id               BIGSERIAL PRIMARY KEY,
+    ip               TEXT NOT NULL,
+    bounces          INTEGER NOT NULL,
+    total_bounces    INTEGER NOT NULL,
+    window_seconds   INTEGER NOT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_ip_rep_stats_ip; CREATE INDEX idx_ip_rep_stats_ip ON ip_reputation_stats(ip, created_at);
 
 -- roles
 DROP TABLE IF EXISTS roles CASCADE;
@@ -352,6 +766,31 @@ CREATE TABLE users (
     updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- user login IPs
+DROP TABLE IF EXISTS user_login_ips CASCADE;
+CREATE TABLE user_login_ips (
+    user_id          INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    ip               TEXT NOT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+
+    PRIMARY KEY (user_id, ip)
+);
+
+-- admin notifications
+DROP TABLE IF EXISTS notifications_log CASCADE;
+CREATE TABLE notifications_log (
+    id               BIGSERIAL PRIMARY KEY,
+    type             TEXT NOT NULL,
+    subject          TEXT NOT NULL,
+    data             JSONB NOT NULL DEFAULT '{}',
+    channels         TEXT[] NOT NULL DEFAULT '{}',
+    error            TEXT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_notifications_log_type; CREATE INDEX idx_notifications_log_type ON notifications_log(type);
+DROP INDEX IF EXISTS idx_notifications_log_date; CREATE INDEX idx_notifications_log_date ON notifications_log(created_at);
+
 -- user sessions
 DROP TABLE IF EXISTS sessions CASCADE;
 CREATE TABLE sessions (
@@ -361,6 +800,18 @@ CREATE TABLE sessions (
 );
 DROP INDEX IF EXISTS idx_sessions; CREATE INDEX idx_sessions ON sessions (id, created_at);
 
+-- token buckets for rate limiting requests across instances, eg: public subscriptions.
+DROP TABLE IF EXISTS rate_limits CASCADE;
+CREATE TABLE rate_limits (
+    key              TEXT NOT NULL PRIMARY KEY,
+    tokens           DOUBLE PRECISION NOT NULL,
+
+    -- Whether the last request took a token from the bucket.
+    allowed          BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_rate_limits_date; CREATE INDEX idx_rate_limits_date ON rate_limits(updated_at);
+
 -- materialized views
 
 -- dashboard stats
@@ -399,41 +850,6 @@ CREATE MATERIALIZED VIEW mat_dashboard_counts AS
 DROP INDEX IF EXISTS mat_dashboard_stats_idx; CREATE UNIQUE INDEX mat_dashboard_stats_idx ON mat_dashboard_counts (updated_at);
 
 
-DROP MATERIALIZED VIEW IF EXISTS mat_dashboard_charts;
-CREATE MATERIALIZED VIEW mat_dashboard_charts AS
-    WITH clicks AS (
-        SELECT JSON_AGG(ROW_TO_JSON(row))
-        FROM (
-            WITH viewDates AS (
-              SELECT created_at::DATE AS to_date,
-                     created_at::DATE - INTERVAL '30 DAY' AS from_date
-                     FROM link_clicks ORDER BY id DESC LIMIT 1
-            )
-            SELECT COUNT(*) AS count, created_at::DATE as date FROM link_clicks
-              WHERE created_at >= (SELECT from_date FROM viewDates)
-                AND created_at < (SELECT to_date FROM viewDates) + INTERVAL '1 day'
-              GROUP by date ORDER BY date
-        ) row
-    ),
-    views AS (
-        SELECT JSON_AGG(ROW_TO_JSON(row))
-        FROM (
-            WITH viewDates AS (
-              SELECT created_at::DATE AS to_date,
-                     created_at::DATE - INTERVAL '30 DAY' AS from_date
-                     FROM campaign_views ORDER BY id DESC LIMIT 1
-            )
-            SELECT COUNT(*) AS count, created_at::DATE as date FROM campaign_views
-              WHERE created_at >= (SELECT from_date FROM viewDates)
-                AND created_at < (SELECT to_date FROM viewDates) + INTERVAL '1 day'
-              GROUP by date ORDER BY date
-        ) row
-    )
-    SELECT NOW() AS updated_at, JSON_BUILD_OBJECT('link_clicks', COALESCE((SELECT * FROM clicks), '[]'),
-                                  'campaign_views', COALESCE((SELECT * FROM views), '[]')
-                                ) AS data;
-DROP INDEX IF EXISTS mat_dashboard_charts_idx; CREATE UNIQUE INDEX mat_dashboard_charts_idx ON mat_dashboard_charts (updated_at);
-
 -- subscriber counts stats for lists
 DROP MATERIALIZED VIEW IF EXISTS mat_list_subscriber_stats;
 CREATE MATERIALIZED VIEW mat_list_subscriber_stats AS
@@ -0,0 +1,54 @@
+<!doctype html>
+<html>
+    <head>
+        <title>{{ .Campaign.Subject }}</title>
+        <meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+        <meta name="viewport" content="width=device-width, initial-scale=1, minimum-scale=1">
+        <base target="_blank">
+        <style>
+            img {
+                max-width: 100%;
+                height: auto;
+            }
+            a {
+                color: #6a3fc8;
+            }
+            .button {
+                background: #6a3fc8;
+                border-radius: 25px;
+                color: #fff !important;
+                text-decoration: none !important;
+                font-weight: bold;
+                padding: 12px 34px;
+                display: inline-block;
+            }
+            @media screen and (max-width: 600px) {
+                .wrap, .banner {
+                    padding: 20px !important;
+                }
+            }
+        </style>
+    </head>
+<body style="background-color: #f3f0fa;font-family: 'Helvetica Neue', 'Segoe UI', Helvetica, sans-serif;font-size: 16px;line-height: 27px;margin: 0;color: #444;">
+    <div style="padding: 30px 10px;">
+        <div style="max-width: 580px;margin: 0 auto;border-radius: 8px;overflow: hidden;">
+            <div class="banner" style="background-color: #6a3fc8;color: #fff;padding: 45px 40px;text-align: center;">
+                <div style="font-size: 28px;font-weight: bold;line-height: 36px;">
+                    {{ .Campaign.Subject }}
+                </div>
+            </div>
+
+            <div class="wrap" style="background-color: #fff;padding: 40px;">
+                {{ template "content" . }}
+            </div>
+        </div>
+
+        <p style="text-align: center;font-size: 12px;color: #888;">
+            <a href="{{ UnsubscribeURL }}" style="color: #888;">{{ L.T "email.unsub" }}</a>
+            &nbsp;&nbsp;
+            <a href="{{ MessageURL }}" style="color: #888;">{{ L.T "email.viewInBrowser" }}</a>
+        </p>
+    </div>
+    {{ TrackView }}
+</body>
+</html>
@@ -0,0 +1,55 @@
+<!doctype html>
+<html>
+    <head>
+        <title>{{ .Campaign.Subject }}</title>
+        <meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+        <meta name="viewport" content="width=device-width, initial-scale=1, minimum-scale=1">
+        <base target="_blank">
+        <style>
+            img {
+                max-width: 100%;
+                height: auto;
+            }
+            a {
+                color: #b4361d;
+            }
+            h2 {
+                font-size: 20px;
+                color: #111;
+                border-top: 1px solid #eee;
+                padding-top: 25px;
+                margin-top: 30px;
+            }
+            h3 {
+                font-size: 16px;
+                margin-bottom: 0;
+            }
+            hr {
+                border: 0;
+                border-top: 1px solid #eee;
+            }
+            @media screen and (max-width: 700px) {
+                .wrap {
+                    padding: 20px !important;
+                }
+            }
+        </style>
+    </head>
+<body style="background-color: #f6f4f0;font-family: 'Helvetica Neue', 'Segoe UI', Helvetica, sans-serif;font-size: 15px;line-height: 25px;margin: 0;color: #3b3b3b;">
+    <div style="padding: 30px 10px;">
+        <div class="wrap" style="background-color: #fff;padding: 40px 50px;max-width: 680px;margin: 0 auto;border-top: 4px solid #b4361d;">
+            <p style="font-size: 12px;text-transform: uppercase;letter-spacing: 2px;color: #999;margin: 0 0 10px 0;">
+                {{ Date "Monday, January 2, 2006" }}
+            </p>
+            {{ template "content" . }}
+        </div>
+
+        <p style="text-align: center;font-size: 12px;color: #888;">
+            <a href="{{ UnsubscribeURL }}" style="color: #888;">{{ L.T "email.unsub" }}</a>
+            &nbsp;&nbsp;
+            <a href="{{ MessageURL }}" style="color: #888;">{{ L.T "email.viewInBrowser" }}</a>
+        </p>
+    </div>
+    {{ TrackView }}
+</body>
+</html>
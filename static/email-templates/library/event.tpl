@@ -0,0 +1,57 @@
+<!doctype html>
+<html>
+    <head>
+        <title>{{ .Campaign.Subject }}</title>
+        <meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+        <meta name="viewport" content="width=device-width, initial-scale=1, minimum-scale=1">
+        <base target="_blank">
+        <style>
+            img {
+                max-width: 100%;
+                height: auto;
+            }
+            a {
+                color: #d9480f;
+            }
+            table {
+                width: 100%;
+                border-collapse: collapse;
+            }
+            table td {
+                border-bottom: 1px solid #f1e4dc;
+                padding: 8px 5px;
+            }
+            .button {
+                background: #d9480f;
+                border-radius: 3px;
+                color: #fff !important;
+                text-decoration: none !important;
+                font-weight: bold;
+                padding: 12px 32px;
+                display: inline-block;
+            }
+            @media screen and (max-width: 600px) {
+                .wrap {
+                    padding: 20px !important;
+                }
+            }
+        </style>
+    </head>
+<body style="background-color: #fbf3ee;font-family: 'Helvetica Neue', 'Segoe UI', Helvetica, sans-serif;font-size: 15px;line-height: 26px;margin: 0;color: #444;">
+    <div style="padding: 30px 10px;">
+        <div class="wrap" style="background-color: #fff;padding: 40px;max-width: 540px;margin: 0 auto;border-left: 6px solid #d9480f;border-radius: 4px;">
+            <div style="font-size: 24px;font-weight: bold;line-height: 32px;color: #111;margin-bottom: 20px;">
+                {{ .Campaign.Subject }}
+            </div>
+            {{ template "content" . }}
+        </div>
+
+        <p style="text-align: center;font-size: 12px;color: #888;">
+            <a href="{{ UnsubscribeURL }}" style="color: #888;">{{ L.T "email.unsub" }}</a>
+            &nbsp;&nbsp;
+            <a href="{{ MessageURL }}" style="color: #888;">{{ L.T "email.viewInBrowser" }}</a>
+        </p>
+    </div>
+    {{ TrackView }}
+</body>
+</html>
@@ -0,0 +1,44 @@
+<!doctype html>
+<html>
+    <head>
+        <title>{{ .Campaign.Subject }}</title>
+        <meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+        <meta name="viewport" content="width=device-width, initial-scale=1, minimum-scale=1">
+        <base target="_blank">
+        <style>
+            img {
+                max-width: 100%;
+                height: auto;
+            }
+            a {
+                color: #111;
+            }
+            .button {
+                background: #111;
+                color: #fff !important;
+                text-decoration: none !important;
+                padding: 10px 24px;
+                display: inline-block;
+            }
+            @media screen and (max-width: 600px) {
+                .wrap {
+                    padding: 20px !important;
+                }
+            }
+        </style>
+    </head>
+<body style="background-color: #fafafa;font-family: 'Helvetica Neue', 'Segoe UI', Helvetica, sans-serif;font-size: 15px;line-height: 26px;margin: 0;color: #333;">
+    <div style="padding: 30px 10px;">
+        <div class="wrap" style="background-color: #fff;border: 1px solid #e6e6e6;padding: 40px;max-width: 540px;margin: 0 auto;">
+            {{ template "content" . }}
+        </div>
+
+        <p style="text-align: center;font-size: 12px;color: #999;">
+            <a href="{{ UnsubscribeURL }}" style="color: #999;">{{ L.T "email.unsub" }}</a>
+            &nbsp;&nbsp;
+            <a href="{{ MessageURL }}" style="color: #999;">{{ L.T "email.viewInBrowser" }}</a>
+        </p>
+    </div>
+    {{ TrackView }}
+</body>
+</html>
@@ -0,0 +1,61 @@
+<!doctype html>
+<html>
+    <head>
+        <title>{{ .Campaign.Subject }}</title>
+        <meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+        <meta name="viewport" content="width=device-width, initial-scale=1, minimum-scale=1">
+        <base target="_blank">
+        <style>
+            img {
+                max-width: 100%;
+                height: auto;
+            }
+            a {
+                color: #0055d4;
+            }
+            h1, h2, h3 {
+                color: #111;
+                line-height: 1.3;
+            }
+            .button {
+                background: #0055d4;
+                border-radius: 3px;
+                color: #fff !important;
+                text-decoration: none !important;
+                font-weight: bold;
+                padding: 10px 30px;
+                display: inline-block;
+            }
+            @media screen and (max-width: 600px) {
+                .wrap, .masthead {
+                    padding: 20px !important;
+                }
+            }
+        </style>
+    </head>
+<body style="background-color: #eef1f5;font-family: 'Helvetica Neue', 'Segoe UI', Helvetica, sans-serif;font-size: 15px;line-height: 26px;margin: 0;color: #444;">
+    <div style="padding: 30px 10px;">
+        <div style="max-width: 600px;margin: 0 auto;">
+            <div class="masthead" style="background-color: #0055d4;color: #fff;padding: 30px 40px;border-radius: 5px 5px 0 0;">
+                <div style="font-size: 12px;text-transform: uppercase;letter-spacing: 2px;opacity: 0.8;">
+                    {{ Date "January 2, 2006" }}
+                </div>
+                <div style="font-size: 24px;font-weight: bold;line-height: 32px;margin-top: 5px;">
+                    {{ .Campaign.Subject }}
+                </div>
+            </div>
+
+            <div class="wrap" style="background-color: #fff;padding: 40px;border-radius: 0 0 5px 5px;">
+                {{ template "content" . }}
+            </div>
+
+            <p style="text-align: center;font-size: 12px;color: #888;">
+                <a href="{{ UnsubscribeURL }}" style="color: #888;">{{ L.T "email.unsub" }}</a>
+                &nbsp;&nbsp;
+                <a href="{{ MessageURL }}" style="color: #888;">{{ L.T "email.viewInBrowser" }}</a>
+            </p>
+        </div>
+    </div>
+    {{ TrackView }}
+</body>
+</html>
@@ -0,0 +1,27 @@
+<!doctype html>
+<html>
+    <head>
+        <title>{{ .Campaign.Subject }}</title>
+        <meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+        <meta name="viewport" content="width=device-width, initial-scale=1, minimum-scale=1">
+        <base target="_blank">
+        <style>
+            img {
+                max-width: 100%;
+                height: auto;
+            }
+        </style>
+    </head>
+<body style="background-color: #fff;font-family: Georgia, 'Times New Roman', serif;font-size: 16px;line-height: 26px;margin: 0;color: #222;">
+    <div style="max-width: 600px;padding: 20px;">
+        {{ template "content" . }}
+
+        <p style="margin-top: 40px;font-size: 13px;color: #888;">
+            <a href="{{ UnsubscribeURL }}" style="color: #888;">{{ L.T "email.unsub" }}</a>
+            &nbsp;&middot;&nbsp;
+            <a href="{{ MessageURL }}" style="color: #888;">{{ L.T "email.viewInBrowser" }}</a>
+        </p>
+    </div>
+    {{ TrackView }}
+</body>
+</html>
@@ -0,0 +1,64 @@
+<!doctype html>
+<html>
+    <head>
+        <title>{{ .Campaign.Subject }}</title>
+        <meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+        <meta name="viewport" content="width=device-width, initial-scale=1, minimum-scale=1">
+        <base target="_blank">
+        <style>
+            img {
+                max-width: 100%;
+                height: auto;
+            }
+            a {
+                color: #0a8f6a;
+            }
+            h1, h2 {
+                color: #111;
+                line-height: 1.25;
+            }
+            h1 {
+                font-size: 30px;
+            }
+            .button {
+                background: #0a8f6a;
+                border-radius: 4px;
+                color: #fff !important;
+                text-decoration: none !important;
+                font-weight: bold;
+                padding: 14px 36px;
+                display: inline-block;
+            }
+            @media screen and (max-width: 600px) {
+                .wrap, .hero {
+                    padding: 20px !important;
+                }
+            }
+        </style>
+    </head>
+<body style="background-color: #ececec;font-family: 'Helvetica Neue', 'Segoe UI', Helvetica, sans-serif;font-size: 16px;line-height: 27px;margin: 0;color: #444;">
+    <div style="padding: 30px 10px;">
+        <div style="max-width: 600px;margin: 0 auto;">
+            <div class="hero" style="background-color: #16181d;color: #fff;padding: 50px 40px;text-align: center;">
+                <div style="font-size: 12px;text-transform: uppercase;letter-spacing: 3px;color: #3fd1a6;">
+                    {{ L.T "globals.terms.new" }}
+                </div>
+                <div style="font-size: 32px;font-weight: bold;line-height: 40px;margin-top: 10px;">
+                    {{ .Campaign.Subject }}
+                </div>
+            </div>
+
+            <div class="wrap" style="background-color: #fff;padding: 40px;">
+                {{ template "content" . }}
+            </div>
+        </div>
+
+        <p style="text-align: center;font-size: 12px;color: #888;">
+            <a href="{{ UnsubscribeURL }}" style="color: #888;">{{ L.T "email.unsub" }}</a>
+            &nbsp;&nbsp;
+            <a href="{{ MessageURL }}" style="color: #888;">{{ L.T "email.viewInBrowser" }}</a>
+        </p>
+    </div>
+    {{ TrackView }}
+</body>
+</html>